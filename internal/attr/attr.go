@@ -0,0 +1,188 @@
+// Package attr looks up the attributes .gitattributes files give to
+// working-tree paths.
+package attr
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/elliota43/rev/internal/ignore"
+)
+
+// The values Get returns for an attribute set with "name" or unset with
+// "-name". An attribute that is unspecified, or reset with "!name", has
+// the value "".
+const (
+	Set   = "set"
+	Unset = "unset"
+)
+
+// macros are the attribute macros git defines itself.
+var macros = map[string][]assignment{
+	"binary": {{"diff", Unset}, {"merge", Unset}, {"text", Unset}},
+}
+
+// assignment is one attribute on a line of an attributes file.
+type assignment struct {
+	name, value string
+}
+
+// rule is one line of an attributes file.
+type rule struct {
+	// base is the directory of the file the rule came from, relative to
+	// the working tree ("" for the root). It only applies below there.
+	base string
+	re   *regexp.Regexp
+	// anchored patterns contain a slash and match the whole path below
+	// base; the rest match just the last component, at any depth.
+	anchored bool
+	attrs    []assignment
+}
+
+// Matcher holds the attribute rules in force for a working tree, lowest
+// precedence first.
+type Matcher struct {
+	rules []rule
+}
+
+// Load reads every .gitattributes in workTree, then
+// .git/info/attributes, which overrides them. A bare repository, with
+// workTree "", has only the latter.
+func Load(gitDir, workTree string) (*Matcher, error) {
+	m := &Matcher{}
+	if workTree != "" {
+		if err := m.loadWorkTree(workTree); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(gitDir, "info", "attributes"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	m.Add("", data)
+	return m, nil
+}
+
+// loadWorkTree adds every .gitattributes in workTree.
+func (m *Matcher) loadWorkTree(workTree string) error {
+	// WalkDir visits a directory before anything in it, so deeper files
+	// land later in the list and take precedence.
+	return filepath.WalkDir(workTree, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(workTree, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+		data, err := os.ReadFile(filepath.Join(p, ".gitattributes"))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		m.Add(rel, data)
+		return nil
+	})
+}
+
+// Add parses the attributes file data found in dir, a slash-separated
+// path relative to the working tree ("" for the root). Its rules take
+// precedence over those added before.
+func (m *Matcher) Add(dir string, data []byte) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if r, ok := parseRule(dir, sc.Text()); ok {
+			m.rules = append(m.rules, r)
+		}
+	}
+}
+
+// Get returns the value of the attribute name for p, a slash-separated
+// path relative to the working tree: Set, Unset, the value given with
+// "name=value", or "" if it is unspecified. The last rule to mention the
+// attribute decides.
+func (m *Matcher) Get(p, name string) string {
+	for i := len(m.rules) - 1; i >= 0; i-- {
+		r := &m.rules[i]
+		rel := p
+		if r.base != "" {
+			var ok bool
+			if rel, ok = strings.CutPrefix(p, r.base+"/"); !ok {
+				continue
+			}
+		}
+		if !r.anchored {
+			rel = path.Base(rel)
+		}
+		if !r.re.MatchString(rel) {
+			continue
+		}
+		for j := len(r.attrs) - 1; j >= 0; j-- {
+			if r.attrs[j].name == name {
+				return r.attrs[j].value
+			}
+		}
+	}
+	return ""
+}
+
+// parseRule parses one line of an attributes file. ok is false for
+// blank lines, comments, and lines git ignores: negative patterns and
+// macro definitions.
+func parseRule(dir, line string) (rule, bool) {
+	fields := strings.Fields(strings.TrimSuffix(line, "\r"))
+	if len(fields) == 0 || fields[0][0] == '#' || fields[0][0] == '!' || fields[0][0] == '[' {
+		return rule{}, false
+	}
+
+	r := rule{base: dir}
+	pattern := fields[0]
+	if strings.Contains(pattern, "/") {
+		r.anchored = true
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+	re, err := ignore.Glob(pattern)
+	if err != nil {
+		return rule{}, false
+	}
+	r.re = re
+
+	for _, f := range fields[1:] {
+		a := assignment{name: f, value: Set}
+		switch {
+		case f[0] == '-':
+			a = assignment{f[1:], Unset}
+		case f[0] == '!':
+			a = assignment{f[1:], ""}
+		default:
+			if name, value, ok := strings.Cut(f, "="); ok {
+				a = assignment{name, value}
+			}
+		}
+		if a.value == Set && macros[a.name] != nil {
+			r.attrs = append(r.attrs, macros[a.name]...)
+		}
+		r.attrs = append(r.attrs, a)
+	}
+	return r, true
+}
@@ -0,0 +1,59 @@
+package attr
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, root, name, content string) {
+	t.Helper()
+	p := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The expectations below were checked against git check-attr.
+func TestLoad(t *testing.T) {
+	workTree := t.TempDir()
+	gitDir := filepath.Join(workTree, ".git")
+	writeFile(t, workTree, ".gitattributes", `# comment
+*.bin binary
+*.txt text eol=lf
+/root.c filter=rootonly
+src/**/*.c filter=deep
+`)
+	writeFile(t, workTree, "src/.gitattributes", "*.txt -text\n*.c !filter diff=cpp\n")
+	writeFile(t, gitDir, "info/attributes", "info.* filter=info\n")
+
+	m, err := Load(gitDir, workTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path, name, want string
+	}{
+		{"a.bin", "diff", Unset},
+		{"a.bin", "text", Unset},
+		{"a.bin", "eol", ""},
+		{"a.txt", "text", Set},
+		{"a.txt", "eol", "lf"},
+		{"src/a.txt", "text", Unset},
+		{"src/a.txt", "eol", "lf"},
+		{"root.c", "filter", "rootonly"},
+		{"src/root.c", "filter", ""},
+		{"src/root.c", "diff", "cpp"},
+		{"src/deep/x.c", "filter", ""},
+		{"src/deep/x.c", "diff", "cpp"},
+		{"x.c", "filter", ""},
+		{"info.c", "filter", "info"},
+	} {
+		if got := m.Get(tt.path, tt.name); got != tt.want {
+			t.Errorf("Get(%q, %q) = %q, want %q", tt.path, tt.name, got, tt.want)
+		}
+	}
+}
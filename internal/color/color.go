@@ -0,0 +1,128 @@
+// Package color provides the ANSI palette shared by command output, along
+// with the auto-detection that decides whether escapes are emitted at all.
+package color
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Color is an ANSI SGR escape sequence.
+type Color string
+
+const (
+	Reset  Color = "\x1b[m"
+	Bold   Color = "\x1b[1m"
+	Red    Color = "\x1b[31m"
+	Green  Color = "\x1b[32m"
+	Yellow Color = "\x1b[33m"
+	Cyan   Color = "\x1b[36m"
+)
+
+// Palette slots. Commands refer to these rather than raw colors so every
+// command renders the same kind of output the same way.
+const (
+	DiffMeta       = Bold
+	DiffAdded      = Green
+	DiffRemoved    = Red
+	DiffHunk       = Cyan
+	LogHash        = Yellow
+	StatusStaged   = Green
+	StatusUnstaged = Red
+)
+
+// Mode controls when color is used, mirroring --color and color.ui.
+type Mode int
+
+const (
+	Auto Mode = iota
+	Always
+	Never
+)
+
+// ParseMode parses a --color argument or color.ui value. Like git, the
+// boolean spellings are accepted, with "true" meaning auto.
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "", "auto", "true":
+		return Auto, nil
+	case "always":
+		return Always, nil
+	case "never", "false":
+		return Never, nil
+	default:
+		return Auto, fmt.Errorf("invalid color mode %q (want auto, always, or never)", s)
+	}
+}
+
+// Enabled reports whether output written to w should be colored under the
+// given mode. Auto colors only terminals, and NO_COLOR or TERM=dumb turn
+// it off.
+func Enabled(w io.Writer, mode Mode) bool {
+	switch mode {
+	case Always:
+		return true
+	case Never:
+		return false
+	}
+
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(w)
+}
+
+// isTerminal returns true if w is a character device such as a TTY.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Columns returns the width of the terminal w writes to, found the way
+// git finds it: $COLUMNS if set, else the terminal's own width, else 80.
+func Columns(w io.Writer) int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	if f, ok := w.(*os.File); ok {
+		if n := terminalWidth(f); n > 0 {
+			return n
+		}
+	}
+	return 80
+}
+
+// Writer wraps an output stream and paints text only when color is
+// enabled for it. With color disabled, it passes text through untouched.
+type Writer struct {
+	io.Writer
+	enabled bool
+}
+
+// NewWriter returns a Writer for w, resolving mode once up front.
+func NewWriter(w io.Writer, mode Mode) *Writer {
+	return &Writer{Writer: w, enabled: Enabled(w, mode)}
+}
+
+// Enabled reports whether this writer emits escape sequences.
+func (w *Writer) Enabled() bool {
+	return w.enabled
+}
+
+// Paint wraps s in the given color, or returns it unchanged when color
+// is disabled.
+func (w *Writer) Paint(c Color, s string) string {
+	if !w.enabled || s == "" {
+		return s
+	}
+	return string(c) + s + string(Reset)
+}
@@ -0,0 +1,68 @@
+package color
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := map[string]Mode{
+		"":       Auto,
+		"auto":   Auto,
+		"true":   Auto,
+		"always": Always,
+		"never":  Never,
+		"false":  Never,
+	}
+	for in, want := range tests {
+		got, err := ParseMode(in)
+		if err != nil {
+			t.Errorf("ParseMode(%q) error: %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ParseMode(%q): got %d, want %d", in, got, want)
+		}
+	}
+
+	if _, err := ParseMode("sometimes"); err == nil {
+		t.Error("ParseMode(\"sometimes\") should return error")
+	}
+}
+
+func TestWriter_Paint(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf, Always)
+	if got, want := w.Paint(DiffAdded, "+x"), "\x1b[32m+x\x1b[m"; got != want {
+		t.Errorf("Paint with Always: got %q, want %q", got, want)
+	}
+
+	w = NewWriter(&buf, Never)
+	if got := w.Paint(DiffAdded, "+x"); got != "+x" {
+		t.Errorf("Paint with Never: got %q, want %q", got, "+x")
+	}
+}
+
+func TestEnabled_Auto(t *testing.T) {
+	// A buffer is never a terminal.
+	if Enabled(&bytes.Buffer{}, Auto) {
+		t.Error("Auto should be disabled for non-terminal writers")
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	if !Enabled(&bytes.Buffer{}, Always) {
+		t.Error("Always should ignore NO_COLOR")
+	}
+}
+
+func TestColumns(t *testing.T) {
+	t.Setenv("COLUMNS", "")
+	if got := Columns(&bytes.Buffer{}); got != 80 {
+		t.Errorf("Columns() for a buffer = %d, want 80", got)
+	}
+	t.Setenv("COLUMNS", "132")
+	if got := Columns(&bytes.Buffer{}); got != 132 {
+		t.Errorf("Columns() with COLUMNS=132 = %d, want 132", got)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package color
+
+import "os"
+
+// terminalWidth can't ask the terminal here, so it always reports that
+// the width is unknown.
+func terminalWidth(f *os.File) int {
+	return 0
+}
@@ -0,0 +1,330 @@
+package diff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Algorithm is a way of matching up the lines of two texts. They all
+// find a correct diff; they differ in which of the possible ones they
+// pick.
+type Algorithm int
+
+const (
+	// Myers finds a diff with as few changed lines as possible. git's
+	// Myers gives up on that for large inputs to save time, but rev's
+	// doesn't, so it is also what Minimal asks for.
+	Myers Algorithm = iota
+	Minimal
+	// Patience matches up lines that appear exactly once on each side
+	// first, so unique lines such as function signatures anchor the diff
+	// rather than blank lines and braces. Code with moved or rewritten
+	// blocks often reads better this way.
+	Patience
+	// Histogram extends Patience to lines that aren't unique, preferring
+	// those that occur least often.
+	Histogram
+)
+
+var algorithmNames = map[Algorithm]string{
+	Myers: "myers", Minimal: "minimal", Patience: "patience", Histogram: "histogram",
+}
+
+func (alg Algorithm) String() string {
+	return algorithmNames[alg]
+}
+
+// ParseAlgorithm parses a --diff-algorithm or diff.algorithm value,
+// ignoring case. "default" is Myers, as in git.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	lower := strings.ToLower(name)
+	if lower == "default" {
+		return Myers, nil
+	}
+	for alg, n := range algorithmNames {
+		if n == lower {
+			return alg, nil
+		}
+	}
+	return Myers, fmt.Errorf("unknown diff algorithm %q (want myers, minimal, patience, or histogram)", name)
+}
+
+// Diff returns the ops that turn lines a into lines b, with each run of
+// changes placed as DiffBlobs places it.
+func (alg Algorithm) Diff(a, b [][]byte) []Op {
+	toStrings := func(lines [][]byte) []string {
+		out := make([]string, len(lines))
+		for i, l := range lines {
+			out[i] = string(l)
+		}
+		return out
+	}
+	aIDs, bIDs := numberLines(toStrings(a), toStrings(b))
+	return compact(alg.editScript(aIDs, bIDs), aIDs, bIDs)
+}
+
+func (alg Algorithm) editScript(a, b []int) []Op {
+	switch alg {
+	case Patience:
+		// Unlike Myers, these look at the whole of both sides, common
+		// ends included, as git's do.
+		var ops []Op
+		patience(a, b, &ops)
+		return ops
+	case Histogram:
+		var ops []Op
+		histogram(a, b, &ops)
+		return ops
+	}
+	return editScript(a, b)
+}
+
+// appendChanged appends ops deleting all of a and inserting all of b.
+func appendChanged(ops []Op, a, b []int) []Op {
+	for range a {
+		ops = append(ops, Delete)
+	}
+	for range b {
+		ops = append(ops, Insert)
+	}
+	return ops
+}
+
+func appendEqual(ops []Op, n int) []Op {
+	for range n {
+		ops = append(ops, Equal)
+	}
+	return ops
+}
+
+// patience appends the ops turning a into b to ops, following git's
+// xpatience. The lines that occur exactly once in each are matched up
+// in the longest sequence that keeps their order on both sides; lines
+// next to a match that also match are taken along, and the stretches
+// between are diffed the same way. A stretch with no unique common lines
+// falls back to Myers.
+func patience(a, b []int, ops *[]Op) {
+	if len(a) == 0 || len(b) == 0 {
+		*ops = appendChanged(*ops, a, b)
+		return
+	}
+
+	type entry struct{ countA, countB, lineA, lineB int }
+	entries := make(map[int]*entry)
+	var order []*entry // in order of first appearance in a
+	for i, id := range a {
+		e := entries[id]
+		if e == nil {
+			e = &entry{lineA: i}
+			entries[id] = e
+			order = append(order, e)
+		}
+		e.countA++
+	}
+	common := false
+	for j, id := range b {
+		if e := entries[id]; e != nil {
+			common = true
+			e.countB++
+			e.lineB = j
+		}
+	}
+	if !common {
+		*ops = appendChanged(*ops, a, b)
+		return
+	}
+
+	var unique []match
+	for _, e := range order {
+		if e.countA == 1 && e.countB == 1 {
+			unique = append(unique, match{e.lineA, e.lineB})
+		}
+	}
+	matches := longestIncreasing(unique)
+	if len(matches) == 0 {
+		*ops = append(*ops, editScript(a, b)...)
+		return
+	}
+
+	var i, j int
+	for k := 0; ; k++ {
+		nextI, nextJ := len(a), len(b)
+		if k < len(matches) {
+			nextI, nextJ = matches[k].i, matches[k].j
+			for nextI > i && nextJ > j && a[nextI-1] == b[nextJ-1] {
+				nextI--
+				nextJ--
+			}
+		}
+		for i < nextI && j < nextJ && a[i] == b[j] {
+			*ops = append(*ops, Equal)
+			i++
+			j++
+		}
+		if i < nextI || j < nextJ {
+			patience(a[i:nextI], b[j:nextJ], ops)
+		}
+		if k == len(matches) {
+			return
+		}
+		for k+1 < len(matches) && matches[k+1].i == matches[k].i+1 && matches[k+1].j == matches[k].j+1 {
+			k++
+		}
+		*ops = appendEqual(*ops, matches[k].i+1-nextI)
+		i, j = matches[k].i+1, matches[k].j+1
+	}
+}
+
+// match pairs line i of one side with line j of the other.
+type match struct{ i, j int }
+
+// longestIncreasing returns the longest subsequence of matches, which
+// are in order of i, that is also in order of j, found by patience
+// sorting.
+func longestIncreasing(matches []match) []match {
+	// tops[n] is the match ending the best sequence of length n+1 found
+	// so far; prev links each match to the one before it.
+	var tops []int
+	prev := make([]int, len(matches))
+	for m, cur := range matches {
+		n := sort.Search(len(tops), func(n int) bool { return matches[tops[n]].j > cur.j })
+		prev[m] = -1
+		if n > 0 {
+			prev[m] = tops[n-1]
+		}
+		if n == len(tops) {
+			tops = append(tops, m)
+		} else {
+			tops[n] = m
+		}
+	}
+	if len(tops) == 0 {
+		return nil
+	}
+	out := make([]match, len(tops))
+	for n, m := len(tops)-1, tops[len(tops)-1]; n >= 0; n, m = n-1, prev[m] {
+		out[n] = matches[m]
+	}
+	return out
+}
+
+// maxChainLength is how often a line may occur in a before the histogram
+// diff stops trusting it as an anchor, as in git.
+const maxChainLength = 64
+
+// histogram appends the ops turning a into b to ops, following git's
+// xhistogram. It finds the longest run of common lines built around the
+// line occurring least often in a, diffs what comes before it the same
+// way, and carries on after it. If every common line is too common, it
+// falls back to Myers.
+func histogram(a, b []int, ops *[]Op) {
+	for {
+		if len(a) == 0 || len(b) == 0 {
+			*ops = appendChanged(*ops, a, b)
+			return
+		}
+		lcs, result := longestCommonRun(a, b)
+		switch result {
+		case noCommon:
+			*ops = appendChanged(*ops, a, b)
+			return
+		case tooCommon:
+			*ops = append(*ops, editScript(a, b)...)
+			return
+		}
+		histogram(a[:lcs.startA], b[:lcs.startB], ops)
+		*ops = appendEqual(*ops, lcs.endA-lcs.startA+1)
+		a, b = a[lcs.endA+1:], b[lcs.endB+1:]
+	}
+}
+
+// run is a stretch of lines, inclusive at both ends, that a and b share.
+type run struct{ startA, startB, endA, endB int }
+
+type runResult int
+
+const (
+	foundRun runResult = iota
+	noCommon
+	tooCommon
+)
+
+// longestCommonRun finds the run of common lines histogram splits a and
+// b around: the one whose rarest line occurs least often in a, and the
+// longest among those.
+func longestCommonRun(a, b []int) (run, runResult) {
+	// For each distinct line of a: where it first occurs and how often.
+	// next chains each occurrence to the following one, or -1.
+	type record struct{ first, count int }
+	records := make(map[int]*record)
+	lineRecord := make([]*record, len(a))
+	next := make([]int, len(a))
+	for i := len(a) - 1; i >= 0; i-- {
+		r := records[a[i]]
+		if r == nil {
+			r = &record{first: -1}
+			records[a[i]] = r
+		}
+		next[i] = r.first
+		r.first = i
+		r.count++
+		lineRecord[i] = r
+	}
+
+	var best run
+	found, common := false, false
+	limit := maxChainLength + 1
+	for j := 0; j < len(b); {
+		nextJ := j + 1
+		r := records[b[j]]
+		if r != nil {
+			common = true
+		}
+		if r != nil && r.count <= limit {
+			for i := r.first; ; {
+				startA, startB, endA, endB := i, j, i, j
+				count := r.count
+				for startA > 0 && startB > 0 && a[startA-1] == b[startB-1] {
+					startA--
+					startB--
+					if count > 1 {
+						count = min(count, lineRecord[startA].count)
+					}
+				}
+				for endA < len(a)-1 && endB < len(b)-1 && a[endA+1] == b[endB+1] {
+					endA++
+					endB++
+					if count > 1 {
+						count = min(count, lineRecord[endA].count)
+					}
+				}
+				nextJ = max(nextJ, endB+1)
+				if best.endA-best.startA < endA-startA || count < limit {
+					best = run{startA, startB, endA, endB}
+					found = true
+					limit = count
+				}
+
+				// Try the next occurrence past this run.
+				n := next[i]
+				for n != -1 && n <= endA {
+					n = next[n]
+				}
+				if n == -1 {
+					break
+				}
+				i = n
+			}
+		}
+		j = nextJ
+	}
+
+	switch {
+	case common && limit > maxChainLength:
+		return run{}, tooCommon
+	case !found:
+		return run{}, noCommon
+	}
+	return best, foundRun
+}
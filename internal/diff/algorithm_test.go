@@ -0,0 +1,75 @@
+package diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestAlgorithm_DiffBlobs(t *testing.T) {
+	// A new function goes in before b and c is dropped. Myers makes the
+	// fewest changes by keeping the braces and blank lines where they
+	// are; patience and histogram anchor on the signatures and show
+	// whole functions coming and going. The expected output is git's.
+	a := "int a()\n{\n    a_body();\n}\n\nint b()\n{\n    b_body();\n}\n\nint c()\n{\n    c_body();\n}\n"
+	b := "int a()\n{\n    a_body();\n}\n\nint z()\n{\n    z_body();\n}\n\nint b()\n{\n    b_body();\n}\n"
+	myers := "@@ -3,12 +3,12 @@ int a()\n" +
+		"     a_body();\n }\n \n" +
+		"-int b()\n+int z()\n {\n-    b_body();\n+    z_body();\n }\n \n" +
+		"-int c()\n+int b()\n {\n-    c_body();\n+    b_body();\n }\n"
+	unique := "@@ -3,12 +3,12 @@ int a()\n" +
+		"     a_body();\n }\n \n" +
+		"+int z()\n+{\n+    z_body();\n+}\n+\n" +
+		" int b()\n {\n     b_body();\n }\n" +
+		"-\n-int c()\n-{\n-    c_body();\n-}\n"
+
+	for _, tt := range []struct {
+		alg  Algorithm
+		want string
+	}{
+		{Myers, myers},
+		{Minimal, myers},
+		{Patience, unique},
+		{Histogram, unique},
+	} {
+		t.Run(tt.alg.String(), func(t *testing.T) {
+			if got := render(tt.alg.DiffBlobs([]byte(a), []byte(b))); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlgorithm_Random(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomText := func() string {
+		var b strings.Builder
+		for range rng.Intn(40) {
+			b.WriteString(string(rune('a' + rng.Intn(8))))
+			b.WriteString("\n")
+		}
+		return b.String()
+	}
+	for _, alg := range []Algorithm{Patience, Histogram} {
+		for range 500 {
+			a, b := randomText(), randomText()
+			if got := apply(t, a, alg.DiffBlobs([]byte(a), []byte(b))); got != b {
+				t.Fatalf("%s: applying diff of %q to %q gave %q", alg, a, b, got)
+			}
+		}
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for name, want := range map[string]Algorithm{
+		"myers": Myers, "default": Myers, "minimal": Minimal,
+		"Patience": Patience, "HISTOGRAM": Histogram,
+	} {
+		if got, err := ParseAlgorithm(name); err != nil || got != want {
+			t.Errorf("ParseAlgorithm(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseAlgorithm("fast"); err == nil {
+		t.Error("ParseAlgorithm(\"fast\") succeeded")
+	}
+}
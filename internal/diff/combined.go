@@ -0,0 +1,369 @@
+package diff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// CombinedLine is one line of a combined diff. Marks has a column for
+// each parent: '-' for a line the result dropped from that parent, '+'
+// for a result line that parent lacks, and ' ' otherwise.
+type CombinedLine struct {
+	Marks string
+	Text  string
+}
+
+// CombinedHunk is a run of a combined diff with its range in each parent
+// and in the result.
+type CombinedHunk struct {
+	ParentStarts, ParentLines []int
+	NewStart, NewLines        int
+	// Section is the start of the nearest line above the hunk, since the
+	// previous one, that looks like a function header.
+	Section string
+	Lines   []CombinedLine
+}
+
+// Header returns the hunk's "@@@ -a,b -c,d +e,f @@@" line, with one more
+// '@' than there are parents, followed by its section if it has one.
+func (h CombinedHunk) Header() string {
+	at := strings.Repeat("@", len(h.ParentStarts)+1)
+	var b strings.Builder
+	b.WriteString(at)
+	for i, start := range h.ParentStarts {
+		fmt.Fprintf(&b, " -%d,%d", start, h.ParentLines[i])
+	}
+	fmt.Fprintf(&b, " +%d,%d %s", h.NewStart, h.NewLines, at)
+	if h.Section != "" {
+		b.WriteString(" " + h.Section)
+	}
+	return b.String()
+}
+
+// MaxParents is how many parents DiffCombined can compare against.
+const MaxParents = 64
+
+// lostLine is a line the result dropped, with a bit set for each parent
+// that had it.
+type lostLine struct {
+	text    string
+	parents uint64
+}
+
+// combinedLine is what DiffCombined knows of a line of the result: a bit
+// for each parent that lacks it, the lines dropped just before it, and
+// each parent's line number there.
+type combinedLine struct {
+	added uint64
+	lost  []lostLine
+	pLine []int
+	// shown is set for the lines that go into a hunk, and noLost for
+	// leading context whose lost lines aren't shown.
+	shown, noLost bool
+}
+
+// DiffCombined returns the combined diff of result against each of
+// parents, as git's "diff --cc" shows a merge: only the hunks where the
+// result differs from every parent in some way, so that a hunk taken
+// whole from one side is left out. It returns nil if there is no such
+// hunk. There may be at most MaxParents parents.
+func (alg Algorithm) DiffCombined(parents [][]byte, result []byte) []CombinedHunk {
+	res := splitLines(result)
+	n := len(res)
+	// One more entry holds lines dropped after the last, and another the
+	// parents' line counts at the end.
+	lines := make([]combinedLine, n+2)
+	for i := range lines {
+		lines[i].pLine = make([]int, len(parents))
+	}
+	for p, parent := range parents {
+		alg.addParent(lines, res, splitLines(parent), p)
+	}
+
+	all := uint64(1)<<len(parents) - 1
+	if len(parents) == MaxParents {
+		all = ^uint64(0)
+	}
+	markHunks(lines, n, all)
+	return combinedHunks(lines, res, len(parents))
+}
+
+// addParent records how result lines res differ from parent p's lines
+// old.
+func (alg Algorithm) addParent(lines []combinedLine, res, old []string, p int) {
+	oldIDs, resIDs := numberLines(old, res)
+	ops := compact(alg.editScript(oldIDs, resIDs), oldIDs, resIDs)
+	bit := uint64(1) << p
+
+	// Lines dropped in a run of changes hang before the result line the
+	// run starts at, merged with what other parents dropped there.
+	lost := make(map[int][]string)
+	var i, j int
+	at := -1
+	for _, op := range ops {
+		switch op {
+		case Equal:
+			i++
+			j++
+			at = -1
+		case Delete:
+			if at < 0 {
+				at = j
+			}
+			lost[at] = append(lost[at], old[i])
+			i++
+		case Insert:
+			if at < 0 {
+				at = j
+			}
+			lines[j].added |= bit
+			j++
+		}
+	}
+	for at, dropped := range lost {
+		lines[at].lost = coalesce(lines[at].lost, dropped, bit)
+	}
+
+	line := 1
+	for k := range lines {
+		lines[k].pLine[p] = line
+		for _, l := range lines[k].lost {
+			if l.parents&bit != 0 {
+				line++
+			}
+		}
+		if k < len(res) && lines[k].added&bit == 0 {
+			line++
+		}
+	}
+}
+
+// coalesce merges the lines parent bit dropped at a point into those
+// other parents dropped there, sharing the lines of their longest common
+// subsequence.
+func coalesce(base []lostLine, dropped []string, bit uint64) []lostLine {
+	lcs := make([][]int, len(base)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(dropped)+1)
+	}
+	for i := 1; i <= len(base); i++ {
+		for j := 1; j <= len(dropped); j++ {
+			if base[i-1].text == dropped[j-1] {
+				lcs[i][j] = lcs[i-1][j-1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i][j-1], lcs[i-1][j])
+			}
+		}
+	}
+
+	var out []lostLine
+	i, j := len(base), len(dropped)
+	for i > 0 || j > 0 {
+		switch {
+		case i > 0 && j > 0 && base[i-1].text == dropped[j-1]:
+			l := base[i-1]
+			l.parents |= bit
+			out = append(out, l)
+			i--
+			j--
+		case j > 0 && (i == 0 || lcs[i][j-1] >= lcs[i-1][j]):
+			out = append(out, lostLine{dropped[j-1], bit})
+			j--
+		default:
+			out = append(out, base[i-1])
+			i--
+		}
+	}
+	slices.Reverse(out)
+	return out
+}
+
+// markHunks sets shown on the lines of the n result lines that belong in
+// a hunk. A hunk is dropped if every change in it is against the same
+// parents, short of all of them, since the result then just takes the
+// other side's version.
+func markHunks(lines []combinedLine, n int, all uint64) {
+	for k := 0; k <= n; k++ {
+		lines[k].shown = lines[k].added != 0 || len(lines[k].lost) > 0
+	}
+
+	for i := 0; i <= n; {
+		for i <= n && !lines[i].shown {
+			i++
+		}
+		if i > n {
+			break
+		}
+		start := i
+		j := i + 1
+		for ; j <= n; j++ {
+			if lines[j].shown {
+				continue
+			}
+			// Carry on if there is another change within Context lines.
+			next := min(hunkTail(lines, start, j)+Context, n+1)
+			for next--; next >= j && !lines[next].shown; next-- {
+			}
+			if next < j {
+				break
+			}
+			j = next
+		}
+		end := j
+
+		var same uint64
+		interesting := false
+		for k := start; k < end && !interesting; k++ {
+			if d := lines[k].added; d != 0 {
+				if same == 0 {
+					same = d
+				} else if same != d {
+					interesting = true
+				}
+			}
+			for _, l := range lines[k].lost {
+				if same == 0 {
+					same = l.parents
+				} else if same != l.parents {
+					interesting = true
+				}
+			}
+		}
+		if !interesting && same != all {
+			for k := start; k < end; k++ {
+				lines[k].shown = false
+			}
+		}
+		i = end
+	}
+
+	addContext(lines, n)
+}
+
+// addContext marks up to Context lines around each run of shown lines,
+// joining runs whose gap is smaller than that.
+func addContext(lines []combinedLine, n int) {
+	next := func(i int, shown bool) int {
+		for i <= n && lines[i].shown != shown {
+			i++
+		}
+		return i
+	}
+	for i := next(0, true); i <= n; {
+		for j := max(i-Context, 0); j < i; j++ {
+			if !lines[j].shown {
+				lines[j].noLost = true
+			}
+			lines[j].shown = true
+		}
+		for {
+			j := next(i, false)
+			if j > n {
+				return
+			}
+			k := next(j, true)
+			j = hunkTail(lines, i, j)
+			if k < j+Context {
+				for ; j < k; j++ {
+					lines[j].shown = true
+				}
+				i = k
+				continue
+			}
+			for end := min(j+Context, n+1); j < end; j++ {
+				lines[j].shown = true
+			}
+			i = k
+			break
+		}
+	}
+}
+
+// hunkTail returns where trailing context should start for a hunk from
+// start to end. A last line shown only for the lines dropped before it
+// is itself context.
+func hunkTail(lines []combinedLine, start, end int) int {
+	if start+1 <= end && lines[end-1].added == 0 {
+		end--
+	}
+	return end
+}
+
+// maxCombinedSectionLen caps a combined hunk's section, as git does.
+const maxCombinedSectionLen = 40
+
+// combinedHunks gathers the shown lines into hunks.
+func combinedHunks(lines []combinedLine, res []string, parents int) []CombinedHunk {
+	n := len(res)
+	marks := func(bits uint64, mark byte) string {
+		b := make([]byte, parents)
+		for p := range b {
+			b[p] = ' '
+			if bits&(1<<p) != 0 {
+				b[p] = mark
+			}
+		}
+		return string(b)
+	}
+
+	var out []CombinedHunk
+	for k := 0; ; {
+		var sectionLine string
+		for ; k <= n && !lines[k].shown; k++ {
+			if k < n && isSectionLine(res[k]) {
+				sectionLine = res[k]
+			}
+		}
+		if k > n {
+			return out
+		}
+		end := k + 1
+		for end <= n && lines[end].shown {
+			end++
+		}
+
+		h := CombinedHunk{
+			ParentStarts: make([]int, parents),
+			ParentLines:  make([]int, parents),
+			NewStart:     k + 1,
+			NewLines:     end - k,
+			Section:      combinedSection(sectionLine),
+		}
+		if end > n {
+			h.NewLines--
+		}
+		for p := range parents {
+			h.ParentStarts[p] = lines[k].pLine[p]
+			h.ParentLines[p] = lines[end].pLine[p] - lines[k].pLine[p]
+		}
+		for ; k < end; k++ {
+			if !lines[k].noLost {
+				for _, l := range lines[k].lost {
+					h.Lines = append(h.Lines, CombinedLine{marks(l.parents, '-'), l.text})
+				}
+			}
+			if k == n {
+				k++
+				break
+			}
+			h.Lines = append(h.Lines, CombinedLine{marks(lines[k].added, '+'), res[k]})
+		}
+		out = append(out, h)
+	}
+}
+
+// combinedSection cuts a section line as git does for combined hunks:
+// to its first maxCombinedSectionLen bytes, and then to before the last
+// of those that isn't a space.
+func combinedSection(l string) string {
+	l, _, _ = strings.Cut(l, "\n")
+	l = l[:min(len(l), maxCombinedSectionLen)]
+	end := 0
+	for i := 0; i < len(l); i++ {
+		if !strings.ContainsRune(" \t\r\v\f", rune(l[i])) {
+			end = i
+		}
+	}
+	return l[:end]
+}
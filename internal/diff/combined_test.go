@@ -0,0 +1,68 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+// renderCombined formats hunks the way rev show prints a merge's
+// combined diff, minus the file headers.
+func renderCombined(hunks []CombinedHunk) string {
+	var b strings.Builder
+	for _, h := range hunks {
+		b.WriteString(h.Header() + "\n")
+		for _, l := range h.Lines {
+			b.WriteString(l.Marks + l.Text)
+		}
+	}
+	return b.String()
+}
+
+func TestDiffCombined(t *testing.T) {
+	tests := []struct {
+		name    string
+		parents []string
+		result  string
+		want    string
+	}{
+		{
+			"conflict resolved", []string{"a\nB1\nc\n", "a\nB2\nc\n"}, "a\nR\nc\n",
+			"@@@ -1,3 -1,3 +1,3 @@@\n  a\n- B1\n -B2\n++R\n  c\n",
+		},
+		{"one side taken", []string{"a\nB1\nc\n", "a\nB2\nc\n"}, "a\nB1\nc\n", ""},
+		{
+			"both sides kept", []string{"a\nx\nc\n", "a\ny\nc\n"}, "a\nx\ny\nc\n",
+			"@@@ -1,3 -1,3 +1,4 @@@\n  a\n +x\n+ y\n  c\n",
+		},
+		{
+			// The first change comes whole from the first parent, so only
+			// the second is shown.
+			"uninteresting hunk dropped",
+			[]string{"one\n" + numbered(2, 11) + "P\n", numbered(1, 11) + "Q\n"},
+			"one\n" + numbered(2, 11) + "R\n",
+			"@@@ -9,4 -9,4 +9,4 @@@ on\n  9\n  10\n  11\n- P\n -Q\n++R\n",
+		},
+		{
+			"octopus", []string{"a\nb\n", "a\nc\n", "a\nd\n"}, "a\nR\n",
+			"@@@@ -1,2 -1,2 -1,2 +1,2 @@@@\n   a\n-  b\n - c\n  -d\n+++R\n",
+		},
+		{
+			"section cut short", []string{"func f\n1\n2\n3\n4\n5\nP\n", "func f\n1\n2\n3\n4\n5\nQ\n"},
+			"func f\n1\n2\n3\n4\n5\nR\n",
+			"@@@ -4,4 -4,4 +4,4 @@@ func \n  3\n  4\n  5\n- P\n -Q\n++R\n",
+		},
+		{
+			"deleted", []string{"a\n", "b\n"}, "",
+			"@@@ -1,1 -1,1 +1,0 @@@\n- a\n -b\n",
+		},
+	}
+	for _, tt := range tests {
+		var parents [][]byte
+		for _, p := range tt.parents {
+			parents = append(parents, []byte(p))
+		}
+		if got := renderCombined(Myers.DiffCombined(parents, []byte(tt.result))); got != tt.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,469 @@
+// Package diff computes line-based differences between blobs, with the
+// Myers algorithm or one of git's alternatives, and groups them into
+// unified-diff hunks.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Op says what a line of a hunk does.
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Line is one line of a hunk. Text keeps its trailing newline, so a last
+// line without one can be told apart and marked as git does.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// Hunk is a run of changes with the unchanged lines around them. Starts
+// are 1-based line numbers in the old and new blobs.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	// Section is the nearest line above the hunk that looks like the
+	// start of a function or block, shown after the header as git does.
+	Section string
+	Lines   []Line
+}
+
+// Context is how many unchanged lines surround each change. Changes
+// closer together than twice this share a hunk.
+const Context = 3
+
+// Header returns the hunk's "@@ -a,b +c,d @@" line, followed by its
+// section if it has one.
+func (h Hunk) Header() string {
+	s := fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+	if h.Section != "" {
+		s += " " + h.Section
+	}
+	return s
+}
+
+// hunkRange formats one side of a hunk header. A count of one is left
+// out, and an empty side names the line before it.
+func hunkRange(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, n)
+}
+
+// binarySniffLen is how much of a blob IsBinary looks at, matching git.
+const binarySniffLen = 8000
+
+// IsBinary reports whether data should be treated as binary rather than
+// text, which git decides by looking for a NUL byte near the start.
+func IsBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), binarySniffLen)], 0) >= 0
+}
+
+// DiffBlobs returns the hunks that turn a into b, or nil if they are the
+// same. Both are treated as lines of text; callers check IsBinary first.
+func DiffBlobs(a, b []byte) []Hunk {
+	return Myers.DiffBlobs(a, b)
+}
+
+// DiffBlobs is the package's DiffBlobs, finding the changes with alg.
+func (alg Algorithm) DiffBlobs(a, b []byte) []Hunk {
+	oldLines, newLines := splitLines(a), splitLines(b)
+	oldIDs, newIDs := numberLines(oldLines, newLines)
+	ops := compact(alg.editScript(oldIDs, newIDs), oldIDs, newIDs)
+
+	lines := make([]Line, len(ops))
+	var i, j int
+	for n, op := range ops {
+		switch op {
+		case Equal:
+			lines[n] = Line{Equal, oldLines[i]}
+			i++
+			j++
+		case Delete:
+			lines[n] = Line{Delete, oldLines[i]}
+			i++
+		case Insert:
+			lines[n] = Line{Insert, newLines[j]}
+			j++
+		}
+	}
+	return hunks(lines, oldLines)
+}
+
+// numberLines gives each distinct line of a and b a number, since the
+// algorithms compare ints.
+func numberLines(a, b []string) (aIDs, bIDs []int) {
+	ids := make(map[string]int)
+	number := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[l]
+			if !ok {
+				id = len(ids)
+				ids[l] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	return number(a), number(b)
+}
+
+// splitLines splits data after each newline. The last line has no
+// newline if data doesn't end with one.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// editScript returns a shortest sequence of ops turning a into b, found
+// with Myers' O(ND) algorithm: for each edit count d it records the
+// furthest point reached on every diagonal k = x - y, then walks those
+// records back from the end to recover the path.
+func editScript(a, b []int) []Op {
+	// Common ends need no search, and trimming them keeps the trace
+	// small for the usual case of a few edits in a large file.
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	ops := make([]Op, 0, prefix+len(a)+len(b)+suffix)
+	for range prefix {
+		ops = append(ops, Equal)
+	}
+	ops = append(ops, shortestPath(a, b)...)
+	for range suffix {
+		ops = append(ops, Equal)
+	}
+	return ops
+}
+
+// shortestPath is the Myers search itself. trace[d] holds the furthest x
+// on diagonals -d..d after d edits.
+func shortestPath(a, b []int) []Op {
+	n, m := len(a), len(b)
+	off := n + m + 1
+	v := make([]int, 2*off+1)
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				trace = append(trace, slices.Clone(v[off-d:off+d+1]))
+				return backtrack(trace, n, m)
+			}
+		}
+		trace = append(trace, slices.Clone(v[off-d:off+d+1]))
+	}
+	return nil
+}
+
+// backtrack follows trace from (n, m) back to the start, emitting the
+// ops in order.
+func backtrack(trace [][]int, n, m int) []Op {
+	var ops []Op
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1] // diagonal k is at prev[k+d-1]
+		k := x - y
+		prevK := k - 1
+		if k == -d || k != d && prev[k-1+d-1] < prev[k+1+d-1] {
+			prevK = k + 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, Equal)
+			x--
+			y--
+		}
+		if x == prevX {
+			ops = append(ops, Insert)
+			y--
+		} else {
+			ops = append(ops, Delete)
+			x--
+		}
+	}
+	for ; x > 0; x-- {
+		ops = append(ops, Equal)
+	}
+	slices.Reverse(ops)
+	return ops
+}
+
+// compact moves each run of deleted or inserted lines to a consistent
+// place, as git's xdiff does. A run can often slide up or down over
+// matching lines and still describe the same change, and Myers leaves
+// it wherever the search happened to. Runs go as far down as they can,
+// unless somewhere along the way they line up with a change on the other
+// side, which makes for a tidier replacement.
+func compact(ops []Op, a, b []int) []Op {
+	from := &side{lines: a, changed: make([]bool, len(a))}
+	to := &side{lines: b, changed: make([]bool, len(b))}
+	var i, j int
+	for _, op := range ops {
+		switch op {
+		case Equal:
+			i++
+			j++
+		case Delete:
+			from.changed[i] = true
+			i++
+		case Insert:
+			to.changed[j] = true
+			j++
+		}
+	}
+	from.compact(to)
+	to.compact(from)
+
+	ops = ops[:0]
+	for i, j = 0, 0; i < len(a) || j < len(b); {
+		switch {
+		case from.at(i):
+			ops = append(ops, Delete)
+			i++
+		case to.at(j):
+			ops = append(ops, Insert)
+			j++
+		default:
+			ops = append(ops, Equal)
+			i++
+			j++
+		}
+	}
+	return ops
+}
+
+// side is one blob's lines and which of them are changed.
+type side struct {
+	lines   []int
+	changed []bool
+}
+
+// group is a run of changed lines [start, end) on one side. Between each
+// pair of unchanged lines there is exactly one group per side, possibly
+// empty, so walking both sides' groups together keeps them paired.
+type group struct{ start, end int }
+
+func (s *side) at(i int) bool {
+	return i >= 0 && i < len(s.changed) && s.changed[i]
+}
+
+func (s *side) first() group {
+	var g group
+	for s.at(g.end) {
+		g.end++
+	}
+	return g
+}
+
+// next moves g to the following group, reporting false at the end.
+func (s *side) next(g *group) bool {
+	if g.end == len(s.changed) {
+		return false
+	}
+	g.start = g.end + 1
+	g.end = g.start
+	for s.at(g.end) {
+		g.end++
+	}
+	return true
+}
+
+// prev moves g to the preceding group, reporting false at the start.
+func (s *side) prev(g *group) bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	g.start = g.end
+	for s.at(g.start - 1) {
+		g.start--
+	}
+	return true
+}
+
+// slideDown shifts g down a line if the line after it matches its first
+// line, absorbing any group it runs into.
+func (s *side) slideDown(g *group) bool {
+	if g.end >= len(s.lines) || s.lines[g.start] != s.lines[g.end] {
+		return false
+	}
+	s.changed[g.start], s.changed[g.end] = false, true
+	g.start++
+	g.end++
+	for s.at(g.end) {
+		g.end++
+	}
+	return true
+}
+
+// slideUp is slideDown in the other direction.
+func (s *side) slideUp(g *group) bool {
+	if g.start == 0 || s.lines[g.start-1] != s.lines[g.end-1] {
+		return false
+	}
+	g.start--
+	g.end--
+	s.changed[g.start], s.changed[g.end] = true, false
+	for s.at(g.start - 1) {
+		g.start--
+	}
+	return true
+}
+
+// compact places each of s's groups, tracking the paired group in other
+// so it can tell when a group lines up with a change there.
+func (s *side) compact(other *side) {
+	g, og := s.first(), other.first()
+	for {
+		if g.end != g.start {
+			var earliestEnd int
+			endMatchingOther := -1
+			// Sliding can merge groups, which may make room to slide
+			// further, so repeat until the size settles.
+			for {
+				size := g.end - g.start
+				endMatchingOther = -1
+				for s.slideUp(&g) {
+					other.prev(&og)
+				}
+				earliestEnd = g.end
+				if og.end > og.start {
+					endMatchingOther = g.end
+				}
+				for s.slideDown(&g) {
+					other.next(&og)
+					if og.end > og.start {
+						endMatchingOther = g.end
+					}
+				}
+				if size == g.end-g.start {
+					break
+				}
+			}
+			if g.end != earliestEnd && endMatchingOther != -1 {
+				for og.end == og.start {
+					s.slideUp(&g)
+					other.prev(&og)
+				}
+			}
+		}
+		if !s.next(&g) {
+			return
+		}
+		other.next(&og)
+	}
+}
+
+// hunks groups the changed lines of a full diff into hunks with Context
+// lines around them. old is the old blob's lines, searched for sections.
+func hunks(lines []Line, old []string) []Hunk {
+	var out []Hunk
+	for start := 0; start < len(lines); {
+		// Find the next change and extend the hunk until a run of more
+		// than 2*Context unchanged lines, or the end.
+		first := start
+		for first < len(lines) && lines[first].Op == Equal {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		end := first
+		for i := first; i < len(lines); i++ {
+			if lines[i].Op != Equal {
+				end = i + 1
+			} else if i-end >= 2*Context {
+				break
+			}
+		}
+
+		from := max(first-Context, start)
+		to := min(end+Context, len(lines))
+		h := Hunk{OldStart: 1, NewStart: 1, Lines: lines[from:to]}
+		for _, l := range lines[:from] {
+			if l.Op != Insert {
+				h.OldStart++
+			}
+			if l.Op != Delete {
+				h.NewStart++
+			}
+		}
+		for _, l := range h.Lines {
+			if l.Op != Insert {
+				h.OldLines++
+			}
+			if l.Op != Delete {
+				h.NewLines++
+			}
+		}
+		h.Section = section(old[:h.OldStart-1])
+		out = append(out, h)
+		start = to
+	}
+	return out
+}
+
+// maxSectionLen caps a hunk header's section text, as git does.
+const maxSectionLen = 80
+
+// section returns the last of lines that isSectionLine accepts.
+func section(lines []string) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if l := lines[i]; isSectionLine(l) {
+			if len(l) > maxSectionLen {
+				l = l[:maxSectionLen]
+			}
+			return strings.TrimRight(l, " \t\r\n")
+		}
+	}
+	return ""
+}
+
+// isSectionLine reports whether l looks like a function header: it
+// starts with a letter, '_', or '$'.
+func isSectionLine(l string) bool {
+	if l == "" {
+		return false
+	}
+	c := l[0]
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$'
+}
@@ -0,0 +1,173 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// render formats hunks the way rev diff prints them, minus the file
+// headers.
+func render(hunks []Hunk) string {
+	var b strings.Builder
+	for _, h := range hunks {
+		b.WriteString(h.Header() + "\n")
+		for _, l := range h.Lines {
+			b.WriteString(" -+"[l.Op:l.Op+1] + l.Text)
+			if !strings.HasSuffix(l.Text, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+func numbered(from, to int) string {
+	var b strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return b.String()
+}
+
+func TestDiffBlobs(t *testing.T) {
+	tests := []struct {
+		name, a, b, want string
+	}{
+		{"same", "a\nb\n", "a\nb\n", ""},
+		{"from empty", "", "a\nb\n", "@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"to empty", "a\n", "", "@@ -1 +0,0 @@\n-a\n"},
+		{
+			"replace",
+			"one\ntwo\nthree\n", "one\n2\nthree\nfour",
+			"@@ -1,3 +1,4 @@\n one\n-two\n+2\n three\n+four\n\\ No newline at end of file\n",
+		},
+		{
+			"newline added",
+			"a\nb", "a\nb\n",
+			"@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			// Six unchanged lines between changes still make one hunk.
+			"merged hunks",
+			numbered(1, 10), strings.Replace(strings.Replace(numbered(1, 10), "2\n", "x\n", 1), "9\n", "y\n", 1),
+			"@@ -1,10 +1,10 @@\n 1\n-2\n+x\n 3\n 4\n 5\n 6\n 7\n 8\n-9\n+y\n 10\n",
+		},
+		{
+			"separate hunks",
+			numbered(1, 12), strings.Replace(strings.Replace(numbered(1, 12), "1\n", "x\n", 1), "12\n", "y\n", 1),
+			"@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+y\n",
+		},
+		{
+			// The inserted copy could sit above or below the existing
+			// block; like git, it goes below.
+			"slid down",
+			"{\n}\n", "{\n}\n{\n}\n",
+			"@@ -1,2 +1,4 @@\n {\n }\n+{\n+}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := render(DiffBlobs([]byte(tt.a), []byte(tt.b))); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffBlobs_Section(t *testing.T) {
+	a := "func f() {\n" + numbered(1, 8) + "}\n"
+	b := strings.Replace(a, "7\n", "seven\n", 1)
+	hunks := DiffBlobs([]byte(a), []byte(b))
+	if len(hunks) != 1 {
+		t.Fatalf("got %d hunks", len(hunks))
+	}
+	if got, want := hunks[0].Header(), "@@ -5,6 +5,6 @@ func f() {"; got != want {
+		t.Errorf("Header() = %q, want %q", got, want)
+	}
+}
+
+// apply rebuilds the new blob from the old one and its hunks.
+func apply(t *testing.T, a string, hunks []Hunk) string {
+	t.Helper()
+	old := splitLines([]byte(a))
+	var out []string
+	next := 0
+	for _, h := range hunks {
+		out = append(out, old[next:h.OldStart-1]...)
+		next = h.OldStart - 1
+		for _, l := range h.Lines {
+			if l.Op != Insert {
+				if old[next] != l.Text {
+					t.Fatalf("hunk %s expects %q at line %d, have %q", h.Header(), l.Text, next+1, old[next])
+				}
+				next++
+			}
+			if l.Op != Delete {
+				out = append(out, l.Text)
+			}
+		}
+	}
+	out = append(out, old[next:]...)
+	return strings.Join(out, "")
+}
+
+func TestDiffBlobs_Random(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomText := func() string {
+		var b strings.Builder
+		for range rng.Intn(40) {
+			b.WriteString(string(rune('a' + rng.Intn(4))))
+			b.WriteString("\n")
+		}
+		return b.String()
+	}
+	for range 500 {
+		a, b := randomText(), randomText()
+		if got := apply(t, a, DiffBlobs([]byte(a), []byte(b))); got != b {
+			t.Fatalf("applying diff of %q to %q gave %q", a, b, got)
+		}
+	}
+}
+
+func TestEditScript_Shortest(t *testing.T) {
+	// "abcabba" to "cbabac", the example from Myers' paper, takes five
+	// edits.
+	a, b := []int{0, 1, 2, 0, 1, 1, 0}, []int{2, 1, 0, 1, 0, 2}
+	edits := 0
+	for _, op := range editScript(a, b) {
+		if op != Equal {
+			edits++
+		}
+	}
+	if edits != 5 {
+		t.Errorf("got %d edits, want 5", edits)
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	tests := map[string]bool{
+		"":                                 false,
+		"plain text\n":                     false,
+		"a\x00b":                           true,
+		strings.Repeat("x", 8000) + "\x00": false,
+	}
+	for in, want := range tests {
+		if got := IsBinary([]byte(in)); got != want {
+			t.Errorf("IsBinary(%.20q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestHunkHeader(t *testing.T) {
+	got := []string{
+		Hunk{OldStart: 1, OldLines: 0, NewStart: 1, NewLines: 3}.Header(),
+		Hunk{OldStart: 4, OldLines: 1, NewStart: 4, NewLines: 2, Section: "func x()"}.Header(),
+	}
+	want := []string{"@@ -0,0 +1,3 @@", "@@ -4 +4,2 @@ func x()"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,197 @@
+package diff
+
+import (
+	"hash/fnv"
+	"path"
+	"sort"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// DefaultRenameThreshold is the similarity, in percent, that -M asks for
+// when it isn't given one.
+const DefaultRenameThreshold = 50
+
+// renameLimit caps the deleted and added files compared by content, as
+// git's diff.renameLimit does; beyond it only exact renames are found.
+const renameLimit = 1000
+
+// DetectRenames turns pairs of a deleted and an added file in changes
+// into renames when the added file's content is at least threshold
+// percent similar to the deleted one's. Identical blobs are paired first,
+// preferring a source with the same base name; the rest are scored by how
+// much content they share, and the best-scoring pairs win. Empty files
+// and pairs of a symlink with a regular file are never matched. The
+// result is sorted by path like DiffTrees's.
+func DetectRenames(gitDir string, changes []Change, threshold int) ([]Change, error) {
+	var srcs, dsts []int
+	for i, c := range changes {
+		switch {
+		case c.Kind == Deleted && renamable(c.OldMode, c.OldHash):
+			srcs = append(srcs, i)
+		case c.Kind == Added && renamable(c.NewMode, c.NewHash):
+			dsts = append(dsts, i)
+		}
+	}
+	if len(srcs) == 0 || len(dsts) == 0 {
+		return changes, nil
+	}
+
+	// pairs maps a destination to its source and score.
+	type match struct{ src, score int }
+	pairs := make(map[int]match)
+	used := make(map[int]bool)
+	compatible := func(s, d int) bool {
+		return (changes[s].OldMode == object.ModeSymlink) == (changes[d].NewMode == object.ModeSymlink)
+	}
+
+	byHash := make(map[string][]int)
+	for _, s := range srcs {
+		byHash[changes[s].OldHash] = append(byHash[changes[s].OldHash], s)
+	}
+	for _, d := range dsts {
+		best := -1
+		for _, s := range byHash[changes[d].NewHash] {
+			if used[s] || !compatible(s, d) {
+				continue
+			}
+			if best < 0 || path.Base(changes[s].OldPath) == path.Base(changes[d].NewPath) && path.Base(changes[best].OldPath) != path.Base(changes[d].NewPath) {
+				best = s
+			}
+		}
+		if best >= 0 {
+			pairs[d] = match{best, 100}
+			used[best] = true
+		}
+	}
+
+	var restSrcs, restDsts []int
+	for _, s := range srcs {
+		if !used[s] {
+			restSrcs = append(restSrcs, s)
+		}
+	}
+	for _, d := range dsts {
+		if _, ok := pairs[d]; !ok {
+			restDsts = append(restDsts, d)
+		}
+	}
+	if len(restSrcs) > 0 && len(restDsts) > 0 && len(restSrcs)*len(restDsts) <= renameLimit*renameLimit {
+		sigs := make(map[string]*signature)
+		load := func(hash string) (*signature, error) {
+			if sig, ok := sigs[hash]; ok {
+				return sig, nil
+			}
+			obj, err := object.Read(gitDir, hash)
+			if err != nil {
+				return nil, err
+			}
+			sig := newSignature(obj.Body)
+			sigs[hash] = sig
+			return sig, nil
+		}
+
+		type candidate struct{ src, dst, score int }
+		var candidates []candidate
+		for _, d := range restDsts {
+			dsig, err := load(changes[d].NewHash)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range restSrcs {
+				if !compatible(s, d) {
+					continue
+				}
+				ssig, err := load(changes[s].OldHash)
+				if err != nil {
+					return nil, err
+				}
+				if score := similarity(ssig, dsig, threshold); score >= threshold {
+					candidates = append(candidates, candidate{s, d, score})
+				}
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+		for _, c := range candidates {
+			if _, ok := pairs[c.dst]; ok || used[c.src] {
+				continue
+			}
+			pairs[c.dst] = match{c.src, c.score}
+			used[c.src] = true
+		}
+	}
+
+	out := make([]Change, 0, len(changes)-len(pairs))
+	for i, c := range changes {
+		if used[i] {
+			continue
+		}
+		if m, ok := pairs[i]; ok {
+			src := changes[m.src]
+			c = Change{
+				Kind:    Renamed,
+				OldPath: src.OldPath, NewPath: c.NewPath,
+				OldMode: src.OldMode, NewMode: c.NewMode,
+				OldHash: src.OldHash, NewHash: c.NewHash,
+				Similarity: m.score,
+			}
+		}
+		out = append(out, c)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Path() < out[j].Path() })
+	return out, nil
+}
+
+// renamable reports whether a file can take part in a rename: a blob,
+// and not the empty one, which would match every other empty file.
+func renamable(mode uint32, hash string) bool {
+	return mode != object.ModeGitlink && hash != emptyBlob
+}
+
+// emptyBlob is the SHA-1 of the empty blob.
+const emptyBlob = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+
+// maxChunk is the longest piece a line is cut into for scoring, so one
+// long line doesn't count as a single unit.
+const maxChunk = 64
+
+// signature summarizes a blob for similarity scoring: how many bytes of
+// it fall in each distinct chunk, a chunk being a line or a piece of a
+// long one.
+type signature struct {
+	size   int
+	chunks map[uint64]int
+}
+
+func newSignature(data []byte) *signature {
+	sig := &signature{size: len(data), chunks: make(map[uint64]int)}
+	for start := 0; start < len(data); {
+		end := start
+		for end < len(data) && end-start < maxChunk {
+			end++
+			if data[end-1] == '\n' {
+				break
+			}
+		}
+		h := fnv.New64a()
+		h.Write(data[start:end])
+		sig.chunks[h.Sum64()] += end - start
+		start = end
+	}
+	return sig
+}
+
+// similarity returns the percentage of the larger blob's bytes that the
+// two share, the way git scores renames. Pairs whose sizes alone rule
+// out reaching threshold return 0 without comparing chunks.
+func similarity(a, b *signature, threshold int) int {
+	larger, smaller := max(a.size, b.size), min(a.size, b.size)
+	if larger == 0 || smaller*100 < threshold*larger {
+		return 0
+	}
+	shared := 0
+	for h, n := range a.chunks {
+		shared += min(n, b.chunks[h])
+	}
+	return shared * 100 / larger
+}
@@ -0,0 +1,114 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func renameSummary(changes []Change) []string {
+	var out []string
+	for _, c := range changes {
+		s := c.Kind.Status() + " " + c.Path()
+		if c.Kind == Renamed {
+			s = c.Kind.Status() + " " + c.OldPath + " -> " + c.NewPath
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestDetectRenames(t *testing.T) {
+	gitDir := testGitDir(t)
+	body := numbered(1, 20)
+	a := writeTree(t, gitDir, map[string]string{
+		"old/exact.txt": "exact content\n",
+		"near.txt":      body,
+		"far.txt":       numbered(100, 120),
+		"empty":         "",
+	})
+	b := writeTree(t, gitDir, map[string]string{
+		"new/exact.txt": "exact content\n",
+		"near2.txt":     body + "21\n",
+		"other.txt":     "nothing alike\n",
+		"empty2":        "",
+	})
+	changes, err := DiffTrees(gitDir, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DetectRenames(gitDir, changes, DefaultRenameThreshold)
+	if err != nil {
+		t.Fatalf("DetectRenames() error: %v", err)
+	}
+	want := []string{
+		"D empty", "A empty2", "D far.txt",
+		"R near.txt -> near2.txt", "R old/exact.txt -> new/exact.txt", "A other.txt",
+	}
+	if s := renameSummary(got); !reflect.DeepEqual(s, want) {
+		t.Errorf("DetectRenames() =\n%v\nwant\n%v", s, want)
+	}
+	for _, c := range got {
+		switch c.NewPath {
+		case "new/exact.txt":
+			if c.Similarity != 100 || c.OldHash != c.NewHash {
+				t.Errorf("exact rename = %+v", c)
+			}
+		case "near2.txt":
+			// 51 of the 54 bytes of the new file are shared.
+			if c.Similarity != 94 {
+				t.Errorf("near rename similarity = %d, want 94", c.Similarity)
+			}
+		}
+	}
+
+	// Above the pair's similarity, only the exact rename remains.
+	got, err = DetectRenames(gitDir, changes, 95)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range got {
+		if c.Kind == Renamed && c.NewPath == "near2.txt" {
+			t.Errorf("94%% similar pair renamed at a 95%% threshold")
+		}
+	}
+}
+
+func TestDetectRenames_PrefersSameName(t *testing.T) {
+	gitDir := testGitDir(t)
+	a := writeTree(t, gitDir, map[string]string{"a/x.go": "same\n", "a/y.go": "same\n"})
+	b := writeTree(t, gitDir, map[string]string{"b/y.go": "same\n", "b/z.go": "same\n"})
+	changes, err := DiffTrees(gitDir, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DetectRenames(gitDir, changes, DefaultRenameThreshold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"R a/y.go -> b/y.go", "R a/x.go -> b/z.go"}
+	if s := renameSummary(got); !reflect.DeepEqual(s, want) {
+		t.Errorf("DetectRenames() = %v, want %v", s, want)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	long := strings.Repeat("x", 200) + "\n"
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"a\nb\nc\nd\n", "a\nb\nc\nd\n", 100},
+		{"a\nb\nc\nd\n", "a\nb\n", 0}, // half the size can't reach 60%
+		{"a\nb\nc\nd\n", "a\nb\nc\nX\n", 75},
+		// A long line is scored in pieces, so changing its end keeps
+		// most of it.
+		{long, strings.Repeat("x", 199) + "y\n", 95},
+	}
+	for _, tt := range tests {
+		if got := similarity(newSignature([]byte(tt.a)), newSignature([]byte(tt.b)), 60); got != tt.want {
+			t.Errorf("similarity(%.10q, %.10q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
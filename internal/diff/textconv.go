@@ -0,0 +1,83 @@
+package diff
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+
+	"github.com/elliota43/rev/internal/attr"
+	"github.com/elliota43/rev/internal/repository"
+)
+
+// Textconv runs the textconv commands of the diff drivers .gitattributes
+// assigns to paths with diff=<driver>. diff.<driver>.textconv names a
+// command that turns a file, such as an image or a document, into text
+// worth diffing. Each blob is converted at most once per driver. A nil
+// *Textconv converts nothing.
+type Textconv struct {
+	workTree string
+	attrs    *attr.Matcher
+	cfg      *repository.Config
+	// cache holds converted blobs by driver and hash.
+	cache map[[2]string][]byte
+}
+
+// LoadTextconv reads the attributes and configuration of the repository
+// at gitDir, whose working tree is workTree, or "" if it is bare.
+func LoadTextconv(gitDir, workTree string) (*Textconv, error) {
+	attrs, err := attr.Load(gitDir, workTree)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := repository.ParseConfig(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Textconv{workTree: workTree, attrs: attrs, cfg: cfg, cache: make(map[[2]string][]byte)}, nil
+}
+
+// Convert returns data, the blob hash as found at p, converted by the
+// textconv command of p's diff driver, and whether p has one. As in git,
+// the command is run by the shell with the path of a temporary file
+// holding data as its argument, and what it prints is the text.
+func (t *Textconv) Convert(p, hash string, data []byte) ([]byte, bool, error) {
+	if t == nil {
+		return data, false, nil
+	}
+	driver := t.attrs.Get(p, "diff")
+	if driver == "" || driver == attr.Set || driver == attr.Unset {
+		return data, false, nil
+	}
+	command, ok := t.cfg.Get("diff."+driver, "textconv")
+	if !ok || command == "" {
+		return data, false, nil
+	}
+	key := [2]string{driver, hash}
+	if text, ok := t.cache[key]; ok {
+		return text, true, nil
+	}
+
+	f, err := os.CreateTemp("", "*_"+path.Base(p))
+	if err != nil {
+		return nil, false, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	cmd := exec.Command("sh", "-c", command+` "$@"`, command, f.Name())
+	cmd.Dir = t.workTree
+	cmd.Stderr = os.Stderr
+	text, err := cmd.Output()
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: textconv '%s' failed: %w", p, command, err)
+	}
+	t.cache[key] = text
+	return text, true, nil
+}
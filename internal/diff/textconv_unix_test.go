@@ -0,0 +1,61 @@
+//go:build unix
+
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elliota43/rev/internal/repository"
+)
+
+func TestTextconv(t *testing.T) {
+	repo, err := repository.Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The up driver upper-cases its file and counts its runs in a log.
+	log := filepath.Join(t.TempDir(), "runs")
+	config := "[diff \"up\"]\n\ttextconv = \"echo run >>" + log + "; tr a-z A-Z <\"\n" +
+		"[diff \"broken\"]\n\ttextconv = false\n"
+	if err := os.WriteFile(filepath.Join(repo.GitDir, "config"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	attrs := "*.up diff=up\n*.none diff=none\n*.bad diff=broken\n"
+	if err := os.WriteFile(filepath.Join(repo.Path, ".gitattributes"), []byte(attrs), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tc, err := LoadTextconv(repo.GitDir, repo.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, want string
+		converted  bool
+	}{
+		{"a.up", "HELLO\n", true},
+		{"dir/b.up", "HELLO\n", true},
+		{"a.none", "hello\n", false},
+		{"plain", "hello\n", false},
+	}
+	for _, tt := range tests {
+		got, converted, err := tc.Convert(tt.path, "abc123", []byte("hello\n"))
+		if err != nil || string(got) != tt.want || converted != tt.converted {
+			t.Errorf("Convert(%s) = %q, %v, %v, want %q, %v", tt.path, got, converted, err, tt.want, tt.converted)
+		}
+	}
+	// Both .up paths had the same blob, so the command ran once.
+	if runs, _ := os.ReadFile(log); strings.Count(string(runs), "run") != 1 {
+		t.Errorf("textconv ran %d times, want 1", strings.Count(string(runs), "run"))
+	}
+
+	if _, _, err := tc.Convert("x.bad", "abc123", []byte("hello\n")); err == nil {
+		t.Error("Convert() with a failing command: want error")
+	}
+	if got, converted, err := (*Textconv)(nil).Convert("a.up", "abc123", []byte("hello\n")); string(got) != "hello\n" || converted || err != nil {
+		t.Errorf("nil Convert() = %q, %v, %v", got, converted, err)
+	}
+}
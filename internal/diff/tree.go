@@ -0,0 +1,135 @@
+package diff
+
+import (
+	"sort"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// ChangeKind says how a path differs between two trees.
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Deleted
+	Modified
+	// Renamed is a file deleted from one path and added at another with
+	// similar content, found by DetectRenames.
+	Renamed
+)
+
+// Status returns the letter git's raw diff output uses for the kind.
+func (k ChangeKind) Status() string {
+	return [...]string{Added: "A", Deleted: "D", Modified: "M", Renamed: "R"}[k]
+}
+
+// Change is one file that differs between two trees. The old side's
+// fields are zero for an added file, and the new side's for a deleted
+// one.
+type Change struct {
+	Kind             ChangeKind
+	OldPath, NewPath string
+	OldMode, NewMode uint32
+	OldHash, NewHash string
+	// Similarity is how alike a renamed file's old and new content are,
+	// in percent.
+	Similarity int
+}
+
+// Path returns the path the change is listed under: the new path, or the
+// old one for a deletion.
+func (c Change) Path() string {
+	if c.Kind == Deleted {
+		return c.OldPath
+	}
+	return c.NewPath
+}
+
+// DiffTrees returns the files that differ between the trees treeA and
+// treeB, sorted by path. Either may be "" for an empty tree. Subtrees
+// with the same hash on both sides are skipped without being read.
+func DiffTrees(gitDir, treeA, treeB string) ([]Change, error) {
+	var changes []Change
+	if err := diffTrees(gitDir, treeA, treeB, "", &changes); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path() < changes[j].Path() })
+	return changes, nil
+}
+
+func diffTrees(gitDir, a, b, prefix string, changes *[]Change) error {
+	if a == b {
+		return nil
+	}
+	oldEntries, err := readTree(gitDir, a)
+	if err != nil {
+		return err
+	}
+	newEntries, err := readTree(gitDir, b)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for name := range oldEntries {
+		names = append(names, name)
+	}
+	for name := range newEntries {
+		if _, ok := oldEntries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		o, inOld := oldEntries[name]
+		n, inNew := newEntries[name]
+		p := prefix + name
+		if inOld && inNew && o.Mode == n.Mode && o.Hash == n.Hash {
+			continue
+		}
+
+		// Subtrees are walked for their files. A tree on one side only,
+		// or facing a file, is all additions or all deletions.
+		oldTree, newTree := "", ""
+		if inOld && o.Mode == object.ModeTree {
+			oldTree = o.Hash
+		}
+		if inNew && n.Mode == object.ModeTree {
+			newTree = n.Hash
+		}
+		if oldTree != "" || newTree != "" {
+			if err := diffTrees(gitDir, oldTree, newTree, p+"/", changes); err != nil {
+				return err
+			}
+		}
+
+		oldFile := inOld && oldTree == ""
+		newFile := inNew && newTree == ""
+		switch {
+		case oldFile && newFile:
+			*changes = append(*changes, Change{Kind: Modified, OldPath: p, NewPath: p, OldMode: o.Mode, NewMode: n.Mode, OldHash: o.Hash, NewHash: n.Hash})
+		case oldFile:
+			*changes = append(*changes, Change{Kind: Deleted, OldPath: p, OldMode: o.Mode, OldHash: o.Hash})
+		case newFile:
+			*changes = append(*changes, Change{Kind: Added, NewPath: p, NewMode: n.Mode, NewHash: n.Hash})
+		}
+	}
+	return nil
+}
+
+// readTree returns the entries of tree by name, or none for "".
+func readTree(gitDir, tree string) (map[string]object.TreeEntry, error) {
+	entries := make(map[string]object.TreeEntry)
+	if tree == "" {
+		return entries, nil
+	}
+	list, err := object.ReadTree(gitDir, tree)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range list {
+		entries[e.Name] = e
+	}
+	return entries, nil
+}
@@ -0,0 +1,158 @@
+package diff
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// writeTree stores a tree built from files, a map of slash-separated path
+// to blob content, and returns its hash.
+func writeTree(t *testing.T, gitDir string, files map[string]string) string {
+	t.Helper()
+	dirs := make(map[string][]object.TreeEntry)
+	for p, content := range files {
+		sha, err := object.WriteObject(gitDir, object.TypeBlob, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir, name := "", p
+		if i := strings.LastIndex(p, "/"); i >= 0 {
+			dir, name = p[:i], p[i+1:]
+		}
+		dirs[dir] = append(dirs[dir], object.TreeEntry{Mode: object.ModeFile, Name: name, Hash: sha})
+		// Make sure every ancestor directory gets a tree.
+		for dir != "" {
+			parent := ""
+			if i := strings.LastIndex(dir, "/"); i >= 0 {
+				parent = dir[:i]
+			}
+			if _, ok := dirs[parent]; !ok {
+				dirs[parent] = nil
+			}
+			dir = parent
+		}
+	}
+	return writeDir(t, gitDir, dirs, "")
+}
+
+func writeDir(t *testing.T, gitDir string, dirs map[string][]object.TreeEntry, dir string) string {
+	t.Helper()
+	entries := append([]object.TreeEntry(nil), dirs[dir]...)
+	for sub := range dirs {
+		parent, name := "", sub
+		if i := strings.LastIndex(sub, "/"); i >= 0 {
+			parent, name = sub[:i], sub[i+1:]
+		}
+		if sub != "" && parent == dir {
+			entries = append(entries, object.TreeEntry{Mode: object.ModeTree, Name: name, Hash: writeDir(t, gitDir, dirs, sub)})
+		}
+	}
+	body, err := object.EncodeTree(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha, err := object.WriteObject(gitDir, object.TypeTree, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+func testGitDir(t *testing.T) string {
+	t.Helper()
+	gitDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(gitDir, "objects"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return gitDir
+}
+
+func summary(changes []Change) []string {
+	var out []string
+	for _, c := range changes {
+		out = append(out, fmt.Sprintf("%s %s", c.Kind.Status(), c.Path()))
+	}
+	return out
+}
+
+func TestDiffTrees(t *testing.T) {
+	gitDir := testGitDir(t)
+	a := writeTree(t, gitDir, map[string]string{
+		"same.txt":     "same\n",
+		"edit.txt":     "old\n",
+		"gone.txt":     "gone\n",
+		"lib/keep.go":  "keep\n",
+		"lib/sub/x.go": "x\n",
+		"swap":         "file becomes a directory\n",
+		"dir/f":        "directory becomes a file\n",
+	})
+	b := writeTree(t, gitDir, map[string]string{
+		"same.txt":     "same\n",
+		"edit.txt":     "new\n",
+		"new.txt":      "new\n",
+		"lib/keep.go":  "keep\n",
+		"lib/sub/x.go": "x changed\n",
+		"swap/inner":   "inner\n",
+		"dir":          "now a file\n",
+	})
+
+	changes, err := DiffTrees(gitDir, a, b)
+	if err != nil {
+		t.Fatalf("DiffTrees() error: %v", err)
+	}
+	want := []string{"A dir", "D dir/f", "M edit.txt", "D gone.txt", "M lib/sub/x.go", "A new.txt", "D swap", "A swap/inner"}
+	if got := summary(changes); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffTrees() =\n%v\nwant\n%v", got, want)
+	}
+	for _, c := range changes {
+		if c.Path() == "edit.txt" && (c.OldHash == "" || c.NewHash == "" || c.OldMode != object.ModeFile) {
+			t.Errorf("modified entry missing a side: %+v", c)
+		}
+	}
+
+	if changes, err := DiffTrees(gitDir, "", a); err != nil || len(changes) != 7 {
+		t.Errorf("DiffTrees(empty, a) = %d changes, %v; want 7 additions", len(changes), err)
+	}
+	if changes, err := DiffTrees(gitDir, a, a); err != nil || len(changes) != 0 {
+		t.Errorf("DiffTrees(a, a) = %v, %v; want nothing", changes, err)
+	}
+}
+
+func TestDiffTrees_SkipsSameSubtree(t *testing.T) {
+	gitDir := testGitDir(t)
+	// Both trees point at a subtree that isn't in the store, so reading
+	// it would fail.
+	missing := strings.Repeat("ab", 20)
+	tree := func(content string) string {
+		blob, err := object.WriteObject(gitDir, object.TypeBlob, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := object.EncodeTree([]object.TreeEntry{
+			{Mode: object.ModeFile, Name: "f", Hash: blob},
+			{Mode: object.ModeTree, Name: "big", Hash: missing},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sha, err := object.WriteObject(gitDir, object.TypeTree, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha
+	}
+
+	changes, err := DiffTrees(gitDir, tree("1\n"), tree("2\n"))
+	if err != nil {
+		t.Fatalf("DiffTrees() descended into an unchanged subtree: %v", err)
+	}
+	if got := summary(changes); !reflect.DeepEqual(got, []string{"M f"}) {
+		t.Errorf("DiffTrees() = %v, want [M f]", got)
+	}
+}
@@ -0,0 +1,146 @@
+package diff
+
+import (
+	"regexp"
+	"strings"
+)
+
+// WordSegment is one piece of a word diff: text both sides share (Equal,
+// taken from the new side, with its spacing), or a run of words only the
+// old side (Delete) or the new side (Insert) has.
+type WordSegment struct {
+	Op   Op
+	Text string
+}
+
+// DiffWords compares old and new, the removed and added lines of one run
+// of changes in a hunk, word by word, as git diff --word-diff does. A
+// word is a run of non-whitespace, or, if wordRegex is not nil, each
+// non-empty match of it, cut short at a newline. Concatenating the Equal
+// and Insert segments gives back new.
+func DiffWords(old, new string, wordRegex *regexp.Regexp) []WordSegment {
+	// Everything removed and nothing added is shown as one deletion,
+	// spacing and all.
+	if new == "" {
+		if old == "" {
+			return nil
+		}
+		return []WordSegment{{Delete, old}}
+	}
+
+	oldWords, newWords := splitWords(old, wordRegex), splitWords(new, wordRegex)
+	ids := make(map[string]int)
+	number := func(text string, words []span) []int {
+		out := make([]int, len(words))
+		for i, w := range words {
+			id, ok := ids[text[w.start:w.end]]
+			if !ok {
+				id = len(ids)
+				ids[text[w.start:w.end]] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	oldIDs, newIDs := number(old, oldWords), number(new, newWords)
+	ops := compact(editScript(oldIDs, newIDs), oldIDs, newIDs)
+
+	var segs []WordSegment
+	add := func(op Op, text string) {
+		if text != "" {
+			segs = append(segs, WordSegment{op, text})
+		}
+	}
+	// cur is how much of new has been written out.
+	var i, j, cur int
+	for k := 0; k < len(ops); {
+		if ops[k] == Equal {
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+		firstOld, firstNew := i, j
+		for ; k < len(ops) && ops[k] != Equal; k++ {
+			if ops[k] == Delete {
+				i++
+			} else {
+				j++
+			}
+		}
+
+		// With no words added, the change sits just after the word
+		// before it.
+		begin := 0
+		if firstNew > 0 {
+			begin = newWords[firstNew-1].end
+		}
+		end := begin
+		if j > firstNew {
+			begin, end = newWords[firstNew].start, newWords[j-1].end
+		}
+		add(Equal, new[cur:begin])
+		if i > firstOld {
+			add(Delete, old[oldWords[firstOld].start:oldWords[i-1].end])
+		}
+		add(Insert, new[begin:end])
+		cur = end
+	}
+	add(Equal, new[cur:])
+	return segs
+}
+
+// span is the position of a word in its text.
+type span struct{ start, end int }
+
+// splitWords finds the words of text the way git does.
+func splitWords(text string, wordRegex *regexp.Regexp) []span {
+	var words []span
+	for i := 0; i < len(text); {
+		if wordRegex != nil {
+			loc := wordRegex.FindStringIndex(text[i:])
+			if loc == nil {
+				break
+			}
+			start, end := i+loc[0], i+loc[1]
+			if nl := strings.IndexByte(text[start:end], '\n'); nl >= 0 {
+				end = start + nl
+			}
+			if start == end {
+				i = start + 1
+				continue
+			}
+			words = append(words, span{start, end})
+			i = end
+			continue
+		}
+
+		for i < len(text) && isSpace(text[i]) {
+			i++
+		}
+		if i == len(text) {
+			break
+		}
+		start := i
+		for i < len(text) && !isSpace(text[i]) {
+			i++
+		}
+		words = append(words, span{start, i})
+	}
+	return words
+}
+
+// isSpace is git's isspace, which leaves out \v and \f.
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// CompileWordRegex compiles a --word-diff-regex pattern. Like git's POSIX
+// matching, it finds the leftmost-longest match, and ^ and $ match at
+// line boundaries.
+func CompileWordRegex(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile("(?m)" + pattern)
+	if err != nil {
+		return nil, err
+	}
+	re.Longest()
+	return re, nil
+}
@@ -0,0 +1,67 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+// renderWords formats segments the way --word-diff=plain marks them.
+func renderWords(segs []WordSegment) string {
+	var b strings.Builder
+	for _, s := range segs {
+		switch s.Op {
+		case Delete:
+			b.WriteString("[-" + s.Text + "-]")
+		case Insert:
+			b.WriteString("{+" + s.Text + "+}")
+		default:
+			b.WriteString(s.Text)
+		}
+	}
+	return b.String()
+}
+
+func TestDiffWords(t *testing.T) {
+	// The expected results are git diff --word-diff's.
+	tests := []struct {
+		name, old, new, regex, want string
+	}{
+		{"changed words", "hello world foo\n", "hello there foo bar\n", "", "hello [-world-]{+there+} foo {+bar+}\n"},
+		{"deleted word", "second line here\n", "second  line\n", "", "second  line[-here-]\n"},
+		{"whitespace only", "a b\n", "a  b\n", "", "a  b\n"},
+		{"added lines", "", "new line\n", "", "{+new line+}\n"},
+		{"removed lines", "only\n", "", "", "[-only\n-]"},
+		{"regex", "hello world foo\n", "hello there foo bar\n", ".", "hello [-wo-]{+the+}r[-ld-]{+e+} foo{+ bar+}\n"},
+		{"regex words", "a.b(c)\n", "a.x(c)\n", "[a-z]+|[^[:space:]]", "a.[-b-]{+x+}(c)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var segs []WordSegment
+			if tt.regex != "" {
+				re, err := CompileWordRegex(tt.regex)
+				if err != nil {
+					t.Fatal(err)
+				}
+				segs = DiffWords(tt.old, tt.new, re)
+			} else {
+				segs = DiffWords(tt.old, tt.new, nil)
+			}
+			if got := renderWords(segs); got != tt.want {
+				t.Errorf("DiffWords() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffWords_KeepsNewText(t *testing.T) {
+	old, new := "one two\nthree four\n", "two one\n\tthree  five\nsix\n"
+	var b strings.Builder
+	for _, s := range DiffWords(old, new, nil) {
+		if s.Op != Delete {
+			b.WriteString(s.Text)
+		}
+	}
+	if b.String() != new {
+		t.Errorf("Equal and Insert segments give %q, want %q", b.String(), new)
+	}
+}
@@ -0,0 +1,272 @@
+package fastimport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/elliota43/rev/internal/diff"
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/repository"
+)
+
+// Exporter writes history as a fast-import stream that an Importer, or
+// git fast-import, turns back into the same objects and refs.
+type Exporter struct {
+	repo *repository.Repository
+
+	// Marks maps marks to the objects they were given. Objects already
+	// marked when Export starts, as loaded with ReadMarks from an earlier
+	// export, are taken to be in the importing repository and aren't
+	// written again; afterwards it holds every mark written.
+	Marks map[int]string
+
+	w      *bufio.Writer
+	marked map[string]int
+	last   int
+	// onRef records the ref each commit was written on.
+	onRef map[string]string
+}
+
+// NewExporter returns an exporter reading from repo.
+func NewExporter(repo *repository.Repository) *Exporter {
+	return &Exporter{repo: repo, Marks: make(map[int]string)}
+}
+
+// Export writes the history reachable from refs, full ref names, to w.
+// Commits come parents first, each after the blobs it introduces, with
+// its changes against its first parent as file commands; each ref's
+// commits are written on that ref, and refs that end up on a commit
+// written for another ref get a reset. Annotated tags of commits become
+// tag commands.
+func (ex *Exporter) Export(w io.Writer, refs []string) error {
+	ex.w = bufio.NewWriter(w)
+	ex.marked = make(map[string]int)
+	ex.onRef = make(map[string]string)
+	for mark, hash := range ex.Marks {
+		ex.marked[hash] = mark
+		ex.last = max(ex.last, mark)
+	}
+
+	type tagRef struct{ ref, hash, commit string }
+	var tags []tagRef
+	var resets [][2]string
+	for _, ref := range refs {
+		hash, err := repository.ResolveRef(ex.repo.GitDir, ref)
+		if err != nil {
+			return err
+		}
+		commit, err := repository.Peel(ex.repo.GitDir, hash, object.TypeCommit)
+		if err != nil {
+			return fmt.Errorf("%s: %w", ref, err)
+		}
+		if err := ex.exportHistory(ref, commit); err != nil {
+			return err
+		}
+		if hash != commit {
+			tags = append(tags, tagRef{ref, hash, commit})
+		} else if ex.onRef[commit] != ref {
+			resets = append(resets, [2]string{ref, commit})
+		}
+	}
+
+	for _, r := range resets {
+		fmt.Fprintf(ex.w, "reset %s\nfrom :%d\n\n", r[0], ex.marked[r[1]])
+	}
+	for _, t := range tags {
+		if err := ex.exportTag(t.ref, t.hash, t.commit); err != nil {
+			return err
+		}
+	}
+	return ex.w.Flush()
+}
+
+// exportHistory writes tip and every ancestor not yet written, parents
+// before children, on ref.
+func (ex *Exporter) exportHistory(ref, tip string) error {
+	type frame struct {
+		commit *object.Commit
+		next   int
+	}
+	visiting := make(map[string]bool)
+	var stack []*frame
+	push := func(hash string) error {
+		if _, done := ex.marked[hash]; done || visiting[hash] {
+			return nil
+		}
+		obj, err := object.Read(ex.repo.GitDir, hash)
+		if err != nil {
+			return err
+		}
+		c, err := object.ParseCommit(obj)
+		if err != nil {
+			return err
+		}
+		visiting[hash] = true
+		stack = append(stack, &frame{commit: c})
+		return nil
+	}
+
+	if err := push(tip); err != nil {
+		return err
+	}
+	// The walk keeps its own stack, so long histories can't overflow
+	// the goroutine's.
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		if f.next < len(f.commit.Parents) {
+			f.next++
+			if err := push(f.commit.Parents[f.next-1]); err != nil {
+				return err
+			}
+			continue
+		}
+		stack = stack[:len(stack)-1]
+		if err := ex.exportCommit(ref, f.commit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mark gives hash the next mark.
+func (ex *Exporter) mark(hash string) int {
+	ex.last++
+	ex.marked[hash] = ex.last
+	ex.Marks[ex.last] = hash
+	return ex.last
+}
+
+func (ex *Exporter) exportCommit(ref string, c *object.Commit) error {
+	var parentTree string
+	if len(c.Parents) > 0 {
+		var err error
+		if parentTree, err = repository.Peel(ex.repo.GitDir, c.Parents[0], object.TypeTree); err != nil {
+			return err
+		}
+	}
+	changes, err := diff.DiffTrees(ex.repo.GitDir, parentTree, c.Tree)
+	if err != nil {
+		return err
+	}
+
+	for _, ch := range changes {
+		if ch.Kind == diff.Deleted || ch.NewMode == object.ModeGitlink {
+			continue
+		}
+		if _, done := ex.marked[ch.NewHash]; done {
+			continue
+		}
+		obj, err := object.Read(ex.repo.GitDir, ch.NewHash)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(ex.w, "blob\nmark :%d\n", ex.mark(ch.NewHash))
+		writeData(ex.w, obj.Body)
+		ex.w.WriteString("\n")
+	}
+
+	if len(c.Parents) == 0 {
+		fmt.Fprintf(ex.w, "reset %s\n", ref)
+	}
+	fmt.Fprintf(ex.w, "commit %s\nmark :%d\n", ref, ex.mark(c.Hash))
+	ex.onRef[c.Hash] = ref
+	fmt.Fprintf(ex.w, "author %s\ncommitter %s\n", c.Author, c.Committer)
+	for _, h := range c.Extra {
+		if h.Key == "encoding" {
+			fmt.Fprintf(ex.w, "encoding %s\n", h.Value)
+		}
+	}
+	writeData(ex.w, []byte(c.Message))
+	for i, p := range c.Parents {
+		cmd := "merge"
+		if i == 0 {
+			cmd = "from"
+		}
+		fmt.Fprintf(ex.w, "%s :%d\n", cmd, ex.marked[p])
+	}
+	for _, ch := range changes {
+		if ch.Kind == diff.Deleted {
+			fmt.Fprintf(ex.w, "D %s\n", quotePath(ch.OldPath))
+			continue
+		}
+		ref := ch.NewHash
+		if mark, ok := ex.marked[ref]; ok && ch.NewMode != object.ModeGitlink {
+			ref = fmt.Sprintf(":%d", mark)
+		}
+		fmt.Fprintf(ex.w, "M %06o %s %s\n", ch.NewMode, ref, quotePath(ch.NewPath))
+	}
+	ex.w.WriteString("\n")
+	return nil
+}
+
+func (ex *Exporter) exportTag(ref, hash, commit string) error {
+	obj, err := object.Read(ex.repo.GitDir, hash)
+	if err != nil {
+		return err
+	}
+	tag, err := object.ParseTag(obj)
+	if err != nil {
+		return err
+	}
+	if tag.Object != commit {
+		return fmt.Errorf("%s: tags of tags and of non-commits can't be exported", ref)
+	}
+	if _, done := ex.marked[hash]; done {
+		return nil
+	}
+
+	fmt.Fprintf(ex.w, "tag %s\nmark :%d\nfrom :%d\n", strings.TrimPrefix(ref, "refs/tags/"), ex.mark(hash), ex.marked[commit])
+	if tag.Tagger != (object.Signature{}) {
+		fmt.Fprintf(ex.w, "tagger %s\n", tag.Tagger)
+	}
+	writeData(ex.w, []byte(tag.Message+tag.Sig))
+	ex.w.WriteString("\n")
+	return nil
+}
+
+func writeData(w *bufio.Writer, data []byte) {
+	fmt.Fprintf(w, "data %d\n", len(data))
+	w.Write(data)
+}
+
+// quotePath quotes p the way git does in a fast-import stream: in C
+// style if it has control characters, quotes, backslashes, or non-ASCII
+// bytes, and in plain quotes if it only has spaces.
+func quotePath(p string) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\a':
+			b.WriteString(`\a`)
+		case c == '\b':
+			b.WriteString(`\b`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\v':
+			b.WriteString(`\v`)
+		case c == '\f':
+			b.WriteString(`\f`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, `\%03o`, c)
+		default:
+			b.WriteByte(c)
+			continue
+		}
+		quoted = true
+	}
+	if quoted || strings.Contains(p, " ") {
+		return `"` + b.String() + `"`
+	}
+	return p
+}
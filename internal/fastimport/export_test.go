@@ -0,0 +1,161 @@
+package fastimport
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/elliota43/rev/internal/repository"
+)
+
+const historyStream = `commit refs/heads/main
+mark :1
+author A <a@x> 100 +0100
+committer C <c@x> 100 +0100
+data 4
+one
+M 644 inline a
+data 2
+a
+M 644 inline "with space"
+data 2
+s
+
+commit refs/heads/side
+mark :2
+committer C <c@x> 200 +0000
+data 4
+two
+from :1
+M 755 inline "tab\there"
+data 2
+t
+D a
+
+commit refs/heads/main
+mark :3
+committer C <c@x> 300 +0000
+encoding ISO-8859-1
+data 6
+merge
+from :1
+merge :2
+M 120000 inline link
+data 1
+a
+M 160000 1111111111111111111111111111111111111111 sub
+
+reset refs/heads/also-main
+from :3
+
+tag v1
+from :2
+tagger T <t@x> 400 +0000
+data 4
+tag
+`
+
+var historyRefs = []string{"refs/heads/also-main", "refs/heads/main", "refs/heads/side", "refs/tags/v1"}
+
+func listRefs(t *testing.T, repo *repository.Repository) string {
+	t.Helper()
+	refs, err := repository.ListRefs(repo.GitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	for _, r := range refs {
+		b.WriteString(r.Hash + " " + r.Name + "\n")
+	}
+	return b.String()
+}
+
+func TestExport_RoundTrip(t *testing.T) {
+	src := testRepo(t)
+	runImport(t, src, historyStream)
+
+	var stream bytes.Buffer
+	if err := NewExporter(src).Export(&stream, historyRefs); err != nil {
+		t.Fatalf("Export() error: %v", err)
+	}
+
+	dst := testRepo(t)
+	runImport(t, dst, stream.String())
+	if got, want := listRefs(t, dst), listRefs(t, src); got != want {
+		t.Errorf("refs after the round trip:\n%s\nwant:\n%s\nstream:\n%s", got, want, stream.String())
+	}
+}
+
+func TestExport_Stream(t *testing.T) {
+	repo := testRepo(t)
+	runImport(t, repo, "commit refs/heads/main\ncommitter C <c@x> 1 +0000\ndata 4\none\nM 644 inline a\ndata 2\na\n\n"+
+		"commit refs/heads/main\ncommitter C <c@x> 2 +0000\ndata 3\ntwo\nD a\nM 644 inline b\ndata 2\na\n")
+
+	var stream bytes.Buffer
+	if err := NewExporter(repo).Export(&stream, []string{"refs/heads/main"}); err != nil {
+		t.Fatal(err)
+	}
+	// The second commit's file has the first one's content, so its blob
+	// isn't written again.
+	want := "blob\nmark :1\ndata 2\na\n\n" +
+		"reset refs/heads/main\ncommit refs/heads/main\nmark :2\n" +
+		"author C <c@x> 1 +0000\ncommitter C <c@x> 1 +0000\ndata 4\none\nM 100644 :1 a\n\n" +
+		"commit refs/heads/main\nmark :3\n" +
+		"author C <c@x> 2 +0000\ncommitter C <c@x> 2 +0000\ndata 3\ntwofrom :2\nD a\nM 100644 :1 b\n\n"
+	if stream.String() != want {
+		t.Errorf("stream:\n%s\nwant:\n%s", stream.String(), want)
+	}
+}
+
+func TestExport_Incremental(t *testing.T) {
+	repo := testRepo(t)
+	runImport(t, repo, "commit refs/heads/main\ncommitter C <c@x> 1 +0000\ndata 0\nM 644 inline a\ndata 2\na\n")
+
+	first := NewExporter(repo)
+	var stream bytes.Buffer
+	if err := first.Export(&stream, []string{"refs/heads/main"}); err != nil {
+		t.Fatal(err)
+	}
+	dst := testRepo(t)
+	runImport(t, dst, stream.String())
+
+	runImport(t, repo, "commit refs/heads/main\ncommitter C <c@x> 2 +0000\ndata 0\nfrom refs/heads/main^0\nM 644 inline b\ndata 2\nb\n")
+	second := NewExporter(repo)
+	second.Marks = first.Marks
+	stream.Reset()
+	if err := second.Export(&stream, []string{"refs/heads/main"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(stream.String(), "commit ") != 1 || strings.Count(stream.String(), "blob\n") != 1 {
+		t.Errorf("the second export repeats history:\n%s", stream.String())
+	}
+
+	// The importer needs the same marks to resolve the new commit's from.
+	im := NewImporter(dst)
+	for mark, hash := range first.Marks {
+		im.Marks[mark] = hash
+	}
+	if err := im.Import(&stream); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listRefs(t, dst), listRefs(t, repo); got != want {
+		t.Errorf("refs after the incremental import:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestQuotePath(t *testing.T) {
+	tests := map[string]string{
+		"plain/path":  "plain/path",
+		"with space":  `"with space"`,
+		"tab\there":   `"tab\there"`,
+		`quo"te`:      `"quo\"te"`,
+		"café":        `"caf\303\251"`,
+		`back\slash`:  `"back\\slash"`,
+		"new\nline x": `"new\nline x"`,
+	}
+	for in, want := range tests {
+		if got := quotePath(in); got != want {
+			t.Errorf("quotePath(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
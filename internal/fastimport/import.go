@@ -0,0 +1,761 @@
+// Package fastimport reads and writes git's fast-import stream format,
+// the interchange format used to migrate history between version control
+// systems and to rewrite it in bulk.
+package fastimport
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elliota43/rev/internal/index"
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/repository"
+)
+
+// Stats counts what an import created.
+type Stats struct {
+	Blobs, Commits, Tags int
+}
+
+// Importer builds objects and refs from a fast-import stream. It handles
+// the blob, commit, tag, reset, progress, checkpoint, and done commands;
+// commits take from and merge lines and the M, D, C, R, and deleteall
+// file commands. Dates must be in git's raw format.
+type Importer struct {
+	repo *repository.Repository
+	// algo is the repository's object format, read when Import starts.
+	algo object.Algorithm
+
+	// Force updates refs even when the new tip doesn't contain the old
+	// one. Without it, such refs are left alone and Import fails once the
+	// rest of the stream has been applied.
+	Force bool
+	// Progress receives the text of progress commands, one per line. It
+	// may be nil.
+	Progress io.Writer
+	// Marks maps the marks the stream sets, and any the caller loads
+	// beforehand with ReadMarks, to the objects they name.
+	Marks map[int]string
+	Stats Stats
+
+	branches map[string]*branch
+	tags     map[string]string
+	r        *bufio.Reader
+	line     int
+	// pending holds a line read ahead and not yet handled.
+	pending *string
+}
+
+// branch is the state of one ref the stream has committed to or reset.
+type branch struct {
+	tip   string
+	files map[string]object.TreeEntry
+}
+
+// NewImporter returns an importer writing into repo.
+func NewImporter(repo *repository.Repository) *Importer {
+	return &Importer{
+		repo:     repo,
+		Marks:    make(map[int]string),
+		branches: make(map[string]*branch),
+		tags:     make(map[string]string),
+	}
+}
+
+// Import reads the stream from r, writing objects as it goes, then
+// updates the refs it named. A stream that uses `feature done` must end
+// with a done command.
+func (im *Importer) Import(r io.Reader) error {
+	var err error
+	if im.algo, err = repository.ObjectFormat(im.repo.GitDir); err != nil {
+		return err
+	}
+	im.r = bufio.NewReader(r)
+	im.line = 0
+	needDone := false
+	for {
+		line, ok, err := im.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if needDone {
+				return im.errorf("stream ends without a done command")
+			}
+			break
+		}
+
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch cmd {
+		case "blob":
+			err = im.parseBlob()
+		case "commit":
+			err = im.parseCommit(arg)
+		case "tag":
+			err = im.parseTag(arg)
+		case "reset":
+			err = im.parseReset(arg)
+		case "progress":
+			if im.Progress != nil {
+				fmt.Fprintf(im.Progress, "progress %s\n", arg)
+			}
+		case "checkpoint":
+		case "feature":
+			switch arg {
+			case "done":
+				needDone = true
+			case "date-format=raw":
+			default:
+				err = im.errorf("unsupported feature: %s", arg)
+			}
+		case "done":
+			return im.updateRefs()
+		default:
+			err = im.errorf("unsupported command: %s", line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return im.updateRefs()
+}
+
+// next returns the next line that isn't a comment, without its newline,
+// and false at the end of the stream.
+func (im *Importer) next() (string, bool, error) {
+	if im.pending != nil {
+		line := *im.pending
+		im.pending = nil
+		return line, true, nil
+	}
+	for {
+		line, err := im.r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return "", false, nil
+		}
+		if err != nil && err != io.EOF {
+			return "", false, err
+		}
+		im.line++
+		line = strings.TrimSuffix(line, "\n")
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Blank lines between commands are optional padding.
+		if line == "" {
+			continue
+		}
+		return line, true, nil
+	}
+}
+
+// unread puts line back to be returned by the next call to next.
+func (im *Importer) unread(line string) {
+	im.pending = &line
+}
+
+// optional returns the argument of the next line if it starts with
+// prefix, and otherwise leaves the line to be read again.
+func (im *Importer) optional(prefix string) (string, bool, error) {
+	line, ok, err := im.next()
+	if err != nil || !ok {
+		return "", false, err
+	}
+	if arg, found := strings.CutPrefix(line, prefix+" "); found {
+		return arg, true, nil
+	}
+	im.unread(line)
+	return "", false, nil
+}
+
+func (im *Importer) errorf(format string, args ...any) error {
+	return fmt.Errorf("fast-import: line %d: %s", im.line, fmt.Sprintf(format, args...))
+}
+
+// parseMark reads an optional "mark :<n>" line, and the original-oid
+// line that may follow it, and returns the mark or 0.
+func (im *Importer) parseMark() (int, error) {
+	arg, ok, err := im.optional("mark")
+	if err != nil || !ok {
+		return 0, err
+	}
+	mark, err := strconv.Atoi(strings.TrimPrefix(arg, ":"))
+	if err != nil || !strings.HasPrefix(arg, ":") || mark <= 0 {
+		return 0, im.errorf("invalid mark: %s", arg)
+	}
+	if _, _, err := im.optional("original-oid"); err != nil {
+		return 0, err
+	}
+	return mark, nil
+}
+
+// parseData reads a data command in either its counted ("data <n>") or
+// delimited ("data <<<delim>") form.
+func (im *Importer) parseData() ([]byte, error) {
+	line, ok, err := im.next()
+	if err != nil {
+		return nil, err
+	}
+	arg, found := strings.CutPrefix(line, "data ")
+	if !ok || !found {
+		return nil, im.errorf("expected data command, got %q", line)
+	}
+
+	if delim, ok := strings.CutPrefix(arg, "<<"); ok {
+		var data []byte
+		for {
+			l, err := im.r.ReadString('\n')
+			if err != nil {
+				return nil, im.errorf("data <<%s: missing terminator", delim)
+			}
+			im.line++
+			if strings.TrimSuffix(l, "\n") == delim {
+				return data, nil
+			}
+			data = append(data, l...)
+		}
+	}
+
+	n, err := strconv.ParseUint(arg, 10, 63)
+	if err != nil {
+		return nil, im.errorf("invalid data length: %s", arg)
+	}
+	if n > uint64(object.MaxObjectSize) {
+		return nil, im.errorf("data of %d bytes exceeds the object size limit", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(im.r, data); err != nil {
+		return nil, im.errorf("data: %v", err)
+	}
+	im.line += bytes.Count(data, []byte("\n"))
+	// The newline after the data is optional.
+	if b, err := im.r.ReadByte(); err == nil && b != '\n' {
+		im.r.UnreadByte()
+	}
+	return data, nil
+}
+
+func (im *Importer) setMark(mark int, hash string) {
+	if mark != 0 {
+		im.Marks[mark] = hash
+	}
+}
+
+func (im *Importer) parseBlob() error {
+	mark, err := im.parseMark()
+	if err != nil {
+		return err
+	}
+	data, err := im.parseData()
+	if err != nil {
+		return err
+	}
+	hash, err := object.WriteObjectWith(im.algo, im.repo.GitDir, object.TypeBlob, data)
+	if err != nil {
+		return err
+	}
+	im.Stats.Blobs++
+	im.setMark(mark, hash)
+	return nil
+}
+
+// resolve turns a from, merge, or tag target into an object hash: a
+// mark, a branch the stream has written, or a revision in the repository.
+// A trailing "^0" reads the ref as it is in the repository, as git uses
+// it to continue an incremental import.
+func (im *Importer) resolve(rev string) (string, error) {
+	if strings.HasPrefix(rev, ":") {
+		mark, err := strconv.Atoi(rev[1:])
+		hash, ok := im.Marks[mark]
+		if err != nil || !ok {
+			return "", im.errorf("mark not declared: %s", rev)
+		}
+		return hash, nil
+	}
+	if name, ok := strings.CutSuffix(rev, "^0"); ok {
+		rev = name
+	} else if b, ok := im.branches[rev]; ok && b.tip != "" {
+		return b.tip, nil
+	}
+	hash, err := repository.ResolveRef(im.repo.GitDir, rev)
+	if err != nil {
+		return "", im.errorf("%v", err)
+	}
+	return hash, nil
+}
+
+// resolveCommit is resolve for a from or merge, peeling tags to the
+// commit they name.
+func (im *Importer) resolveCommit(rev string) (string, error) {
+	hash, err := im.resolve(rev)
+	if err != nil {
+		return "", err
+	}
+	if hash, err = repository.Peel(im.repo.GitDir, hash, object.TypeCommit); err != nil {
+		return "", im.errorf("%s: %v", rev, err)
+	}
+	return hash, nil
+}
+
+// startFrom points b at the commit rev and loads that commit's tree.
+func (im *Importer) startFrom(b *branch, rev string) error {
+	b.files = make(map[string]object.TreeEntry)
+	// The null hash, as a from, starts a branch over with no history.
+	if rev == strings.Repeat("0", im.algo.HexLen()) {
+		b.tip = ""
+		return nil
+	}
+	hash, err := im.resolveCommit(rev)
+	if err != nil {
+		return err
+	}
+	tree, err := repository.Peel(im.repo.GitDir, hash, object.TypeTree)
+	if err != nil {
+		return err
+	}
+	if err := im.loadTree(b, tree, ""); err != nil {
+		return err
+	}
+	b.tip = hash
+	return nil
+}
+
+// loadTree adds the files of tree to b under prefix ("" or ending in
+// "/").
+func (im *Importer) loadTree(b *branch, tree, prefix string) error {
+	entries, err := object.FlattenTree(im.repo.GitDir, tree)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		e.Name = prefix + e.Name
+		b.files[e.Name] = e
+	}
+	return nil
+}
+
+func (im *Importer) branch(ref string) (*branch, error) {
+	if err := repository.CheckRefName(ref); err != nil {
+		return nil, im.errorf("%v", err)
+	}
+	b, ok := im.branches[ref]
+	if !ok {
+		b = &branch{files: make(map[string]object.TreeEntry)}
+		im.branches[ref] = b
+	}
+	return b, nil
+}
+
+func (im *Importer) parseReset(ref string) error {
+	b, err := im.branch(ref)
+	if err != nil {
+		return err
+	}
+	from, ok, err := im.optional("from")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		b.tip = ""
+		b.files = make(map[string]object.TreeEntry)
+		return nil
+	}
+	return im.startFrom(b, from)
+}
+
+func (im *Importer) parseCommit(ref string) error {
+	b, err := im.branch(ref)
+	if err != nil {
+		return err
+	}
+	mark, err := im.parseMark()
+	if err != nil {
+		return err
+	}
+
+	c := &object.Commit{}
+	var haveAuthor bool
+	if arg, ok, err := im.optional("author"); err != nil {
+		return err
+	} else if ok {
+		if c.Author, err = object.ParseSignature(arg); err != nil {
+			return im.errorf("author: %v", err)
+		}
+		haveAuthor = true
+	}
+	arg, ok, err := im.optional("committer")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return im.errorf("commit %s: missing committer", ref)
+	}
+	if c.Committer, err = object.ParseSignature(arg); err != nil {
+		return im.errorf("committer: %v", err)
+	}
+	if !haveAuthor {
+		c.Author = c.Committer
+	}
+	if enc, ok, err := im.optional("encoding"); err != nil {
+		return err
+	} else if ok {
+		c.Extra = append(c.Extra, object.ExtraHeader{Key: "encoding", Value: enc})
+	}
+	msg, err := im.parseData()
+	if err != nil {
+		return err
+	}
+	c.Message = string(msg)
+
+	if from, ok, err := im.optional("from"); err != nil {
+		return err
+	} else if ok {
+		if err := im.startFrom(b, from); err != nil {
+			return err
+		}
+	}
+	if b.tip != "" {
+		c.Parents = append(c.Parents, b.tip)
+	}
+	for {
+		merge, ok, err := im.optional("merge")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		hash, err := im.resolveCommit(merge)
+		if err != nil {
+			return err
+		}
+		c.Parents = append(c.Parents, hash)
+	}
+
+	if err := im.parseFileChanges(b); err != nil {
+		return err
+	}
+
+	idx := &index.Index{Version: 2, Algorithm: im.algo}
+	for _, e := range b.files {
+		idx.Add(&index.Entry{Path: e.Name, Mode: e.Mode, Hash: e.Hash})
+	}
+	if c.Tree, err = idx.WriteTree(im.repo.GitDir); err != nil {
+		return err
+	}
+	hash, err := object.WriteObjectWith(im.algo, im.repo.GitDir, object.TypeCommit, c.Bytes())
+	if err != nil {
+		return err
+	}
+	im.Stats.Commits++
+	b.tip = hash
+	im.setMark(mark, hash)
+	return nil
+}
+
+// parseFileChanges applies the file commands that end a commit to b's
+// files.
+func (im *Importer) parseFileChanges(b *branch) error {
+	for {
+		line, ok, err := im.next()
+		if err != nil || !ok {
+			return err
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch cmd {
+		case "M":
+			err = im.fileModify(b, arg)
+		case "D":
+			var p string
+			if p, err = im.path(arg); err == nil {
+				removePath(b.files, p)
+			}
+		case "C", "R":
+			err = im.fileCopy(b, arg, cmd == "R")
+		case "deleteall":
+			b.files = make(map[string]object.TreeEntry)
+		default:
+			im.unread(line)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// fileModify applies "M <mode> <dataref> <path>".
+func (im *Importer) fileModify(b *branch, arg string) error {
+	fields := strings.SplitN(arg, " ", 3)
+	if len(fields) != 3 {
+		return im.errorf("malformed M command: M %s", arg)
+	}
+	mode, err := parseMode(fields[0])
+	if err != nil {
+		return im.errorf("%v", err)
+	}
+	p, err := im.path(fields[2])
+	if err != nil {
+		return err
+	}
+
+	var hash string
+	switch ref := fields[1]; {
+	case ref == "inline":
+		if mode == object.ModeTree || mode == object.ModeGitlink {
+			return im.errorf("inline data can't be a %06o entry", mode)
+		}
+		data, err := im.parseData()
+		if err != nil {
+			return err
+		}
+		if hash, err = object.WriteObjectWith(im.algo, im.repo.GitDir, object.TypeBlob, data); err != nil {
+			return err
+		}
+		im.Stats.Blobs++
+	case strings.HasPrefix(ref, ":"):
+		mark, err := strconv.Atoi(ref[1:])
+		var ok bool
+		if hash, ok = im.Marks[mark]; err != nil || !ok {
+			return im.errorf("mark not declared: %s", ref)
+		}
+	case len(ref) == im.algo.HexLen():
+		hash = ref
+		// A submodule's commit lives in another repository.
+		if mode != object.ModeGitlink {
+			if err := object.Exists(im.repo.GitDir, hash); err != nil {
+				return im.errorf("%v", err)
+			}
+		}
+	default:
+		return im.errorf("invalid dataref: %s", ref)
+	}
+
+	removePath(b.files, p)
+	if mode == object.ModeTree {
+		return im.loadTree(b, hash, p+"/")
+	}
+	// A file replaces any file standing where its parent directories go.
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		delete(b.files, dir)
+	}
+	b.files[p] = object.TreeEntry{Mode: mode, Name: p, Hash: hash}
+	return nil
+}
+
+// fileCopy applies "C <src> <dst>", or "R <src> <dst>" if rename.
+func (im *Importer) fileCopy(b *branch, arg string, rename bool) error {
+	var src, rest string
+	if strings.HasPrefix(arg, `"`) {
+		end := closingQuote(arg)
+		if end < 0 {
+			return im.errorf("unterminated path: %s", arg)
+		}
+		src, rest = arg[:end+1], strings.TrimPrefix(arg[end+1:], " ")
+	} else {
+		var ok bool
+		if src, rest, ok = strings.Cut(arg, " "); !ok {
+			return im.errorf("missing destination path: %s", arg)
+		}
+	}
+	srcPath, err := im.path(src)
+	if err != nil {
+		return err
+	}
+	dstPath, err := im.path(rest)
+	if err != nil {
+		return err
+	}
+
+	var moved []object.TreeEntry
+	for p, e := range b.files {
+		if p == srcPath || strings.HasPrefix(p, srcPath+"/") {
+			moved = append(moved, e)
+		}
+	}
+	if len(moved) == 0 {
+		return im.errorf("path %s not in branch", srcPath)
+	}
+	if rename {
+		removePath(b.files, srcPath)
+	}
+	removePath(b.files, dstPath)
+	for _, e := range moved {
+		e.Name = dstPath + strings.TrimPrefix(e.Name, srcPath)
+		b.files[e.Name] = e
+	}
+	return nil
+}
+
+// path unquotes a path argument if it is in C-style quotes and checks
+// that it is a clean relative path.
+func (im *Importer) path(s string) (string, error) {
+	p := s
+	if strings.HasPrefix(s, `"`) {
+		var err error
+		if p, err = strconv.Unquote(s); err != nil {
+			return "", im.errorf("invalid quoted path: %s", s)
+		}
+	}
+	if p == "" || path.Clean(p) != p || strings.HasPrefix(p, "/") || p == ".." || strings.HasPrefix(p, "../") {
+		return "", im.errorf("invalid path: %s", s)
+	}
+	return p, nil
+}
+
+// closingQuote returns the index of the quote ending the quoted string
+// at the start of s, or -1.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// removePath deletes p, or everything under it if it is a directory.
+func removePath(files map[string]object.TreeEntry, p string) {
+	delete(files, p)
+	for name := range files {
+		if strings.HasPrefix(name, p+"/") {
+			delete(files, name)
+		}
+	}
+}
+
+func parseMode(s string) (uint32, error) {
+	switch s {
+	case "644", "100644":
+		return object.ModeFile, nil
+	case "755", "100755":
+		return object.ModeExecutable, nil
+	case "120000":
+		return object.ModeSymlink, nil
+	case "160000":
+		return object.ModeGitlink, nil
+	case "040000", "40000":
+		return object.ModeTree, nil
+	}
+	return 0, fmt.Errorf("invalid file mode: %s", s)
+}
+
+func (im *Importer) parseTag(name string) error {
+	ref := "refs/tags/" + name
+	if err := repository.CheckRefName(ref); err != nil {
+		return im.errorf("%v", err)
+	}
+	mark, err := im.parseMark()
+	if err != nil {
+		return err
+	}
+	from, ok, err := im.optional("from")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return im.errorf("tag %s: missing from", name)
+	}
+	target, err := im.resolve(from)
+	if err != nil {
+		return err
+	}
+	objType, _, err := object.ReadHeader(im.repo.GitDir, target)
+	if err != nil {
+		return err
+	}
+	if _, _, err := im.optional("original-oid"); err != nil {
+		return err
+	}
+
+	t := &object.Tag{Object: target, Type: objType, Tag: name}
+	if arg, ok, err := im.optional("tagger"); err != nil {
+		return err
+	} else if ok {
+		if t.Tagger, err = object.ParseSignature(arg); err != nil {
+			return im.errorf("tagger: %v", err)
+		}
+	}
+	msg, err := im.parseData()
+	if err != nil {
+		return err
+	}
+	t.Message = string(msg)
+
+	hash, err := object.WriteObjectWith(im.algo, im.repo.GitDir, object.TypeTag, t.Bytes())
+	if err != nil {
+		return err
+	}
+	im.Stats.Tags++
+	im.tags[ref] = hash
+	im.setMark(mark, hash)
+	return nil
+}
+
+// updateRefs points each branch the stream wrote at its new tip, and each
+// tag at its tag object. A branch whose tip doesn't contain the ref's
+// current value is skipped unless im.Force is set.
+func (im *Importer) updateRefs() error {
+	refs := make([]string, 0, len(im.branches))
+	for ref := range im.branches {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	var rejected []string
+	for _, ref := range refs {
+		tip := im.branches[ref].tip
+		if tip == "" {
+			continue
+		}
+		old, err := repository.ResolveRef(im.repo.GitDir, ref)
+		if err != nil && !errors.Is(err, repository.ErrUnknownRevision) {
+			return err
+		}
+		if old != "" && old != tip && !im.Force {
+			contains, err := im.contains(tip, old)
+			if err != nil {
+				return err
+			}
+			if !contains {
+				rejected = append(rejected, fmt.Sprintf("%s (new tip %s does not contain %s)", ref, tip, old))
+				continue
+			}
+		}
+		if err := repository.UpdateRef(im.repo.GitDir, ref, tip); err != nil {
+			return err
+		}
+	}
+	for ref, hash := range im.tags {
+		if err := repository.UpdateRef(im.repo.GitDir, ref, hash); err != nil {
+			return err
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("fast-import: not updating %s", strings.Join(rejected, ", "))
+	}
+	return nil
+}
+
+// contains reports whether commit old is tip or one of its ancestors.
+func (im *Importer) contains(tip, old string) (bool, error) {
+	for c, err := range im.repo.Log(tip, repository.LogOptions{AllParents: true}) {
+		if err != nil {
+			return false, err
+		}
+		if c.Hash == old {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,308 @@
+package fastimport
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/repository"
+)
+
+func testRepo(t *testing.T) *repository.Repository {
+	t.Helper()
+	repo, err := repository.Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func runImport(t *testing.T, repo *repository.Repository, stream string) *Importer {
+	t.Helper()
+	im := NewImporter(repo)
+	if err := im.Import(strings.NewReader(stream)); err != nil {
+		t.Fatalf("Import() error: %v", err)
+	}
+	return im
+}
+
+func resolve(t *testing.T, repo *repository.Repository, ref string) string {
+	t.Helper()
+	hash, err := repository.ResolveRef(repo.GitDir, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func readCommit(t *testing.T, repo *repository.Repository, hash string) *object.Commit {
+	t.Helper()
+	obj, err := object.Read(repo.GitDir, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := object.ParseCommit(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// files lists a commit's tree as "<mode> <path> <content>" lines.
+func files(t *testing.T, repo *repository.Repository, commit string) string {
+	t.Helper()
+	entries, err := object.FlattenTree(repo.GitDir, readCommit(t, repo, commit).Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		obj, err := object.Read(repo.GitDir, e.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&b, "%06o %s %s\n", e.Mode, e.Name, strings.TrimSpace(string(obj.Body)))
+	}
+	return b.String()
+}
+
+func TestImport_MatchesGit(t *testing.T) {
+	repo := testRepo(t)
+	// git fast-import writes 7ee035a for this stream.
+	runImport(t, repo, "commit refs/heads/main\n"+
+		"committer u <e@x> 1 +0000\n"+
+		"data <<EOF\nroot\nEOF\n"+
+		"M 644 inline f\ndata 3\nhi\n\n")
+	if got := resolve(t, repo, "refs/heads/main"); got != "7ee035ae5482b7a78d84d46803abe9491d075d32" {
+		t.Errorf("main = %s, want git's 7ee035a", got)
+	}
+}
+
+func TestImport_History(t *testing.T) {
+	repo := testRepo(t)
+	im := runImport(t, repo, `# a comment
+blob
+mark :1
+data 2
+a
+
+blob
+mark :2
+data 2
+b
+
+commit refs/heads/main
+mark :10
+author A <a@x> 100 +0100
+committer C <c@x> 200 +0000
+data 4
+one
+M 100644 :1 a
+M 100644 :2 "dir/with space"
+
+commit refs/heads/side
+mark :11
+committer C <c@x> 300 +0000
+data 4
+two
+from :10
+M 755 :2 b
+R a renamed
+
+commit refs/heads/main
+mark :12
+committer C <c@x> 400 +0000
+data 6
+merge
+merge :11
+C dir copy
+D a
+
+tag v1
+from :12
+tagger T <t@x> 500 +0000
+data 4
+tag
+
+reset refs/heads/old
+from :10
+`)
+
+	main := resolve(t, repo, "refs/heads/main")
+	side := resolve(t, repo, "refs/heads/side")
+	first := im.Marks[10]
+	if main != im.Marks[12] || side != im.Marks[11] {
+		t.Fatalf("refs don't match marks: main=%s side=%s marks=%v", main, side, im.Marks)
+	}
+	if old := resolve(t, repo, "refs/heads/old"); old != first {
+		t.Errorf("reset branch = %s, want %s", old, first)
+	}
+
+	c := readCommit(t, repo, first)
+	if c.Author.String() != "A <a@x> 100 +0100" || c.Committer.String() != "C <c@x> 200 +0000" || c.Message != "one\n" || len(c.Parents) != 0 {
+		t.Errorf("first commit = %+v", c)
+	}
+	if got := readCommit(t, repo, side).Author.Name; got != "C" {
+		t.Errorf("author without an author line = %q, want the committer", got)
+	}
+	if got := readCommit(t, repo, main).Parents; len(got) != 2 || got[0] != first || got[1] != side {
+		t.Errorf("merge parents = %v, want [%s %s]", got, first, side)
+	}
+
+	if got, want := files(t, repo, side), "100755 b b\n100644 dir/with space b\n100644 renamed a\n"; got != want {
+		t.Errorf("side files:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := files(t, repo, main), "100644 copy/with space b\n100644 dir/with space b\n"; got != want {
+		t.Errorf("main files:\n%s\nwant:\n%s", got, want)
+	}
+
+	tag, err := repository.Peel(repo.GitDir, "v1", object.TypeCommit)
+	if err != nil || tag != main {
+		t.Errorf("v1 peels to %s, %v; want %s", tag, err, main)
+	}
+	if im.Stats != (Stats{Blobs: 2, Commits: 3, Tags: 1}) {
+		t.Errorf("Stats = %+v", im.Stats)
+	}
+}
+
+func TestImport_FileCommands(t *testing.T) {
+	repo := testRepo(t)
+	runImport(t, repo, `commit refs/heads/main
+mark :1
+committer C <c@x> 1 +0000
+data 0
+M 644 inline a
+data 1
+a
+M 644 inline sub/b
+data 1
+b
+
+commit refs/heads/main
+mark :2
+committer C <c@x> 2 +0000
+data 0
+deleteall
+M 644 inline sub
+data 4
+file
+`)
+	if got, want := files(t, repo, resolve(t, repo, "refs/heads/main")), "100644 sub file\n"; got != want {
+		t.Errorf("after deleteall:\n%s\nwant:\n%s", got, want)
+	}
+
+	first := resolve(t, repo, "refs/heads/main")
+	tree := readCommit(t, repo, first).Tree
+	runImport(t, repo, "commit refs/heads/main\ncommitter C <c@x> 3 +0000\ndata 0\nfrom refs/heads/main^0\n"+
+		"M 040000 "+tree+" nested\nM 644 inline nested/sub/x\ndata 1\nx\n")
+	got := files(t, repo, resolve(t, repo, "refs/heads/main"))
+	if want := "100644 nested/sub/x x\n100644 sub file\n"; got != want {
+		t.Errorf("after a tree M and a file over it:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestImport_NonFastForward(t *testing.T) {
+	repo := testRepo(t)
+	stream := func(msg string) string {
+		return fmt.Sprintf("commit refs/heads/main\ncommitter C <c@x> 1 +0000\ndata %d\n%s\n", len(msg), msg)
+	}
+	runImport(t, repo, stream("a"))
+	before := resolve(t, repo, "refs/heads/main")
+
+	im := NewImporter(repo)
+	err := im.Import(strings.NewReader(stream("b")))
+	if err == nil || !strings.Contains(err.Error(), "not updating refs/heads/main") {
+		t.Fatalf("unrelated history: got %v", err)
+	}
+	if got := resolve(t, repo, "refs/heads/main"); got != before {
+		t.Errorf("main moved to %s without --force", got)
+	}
+
+	im = NewImporter(repo)
+	im.Force = true
+	if err := im.Import(strings.NewReader(stream("b"))); err != nil {
+		t.Fatal(err)
+	}
+	if got := resolve(t, repo, "refs/heads/main"); got == before {
+		t.Error("Force didn't update main")
+	}
+
+	// Building on the ref with ^0 is a fast-forward.
+	after := resolve(t, repo, "refs/heads/main")
+	runImport(t, repo, "commit refs/heads/main\ncommitter C <c@x> 2 +0000\ndata 1\nc\nfrom refs/heads/main^0\n")
+	if got := readCommit(t, repo, resolve(t, repo, "refs/heads/main")).Parents; len(got) != 1 || got[0] != after {
+		t.Errorf("incremental import parents = %v, want [%s]", got, after)
+	}
+}
+
+func TestImport_ProgressAndDone(t *testing.T) {
+	repo := testRepo(t)
+	var progress bytes.Buffer
+	im := NewImporter(repo)
+	im.Progress = &progress
+	if err := im.Import(strings.NewReader("feature done\nprogress half way\ncheckpoint\ndone\nthis is never read\n")); err != nil {
+		t.Fatalf("Import() error: %v", err)
+	}
+	if progress.String() != "progress half way\n" {
+		t.Errorf("progress = %q", progress.String())
+	}
+
+	err := NewImporter(repo).Import(strings.NewReader("feature done\nprogress x\n"))
+	if err == nil || !strings.Contains(err.Error(), "done") {
+		t.Errorf("missing done: got %v", err)
+	}
+}
+
+func TestImport_Errors(t *testing.T) {
+	tests := map[string]string{
+		"unknown command":   "frobnicate\n",
+		"undeclared mark":   "commit refs/heads/main\ncommitter C <c@x> 1 +0000\ndata 0\nM 644 :7 a\n",
+		"missing committer": "commit refs/heads/main\ndata 0\n",
+		"bad ref name":      "commit refs/heads/a..b\ncommitter C <c@x> 1 +0000\ndata 0\n",
+		"short data":        "blob\ndata 10\nabc",
+		"bad mode":          "commit refs/heads/main\ncommitter C <c@x> 1 +0000\ndata 0\nM 777 inline a\ndata 0\n",
+		"escaping path":     "commit refs/heads/main\ncommitter C <c@x> 1 +0000\ndata 0\nM 644 inline ../a\ndata 0\n",
+		"rename missing":    "commit refs/heads/main\ncommitter C <c@x> 1 +0000\ndata 0\nR a b\n",
+		"unknown feature":   "feature export-marks=x\n",
+	}
+	for name, stream := range tests {
+		t.Run(name, func(t *testing.T) {
+			repo := testRepo(t)
+			if err := NewImporter(repo).Import(strings.NewReader(stream)); err == nil {
+				t.Fatal("Import() succeeded, want error")
+			}
+			if _, err := repository.ResolveRef(repo.GitDir, "refs/heads/main"); err == nil {
+				t.Error("a failed import updated main")
+			}
+		})
+	}
+}
+
+func TestMarks_RoundTrip(t *testing.T) {
+	marks := map[int]string{
+		10: strings.Repeat("a", 40),
+		2:  strings.Repeat("b", 40),
+	}
+	var buf bytes.Buffer
+	if err := WriteMarks(&buf, marks); err != nil {
+		t.Fatal(err)
+	}
+	want := ":2 " + strings.Repeat("b", 40) + "\n:10 " + strings.Repeat("a", 40) + "\n"
+	if buf.String() != want {
+		t.Errorf("WriteMarks() = %q, want %q", buf.String(), want)
+	}
+
+	got := make(map[int]string)
+	if err := ReadMarks(&buf, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[10] != marks[10] || got[2] != marks[2] {
+		t.Errorf("ReadMarks() = %v", got)
+	}
+
+	if err := ReadMarks(strings.NewReader("1 abc\n"), got); err == nil {
+		t.Error("ReadMarks() accepted a malformed line")
+	}
+}
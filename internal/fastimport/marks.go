@@ -0,0 +1,43 @@
+package fastimport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// ReadMarks adds the marks in a marks file, one ":<mark> <hash>" line
+// each, to marks. Hashes may be SHA-1 or SHA-256.
+func ReadMarks(r io.Reader, marks map[int]string) error {
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		name, hash, ok := strings.Cut(sc.Text(), " ")
+		mark, err := strconv.Atoi(strings.TrimPrefix(name, ":"))
+		if !ok || err != nil || !strings.HasPrefix(name, ":") || mark <= 0 ||
+			len(hash) != object.SHA1.HexLen() && len(hash) != object.SHA256.HexLen() {
+			return fmt.Errorf("marks file line %d: malformed mark %q", n, sc.Text())
+		}
+		marks[mark] = hash
+	}
+	return sc.Err()
+}
+
+// WriteMarks writes marks in the format ReadMarks reads, ordered by mark.
+func WriteMarks(w io.Writer, marks map[int]string) error {
+	ids := make([]int, 0, len(marks))
+	for id := range marks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	bw := bufio.NewWriter(w)
+	for _, id := range ids {
+		fmt.Fprintf(bw, ":%d %s\n", id, marks[id])
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,254 @@
+// Package ignore matches working-tree paths against .gitignore patterns.
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// pattern is one line of an ignore file.
+type pattern struct {
+	// base is the directory of the file the pattern came from, relative
+	// to the working tree ("" for the root). It only applies below there.
+	base    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	// anchored patterns contain a slash and match the whole path below
+	// base; the rest match just the last component, at any depth.
+	anchored bool
+}
+
+// Matcher holds the ignore patterns in force for a working tree, lowest
+// precedence first.
+type Matcher struct {
+	patterns []pattern
+}
+
+// Load reads .git/info/exclude and every .gitignore in workTree. Like
+// git, it doesn't look inside directories that are already ignored,
+// since nothing in them can be un-ignored.
+func Load(gitDir, workTree string) (*Matcher, error) {
+	m := &Matcher{}
+	data, err := os.ReadFile(filepath.Join(gitDir, "info", "exclude"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	m.Add("", data)
+
+	// WalkDir visits a directory before anything in it, so deeper files
+	// land later in the list and take precedence.
+	err = filepath.WalkDir(workTree, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(workTree, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		} else if d.Name() == ".git" || m.Match(rel, true) {
+			return filepath.SkipDir
+		}
+
+		data, err := os.ReadFile(filepath.Join(p, ".gitignore"))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		m.Add(rel, data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Add parses the ignore file data found in dir, a slash-separated path
+// relative to the working tree ("" for the root). Its patterns take
+// precedence over those added before.
+func (m *Matcher) Add(dir string, data []byte) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if p, ok := parsePattern(dir, sc.Text()); ok {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+}
+
+// Match reports whether path, slash-separated and relative to the
+// working tree, is ignored. isDir says whether it names a directory,
+// which directory-only patterns like "build/" need. A path inside an
+// ignored directory is ignored whatever its own patterns say, as in git.
+func (m *Matcher) Match(p string, isDir bool) bool {
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' && m.matchOne(p[:i], true) {
+			return true
+		}
+	}
+	return m.matchOne(p, isDir)
+}
+
+// matchOne applies the patterns to p alone, the last matching pattern
+// deciding.
+func (m *Matcher) matchOne(p string, isDir bool) bool {
+	for i := len(m.patterns) - 1; i >= 0; i-- {
+		pat := &m.patterns[i]
+		if pat.dirOnly && !isDir {
+			continue
+		}
+		rel := p
+		if pat.base != "" {
+			var ok bool
+			if rel, ok = strings.CutPrefix(p, pat.base+"/"); !ok {
+				continue
+			}
+		}
+		if !pat.anchored {
+			rel = path.Base(rel)
+		}
+		if pat.re.MatchString(rel) {
+			return !pat.negate
+		}
+	}
+	return false
+}
+
+// parsePattern parses one line of an ignore file. ok is false for blank
+// lines and comments.
+func parsePattern(dir, line string) (pattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimTrailingSpace(line)
+	if line == "" || line[0] == '#' {
+		return pattern{}, false
+	}
+
+	p := pattern{base: dir}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return pattern{}, false
+	}
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	re, err := Glob(line)
+	if err != nil {
+		// An unterminated bracket and the like match nothing in git.
+		return pattern{}, false
+	}
+	p.re = re
+	return p, true
+}
+
+// trimTrailingSpace drops trailing spaces unless they are escaped with a
+// backslash.
+func trimTrailingSpace(s string) string {
+	end := len(s)
+	for end > 0 && s[end-1] == ' ' {
+		if end > 1 && s[end-2] == '\\' {
+			break
+		}
+		end--
+	}
+	return s[:end]
+}
+
+// Glob compiles a gitignore glob, which .gitattributes patterns share,
+// into a regular expression matching whole slash-separated paths.
+func Glob(glob string) (*regexp.Regexp, error) {
+	return regexp.Compile(globRegexp(glob))
+}
+
+// globRegexp translates a gitignore glob into an anchored regular
+// expression. '*' and '?' don't match '/'; "**" as a whole path
+// component matches any number of directories.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case glob[i:] == "/**":
+			b.WriteString("/.*")
+			i += 2
+		case c == '*':
+			for i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+			}
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := classEnd(glob, i)
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : end]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = end
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// classEnd returns the index of the ']' closing the bracket expression
+// that opens at glob[start], or -1 if it isn't closed. A ']' right after
+// the opening bracket (or its '!' or '^') is part of the class.
+func classEnd(glob string, start int) int {
+	i := start + 1
+	if i < len(glob) && (glob[i] == '!' || glob[i] == '^') {
+		i++
+	}
+	if i < len(glob) && glob[i] == ']' {
+		i++
+	}
+	for ; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "[:"):
+			if end := strings.Index(glob[i+2:], ":]"); end >= 0 {
+				i += end + 3
+			}
+		case glob[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
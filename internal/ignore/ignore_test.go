@@ -0,0 +1,133 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, root, name, content string) {
+	t.Helper()
+	p := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The expectations below were checked against git check-ignore.
+func TestLoad(t *testing.T) {
+	workTree := t.TempDir()
+	gitDir := filepath.Join(workTree, ".git")
+	writeFile(t, workTree, ".gitignore", `# comment
+build/
+!build/keep.txt
+*.log
+!important.log
+out/*
+!out/keep
+/rootonly
+**/cache
+doc/**/*.pdf
+\#hash
+`+"trailing  \n")
+	writeFile(t, workTree, "src/.gitignore", "*.tmp\n!x.tmp\n")
+	writeFile(t, workTree, "build/.gitignore", "!*\n")
+	writeFile(t, gitDir, "info/exclude", "excluded\n*.tmp\n")
+
+	m, err := Load(gitDir, workTree)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"build", true, true},
+		{"build", false, false}, // "build/" only matches directories
+		// Negating a file inside an ignored directory doesn't bring it
+		// back, from the same .gitignore or one inside the directory.
+		{"build/keep.txt", false, true},
+		{"build/sub/x", false, true},
+		{"a.log", false, true},
+		{"logs/b.log", false, true},
+		{"important.log", false, false},
+		// out/* ignores the contents, not the directory, so a negation
+		// can pick a file back out.
+		{"out", true, false},
+		{"out/keep", false, false},
+		{"out/drop", false, true},
+		{"rootonly", false, true},
+		{"src/rootonly", false, false},
+		{"deep/a/b/cache", false, true},
+		{"deep/cache/z", false, true},
+		{"doc/x/y/a.pdf", false, true},
+		{"doc/b.pdf", false, true},
+		{"doc/c.txt", false, false},
+		{"#hash", false, true},
+		{"trailing", false, true},
+		{"excluded", false, true},
+		// The deeper src/.gitignore overrides info/exclude and its own
+		// earlier line.
+		{"src/a.tmp", false, true},
+		{"src/x.tmp", false, false},
+		{"src/gen/y.tmp", false, true},
+		{"top.tmp", false, true},
+		{"kept.txt", false, false},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestMatch_LaterPatternWins(t *testing.T) {
+	var m Matcher
+	m.Add("", []byte("*.txt\n!a.txt\n"))
+	m.Add("sub", []byte("a.txt\n"))
+	for p, want := range map[string]bool{"b.txt": true, "a.txt": false, "sub/a.txt": true, "sub/b.txt": true} {
+		if got := m.Match(p, false); got != want {
+			t.Errorf("Match(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		glob, path string
+		want       bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "dir/main.go", false},
+		{"a?c", "abc", true},
+		{"a?c", "a/c", false},
+		{"[abc].txt", "b.txt", true},
+		{"[!abc].txt", "b.txt", false},
+		{"[]x].txt", "].txt", true},
+		{"[[:digit:]]x", "7x", true},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**", "a/x/y", true},
+		{"a/**", "a", false},
+		{"**/b", "x/y/b", true},
+		{"a**b", "axxb", true},
+		{"a**b", "a/b", false},
+		{`\*`, "*", true},
+		{`\*`, "x", false},
+		{"a.b", "axb", false},
+	}
+	for _, tt := range tests {
+		p, ok := parsePattern("", tt.glob)
+		if !ok {
+			t.Errorf("parsePattern(%q) failed", tt.glob)
+			continue
+		}
+		if got := p.re.MatchString(tt.path); got != tt.want {
+			t.Errorf("glob %q on %q = %v, want %v (regexp %s)", tt.glob, tt.path, got, tt.want, p.re)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// ErrNoMatch is returned by AddPath for a path that is neither on disk
+// nor in the index.
+var ErrNoMatch = errors.New("pathspec did not match any files")
+
+// AddPath stages rel, a slash-separated path relative to workTree ("" or
+// "." for the whole tree), writing blobs into gitDir's object store. A
+// file's entry is inserted or replaced; a directory is walked, skipping
+// .git, and entries under it whose files are gone are dropped, as git
+// add does. A path deleted from disk is likewise removed from the index.
+// Files are passed through their clean filters on the way in.
+func (idx *Index) AddPath(gitDir, workTree, rel string) error {
+	rel = cleanPath(rel)
+	full := filepath.Join(workTree, filepath.FromSlash(rel))
+	filters, err := LoadFilters(gitDir, workTree)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(full)
+	if errors.Is(err, fs.ErrNotExist) {
+		if !idx.removeTree(rel) {
+			return fmt.Errorf("%w: %s", ErrNoMatch, rel)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return idx.addFile(gitDir, filters, full, rel, info)
+	}
+
+	seen := make(map[string]bool)
+	err = filepath.WalkDir(full, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		r, err := filepath.Rel(workTree, p)
+		if err != nil {
+			return err
+		}
+		r = filepath.ToSlash(r)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		seen[r] = true
+		return idx.addFile(gitDir, filters, p, r, info)
+	})
+	if err != nil {
+		return err
+	}
+
+	kept := idx.Entries[:0]
+	for _, e := range idx.Entries {
+		if seen[e.Path] || !underDir(e.Path, rel) {
+			kept = append(kept, e)
+		} else {
+			idx.Tree.invalidate(e.Path)
+		}
+	}
+	idx.Entries = kept
+	return nil
+}
+
+// addFile hashes the file at full, cleaned by filters, and stages it as
+// rel. Sockets, FIFOs, and devices can't be stored and are skipped.
+func (idx *Index) addFile(gitDir string, filters *Filters, full, rel string, info fs.FileInfo) error {
+	data, mode, err := readFile(full, info)
+	if errors.Is(err, errUnsupportedFile) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if mode != object.ModeSymlink {
+		if data, err = filters.Clean(rel, data); err != nil {
+			return err
+		}
+	}
+
+	sha, err := object.WriteObjectWith(idx.Algorithm, gitDir, object.TypeBlob, data)
+	if err != nil {
+		return fmt.Errorf("adding %s: %w", rel, err)
+	}
+	e := &Entry{Mode: mode, Hash: sha, Path: rel}
+	fillStat(e, info)
+
+	// A file replaces a directory of the same name and vice versa, so
+	// drop entries under rel and entries for any of its parents.
+	idx.removeTree(rel)
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		idx.Remove(dir)
+	}
+	idx.Add(e)
+	return nil
+}
+
+// errUnsupportedFile is returned by readFile for file types git can't
+// store.
+var errUnsupportedFile = errors.New("unsupported file type")
+
+// readFile returns what the file at full would be stored as: its content,
+// or a symlink's target, and the index mode for it.
+func readFile(full string, info fs.FileInfo) ([]byte, uint32, error) {
+	mode, ok := fileMode(info)
+	if !ok {
+		return nil, 0, fmt.Errorf("%s: %w", full, errUnsupportedFile)
+	}
+	if mode == object.ModeSymlink {
+		target, err := os.Readlink(full)
+		return []byte(target), mode, err
+	}
+	data, err := os.ReadFile(full)
+	return data, mode, err
+}
+
+// ReadWorkFile returns what the working-tree file at full would be
+// staged as: its content, or a symlink's target, and its mode.
+func ReadWorkFile(full string) ([]byte, uint32, error) {
+	info, err := os.Lstat(full)
+	if err != nil {
+		return nil, 0, err
+	}
+	return readFile(full, info)
+}
+
+// HashFile returns the blob hash and mode the file at full would be
+// staged with, without writing anything to the object store.
+func HashFile(full string) (string, uint32, error) {
+	data, mode, err := ReadWorkFile(full)
+	if err != nil {
+		return "", 0, err
+	}
+	return object.HashBytes([]byte(object.Header(object.TypeBlob, int64(len(data))) + string(data))), mode, nil
+}
+
+// removeTree removes the entries for rel and everything under it, and
+// reports whether there were any.
+func (idx *Index) removeTree(rel string) bool {
+	kept := idx.Entries[:0]
+	for _, e := range idx.Entries {
+		if e.Path != rel && !underDir(e.Path, rel) {
+			kept = append(kept, e)
+		} else {
+			idx.Tree.invalidate(e.Path)
+		}
+	}
+	removed := len(kept) < len(idx.Entries)
+	idx.Entries = kept
+	return removed
+}
+
+// underDir reports whether p lies inside directory dir ("" being the
+// root).
+func underDir(p, dir string) bool {
+	return dir == "" || strings.HasPrefix(p, dir+"/")
+}
+
+// cleanPath normalizes a slash-separated relative path, mapping the tree
+// root to "".
+func cleanPath(rel string) string {
+	rel = path.Clean(rel)
+	if rel == "." {
+		return ""
+	}
+	return rel
+}
@@ -0,0 +1,208 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// testWorkTree creates a working tree with an empty .git/objects and the
+// given files (slash-separated path to content).
+func testWorkTree(t *testing.T, files map[string]string) (workTree, gitDir string) {
+	t.Helper()
+	workTree = t.TempDir()
+	gitDir = filepath.Join(workTree, ".git")
+	if err := os.MkdirAll(filepath.Join(gitDir, "objects"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		writeFile(t, workTree, name, content)
+	}
+	return workTree, gitDir
+}
+
+func writeFile(t *testing.T, workTree, name, content string) {
+	t.Helper()
+	p := filepath.Join(workTree, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func paths(idx *Index) string {
+	var ps []string
+	for _, e := range idx.Entries {
+		ps = append(ps, e.Path)
+	}
+	return strings.Join(ps, " ")
+}
+
+func TestAddPath_File(t *testing.T) {
+	workTree, gitDir := testWorkTree(t, map[string]string{"hello.txt": "hello\n"})
+
+	idx := New()
+	if err := idx.AddPath(gitDir, workTree, "hello.txt"); err != nil {
+		t.Fatalf("AddPath() error: %v", err)
+	}
+	e := idx.Find("hello.txt")
+	if e == nil {
+		t.Fatal("hello.txt not staged")
+	}
+	if e.Hash != blobHash || e.Mode != object.ModeFile || e.Size != 6 || e.MTime.IsZero() {
+		t.Errorf("entry = %+v", *e)
+	}
+	if err := object.Exists(gitDir, e.Hash); err != nil {
+		t.Errorf("blob not written: %v", err)
+	}
+
+	// Re-adding an unchanged file leaves the same entry.
+	if err := idx.AddPath(gitDir, workTree, "hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Entries) != 1 || idx.Entries[0].Hash != blobHash {
+		t.Errorf("re-add changed the index: %+v", idx.Entries)
+	}
+
+	writeFile(t, workTree, "hello.txt", "changed\n")
+	if err := idx.AddPath(gitDir, workTree, "hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if idx.Find("hello.txt").Hash == blobHash {
+		t.Error("modified file kept its old hash")
+	}
+}
+
+func TestAddPath_Directory(t *testing.T) {
+	workTree, gitDir := testWorkTree(t, map[string]string{
+		"a/b/c.txt": "c\n",
+		"a/d.txt":   "d\n",
+		"top.txt":   "top\n",
+	})
+	if err := os.Chmod(filepath.Join(workTree, "a", "d.txt"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := New()
+	if err := idx.AddPath(gitDir, workTree, "a"); err != nil {
+		t.Fatalf("AddPath() error: %v", err)
+	}
+	if got := paths(idx); got != "a/b/c.txt a/d.txt" {
+		t.Errorf("staged %q", got)
+	}
+	if idx.Find("a/d.txt").Mode != object.ModeExecutable {
+		t.Errorf("a/d.txt mode = %o, want executable", idx.Find("a/d.txt").Mode)
+	}
+
+	// The whole tree, without .git.
+	if err := idx.AddPath(gitDir, workTree, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(idx); got != "a/b/c.txt a/d.txt top.txt" {
+		t.Errorf("staged %q", got)
+	}
+
+	// Files deleted under an added directory drop out of the index.
+	os.Remove(filepath.Join(workTree, "a", "b", "c.txt"))
+	if err := idx.AddPath(gitDir, workTree, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(idx); got != "a/d.txt top.txt" {
+		t.Errorf("after deleting a/b/c.txt: staged %q", got)
+	}
+}
+
+func TestAddPath_Deleted(t *testing.T) {
+	workTree, gitDir := testWorkTree(t, map[string]string{"gone.txt": "x\n"})
+
+	idx := New()
+	if err := idx.AddPath(gitDir, workTree, "gone.txt"); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(workTree, "gone.txt"))
+	if err := idx.AddPath(gitDir, workTree, "gone.txt"); err != nil {
+		t.Fatalf("AddPath() of deleted file: %v", err)
+	}
+	if len(idx.Entries) != 0 {
+		t.Errorf("deleted file still staged: %+v", idx.Entries)
+	}
+
+	if err := idx.AddPath(gitDir, workTree, "never.txt"); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("AddPath() of unknown path: got %v, want ErrNoMatch", err)
+	}
+}
+
+func TestAddPath_FileReplacesDirectory(t *testing.T) {
+	workTree, gitDir := testWorkTree(t, map[string]string{"x/y": "y\n"})
+
+	idx := New()
+	if err := idx.AddPath(gitDir, workTree, ""); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(filepath.Join(workTree, "x"))
+	writeFile(t, workTree, "x", "now a file\n")
+	if err := idx.AddPath(gitDir, workTree, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(idx); got != "x" {
+		t.Errorf("staged %q, want x", got)
+	}
+
+	os.Remove(filepath.Join(workTree, "x"))
+	writeFile(t, workTree, "x/z", "z\n")
+	if err := idx.AddPath(gitDir, workTree, "x/z"); err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(idx); got != "x/z" {
+		t.Errorf("staged %q, want x/z", got)
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	workTree, _ := testWorkTree(t, map[string]string{"hello.txt": "hello\n"})
+
+	sha, mode, err := HashFile(filepath.Join(workTree, "hello.txt"))
+	if err != nil {
+		t.Fatalf("HashFile() error: %v", err)
+	}
+	if sha != blobHash || mode != object.ModeFile {
+		t.Errorf("HashFile() = %s %o, want %s 100644", sha, mode, blobHash)
+	}
+
+	if err := os.Symlink("hello.txt", filepath.Join(workTree, "link")); err != nil {
+		t.Fatal(err)
+	}
+	sha, mode, err = HashFile(filepath.Join(workTree, "link"))
+	if err != nil {
+		t.Fatalf("HashFile(symlink) error: %v", err)
+	}
+	if want := object.HashBytes([]byte("blob 9\x00hello.txt")); sha != want || mode != object.ModeSymlink {
+		t.Errorf("HashFile(symlink) = %s %o, want %s 120000", sha, mode, want)
+	}
+}
+
+func TestReadWorkFile(t *testing.T) {
+	workTree, _ := testWorkTree(t, map[string]string{"run.sh": "#!/bin/sh\n"})
+	full := filepath.Join(workTree, "run.sh")
+	if err := os.Chmod(full, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("run.sh", filepath.Join(workTree, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, mode, err := ReadWorkFile(full)
+	if err != nil || string(data) != "#!/bin/sh\n" || mode != object.ModeExecutable {
+		t.Errorf("ReadWorkFile(run.sh) = %q, %o, %v", data, mode, err)
+	}
+	data, mode, err = ReadWorkFile(filepath.Join(workTree, "link"))
+	if err != nil || string(data) != "run.sh" || mode != object.ModeSymlink {
+		t.Errorf("ReadWorkFile(link) = %q, %o, %v", data, mode, err)
+	}
+}
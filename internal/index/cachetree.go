@@ -0,0 +1,167 @@
+package index
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// CacheTree is the index's cached-tree extension, "TREE": the tree
+// hashes WriteTree last produced for the root directory and those below
+// it, so that directories with no changes since needn't be written
+// again. A directory whose entries have changed is invalid until the next
+// WriteTree.
+type CacheTree struct {
+	// Name is the directory's name within its parent, "" for the root.
+	Name string
+	// EntryCount is how many index entries lie under the directory, or
+	// -1 if Hash is out of date.
+	EntryCount int
+	Hash       string
+	Subtrees   []*CacheTree
+}
+
+// valid reports whether t's hash can stand for the count entries now
+// under its directory.
+func (t *CacheTree) valid(count int) bool {
+	return t != nil && t.EntryCount >= 0 && t.EntryCount == count
+}
+
+// sub returns the subtree called name, or nil.
+func (t *CacheTree) sub(name string) *CacheTree {
+	for _, s := range t.Subtrees {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// invalidate marks the directories holding path, from t down, as out of
+// date. Their subtrees elsewhere stay valid.
+func (t *CacheTree) invalidate(path string) {
+	for t != nil {
+		t.EntryCount = -1
+		dir, rest, ok := strings.Cut(path, "/")
+		if !ok {
+			return
+		}
+		t, path = t.sub(dir), rest
+	}
+}
+
+// sortSubtrees puts subtrees in the order git keeps them: shorter names
+// first, then bytewise.
+func (t *CacheTree) sortSubtrees() {
+	sort.Slice(t.Subtrees, func(i, j int) bool {
+		a, b := t.Subtrees[i].Name, t.Subtrees[j].Name
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+}
+
+// encode appends t and its subtrees to buf in the extension's format:
+// for each directory, depth first, its name, a NUL, its entry count and
+// number of subtrees in decimal, a newline, and then, if it is valid,
+// its raw hash.
+func (t *CacheTree) encode(buf *bytes.Buffer, algo object.Algorithm) error {
+	t.sortSubtrees()
+	fmt.Fprintf(buf, "%s\x00%d %d\n", t.Name, t.EntryCount, len(t.Subtrees))
+	if t.EntryCount >= 0 {
+		raw, err := hex.DecodeString(t.Hash)
+		if err != nil || len(raw) != algo.Size() {
+			return fmt.Errorf("cached tree %q: bad hash %q", t.Name, t.Hash)
+		}
+		buf.Write(raw)
+	}
+	for _, s := range t.Subtrees {
+		if err := s.encode(buf, algo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeCacheTree parses the data of a TREE extension.
+func decodeCacheTree(data []byte, algo object.Algorithm) (*CacheTree, error) {
+	t, rest, err := decodeCacheTreeNode(data, algo)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%d bytes after the root", len(rest))
+	}
+	return t, nil
+}
+
+// decodeCacheTreeNode parses the directory at the start of data,
+// subtrees included, and returns it with the data that follows.
+func decodeCacheTreeNode(data []byte, algo object.Algorithm) (*CacheTree, []byte, error) {
+	name, data, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return nil, nil, fmt.Errorf("unterminated name")
+	}
+	line, data, ok := bytes.Cut(data, []byte{'\n'})
+	if !ok {
+		return nil, nil, fmt.Errorf("%q: unterminated counts", name)
+	}
+	count, subs, ok := strings.Cut(string(line), " ")
+	if !ok {
+		return nil, nil, fmt.Errorf("%q: bad counts %q", name, line)
+	}
+	t := &CacheTree{Name: string(name)}
+	var err error
+	if t.EntryCount, err = strconv.Atoi(count); err != nil || t.EntryCount < -1 {
+		return nil, nil, fmt.Errorf("%q: bad entry count %q", name, count)
+	}
+	n, err := strconv.Atoi(subs)
+	if err != nil || n < 0 {
+		return nil, nil, fmt.Errorf("%q: bad subtree count %q", name, subs)
+	}
+	if t.EntryCount >= 0 {
+		size := algo.Size()
+		if len(data) < size {
+			return nil, nil, fmt.Errorf("%q: truncated hash", name)
+		}
+		t.Hash = hex.EncodeToString(data[:size])
+		data = data[size:]
+	}
+	for range n {
+		var s *CacheTree
+		if s, data, err = decodeCacheTreeNode(data, algo); err != nil {
+			return nil, nil, err
+		}
+		t.Subtrees = append(t.Subtrees, s)
+	}
+	return t, data, nil
+}
+
+// cacheTreeOf returns the cached tree for the tree hash, read from the
+// object store, as FromTree primes it.
+func cacheTreeOf(gitDir, name, hash string) (*CacheTree, error) {
+	entries, err := object.ReadTree(gitDir, hash)
+	if err != nil {
+		return nil, err
+	}
+	t := &CacheTree{Name: name, Hash: hash}
+	for _, e := range entries {
+		if e.Mode != object.ModeTree {
+			t.EntryCount++
+			continue
+		}
+		s, err := cacheTreeOf(gitDir, e.Name, e.Hash)
+		if err != nil {
+			return nil, err
+		}
+		t.EntryCount += s.EntryCount
+		t.Subtrees = append(t.Subtrees, s)
+	}
+	return t, nil
+}
@@ -0,0 +1,132 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// cacheCounts lists the entry counts of t's directories, depth first.
+func cacheCounts(t *CacheTree) []int {
+	counts := []int{t.EntryCount}
+	for _, s := range t.Subtrees {
+		counts = append(counts, cacheCounts(s)...)
+	}
+	return counts
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCacheTree(t *testing.T) {
+	workTree, gitDir := testWorkTree(t, map[string]string{
+		"a/b/c.txt": "c\n",
+		"a/d.txt":   "d\n",
+		"ab/e.txt":  "e\n",
+		"z.txt":     "z\n",
+	})
+	idx := stageAll(t, gitDir, workTree)
+	root, err := idx.WriteTree(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Subtrees go shortest name first: root, a, a/b, ab.
+	if got, want := cacheCounts(idx.Tree), []int{4, 2, 1, 1}; !equalInts(got, want) {
+		t.Errorf("counts = %v, want %v", got, want)
+	}
+	if idx.Tree.Hash != root {
+		t.Errorf("root hash %s, want %s", idx.Tree.Hash, root)
+	}
+
+	// The extension survives a round trip through the file.
+	if err := WriteIndex(gitDir, idx); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadIndex(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Tree == nil || read.Tree.Hash != root || !equalInts(cacheCounts(read.Tree), []int{4, 2, 1, 1}) {
+		t.Fatalf("read back %+v", read.Tree)
+	}
+
+	// Changing a/b/c.txt invalidates a/b and the directories above it,
+	// but not ab.
+	writeFile(t, workTree, "a/b/c.txt", "changed\n")
+	if err := read.AddPath(gitDir, workTree, "a/b/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cacheCounts(read.Tree), []int{-1, -1, -1, 1}; !equalInts(got, want) {
+		t.Errorf("after add: counts = %v, want %v", got, want)
+	}
+
+	// A valid directory's hash is used without looking at its entries:
+	// pointing ab's at another tree shows up in the root.
+	ab := read.Tree.sub("ab")
+	ab.Hash = read.Tree.sub("a").sub("b").Hash
+	got, err := read.WriteTree(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := object.ReadTree(gitDir, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[1].Name != "ab" || entries[1].Hash != ab.Hash {
+		t.Errorf("root entries %+v: ab not taken from the cache", entries)
+	}
+	if got, want := cacheCounts(read.Tree), []int{4, 2, 1, 1}; !equalInts(got, want) {
+		t.Errorf("after write: counts = %v, want %v", got, want)
+	}
+
+	// Removing a directory's last entry drops it from the cache.
+	read.removeTree("ab")
+	if _, err := read.WriteTree(gitDir); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cacheCounts(read.Tree), []int{3, 2, 1}; !equalInts(got, want) {
+		t.Errorf("after remove: counts = %v, want %v", got, want)
+	}
+}
+
+func TestCacheTree_IntentToAdd(t *testing.T) {
+	_, gitDir := testWorkTree(t, nil)
+	idx := New()
+	idx.Add(testEntry("a/x.txt"))
+	ita := testEntry("a/b/later.txt")
+	ita.IntentToAdd = true
+	idx.Add(ita)
+	if _, err := idx.WriteTree(gitDir); err != nil {
+		t.Fatal(err)
+	}
+	// a holds an intent-to-add entry, so it and the root stay invalid,
+	// and a/b, which holds nothing else, isn't cached at all.
+	if got, want := cacheCounts(idx.Tree), []int{-1, -1}; !equalInts(got, want) {
+		t.Errorf("counts = %v, want %v", got, want)
+	}
+}
+
+func TestFromTree_PrimesCacheTree(t *testing.T) {
+	workTree, gitDir := testWorkTree(t, map[string]string{"a/b.txt": "b\n", "c.txt": "c\n"})
+	root, err := stageAll(t, gitDir, workTree).WriteTree(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := FromTree(gitDir, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Tree.Hash != root || !equalInts(cacheCounts(idx.Tree), []int{2, 1}) {
+		t.Errorf("cached tree %+v", idx.Tree)
+	}
+}
@@ -0,0 +1,210 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// ErrWouldOverwrite is returned by Checkout when switching trees would
+// lose staged, unstaged, or untracked changes. The error lists the paths.
+var ErrWouldOverwrite = errors.New("local changes would be overwritten by checkout")
+
+// Checkout moves the index and the working tree at workTree from oldTree
+// (HEAD's tree, "" if there is none) to newTree. Paths that are the same
+// in both trees are left alone, carrying any local changes across; the
+// rest are written from newTree or, if newTree lacks them, deleted along
+// with directories they leave empty. Unless force is set, nothing is
+// touched if a path that differs has local changes, the way git checkout
+// refuses; with force, every tracked path is reset to newTree. Files are
+// passed through their smudge filters on the way out.
+//
+// The index is updated in memory; the caller writes it.
+func (idx *Index) Checkout(gitDir, workTree, oldTree, newTree string, force bool) error {
+	old, err := flatTree(gitDir, oldTree)
+	if err != nil {
+		return err
+	}
+	next, err := flatTree(gitDir, newTree)
+	if err != nil {
+		return err
+	}
+	filters, err := LoadFilters(gitDir, workTree)
+	if err != nil {
+		return err
+	}
+
+	var remove, write []string
+	if force {
+		tracked := make(map[string]bool)
+		for _, e := range idx.Entries {
+			tracked[e.Path] = true
+		}
+		for p := range old {
+			tracked[p] = true
+		}
+		for p := range tracked {
+			if _, ok := next[p]; !ok {
+				remove = append(remove, p)
+			}
+		}
+		for p := range next {
+			write = append(write, p)
+		}
+		idx.Entries, idx.Tree = nil, nil
+	} else {
+		for p, te := range old {
+			if nte, ok := next[p]; !ok {
+				remove = append(remove, p)
+			} else if nte != te {
+				write = append(write, p)
+			}
+		}
+		for p := range next {
+			if _, ok := old[p]; !ok {
+				write = append(write, p)
+			}
+		}
+		if err := idx.checkOverwrite(gitDir, workTree, filters, old, next, append(remove, write...)); err != nil {
+			return err
+		}
+	}
+	sort.Strings(remove)
+	sort.Strings(write)
+
+	// Deletions go first so a file can take the place of a directory
+	// that is going away, and the other way around.
+	for _, p := range remove {
+		// A path already gone from the index, as after rev rm --cached,
+		// is untracked now and stays on disk.
+		if idx.Remove(p) || force {
+			if err := RemoveFile(workTree, p); err != nil {
+				return err
+			}
+		}
+	}
+	for _, p := range write {
+		te := next[p]
+		full := filepath.Join(workTree, filepath.FromSlash(p))
+		if err := filters.materialize(gitDir, te.Hash, full, p, te.Mode); err != nil {
+			return err
+		}
+		info, err := os.Lstat(full)
+		if err != nil {
+			return err
+		}
+		e := &Entry{Mode: te.Mode, Hash: te.Hash, Path: p}
+		fillStat(e, info)
+		idx.removeTree(p)
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			idx.Remove(dir)
+		}
+		idx.Add(e)
+	}
+	return nil
+}
+
+// checkOverwrite returns ErrWouldOverwrite if moving any of paths from
+// old to next would lose work: an index entry matching neither tree, a
+// conflict, a tracked file edited since it was staged, or an untracked
+// file in the way of a new one.
+func (idx *Index) checkOverwrite(gitDir, workTree string, filters *Filters, old, next map[string]object.TreeEntry, paths []string) error {
+	var indexTime time.Time
+	if info, err := os.Stat(Path(gitDir)); err == nil {
+		indexTime = info.ModTime()
+	}
+
+	conflicted := make(map[string]bool)
+	for _, e := range idx.Entries {
+		if e.Stage != 0 {
+			conflicted[e.Path] = true
+		}
+	}
+
+	var blocked []string
+	for _, p := range paths {
+		o, inOld := old[p]
+		n, inNext := next[p]
+		full := filepath.Join(workTree, filepath.FromSlash(p))
+		e := idx.Find(p)
+
+		if conflicted[p] || !matches(e, o, inOld) && !matches(e, n, inNext) {
+			blocked = append(blocked, p)
+			continue
+		}
+		if e != nil {
+			_, changed, err := e.workTreeChange(filters, full, indexTime)
+			if err != nil {
+				return err
+			}
+			if changed {
+				blocked = append(blocked, p)
+			}
+			continue
+		}
+		if !inNext {
+			continue
+		}
+		sha, mode, err := filters.HashFile(full, p)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil || sha != n.Hash || mode != n.Mode {
+			blocked = append(blocked, p)
+		}
+	}
+	if len(blocked) == 0 {
+		return nil
+	}
+	sort.Strings(blocked)
+	return fmt.Errorf("%w:\n\t%s", ErrWouldOverwrite, strings.Join(blocked, "\n\t"))
+}
+
+// matches reports whether the index entry e (nil if absent) holds the
+// same thing as te, or is absent where te is.
+func matches(e *Entry, te object.TreeEntry, ok bool) bool {
+	if e == nil || !ok {
+		return e == nil && !ok
+	}
+	return e.Mode == te.Mode && e.Hash == te.Hash
+}
+
+// flatTree returns the files of tree by path, or nothing for "".
+func flatTree(gitDir, tree string) (map[string]object.TreeEntry, error) {
+	files := make(map[string]object.TreeEntry)
+	if tree == "" {
+		return files, nil
+	}
+	flat, err := object.FlattenTree(gitDir, tree)
+	if err != nil {
+		return nil, err
+	}
+	for _, te := range flat {
+		files[te.Name] = te
+	}
+	return files, nil
+}
+
+// RemoveFile deletes rel from the working tree, then any parent
+// directories that leaves empty, stopping at workTree. A file that is
+// already gone is not an error.
+func RemoveFile(workTree, rel string) error {
+	full := filepath.Join(workTree, filepath.FromSlash(rel))
+	if err := os.Remove(full); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for dir := filepath.Dir(full); dir != workTree && strings.HasPrefix(dir, workTree); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
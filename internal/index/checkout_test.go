@@ -0,0 +1,136 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// checkoutTrees stages two versions of a working tree, returning their
+// trees with the first version checked out and its index written.
+func checkoutTrees(t *testing.T) (workTree, gitDir, oldTree, newTree string) {
+	t.Helper()
+	workTree, gitDir = testWorkTree(t, map[string]string{"same": "same\n", "changed": "v2\n", "added": "added\n"})
+	newTree = treeFromIndex(t, gitDir, stageAll(t, gitDir, workTree))
+
+	os.Remove(filepath.Join(workTree, "added"))
+	writeFile(t, workTree, "changed", "v1\n")
+	writeFile(t, workTree, "removed", "removed\n")
+	oldTree = treeFromIndex(t, gitDir, stageAll(t, gitDir, workTree))
+	return workTree, gitDir, oldTree, newTree
+}
+
+func readWorkFile(t *testing.T, workTree, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(workTree, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCheckout(t *testing.T) {
+	workTree, gitDir, oldTree, newTree := checkoutTrees(t)
+	idx, err := ReadIndex(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An edit to a file both trees agree on is carried across.
+	writeFile(t, workTree, "same", "local edit\n")
+
+	if err := idx.Checkout(gitDir, workTree, oldTree, newTree, false); err != nil {
+		t.Fatalf("Checkout() error: %v", err)
+	}
+	if got := paths(idx); got != "added changed same" {
+		t.Errorf("index = %q", got)
+	}
+	if got := readWorkFile(t, workTree, "changed"); got != "v2\n" {
+		t.Errorf("changed = %q, want v2", got)
+	}
+	if got := readWorkFile(t, workTree, "same"); got != "local edit\n" {
+		t.Errorf("same = %q, local edit lost", got)
+	}
+	if _, err := os.Lstat(filepath.Join(workTree, "removed")); !os.IsNotExist(err) {
+		t.Errorf("removed still on disk: %v", err)
+	}
+	if err := WriteIndex(gitDir, idx); err != nil {
+		t.Fatal(err)
+	}
+	st, err := idx.Status(gitDir, workTree, newTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := changes(st.Unstaged); len(got) != 1 || got[0] != "modified same" || len(st.Staged) != 0 {
+		t.Errorf("status after checkout: %+v", st)
+	}
+}
+
+func TestCheckout_Refuses(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		setup func(t *testing.T, idx *Index, gitDir, workTree string)
+	}{
+		{"unstaged", func(t *testing.T, idx *Index, gitDir, workTree string) {
+			writeFile(t, workTree, "changed", "local\n")
+		}},
+		{"staged", func(t *testing.T, idx *Index, gitDir, workTree string) {
+			writeFile(t, workTree, "removed", "local\n")
+			if err := idx.AddPath(gitDir, workTree, "removed"); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"untracked", func(t *testing.T, idx *Index, gitDir, workTree string) {
+			writeFile(t, workTree, "added", "in the way\n")
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			workTree, gitDir, oldTree, newTree := checkoutTrees(t)
+			idx, err := ReadIndex(gitDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.setup(t, idx, gitDir, workTree)
+			before := paths(idx)
+
+			err = idx.Checkout(gitDir, workTree, oldTree, newTree, false)
+			if !errors.Is(err, ErrWouldOverwrite) {
+				t.Fatalf("Checkout() = %v, want ErrWouldOverwrite", err)
+			}
+			if got := paths(idx); got != before {
+				t.Errorf("index changed on refusal: %q", got)
+			}
+			if got := readWorkFile(t, workTree, "changed"); got == "v2\n" {
+				t.Error("working tree changed on refusal")
+			}
+
+			if err := idx.Checkout(gitDir, workTree, oldTree, newTree, true); err != nil {
+				t.Fatalf("forced Checkout() error: %v", err)
+			}
+			for name, want := range map[string]string{"changed": "v2\n", "added": "added\n"} {
+				if got := readWorkFile(t, workTree, name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if got := paths(idx); got != "added changed same" {
+				t.Errorf("index after force = %q", got)
+			}
+		})
+	}
+}
+
+func TestRemoveFile(t *testing.T) {
+	workTree, _ := testWorkTree(t, map[string]string{"a/b/c": "c\n", "a/d": "d\n"})
+	if err := RemoveFile(workTree, "a/b/c"); err != nil {
+		t.Fatalf("RemoveFile() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workTree, "a", "b")); !os.IsNotExist(err) {
+		t.Error("empty directory a/b left behind")
+	}
+	if _, err := os.Stat(filepath.Join(workTree, "a", "d")); err != nil {
+		t.Errorf("sibling removed: %v", err)
+	}
+	if err := RemoveFile(workTree, "a/b/c"); err != nil {
+		t.Errorf("RemoveFile() of missing file: %v", err)
+	}
+}
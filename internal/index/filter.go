@@ -0,0 +1,143 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/elliota43/rev/internal/attr"
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/repository"
+)
+
+// Filters runs the clean and smudge commands of the filter drivers that
+// .gitattributes assigns to paths with filter=<driver>. A driver is
+// configured as filter.<driver>.clean, which turns working-tree content
+// into what is stored, and filter.<driver>.smudge, which does the
+// reverse. A nil *Filters leaves content alone.
+type Filters struct {
+	workTree string
+	attrs    *attr.Matcher
+	cfg      *repository.Config
+	// algo is the repository's object format, which HashFile hashes with.
+	algo object.Algorithm
+}
+
+// LoadFilters reads the attributes and configuration of the repository
+// at gitDir with its working tree at workTree.
+func LoadFilters(gitDir, workTree string) (*Filters, error) {
+	attrs, err := attr.Load(gitDir, workTree)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := repository.ParseConfig(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	algo, err := repository.ObjectFormat(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Filters{workTree: workTree, attrs: attrs, cfg: cfg, algo: algo}, nil
+}
+
+// Clean returns data, the content of the working-tree file rel, as it
+// should be stored.
+func (f *Filters) Clean(rel string, data []byte) ([]byte, error) {
+	return f.run(rel, "clean", data)
+}
+
+// Smudge returns data, a blob stored for rel, as it should be written to
+// the working tree.
+func (f *Filters) Smudge(rel string, data []byte) ([]byte, error) {
+	return f.run(rel, "smudge", data)
+}
+
+// run passes data through the kind command of rel's filter driver. As in
+// git, "%f" in the command is replaced by the quoted path, and a driver
+// whose command is missing or fails leaves data as it is, unless
+// filter.<driver>.required is set.
+func (f *Filters) run(rel, kind string, data []byte) ([]byte, error) {
+	if f == nil {
+		return data, nil
+	}
+	driver := f.attrs.Get(rel, "filter")
+	if driver == "" || driver == attr.Set || driver == attr.Unset {
+		return data, nil
+	}
+	required, _, err := f.cfg.GetBool("filter."+driver, "required")
+	if err != nil {
+		return nil, err
+	}
+	command, ok := f.cfg.Get("filter."+driver, kind)
+	if !ok || command == "" {
+		if required {
+			return nil, fmt.Errorf("%s: %s filter '%s' is required but not configured", rel, kind, driver)
+		}
+		return data, nil
+	}
+
+	command = strings.ReplaceAll(command, "%f", "'"+strings.ReplaceAll(rel, "'", `'\''`)+"'")
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = f.workTree
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if required {
+			return nil, fmt.Errorf("%s: %s filter '%s' failed: %w", rel, kind, driver, err)
+		}
+		fmt.Fprintf(os.Stderr, "error: external filter '%s' failed\n", command)
+		return data, nil
+	}
+	return out, nil
+}
+
+// ReadWorkFile is the package's ReadWorkFile for the working-tree file
+// rel, with its content cleaned by its filter driver. A symlink's target
+// isn't filtered.
+func (f *Filters) ReadWorkFile(full, rel string) ([]byte, uint32, error) {
+	data, mode, err := ReadWorkFile(full)
+	if err != nil || mode == object.ModeSymlink {
+		return data, mode, err
+	}
+	data, err = f.Clean(rel, data)
+	return data, mode, err
+}
+
+// HashFile is the package's HashFile for the working-tree file rel, with
+// its content cleaned by its filter driver and hashed in the repository's
+// object format.
+func (f *Filters) HashFile(full, rel string) (string, uint32, error) {
+	data, mode, err := f.ReadWorkFile(full, rel)
+	if err != nil {
+		return "", 0, err
+	}
+	algo := object.SHA1
+	if f != nil {
+		algo = f.algo
+	}
+	return object.HashBytesWith(algo, []byte(object.Header(object.TypeBlob, int64(len(data)))+string(data))), mode, nil
+}
+
+// materialize is object.MaterializeBlob with the blob smudged by rel's
+// filter driver on its way to the working tree.
+func (f *Filters) materialize(gitDir, sha, full, rel string, mode uint32) error {
+	if f == nil || mode == object.ModeSymlink {
+		return object.MaterializeBlob(gitDir, sha, full, mode)
+	}
+	obj, err := object.Read(gitDir, sha)
+	if err != nil {
+		return err
+	}
+	if obj.Type != object.TypeBlob {
+		return fmt.Errorf("materializing %s: object %s is a %s, not a blob", full, obj.Hash, obj.Type)
+	}
+	data, err := f.Smudge(rel, obj.Body)
+	if err != nil {
+		return err
+	}
+	return object.MaterializeData(data, full, mode)
+}
@@ -0,0 +1,87 @@
+//go:build unix
+
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// filterWorkTree sets up a working tree whose *.up files go through a
+// filter driver that stores them upper-cased and checks them out
+// lower-cased.
+func filterWorkTree(t *testing.T, files map[string]string) (workTree, gitDir string) {
+	t.Helper()
+	workTree, gitDir = testWorkTree(t, files)
+	writeFile(t, workTree, ".gitattributes", "*.up filter=up\n")
+	writeFile(t, gitDir, "config", "[filter \"up\"]\n\tclean = tr a-z A-Z\n\tsmudge = tr A-Z a-z\n")
+	return workTree, gitDir
+}
+
+func TestFilters(t *testing.T) {
+	workTree, gitDir := filterWorkTree(t, map[string]string{"a.up": "hello\n", "plain": "hello\n"})
+
+	// add stores the cleaned content; files without the attribute are
+	// stored as they are.
+	idx := stageAll(t, gitDir, workTree)
+	for name, want := range map[string]string{"a.up": "HELLO\n", "plain": "hello\n"} {
+		obj, err := object.Read(gitDir, idx.Find(name).Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(obj.Body) != want {
+			t.Errorf("%s stored as %q, want %q", name, obj.Body, want)
+		}
+	}
+
+	// status compares the cleaned file, so a file that cleans to the same
+	// blob is unchanged.
+	tree := treeFromIndex(t, gitDir, idx)
+	writeFile(t, workTree, "a.up", "HeLLo\n")
+	st, err := idx.Status(gitDir, workTree, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Unstaged) != 0 {
+		t.Errorf("unstaged: %v", changes(st.Unstaged))
+	}
+
+	// Restore and Checkout smudge what they write.
+	if err := idx.Restore(gitDir, workTree, map[string]object.TreeEntry{"a.up": {Mode: object.ModeFile, Hash: idx.Find("a.up").Hash}}, []string{"a.up"}, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWorkFile(t, workTree, "a.up"); got != "hello\n" {
+		t.Errorf("restored a.up = %q", got)
+	}
+	os.Remove(filepath.Join(workTree, "a.up"))
+	if err := idx.Checkout(gitDir, workTree, "", tree, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWorkFile(t, workTree, "a.up"); got != "hello\n" {
+		t.Errorf("checked out a.up = %q", got)
+	}
+}
+
+func TestFilters_Failing(t *testing.T) {
+	workTree, gitDir := filterWorkTree(t, map[string]string{"a.up": "hello\n"})
+	writeFile(t, gitDir, "config", "[filter \"up\"]\n\tclean = false\n")
+	filters, err := LoadFilters(gitDir, workTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failing filter leaves the content alone, unless it is required.
+	if got, err := filters.Clean("a.up", []byte("x")); string(got) != "x" || err != nil {
+		t.Errorf("Clean() = %q, %v", got, err)
+	}
+	filters.cfg.Set("filter.up", "required", "true")
+	if _, err := filters.Clean("a.up", []byte("x")); err == nil {
+		t.Error("Clean() with a required filter failing: no error")
+	}
+	if _, err := filters.Smudge("a.up", []byte("x")); err == nil {
+		t.Error("Smudge() with a required filter missing: no error")
+	}
+}
@@ -0,0 +1,440 @@
+// Package index reads and writes the git index (.git/index), the staging
+// area that records what the next commit will contain.
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/repository"
+)
+
+// ErrCorrupt is returned (wrapped) when the index file can't be parsed or
+// its checksum doesn't match.
+var ErrCorrupt = errors.New("corrupt index")
+
+var indexSignature = []byte("DIRC")
+
+// Flag bits in an entry's 16-bit flags and, from version 3 on, its
+// 16-bit extended flags.
+const (
+	flagAssumeValid  = 0x8000
+	flagExtended     = 0x4000
+	flagStageShift   = 12
+	flagStageMask    = 0x3000
+	flagNameMask     = 0x0fff
+	flagSkipWorktree = 0x4000
+	flagIntentToAdd  = 0x2000
+)
+
+// entryFixedLen is the size of an entry before its path: ten 32-bit stat
+// fields, the object name, 20 bytes for SHA-1 and 32 for SHA-256, and
+// the 16-bit flags.
+func entryFixedLen(algo object.Algorithm) int {
+	return 10*4 + algo.Size() + 2
+}
+
+// Entry is one staged path.
+type Entry struct {
+	CTime time.Time
+	MTime time.Time
+	Dev   uint32
+	Ino   uint32
+	// Mode is the git file mode: 0100644, 0100755, 0120000 (symlink), or
+	// 0160000 (gitlink).
+	Mode uint32
+	UID  uint32
+	GID  uint32
+	// Size is the file size truncated to 32 bits, as git stores it.
+	Size uint32
+	Hash string
+	// Stage is 0 for a normal entry and 1-3 (base, ours, theirs) for the
+	// sides of an unresolved merge conflict.
+	Stage       int
+	AssumeValid bool
+	// SkipWorktree and IntentToAdd are extended flags, which need index
+	// version 3.
+	SkipWorktree bool
+	IntentToAdd  bool
+	// Path is slash-separated and relative to the top of the working tree.
+	Path string
+}
+
+// Index is the parsed contents of an index file. Entries are kept sorted
+// by path, then stage.
+type Index struct {
+	Version uint32
+	// Algorithm is the repository's object format. It sets the length of
+	// the object names the index stores and of its checksum.
+	Algorithm object.Algorithm
+	Entries   []*Entry
+	// Tree is the cached-tree extension, or nil if there is none. Add and
+	// Remove keep it up to date; code that sets Entries directly must
+	// clear it.
+	Tree *CacheTree
+}
+
+// New returns an empty version 2 index for a SHA-1 repository.
+func New() *Index {
+	return &Index{Version: 2}
+}
+
+// Path returns the location of the index file in gitDir.
+func Path(gitDir string) string {
+	return filepath.Join(gitDir, "index")
+}
+
+// ReadIndex reads <gitDir>/index, in the repository's object format. A
+// repository with no index yet (nothing ever staged) gets an empty one.
+// Of the optional extensions, only the cached tree is kept; the rest are
+// dropped when the index is rewritten.
+func ReadIndex(gitDir string) (*Index, error) {
+	algo, err := repository.ObjectFormat(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(Path(gitDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Index{Version: 2, Algorithm: algo}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+	return DecodeWith(algo, data)
+}
+
+// WriteIndex writes idx to <gitDir>/index. It goes through index.lock,
+// renamed into place, so readers never see a partial index and two
+// writers can't both succeed.
+func WriteIndex(gitDir string, idx *Index) error {
+	data, err := idx.Encode()
+	if err != nil {
+		return err
+	}
+
+	path := Path(gitDir)
+	lockPath := path + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("writing index: %s exists; another process may be writing it", lockPath)
+		}
+		return fmt.Errorf("locking index: %w", err)
+	}
+
+	_, err = lock.Write(data)
+	if closeErr := lock.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(lockPath, path)
+	}
+	if err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("writing index: %w", err)
+	}
+	return nil
+}
+
+// Decode parses the bytes of a SHA-1 repository's index file (versions
+// 2 and 3).
+func Decode(data []byte) (*Index, error) {
+	return DecodeWith(object.SHA1, data)
+}
+
+// DecodeWith is Decode for an index of the given object format.
+func DecodeWith(algo object.Algorithm, data []byte) (*Index, error) {
+	sumLen := algo.Size()
+	if len(data) < 12+sumLen {
+		return nil, corrupt("file is truncated")
+	}
+	body, trailer := data[:len(data)-sumLen], data[len(data)-sumLen:]
+	if sum := checksum(algo, body); !bytes.Equal(sum, trailer) {
+		return nil, corrupt("checksum mismatch")
+	}
+	if !bytes.Equal(body[:4], indexSignature) {
+		return nil, corrupt("bad signature %q", body[:4])
+	}
+	idx := &Index{Version: binary.BigEndian.Uint32(body[4:8]), Algorithm: algo}
+	if idx.Version != 2 && idx.Version != 3 {
+		return nil, fmt.Errorf("unsupported index version %d", idx.Version)
+	}
+	count := binary.BigEndian.Uint32(body[8:12])
+
+	pos := 12
+	idx.Entries = make([]*Entry, 0, count)
+	for i := range count {
+		e, n, err := decodeEntry(body[pos:], idx.Version, algo)
+		if err != nil {
+			return nil, corrupt("entry %d: %v", i, err)
+		}
+		idx.Entries = append(idx.Entries, e)
+		pos += n
+	}
+
+	for pos < len(body) {
+		if len(body)-pos < 8 {
+			return nil, corrupt("truncated extension header")
+		}
+		sig := body[pos : pos+4]
+		size := int(binary.BigEndian.Uint32(body[pos+4:]))
+		if size > len(body)-pos-8 {
+			return nil, corrupt("extension %q overruns the file", sig)
+		}
+		// Extensions with an upper-case signature are optional caches;
+		// anything else must be understood to read the index correctly.
+		if sig[0] < 'A' || sig[0] > 'Z' {
+			return nil, fmt.Errorf("unsupported index extension %q", sig)
+		}
+		if string(sig) == "TREE" {
+			var err error
+			if idx.Tree, err = decodeCacheTree(body[pos+8:pos+8+size], algo); err != nil {
+				return nil, corrupt("cached tree: %v", err)
+			}
+		}
+		pos += 8 + size
+	}
+
+	if !sort.SliceIsSorted(idx.Entries, func(i, j int) bool { return less(idx.Entries[i], idx.Entries[j]) }) {
+		return nil, corrupt("entries are not sorted")
+	}
+	return idx, nil
+}
+
+// decodeEntry parses the entry at the start of data and returns it with
+// its length, padding included.
+func decodeEntry(data []byte, version uint32, algo object.Algorithm) (*Entry, int, error) {
+	fixedLen := entryFixedLen(algo)
+	if len(data) < fixedLen {
+		return nil, 0, fmt.Errorf("truncated")
+	}
+	u32 := func(i int) uint32 { return binary.BigEndian.Uint32(data[i*4:]) }
+	e := &Entry{
+		CTime: decodeTime(u32(0), u32(1)),
+		MTime: decodeTime(u32(2), u32(3)),
+		Dev:   u32(4),
+		Ino:   u32(5),
+		Mode:  u32(6),
+		UID:   u32(7),
+		GID:   u32(8),
+		Size:  u32(9),
+		Hash:  hex.EncodeToString(data[40 : fixedLen-2]),
+	}
+	flags := binary.BigEndian.Uint16(data[fixedLen-2:])
+	e.AssumeValid = flags&flagAssumeValid != 0
+	e.Stage = int(flags&flagStageMask) >> flagStageShift
+
+	pos := fixedLen
+	if flags&flagExtended != 0 {
+		if version < 3 {
+			return nil, 0, fmt.Errorf("extended flags in a version %d index", version)
+		}
+		if len(data) < pos+2 {
+			return nil, 0, fmt.Errorf("truncated")
+		}
+		ext := binary.BigEndian.Uint16(data[pos:])
+		e.SkipWorktree = ext&flagSkipWorktree != 0
+		e.IntentToAdd = ext&flagIntentToAdd != 0
+		pos += 2
+	}
+
+	// The name length is in the flags unless it is too long to fit, in
+	// which case the path runs to the first NUL.
+	nameLen := int(flags & flagNameMask)
+	if nameLen == flagNameMask {
+		nul := bytes.IndexByte(data[pos:], 0)
+		if nul < 0 {
+			return nil, 0, fmt.Errorf("unterminated path")
+		}
+		nameLen = nul
+	}
+	if len(data) < pos+nameLen+1 || data[pos+nameLen] != 0 {
+		return nil, 0, fmt.Errorf("truncated path")
+	}
+	e.Path = string(data[pos : pos+nameLen])
+
+	n := paddedLen(pos + nameLen)
+	if len(data) < n {
+		return nil, 0, fmt.Errorf("truncated padding")
+	}
+	return e, n, nil
+}
+
+// Encode returns idx in the on-disk format, sorting the entries first.
+// The version is raised to 3 if any entry has extended flags set.
+func (idx *Index) Encode() ([]byte, error) {
+	idx.sort()
+	version := idx.Version
+	if version < 2 {
+		version = 2
+	}
+	for _, e := range idx.Entries {
+		if e.SkipWorktree || e.IntentToAdd {
+			version = max(version, 3)
+		}
+	}
+	if version > 3 {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(indexSignature)
+	binary.Write(&buf, binary.BigEndian, version)
+	binary.Write(&buf, binary.BigEndian, uint32(len(idx.Entries)))
+	for _, e := range idx.Entries {
+		if err := encodeEntry(&buf, e, idx.Algorithm); err != nil {
+			return nil, err
+		}
+	}
+	if idx.Tree != nil {
+		var ext bytes.Buffer
+		if err := idx.Tree.encode(&ext, idx.Algorithm); err != nil {
+			return nil, err
+		}
+		buf.WriteString("TREE")
+		binary.Write(&buf, binary.BigEndian, uint32(ext.Len()))
+		buf.Write(ext.Bytes())
+	}
+	buf.Write(checksum(idx.Algorithm, buf.Bytes()))
+	return buf.Bytes(), nil
+}
+
+// checksum is the trailer of an index file whose content is data, its
+// hash under the repository's object format.
+func checksum(algo object.Algorithm, data []byte) []byte {
+	raw, _ := hex.DecodeString(object.HashBytesWith(algo, data))
+	return raw
+}
+
+func encodeEntry(buf *bytes.Buffer, e *Entry, algo object.Algorithm) error {
+	name, err := hex.DecodeString(e.Hash)
+	if err != nil || len(name) != algo.Size() {
+		return fmt.Errorf("index entry %s: invalid object name %q", e.Path, e.Hash)
+	}
+	if e.Stage < 0 || e.Stage > 3 {
+		return fmt.Errorf("index entry %s: invalid stage %d", e.Path, e.Stage)
+	}
+
+	start := buf.Len()
+	cs, cns := encodeTime(e.CTime)
+	ms, mns := encodeTime(e.MTime)
+	for _, v := range []uint32{cs, cns, ms, mns, e.Dev, e.Ino, e.Mode, e.UID, e.GID, e.Size} {
+		binary.Write(buf, binary.BigEndian, v)
+	}
+	buf.Write(name)
+
+	flags := uint16(min(len(e.Path), flagNameMask)) | uint16(e.Stage)<<flagStageShift
+	if e.AssumeValid {
+		flags |= flagAssumeValid
+	}
+	var ext uint16
+	if e.SkipWorktree {
+		ext |= flagSkipWorktree
+	}
+	if e.IntentToAdd {
+		ext |= flagIntentToAdd
+	}
+	if ext != 0 {
+		flags |= flagExtended
+	}
+	binary.Write(buf, binary.BigEndian, flags)
+	if ext != 0 {
+		binary.Write(buf, binary.BigEndian, ext)
+	}
+	buf.WriteString(e.Path)
+
+	// Pad with 1-8 NULs to a multiple of eight bytes; the first NUL also
+	// terminates the path.
+	n := buf.Len() - start
+	buf.Write(make([]byte, paddedLen(n)-n))
+	return nil
+}
+
+// paddedLen returns the length of an entry of n bytes (up to the end of
+// its path) once NUL-padded.
+func paddedLen(n int) int {
+	return (n + 8) &^ 7
+}
+
+func decodeTime(sec, nsec uint32) time.Time {
+	if sec == 0 && nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+func encodeTime(t time.Time) (uint32, uint32) {
+	if t.IsZero() {
+		return 0, 0
+	}
+	return uint32(t.Unix()), uint32(t.Nanosecond())
+}
+
+// less orders entries by path, compared bytewise, then by stage.
+func less(a, b *Entry) bool {
+	if a.Path != b.Path {
+		return a.Path < b.Path
+	}
+	return a.Stage < b.Stage
+}
+
+func (idx *Index) sort() {
+	sort.SliceStable(idx.Entries, func(i, j int) bool { return less(idx.Entries[i], idx.Entries[j]) })
+}
+
+// search returns the position of the first entry at or after path and
+// stage.
+func (idx *Index) search(path string, stage int) int {
+	key := &Entry{Path: path, Stage: stage}
+	return sort.Search(len(idx.Entries), func(i int) bool { return !less(idx.Entries[i], key) })
+}
+
+// Find returns the stage-0 entry for path, or nil if it isn't staged.
+func (idx *Index) Find(path string) *Entry {
+	i := idx.search(path, 0)
+	if i < len(idx.Entries) && idx.Entries[i].Path == path && idx.Entries[i].Stage == 0 {
+		return idx.Entries[i]
+	}
+	return nil
+}
+
+// Add inserts e in sorted position, replacing any entry with the same
+// path and stage.
+func (idx *Index) Add(e *Entry) {
+	idx.Tree.invalidate(e.Path)
+	i := idx.search(e.Path, e.Stage)
+	if i < len(idx.Entries) && idx.Entries[i].Path == e.Path && idx.Entries[i].Stage == e.Stage {
+		idx.Entries[i] = e
+		return
+	}
+	idx.Entries = append(idx.Entries, nil)
+	copy(idx.Entries[i+1:], idx.Entries[i:])
+	idx.Entries[i] = e
+}
+
+// Remove deletes every entry for path, at any stage, and reports whether
+// there were any.
+func (idx *Index) Remove(path string) bool {
+	i := idx.search(path, 0)
+	j := i
+	for j < len(idx.Entries) && idx.Entries[j].Path == path {
+		j++
+	}
+	idx.Entries = append(idx.Entries[:i], idx.Entries[j:]...)
+	if j > i {
+		idx.Tree.invalidate(path)
+	}
+	return j > i
+}
+
+func corrupt(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,225 @@
+package index
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+const blobHash = "ce013625030ba8dba906f756967f9e9ca394464a"
+
+func testEntry(path string) *Entry {
+	return &Entry{
+		CTime: time.Unix(1700000000, 123),
+		MTime: time.Unix(1700000001, 456),
+		Dev:   1,
+		Ino:   2,
+		Mode:  0100644,
+		UID:   1000,
+		GID:   1000,
+		Size:  6,
+		Hash:  blobHash,
+		Path:  path,
+	}
+}
+
+func TestWriteAndReadIndex(t *testing.T) {
+	gitDir := t.TempDir()
+
+	idx := New()
+	for _, p := range []string{"b.txt", "a/z.txt", "a.txt", "a/b/c.txt"} {
+		idx.Add(testEntry(p))
+	}
+	if err := WriteIndex(gitDir, idx); err != nil {
+		t.Fatalf("WriteIndex() error: %v", err)
+	}
+
+	got, err := ReadIndex(gitDir)
+	if err != nil {
+		t.Fatalf("ReadIndex() error: %v", err)
+	}
+	if got.Version != 2 {
+		t.Errorf("Version = %d, want 2", got.Version)
+	}
+	var paths []string
+	for _, e := range got.Entries {
+		paths = append(paths, e.Path)
+	}
+	// Bytewise order puts "a.txt" before "a/..." since '.' < '/'.
+	want := "a.txt a/b/c.txt a/z.txt b.txt"
+	if strings.Join(paths, " ") != want {
+		t.Errorf("paths = %v, want %s", paths, want)
+	}
+	if e, w := got.Entries[0], testEntry("a.txt"); *e != *w {
+		t.Errorf("entry round trip:\n got %+v\nwant %+v", *e, *w)
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "index.lock")); !os.IsNotExist(err) {
+		t.Errorf("index.lock left behind")
+	}
+}
+
+func TestReadIndex_Missing(t *testing.T) {
+	idx, err := ReadIndex(t.TempDir())
+	if err != nil {
+		t.Fatalf("ReadIndex() error: %v", err)
+	}
+	if len(idx.Entries) != 0 {
+		t.Errorf("got %d entries, want 0", len(idx.Entries))
+	}
+}
+
+func TestWriteIndex_Locked(t *testing.T) {
+	gitDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(gitDir, "index.lock"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteIndex(gitDir, New()); err == nil {
+		t.Error("WriteIndex() with index.lock present: want error")
+	}
+}
+
+func TestEncode_ExtendedFlagsAndLongPath(t *testing.T) {
+	idx := New()
+	long := strings.Repeat("d/", 2100) + "f"
+	e := testEntry(long)
+	e.IntentToAdd = true
+	idx.Add(e)
+	conflict := testEntry("x")
+	conflict.Stage = 2
+	conflict.SkipWorktree = true
+	idx.Add(conflict)
+
+	data, err := idx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode() error: %v", err)
+	}
+	if got.Version != 3 {
+		t.Errorf("Version = %d, want 3 for extended flags", got.Version)
+	}
+	if len(got.Entries) != 2 || *got.Entries[0] != *e || *got.Entries[1] != *conflict {
+		t.Errorf("entries didn't round trip: %+v", got.Entries)
+	}
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	idx := New()
+	idx.Add(testEntry("a.txt"))
+	good, err := idx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	badSum := append([]byte(nil), good...)
+	badSum[20] ^= 0xff
+	if _, err := Decode(badSum); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Decode() with bad checksum: got %v, want ErrCorrupt", err)
+	}
+	if _, err := Decode(good[:10]); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Decode() of truncated index: got %v, want ErrCorrupt", err)
+	}
+}
+
+func TestDecodeWith_SHA256(t *testing.T) {
+	long := strings.Repeat("ab", 32)
+	idx := &Index{Version: 2, Algorithm: object.SHA256}
+	e := testEntry("a/b.txt")
+	e.Hash = long
+	idx.Add(e)
+	idx.Tree = &CacheTree{EntryCount: 1, Hash: long, Subtrees: []*CacheTree{{Name: "a", EntryCount: 1, Hash: long}}}
+	data, err := idx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeWith(object.SHA256, data)
+	if err != nil {
+		t.Fatalf("DecodeWith() error: %v", err)
+	}
+	if len(got.Entries) != 1 || *got.Entries[0] != *e {
+		t.Errorf("entries = %+v, want %+v", got.Entries, *e)
+	}
+	if got.Tree == nil || got.Tree.Hash != long || got.Tree.sub("a") == nil || got.Tree.sub("a").Hash != long {
+		t.Errorf("cached tree = %+v", got.Tree)
+	}
+	if _, err := Decode(data); err == nil {
+		t.Error("Decode() read a SHA-256 index as SHA-1")
+	}
+
+	// A SHA-1 name doesn't fit a SHA-256 index.
+	idx.Add(testEntry("c.txt"))
+	if _, err := idx.Encode(); err == nil {
+		t.Error("Encode() accepted a SHA-1 name in a SHA-256 index")
+	}
+}
+
+// withExtension appends an extension to an encoded index and re-signs it.
+func withExtension(data []byte, sig string, payload []byte) []byte {
+	out := append([]byte(nil), data[:len(data)-sha1.Size]...)
+	out = append(out, sig...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(payload)))
+	out = append(out, payload...)
+	sum := sha1.Sum(out)
+	return append(out, sum[:]...)
+}
+
+func TestDecode_Extensions(t *testing.T) {
+	idx := New()
+	idx.Add(testEntry("a.txt"))
+	data, err := idx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Decode(withExtension(data, "REUC", []byte("resolve undo")))
+	if err != nil {
+		t.Fatalf("Decode() with optional extension: %v", err)
+	}
+	if len(got.Entries) != 1 {
+		t.Errorf("got %d entries, want 1", len(got.Entries))
+	}
+
+	if _, err := Decode(withExtension(data, "link", nil)); err == nil {
+		t.Error("Decode() with required extension: want error")
+	}
+}
+
+func TestAddFindRemove(t *testing.T) {
+	idx := New()
+	idx.Add(testEntry("b"))
+	idx.Add(testEntry("a"))
+
+	replaced := testEntry("a")
+	replaced.Size = 99
+	idx.Add(replaced)
+	if len(idx.Entries) != 2 || idx.Entries[0] != replaced {
+		t.Fatalf("Add() didn't replace in place: %+v", idx.Entries)
+	}
+
+	if idx.Find("a") != replaced || idx.Find("c") != nil {
+		t.Error("Find() returned the wrong entry")
+	}
+
+	stage1 := testEntry("b")
+	stage1.Stage = 1
+	idx.Add(stage1)
+	if idx.Find("b") == stage1 {
+		t.Error("Find() returned a conflict stage")
+	}
+	if !idx.Remove("b") || len(idx.Entries) != 1 {
+		t.Errorf("Remove() should drop every stage of b: %+v", idx.Entries)
+	}
+	if idx.Remove("b") {
+		t.Error("Remove() of absent path reported true")
+	}
+}
@@ -0,0 +1,34 @@
+package index
+
+import (
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/repository"
+)
+
+// FromTree returns an index holding one entry per blob (or gitlink) in
+// the tree hash, with paths from the root, and the tree's hashes as its
+// cached tree. Stat fields are zero, since nothing has been checked out
+// yet; status rehashes such entries rather than trusting them.
+func FromTree(gitDir, hash string) (*Index, error) {
+	algo, err := repository.ObjectFormat(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	flat, err := object.FlattenTree(gitDir, hash)
+	if err != nil {
+		return nil, err
+	}
+	idx := &Index{Version: 2, Algorithm: algo}
+	idx.Entries = make([]*Entry, 0, len(flat))
+	for _, te := range flat {
+		idx.Entries = append(idx.Entries, &Entry{Mode: te.Mode, Hash: te.Hash, Path: te.Name})
+	}
+	// A well-formed tree flattens in index order already, since trees
+	// sort directories as if their names ended in "/", but a tree written
+	// by another tool might not be sorted.
+	idx.sort()
+	if idx.Tree, err = cacheTreeOf(gitDir, "", hash); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
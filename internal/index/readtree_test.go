@@ -0,0 +1,50 @@
+package index
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFromTree(t *testing.T) {
+	workTree, gitDir := testWorkTree(t, map[string]string{
+		"a/b/c.txt": "c\n",
+		"a-b.txt":   "ab\n",
+		"a.txt":     "a\n",
+	})
+	staged := stageAll(t, gitDir, workTree)
+	tree, err := staged.WriteTree(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := FromTree(gitDir, tree)
+	if err != nil {
+		t.Fatalf("FromTree() error: %v", err)
+	}
+	if got, want := paths(idx), paths(staged); got != want {
+		t.Fatalf("paths = %q, want %q", got, want)
+	}
+	for i, e := range idx.Entries {
+		s := staged.Entries[i]
+		if e.Mode != s.Mode || e.Hash != s.Hash {
+			t.Errorf("%s: got %o %s, want %o %s", e.Path, e.Mode, e.Hash, s.Mode, s.Hash)
+		}
+		if e.Size != 0 || !e.MTime.Equal(time.Time{}) {
+			t.Errorf("%s: stat fields not zeroed: %+v", e.Path, *e)
+		}
+	}
+
+	// Zeroed stat data never matches, so the files are rehashed and found
+	// unchanged.
+	st, err := idx.Status(gitDir, workTree, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Clean() {
+		t.Errorf("status after FromTree: %+v", st)
+	}
+
+	if _, err := FromTree(gitDir, idx.Entries[0].Hash); err == nil {
+		t.Error("FromTree(blob): want error")
+	}
+}
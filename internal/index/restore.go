@@ -0,0 +1,61 @@
+package index
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// Restore copies each of paths from source, a snapshot of files by path
+// such as a flattened tree, into the index if staged is set and into the
+// working tree at workTree if worktree is. A path that source lacks is
+// removed instead. Unlike Checkout, nothing is checked first: whatever
+// was at the paths is replaced. Files are passed through their smudge
+// filters on the way out.
+//
+// The index is updated in memory; the caller writes it.
+func (idx *Index) Restore(gitDir, workTree string, source map[string]object.TreeEntry, paths []string, staged, worktree bool) error {
+	filters, err := LoadFilters(gitDir, workTree)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		te, ok := source[p]
+		if staged {
+			if ok {
+				idx.removeTree(p)
+				for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+					idx.Remove(dir)
+				}
+				idx.Add(&Entry{Mode: te.Mode, Hash: te.Hash, Path: p})
+			} else {
+				idx.Remove(p)
+			}
+		}
+		if !worktree {
+			continue
+		}
+		if !ok {
+			if err := RemoveFile(workTree, p); err != nil {
+				return err
+			}
+			continue
+		}
+		full := filepath.Join(workTree, filepath.FromSlash(p))
+		if err := filters.materialize(gitDir, te.Hash, full, p, te.Mode); err != nil {
+			return err
+		}
+		// The file now holds what the index does, so its stat data can be
+		// recorded to keep status from rehashing it.
+		if e := idx.Find(p); e != nil && e.Mode == te.Mode && e.Hash == te.Hash {
+			info, err := os.Lstat(full)
+			if err != nil {
+				return err
+			}
+			fillStat(e, info)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestore(t *testing.T) {
+	workTree, gitDir, _, newTree := checkoutTrees(t)
+	idx, err := ReadIndex(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := flatTree(gitDir, newTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The working tree alone takes changed from newTree; the index keeps
+	// the old version.
+	v1 := idx.Find("changed").Hash
+	if err := idx.Restore(gitDir, workTree, source, []string{"changed"}, false, true); err != nil {
+		t.Fatalf("Restore() error: %v", err)
+	}
+	if got := readWorkFile(t, workTree, "changed"); got != "v2\n" {
+		t.Errorf("changed = %q, want v2", got)
+	}
+	if e := idx.Find("changed"); e == nil || e.Hash != v1 {
+		t.Errorf("index entry for changed = %+v, want v1", e)
+	}
+
+	// Both take added, and lose removed, which newTree lacks.
+	if err := idx.Restore(gitDir, workTree, source, []string{"added", "removed"}, true, true); err != nil {
+		t.Fatalf("Restore() error: %v", err)
+	}
+	if got := paths(idx); got != "added changed same" {
+		t.Errorf("index = %q", got)
+	}
+	if got := readWorkFile(t, workTree, "added"); got != "added\n" {
+		t.Errorf("added = %q", got)
+	}
+	if _, err := os.Lstat(filepath.Join(workTree, "removed")); !os.IsNotExist(err) {
+		t.Errorf("removed still on disk: %v", err)
+	}
+
+	// The index alone takes changed, leaving the working tree as it is.
+	writeFile(t, workTree, "changed", "local\n")
+	if err := idx.Restore(gitDir, workTree, source, []string{"changed"}, true, false); err != nil {
+		t.Fatalf("Restore() error: %v", err)
+	}
+	if e := idx.Find("changed"); e == nil || e.Hash != source["changed"].Hash {
+		t.Errorf("index entry for changed = %+v, want v2", e)
+	}
+	if got := readWorkFile(t, workTree, "changed"); got != "local\n" {
+		t.Errorf("changed = %q, want the local edit kept", got)
+	}
+}
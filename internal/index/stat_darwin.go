@@ -0,0 +1,23 @@
+package index
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fillStat copies the stat fields git caches from info into e.
+func fillStat(e *Entry, info os.FileInfo) {
+	e.MTime = info.ModTime()
+	e.CTime = e.MTime
+	e.Size = uint32(info.Size())
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	e.CTime = time.Unix(int64(st.Ctimespec.Sec), int64(st.Ctimespec.Nsec))
+	e.Dev = uint32(st.Dev)
+	e.Ino = uint32(st.Ino)
+	e.UID = st.Uid
+	e.GID = st.Gid
+}
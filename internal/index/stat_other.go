@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package index
+
+import "os"
+
+// fillStat copies the stat fields git caches from info into e. Only the
+// portable ones are available here; the rest stay zero.
+func fillStat(e *Entry, info os.FileInfo) {
+	e.MTime = info.ModTime()
+	e.CTime = e.MTime
+	e.Size = uint32(info.Size())
+}
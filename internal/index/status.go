@@ -0,0 +1,295 @@
+package index
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/elliota43/rev/internal/ignore"
+	"github.com/elliota43/rev/internal/object"
+)
+
+// ChangeKind says how a path differs between two snapshots.
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Modified
+	Deleted
+	// TypeChanged is a path that went from a file to a symlink or back.
+	TypeChanged
+)
+
+// String returns the label git status uses for the change.
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "new file"
+	case Modified:
+		return "modified"
+	case Deleted:
+		return "deleted"
+	case TypeChanged:
+		return "typechange"
+	}
+	return "unknown"
+}
+
+// Change is one path that differs between two of HEAD, the index, and the
+// working tree.
+type Change struct {
+	Path string
+	Kind ChangeKind
+}
+
+// Status is the three-way comparison behind `rev status`.
+type Status struct {
+	// Staged compares the HEAD tree with the index.
+	Staged []Change
+	// Unstaged compares the index with the working tree.
+	Unstaged []Change
+	// Untracked lists files in neither the index nor .git. A directory
+	// holding nothing tracked is listed once, as "dir/".
+	Untracked []string
+}
+
+// Clean reports whether there is nothing staged, changed, or untracked.
+func (s *Status) Clean() bool {
+	return len(s.Staged) == 0 && len(s.Unstaged) == 0 && len(s.Untracked) == 0
+}
+
+// Status compares the index with headTree ("" for a repository with no
+// commits yet) and with the working tree at workTree. Files whose size
+// and mtime match their entry are taken as unchanged without rehashing,
+// unless they were modified too close to when the index was written for
+// the mtime to be trusted. Entries in a merge conflict are not compared.
+func (idx *Index) Status(gitDir, workTree, headTree string) (*Status, error) {
+	st := &Status{}
+
+	head, err := flatTree(gitDir, headTree)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexTime time.Time
+	if info, err := os.Stat(Path(gitDir)); err == nil {
+		indexTime = info.ModTime()
+	}
+	filters, err := LoadFilters(gitDir, workTree)
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(map[string]bool)
+	for _, e := range idx.Entries {
+		tracked[e.Path] = true
+		if e.Stage != 0 {
+			continue
+		}
+
+		if te, ok := head[e.Path]; !ok {
+			st.Staged = append(st.Staged, Change{e.Path, Added})
+		} else if kind, changed := compare(te.Mode, te.Hash, e.Mode, e.Hash); changed {
+			st.Staged = append(st.Staged, Change{e.Path, kind})
+		}
+
+		kind, changed, err := e.workTreeChange(filters, filepath.Join(workTree, filepath.FromSlash(e.Path)), indexTime)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			st.Unstaged = append(st.Unstaged, Change{e.Path, kind})
+		}
+	}
+
+	var deleted []string
+	for p := range head {
+		if !tracked[p] {
+			deleted = append(deleted, p)
+		}
+	}
+	sort.Strings(deleted)
+	for _, p := range deleted {
+		st.Staged = append(st.Staged, Change{p, Deleted})
+	}
+	sort.SliceStable(st.Staged, func(i, j int) bool { return st.Staged[i].Path < st.Staged[j].Path })
+
+	ignored, err := ignore.Load(gitDir, workTree)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := findUntracked(workTree, tracked, ignored, true)
+	if err != nil {
+		return nil, err
+	}
+	st.Untracked = untracked
+	return st, nil
+}
+
+// compare reports how an entry changed from (oldMode, oldHash) to
+// (newMode, newHash), if it did.
+func compare(oldMode uint32, oldHash string, newMode uint32, newHash string) (ChangeKind, bool) {
+	if (oldMode == object.ModeSymlink) != (newMode == object.ModeSymlink) {
+		return TypeChanged, true
+	}
+	return Modified, oldMode != newMode || oldHash != newHash
+}
+
+// workTreeChange compares e with the file at full, as cleaned by filters.
+func (e *Entry) workTreeChange(filters *Filters, full string, indexTime time.Time) (ChangeKind, bool, error) {
+	info, err := os.Lstat(full)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Deleted, true, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	mode, ok := fileMode(info)
+	if !ok {
+		return Deleted, true, nil
+	}
+
+	// A file changed in the same instant the index was written could
+	// still have the mtime recorded in its entry, so only older entries
+	// can skip the hash.
+	racy := !e.MTime.Before(indexTime)
+	if !racy && mode == e.Mode && e.Size == uint32(info.Size()) && e.MTime.Equal(info.ModTime()) {
+		return 0, false, nil
+	}
+
+	sha, mode, err := filters.HashFile(full, e.Path)
+	if err != nil {
+		return 0, false, err
+	}
+	kind, changed := compare(e.Mode, e.Hash, mode, sha)
+	return kind, changed, nil
+}
+
+// fileMode returns the index mode a file would be staged with, and false
+// for files git can't store.
+func fileMode(info fs.FileInfo) (uint32, bool) {
+	switch m := info.Mode(); {
+	case m&os.ModeSymlink != 0:
+		return object.ModeSymlink, true
+	case m.IsRegular() && m&0111 != 0:
+		return object.ModeExecutable, true
+	case m.IsRegular():
+		return object.ModeFile, true
+	}
+	return 0, false
+}
+
+// Untracked lists every file in workTree that is neither in the index nor
+// ignored, one path per file, sorted.
+func (idx *Index) Untracked(gitDir, workTree string) ([]string, error) {
+	tracked := make(map[string]bool)
+	for _, e := range idx.Entries {
+		tracked[e.Path] = true
+	}
+	ignored, err := ignore.Load(gitDir, workTree)
+	if err != nil {
+		return nil, err
+	}
+	return findUntracked(workTree, tracked, ignored, false)
+}
+
+// findUntracked walks workTree for files that are neither in tracked nor
+// ignored. With collapse, directories with no tracked files are reported
+// whole, as "dir/", if they hold any file that isn't ignored.
+func findUntracked(workTree string, tracked map[string]bool, ignored *ignore.Matcher, collapse bool) ([]string, error) {
+	trackedDirs := make(map[string]bool)
+	for p := range tracked {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			trackedDirs[dir] = true
+		}
+	}
+
+	var untracked []string
+	err := filepath.WalkDir(workTree, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == workTree {
+			return nil
+		}
+		rel, err := filepath.Rel(workTree, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if trackedDirs[rel] {
+				return nil
+			}
+			if ignored.Match(rel, true) {
+				return filepath.SkipDir
+			}
+			if !collapse {
+				return nil
+			}
+			hasFiles, err := containsFile(workTree, p, ignored)
+			if err != nil {
+				return err
+			}
+			if hasFiles {
+				untracked = append(untracked, rel+"/")
+			}
+			return filepath.SkipDir
+		}
+		if tracked[rel] || ignored.Match(rel, false) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if _, ok := fileMode(info); ok {
+			untracked = append(untracked, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(untracked)
+	return untracked, nil
+}
+
+// containsFile reports whether dir holds a file anywhere below it that
+// isn't ignored.
+func containsFile(workTree, dir string, ignored *ignore.Matcher) (bool, error) {
+	errFound := errors.New("found")
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(workTree, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if p != dir && (d.Name() == ".git" || ignored.Match(rel, true)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignored.Match(rel, false) {
+			return nil
+		}
+		return errFound
+	})
+	if err == errFound {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,215 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// stageAll adds the whole working tree and writes the index.
+func stageAll(t *testing.T, gitDir, workTree string) *Index {
+	t.Helper()
+	idx := New()
+	if err := idx.AddPath(gitDir, workTree, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteIndex(gitDir, idx); err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+// treeFromIndex writes a single-level tree of idx's entries, standing in
+// for a HEAD commit in tests whose paths have no directories.
+func treeFromIndex(t *testing.T, gitDir string, idx *Index) string {
+	t.Helper()
+	var entries []object.TreeEntry
+	for _, e := range idx.Entries {
+		entries = append(entries, object.TreeEntry{Mode: e.Mode, Name: e.Path, Hash: e.Hash})
+	}
+	body, err := object.EncodeTree(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha, err := object.WriteObject(gitDir, object.TypeTree, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+func changes(cs []Change) []string {
+	var out []string
+	for _, c := range cs {
+		out = append(out, fmt.Sprintf("%s %s", c.Kind, c.Path))
+	}
+	return out
+}
+
+func TestStatus(t *testing.T) {
+	workTree, gitDir := testWorkTree(t, map[string]string{
+		"keep":    "keep\n",
+		"edit":    "edit\n",
+		"remove":  "remove\n",
+		"unstage": "unstage\n",
+	})
+	head := treeFromIndex(t, gitDir, stageAll(t, gitDir, workTree))
+
+	idx, err := ReadIndex(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Staged: a new file, an edit, and a removal from the index.
+	writeFile(t, workTree, "added", "added\n")
+	writeFile(t, workTree, "edit", "edited\n")
+	for _, p := range []string{"added", "edit"} {
+		if err := idx.AddPath(gitDir, workTree, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx.Remove("unstage")
+	// Unstaged: a change and a deletion after staging.
+	writeFile(t, workTree, "edit", "edited again\n")
+	os.Remove(filepath.Join(workTree, "remove"))
+	// Untracked: a file and a directory of files.
+	writeFile(t, workTree, "new/a/b.txt", "b\n")
+	writeFile(t, workTree, "stray", "stray\n")
+	os.MkdirAll(filepath.Join(workTree, "empty"), 0755)
+
+	st, err := idx.Status(gitDir, workTree, head)
+	if err != nil {
+		t.Fatalf("Status() error: %v", err)
+	}
+	if got, want := changes(st.Staged), []string{"new file added", "modified edit", "deleted unstage"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Staged = %v, want %v", got, want)
+	}
+	if got, want := changes(st.Unstaged), []string{"modified edit", "deleted remove"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unstaged = %v, want %v", got, want)
+	}
+	if want := []string{"new/", "stray", "unstage"}; !reflect.DeepEqual(st.Untracked, want) {
+		t.Errorf("Untracked = %v, want %v", st.Untracked, want)
+	}
+}
+
+func TestStatus_NoCommits(t *testing.T) {
+	workTree, gitDir := testWorkTree(t, map[string]string{"a/b.txt": "b\n", "a/c.txt": "c\n"})
+	idx := New()
+	if err := idx.AddPath(gitDir, workTree, "a/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := idx.Status(gitDir, workTree, "")
+	if err != nil {
+		t.Fatalf("Status() error: %v", err)
+	}
+	if got, want := changes(st.Staged), []string{"new file a/b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Staged = %v, want %v", got, want)
+	}
+	// a/ holds a tracked file, so its untracked neighbor is listed alone.
+	if want := []string{"a/c.txt"}; !reflect.DeepEqual(st.Untracked, want) {
+		t.Errorf("Untracked = %v, want %v", st.Untracked, want)
+	}
+	if st.Clean() {
+		t.Error("Clean() = true")
+	}
+}
+
+func TestStatus_StatShortcut(t *testing.T) {
+	workTree, gitDir := testWorkTree(t, map[string]string{"f": "aaaa\n"})
+	full := filepath.Join(workTree, "f")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(full, old, old); err != nil {
+		t.Fatal(err)
+	}
+	idx := stageAll(t, gitDir, workTree)
+	head := treeFromIndex(t, gitDir, idx)
+
+	// Same size and mtime: trusted without reading the file, so an edit
+	// that preserves both goes unnoticed, as it does in git.
+	writeFile(t, workTree, "f", "bbbb\n")
+	if err := os.Chtimes(full, old, old); err != nil {
+		t.Fatal(err)
+	}
+	st, err := idx.Status(gitDir, workTree, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Clean() {
+		t.Errorf("stat-clean file was rehashed: %+v", st)
+	}
+
+	// A racily clean entry, modified no earlier than the index was
+	// written, is always rehashed.
+	now := time.Now().Add(time.Hour)
+	idx.Entries[0].MTime = now
+	if err := os.Chtimes(full, now, now); err != nil {
+		t.Fatal(err)
+	}
+	st, err = idx.Status(gitDir, workTree, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := changes(st.Unstaged), []string{"modified f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("racy entry: Unstaged = %v, want %v", got, want)
+	}
+}
+
+func TestStatus_Ignored(t *testing.T) {
+	workTree, gitDir := testWorkTree(t, map[string]string{
+		".gitignore":      "*.log\nbuild/\n",
+		"tracked.log":     "tracked anyway\n",
+		"debug.log":       "ignored\n",
+		"build/out":       "ignored\n",
+		"logs/only.log":   "ignored\n",
+		"notes/todo.txt":  "untracked\n",
+		"notes/trace.log": "ignored\n",
+	})
+	idx := New()
+	for _, p := range []string{".gitignore", "tracked.log"} {
+		if err := idx.AddPath(gitDir, workTree, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st, err := idx.Status(gitDir, workTree, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// logs/ holds nothing but ignored files, so it isn't listed at all.
+	if want := []string{"notes/"}; !reflect.DeepEqual(st.Untracked, want) {
+		t.Errorf("Untracked = %v, want %v", st.Untracked, want)
+	}
+}
+
+func TestUntracked(t *testing.T) {
+	workTree, gitDir := testWorkTree(t, map[string]string{
+		".gitignore":  "*.o\n",
+		"tracked.c":   "c\n",
+		"new.c":       "c\n",
+		"obj/a.o":     "ignored\n",
+		"lib/x/y.c":   "c\n",
+		"lib/x/y.o":   "ignored\n",
+		"lib/tracked": "t\n",
+		"lib/sibling": "s\n",
+	})
+	idx := New()
+	for _, p := range []string{".gitignore", "tracked.c", "lib/tracked"} {
+		if err := idx.AddPath(gitDir, workTree, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := idx.Untracked(gitDir, workTree)
+	if err != nil {
+		t.Fatalf("Untracked() error: %v", err)
+	}
+	// Unlike Status, untracked directories are listed file by file.
+	if want := []string{"lib/sibling", "lib/x/y.c", "new.c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Untracked() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,109 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// ErrUnmerged is returned by WriteTree while the index has conflict
+// entries, which a tree has no way to record.
+var ErrUnmerged = errors.New("index has unmerged entries")
+
+// WriteTree writes the index as tree objects, one per directory, and
+// returns the root tree's hash. It needs no working tree, only the blobs
+// the entries name. Intent-to-add entries are left out, as in git.
+// Directories the cached tree still holds a hash for aren't written
+// again, and the cached tree is brought up to date; the caller writes
+// the index to keep it.
+func (idx *Index) WriteTree(gitDir string) (string, error) {
+	for _, e := range idx.Entries {
+		if e.Stage != 0 {
+			return "", fmt.Errorf("%w: %s", ErrUnmerged, e.Path)
+		}
+	}
+	if idx.Tree == nil {
+		idx.Tree = &CacheTree{EntryCount: -1}
+	}
+	return writeTree(gitDir, idx.Algorithm, idx.Entries, "", idx.Tree)
+}
+
+// writeTree writes the tree for the directory prefix ("" or ending in
+// "/") from entries, the sorted index entries under it, unless cached
+// has a valid hash for them, and records the result in cached. A
+// directory holding intent-to-add entries is left invalid, since its
+// tree doesn't account for them, and so are those above it.
+func writeTree(gitDir string, algo object.Algorithm, entries []*Entry, prefix string, cached *CacheTree) (string, error) {
+	if cached.valid(len(entries)) && object.Exists(gitDir, cached.Hash) == nil {
+		return cached.Hash, nil
+	}
+
+	var tree []object.TreeEntry
+	var subtrees []*CacheTree
+	intentToAdd := false
+	for i := 0; i < len(entries); {
+		name := strings.TrimPrefix(entries[i].Path, prefix)
+		dir, _, isDir := strings.Cut(name, "/")
+		if !isDir {
+			if entries[i].IntentToAdd {
+				intentToAdd = true
+			} else {
+				tree = append(tree, object.TreeEntry{Mode: entries[i].Mode, Name: name, Hash: entries[i].Hash})
+			}
+			i++
+			continue
+		}
+
+		// Index order keeps everything under dir/ together.
+		sub := prefix + dir + "/"
+		j := i
+		for j < len(entries) && strings.HasPrefix(entries[j].Path, sub) {
+			j++
+		}
+		// A directory of nothing but intent-to-add entries is left out.
+		if allIntentToAdd(entries[i:j]) {
+			intentToAdd = true
+			i = j
+			continue
+		}
+		c := cached.sub(dir)
+		if c == nil {
+			c = &CacheTree{Name: dir, EntryCount: -1}
+		}
+		sha, err := writeTree(gitDir, algo, entries[i:j], sub, c)
+		if err != nil {
+			return "", err
+		}
+		subtrees = append(subtrees, c)
+		if c.EntryCount < 0 {
+			intentToAdd = true
+		}
+		tree = append(tree, object.TreeEntry{Mode: object.ModeTree, Name: dir, Hash: sha})
+		i = j
+	}
+
+	body, err := object.EncodeTree(tree)
+	if err != nil {
+		return "", err
+	}
+	sha, err := object.WriteObjectWith(algo, gitDir, object.TypeTree, body)
+	if err != nil {
+		return "", err
+	}
+	cached.Hash, cached.EntryCount, cached.Subtrees = sha, len(entries), subtrees
+	if intentToAdd {
+		cached.EntryCount = -1
+	}
+	return sha, nil
+}
+
+func allIntentToAdd(entries []*Entry) bool {
+	for _, e := range entries {
+		if !e.IntentToAdd {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package index
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+func TestWriteTree(t *testing.T) {
+	workTree, gitDir := testWorkTree(t, map[string]string{
+		"a/b/c.txt": "c\n",
+		"a/b-d.txt": "d\n",
+		"a.txt":     "a\n",
+		"a/e/f.txt": "f\n",
+		"z.txt":     "z\n",
+	})
+	idx := New()
+	if err := idx.AddPath(gitDir, workTree, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := idx.WriteTree(gitDir)
+	if err != nil {
+		t.Fatalf("WriteTree() error: %v", err)
+	}
+	// Snapshotting the same files directly must give the same trees.
+	want, err := object.WriteDirTree(gitDir, workTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("WriteTree() = %s, want %s", got, want)
+	}
+
+	flat, err := object.FlattenTree(gitDir, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flat) != len(idx.Entries) {
+		t.Fatalf("tree has %d files, index %d", len(flat), len(idx.Entries))
+	}
+	for i, te := range flat {
+		if te.Name != idx.Entries[i].Path || te.Hash != idx.Entries[i].Hash {
+			t.Errorf("tree entry %d = %s %s, want %s %s", i, te.Name, te.Hash, idx.Entries[i].Path, idx.Entries[i].Hash)
+		}
+	}
+}
+
+func TestWriteTree_Empty(t *testing.T) {
+	_, gitDir := testWorkTree(t, nil)
+	got, err := New().WriteTree(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+		t.Errorf("empty index tree = %s", got)
+	}
+}
+
+func TestWriteTree_SkipsIntentToAddAndRefusesConflicts(t *testing.T) {
+	_, gitDir := testWorkTree(t, nil)
+
+	idx := New()
+	ita := testEntry("later.txt")
+	ita.IntentToAdd = true
+	idx.Add(ita)
+	got, err := idx.WriteTree(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+		t.Errorf("intent-to-add entry was written: tree %s", got)
+	}
+
+	conflict := testEntry("both.txt")
+	conflict.Stage = 2
+	idx.Add(conflict)
+	if _, err := idx.WriteTree(gitDir); !errors.Is(err, ErrUnmerged) {
+		t.Errorf("WriteTree() with conflict: got %v, want ErrUnmerged", err)
+	}
+}
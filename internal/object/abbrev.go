@@ -0,0 +1,64 @@
+package object
+
+import "fmt"
+
+// MinAbbrev is the shortest abbreviation AbbrevHash returns, matching
+// git's default core.abbrev.
+const MinAbbrev = 7
+
+// ShortestAbbrev is the shortest abbreviation AbbrevHashMin returns,
+// however short a length it is asked for, as in git.
+const ShortestAbbrev = 4
+
+// AbbrevHash returns the shortest prefix of fullSha, at least MinAbbrev
+// characters long, that names no other object in the repository, loose
+// or packed. It errors if fullSha itself doesn't exist.
+func AbbrevHash(gitDir, fullSha string) (string, error) {
+	return AbbrevHashMin(gitDir, fullSha, MinAbbrev)
+}
+
+// AbbrevHashMin is AbbrevHash with a minimum length of minLen, raised to
+// ShortestAbbrev if it is less. A minLen of the hash's length or more
+// returns fullSha whole.
+func AbbrevHashMin(gitDir, fullSha string, minLen int) (string, error) {
+	if !isFullHash(fullSha) {
+		return "", fmt.Errorf("abbreviating %q: not a full hash", fullSha)
+	}
+	if _, err := locate(gitDir, fullSha); err != nil {
+		return "", err
+	}
+	minLen = max(minLen, ShortestAbbrev)
+	if minLen >= len(fullSha) {
+		return fullSha, nil
+	}
+
+	// Only objects sharing the minimum prefix can force a longer one.
+	prefix := fullSha[:minLen]
+	loose, err := resolveLoose(gitDir, prefix)
+	if err != nil {
+		return "", err
+	}
+	packed, err := findPacked(gitDir, prefix)
+	if err != nil {
+		return "", fmt.Errorf("reading packs: %w", err)
+	}
+
+	n := minLen
+	grow := func(other string) {
+		if other == fullSha {
+			return
+		}
+		common := 0
+		for common < len(other) && other[common] == fullSha[common] {
+			common++
+		}
+		n = max(n, common+1)
+	}
+	for h := range loose {
+		grow(h)
+	}
+	for _, e := range packed {
+		grow(e.hash)
+	}
+	return fullSha[:n], nil
+}
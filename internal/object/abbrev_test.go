@@ -0,0 +1,96 @@
+package object
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAbbrevHash(t *testing.T) {
+	gitDir := testGitDir(t)
+	sha := writeTestBlob(t, gitDir, "hello\n")
+
+	// A lone object gets the minimum length.
+	got, err := AbbrevHash(gitDir, sha)
+	if err != nil {
+		t.Fatalf("AbbrevHash() error: %v", err)
+	}
+	if got != sha[:7] {
+		t.Errorf("got %q, want %q", got, sha[:7])
+	}
+
+	// A loose object sharing the first 9 characters forces 10.
+	dir := filepath.Join(gitDir, "objects", sha[:2])
+	collide := sha[2:9] + strings.Repeat("0", 31)
+	if collide == sha[2:] {
+		collide = sha[2:9] + strings.Repeat("1", 31)
+	}
+	if err := os.WriteFile(filepath.Join(dir, collide), nil, 0444); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := AbbrevHash(gitDir, sha); got != sha[:10] {
+		t.Errorf("with loose collision: got %q, want %q", got, sha[:10])
+	}
+}
+
+func TestAbbrevHashMin(t *testing.T) {
+	gitDir := testGitDir(t)
+	sha := writeTestBlob(t, gitDir, "hello\n")
+
+	tests := []struct {
+		minLen int
+		want   string
+	}{
+		{2, sha[:4]},
+		{4, sha[:4]},
+		{12, sha[:12]},
+		{40, sha},
+		{64, sha},
+	}
+	for _, tt := range tests {
+		if got, err := AbbrevHashMin(gitDir, sha, tt.minLen); err != nil || got != tt.want {
+			t.Errorf("AbbrevHashMin(%d) = %q, %v; want %q", tt.minLen, got, err, tt.want)
+		}
+	}
+
+	// A loose object sharing the first 5 characters still lengthens it.
+	collide := sha[2:5] + strings.Repeat("0", 35)
+	if collide == sha[2:] {
+		collide = sha[2:5] + strings.Repeat("1", 35)
+	}
+	if err := os.WriteFile(filepath.Join(gitDir, "objects", sha[:2], collide), nil, 0444); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := AbbrevHashMin(gitDir, sha, 4); got != sha[:6] {
+		t.Errorf("with loose collision: got %q, want %q", got, sha[:6])
+	}
+}
+
+func TestAbbrevHash_Packed(t *testing.T) {
+	gitDir := testGitDir(t)
+	hashes, _ := writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("packed\n")}})
+
+	// A loose object sharing 8 characters with the packed one.
+	sha := hashes[0]
+	other := sha[:8] + strings.Repeat("f", 32)
+	if err := os.MkdirAll(filepath.Join(gitDir, "objects", sha[:2]), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(gitDir, "objects", sha[:2], other[2:]), nil, 0444)
+
+	if got, err := AbbrevHash(gitDir, sha); err != nil || got != sha[:9] {
+		t.Errorf("got %q, %v; want %q", got, err, sha[:9])
+	}
+}
+
+func TestAbbrevHash_Missing(t *testing.T) {
+	gitDir := testGitDir(t)
+	if _, err := AbbrevHash(gitDir, strings.Repeat("a", 40)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+	if _, err := AbbrevHash(gitDir, "abc"); err == nil {
+		t.Error("expected error for a short hash")
+	}
+}
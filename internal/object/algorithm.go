@@ -0,0 +1,73 @@
+package object
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+)
+
+// Algorithm is an object hash function. A repository uses one for all
+// of its objects, chosen by extensions.objectFormat.
+type Algorithm int
+
+const (
+	// SHA1 is git's original and default object format.
+	SHA1 Algorithm = iota
+	// SHA256 is the newer object format, opted into per repository.
+	SHA256
+)
+
+// ParseAlgorithm parses an extensions.objectFormat value. An empty value
+// means the default, SHA-1.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch name {
+	case "", "sha1":
+		return SHA1, nil
+	case "sha256":
+		return SHA256, nil
+	}
+	return SHA1, fmt.Errorf("unknown object format %q", name)
+}
+
+// String returns the algorithm's name as written in the config.
+func (a Algorithm) String() string {
+	if a == SHA256 {
+		return "sha256"
+	}
+	return "sha1"
+}
+
+// HexLen is the length of a full hex object name: 40 for SHA-1, 64 for
+// SHA-256.
+func (a Algorithm) HexLen() int {
+	return a.Size() * 2
+}
+
+// Size is the length of a binary object name, as trees and the index
+// store it: 20 bytes for SHA-1, 32 for SHA-256.
+func (a Algorithm) Size() int {
+	return a.new().Size()
+}
+
+func (a Algorithm) new() hash.Hash {
+	if a == SHA256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+// isFullHash reports whether hash has the length of a full object name
+// under any supported algorithm.
+func isFullHash(hash string) bool {
+	return len(hash) == SHA1.HexLen() || len(hash) == SHA256.HexLen()
+}
+
+// algorithmFor returns the algorithm that produces full hashes the length
+// of hash.
+func algorithmFor(hash string) Algorithm {
+	if len(hash) == SHA256.HexLen() {
+		return SHA256
+	}
+	return SHA1
+}
@@ -0,0 +1,76 @@
+package object
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DeltaBaseCacheLimit caps the bytes of delta bases readPacked keeps
+// after resolving a chain, so that reading the objects that share a
+// chain doesn't rebuild the same bases each time. repository.Open sets
+// it from core.deltaBaseCacheLimit. Zero or less disables the cache.
+var DeltaBaseCacheLimit int64 = DefaultDeltaBaseCacheLimit
+
+// DefaultDeltaBaseCacheLimit is DeltaBaseCacheLimit when
+// core.deltaBaseCacheLimit isn't set, git's default of 96 MiB.
+const DefaultDeltaBaseCacheLimit = 96 << 20
+
+// baseKey names a pack entry whose object has been resolved.
+type baseKey struct {
+	packPath string
+	offset   uint64
+}
+
+// cachedBase is a resolved object held in the base cache.
+type cachedBase struct {
+	key  baseKey
+	typ  Type
+	body []byte
+}
+
+// baseCache holds resolved delta bases up to DeltaBaseCacheLimit bytes,
+// dropping the least recently used first. Bodies are shared, never
+// written to, and never handed to callers of Read.
+type baseCache struct {
+	mu      sync.Mutex
+	entries map[baseKey]*list.Element
+	order   list.List // front is most recently used
+	size    int64
+}
+
+var deltaBases = &baseCache{entries: make(map[baseKey]*list.Element)}
+
+// get returns the base cached for e, if any.
+func (c *baseCache) get(e packEntry) (Type, []byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[baseKey{e.packPath, e.offset}]
+	if !ok {
+		return "", nil, false
+	}
+	c.order.MoveToFront(el)
+	b := el.Value.(*cachedBase)
+	return b.typ, b.body, true
+}
+
+// add caches body as the object at e, unless it alone is over the limit,
+// and evicts the least recently used bases until the rest fit.
+func (c *baseCache) add(e packEntry, typ Type, body []byte) {
+	limit := DeltaBaseCacheLimit
+	if limit <= 0 || int64(len(body)) > limit {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := baseKey{e.packPath, e.offset}
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cachedBase{key: key, typ: typ, body: body})
+	c.size += int64(len(body))
+	for c.size > limit {
+		b := c.order.Remove(c.order.Back()).(*cachedBase)
+		delete(c.entries, b.key)
+		c.size -= int64(len(b.body))
+	}
+}
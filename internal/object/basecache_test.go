@@ -0,0 +1,98 @@
+package object
+
+import (
+	"container/list"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// setDeltaBaseCacheLimit sets DeltaBaseCacheLimit and empties the cache
+// for the rest of the test.
+func setDeltaBaseCacheLimit(t *testing.T, n int64) {
+	t.Helper()
+	reset := func() {
+		deltaBases.mu.Lock()
+		deltaBases.entries = make(map[baseKey]*list.Element)
+		deltaBases.order.Init()
+		deltaBases.size = 0
+		deltaBases.mu.Unlock()
+	}
+	old := DeltaBaseCacheLimit
+	DeltaBaseCacheLimit = n
+	reset()
+	t.Cleanup(func() {
+		DeltaBaseCacheLimit = old
+		reset()
+	})
+}
+
+func TestBaseCache(t *testing.T) {
+	setDeltaBaseCacheLimit(t, 10)
+	entry := func(offset uint64) packEntry { return packEntry{packPath: "p", offset: offset} }
+
+	deltaBases.add(entry(1), TypeBlob, []byte("aaaa"))
+	deltaBases.add(entry(2), TypeBlob, []byte("bbbb"))
+	deltaBases.get(entry(1))
+	// Over the limit, so the least recently used, 2, goes.
+	deltaBases.add(entry(3), TypeBlob, []byte("cccc"))
+	deltaBases.add(entry(4), TypeBlob, []byte("too big to cache"))
+
+	for offset, want := range map[uint64]string{1: "aaaa", 2: "", 3: "cccc", 4: ""} {
+		_, body, ok := deltaBases.get(entry(offset))
+		if ok != (want != "") || string(body) != want {
+			t.Errorf("get(%d) = %q, %v, want %q", offset, body, ok, want)
+		}
+	}
+	if deltaBases.size != 8 {
+		t.Errorf("size = %d, want 8", deltaBases.size)
+	}
+}
+
+// deltaAppending returns a delta that copies all of a base of n bytes
+// and appends c.
+func deltaAppending(n int, c byte) []byte {
+	varint := func(v int) []byte {
+		var b []byte
+		for v >= 0x80 {
+			b = append(b, byte(v)|0x80)
+			v >>= 7
+		}
+		return append(b, byte(v))
+	}
+	d := append(varint(n), varint(n+1)...)
+	return append(d, 0xb0, byte(n), byte(n>>8), 1, c)
+}
+
+func TestRead_LongDeltaChain(t *testing.T) {
+	for _, limit := range []int64{0, DefaultDeltaBaseCacheLimit} {
+		setDeltaBaseCacheLimit(t, limit)
+		gitDir := testGitDir(t)
+
+		// Each object is a REF_DELTA on the one before, adding a byte.
+		const depth = 1000
+		body := "base"
+		hashes := []string{HashBytes([]byte(Header(TypeBlob, int64(len(body))) + body))}
+		entries := [][]byte{packEntryBytes(packBlob, nil, []byte(body))}
+		for i := range depth {
+			prev, _ := hex.DecodeString(hashes[i])
+			entries = append(entries, packEntryBytes(packRefDelta, prev, deltaAppending(len(body), 'x')))
+			body += "x"
+			hashes = append(hashes, HashBytes([]byte(Header(TypeBlob, int64(len(body)))+body)))
+		}
+		writeTestPackEntries(t, gitDir, hashes, entries)
+
+		for _, i := range []int{depth, depth / 2, depth} {
+			obj, err := Read(gitDir, hashes[i])
+			if err != nil {
+				t.Fatalf("limit %d: Read(depth %d) error: %v", limit, i, err)
+			}
+			if want := "base" + strings.Repeat("x", i); string(obj.Body) != want {
+				t.Errorf("limit %d: Read(depth %d) = %d bytes, want %d", limit, i, len(obj.Body), len(want))
+			}
+		}
+		if got := len(deltaBases.entries); limit == 0 && got != 0 || limit > 0 && got != depth {
+			t.Errorf("limit %d: %d bases cached", limit, got)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature identifies who authored, committed, or tagged something, and
+// when: "Name <email> <unix-seconds> <+hhmm>".
+type Signature struct {
+	Name  string
+	Email string
+	// When is the time in Unix seconds.
+	When int64
+	// Timezone is the offset from UTC as written in the object, e.g.
+	// "+0200". It is kept verbatim so objects round-trip exactly.
+	Timezone string
+}
+
+// ParseSignature parses the value of an author, committer, or tagger line.
+func ParseSignature(s string) (Signature, error) {
+	lt := strings.IndexByte(s, '<')
+	gt := strings.LastIndexByte(s, '>')
+	if lt < 0 || gt < lt {
+		return Signature{}, fmt.Errorf("malformed signature %q: missing <email>", s)
+	}
+
+	sig := Signature{
+		Name:  strings.TrimSpace(s[:lt]),
+		Email: s[lt+1 : gt],
+	}
+
+	fields := strings.Fields(s[gt+1:])
+	if len(fields) != 2 {
+		return Signature{}, fmt.Errorf("malformed signature %q: want \"<time> <zone>\" after email", s)
+	}
+	when, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Signature{}, fmt.Errorf("malformed signature %q: bad timestamp", s)
+	}
+	if _, err := parseTimezone(fields[1]); err != nil {
+		return Signature{}, fmt.Errorf("malformed signature %q: %w", s, err)
+	}
+	sig.When = when
+	sig.Timezone = fields[1]
+	return sig, nil
+}
+
+// String formats the signature as it appears in an object header.
+func (s Signature) String() string {
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.When, s.Timezone)
+}
+
+// Time returns the signature's time in its own timezone.
+func (s Signature) Time() time.Time {
+	offset, _ := parseTimezone(s.Timezone)
+	return time.Unix(s.When, 0).In(time.FixedZone(s.Timezone, offset))
+}
+
+// parseTimezone converts a "+hhmm" or "-hhmm" offset to seconds east of
+// UTC.
+func parseTimezone(tz string) (int, error) {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return 0, fmt.Errorf("bad timezone %q", tz)
+	}
+	hh, err1 := strconv.Atoi(tz[1:3])
+	mm, err2 := strconv.Atoi(tz[3:5])
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("bad timezone %q", tz)
+	}
+	offset := hh*3600 + mm*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return offset, nil
+}
+
+// Commit is a parsed commit object.
+type Commit struct {
+	// Hash is the commit's own name, set by ParseCommit. Bytes ignores
+	// it, so it needn't be filled in for a commit being written.
+	Hash      string
+	Tree      string
+	Parents   []string
+	Author    Signature
+	Committer Signature
+	// Extra holds the headers after committer that rev doesn't model
+	// (encoding, mergetag, gpgsig, ...), in their original order.
+	Extra   []ExtraHeader
+	Message string
+}
+
+// ParseCommit parses a commit object. Headers other than tree, parent,
+// author, and committer are kept in Extra.
+func ParseCommit(o *Object) (*Commit, error) {
+	if o.Type != TypeCommit {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", o.Hash, o.Type)
+	}
+
+	headers, message, err := splitHeaders(o.Body)
+	if err != nil {
+		return nil, fmt.Errorf("commit %s: %w", o.Hash, err)
+	}
+
+	c := &Commit{Hash: o.Hash, Message: message}
+	var haveAuthor, haveCommitter bool
+	for _, h := range headers {
+		switch h.Key {
+		case "tree":
+			c.Tree = h.Value
+		case "parent":
+			c.Parents = append(c.Parents, h.Value)
+		case "author":
+			if c.Author, err = ParseSignature(h.Value); err != nil {
+				return nil, fmt.Errorf("commit %s: author: %w", o.Hash, err)
+			}
+			haveAuthor = true
+		case "committer":
+			if c.Committer, err = ParseSignature(h.Value); err != nil {
+				return nil, fmt.Errorf("commit %s: committer: %w", o.Hash, err)
+			}
+			haveCommitter = true
+		default:
+			c.Extra = append(c.Extra, h)
+		}
+	}
+
+	switch {
+	case c.Tree == "":
+		return nil, fmt.Errorf("commit %s: missing tree header", o.Hash)
+	case !haveAuthor:
+		return nil, fmt.Errorf("commit %s: missing author header", o.Hash)
+	case !haveCommitter:
+		return nil, fmt.Errorf("commit %s: missing committer header", o.Hash)
+	}
+	return c, nil
+}
+
+// Bytes encodes the commit as a commit object body, the inverse of
+// ParseCommit. Extra headers follow committer, where git writes them, so
+// a commit git wrote re-encodes to the same bytes.
+func (c *Commit) Bytes() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "tree %s\n", c.Tree)
+	for _, p := range c.Parents {
+		fmt.Fprintf(&b, "parent %s\n", p)
+	}
+	fmt.Fprintf(&b, "author %s\n", c.Author)
+	fmt.Fprintf(&b, "committer %s\n", c.Committer)
+	for _, h := range c.Extra {
+		fmt.Fprintf(&b, "%s %s\n", h.Key, strings.ReplaceAll(h.Value, "\n", "\n "))
+	}
+	b.WriteString("\n")
+	b.WriteString(c.Message)
+	return b.Bytes()
+}
+
+// ExtraHeader is one "key value" line of a commit or tag header. Values
+// that span several lines (gpgsig, mergetag) are joined with "\n", without
+// the leading space of their continuation lines.
+type ExtraHeader struct {
+	Key   string
+	Value string
+}
+
+// splitHeaders splits a commit or tag body into its header lines and the
+// message that follows the first blank line. Continuation lines, which
+// start with a space, are appended to the previous header's value.
+func splitHeaders(body []byte) ([]ExtraHeader, string, error) {
+	var headers []ExtraHeader
+	rest := body
+	for len(rest) > 0 {
+		nl := bytes.IndexByte(rest, '\n')
+		var line []byte
+		if nl < 0 {
+			line, rest = rest, nil
+		} else {
+			line, rest = rest[:nl], rest[nl+1:]
+		}
+
+		if len(line) == 0 {
+			return headers, string(rest), nil
+		}
+		if line[0] == ' ' {
+			if len(headers) == 0 {
+				return nil, "", fmt.Errorf("continuation line before any header")
+			}
+			headers[len(headers)-1].Value += "\n" + string(line[1:])
+			continue
+		}
+
+		key, value, ok := strings.Cut(string(line), " ")
+		if !ok {
+			return nil, "", fmt.Errorf("malformed header line %q", line)
+		}
+		headers = append(headers, ExtraHeader{Key: key, Value: value})
+	}
+	return headers, "", nil
+}
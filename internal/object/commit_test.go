@@ -0,0 +1,169 @@
+package object
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func commitObject(body string) *Object {
+	return &Object{Type: TypeCommit, Size: int64(len(body)), Hash: "c0ffee", Body: []byte(body)}
+}
+
+func TestParseSignature(t *testing.T) {
+	sig, err := ParseSignature("Ada Lovelace <ada@example.com> 1700000000 -0530")
+	if err != nil {
+		t.Fatalf("ParseSignature() error: %v", err)
+	}
+	want := Signature{Name: "Ada Lovelace", Email: "ada@example.com", When: 1700000000, Timezone: "-0530"}
+	if sig != want {
+		t.Errorf("got %+v, want %+v", sig, want)
+	}
+	if got := sig.String(); got != "Ada Lovelace <ada@example.com> 1700000000 -0530" {
+		t.Errorf("String(): got %q", got)
+	}
+
+	tm := sig.Time()
+	if _, offset := tm.Zone(); offset != -(5*3600 + 30*60) {
+		t.Errorf("Time() offset: got %d", offset)
+	}
+	if !tm.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Time(): got %v", tm)
+	}
+
+	for _, bad := range []string{
+		"No Email 1700000000 +0000",
+		"A <a@b> notanumber +0000",
+		"A <a@b> 1700000000",
+		"A <a@b> 1700000000 0000",
+	} {
+		if _, err := ParseSignature(bad); err == nil {
+			t.Errorf("ParseSignature(%q): expected error", bad)
+		}
+	}
+}
+
+func TestParseCommit(t *testing.T) {
+	body := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"parent 1111111111111111111111111111111111111111\n" +
+		"parent 2222222222222222222222222222222222222222\n" +
+		"author A U Thor <author@example.com> 1700000000 +0100\n" +
+		"committer C O Mitter <committer@example.com> 1700000100 +0000\n" +
+		"gpgsig -----BEGIN PGP SIGNATURE-----\n" +
+		" \n" +
+		" abc\n" +
+		" -----END PGP SIGNATURE-----\n" +
+		"\n" +
+		"Merge branch 'topic'\n\nDetails.\n"
+
+	c, err := ParseCommit(commitObject(body))
+	if err != nil {
+		t.Fatalf("ParseCommit() error: %v", err)
+	}
+	if c.Tree != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+		t.Errorf("Tree: got %q", c.Tree)
+	}
+	if len(c.Parents) != 2 || c.Parents[0][0] != '1' || c.Parents[1][0] != '2' {
+		t.Errorf("Parents: got %v", c.Parents)
+	}
+	if c.Author.Name != "A U Thor" || c.Author.Timezone != "+0100" {
+		t.Errorf("Author: got %+v", c.Author)
+	}
+	if c.Committer.Email != "committer@example.com" || c.Committer.When != 1700000100 {
+		t.Errorf("Committer: got %+v", c.Committer)
+	}
+	if c.Message != "Merge branch 'topic'\n\nDetails.\n" {
+		t.Errorf("Message: got %q", c.Message)
+	}
+	wantSig := "-----BEGIN PGP SIGNATURE-----\n\nabc\n-----END PGP SIGNATURE-----"
+	if len(c.Extra) != 1 || c.Extra[0].Key != "gpgsig" || c.Extra[0].Value != wantSig {
+		t.Errorf("Extra: got %q", c.Extra)
+	}
+}
+
+func TestParseCommit_RootCommit(t *testing.T) {
+	body := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"author A <a@example.com> 1 +0000\n" +
+		"committer A <a@example.com> 1 +0000\n" +
+		"\n" +
+		"Initial\n"
+	c, err := ParseCommit(commitObject(body))
+	if err != nil {
+		t.Fatalf("ParseCommit() error: %v", err)
+	}
+	if len(c.Parents) != 0 {
+		t.Errorf("root commit should have no parents, got %v", c.Parents)
+	}
+}
+
+func TestParseCommit_Errors(t *testing.T) {
+	if _, err := ParseCommit(&Object{Type: TypeBlob, Hash: "abc"}); err == nil || !strings.Contains(err.Error(), "not a commit") {
+		t.Errorf("blob: expected not-a-commit error, got %v", err)
+	}
+
+	tests := map[string]string{
+		"missing tree":      "author A <a@b> 1 +0000\ncommitter A <a@b> 1 +0000\n\nmsg\n",
+		"missing author":    "tree abc\ncommitter A <a@b> 1 +0000\n\nmsg\n",
+		"missing committer": "tree abc\nauthor A <a@b> 1 +0000\n\nmsg\n",
+		"bad author":        "tree abc\nauthor nope\ncommitter A <a@b> 1 +0000\n\nmsg\n",
+		"malformed header":  "tree\n\nmsg\n",
+	}
+	for name, body := range tests {
+		if _, err := ParseCommit(commitObject(body)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestCommit_BytesRoundTrip(t *testing.T) {
+	body := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"parent 1111111111111111111111111111111111111111\n" +
+		"author A U Thor <author@example.com> 1700000000 +0100\n" +
+		"committer C O Mitter <committer@example.com> 1700000100 -0000\n" +
+		"\n" +
+		"Subject\n\nBody\n"
+
+	c, err := ParseCommit(commitObject(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(c.Bytes()); got != body {
+		t.Errorf("Bytes():\ngot  %q\nwant %q", got, body)
+	}
+}
+
+// testdata/mergetag.commit is a signed merge of a signed tag, written by
+// git: a mergetag header and then a gpgsig header, both after committer.
+func TestCommit_MergetagFixture(t *testing.T) {
+	body, err := os.ReadFile(filepath.Join("testdata", "mergetag.commit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := commitObject(string(body))
+	if got, want := HashBytes([]byte(Header(TypeCommit, int64(len(body)))+string(body))), "1cb878df0cfe81f7de4d34306373119f6a084f62"; got != want {
+		t.Fatalf("fixture hashes to %s, want %s", got, want)
+	}
+
+	c, err := ParseCommit(obj)
+	if err != nil {
+		t.Fatalf("ParseCommit() error: %v", err)
+	}
+	var keys []string
+	for _, h := range c.Extra {
+		keys = append(keys, h.Key)
+	}
+	if strings.Join(keys, " ") != "mergetag gpgsig" {
+		t.Errorf("Extra keys = %v, want [mergetag gpgsig]", keys)
+	}
+	if !strings.HasPrefix(c.Extra[0].Value, "object 380601290d647d69c08e26d785ad5f4136f7a578\ntype commit\ntag v1\n") {
+		t.Errorf("mergetag value = %q", c.Extra[0].Value)
+	}
+	if got := string(c.Bytes()); got != string(body) {
+		t.Errorf("Bytes():\ngot  %q\nwant %q", got, body)
+	}
+	if got := obj.PrettyPrint(); got != string(body) {
+		t.Errorf("PrettyPrint():\ngot  %q\nwant %q", got, body)
+	}
+}
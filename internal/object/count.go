@@ -0,0 +1,104 @@
+package object
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LooseStats summarizes a repository's loose objects.
+type LooseStats struct {
+	Count int
+	// Size is the total size of the object files on disk, compressed.
+	Size int64
+	// ByType counts objects per type. It is only filled in when asked
+	// for, since it means reading every object's header.
+	ByType map[Type]int
+}
+
+// CountLoose counts the loose objects in gitDir and their size on disk.
+// With byType, it also reads each object's header to tally types.
+func CountLoose(gitDir string, byType bool) (LooseStats, error) {
+	hashes, err := ListObjects(gitDir)
+	if err != nil {
+		return LooseStats{}, err
+	}
+
+	stats := LooseStats{Count: len(hashes)}
+	if byType {
+		stats.ByType = make(map[Type]int)
+	}
+	for _, h := range hashes {
+		info, err := os.Stat(filepath.Join(gitDir, "objects", h[:2], h[2:]))
+		if err != nil {
+			return LooseStats{}, fmt.Errorf("stat object %s: %w", h, err)
+		}
+		stats.Size += info.Size()
+
+		if byType {
+			objType, _, err := ReadHeader(gitDir, h)
+			if err != nil {
+				return LooseStats{}, err
+			}
+			stats.ByType[objType]++
+		}
+	}
+	return stats, nil
+}
+
+// PackStats summarizes a repository's packfiles.
+type PackStats struct {
+	Count int
+	// Kept counts the packs marked with a .keep file, which repack
+	// leaves alone.
+	Kept int
+	// Size is the total size of the packs and their indexes on disk.
+	Size int64
+}
+
+// CountPacks counts the packs in <gitDir>/objects/pack and their size,
+// as count-objects -v reports them.
+func CountPacks(gitDir string) (PackStats, error) {
+	packs, err := filepath.Glob(filepath.Join(gitDir, "objects", "pack", "pack-*.pack"))
+	if err != nil {
+		return PackStats{}, err
+	}
+	stats := PackStats{Count: len(packs)}
+	for _, p := range packs {
+		if exists(strings.TrimSuffix(p, ".pack") + ".keep") {
+			stats.Kept++
+		}
+		for _, name := range []string{p, strings.TrimSuffix(p, ".pack") + ".idx"} {
+			info, err := os.Stat(name)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return PackStats{}, err
+			}
+			stats.Size += info.Size()
+		}
+	}
+	return stats, nil
+}
+
+// SampleLoose counts the loose objects in <gitDir>/objects/17, the one
+// fan-out directory git looks at to estimate the total cheaply: with
+// hashes spread evenly, there are about 256 times as many in all.
+func SampleLoose(gitDir string) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(gitDir, "objects", "17"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		if h := "17" + e.Name(); !e.IsDir() && isFullHash(h) && isHex(h) {
+			n++
+		}
+	}
+	return n, nil
+}
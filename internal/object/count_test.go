@@ -0,0 +1,38 @@
+package object
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCountLoose(t *testing.T) {
+	gitDir := testGitDir(t)
+	writeTestBlob(t, gitDir, "hello\n")
+	writeTestBlob(t, gitDir, "world\n")
+	if _, err := WriteObject(gitDir, TypeTree, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := CountLoose(gitDir, false)
+	if err != nil {
+		t.Fatalf("CountLoose() error: %v", err)
+	}
+	var size int64
+	hashes, _ := ListObjects(gitDir)
+	for _, h := range hashes {
+		info, _ := os.Stat(filepath.Join(gitDir, "objects", h[:2], h[2:]))
+		size += info.Size()
+	}
+	if stats.Count != 3 || stats.Size != size || stats.ByType != nil {
+		t.Errorf("got %+v, want 3 objects of %d bytes", stats, size)
+	}
+
+	stats, err = CountLoose(gitDir, true)
+	if err != nil {
+		t.Fatalf("CountLoose(byType) error: %v", err)
+	}
+	if stats.ByType[TypeBlob] != 2 || stats.ByType[TypeTree] != 1 || stats.ByType[TypeCommit] != 0 {
+		t.Errorf("ByType: got %v", stats.ByType)
+	}
+}
@@ -0,0 +1,211 @@
+package object
+
+import (
+	"fmt"
+)
+
+// maxDeltaDepth bounds how many deltas readPacked follows before
+// giving up. git itself writes chains of at most 50 by default, so
+// anything far longer is treated as corrupt rather than walked forever.
+const maxDeltaDepth = 4096
+
+// applyDelta reconstructs an object from its base and a git delta: two
+// size varints (base, then result) followed by copy and insert opcodes.
+// A copy op (high bit set) names an offset and length in base, with the
+// low seven bits saying which of those bytes follow; an insert op (1-127)
+// is followed by that many literal bytes.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	baseSize, delta, err := deltaHeaderSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	if baseSize != uint64(len(base)) {
+		return nil, fmt.Errorf("delta expects a %d-byte base, got %d", baseSize, len(base))
+	}
+	resultSize, delta, err := deltaHeaderSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	if MaxObjectSize > 0 && resultSize > uint64(MaxObjectSize) {
+		return nil, fmt.Errorf("%w: delta result declares %d bytes", ErrTooLarge, resultSize)
+	}
+
+	// resultSize comes from the pack, so it only sizes the buffer up to
+	// what base and delta could plausibly produce; a larger result (a
+	// delta can repeat base) grows it as the data actually arrives.
+	out := make([]byte, 0, min(resultSize, uint64(len(base)+len(delta))))
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		switch {
+		case op&0x80 != 0:
+			var offset, n uint64
+			for i := range 4 {
+				if op&(1<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, fmt.Errorf("truncated delta copy op")
+					}
+					offset |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			for i := range 3 {
+				if op&(0x10<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, fmt.Errorf("truncated delta copy op")
+					}
+					n |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if offset+n > uint64(len(base)) {
+				return nil, fmt.Errorf("delta copies %d bytes at %d, past the %d-byte base", n, offset, len(base))
+			}
+			out = append(out, base[offset:offset+n]...)
+		case op != 0:
+			if int(op) > len(delta) {
+				return nil, fmt.Errorf("truncated delta insert op")
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, fmt.Errorf("reserved delta opcode 0")
+		}
+
+		if uint64(len(out)) > resultSize {
+			return nil, fmt.Errorf("delta result overruns its declared %d bytes", resultSize)
+		}
+	}
+
+	if uint64(len(out)) != resultSize {
+		return nil, fmt.Errorf("delta result is %d bytes, declared %d", len(out), resultSize)
+	}
+	return out, nil
+}
+
+// deltaHeaderSize reads one of the little-endian base-128 sizes at the
+// start of a delta and returns it with the rest of the delta.
+func deltaHeaderSize(delta []byte) (uint64, []byte, error) {
+	var size uint64
+	for i, shift := 0, 0; i < len(delta); i, shift = i+1, shift+7 {
+		if shift > 63 {
+			break
+		}
+		size |= uint64(delta[i]&0x7f) << shift
+		if delta[i]&0x80 == 0 {
+			return size, delta[i+1:], nil
+		}
+	}
+	return 0, nil, fmt.Errorf("malformed delta size header")
+}
+
+// deltaBlock is the length of the base chunks computeDelta indexes. A
+// match must start with a whole chunk, so shorter runs are inserted.
+const deltaBlock = 16
+
+// maxDeltaCopy is the most a single copy op is made to copy, the limit
+// git's own packs keep to.
+const maxDeltaCopy = 0x10000
+
+// computeDelta returns a delta that applyDelta turns from base into
+// target, or nil if it would be longer than maxSize bytes. Base is
+// indexed at every deltaBlock boundary, and target is scanned for those
+// blocks; a hit is extended forward, and backward over literals not yet
+// emitted, and becomes copy ops, while bytes between hits become inserts.
+func computeDelta(base, target []byte, maxSize int) []byte {
+	blocks := make(map[[deltaBlock]byte]int, len(base)/deltaBlock)
+	for off := len(base) - len(base)%deltaBlock - deltaBlock; off >= 0; off -= deltaBlock {
+		// Walking backward leaves each block's earliest offset.
+		blocks[[deltaBlock]byte(base[off:off+deltaBlock])] = off
+	}
+
+	out := appendDeltaSize(nil, uint64(len(base)))
+	out = appendDeltaSize(out, uint64(len(target)))
+	var pending []byte
+	for i := 0; i < len(target); {
+		off, ok := -1, false
+		if i+deltaBlock <= len(target) {
+			off, ok = blocks[[deltaBlock]byte(target[i:i+deltaBlock])]
+		}
+		if !ok {
+			pending = append(pending, target[i])
+			i++
+			continue
+		}
+
+		n := deltaBlock
+		for i+n < len(target) && off+n < len(base) && target[i+n] == base[off+n] {
+			n++
+		}
+		for len(pending) > 0 && off > 0 && pending[len(pending)-1] == base[off-1] {
+			pending = pending[:len(pending)-1]
+			off--
+			i--
+			n++
+		}
+
+		out = appendDeltaInsert(out, pending)
+		pending = pending[:0]
+		for i += n; n > 0; {
+			chunk := min(n, maxDeltaCopy)
+			out = appendDeltaCopy(out, off, chunk)
+			off += chunk
+			n -= chunk
+		}
+		if len(out) > maxSize {
+			return nil
+		}
+	}
+	out = appendDeltaInsert(out, pending)
+	if len(out) > maxSize {
+		return nil
+	}
+	return out
+}
+
+// appendDeltaSize appends one of the size varints a delta starts with.
+func appendDeltaSize(out []byte, size uint64) []byte {
+	for size >= 0x80 {
+		out = append(out, byte(size)|0x80)
+		size >>= 7
+	}
+	return append(out, byte(size))
+}
+
+// appendDeltaInsert appends insert ops for data, at most 127 bytes each.
+func appendDeltaInsert(out, data []byte) []byte {
+	for len(data) > 0 {
+		n := min(len(data), 0x7f)
+		out = append(out, byte(n))
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
+
+// appendDeltaCopy appends a copy op for n bytes at offset in the base,
+// writing only the nonzero bytes of each and flagging which are present.
+func appendDeltaCopy(out []byte, offset, n int) []byte {
+	op := len(out)
+	out = append(out, 0x80)
+	for i := range 4 {
+		if b := byte(offset >> (8 * i)); b != 0 {
+			out[op] |= 1 << i
+			out = append(out, b)
+		}
+	}
+	// A length of exactly 0x10000 is written as no length bytes at all.
+	if n != maxDeltaCopy {
+		for i := range 3 {
+			if b := byte(n >> (8 * i)); b != 0 {
+				out[op] |= 0x10 << i
+				out = append(out, b)
+			}
+		}
+	}
+	return out
+}
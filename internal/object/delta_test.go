@@ -0,0 +1,292 @@
+package object
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello world")
+	// base size 11, result size 11; copy "hello " (offset 0, size 6), then
+	// insert "there".
+	delta := []byte{11, 11, 0x90, 6, 5, 't', 'h', 'e', 'r', 'e'}
+
+	got, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatalf("applyDelta() error: %v", err)
+	}
+	if string(got) != "hello there" {
+		t.Errorf("applyDelta() = %q, want %q", got, "hello there")
+	}
+}
+
+func TestApplyDelta_CopyOffset(t *testing.T) {
+	base := []byte("0123456789")
+	// copy offset 4 size 3, then offset 0 size 2
+	delta := []byte{10, 5, 0x91, 4, 3, 0x90, 2}
+
+	got, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatalf("applyDelta() error: %v", err)
+	}
+	if string(got) != "45601" {
+		t.Errorf("applyDelta() = %q, want %q", got, "45601")
+	}
+}
+
+func TestComputeDelta(t *testing.T) {
+	var lines []string
+	for i := range 2000 {
+		lines = append(lines, fmt.Sprintf("line %d of a file that changes a little", i))
+	}
+	base := []byte(strings.Join(lines, "\n"))
+	lines[10] = "an edited line"
+	lines = append(lines[:500], lines[600:]...)
+	target := []byte("a new first line\n" + strings.Join(lines, "\n") + "\nand a new last one")
+
+	tests := []struct {
+		name         string
+		base, target []byte
+	}{
+		{"edited", base, target},
+		{"reversed", target, base},
+		{"identical", base, base},
+		{"copy longer than one op", bytes.Repeat(base, 5), bytes.Repeat(base, 5)},
+		{"nothing shared", []byte("abc"), []byte("xyz")},
+		{"empty target", base, nil},
+		{"empty base", nil, target},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta := computeDelta(tt.base, tt.target, 2*len(tt.target)+64)
+			if delta == nil {
+				t.Fatal("computeDelta() = nil, want a delta")
+			}
+			got, err := applyDelta(tt.base, delta)
+			if err != nil {
+				t.Fatalf("applyDelta() error: %v", err)
+			}
+			if !bytes.Equal(got, tt.target) {
+				t.Error("applyDelta(computeDelta()) doesn't reproduce the target")
+			}
+		})
+	}
+
+	if delta := computeDelta(base, target, len(target)); len(delta) > len(target)/20 {
+		t.Errorf("delta for a small edit is %d bytes of %d", len(delta), len(target))
+	}
+	if delta := computeDelta(base, target, 10); delta != nil {
+		t.Errorf("computeDelta() over maxSize = %d bytes, want nil", len(delta))
+	}
+}
+
+func TestApplyDelta_Invalid(t *testing.T) {
+	base := []byte("hello world")
+	tests := []struct {
+		name  string
+		delta []byte
+	}{
+		{"empty", nil},
+		{"wrong base size", []byte{10, 5, 0x90, 5}},
+		{"truncated result size", []byte{11, 0x80}},
+		{"copy past base", []byte{11, 6, 0x91, 8, 6}},
+		{"truncated copy", []byte{11, 6, 0x91}},
+		{"truncated insert", []byte{11, 6, 6, 'a', 'b'}},
+		{"reserved opcode", []byte{11, 1, 0}},
+		{"short result", []byte{11, 6, 0x90, 5}},
+		{"long result", []byte{11, 4, 0x90, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := applyDelta(base, tt.delta); err == nil {
+				t.Errorf("applyDelta() = %q, want error", got)
+			}
+		})
+	}
+}
+
+func TestApplyDelta_HugeDeclaredSize(t *testing.T) {
+	// Keep the size guard out of the way so the allocation is what's
+	// being tested.
+	setMaxObjectSize(t, 1<<40)
+
+	// A 9-byte delta declaring a 4 GiB result but inserting only "abc".
+	delta := []byte{11, 0x80, 0x80, 0x80, 0x80, 0x10, 3, 'a', 'b', 'c'}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := applyDelta([]byte("hello world"), delta)
+	runtime.ReadMemStats(&after)
+
+	if err == nil {
+		t.Fatal("applyDelta() with a short result: expected error")
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("applyDelta() allocated %d bytes for a %d-byte delta", n, len(delta))
+	}
+}
+
+func TestApplyDelta_GrowsPastPrealloc(t *testing.T) {
+	// A 256-byte base copied 64 times: the result is far larger than base
+	// and delta together, so it must grow beyond the preallocation.
+	base := bytes.Repeat([]byte("x"), 256)
+	delta := []byte{0x80, 0x02, 0x80, 0x80, 0x01} // base 256, result 16384
+	for range 64 {
+		delta = append(delta, 0x90|0x20, 0x00, 0x01) // copy offset 0, size 256
+	}
+	got, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatalf("applyDelta() error: %v", err)
+	}
+	if want := bytes.Repeat(base, 64); !bytes.Equal(got, want) {
+		t.Errorf("applyDelta() returned %d bytes, want %d", len(got), len(want))
+	}
+}
+
+// Fixture packs written by git from a three-commit history of one file,
+// so the older versions of f.txt are stored as deltas: ofs-delta.pack uses
+// OFS_DELTA (git pack-objects --delta-base-offset) and ref-delta.pack
+// REF_DELTA. The blob chains are 6a77d1 <- ed8039 <- f27c53.
+var deltaFixtureBlobs = []string{
+	"6a77d1c23d35a9c1509b41156c6884736eec427d",
+	"ed8039e1f0be5496c90427ca79cc2ffcd0cbe5a0",
+	"f27c53e837e3e3378172da8ea89fea801f33630d",
+}
+
+// copyFixturePack installs testdata/<name>.{pack,idx} into gitDir.
+func copyFixturePack(t *testing.T, gitDir, name string) {
+	t.Helper()
+	packDir := filepath.Join(gitDir, "objects", "pack")
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range []string{".pack", ".idx"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name+ext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(packDir, "pack-"+name+ext), data, 0444); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRead_DeltaFixtures(t *testing.T) {
+	for _, name := range []string{"ofs-delta", "ref-delta"} {
+		t.Run(name, func(t *testing.T) {
+			gitDir := testGitDir(t)
+			copyFixturePack(t, gitDir, name)
+
+			for i, hash := range deltaFixtureBlobs {
+				obj, err := Read(gitDir, hash)
+				if err != nil {
+					t.Fatalf("Read(%s) error: %v", hash[:7], err)
+				}
+				if obj.Type != TypeBlob {
+					t.Errorf("Read(%s) type = %q, want blob", hash[:7], obj.Type)
+				}
+				if got := HashBytes([]byte(Header(obj.Type, obj.Size) + string(obj.Body))); got != hash {
+					t.Errorf("Read(%s) body hashes to %s", hash[:7], got)
+				}
+				want := "line 1 of a file that is long enough to be worth deltifying\n"
+				if i == 0 && !bytes.HasPrefix(obj.Body, []byte(want)) {
+					t.Errorf("Read(%s) body starts %q", hash[:7], obj.Body[:40])
+				}
+
+				objType, size, err := ReadHeader(gitDir, hash)
+				if err != nil {
+					t.Fatalf("ReadHeader(%s) error: %v", hash[:7], err)
+				}
+				if objType != obj.Type || size != obj.Size {
+					t.Errorf("ReadHeader(%s) = %q %d, want %q %d", hash[:7], objType, size, obj.Type, obj.Size)
+				}
+
+				objType, size, r, err := ReadStream(gitDir, hash)
+				if err != nil {
+					t.Fatalf("ReadStream(%s) error: %v", hash[:7], err)
+				}
+				streamed, err := io.ReadAll(r)
+				r.Close()
+				if err != nil {
+					t.Fatalf("reading stream %s: %v", hash[:7], err)
+				}
+				if objType != obj.Type || size != obj.Size || !bytes.Equal(streamed, obj.Body) {
+					t.Errorf("ReadStream(%s) disagrees with Read", hash[:7])
+				}
+
+				if err := VerifyObject(gitDir, hash); err != nil {
+					t.Errorf("VerifyObject(%s) error: %v", hash[:7], err)
+				}
+			}
+		})
+	}
+}
+
+func TestRead_RefDeltaLooseBase(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	base := []byte("hello world")
+	baseHash, err := WriteObject(gitDir, TypeBlob, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := "hello there"
+	resultHash := HashBytes([]byte(Header(TypeBlob, int64(len(result))) + result))
+
+	baseName, _ := hex.DecodeString(baseHash)
+	delta := []byte{11, 11, 0x90, 6, 5, 't', 'h', 'e', 'r', 'e'}
+	writeTestPackEntries(t, gitDir, []string{resultHash}, [][]byte{
+		packEntryBytes(packRefDelta, baseName, delta),
+	})
+
+	obj, err := Read(gitDir, resultHash)
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if obj.Type != TypeBlob || string(obj.Body) != result {
+		t.Errorf("Read() = %q %q, want blob %q", obj.Type, obj.Body, result)
+	}
+}
+
+func TestRead_DeltaCycle(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	// Two REF_DELTA entries, each naming the other as its base.
+	a := strings.Repeat("a", 40)
+	b := strings.Repeat("b", 40)
+	aName, _ := hex.DecodeString(a)
+	bName, _ := hex.DecodeString(b)
+	delta := []byte{1, 1, 1, 'x'}
+	writeTestPackEntries(t, gitDir, []string{a, b}, [][]byte{
+		packEntryBytes(packRefDelta, bName, delta),
+		packEntryBytes(packRefDelta, aName, delta),
+	})
+
+	if _, err := Read(gitDir, a); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Read() of cyclic delta: got %v, want ErrCorrupt", err)
+	}
+	if _, _, err := ReadHeader(gitDir, a); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("ReadHeader() of cyclic delta: got %v, want ErrCorrupt", err)
+	}
+}
+
+func TestRead_DeltaBadOffset(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	// An OFS_DELTA whose base distance points before the start of the pack.
+	hash := strings.Repeat("c", 40)
+	writeTestPackEntries(t, gitDir, []string{hash}, [][]byte{
+		packEntryBytes(packOfsDelta, []byte{0x7f}, []byte{1, 1, 1, 'x'}),
+	})
+
+	if _, err := Read(gitDir, hash); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Read() got %v, want ErrCorrupt", err)
+	}
+}
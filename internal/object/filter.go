@@ -0,0 +1,107 @@
+package object
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// filterHashes is the number of bit positions set per object. With ten
+// bits per object this gives roughly a 1% false-positive rate.
+const (
+	filterHashes      = 7
+	filterBitsPerItem = 10
+)
+
+// ExistenceFilter speeds up existence checks over many hashes, such as
+// during fetch negotiation or prune, where most lookups are for objects
+// that aren't present. It is a bloom filter built from every loose and
+// packed object at construction time: a miss in the filter is a definite
+// "not found", while a hit falls through to a real lookup, so answers are
+// always correct.
+//
+// Objects written after the filter is built must be registered with Add,
+// or they will be reported missing.
+type ExistenceFilter struct {
+	gitDir string
+	bits   []uint64
+}
+
+// NewExistenceFilter builds a filter over all objects in gitDir.
+func NewExistenceFilter(gitDir string) (*ExistenceFilter, error) {
+	var names [][]byte
+
+	loose, err := ListObjects(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range loose {
+		raw, _ := hex.DecodeString(h)
+		names = append(names, raw)
+	}
+
+	lookups, err := loadPacks(gitDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading packs: %w", err)
+	}
+	for _, l := range lookups {
+		l.forEach(func(name []byte) {
+			names = append(names, name)
+		})
+	}
+
+	words := (len(names)*filterBitsPerItem + 63) / 64
+	f := &ExistenceFilter{gitDir: gitDir, bits: make([]uint64, max(words, 1))}
+	for _, name := range names {
+		f.add(name)
+	}
+	return f, nil
+}
+
+// Add records an object written after the filter was built.
+func (f *ExistenceFilter) Add(hash string) {
+	if raw, err := hex.DecodeString(hash); err == nil && len(raw) == 20 {
+		f.add(raw)
+	}
+}
+
+// Exists behaves like the package-level Exists, but answers ErrNotFound
+// without touching disk when the filter rules the object out. Partial
+// hashes can't be checked against the filter and always do a real lookup.
+func (f *ExistenceFilter) Exists(hash string) error {
+	raw, err := hex.DecodeString(hash)
+	if err == nil && len(raw) == 20 && !f.mayContain(raw) {
+		return fmt.Errorf("%w: %s", ErrNotFound, hash)
+	}
+	return Exists(f.gitDir, hash)
+}
+
+func (f *ExistenceFilter) add(name []byte) {
+	for _, bit := range f.positions(name) {
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *ExistenceFilter) mayContain(name []byte) bool {
+	for _, bit := range f.positions(name) {
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// positions derives the filter bits for an object. Object names are
+// already uniformly distributed, so two slices of the name serve as the
+// base hashes for double hashing.
+func (f *ExistenceFilter) positions(name []byte) [filterHashes]uint64 {
+	m := uint64(len(f.bits) * 64)
+	h1 := binary.BigEndian.Uint64(name[0:8])
+	h2 := binary.BigEndian.Uint64(name[8:16]) | 1
+
+	var pos [filterHashes]uint64
+	for i := range pos {
+		pos[i] = (h1 + uint64(i)*h2) % m
+	}
+	return pos
+}
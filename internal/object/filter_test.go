@@ -0,0 +1,47 @@
+package object
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExistenceFilter(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	loose := "ce013625030ba8dba906f756967f9e9ca394464a"
+	Write(gitDir, loose, []byte("blob 6\x00hello\n"))
+	packed, _ := writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("packed\n")}})
+
+	f, err := NewExistenceFilter(gitDir)
+	if err != nil {
+		t.Fatalf("NewExistenceFilter() error: %v", err)
+	}
+
+	for _, h := range []string{loose, packed[0], loose[:8]} {
+		if err := f.Exists(h); err != nil {
+			t.Errorf("Exists(%s): %v", h, err)
+		}
+	}
+
+	missing := "0000000000000000000000000000000000000000"
+	if err := f.Exists(missing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists(missing): got %v, want ErrNotFound", err)
+	}
+}
+
+func TestExistenceFilter_Add(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	f, err := NewExistenceFilter(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sha := "ce013625030ba8dba906f756967f9e9ca394464a"
+	Write(gitDir, sha, []byte("blob 6\x00hello\n"))
+	f.Add(sha)
+
+	if err := f.Exists(sha); err != nil {
+		t.Errorf("Exists() after Add: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package object
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ListObjects returns the hash of every loose object under
+// <gitDir>/objects, sorted. Only two-hex-digit fanout directories are
+// read, so objects/info and objects/pack are skipped, and files there
+// whose names don't complete a full hash (temporary files and the like)
+// are ignored.
+func ListObjects(gitDir string) ([]string, error) {
+	objectsDir := filepath.Join(gitDir, "objects")
+	fanout, err := os.ReadDir(objectsDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading objects dir: %w", err)
+	}
+
+	var hashes []string
+	for _, d := range fanout {
+		if !d.IsDir() || len(d.Name()) != 2 || !isHex(d.Name()) {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(objectsDir, d.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading object dir: %w", err)
+		}
+		for _, e := range entries {
+			h := d.Name() + e.Name()
+			if !e.IsDir() && isFullHash(h) && isHex(h) {
+				hashes = append(hashes, h)
+			}
+		}
+	}
+	sort.Strings(hashes)
+	return hashes, nil
+}
+
+// isHex reports whether s is lowercase hex, as object names are.
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
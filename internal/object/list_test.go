@@ -0,0 +1,33 @@
+package object
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListObjects(t *testing.T) {
+	gitDir := testGitDir(t)
+	hello := writeTestBlob(t, gitDir, "hello\n")
+	world := writeTestBlob(t, gitDir, "world\n")
+	long := writeSHA256Blob(t, gitDir, "hello\n")
+	writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("packed\n")}})
+
+	// Things that aren't loose objects.
+	objects := filepath.Join(gitDir, "objects")
+	os.MkdirAll(filepath.Join(objects, "info"), 0755)
+	os.WriteFile(filepath.Join(objects, "info", "packs"), nil, 0644)
+	os.WriteFile(filepath.Join(objects, hello[:2], "tmp_obj_123456"), nil, 0644)
+	os.MkdirAll(filepath.Join(objects, "zz"), 0755)
+	os.WriteFile(filepath.Join(objects, "zz", "0123456789012345678901234567890123456789"[:38]), nil, 0644)
+
+	got, err := ListObjects(gitDir)
+	if err != nil {
+		t.Fatalf("ListObjects() error: %v", err)
+	}
+	want := []string{long, world, hello}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// MaterializeBlob writes the blob sha to destPath as a working-tree file
+// with the given tree entry mode: a regular file (executable for 100755)
+// or, for 120000, a symlink whose target is the blob's content. Missing
+// parent directories are created.
+//
+// The file is written under a temporary name beside destPath and renamed
+// into place, so an interrupted write never leaves a torn file and an
+// existing file at destPath is replaced in one step. As in git, files are
+// created 0666 (0777 if executable) and directories 0777, less the
+// umask.
+func MaterializeBlob(gitDir, sha, destPath string, mode uint32) error {
+	if mode != ModeFile && mode != ModeExecutable && mode != ModeSymlink {
+		return fmt.Errorf("materializing %s: unsupported mode %06o", destPath, mode)
+	}
+
+	obj, err := Read(gitDir, sha)
+	if err != nil {
+		return err
+	}
+	if obj.Type != TypeBlob {
+		return fmt.Errorf("materializing %s: object %s is a %s, not a blob", destPath, obj.Hash, obj.Type)
+	}
+	return MaterializeData(obj.Body, destPath, mode)
+}
+
+// MaterializeData is MaterializeBlob for content already in hand, such as
+// a blob that has been through a smudge filter.
+func MaterializeData(data []byte, destPath string, mode uint32) error {
+	if mode != ModeFile && mode != ModeExecutable && mode != ModeSymlink {
+		return fmt.Errorf("materializing %s: unsupported mode %06o", destPath, mode)
+	}
+
+	dir := filepath.Dir(destPath)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return fmt.Errorf("creating %s: %w", dir, err)
+	}
+
+	perm := os.FileMode(0666)
+	if mode == ModeExecutable {
+		perm = 0777
+	}
+	prefix := filepath.Join(dir, "."+filepath.Base(destPath)+".tmp-")
+	tmpPath, err := createTemp(prefix, func(name string) error {
+		if mode == ModeSymlink {
+			return os.Symlink(string(data), name)
+		}
+		return writeNewFile(name, data, perm)
+	})
+	if err != nil {
+		return fmt.Errorf("materializing %s: %w", destPath, err)
+	}
+
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("materializing %s: %w", destPath, err)
+	}
+	return nil
+}
+
+// createTemp calls create with prefix plus a random suffix until it
+// finds a name that isn't taken, and returns that name. create must fail
+// with fs.ErrExist for a name in use. os.CreateTemp isn't used because it
+// fixes the mode at 0600, and a later chmod would ignore the umask.
+func createTemp(prefix string, create func(name string) error) (string, error) {
+	for range 10000 {
+		name := prefix + strconv.FormatUint(rand.Uint64(), 36)
+		err := create(name)
+		if err == nil {
+			return name, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no unused temporary name for %s", prefix)
+}
+
+// writeNewFile creates name, which must not exist, with permissions perm
+// less the umask, and writes data to it. A partly written file is
+// removed.
+func writeNewFile(name string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name)
+	}
+	return err
+}
@@ -0,0 +1,122 @@
+package object
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestBlob stores content as a loose blob and returns its hash.
+func writeTestBlob(t *testing.T, gitDir, content string) string {
+	t.Helper()
+	sha, full, err := Hash(TypeBlob, strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(gitDir, sha, full); err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+func TestMaterializeBlob_Modes(t *testing.T) {
+	gitDir := testGitDir(t)
+	work := t.TempDir()
+	sha := writeTestBlob(t, gitDir, "#!/bin/sh\necho hi\n")
+
+	// The exact permissions depend on the umask; see
+	// TestMaterializeBlob_Umask.
+	tests := []struct {
+		name string
+		mode uint32
+		exec bool
+	}{
+		{"regular", ModeFile, false},
+		{"executable", ModeExecutable, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(work, "a", "b", tt.name+".sh")
+			if err := MaterializeBlob(gitDir, sha, dest, tt.mode); err != nil {
+				t.Fatalf("MaterializeBlob() error: %v", err)
+			}
+			info, err := os.Lstat(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.Mode().IsRegular() || (info.Mode()&0100 != 0) != tt.exec {
+				t.Errorf("mode: got %v, want executable %v", info.Mode(), tt.exec)
+			}
+			data, _ := os.ReadFile(dest)
+			if string(data) != "#!/bin/sh\necho hi\n" {
+				t.Errorf("content: got %q", data)
+			}
+		})
+	}
+}
+
+func TestMaterializeBlob_Symlink(t *testing.T) {
+	gitDir := testGitDir(t)
+	work := t.TempDir()
+	sha := writeTestBlob(t, gitDir, "../target.txt")
+
+	dest := filepath.Join(work, "dir", "link")
+	if err := MaterializeBlob(gitDir, sha, dest, ModeSymlink); err != nil {
+		t.Fatalf("MaterializeBlob() error: %v", err)
+	}
+	target, err := os.Readlink(dest)
+	if err != nil {
+		t.Fatalf("expected a symlink: %v", err)
+	}
+	if target != "../target.txt" {
+		t.Errorf("link target: got %q", target)
+	}
+}
+
+func TestMaterializeBlob_Replaces(t *testing.T) {
+	gitDir := testGitDir(t)
+	work := t.TempDir()
+	dest := filepath.Join(work, "file")
+
+	// A symlink is replaced by a regular file rather than written through.
+	if err := os.Symlink("elsewhere", dest); err != nil {
+		t.Fatal(err)
+	}
+	sha := writeTestBlob(t, gitDir, "new\n")
+	if err := MaterializeBlob(gitDir, sha, dest, ModeFile); err != nil {
+		t.Fatalf("MaterializeBlob() error: %v", err)
+	}
+	info, err := os.Lstat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Mode().IsRegular() {
+		t.Errorf("expected a regular file, got %v", info.Mode())
+	}
+
+	// No temporary files are left behind.
+	entries, _ := os.ReadDir(work)
+	if len(entries) != 1 {
+		t.Errorf("expected only the materialized file, got %d entries", len(entries))
+	}
+}
+
+func TestMaterializeBlob_Errors(t *testing.T) {
+	gitDir := testGitDir(t)
+	work := t.TempDir()
+	sha := writeTestBlob(t, gitDir, "x")
+
+	if err := MaterializeBlob(gitDir, sha, filepath.Join(work, "sub"), ModeTree); err == nil {
+		t.Error("expected an error for a tree mode")
+	}
+
+	treeSha := "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	Write(gitDir, treeSha, []byte("tree 0\x00"))
+	if err := MaterializeBlob(gitDir, treeSha, filepath.Join(work, "t"), ModeFile); err == nil {
+		t.Error("expected an error for a non-blob object")
+	}
+	if _, err := os.Stat(filepath.Join(work, "t")); !os.IsNotExist(err) {
+		t.Errorf("nothing should be written on error, stat err: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+//go:build unix
+
+package object
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMaterializeBlob_Umask(t *testing.T) {
+	gitDir := testGitDir(t)
+	sha := writeTestBlob(t, gitDir, "data\n")
+
+	for _, tt := range []struct {
+		umask               int
+		file, exec, newDirs os.FileMode
+	}{
+		{0022, 0644, 0755, 0755},
+		{0027, 0640, 0750, 0750},
+		{0002, 0664, 0775, 0775},
+	} {
+		old := syscall.Umask(tt.umask)
+		work := t.TempDir()
+		file := filepath.Join(work, "d", "file")
+		exec := filepath.Join(work, "d", "exec")
+		errFile := MaterializeBlob(gitDir, sha, file, ModeFile)
+		errExec := MaterializeBlob(gitDir, sha, exec, ModeExecutable)
+		syscall.Umask(old)
+		if errFile != nil || errExec != nil {
+			t.Fatalf("MaterializeBlob() errors: %v, %v", errFile, errExec)
+		}
+
+		for path, want := range map[string]os.FileMode{file: tt.file, exec: tt.exec, filepath.Dir(file): tt.newDirs | os.ModeDir} {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode() != want {
+				t.Errorf("umask %04o: %s has mode %v, want %v", tt.umask, filepath.Base(path), info.Mode(), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package object
+
+import "strings"
+
+// Subject returns the subject of a commit or tag message: its first
+// paragraph (everything up to the first blank line) with each line
+// trimmed and joined by single spaces. Leading blank lines are skipped,
+// so a message without any blank line is all subject.
+func Subject(msg string) string {
+	lines := strings.Split(msg, "\n")
+
+	i := 0
+	for i < len(lines) && isBlankLine(lines[i]) {
+		i++
+	}
+
+	var parts []string
+	for ; i < len(lines) && !isBlankLine(lines[i]); i++ {
+		parts = append(parts, strings.TrimSpace(lines[i]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Body returns everything in msg after the subject paragraph, with the
+// separating blank lines and any trailing whitespace removed. It returns
+// "" for a subject-only message.
+func Body(msg string) string {
+	lines := strings.Split(msg, "\n")
+
+	i := 0
+	for i < len(lines) && isBlankLine(lines[i]) {
+		i++
+	}
+	for i < len(lines) && !isBlankLine(lines[i]) {
+		i++
+	}
+	for i < len(lines) && isBlankLine(lines[i]) {
+		i++
+	}
+
+	return strings.TrimRight(strings.Join(lines[i:], "\n"), " \t\r\n")
+}
+
+// Wrap word-wraps each line of text to at most width columns, keeping
+// blank lines (paragraph breaks) intact. Words longer than width are put
+// on a line of their own rather than split. A width <= 0 disables
+// wrapping.
+func Wrap(text string, width int) string {
+	if width <= 0 {
+		return text
+	}
+
+	var out []string
+	for _, line := range strings.Split(text, "\n") {
+		words := strings.Fields(line)
+		if len(words) == 0 {
+			out = append(out, "")
+			continue
+		}
+
+		current := words[0]
+		for _, w := range words[1:] {
+			if len(current)+1+len(w) > width {
+				out = append(out, current)
+				current = w
+				continue
+			}
+			current += " " + w
+		}
+		out = append(out, current)
+	}
+	return strings.Join(out, "\n")
+}
+
+// isBlankLine reports whether a message line is empty or only whitespace,
+// which is what separates paragraphs.
+func isBlankLine(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
@@ -0,0 +1,50 @@
+package object
+
+import "testing"
+
+func TestSubjectAndBody(t *testing.T) {
+	tests := []struct {
+		name, msg, subject, body string
+	}{
+		{"subject only", "Fix bug\n", "Fix bug", ""},
+		{"no trailing newline", "Fix bug", "Fix bug", ""},
+		{"subject and body", "Fix bug\n\nLonger explanation.\n", "Fix bug", "Longer explanation."},
+		{"multi-line subject", "Fix a bug\nthat wraps\n\nBody\n", "Fix a bug that wraps", "Body"},
+		{"leading blank lines", "\n\nSubject\n\nBody\n", "Subject", "Body"},
+		{"whitespace-only separator", "Subject  \n \t \nBody line\n", "Subject", "Body line"},
+		{"extra blank lines before body", "Subject\n\n\n\nBody\n\n\n", "Subject", "Body"},
+		{"body paragraphs kept", "S\n\nP1\n\nP2\n", "S", "P1\n\nP2"},
+		{"empty", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Subject(tt.msg); got != tt.subject {
+				t.Errorf("Subject: got %q, want %q", got, tt.subject)
+			}
+			if got := Body(tt.msg); got != tt.body {
+				t.Errorf("Body: got %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+		want  string
+	}{
+		{"the quick brown fox", 10, "the quick\nbrown fox"},
+		{"short", 10, "short"},
+		{"a\n\nb", 10, "a\n\nb"},
+		{"supercalifragilistic word", 10, "supercalifragilistic\nword"},
+		{"no wrapping at all", 0, "no wrapping at all"},
+	}
+
+	for _, tt := range tests {
+		if got := Wrap(tt.text, tt.width); got != tt.want {
+			t.Errorf("Wrap(%q, %d): got %q, want %q", tt.text, tt.width, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package object
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Multi-pack-index chunk IDs.
+const (
+	chunkPackNames    = 0x504e414d // "PNAM"
+	chunkOIDFanout    = 0x4f494446 // "OIDF"
+	chunkOIDLookup    = 0x4f49444c // "OIDL"
+	chunkObjOffsets   = 0x4f4f4646 // "OOFF"
+	chunkLargeOffsets = 0x4c4f4646 // "LOFF"
+)
+
+// multiPackIndex is a parsed objects/pack/multi-pack-index file: one
+// sorted object table covering several packs, so a lookup is a single
+// binary search instead of one per pack.
+type multiPackIndex struct {
+	oidTable
+	// idxNames are the .idx file names of the covered packs, indexed by
+	// pack-int-id.
+	idxNames []string
+	packDir  string
+	offsets  []byte // OOFF chunk: (pack-int-id, offset) pairs
+	large    []byte // LOFF chunk, may be empty
+}
+
+// parseMultiPackIndex parses a version 1 multi-pack-index.
+func parseMultiPackIndex(data []byte, packDir string) (*multiPackIndex, error) {
+	if len(data) < 12 || string(data[:4]) != "MIDX" {
+		return nil, fmt.Errorf("not a multi-pack-index")
+	}
+	if data[4] != 1 {
+		return nil, fmt.Errorf("unsupported multi-pack-index version %d", data[4])
+	}
+	if data[5] != 1 {
+		return nil, fmt.Errorf("unsupported multi-pack-index hash version %d", data[5])
+	}
+	numChunks := int(data[6])
+	numPacks := int(binary.BigEndian.Uint32(data[8:12]))
+
+	// The chunk table has one extra terminating entry whose offset marks
+	// the end of the last chunk.
+	tableEnd := 12 + (numChunks+1)*12
+	if len(data) < tableEnd {
+		return nil, fmt.Errorf("truncated multi-pack-index chunk table")
+	}
+	chunks := make(map[uint32][]byte, numChunks)
+	for i := range numChunks {
+		entry := data[12+i*12:]
+		next := data[12+(i+1)*12:]
+		id := binary.BigEndian.Uint32(entry)
+		start := binary.BigEndian.Uint64(entry[4:])
+		end := binary.BigEndian.Uint64(next[4:])
+		if start > end || end > uint64(len(data)) {
+			return nil, fmt.Errorf("multi-pack-index chunk %08x out of range", id)
+		}
+		chunks[id] = data[start:end]
+	}
+
+	for _, id := range []uint32{chunkPackNames, chunkOIDFanout, chunkOIDLookup, chunkObjOffsets} {
+		if _, ok := chunks[id]; !ok {
+			return nil, fmt.Errorf("multi-pack-index missing required chunk %08x", id)
+		}
+	}
+
+	table, err := parseOIDTable(chunks[chunkOIDFanout], chunks[chunkOIDLookup])
+	if err != nil {
+		return nil, fmt.Errorf("parsing multi-pack-index: %w", err)
+	}
+	if len(chunks[chunkObjOffsets]) < table.count()*8 {
+		return nil, fmt.Errorf("parsing multi-pack-index: truncated offset chunk")
+	}
+
+	names := strings.Split(string(bytes.TrimRight(chunks[chunkPackNames], "\x00")), "\x00")
+	if len(names) != numPacks {
+		return nil, fmt.Errorf("multi-pack-index lists %d pack names, header says %d", len(names), numPacks)
+	}
+
+	return &multiPackIndex{
+		oidTable: table,
+		idxNames: names,
+		packDir:  packDir,
+		offsets:  chunks[chunkObjOffsets],
+		large:    chunks[chunkLargeOffsets],
+	}, nil
+}
+
+func (m *multiPackIndex) find(prefix string) []packEntry {
+	lo, hi := m.search(prefix)
+	var entries []packEntry
+	for i := lo; i < hi; i++ {
+		packID := binary.BigEndian.Uint32(m.offsets[i*8:])
+		off := uint64(binary.BigEndian.Uint32(m.offsets[i*8+4:]))
+		if off&0x80000000 != 0 {
+			pos := int(off&0x7fffffff) * 8
+			if pos+8 > len(m.large) {
+				continue
+			}
+			off = binary.BigEndian.Uint64(m.large[pos:])
+		}
+		if int(packID) >= len(m.idxNames) {
+			continue
+		}
+		packName := strings.TrimSuffix(m.idxNames[packID], ".idx") + ".pack"
+		entries = append(entries, packEntry{
+			hash:     m.name(i),
+			packPath: filepath.Join(m.packDir, packName),
+			offset:   off,
+		})
+	}
+	return entries
+}
@@ -6,16 +6,46 @@ import (
 	"bufio"
 	"bytes"
 	"compress/zlib"
-	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/elliota43/rev/internal/trace"
+)
+
+var (
+	// ErrNotFound is returned when no object matches a hash.
+	ErrNotFound = errors.New("object not found")
+	// ErrCorrupt is returned when an object exists but can't be read back:
+	// its data fails to inflate or its header doesn't parse.
+	ErrCorrupt = errors.New("corrupt object")
+	// ErrTooLarge is returned when an object exceeds MaxObjectSize.
+	ErrTooLarge = errors.New("object exceeds size limit")
+	// ErrAmbiguous is returned when an abbreviated hash matches more than
+	// one object.
+	ErrAmbiguous = errors.New("ambiguous hash prefix")
 )
 
+// MaxObjectSize caps how large an object Read or ReadStream will open,
+// guarding against crafted objects: a header declaring more is rejected
+// before inflating, and inflation is cut off once the output grows past
+// the cap. repository.Open sets it from core.maxObjectSize. Zero or less
+// disables the limit.
+var MaxObjectSize int64 = DefaultMaxObjectSize
+
+// DefaultMaxObjectSize is MaxObjectSize when core.maxObjectSize isn't set.
+const DefaultMaxObjectSize = 1 << 30
+
+// corrupt wraps a read failure for an object that exists on disk.
+func corrupt(hash string, err error) error {
+	return fmt.Errorf("object %s: %w: %w", hash, ErrCorrupt, err)
+}
+
 // Type represents a Git object type.
 type Type string
 
@@ -26,6 +56,16 @@ const (
 	TypeTag    Type = "tag"
 )
 
+// ParseType returns the Type named by s, or an error if s isn't one of
+// the four object types.
+func ParseType(s string) (Type, error) {
+	switch t := Type(s); t {
+	case TypeBlob, TypeTree, TypeCommit, TypeTag:
+		return t, nil
+	}
+	return "", fmt.Errorf("invalid object type %q", s)
+}
+
 // Object represents a parsed Git object from the object database.
 type Object struct {
 	Type Type
@@ -42,7 +82,12 @@ func Header(objType Type, size int64) string {
 // HashBytes computes the SHA-1 of a full git object (header + content)
 // and returns the hex-encoded hash.
 func HashBytes(fullObject []byte) string {
-	h := sha1.New()
+	return HashBytesWith(SHA1, fullObject)
+}
+
+// HashBytesWith is HashBytes using the given algorithm.
+func HashBytesWith(algo Algorithm, fullObject []byte) string {
+	h := algo.new()
 	h.Write(fullObject)
 	return hex.EncodeToString(h.Sum(nil))
 }
@@ -51,6 +96,11 @@ func HashBytes(fullObject []byte) string {
 // It reads all content from r, builds the full object (header + content),
 // and returns the hex SHA-1 and the full object bytes.
 func Hash(objType Type, r io.Reader, size int64) (sha string, fullObject []byte, err error) {
+	return HashWith(SHA1, objType, r, size)
+}
+
+// HashWith is Hash using the given algorithm.
+func HashWith(algo Algorithm, objType Type, r io.Reader, size int64) (sha string, fullObject []byte, err error) {
 	content, err := io.ReadAll(r)
 	if err != nil {
 		return "", nil, fmt.Errorf("reading content: %w", err)
@@ -61,7 +111,7 @@ func Hash(objType Type, r io.Reader, size int64) (sha string, fullObject []byte,
 	buf.Write(content)
 
 	fullObject = buf.Bytes()
-	sha = HashBytes(fullObject)
+	sha = HashBytesWith(algo, fullObject)
 	return sha, fullObject, nil
 }
 
@@ -78,17 +128,46 @@ func compress(data []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// decompress zlib-decompresses data and returnsn the raw bytes.
+// maxHeaderLen bounds the "<type> <size>\0" header of a loose object,
+// which is counted on top of MaxObjectSize when inflating.
+const maxHeaderLen = 32
+
+// decompress zlib-decompresses data and returns the raw bytes. Inflating
+// stops with ErrTooLarge once the output exceeds what an object within
+// MaxObjectSize could need, so a zlib bomb can't exhaust memory.
 func decompress(data []byte) ([]byte, error) {
 	r, err := zlib.NewReader(bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("creating zlib reader: %w", err)
 	}
 	defer r.Close()
-	return io.ReadAll(r)
+
+	if MaxObjectSize <= 0 {
+		return io.ReadAll(r)
+	}
+
+	limit := MaxObjectSize + maxHeaderLen
+	raw, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(raw)) > limit {
+		return nil, fmt.Errorf("%w: inflates past %d bytes", ErrTooLarge, MaxObjectSize)
+	}
+	return raw, nil
+}
+
+// checkSize rejects a declared object size above MaxObjectSize.
+func checkSize(hash string, size int64) error {
+	if MaxObjectSize > 0 && size > MaxObjectSize {
+		return fmt.Errorf("object %s: %w: declares %d bytes, limit is %d", hash, ErrTooLarge, size, MaxObjectSize)
+	}
+	return nil
 }
 
 // parseRaw splits raw decompressed object bytes into type, size, and body.
+// The type is returned as written; callers decide whether to accept
+// unknown types.
 func parseRaw(raw []byte) (Type, int64, []byte, error) {
 	// Find the null byte separating header from body
 	nullIdx := bytes.IndexByte(raw, 0)
@@ -96,29 +175,80 @@ func parseRaw(raw []byte) (Type, int64, []byte, error) {
 		return "", 0, nil, fmt.Errorf("malformed object: no null byte in header")
 	}
 
-	header := string(raw[:nullIdx])
+	objType, size, err := parseHeader(string(raw[:nullIdx]))
+	if err != nil {
+		return "", 0, nil, err
+	}
+	return objType, size, raw[nullIdx+1:], nil
+}
+
+// parseHeader parses "<type> <size>", an object header without its
+// trailing NUL.
+func parseHeader(header string) (Type, int64, error) {
 	parts := strings.SplitN(header, " ", 2)
 	if len(parts) != 2 {
-		return "", 0, nil, fmt.Errorf("malformed object header: %q", header)
+		return "", 0, fmt.Errorf("malformed object header: %q", header)
 	}
 
 	size, err := strconv.ParseInt(parts[1], 10, 64)
 	if err != nil {
-		return "", 0, nil, fmt.Errorf("parsing object size: %w", err)
+		return "", 0, fmt.Errorf("parsing object size: %w", err)
 	}
+	return Type(parts[0]), size, nil
+}
 
-	return Type(parts[0]), size, raw[nullIdx+1:], nil
+// checkType rejects a header type that isn't one of the four object
+// types.
+func checkType(hash string, objType Type) error {
+	if _, err := ParseType(string(objType)); err != nil {
+		return corrupt(hash, err)
+	}
+	return nil
 }
 
 // Write writes a raw git object (header + content) to the object database
 // under the given gitDir. It compresses the data with zlib and stores it
 // at <gitDir>/objects/<sha[0:2]>/<sha[2:]>.
 func Write(gitDir string, sha string, fullObject []byte) error {
-	if len(sha) != 40 {
+	return writeLoose(filepath.Join(gitDir, "objects"), sha, fullObject)
+}
+
+// WriteVerified stores a raw git object (header + content) under the
+// SHA-1 it hashes to, and returns that hash. Unlike Write, the caller
+// can't pass a name that disagrees with the data. The header is checked
+// too: the type must be known and the size must match the content.
+func WriteVerified(gitDir string, fullObject []byte) (string, error) {
+	return WriteVerifiedWith(SHA1, gitDir, fullObject)
+}
+
+// WriteVerifiedWith is WriteVerified using the given algorithm.
+func WriteVerifiedWith(algo Algorithm, gitDir string, fullObject []byte) (string, error) {
+	sha := HashBytesWith(algo, fullObject)
+	objType, size, body, err := parseRaw(fullObject)
+	if err != nil {
+		return "", fmt.Errorf("writing object %s: %w", sha, err)
+	}
+	if _, err := ParseType(string(objType)); err != nil {
+		return "", fmt.Errorf("writing object %s: %w", sha, err)
+	}
+	if int64(len(body)) != size {
+		return "", fmt.Errorf("writing object %s: header declares %d bytes but body has %d", sha, size, len(body))
+	}
+
+	if err := Write(gitDir, sha, fullObject); err != nil {
+		return "", err
+	}
+	return sha, nil
+}
+
+// writeLoose stores a compressed object under objectsDir, which is either
+// the repository's object store or a quarantine directory.
+func writeLoose(objectsDir string, sha string, fullObject []byte) error {
+	if !isFullHash(sha) {
 		return fmt.Errorf("invalid sha length %d: %q", len(sha), sha)
 	}
 
-	dir := filepath.Join(gitDir, "objects", sha[:2])
+	dir := filepath.Join(objectsDir, sha[:2])
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("creating object dir: %w", err)
 	}
@@ -127,6 +257,9 @@ func Write(gitDir string, sha string, fullObject []byte) error {
 
 	// Already exists - git objects are content-addressed and immutable.
 	if _, err := os.Stat(objPath); err == nil {
+		if trace.Enabled() {
+			trace.Log("object", "write %s skipped (exists)", sha)
+		}
 		return nil
 	}
 
@@ -135,109 +268,302 @@ func Write(gitDir string, sha string, fullObject []byte) error {
 		return err
 	}
 
-	if err := os.WriteFile(objPath, compressed, 0444); err != nil {
+	// Write under a temporary name and rename into place, so readers never
+	// see a partial object. Concurrent writers of the same object each
+	// rename a complete copy of identical content, so whichever lands last
+	// is as good as the first.
+	tmp, err := os.CreateTemp(dir, "tmp_obj_")
+	if err != nil {
+		return fmt.Errorf("writing object file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	_, err = tmp.Write(compressed)
+	if err == nil {
+		err = tmp.Chmod(0444)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, objPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
 		return fmt.Errorf("writing object file: %w", err)
 	}
+	if trace.Enabled() {
+		trace.Log("object", "write %s (%d bytes)", sha, len(compressed))
+	}
 
 	return nil
 }
 
+// WriteObject hashes content as an object of the given type, stores it,
+// and returns its hash. It is Hash followed by Write, for callers that
+// build object bodies in memory.
+func WriteObject(gitDir string, objType Type, content []byte) (string, error) {
+	return WriteObjectWith(SHA1, gitDir, objType, content)
+}
+
+// WriteObjectWith is WriteObject using the given algorithm.
+func WriteObjectWith(algo Algorithm, gitDir string, objType Type, content []byte) (string, error) {
+	sha, fullObject, err := HashWith(algo, objType, bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", err
+	}
+	if err := Write(gitDir, sha, fullObject); err != nil {
+		return "", fmt.Errorf("writing %s: %w", objType, err)
+	}
+	return sha, nil
+}
+
 // Read reads and parses a git object from the object database by its full
 // or partial hash. It supports short hashes (min 4 characters) and returns
-// an error if the hash is ambiguous.
+// an error if the hash is ambiguous. Both loose objects and objects in any
+// packfile are found. An object whose body length differs from the size in
+// its header is reported as ErrCorrupt.
 func Read(gitDir string, hash string) (*Object, error) {
-	objPath, resolvedHash, err := resolvePath(gitDir, hash)
+	return read(gitDir, hash, true)
+}
+
+// ReadUnchecked is Read without the header size check, for tooling that
+// needs to inspect malformed objects. Size is the declared size, which
+// may not match len(Body).
+func ReadUnchecked(gitDir string, hash string) (*Object, error) {
+	return read(gitDir, hash, false)
+}
+
+func read(gitDir string, hash string, checked bool) (*Object, error) {
+	loc, err := locate(gitDir, hash)
 	if err != nil {
 		return nil, err
 	}
+	if trace.Enabled() {
+		trace.Log("object", "read %s (%s)", loc.hash, loc.source())
+	}
+	if loc.packed() {
+		return readPacked(loc.pack)
+	}
+	return readLoose(loc.loosePath, loc.hash, checked)
+}
 
-	compressed, err := os.ReadFile(objPath)
+// readLoose reads the loose object hash from the file at path.
+func readLoose(path, hash string, checked bool) (*Object, error) {
+	compressed, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("reading object file: %w", err)
 	}
 
 	raw, err := decompress(compressed)
+	if errors.Is(err, ErrTooLarge) {
+		return nil, fmt.Errorf("object %s: %w", hash, err)
+	}
 	if err != nil {
-		return nil, err
+		return nil, corrupt(hash, err)
 	}
 
 	objType, size, body, err := parseRaw(raw)
 	if err != nil {
+		return nil, corrupt(hash, err)
+	}
+	if err := checkSize(hash, size); err != nil {
 		return nil, err
 	}
+	if checked {
+		if err := checkType(hash, objType); err != nil {
+			return nil, err
+		}
+		if int64(len(body)) != size {
+			return nil, corrupt(hash, fmt.Errorf("header declares %d bytes but body has %d", size, len(body)))
+		}
+	}
 
 	return &Object{
 		Type: objType,
 		Size: size,
-		Hash: resolvedHash,
+		Hash: hash,
 		Body: body,
 	}, nil
 }
 
+// ReadHeader returns an object's type and size by inflating only its
+// header, so callers like `cat-file -s` don't pay for the whole body.
+// For packed objects the size comes from the pack entry header, so
+// nothing is inflated at all.
+func ReadHeader(gitDir string, hash string) (Type, int64, error) {
+	loc, err := locate(gitDir, hash)
+	if err != nil {
+		return "", 0, err
+	}
+	if trace.Enabled() {
+		trace.Log("object", "read header %s (%s)", loc.hash, loc.source())
+	}
+	if loc.packed() {
+		return readPackedHeader(loc.pack)
+	}
+
+	f, err := os.Open(loc.loosePath)
+	if err != nil {
+		return "", 0, fmt.Errorf("opening object file: %w", err)
+	}
+	defer f.Close()
+
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return "", 0, corrupt(loc.hash, fmt.Errorf("creating zlib reader: %w", err))
+	}
+	defer zr.Close()
+
+	objType, size, err := parseHeaderFromReader(bufio.NewReader(zr))
+	if err != nil {
+		return "", 0, corrupt(loc.hash, err)
+	}
+	if err := checkType(loc.hash, objType); err != nil {
+		return "", 0, err
+	}
+	return objType, size, nil
+}
+
 // Exists returns nil if the object identified by hash exists, or an error.
 func Exists(gitDir string, hash string) error {
-	_, _, err := resolvePath(gitDir, hash)
+	_, err := locate(gitDir, hash)
 	return err
 }
 
-// resolvePath resolves a full or partial hash to the object's file path
-// and full 40-char hash. Returns an error if the object doesn't exist or
-// the hash is ambiguous.
-func resolvePath(gitDir, hash string) (path string, fullHash string, err error) {
+// ResolveHash expands a full or abbreviated hash to the full hash of the
+// one object it names, with the same not-found and ambiguity errors as
+// Read.
+func ResolveHash(gitDir string, hash string) (string, error) {
+	loc, err := locate(gitDir, hash)
+	if err != nil {
+		return "", err
+	}
+	return loc.hash, nil
+}
+
+// location says where an object lives: a loose file or a pack entry.
+type location struct {
+	hash      string
+	loosePath string
+	pack      packEntry
+}
+
+func (l location) packed() bool {
+	return l.loosePath == ""
+}
+
+// source describes the location for trace output.
+func (l location) source() string {
+	if l.packed() {
+		return "packed in " + filepath.Base(l.pack.packPath)
+	}
+	return "loose"
+}
+
+// locate resolves a full or partial hash to the object's location and
+// full 40-char hash, looking at loose objects first and then every pack.
+// Returns ErrNotFound if nothing matches, or an error if the hash is
+// ambiguous.
+func locate(gitDir, hash string) (location, error) {
 	if len(hash) < 4 {
-		return "", "", fmt.Errorf("hash prefix too short (minimum 4 chars): %q", hash)
+		return location{}, fmt.Errorf("hash prefix too short (minimum 4 chars): %q", hash)
 	}
 
+	loose, err := resolveLoose(gitDir, hash)
+	if err != nil {
+		return location{}, err
+	}
+
+	// Fast path: a full hash found loose needs no pack lookups.
+	if p, ok := loose[hash]; ok {
+		return location{hash: hash, loosePath: p}, nil
+	}
+
+	packed, err := findPacked(gitDir, hash)
+	if err != nil {
+		return location{}, fmt.Errorf("reading packs: %w", err)
+	}
+
+	matches := make(map[string]location, len(loose)+len(packed))
+	for h, p := range loose {
+		matches[h] = location{hash: h, loosePath: p}
+	}
+	for _, e := range packed {
+		if _, ok := matches[e.hash]; !ok {
+			matches[e.hash] = location{hash: e.hash, pack: e}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return location{}, fmt.Errorf("%w: %s", ErrNotFound, hash)
+	case 1:
+		for _, loc := range matches {
+			return loc, nil
+		}
+	}
+	return location{}, fmt.Errorf("%w %s (%d matches)", ErrAmbiguous, hash, len(matches))
+}
+
+// readDir is os.ReadDir, replaced in tests to check which lookups scan
+// an object directory.
+var readDir = os.ReadDir
+
+// resolveLoose returns the loose objects matching a full or partial hash,
+// keyed by full hash with their file paths as values.
+func resolveLoose(gitDir, hash string) (map[string]string, error) {
 	objDir := filepath.Join(gitDir, "objects", hash[:2])
+	matches := make(map[string]string)
 
-	// Fast path: full 40-char hash - just check the file directly
-	if len(hash) == 40 {
+	// Fast path: a full-length hash - just check the file directly. A
+	// SHA-1-length name that isn't there may still be a prefix of a
+	// SHA-256 name, so only the longest length can skip the scan on a miss.
+	if isFullHash(hash) {
 		p := filepath.Join(objDir, hash[2:])
-		if _, err := os.Stat(p); err != nil {
-			return "", "", fmt.Errorf("object %s not found", hash)
+		if _, err := os.Stat(p); err == nil {
+			matches[hash] = p
+			return matches, nil
+		}
+		if len(hash) == SHA256.HexLen() {
+			return matches, nil
 		}
-		return p, hash, nil
 	}
 
 	// Partial hash: scan the directory for matching prefixes.
 	prefix := hash[2:]
-	entries, err := os.ReadDir(objDir)
+	entries, err := readDir(objDir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return "", "", fmt.Errorf("object %s not found", hash)
+			return matches, nil
 		}
-		return "", "", fmt.Errorf("reading object dir: %w", err)
+		return nil, fmt.Errorf("reading object dir: %w", err)
 	}
 
-	var matches []string
 	for _, e := range entries {
 		if !e.IsDir() && strings.HasPrefix(e.Name(), prefix) {
-			matches = append(matches, e.Name())
+			matches[hash[:2]+e.Name()] = filepath.Join(objDir, e.Name())
 		}
 	}
-
-	switch len(matches) {
-	case 0:
-		return "", "", fmt.Errorf("object %s not found", hash)
-	case 1:
-		full := hash[:2] + matches[0]
-		return filepath.Join(objDir, matches[0]), full, nil
-	default:
-		return "", "", fmt.Errorf("ambiguous hash prefix %s (%d matches)", hash, len(matches))
-	}
+	return matches, nil
 }
 
-// PrettyPrint returns a human-readable representation of the object.
-// For blobs, this is just the raw content. For trees and commits,
-// this can be expanded later with structured formatting.
+// PrettyPrint returns a human-readable representation of the object, as
+// cat-file -p prints it. Trees are listed one ls-tree line per entry;
+// everything else, commits and tags included, is the raw body, so headers
+// rev doesn't model (gpgsig, mergetag, encoding) survive byte for byte and
+// a signature can still be checked against the output.
 func (o *Object) PrettyPrint() string {
-	switch o.Type {
-	case TypeBlob:
-		return string(o.Body)
-	default:
-		// TODO: structured formatting for tree/commit/tag
-		return string(o.Body)
+	if o.Type == TypeTree {
+		if entries, err := ParseTreeWith(algorithmFor(o.Hash), o.Body); err == nil {
+			var b strings.Builder
+			for _, e := range entries {
+				b.WriteString(e.String())
+				b.WriteByte('\n')
+			}
+			return b.String()
+		}
 	}
+	return string(o.Body)
 }
 
 // FormatHeader returns the "<type> <size>" string for display (without null byte).
@@ -253,17 +579,5 @@ func parseHeaderFromReader(br *bufio.Reader) (Type, int64, error) {
 	if err != nil {
 		return "", 0, fmt.Errorf("reading object header: %w", err)
 	}
-
-	header = strings.TrimRight(header, "\x00")
-	parts := strings.SplitN(header, " ", 2)
-	if len(parts) != 2 {
-		return "", 0, fmt.Errorf("malformed object header: %q", header)
-	}
-
-	size, err := strconv.ParseInt(parts[1], 10, 64)
-	if err != nil {
-		return "", 0, fmt.Errorf("parsing object size: %w", err)
-	}
-
-	return Type(parts[0]), size, nil
+	return parseHeader(strings.TrimSuffix(header, "\x00"))
 }
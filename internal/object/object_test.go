@@ -2,9 +2,11 @@ package object
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -40,6 +42,19 @@ func TestHash_Blob(t *testing.T) {
 	}
 }
 
+func TestParseType(t *testing.T) {
+	for _, name := range []string{"blob", "tree", "commit", "tag"} {
+		if got, err := ParseType(name); err != nil || string(got) != name {
+			t.Errorf("ParseType(%q) = %q, %v", name, got, err)
+		}
+	}
+	for _, bad := range []string{"", "Blob", "ofs-delta"} {
+		if _, err := ParseType(bad); err == nil {
+			t.Errorf("ParseType(%q): expected error", bad)
+		}
+	}
+}
+
 func TestHash_EmptyBlob(t *testing.T) {
 	sha, _, err := Hash(TypeBlob, bytes.NewReader(nil), 0)
 	if err != nil {
@@ -52,6 +67,35 @@ func TestHash_EmptyBlob(t *testing.T) {
 	}
 }
 
+func TestHashWith_SHA256(t *testing.T) {
+	content := []byte("hello\n")
+	sha, _, err := HashWith(SHA256, TypeBlob, bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("HashWith() error: %v", err)
+	}
+
+	// git hash-object in a repository created with --object-format=sha256
+	want := "2cf8d83d9ee29543b34a87727421fdecb7e3f3a183d337639025de576db9ebb4"
+	if sha != want {
+		t.Errorf("SHA mismatch: got %s, want %s", sha, want)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for name, want := range map[string]Algorithm{"": SHA1, "sha1": SHA1, "sha256": SHA256} {
+		got, err := ParseAlgorithm(name)
+		if err != nil || got != want {
+			t.Errorf("ParseAlgorithm(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseAlgorithm("md5"); err == nil {
+		t.Error("ParseAlgorithm(md5): expected error")
+	}
+	if SHA1.HexLen() != 40 || SHA256.HexLen() != 64 {
+		t.Errorf("HexLen: got %d and %d", SHA1.HexLen(), SHA256.HexLen())
+	}
+}
+
 // --- Write / Read round-trip ---
 
 func TestWriteAndRead(t *testing.T) {
@@ -83,6 +127,32 @@ func TestWriteAndRead(t *testing.T) {
 	}
 }
 
+func TestWriteAndRead_SHA256(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	content := []byte("hello\n")
+	sha, data, err := HashWith(SHA256, TypeBlob, bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(gitDir, sha, data); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "objects", sha[:2], sha[2:])); err != nil {
+		t.Fatalf("expected loose object at objects/xx/rest: %v", err)
+	}
+
+	for _, name := range []string{sha, sha[:10]} {
+		obj, err := Read(gitDir, name)
+		if err != nil {
+			t.Fatalf("Read(%s) error: %v", name, err)
+		}
+		if obj.Hash != sha || !bytes.Equal(obj.Body, content) {
+			t.Errorf("Read(%s): got hash %s body %q", name, obj.Hash, obj.Body)
+		}
+	}
+}
+
 func TestWrite_Idempotent(t *testing.T) {
 	gitDir := testGitDir(t)
 
@@ -100,6 +170,77 @@ func TestWrite_Idempotent(t *testing.T) {
 
 // --- Partial hash resolution ---
 
+func TestWriteVerified(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	sha, err := WriteVerified(gitDir, []byte("blob 6\x00hello\n"))
+	if err != nil {
+		t.Fatalf("WriteVerified() error: %v", err)
+	}
+	if sha != "ce013625030ba8dba906f756967f9e9ca394464a" {
+		t.Errorf("sha: got %s", sha)
+	}
+	if err := VerifyObject(gitDir, sha); err != nil {
+		t.Errorf("stored object doesn't verify: %v", err)
+	}
+
+	sha256, err := WriteVerifiedWith(SHA256, gitDir, []byte("blob 6\x00hello\n"))
+	if err != nil || sha256 != "2cf8d83d9ee29543b34a87727421fdecb7e3f3a183d337639025de576db9ebb4" {
+		t.Errorf("WriteVerifiedWith(SHA256): got %s, %v", sha256, err)
+	}
+
+	for _, bad := range []string{
+		"blob 6 hello\n",
+		"blob 9\x00hello\n",
+		"widget 6\x00hello\n",
+	} {
+		if _, err := WriteVerified(gitDir, []byte(bad)); err == nil {
+			t.Errorf("WriteVerified(%q): expected error", bad)
+		}
+	}
+}
+
+func TestWrite_Concurrent(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	content := bytes.Repeat([]byte("concurrent\n"), 10000)
+	sha, data, _ := Hash(TypeBlob, bytes.NewReader(content), int64(len(content)))
+
+	const writers = 16
+	errs := make(chan error, writers)
+	var start sync.WaitGroup
+	start.Add(1)
+	for range writers {
+		go func() {
+			start.Wait()
+			errs <- Write(gitDir, sha, data)
+		}()
+	}
+	start.Done()
+	for range writers {
+		if err := <-errs; err != nil {
+			t.Errorf("concurrent Write() error: %v", err)
+		}
+	}
+
+	obj, err := Read(gitDir, sha)
+	if err != nil {
+		t.Fatalf("Read() after concurrent writes: %v", err)
+	}
+	if !bytes.Equal(obj.Body, content) {
+		t.Error("body mismatch after concurrent writes")
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(gitDir, "objects", sha[:2]))
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("expected only the object file, found %v", names)
+	}
+}
+
 func TestRead_PartialHash(t *testing.T) {
 	gitDir := testGitDir(t)
 
@@ -135,8 +276,8 @@ func TestRead_AmbiguousHash(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected ambiguous error, got nil")
 	}
-	if !strings.Contains(err.Error(), "ambiguous") {
-		t.Errorf("expected 'ambiguous' in error, got: %v", err)
+	if !errors.Is(err, ErrAmbiguous) {
+		t.Errorf("expected ErrAmbiguous, got: %v", err)
 	}
 }
 
@@ -158,6 +299,71 @@ func TestRead_HashTooShort(t *testing.T) {
 	}
 }
 
+// writeSHA256Blob stores content as a SHA-256 blob and returns its hash.
+func writeSHA256Blob(t *testing.T, gitDir, content string) string {
+	t.Helper()
+	sha, data, err := HashWith(SHA256, TypeBlob, strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(gitDir, sha, data); err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+// countScans makes readDir count object directory scans for the rest of
+// the test.
+func countScans(t *testing.T) *int {
+	t.Helper()
+	n := 0
+	t.Cleanup(func() { readDir = os.ReadDir })
+	readDir = func(dir string) ([]os.DirEntry, error) {
+		n++
+		return os.ReadDir(dir)
+	}
+	return &n
+}
+
+func TestRead_FullSHA256HashSkipsScan(t *testing.T) {
+	gitDir := testGitDir(t)
+	sha := writeSHA256Blob(t, gitDir, "hello\n")
+	scans := countScans(t)
+
+	if _, err := Read(gitDir, sha); err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if *scans != 0 {
+		t.Errorf("full hash scanned the object directory %d times", *scans)
+	}
+
+	// A missing full-length hash is also answered without a scan.
+	missing := strings.Repeat("0", 64)
+	if _, err := Read(gitDir, missing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read(missing): got %v, want ErrNotFound", err)
+	}
+	if *scans != 0 {
+		t.Errorf("missing full hash scanned the object directory %d times", *scans)
+	}
+}
+
+func TestRead_SHA1LengthPrefixOfSHA256(t *testing.T) {
+	gitDir := testGitDir(t)
+	sha := writeSHA256Blob(t, gitDir, "hello\n")
+	scans := countScans(t)
+
+	obj, err := Read(gitDir, sha[:40])
+	if err != nil {
+		t.Fatalf("Read(40-char prefix) error: %v", err)
+	}
+	if obj.Hash != sha {
+		t.Errorf("hash: got %s, want %s", obj.Hash, sha)
+	}
+	if *scans != 1 {
+		t.Errorf("40-char prefix: got %d scans, want 1", *scans)
+	}
+}
+
 // --- Exists ---
 
 func TestExists(t *testing.T) {
@@ -182,3 +388,212 @@ func TestPrettyPrint_Blob(t *testing.T) {
 		t.Errorf("PrettyPrint: got %q, want %q", got, "hello\n")
 	}
 }
+
+func TestPrettyPrint_Tree(t *testing.T) {
+	obj := &Object{Type: TypeTree, Body: treeBody(
+		TreeEntry{ModeFile, "README.md", "ce013625030ba8dba906f756967f9e9ca394464a"},
+		TreeEntry{ModeTree, "src", "4b825dc642cb6eb9a060e54bf8d69288fbee4904"},
+	)}
+	want := "100644 blob ce013625030ba8dba906f756967f9e9ca394464a\tREADME.md\n" +
+		"040000 tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\tsrc\n"
+	if got := obj.PrettyPrint(); got != want {
+		t.Errorf("PrettyPrint:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestPrettyPrint_CommitAndTag(t *testing.T) {
+	// A signed commit with headers rev doesn't model must come back
+	// byte for byte, or the signature no longer covers what's printed.
+	commit := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"author A <a@example.com> 1 +0000\n" +
+		"committer A <a@example.com> 1 +0000\n" +
+		"encoding ISO-8859-1\n" +
+		"gpgsig -----BEGIN PGP SIGNATURE-----\n" +
+		" \n" +
+		" iQEzBAABCAAdFiEE\n" +
+		" -----END PGP SIGNATURE-----\n" +
+		"\n" +
+		"Initial\n"
+	if got := commitObject(commit).PrettyPrint(); got != commit {
+		t.Errorf("commit:\ngot  %q\nwant %q", got, commit)
+	}
+
+	tag := "object 1111111111111111111111111111111111111111\ntype commit\ntag v1\n\nmsg\n" +
+		"-----BEGIN PGP SIGNATURE-----\n\niQEz\n-----END PGP SIGNATURE-----\n"
+	if got := tagObject(tag).PrettyPrint(); got != tag {
+		t.Errorf("tag: got %q", got)
+	}
+
+	// Unparseable objects come back raw.
+	bad := &Object{Type: TypeCommit, Body: []byte("not a commit")}
+	if got := bad.PrettyPrint(); got != "not a commit" {
+		t.Errorf("bad commit: got %q", got)
+	}
+}
+
+// --- ReadHeader ---
+
+func TestReadHeader(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	sha := "ce013625030ba8dba906f756967f9e9ca394464a"
+	Write(gitDir, sha, []byte("blob 6\x00hello\n"))
+
+	objType, size, err := ReadHeader(gitDir, sha[:7])
+	if err != nil {
+		t.Fatalf("ReadHeader() error: %v", err)
+	}
+	if objType != TypeBlob {
+		t.Errorf("type: got %q, want %q", objType, TypeBlob)
+	}
+	if size != 6 {
+		t.Errorf("size: got %d, want 6", size)
+	}
+}
+
+func TestReadHeader_NotFound(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	if _, _, err := ReadHeader(gitDir, "0000000000000000000000000000000000000000"); err == nil {
+		t.Error("expected error for non-existent object, got nil")
+	}
+}
+
+// benchBlob writes a large blob and returns its hash.
+func benchBlob(b *testing.B) (gitDir, sha string) {
+	b.Helper()
+	gitDir = filepath.Join(b.TempDir(), ".git")
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<20)
+	sha, full, err := Hash(TypeBlob, bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := Write(gitDir, sha, full); err != nil {
+		b.Fatal(err)
+	}
+	return gitDir, sha
+}
+
+func BenchmarkReadHeader(b *testing.B) {
+	gitDir, sha := benchBlob(b)
+	for b.Loop() {
+		if _, _, err := ReadHeader(gitDir, sha); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRead_SizeOnly(b *testing.B) {
+	gitDir, sha := benchBlob(b)
+	for b.Loop() {
+		if _, err := Read(gitDir, sha); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// --- Missing vs corrupt ---
+
+func TestRead_Corrupt(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	sha := "ce013625030ba8dba906f756967f9e9ca394464a"
+	dir := filepath.Join(gitDir, "objects", sha[:2])
+	os.MkdirAll(dir, 0755)
+	if err := os.WriteFile(filepath.Join(dir, sha[2:]), []byte("not zlib data"), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Read(gitDir, sha)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Read() of corrupt object: got %v, want ErrCorrupt", err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Error("corrupt object should not report ErrNotFound")
+	}
+
+	if _, _, err := ReadHeader(gitDir, sha); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("ReadHeader() of corrupt object: got %v, want ErrCorrupt", err)
+	}
+}
+
+func TestRead_SizeMismatch(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	// Header says 6 bytes, body has 3.
+	sha := strings.Repeat("ab", 20)
+	if err := Write(gitDir, sha, []byte("blob 6\x00hel")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Read(gitDir, sha)
+	if !errors.Is(err, ErrCorrupt) || !strings.Contains(err.Error(), "declares 6 bytes but body has 3") {
+		t.Errorf("Read() of truncated object: got %v", err)
+	}
+
+	obj, err := ReadUnchecked(gitDir, sha)
+	if err != nil {
+		t.Fatalf("ReadUnchecked() error: %v", err)
+	}
+	if obj.Size != 6 || string(obj.Body) != "hel" {
+		t.Errorf("ReadUnchecked(): got size %d body %q", obj.Size, obj.Body)
+	}
+}
+
+func TestRead_MissingIsErrNotFound(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	_, err := Read(gitDir, "0000000000000000000000000000000000000000")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+	if errors.Is(err, ErrCorrupt) {
+		t.Error("missing object should not report ErrCorrupt")
+	}
+}
+
+// --- Size limits ---
+
+// setMaxObjectSize lowers MaxObjectSize for the duration of a test.
+func setMaxObjectSize(t *testing.T, n int64) {
+	t.Helper()
+	old := MaxObjectSize
+	MaxObjectSize = n
+	t.Cleanup(func() { MaxObjectSize = old })
+}
+
+func TestRead_DeclaredSizeOverLimit(t *testing.T) {
+	gitDir := testGitDir(t)
+	setMaxObjectSize(t, 4)
+
+	sha := "ce013625030ba8dba906f756967f9e9ca394464a"
+	Write(gitDir, sha, []byte("blob 6\x00hello\n"))
+
+	if _, err := Read(gitDir, sha); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v, want ErrTooLarge", err)
+	}
+}
+
+func TestRead_InflationBomb(t *testing.T) {
+	gitDir := testGitDir(t)
+	setMaxObjectSize(t, 64)
+
+	// A tiny declared size over a body that inflates far past the limit.
+	bomb := append([]byte("blob 6\x00"), bytes.Repeat([]byte{0}, 1<<20)...)
+	sha := "ce013625030ba8dba906f756967f9e9ca394464a"
+	Write(gitDir, sha, bomb)
+
+	if _, err := Read(gitDir, sha); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v, want ErrTooLarge", err)
+	}
+}
+
+func TestRead_PackedOverLimit(t *testing.T) {
+	gitDir := testGitDir(t)
+	hashes, _ := writeTestPack(t, gitDir, []testObject{{TypeBlob, bytes.Repeat([]byte("x"), 100)}})
+	setMaxObjectSize(t, 50)
+
+	if _, err := Read(gitDir, hashes[0]); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v, want ErrTooLarge", err)
+	}
+}
@@ -0,0 +1,616 @@
+package object
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elliota43/rev/internal/trace"
+)
+
+// Pack object type codes, as stored in each pack entry header.
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+// packTypes maps non-delta pack type codes to object types.
+var packTypes = map[byte]Type{
+	packCommit: TypeCommit,
+	packTree:   TypeTree,
+	packBlob:   TypeBlob,
+	packTag:    TypeTag,
+}
+
+// packEntry locates one object inside a packfile.
+type packEntry struct {
+	hash     string
+	packPath string
+	offset   uint64
+	// gitDir is the repository the pack belongs to, where REF_DELTA bases
+	// are looked up. findPacked fills it in.
+	gitDir string
+}
+
+// packLookup finds objects by hash. It is implemented by pack indexes
+// (.idx) and multi-pack-indexes, which cover several packs at once.
+type packLookup interface {
+	// find returns every entry whose hash starts with the given hex prefix.
+	// A full 40-char hash yields at most one entry.
+	find(prefix string) []packEntry
+	// forEach calls fn with the raw 20-byte name of every object covered.
+	forEach(fn func(name []byte))
+}
+
+// oidTable is the fanout table plus sorted object names shared by the
+// .idx and multi-pack-index formats.
+type oidTable struct {
+	fanout [256]uint32
+	names  []byte // count * 20 bytes, sorted
+}
+
+func (t *oidTable) count() int {
+	return int(t.fanout[255])
+}
+
+func (t *oidTable) name(i int) string {
+	return hex.EncodeToString(t.names[i*20 : i*20+20])
+}
+
+func (t *oidTable) forEach(fn func(name []byte)) {
+	for i := range t.count() {
+		fn(t.names[i*20 : i*20+20])
+	}
+}
+
+// search returns the index range [lo, hi) of names starting with prefix.
+func (t *oidTable) search(prefix string) (int, int) {
+	first, err := hex.DecodeString(prefix[:2])
+	if err != nil {
+		return 0, 0
+	}
+
+	start := 0
+	if first[0] > 0 {
+		start = int(t.fanout[first[0]-1])
+	}
+	end := int(t.fanout[first[0]])
+
+	lo := start + sort.Search(end-start, func(i int) bool {
+		return t.name(start+i) >= prefix
+	})
+	hi := lo
+	for hi < end && strings.HasPrefix(t.name(hi), prefix) {
+		hi++
+	}
+	return lo, hi
+}
+
+// parseOIDTable reads a fanout table and the object names that follow it.
+func parseOIDTable(fanout, names []byte) (oidTable, error) {
+	var t oidTable
+	if len(fanout) < 256*4 {
+		return t, fmt.Errorf("truncated fanout table")
+	}
+	for i := range t.fanout {
+		t.fanout[i] = binary.BigEndian.Uint32(fanout[i*4:])
+	}
+	if len(names) < t.count()*20 {
+		return t, fmt.Errorf("truncated object name table")
+	}
+	t.names = names[:t.count()*20]
+	return t, nil
+}
+
+// packIndex is a parsed version 2 pack index (.idx) file.
+type packIndex struct {
+	oidTable
+	packPath string
+	offsets  []uint64
+}
+
+var packIdxMagic = []byte{0xff, 't', 'O', 'c'}
+
+// parsePackIndex parses a version 2 .idx file for the given pack.
+func parsePackIndex(data []byte, packPath string) (*packIndex, error) {
+	if len(data) < 8 || !bytes.Equal(data[:4], packIdxMagic) {
+		return nil, fmt.Errorf("unsupported pack index format (only v2 is supported)")
+	}
+	if v := binary.BigEndian.Uint32(data[4:8]); v != 2 {
+		return nil, fmt.Errorf("unsupported pack index version %d", v)
+	}
+
+	table, err := parseOIDTable(data[8:], data[8+256*4:])
+	if err != nil {
+		return nil, fmt.Errorf("parsing pack index: %w", err)
+	}
+	n := table.count()
+
+	// Layout after the fanout: names, CRC32s, 4-byte offsets, 8-byte
+	// large offsets, then the pack and index checksums.
+	offStart := 8 + 256*4 + n*20 + n*4
+	largeStart := offStart + n*4
+	if len(data) < largeStart+40 {
+		return nil, fmt.Errorf("parsing pack index: truncated offset table")
+	}
+
+	idx := &packIndex{oidTable: table, packPath: packPath, offsets: make([]uint64, n)}
+	for i := range n {
+		off := binary.BigEndian.Uint32(data[offStart+i*4:])
+		if off&0x80000000 == 0 {
+			idx.offsets[i] = uint64(off)
+			continue
+		}
+		pos := largeStart + int(off&0x7fffffff)*8
+		if pos+8 > len(data)-40 {
+			return nil, fmt.Errorf("parsing pack index: large offset out of range")
+		}
+		idx.offsets[i] = binary.BigEndian.Uint64(data[pos:])
+	}
+	return idx, nil
+}
+
+func (idx *packIndex) find(prefix string) []packEntry {
+	lo, hi := idx.search(prefix)
+	var entries []packEntry
+	for i := lo; i < hi; i++ {
+		entries = append(entries, packEntry{hash: idx.name(i), packPath: idx.packPath, offset: idx.offsets[i]})
+	}
+	return entries
+}
+
+// cachedLookup remembers a parsed index along with the file state it was
+// parsed from, so repeated reads in one process don't re-parse it.
+type cachedLookup struct {
+	modTime int64
+	size    int64
+	lookup  packLookup
+}
+
+var (
+	packCacheMu sync.Mutex
+	packCache   = make(map[string]cachedLookup)
+)
+
+// loadCached returns the parsed index at path, re-parsing only if the
+// file changed since it was last loaded.
+func loadCached(path string, parse func([]byte) (packLookup, error)) (packLookup, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	packCacheMu.Lock()
+	defer packCacheMu.Unlock()
+
+	if c, ok := packCache[path]; ok && c.modTime == info.ModTime().UnixNano() && c.size == info.Size() {
+		return c.lookup, nil
+	}
+
+	start := time.Now()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lookup, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if trace.Enabled() {
+		trace.Log("pack", "loaded %s (%d bytes) in %s", filepath.Base(path), len(data), trace.Since(start))
+	}
+	packCache[path] = cachedLookup{modTime: info.ModTime().UnixNano(), size: info.Size(), lookup: lookup}
+	return lookup, nil
+}
+
+// loadPacks returns lookups covering every pack in <gitDir>/objects/pack.
+// A multi-pack-index is used for the packs it covers; any pack it doesn't
+// list (e.g. one added since it was written) is read from its own .idx.
+func loadPacks(gitDir string) ([]packLookup, error) {
+	packDir := filepath.Join(gitDir, "objects", "pack")
+
+	var lookups []packLookup
+	covered := make(map[string]bool)
+
+	midxPath := filepath.Join(packDir, "multi-pack-index")
+	if _, err := os.Stat(midxPath); err == nil {
+		lookup, err := loadCached(midxPath, func(data []byte) (packLookup, error) {
+			return parseMultiPackIndex(data, packDir)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range lookup.(*multiPackIndex).idxNames {
+			covered[name] = true
+		}
+		lookups = append(lookups, lookup)
+	}
+
+	idxPaths, err := filepath.Glob(filepath.Join(packDir, "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range idxPaths {
+		if covered[filepath.Base(p)] {
+			continue
+		}
+		packPath := strings.TrimSuffix(p, ".idx") + ".pack"
+		lookup, err := loadCached(p, func(data []byte) (packLookup, error) {
+			return parsePackIndex(data, packPath)
+		})
+		if err != nil {
+			return nil, err
+		}
+		lookups = append(lookups, lookup)
+	}
+
+	return lookups, nil
+}
+
+// findPacked returns every packed object whose hash starts with prefix,
+// de-duplicated across packs (the same object may be in several).
+func findPacked(gitDir, prefix string) ([]packEntry, error) {
+	lookups, err := loadPacks(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var entries []packEntry
+	for _, l := range lookups {
+		for _, e := range l.find(prefix) {
+			if !seen[e.hash] {
+				seen[e.hash] = true
+				e.gitDir = gitDir
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// packReader is what pack entry headers are decoded from: a buffered
+// pack file, or a whole pack held in memory.
+type packReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// readPackEntryHeader reads the type code and size varint at the start
+// of a pack entry.
+func readPackEntryHeader(br packReader) (byte, int64, error) {
+	c, err := br.ReadByte()
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading pack entry header: %w", err)
+	}
+
+	typ := (c >> 4) & 7
+	size := int64(c & 0x0f)
+	shift := 4
+	for c&0x80 != 0 {
+		if c, err = br.ReadByte(); err != nil {
+			return 0, 0, fmt.Errorf("reading pack entry header: %w", err)
+		}
+		size |= int64(c&0x7f) << shift
+		shift += 7
+	}
+	return typ, size, nil
+}
+
+// openPackEntry opens the pack containing e and positions a reader at
+// the start of its entry.
+func openPackEntry(e packEntry) (*os.File, *bufio.Reader, error) {
+	f, err := os.Open(e.packPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening pack: %w", err)
+	}
+	if _, err := f.Seek(int64(e.offset), io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("seeking in pack: %w", err)
+	}
+	return f, bufio.NewReader(f), nil
+}
+
+// deltaRef is what follows a delta entry's header: where its base is.
+// baseOffset is set for OFS_DELTA, baseHash for REF_DELTA.
+type deltaRef struct {
+	baseOffset uint64
+	baseHash   string
+}
+
+// readDeltaRef reads the base reference of a delta entry at offset whose
+// header has just been read. It does nothing for non-delta entries, and
+// rejects type codes that are neither.
+func readDeltaRef(br packReader, code byte, offset uint64) (deltaRef, error) {
+	switch code {
+	case packOfsDelta:
+		// A big-endian base-128 distance back from this entry, where each
+		// continuation also adds one so no value has two encodings.
+		c, err := br.ReadByte()
+		if err != nil {
+			return deltaRef{}, fmt.Errorf("reading delta base offset: %w", err)
+		}
+		dist := uint64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = br.ReadByte(); err != nil {
+				return deltaRef{}, fmt.Errorf("reading delta base offset: %w", err)
+			}
+			dist = (dist+1)<<7 | uint64(c&0x7f)
+		}
+		if dist == 0 || dist > offset {
+			return deltaRef{}, fmt.Errorf("delta base offset %d out of range", dist)
+		}
+		return deltaRef{baseOffset: offset - dist}, nil
+	case packRefDelta:
+		var raw [20]byte
+		if _, err := io.ReadFull(br, raw[:]); err != nil {
+			return deltaRef{}, fmt.Errorf("reading delta base name: %w", err)
+		}
+		return deltaRef{baseHash: hex.EncodeToString(raw[:])}, nil
+	}
+	if _, ok := packTypes[code]; !ok {
+		return deltaRef{}, fmt.Errorf("unknown pack type %d", code)
+	}
+	return deltaRef{}, nil
+}
+
+// openPackEntryHeader opens e and decodes its header and any delta base
+// reference, leaving the reader at the start of the entry's zlib data.
+// Header errors are reported against hash, the object being read.
+func openPackEntryHeader(e packEntry, hash string) (*os.File, *bufio.Reader, byte, int64, deltaRef, error) {
+	f, br, err := openPackEntry(e)
+	if err != nil {
+		return nil, nil, 0, 0, deltaRef{}, err
+	}
+	code, size, err := readPackEntryHeader(br)
+	var ref deltaRef
+	if err == nil {
+		ref, err = readDeltaRef(br, code, e.offset)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, 0, 0, deltaRef{}, corrupt(hash, err)
+	}
+	return f, br, code, size, ref, nil
+}
+
+// deltaBase returns the pack entry holding the base of a delta found at
+// cur. A REF_DELTA base may instead be a loose object, whose hash is
+// returned as loose.
+func deltaBase(cur packEntry, ref deltaRef, hash string) (next packEntry, loose string, err error) {
+	if ref.baseHash == "" {
+		return packEntry{packPath: cur.packPath, offset: ref.baseOffset, gitDir: cur.gitDir}, "", nil
+	}
+	loc, err := locate(cur.gitDir, ref.baseHash)
+	if err != nil {
+		return packEntry{}, "", fmt.Errorf("object %s: delta base %s: %w", hash, ref.baseHash, err)
+	}
+	if !loc.packed() {
+		return packEntry{}, loc.hash, nil
+	}
+	return loc.pack, "", nil
+}
+
+// deltaChain guards a walk down a delta chain against loops, which a
+// corrupt or malicious pack can contain, and against runaway depth.
+type deltaChain struct {
+	hash string
+	seen map[packEntry]bool
+}
+
+func newDeltaChain(hash string) *deltaChain {
+	return &deltaChain{hash: hash, seen: make(map[packEntry]bool)}
+}
+
+// visit records e as the next link and errors if it was seen already or
+// the chain is too long.
+func (c *deltaChain) visit(e packEntry) error {
+	key := packEntry{packPath: e.packPath, offset: e.offset}
+	if c.seen[key] {
+		return corrupt(c.hash, fmt.Errorf("delta chain loops back to offset %d", e.offset))
+	}
+	if len(c.seen) >= maxDeltaDepth {
+		return corrupt(c.hash, fmt.Errorf("delta chain longer than %d", maxDeltaDepth))
+	}
+	c.seen[key] = true
+	return nil
+}
+
+// readPackedHeader returns a packed object's type and size from its entry
+// header alone, without inflating any data. For a delta, the size is read
+// from the start of the delta data and the type from the end of its
+// chain, whose entry headers are read but not inflated.
+func readPackedHeader(e packEntry) (Type, int64, error) {
+	f, br, code, size, ref, err := openPackEntryHeader(e, e.hash)
+	if err != nil {
+		return "", 0, err
+	}
+	if objType, ok := packTypes[code]; ok {
+		f.Close()
+		return objType, size, nil
+	}
+
+	resultSize, err := deltaResultSize(br)
+	f.Close()
+	if err != nil {
+		return "", 0, corrupt(e.hash, err)
+	}
+
+	chain := newDeltaChain(e.hash)
+	cur := e
+	for {
+		if err := chain.visit(cur); err != nil {
+			return "", 0, err
+		}
+		next, loose, err := deltaBase(cur, ref, e.hash)
+		if err != nil {
+			return "", 0, err
+		}
+		if loose != "" {
+			objType, _, err := ReadHeader(cur.gitDir, loose)
+			return objType, resultSize, err
+		}
+
+		f, _, code, _, nextRef, err := openPackEntryHeader(next, e.hash)
+		if err != nil {
+			return "", 0, err
+		}
+		f.Close()
+		if objType, ok := packTypes[code]; ok {
+			return objType, resultSize, nil
+		}
+		cur, ref = next, nextRef
+	}
+}
+
+// deltaResultSize inflates just enough of a delta to read the size of the
+// object it produces, the second of its two header sizes.
+func deltaResultSize(br *bufio.Reader) (int64, error) {
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return 0, fmt.Errorf("creating zlib reader: %w", err)
+	}
+	defer zr.Close()
+
+	// Two varints of at most 10 bytes each.
+	head, err := io.ReadAll(io.LimitReader(zr, 20))
+	if err != nil {
+		return 0, fmt.Errorf("inflating delta header: %w", err)
+	}
+	_, rest, err := deltaHeaderSize(head)
+	if err != nil {
+		return 0, err
+	}
+	size, _, err := deltaHeaderSize(rest)
+	if err != nil {
+		return 0, err
+	}
+	return int64(size), nil
+}
+
+// inflateEntry inflates a pack entry's data, which the entry header says
+// is exactly size bytes.
+func inflateEntry(hash string, br *bufio.Reader, size int64) ([]byte, error) {
+	if err := checkSize(hash, size); err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return nil, corrupt(hash, fmt.Errorf("creating zlib reader: %w", err))
+	}
+	defer zr.Close()
+
+	// The entry header gives the exact inflated size, so anything beyond
+	// it is refused rather than buffered.
+	data, err := io.ReadAll(io.LimitReader(zr, size+1))
+	if err != nil {
+		return nil, corrupt(hash, fmt.Errorf("inflating: %w", err))
+	}
+	if int64(len(data)) > size {
+		return nil, corrupt(hash, fmt.Errorf("inflates past its declared %d bytes", size))
+	}
+	if int64(len(data)) < size {
+		return nil, corrupt(hash, fmt.Errorf("inflates to %d bytes but declares %d", len(data), size))
+	}
+	return data, nil
+}
+
+// readPacked inflates a packed object. A delta's chain is followed down
+// to a whole object, a loose base, or a base in the cache, and then each
+// delta on the way back up is inflated and applied in turn, so that only
+// one delta and the base it applies to are in memory at once. The bases
+// built along the way are cached.
+func readPacked(e packEntry) (*Object, error) {
+	var links []packEntry
+	var objType Type
+	var body []byte
+
+	chain := newDeltaChain(e.hash)
+	cur := e
+	for body == nil {
+		if err := chain.visit(cur); err != nil {
+			return nil, err
+		}
+		if cur != e {
+			if t, cached, ok := deltaBases.get(cur); ok {
+				objType, body = t, cached
+				break
+			}
+		}
+		f, br, code, size, ref, err := openPackEntryHeader(cur, e.hash)
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := packTypes[code]; ok {
+			data, err := inflateEntry(e.hash, br, size)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			objType, body = t, data
+			if cur != e {
+				deltaBases.add(cur, objType, body)
+			}
+			break
+		}
+		f.Close()
+		links = append(links, cur)
+
+		next, loose, err := deltaBase(cur, ref, e.hash)
+		if err != nil {
+			return nil, err
+		}
+		if loose != "" {
+			base, err := Read(cur.gitDir, loose)
+			if err != nil {
+				return nil, fmt.Errorf("object %s: delta base: %w", e.hash, err)
+			}
+			objType, body = base.Type, base.Body
+			break
+		}
+		cur = next
+	}
+
+	for i := len(links) - 1; i >= 0; i-- {
+		f, br, _, size, _, err := openPackEntryHeader(links[i], e.hash)
+		if err != nil {
+			return nil, err
+		}
+		delta, err := inflateEntry(e.hash, br, size)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if body, err = applyDelta(body, delta); err != nil {
+			if errors.Is(err, ErrTooLarge) {
+				return nil, fmt.Errorf("object %s: %w", e.hash, err)
+			}
+			return nil, corrupt(e.hash, fmt.Errorf("applying delta: %w", err))
+		}
+		if i > 0 {
+			deltaBases.add(links[i], objType, body)
+		}
+	}
+
+	return &Object{
+		Type: objType,
+		Size: int64(len(body)),
+		Hash: e.hash,
+		Body: body,
+	}, nil
+}
@@ -0,0 +1,364 @@
+package object
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type testObject struct {
+	typ  Type
+	body []byte
+}
+
+var testPackCodes = map[Type]byte{
+	TypeCommit: packCommit,
+	TypeTree:   packTree,
+	TypeBlob:   packBlob,
+	TypeTag:    packTag,
+}
+
+// writeTestPack writes objs as a packfile plus v2 index under
+// <gitDir>/objects/pack. It returns the object hashes (in input order)
+// and the .idx file name.
+func writeTestPack(t *testing.T, gitDir string, objs []testObject) ([]string, string) {
+	t.Helper()
+
+	hashes := make([]string, len(objs))
+	entries := make([][]byte, len(objs))
+	for i, o := range objs {
+		sha, _, err := Hash(o.typ, bytes.NewReader(o.body), int64(len(o.body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[i] = sha
+		entries[i] = packEntryBytes(testPackCodes[o.typ], nil, o.body)
+	}
+	return hashes, writeTestPackEntries(t, gitDir, hashes, entries)
+}
+
+// packEntryBytes encodes one pack entry: the type and size header, then
+// extra (a delta's base reference, if any), then data zlib-compressed.
+func packEntryBytes(code byte, extra, data []byte) []byte {
+	var e bytes.Buffer
+	size := len(data)
+	c := code<<4 | byte(size&0x0f)
+	size >>= 4
+	for size > 0 {
+		e.WriteByte(c | 0x80)
+		c = byte(size & 0x7f)
+		size >>= 7
+	}
+	e.WriteByte(c)
+	e.Write(extra)
+	zw := zlib.NewWriter(&e)
+	zw.Write(data)
+	zw.Close()
+	return e.Bytes()
+}
+
+// writeTestPackEntries writes already-encoded entries, named by hashes,
+// as a packfile plus v2 index under <gitDir>/objects/pack, and returns
+// the .idx file name.
+func writeTestPackEntries(t testing.TB, gitDir string, hashes []string, encoded [][]byte) string {
+	t.Helper()
+
+	type entry struct {
+		hash   []byte
+		crc    uint32
+		offset uint32
+	}
+
+	var pack bytes.Buffer
+	pack.WriteString("PACK")
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+	binary.Write(&pack, binary.BigEndian, uint32(len(encoded)))
+
+	entries := make([]entry, len(encoded))
+	for i, e := range encoded {
+		raw, _ := hex.DecodeString(hashes[i])
+		entries[i] = entry{hash: raw, crc: crc32.ChecksumIEEE(e), offset: uint32(pack.Len())}
+		pack.Write(e)
+	}
+	packSum := sha1.Sum(pack.Bytes())
+	pack.Write(packSum[:])
+
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].hash, entries[j].hash) < 0
+	})
+
+	var idx bytes.Buffer
+	idx.Write(packIdxMagic)
+	binary.Write(&idx, binary.BigEndian, uint32(2))
+	var fanout [256]uint32
+	for _, e := range entries {
+		for b := int(e.hash[0]); b < 256; b++ {
+			fanout[b]++
+		}
+	}
+	binary.Write(&idx, binary.BigEndian, fanout)
+	for _, e := range entries {
+		idx.Write(e.hash)
+	}
+	for _, e := range entries {
+		binary.Write(&idx, binary.BigEndian, e.crc)
+	}
+	for _, e := range entries {
+		binary.Write(&idx, binary.BigEndian, e.offset)
+	}
+	idx.Write(packSum[:])
+	idxSum := sha1.Sum(idx.Bytes())
+	idx.Write(idxSum[:])
+
+	packDir := filepath.Join(gitDir, "objects", "pack")
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	base := "pack-" + hex.EncodeToString(packSum[:])
+	if err := os.WriteFile(filepath.Join(packDir, base+".pack"), pack.Bytes(), 0444); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(packDir, base+".idx"), idx.Bytes(), 0444); err != nil {
+		t.Fatal(err)
+	}
+	return base + ".idx"
+}
+
+// writeTestMultiPackIndex writes a multi-pack-index covering the given
+// packs (by .idx name, which must be sorted).
+func writeTestMultiPackIndex(t *testing.T, gitDir string, idxNames []string) {
+	t.Helper()
+	packDir := filepath.Join(gitDir, "objects", "pack")
+
+	type entry struct {
+		hash   []byte
+		packID uint32
+		offset uint32
+	}
+	var entries []entry
+	seen := make(map[string]bool)
+	for id, name := range idxNames {
+		data, err := os.ReadFile(filepath.Join(packDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx, err := parsePackIndex(data, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range idx.count() {
+			h := idx.name(i)
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			raw, _ := hex.DecodeString(h)
+			entries = append(entries, entry{raw, uint32(id), uint32(idx.offsets[i])})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].hash, entries[j].hash) < 0
+	})
+
+	var pnam, oidf, oidl, ooff bytes.Buffer
+	for _, n := range idxNames {
+		pnam.WriteString(n)
+		pnam.WriteByte(0)
+	}
+	for pnam.Len()%4 != 0 {
+		pnam.WriteByte(0)
+	}
+	var fanout [256]uint32
+	for _, e := range entries {
+		for b := int(e.hash[0]); b < 256; b++ {
+			fanout[b]++
+		}
+	}
+	binary.Write(&oidf, binary.BigEndian, fanout)
+	for _, e := range entries {
+		oidl.Write(e.hash)
+		binary.Write(&ooff, binary.BigEndian, e.packID)
+		binary.Write(&ooff, binary.BigEndian, e.offset)
+	}
+
+	chunks := []struct {
+		id   uint32
+		data []byte
+	}{
+		{chunkPackNames, pnam.Bytes()},
+		{chunkOIDFanout, oidf.Bytes()},
+		{chunkOIDLookup, oidl.Bytes()},
+		{chunkObjOffsets, ooff.Bytes()},
+	}
+
+	var midx bytes.Buffer
+	midx.WriteString("MIDX")
+	midx.Write([]byte{1, 1, byte(len(chunks)), 0})
+	binary.Write(&midx, binary.BigEndian, uint32(len(idxNames)))
+	offset := uint64(12 + (len(chunks)+1)*12)
+	for _, c := range chunks {
+		binary.Write(&midx, binary.BigEndian, c.id)
+		binary.Write(&midx, binary.BigEndian, offset)
+		offset += uint64(len(c.data))
+	}
+	binary.Write(&midx, binary.BigEndian, uint32(0))
+	binary.Write(&midx, binary.BigEndian, offset)
+	for _, c := range chunks {
+		midx.Write(c.data)
+	}
+	sum := sha1.Sum(midx.Bytes())
+	midx.Write(sum[:])
+
+	if err := os.WriteFile(filepath.Join(packDir, "multi-pack-index"), midx.Bytes(), 0444); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRead_Packed(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	body := bytes.Repeat([]byte("packed content\n"), 10)
+	hashes, _ := writeTestPack(t, gitDir, []testObject{
+		{TypeBlob, []byte("hello\n")},
+		{TypeBlob, body},
+	})
+
+	obj, err := Read(gitDir, hashes[1])
+	if err != nil {
+		t.Fatalf("Read() packed object: %v", err)
+	}
+	if obj.Type != TypeBlob || obj.Size != int64(len(body)) || !bytes.Equal(obj.Body, body) {
+		t.Errorf("packed object: got type %q size %d body %q", obj.Type, obj.Size, obj.Body)
+	}
+
+	objType, size, err := ReadHeader(gitDir, hashes[1][:8])
+	if err != nil {
+		t.Fatalf("ReadHeader() packed object: %v", err)
+	}
+	if objType != TypeBlob || size != int64(len(body)) {
+		t.Errorf("ReadHeader: got %q %d, want blob %d", objType, size, len(body))
+	}
+
+	if err := Exists(gitDir, hashes[0]); err != nil {
+		t.Errorf("Exists() packed object: %v", err)
+	}
+}
+
+// benchPack writes a pack holding a large blob whole and a second blob
+// stored as a delta against it, and returns their hashes.
+func benchPack(b *testing.B) (gitDir, whole, delta string) {
+	b.Helper()
+	gitDir = filepath.Join(b.TempDir(), ".git")
+	base := bytes.Repeat([]byte("0123456789abcdef"), 1<<20)
+	target := append(bytes.Clone(base), "one more line\n"...)
+	whole = HashBytes([]byte(Header(TypeBlob, int64(len(base))) + string(base)))
+	delta = HashBytes([]byte(Header(TypeBlob, int64(len(target))) + string(target)))
+
+	baseName, _ := hex.DecodeString(whole)
+	writeTestPackEntries(b, gitDir, []string{whole, delta}, [][]byte{
+		packEntryBytes(packBlob, nil, base),
+		packEntryBytes(packRefDelta, baseName, computeDelta(base, target, len(target))),
+	})
+	return gitDir, whole, delta
+}
+
+// BenchmarkReadHeader_Packed reads the size of packed objects from their
+// entry headers, and for a delta the start of its data, against
+// inflating (and for the delta, resolving) them with Read.
+func BenchmarkReadHeader_Packed(b *testing.B) {
+	gitDir, whole, delta := benchPack(b)
+	for _, bench := range []struct {
+		name, hash string
+	}{{"whole", whole}, {"delta", delta}} {
+		b.Run(bench.name+"/ReadHeader", func(b *testing.B) {
+			for b.Loop() {
+				if _, _, err := ReadHeader(gitDir, bench.hash); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(bench.name+"/Read", func(b *testing.B) {
+			for b.Loop() {
+				if _, err := Read(gitDir, bench.hash); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRead_SecondaryPack(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("first pack\n")}})
+	hashes, _ := writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("second pack\n")}})
+
+	obj, err := Read(gitDir, hashes[0])
+	if err != nil {
+		t.Fatalf("Read() from secondary pack: %v", err)
+	}
+	if string(obj.Body) != "second pack\n" {
+		t.Errorf("body: got %q", obj.Body)
+	}
+}
+
+func TestRead_MultiPackIndex(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	hashesA, idxA := writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("pack a\n")}})
+	hashesB, idxB := writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("pack b\n")}})
+	names := []string{idxA, idxB}
+	sort.Strings(names)
+	writeTestMultiPackIndex(t, gitDir, names)
+
+	// With the .idx files gone, objects can only be found via the midx.
+	for _, n := range names {
+		os.Remove(filepath.Join(gitDir, "objects", "pack", n))
+	}
+
+	for _, h := range []string{hashesA[0], hashesB[0]} {
+		if _, err := Read(gitDir, h); err != nil {
+			t.Errorf("Read(%s) via multi-pack-index: %v", h, err)
+		}
+	}
+}
+
+func TestRead_PackedNotFound(t *testing.T) {
+	gitDir := testGitDir(t)
+	writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("hello\n")}})
+
+	_, err := Read(gitDir, "0000000000000000000000000000000000000000")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRead_AmbiguousLooseAndPacked(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	hashes, _ := writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("hello\n")}})
+
+	// A loose object sharing the packed object's 4-char prefix.
+	other := hashes[0][:4] + strings.Repeat("f", 36)
+	Write(gitDir, other, []byte("blob 6\x00world\n"))
+
+	_, err := Read(gitDir, hashes[0][:4])
+	if err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("expected ambiguous error, got %v", err)
+	}
+
+	// The same object both loose and packed is not ambiguous.
+	Write(gitDir, hashes[0], []byte("blob 6\x00hello\n"))
+	if _, err := Read(gitDir, hashes[0][:6]); err != nil {
+		t.Errorf("object present loose and packed: %v", err)
+	}
+}
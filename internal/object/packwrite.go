@@ -0,0 +1,355 @@
+package object
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// WrittenPack describes a packfile produced by WritePack: its checksum
+// (the SHA-1 trailer, which also names the pack) and what its index needs.
+type WrittenPack struct {
+	Checksum string
+
+	entries []writtenEntry
+	sum     []byte
+}
+
+// writtenEntry is one object's row in the pack index.
+type writtenEntry struct {
+	name   []byte
+	crc    uint32
+	offset uint64
+}
+
+// PackOptions controls the delta compression WriteDeltaPack does.
+type PackOptions struct {
+	// Window is how many of the objects before each one, in the order
+	// deltas are searched, it is tried against as a delta base. Zero or
+	// less stores every object whole.
+	Window int
+	// Depth is the longest delta chain written: a base that is already
+	// a delta that many times over isn't used. Zero or less means
+	// DefaultPackDepth.
+	Depth int
+}
+
+// Git's defaults for pack.window and pack.depth.
+const (
+	DefaultPackWindow = 10
+	DefaultPackDepth  = 50
+)
+
+// WritePack writes the named objects to w as a version 2 packfile. Every
+// object is stored whole and zlib-compressed, with no deltas. Names may be
+// repeated; each object is packed once, in the order first named. Only
+// SHA-1 objects can be packed.
+func WritePack(w io.Writer, gitDir string, hashes []string) (*WrittenPack, error) {
+	return WriteDeltaPack(w, gitDir, hashes, PackOptions{})
+}
+
+// WriteDeltaPack is WritePack, but with a positive opts.Window it stores
+// objects as OFS_DELTAs against one another where that is smaller. As
+// in git, objects are then written grouped by type and largest first, so
+// each is compared with similar, usually older, versions of itself and
+// every base comes before the deltas made from it. Without paths to go
+// on, objects of a type are ordered by size alone.
+func WriteDeltaPack(w io.Writer, gitDir string, hashes []string, opts PackOptions) (*WrittenPack, error) {
+	var objs []*Object
+	seen := make(map[string]bool)
+	for _, h := range hashes {
+		obj, err := Read(gitDir, h)
+		if err != nil {
+			return nil, err
+		}
+		if len(obj.Hash) != SHA1.HexLen() {
+			return nil, fmt.Errorf("packing %s: only SHA-1 objects can be packed", obj.Hash)
+		}
+		if !seen[obj.Hash] {
+			seen[obj.Hash] = true
+			objs = append(objs, obj)
+		}
+	}
+	var deltas []packDelta
+	if opts.Window > 0 {
+		deltas = findDeltas(objs, opts)
+	}
+
+	sum := sha1.New()
+	cw := &countingWriter{w: io.MultiWriter(w, sum)}
+
+	var header [12]byte
+	copy(header[:], "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(objs)))
+	if _, err := cw.Write(header[:]); err != nil {
+		return nil, fmt.Errorf("writing pack header: %w", err)
+	}
+
+	pack := &WrittenPack{entries: make([]writtenEntry, 0, len(objs))}
+	for i, obj := range objs {
+		var entry []byte
+		var err error
+		if deltas != nil && deltas[i].data != nil {
+			base := pack.entries[deltas[i].base].offset
+			entry, err = encodeDeltaEntry(obj.Hash, cw.n-base, deltas[i].data)
+		} else {
+			entry, err = encodePackEntry(obj)
+		}
+		if err != nil {
+			return nil, err
+		}
+		name, _ := hex.DecodeString(obj.Hash)
+		pack.entries = append(pack.entries, writtenEntry{name: name, crc: crc32.ChecksumIEEE(entry), offset: cw.n})
+		if _, err := cw.Write(entry); err != nil {
+			return nil, fmt.Errorf("writing pack entry %s: %w", obj.Hash, err)
+		}
+	}
+
+	pack.sum = sum.Sum(nil)
+	if _, err := w.Write(pack.sum); err != nil {
+		return nil, fmt.Errorf("writing pack trailer: %w", err)
+	}
+	pack.Checksum = hex.EncodeToString(pack.sum)
+	return pack, nil
+}
+
+// packDelta is how findDeltas chose to store one object: as data, a
+// delta against the object at index base, or whole if data is nil.
+type packDelta struct {
+	base  int
+	data  []byte
+	depth int
+}
+
+// findDeltas sorts objs into the order they are written in and returns,
+// for each, the smallest delta against the opts.Window objects before
+// it, if any is under half the object's size.
+func findDeltas(objs []*Object, opts PackOptions) []packDelta {
+	depth := opts.Depth
+	if depth <= 0 {
+		depth = DefaultPackDepth
+	}
+	typeOrder := map[Type]int{TypeCommit: 0, TypeTree: 1, TypeBlob: 2, TypeTag: 3}
+	sort.SliceStable(objs, func(i, j int) bool {
+		a, b := objs[i], objs[j]
+		if a.Type != b.Type {
+			return typeOrder[a.Type] < typeOrder[b.Type]
+		}
+		if len(a.Body) != len(b.Body) {
+			return len(a.Body) > len(b.Body)
+		}
+		return a.Hash < b.Hash
+	})
+
+	deltas := make([]packDelta, len(objs))
+	for i, obj := range objs {
+		// Git's starting bound: a delta must save at least half the
+		// object, less room for the entry header, to be worth reading.
+		maxSize := len(obj.Body)/2 - 20
+		for j := max(0, i-opts.Window); j < i; j++ {
+			if maxSize <= 0 {
+				break
+			}
+			if objs[j].Type != obj.Type || deltas[j].depth >= depth {
+				continue
+			}
+			if d := computeDelta(objs[j].Body, obj.Body, maxSize); d != nil {
+				deltas[i] = packDelta{base: j, data: d, depth: deltas[j].depth + 1}
+				maxSize = len(d) - 1
+			}
+		}
+	}
+	return deltas
+}
+
+// encodePackEntry returns obj as a whole pack entry, stored under its
+// own type.
+func encodePackEntry(obj *Object) ([]byte, error) {
+	var code byte
+	for c, t := range packTypes {
+		if t == obj.Type {
+			code = c
+		}
+	}
+	if code == 0 {
+		return nil, fmt.Errorf("packing %s: unsupported object type %q", obj.Hash, obj.Type)
+	}
+	return encodeEntry(obj.Hash, code, nil, obj.Body)
+}
+
+// encodeDeltaEntry returns an OFS_DELTA pack entry for the object hash,
+// whose base entry starts dist bytes before this one. The distance is
+// big-endian base-128, each continuation taking one off the value below
+// it, the inverse of readDeltaRef.
+func encodeDeltaEntry(hash string, dist uint64, delta []byte) ([]byte, error) {
+	var buf [10]byte
+	pos := len(buf) - 1
+	buf[pos] = byte(dist & 0x7f)
+	for dist >>= 7; dist > 0; dist >>= 7 {
+		dist--
+		pos--
+		buf[pos] = 0x80 | byte(dist&0x7f)
+	}
+	return encodeEntry(hash, packOfsDelta, buf[pos:], delta)
+}
+
+// encodeEntry returns a pack entry: the type and size header (a 3-bit
+// type and a little-endian base-128 size of data), ref for deltas, and
+// the deflated data.
+func encodeEntry(hash string, code byte, ref, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	size := uint64(len(data))
+	c := code<<4 | byte(size&0x0f)
+	size >>= 4
+	for size > 0 {
+		buf.WriteByte(c | 0x80)
+		c = byte(size & 0x7f)
+		size >>= 7
+	}
+	buf.WriteByte(c)
+	buf.Write(ref)
+
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("compressing %s: %w", hash, err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("compressing %s: %w", hash, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteIndex writes the version 2 .idx file for the pack.
+func (p *WrittenPack) WriteIndex(w io.Writer) error {
+	entries := append([]writtenEntry(nil), p.entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].name, entries[j].name) < 0
+	})
+
+	var buf bytes.Buffer
+	buf.Write(packIdxMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+
+	var fanout [256]uint32
+	for _, e := range entries {
+		fanout[e.name[0]]++
+	}
+	for i := 1; i < 256; i++ {
+		fanout[i] += fanout[i-1]
+	}
+	binary.Write(&buf, binary.BigEndian, fanout)
+
+	for _, e := range entries {
+		buf.Write(e.name)
+	}
+	for _, e := range entries {
+		binary.Write(&buf, binary.BigEndian, e.crc)
+	}
+	// Offsets past 2^31 go in a trailing table of 8-byte offsets, with the
+	// 4-byte slot holding the MSB-flagged position in that table.
+	var large []uint64
+	for _, e := range entries {
+		if e.offset < 0x80000000 {
+			binary.Write(&buf, binary.BigEndian, uint32(e.offset))
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(len(large))|0x80000000)
+		large = append(large, e.offset)
+	}
+	for _, off := range large {
+		binary.Write(&buf, binary.BigEndian, off)
+	}
+	buf.Write(p.sum)
+
+	idxSum := sha1.Sum(buf.Bytes())
+	buf.Write(idxSum[:])
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("writing pack index: %w", err)
+	}
+	return nil
+}
+
+// SavePack packs the named objects into <gitDir>/objects/pack as
+// pack-<checksum>.pack and .idx, and returns the checksum. Both files are
+// written under temporary names and renamed into place, the index last,
+// so readers never see an index without its pack.
+func SavePack(gitDir string, hashes []string) (string, error) {
+	return SaveDeltaPack(gitDir, hashes, PackOptions{})
+}
+
+// SaveDeltaPack is SavePack, with deltas as WriteDeltaPack makes them.
+func SaveDeltaPack(gitDir string, hashes []string, opts PackOptions) (string, error) {
+	packDir := filepath.Join(gitDir, "objects", "pack")
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		return "", fmt.Errorf("creating pack dir: %w", err)
+	}
+
+	var pack *WrittenPack
+	packTmp, err := writeTemp(packDir, "tmp_pack_", func(w io.Writer) error {
+		var err error
+		pack, err = WriteDeltaPack(w, gitDir, hashes, opts)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(packTmp)
+
+	idxTmp, err := writeTemp(packDir, "tmp_idx_", pack.WriteIndex)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(idxTmp)
+
+	base := filepath.Join(packDir, "pack-"+pack.Checksum)
+	if err := os.Rename(packTmp, base+".pack"); err != nil {
+		return "", fmt.Errorf("renaming pack into place: %w", err)
+	}
+	if err := os.Rename(idxTmp, base+".idx"); err != nil {
+		return "", fmt.Errorf("renaming pack index into place: %w", err)
+	}
+	return pack.Checksum, nil
+}
+
+// writeTemp creates a read-only temporary file in dir, fills it with
+// write, and returns its path. The file is removed if anything fails.
+func writeTemp(dir, pattern string, write func(io.Writer) error) (string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", fmt.Errorf("creating temp file: %w", err)
+	}
+	err = write(f)
+	if err == nil {
+		err = f.Chmod(0444)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// countingWriter counts the bytes written through it, giving each pack
+// entry's offset.
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += uint64(n)
+	return n, err
+}
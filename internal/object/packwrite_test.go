@@ -0,0 +1,155 @@
+package object
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSavePack(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	var hashes []string
+	bodies := map[string][]byte{}
+	for _, body := range []string{"hello\n", "world\n", string(bytes.Repeat([]byte("x"), 5000))} {
+		h, err := WriteObject(gitDir, TypeBlob, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, h)
+		bodies[h] = []byte(body)
+	}
+	// Repeats are packed once.
+	hashes = append(hashes, hashes[0])
+
+	checksum, err := SavePack(gitDir, hashes)
+	if err != nil {
+		t.Fatalf("SavePack() error: %v", err)
+	}
+
+	packPath := filepath.Join(gitDir, "objects", "pack", "pack-"+checksum+".pack")
+	data, err := os.ReadFile(packPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := data[8:12]; !bytes.Equal(got, []byte{0, 0, 0, 3}) {
+		t.Errorf("pack object count = %v, want 3", got)
+	}
+	sum := sha1.Sum(data[:len(data)-20])
+	if hex.EncodeToString(sum[:]) != checksum || !bytes.Equal(sum[:], data[len(data)-20:]) {
+		t.Errorf("pack trailer doesn't match its contents")
+	}
+
+	// With the loose copies gone, every object must come from the pack.
+	for h := range bodies {
+		os.Remove(filepath.Join(gitDir, "objects", h[:2], h[2:]))
+	}
+	for h, body := range bodies {
+		obj, err := Read(gitDir, h)
+		if err != nil {
+			t.Fatalf("Read(%s) from pack: %v", h[:7], err)
+		}
+		if !bytes.Equal(obj.Body, body) {
+			t.Errorf("Read(%s) body mismatch", h[:7])
+		}
+	}
+}
+
+func TestSaveDeltaPack(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	var hashes []string
+	bodies := map[string][]byte{}
+	version := bytes.Repeat([]byte("some text that is edited a little each time\n"), 200)
+	for i := range 8 {
+		version = append(version, fmt.Sprintf("edit %d\n", i)...)
+		h, err := WriteObject(gitDir, TypeBlob, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, h)
+		bodies[h] = bytes.Clone(version)
+	}
+
+	whole, err := SavePack(gitDir, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A depth of 2 forces more than one chain.
+	checksum, err := SaveDeltaPack(gitDir, hashes, PackOptions{Window: 10, Depth: 2})
+	if err != nil {
+		t.Fatalf("SaveDeltaPack() error: %v", err)
+	}
+	packDir := filepath.Join(gitDir, "objects", "pack")
+	wholeInfo, err := os.Stat(filepath.Join(packDir, "pack-"+whole+".pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deltaInfo, err := os.Stat(filepath.Join(packDir, "pack-"+checksum+".pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deltaInfo.Size() >= wholeInfo.Size()/2 {
+		t.Errorf("delta pack is %d bytes, whole pack %d", deltaInfo.Size(), wholeInfo.Size())
+	}
+
+	for _, suffix := range []string{".pack", ".idx"} {
+		os.Remove(filepath.Join(packDir, "pack-"+whole+suffix))
+	}
+	for h := range bodies {
+		os.Remove(filepath.Join(gitDir, "objects", h[:2], h[2:]))
+	}
+	for h, body := range bodies {
+		obj, err := Read(gitDir, h)
+		if err != nil {
+			t.Fatalf("Read(%s) from pack: %v", h[:7], err)
+		}
+		if !bytes.Equal(obj.Body, body) {
+			t.Errorf("Read(%s) body mismatch", h[:7])
+		}
+	}
+}
+
+func TestWritePack_Missing(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	var buf bytes.Buffer
+	if _, err := WritePack(&buf, gitDir, []string{"0123456789012345678901234567890123456789"}); err == nil {
+		t.Error("WritePack() of a missing object: want error")
+	}
+}
+
+func TestWriteIndex_LargeOffsets(t *testing.T) {
+	pack := &WrittenPack{
+		sum: make([]byte, 20),
+		entries: []writtenEntry{
+			{name: bytes.Repeat([]byte{0xbb}, 20), offset: 12},
+			{name: bytes.Repeat([]byte{0xaa}, 20), offset: 5 << 30},
+		},
+	}
+	var buf bytes.Buffer
+	if err := pack.WriteIndex(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := parsePackIndex(buf.Bytes(), "test.pack")
+	if err != nil {
+		t.Fatalf("parsePackIndex() error: %v", err)
+	}
+	for _, want := range []struct {
+		hash   string
+		offset uint64
+	}{
+		{hex.EncodeToString(pack.entries[0].name), 12},
+		{hex.EncodeToString(pack.entries[1].name), 5 << 30},
+	} {
+		entries := idx.find(want.hash)
+		if len(entries) != 1 || entries[0].offset != want.offset {
+			t.Errorf("find(%s) = %+v, want offset %d", want.hash[:4], entries, want.offset)
+		}
+	}
+}
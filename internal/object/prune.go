@@ -0,0 +1,48 @@
+package object
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// PrunePacked removes loose objects that are also stored in a packfile,
+// returning the paths of the files removed. Each object's pack membership
+// is checked before its loose copy is deleted, and fanout directories
+// left empty are removed too. With dryRun, nothing is deleted and the
+// paths that would be removed are returned.
+func PrunePacked(gitDir string, dryRun bool) ([]string, error) {
+	hashes, err := ListObjects(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []string
+	emptied := make(map[string]bool)
+	for _, h := range hashes {
+		packed, err := findPacked(gitDir, h)
+		if err != nil {
+			return pruned, fmt.Errorf("reading packs: %w", err)
+		}
+		if len(packed) == 0 {
+			continue
+		}
+
+		p := filepath.Join(gitDir, "objects", h[:2], h[2:])
+		pruned = append(pruned, p)
+		if dryRun {
+			continue
+		}
+		if err := os.Remove(p); err != nil {
+			return pruned, fmt.Errorf("removing %s: %w", p, err)
+		}
+		emptied[filepath.Dir(p)] = true
+	}
+
+	// Remove fails on non-empty directories, which is exactly the check
+	// we want.
+	for dir := range emptied {
+		os.Remove(dir)
+	}
+	return pruned, nil
+}
@@ -0,0 +1,48 @@
+package object
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrunePacked(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	hashes, _ := writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("hello\n")}})
+	packedSha := hashes[0]
+	Write(gitDir, packedSha, []byte("blob 6\x00hello\n"))
+
+	looseOnly := "cc628ccd10742baea8241c5924df992b5c019f71"
+	Write(gitDir, looseOnly, []byte("blob 6\x00world\n"))
+
+	// Dry run reports but keeps the file.
+	pruned, err := PrunePacked(gitDir, true)
+	if err != nil {
+		t.Fatalf("PrunePacked(dryRun) error: %v", err)
+	}
+	packedPath := filepath.Join(gitDir, "objects", packedSha[:2], packedSha[2:])
+	if len(pruned) != 1 || pruned[0] != packedPath {
+		t.Fatalf("dry run: got %v, want [%s]", pruned, packedPath)
+	}
+	if _, err := os.Stat(packedPath); err != nil {
+		t.Fatalf("dry run removed the loose object: %v", err)
+	}
+
+	if _, err := PrunePacked(gitDir, false); err != nil {
+		t.Fatalf("PrunePacked() error: %v", err)
+	}
+	if _, err := os.Stat(packedPath); !os.IsNotExist(err) {
+		t.Errorf("loose copy of packed object should be removed, stat err: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(packedPath)); !os.IsNotExist(err) {
+		t.Errorf("empty fanout dir should be removed, stat err: %v", err)
+	}
+
+	// Both objects are still readable: one from the pack, one loose.
+	for _, h := range []string{packedSha, looseOnly} {
+		if _, err := Read(gitDir, h); err != nil {
+			t.Errorf("Read(%s) after prune: %v", h, err)
+		}
+	}
+}
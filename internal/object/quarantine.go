@@ -0,0 +1,134 @@
+package object
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// quarantinePrefix names temporary object directories the same way git
+// does, so they are easy to spot (and clean up) under objects/.
+const quarantinePrefix = "tmp_objdir-incoming-"
+
+// Quarantine is a temporary object directory that received objects are
+// written into before they are known to be valid. Nothing in it is
+// visible to Read or Exists until Promote moves it into the main store.
+type Quarantine struct {
+	// Dir is the quarantine's object directory, laid out like objects/.
+	Dir string
+
+	gitDir string
+}
+
+// NewQuarantine creates an empty quarantine directory inside
+// <gitDir>/objects. Keeping it on the same filesystem as the object store
+// lets Promote use renames instead of copies.
+func NewQuarantine(gitDir string) (*Quarantine, error) {
+	dir, err := os.MkdirTemp(filepath.Join(gitDir, "objects"), quarantinePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("creating quarantine dir: %w", err)
+	}
+	return &Quarantine{Dir: dir, gitDir: gitDir}, nil
+}
+
+// Write stores a raw git object (header + content) in the quarantine.
+func (q *Quarantine) Write(sha string, fullObject []byte) error {
+	return writeLoose(q.Dir, sha, fullObject)
+}
+
+// Read reads the object with the full name hash from the quarantine, or
+// from the main store if the quarantine doesn't have it, so a push can be
+// checked against the objects it brings before they are promoted.
+func (q *Quarantine) Read(hash string) (*Object, error) {
+	if !isFullHash(hash) {
+		return nil, fmt.Errorf("invalid object name %q", hash)
+	}
+	path := filepath.Join(q.Dir, hash[:2], hash[2:])
+	if _, err := os.Stat(path); err != nil {
+		return Read(q.gitDir, hash)
+	}
+	return readLoose(path, hash, true)
+}
+
+// Promote moves every quarantined object into the main object store and
+// removes the quarantine directory. Objects already present in the store
+// are left untouched, since identical hashes mean identical content.
+// Loose objects move before packs, and each .pack before its .idx, so a
+// reader never finds an index whose pack isn't there yet.
+func (q *Quarantine) Promote() error {
+	objectsDir := filepath.Join(q.gitDir, "objects")
+
+	fanout, err := os.ReadDir(q.Dir)
+	if err != nil {
+		return fmt.Errorf("reading quarantine dir: %w", err)
+	}
+
+	hasPacks := false
+	for _, d := range fanout {
+		if !d.IsDir() {
+			continue
+		}
+		if d.Name() == "pack" {
+			hasPacks = true
+			continue
+		}
+		if err := promoteDir(filepath.Join(q.Dir, d.Name()), filepath.Join(objectsDir, d.Name()), false); err != nil {
+			return err
+		}
+	}
+	if hasPacks {
+		if err := promoteDir(filepath.Join(q.Dir, "pack"), filepath.Join(objectsDir, "pack"), true); err != nil {
+			return err
+		}
+	}
+
+	return q.Discard()
+}
+
+// promoteDir renames the files in srcDir into dstDir, skipping any dstDir
+// already has. With indexLast, .idx files go after everything else.
+func promoteDir(srcDir, dstDir string, indexLast bool) error {
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return fmt.Errorf("creating object dir: %w", err)
+	}
+
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return fmt.Errorf("reading quarantine dir: %w", err)
+	}
+	move := func(e os.DirEntry) error {
+		dst := filepath.Join(dstDir, e.Name())
+		if _, err := os.Stat(dst); err == nil {
+			return nil
+		}
+		if err := os.Rename(filepath.Join(srcDir, e.Name()), dst); err != nil {
+			return fmt.Errorf("promoting %s/%s: %w", filepath.Base(srcDir), e.Name(), err)
+		}
+		return nil
+	}
+	var indexes []os.DirEntry
+	for _, e := range entries {
+		if indexLast && strings.HasSuffix(e.Name(), ".idx") {
+			indexes = append(indexes, e)
+			continue
+		}
+		if err := move(e); err != nil {
+			return err
+		}
+	}
+	for _, e := range indexes {
+		if err := move(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Discard deletes the quarantine and everything in it.
+func (q *Quarantine) Discard() error {
+	if err := os.RemoveAll(q.Dir); err != nil {
+		return fmt.Errorf("removing quarantine dir: %w", err)
+	}
+	return nil
+}
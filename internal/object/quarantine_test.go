@@ -0,0 +1,164 @@
+package object
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQuarantine_Promote(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	q, err := NewQuarantine(gitDir)
+	if err != nil {
+		t.Fatalf("NewQuarantine() error: %v", err)
+	}
+
+	sha := "ce013625030ba8dba906f756967f9e9ca394464a"
+	if err := q.Write(sha, []byte("blob 6\x00hello\n")); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+
+	// Quarantined objects must not be visible yet.
+	if err := Exists(gitDir, sha); err == nil {
+		t.Fatal("quarantined object visible before Promote")
+	}
+
+	if err := q.Promote(); err != nil {
+		t.Fatalf("Promote() error: %v", err)
+	}
+
+	obj, err := Read(gitDir, sha)
+	if err != nil {
+		t.Fatalf("Read() after Promote: %v", err)
+	}
+	if string(obj.Body) != "hello\n" {
+		t.Errorf("body: got %q, want %q", obj.Body, "hello\n")
+	}
+	if _, err := os.Stat(q.Dir); !os.IsNotExist(err) {
+		t.Errorf("quarantine dir should be removed after Promote, stat err: %v", err)
+	}
+}
+
+func TestQuarantine_PromoteExisting(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	sha := "ce013625030ba8dba906f756967f9e9ca394464a"
+	data := []byte("blob 6\x00hello\n")
+	if err := Write(gitDir, sha, data); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := NewQuarantine(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Write(sha, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Promote(); err != nil {
+		t.Fatalf("Promote() with existing object: %v", err)
+	}
+	if err := Exists(gitDir, sha); err != nil {
+		t.Errorf("Exists() after Promote: %v", err)
+	}
+}
+
+func TestQuarantine_PromotePacks(t *testing.T) {
+	gitDir := testGitDir(t)
+	q, err := NewQuarantine(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha := "ce013625030ba8dba906f756967f9e9ca394464a"
+	if err := q.Write(sha, []byte("blob 6\x00hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(q.Dir, "pack"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"pack-1.idx", "pack-1.pack"} {
+		if err := os.WriteFile(filepath.Join(q.Dir, "pack", name), []byte(name), 0444); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A loose object that can't be promoted stops the packs going in.
+	blocker := filepath.Join(gitDir, "objects", sha[:2])
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Promote(); err == nil {
+		t.Fatal("Promote() succeeded with a loose object blocked")
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "objects", "pack", "pack-1.pack")); !os.IsNotExist(err) {
+		t.Errorf("pack promoted before loose objects: stat err %v", err)
+	}
+
+	os.Remove(blocker)
+	if err := q.Promote(); err != nil {
+		t.Fatalf("Promote() error: %v", err)
+	}
+	for _, name := range []string{"pack-1.idx", "pack-1.pack"} {
+		if data, err := os.ReadFile(filepath.Join(gitDir, "objects", "pack", name)); err != nil || string(data) != name {
+			t.Errorf("%s after Promote: %q, %v", name, data, err)
+		}
+	}
+	if err := Exists(gitDir, sha); err != nil {
+		t.Errorf("Exists() after Promote: %v", err)
+	}
+}
+
+func TestQuarantine_Discard(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	q, err := NewQuarantine(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sha := "ce013625030ba8dba906f756967f9e9ca394464a"
+	if err := q.Write(sha, []byte("blob 6\x00hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Discard(); err != nil {
+		t.Fatalf("Discard() error: %v", err)
+	}
+
+	if err := Exists(gitDir, sha); err == nil {
+		t.Error("discarded object should not exist in the store")
+	}
+	if _, err := os.Stat(q.Dir); !os.IsNotExist(err) {
+		t.Errorf("quarantine dir should be removed, stat err: %v", err)
+	}
+}
+
+func TestQuarantine_Read(t *testing.T) {
+	gitDir := testGitDir(t)
+	outside := writeTestBlob(t, gitDir, "outside\n")
+
+	q, err := NewQuarantine(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Discard()
+	inside := "ce013625030ba8dba906f756967f9e9ca394464a"
+	if err := q.Write(inside, []byte("blob 6\x00hello\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	for hash, want := range map[string]string{inside: "hello\n", outside: "outside\n"} {
+		obj, err := q.Read(hash)
+		if err != nil {
+			t.Fatalf("Read(%s) error: %v", hash[:7], err)
+		}
+		if string(obj.Body) != want {
+			t.Errorf("Read(%s) = %q, want %q", hash[:7], obj.Body, want)
+		}
+	}
+	if _, err := q.Read(strings.Repeat("1", 40)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read of a missing object: got %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,75 @@
+package object
+
+import "fmt"
+
+// Reachable returns every object reachable from tips, which may be
+// commits, trees, blobs, or annotated tags, leaving out everything
+// reachable from exclude. Each object comes after the one that led to
+// it, tips first, which is the order a pack is best written in. Objects
+// in exclude must exist; submodule commits in trees are not followed.
+//
+// The whole of exclude's history is walked first, so this costs the same
+// however little of it is shared with tips.
+func Reachable(gitDir string, tips, exclude []string) ([]string, error) {
+	seen := make(map[string]bool)
+	if _, err := walkObjects(gitDir, exclude, seen); err != nil {
+		return nil, err
+	}
+	return walkObjects(gitDir, tips, seen)
+}
+
+// walkObjects returns the objects reachable from start that aren't in
+// seen, adding each to seen.
+func walkObjects(gitDir string, start []string, seen map[string]bool) ([]string, error) {
+	var out []string
+	stack := make([]string, 0, len(start))
+	for i := len(start) - 1; i >= 0; i-- {
+		stack = append(stack, start[i])
+	}
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		out = append(out, hash)
+
+		obj, err := Read(gitDir, hash)
+		if err != nil {
+			return nil, err
+		}
+		// Pushed in reverse, so they're visited in the order named.
+		var next []string
+		switch obj.Type {
+		case TypeCommit:
+			c, err := ParseCommit(obj)
+			if err != nil {
+				return nil, err
+			}
+			next = append([]string{c.Tree}, c.Parents...)
+		case TypeTree:
+			entries, err := ParseTreeWith(algorithmFor(hash), obj.Body)
+			if err != nil {
+				return nil, fmt.Errorf("tree %s: %w", hash, err)
+			}
+			for _, e := range entries {
+				if e.Mode != ModeGitlink {
+					next = append(next, e.Hash)
+				}
+			}
+		case TypeTag:
+			t, err := ParseTag(obj)
+			if err != nil {
+				return nil, err
+			}
+			next = []string{t.Object}
+		}
+		for i := len(next) - 1; i >= 0; i-- {
+			if !seen[next[i]] {
+				stack = append(stack, next[i])
+			}
+		}
+	}
+	return out, nil
+}
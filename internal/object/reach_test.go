@@ -0,0 +1,67 @@
+package object
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReachable(t *testing.T) {
+	gitDir := testGitDir(t)
+	write := func(typ Type, body []byte) string {
+		t.Helper()
+		h, err := WriteObject(gitDir, typ, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	tree := func(entries ...TreeEntry) string {
+		t.Helper()
+		body, err := EncodeTree(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return write(TypeTree, body)
+	}
+	sig := Signature{Name: "A", Email: "a@example.com", When: 1, Timezone: "+0000"}
+	commit := func(tree string, parents ...string) string {
+		c := &Commit{Tree: tree, Parents: parents, Author: sig, Committer: sig, Message: "m\n"}
+		return write(TypeCommit, c.Bytes())
+	}
+
+	// first has one file; second adds another, in a subdirectory, and
+	// keeps the first. A submodule entry is never followed.
+	one, two := writeTestBlob(t, gitDir, "one\n"), writeTestBlob(t, gitDir, "two\n")
+	tree1 := tree(TreeEntry{ModeFile, "one", one})
+	sub := tree(TreeEntry{ModeFile, "two", two})
+	gitlink := "1111111111111111111111111111111111111111"
+	tree2 := tree(TreeEntry{ModeFile, "one", one}, TreeEntry{ModeTree, "sub", sub}, TreeEntry{ModeGitlink, "mod", gitlink})
+	first := commit(tree1)
+	second := commit(tree2, first)
+	tag := write(TypeTag, (&Tag{Object: second, Type: TypeCommit, Tag: "v1", Tagger: sig, Message: "v1\n"}).Bytes())
+
+	tests := []struct {
+		name          string
+		tips, exclude []string
+		want          []string
+	}{
+		{"everything", []string{tag}, nil, []string{tag, second, tree2, one, sub, two, first, tree1}},
+		{"excluding the parent", []string{second}, []string{first}, []string{second, tree2, sub, two}},
+		{"nothing new", []string{first}, []string{second}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Reachable(gitDir, tt.tips, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Reachable(gitDir, []string{gitlink}, nil); err == nil {
+		t.Error("Reachable from a missing object succeeded")
+	}
+}
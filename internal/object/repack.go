@@ -0,0 +1,185 @@
+package object
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RepackOptions controls Repack.
+type RepackOptions struct {
+	// All packs every object in the existing packs, along with the loose
+	// objects, into one new pack. Otherwise only loose objects that no
+	// pack has yet are packed. Packs with a .keep or .promisor file are
+	// left alone.
+	All bool
+	// Delete removes what the new pack makes redundant: with All, the
+	// old packs, each only once all of its objects are found in the new
+	// pack; and the loose copies of packed objects.
+	Delete bool
+	// Window and Depth are passed to SaveDeltaPack. A zero Window
+	// stores every object whole.
+	Window, Depth int
+}
+
+// RepackResult says what Repack did.
+type RepackResult struct {
+	// Checksum names the new pack, or is "" if there was nothing to pack.
+	Checksum string
+	Objects  int
+	// Removed lists the .pack files deleted, and Pruned the loose
+	// objects.
+	Removed, Pruned []string
+}
+
+// packSuffixes are the files that can sit beside a .pack and go when it
+// does.
+var packSuffixes = []string{".idx", ".pack", ".rev", ".bitmap", ".mtimes"}
+
+// Repack packs objects into a new pack in <gitDir>/objects/pack, as git
+// repack does, and with opts.Delete removes the packs and loose objects
+// it supersedes. Deltas are computed afresh, as opts.Window and
+// opts.Depth allow; none are carried over from the old packs.
+func Repack(gitDir string, opts RepackOptions) (*RepackResult, error) {
+	loose, err := ListObjects(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	var hashes, old []string
+	if opts.All {
+		if old, err = repackablePacks(gitDir); err != nil {
+			return nil, err
+		}
+		for _, p := range old {
+			names, err := packObjectNames(p)
+			if err != nil {
+				return nil, err
+			}
+			hashes = append(hashes, names...)
+		}
+		// In a fixed order, repacking again makes the same pack.
+		hashes = append(hashes, loose...)
+		sort.Strings(hashes)
+	} else {
+		for _, h := range loose {
+			packed, err := findPacked(gitDir, h)
+			if err != nil {
+				return nil, fmt.Errorf("reading packs: %w", err)
+			}
+			if len(packed) == 0 {
+				hashes = append(hashes, h)
+			}
+		}
+	}
+
+	res := &RepackResult{}
+	if len(hashes) > 0 {
+		if res.Checksum, err = SaveDeltaPack(gitDir, hashes, PackOptions{Window: opts.Window, Depth: opts.Depth}); err != nil {
+			return nil, err
+		}
+		newPack := filepath.Join(gitDir, "objects", "pack", "pack-"+res.Checksum+".pack")
+		names, err := packObjectNames(newPack)
+		if err != nil {
+			return nil, err
+		}
+		res.Objects = len(names)
+		if opts.Delete {
+			if res.Removed, err = removePacks(gitDir, old, newPack, names); err != nil {
+				return res, err
+			}
+		}
+	}
+	if opts.Delete {
+		if res.Pruned, err = PrunePacked(gitDir, false); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// repackablePacks returns the path of every .pack in gitDir that has an
+// index and no .keep or .promisor file.
+func repackablePacks(gitDir string) ([]string, error) {
+	idxPaths, err := filepath.Glob(filepath.Join(gitDir, "objects", "pack", "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	var packs []string
+	for _, p := range idxPaths {
+		base := strings.TrimSuffix(p, ".idx")
+		if exists(base+".keep") || exists(base+".promisor") {
+			continue
+		}
+		packs = append(packs, base+".pack")
+	}
+	return packs, nil
+}
+
+// packObjectNames returns the hashes of the objects in a pack, read from
+// its index.
+func packObjectNames(packPath string) ([]string, error) {
+	idxPath := strings.TrimSuffix(packPath, ".pack") + ".idx"
+	data, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := parsePackIndex(data, packPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(idxPath), err)
+	}
+	names := make([]string, idx.count())
+	for i := range names {
+		names[i] = idx.name(i)
+	}
+	return names, nil
+}
+
+// removePacks deletes the packs in old, other than newPack itself, once
+// each is checked to hold nothing missing from newNames. A
+// multi-pack-index is removed with them, since it would name packs that
+// are gone.
+func removePacks(gitDir string, old []string, newPack string, newNames []string) ([]string, error) {
+	have := make(map[string]bool, len(newNames))
+	for _, h := range newNames {
+		have[h] = true
+	}
+	var removed []string
+	for _, p := range old {
+		if p == newPack {
+			continue
+		}
+		names, err := packObjectNames(p)
+		if err != nil {
+			return removed, err
+		}
+		for _, h := range names {
+			if !have[h] {
+				return removed, fmt.Errorf("%s: object %s is missing from the new pack", filepath.Base(p), h)
+			}
+		}
+		if len(removed) == 0 {
+			midx := filepath.Join(gitDir, "objects", "pack", "multi-pack-index")
+			if err := os.Remove(midx); err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+		}
+		// The index goes first, so readers never find an index without
+		// its pack.
+		base := strings.TrimSuffix(p, ".pack")
+		for _, suffix := range packSuffixes {
+			if err := os.Remove(base + suffix); err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+		}
+		removed = append(removed, p)
+	}
+	return removed, nil
+}
+
+// exists reports whether a file is at path.
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
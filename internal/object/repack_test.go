@@ -0,0 +1,93 @@
+package object
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// packFiles returns the names of the files in gitDir's pack directory.
+func packFiles(t *testing.T, gitDir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(gitDir, "objects", "pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestRepack(t *testing.T) {
+	gitDir := testGitDir(t)
+	first, idx1 := writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("one\n")}})
+	second, idx2 := writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("two\n")}, {TypeBlob, []byte("one\n")}})
+	idxNames := []string{idx1, idx2}
+	sort.Strings(idxNames)
+	writeTestMultiPackIndex(t, gitDir, idxNames)
+	loose := writeTestBlob(t, gitDir, "loose\n")
+	alsoPacked := writeTestBlob(t, gitDir, "two\n")
+
+	// Without All, only the object no pack has is packed.
+	res, err := Repack(gitDir, RepackOptions{})
+	if err != nil {
+		t.Fatalf("Repack() error: %v", err)
+	}
+	if res.Objects != 1 || len(res.Removed) != 0 || len(res.Pruned) != 0 {
+		t.Errorf("Repack() = %+v, want one object packed and nothing removed", res)
+	}
+	if got := len(packFiles(t, gitDir)); got != 7 {
+		t.Errorf("%d files in the pack dir, want 7", got)
+	}
+
+	res, err = Repack(gitDir, RepackOptions{All: true, Delete: true})
+	if err != nil {
+		t.Fatalf("Repack(All, Delete) error: %v", err)
+	}
+	if res.Objects != 3 || len(res.Removed) != 3 || len(res.Pruned) != 2 {
+		t.Errorf("Repack(All, Delete) = %+v, want 3 objects, 3 packs and 2 loose objects removed", res)
+	}
+	want := []string{"pack-" + res.Checksum + ".idx", "pack-" + res.Checksum + ".pack"}
+	if got := packFiles(t, gitDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("pack dir = %v, want %v", got, want)
+	}
+	for _, h := range []string{first[0], second[0], loose, alsoPacked} {
+		if _, err := Read(gitDir, h); err != nil {
+			t.Errorf("Read(%s) after repack: %v", h, err)
+		}
+	}
+
+	// The same objects make the same pack, which isn't removed.
+	again, err := Repack(gitDir, RepackOptions{All: true, Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Checksum != res.Checksum || len(again.Removed) != 0 {
+		t.Errorf("second Repack(All, Delete) = %+v, want pack %s kept", again, res.Checksum)
+	}
+}
+
+func TestRepack_Keep(t *testing.T) {
+	gitDir := testGitDir(t)
+	_, kept := writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("kept\n")}})
+	keep := filepath.Join(gitDir, "objects", "pack", kept[:len(kept)-len(".idx")]+".keep")
+	if err := os.WriteFile(keep, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestBlob(t, gitDir, "loose\n")
+
+	res, err := Repack(gitDir, RepackOptions{All: true, Delete: true})
+	if err != nil {
+		t.Fatalf("Repack() error: %v", err)
+	}
+	if res.Objects != 1 || len(res.Removed) != 0 {
+		t.Errorf("Repack() = %+v, want only the loose object packed", res)
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "objects", "pack", kept)); err != nil {
+		t.Errorf("kept pack removed: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package object
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ServeObject writes the body of the object identified by hash to w, so
+// rev can be embedded as a simple object server. The response carries the
+// object's size as Content-Length and its type in X-Git-Object-Type, and
+// Range requests are honored so clients can fetch large blobs in pieces.
+// A missing object is a 404; any other read failure is a 500.
+func ServeObject(w http.ResponseWriter, r *http.Request, gitDir, hash string) {
+	obj, err := Read(gitDir, hash)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Git-Object-Type", string(obj.Type))
+	// Objects are immutable, so the hash is a perfect validator.
+	w.Header().Set("ETag", `"`+obj.Hash+`"`)
+
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(obj.Body))
+}
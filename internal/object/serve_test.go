@@ -0,0 +1,65 @@
+package object
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeObject(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	sha := "ce013625030ba8dba906f756967f9e9ca394464a"
+	Write(gitDir, sha, []byte("blob 6\x00hello\n"))
+
+	req := httptest.NewRequest(http.MethodGet, "/"+sha, nil)
+	rec := httptest.NewRecorder()
+	ServeObject(rec, req, gitDir, sha)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200", rec.Code)
+	}
+	if got := rec.Body.String(); got != "hello\n" {
+		t.Errorf("body: got %q, want %q", got, "hello\n")
+	}
+	if got := rec.Header().Get("Content-Length"); got != "6" {
+		t.Errorf("Content-Length: got %q, want 6", got)
+	}
+	if got := rec.Header().Get("X-Git-Object-Type"); got != "blob" {
+		t.Errorf("X-Git-Object-Type: got %q, want blob", got)
+	}
+}
+
+func TestServeObject_Range(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	sha := "ce013625030ba8dba906f756967f9e9ca394464a"
+	Write(gitDir, sha, []byte("blob 6\x00hello\n"))
+
+	req := httptest.NewRequest(http.MethodGet, "/"+sha, nil)
+	req.Header.Set("Range", "bytes=1-3")
+	rec := httptest.NewRecorder()
+	ServeObject(rec, req, gitDir, sha)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status: got %d, want 206", rec.Code)
+	}
+	if got := rec.Body.String(); got != "ell" {
+		t.Errorf("body: got %q, want %q", got, "ell")
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes 1-3/6" {
+		t.Errorf("Content-Range: got %q", got)
+	}
+}
+
+func TestServeObject_NotFound(t *testing.T) {
+	gitDir := testGitDir(t)
+
+	sha := "0000000000000000000000000000000000000000"
+	rec := httptest.NewRecorder()
+	ServeObject(rec, httptest.NewRequest(http.MethodGet, "/"+sha, nil), gitDir, sha)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status: got %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,195 @@
+package object
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/elliota43/rev/internal/trace"
+)
+
+// ReadStream opens an object for streaming: it returns the type and size
+// from the header and a reader over the inflated body, so large blobs can
+// be copied out in constant memory. The caller must close the reader.
+// An object declaring more than MaxObjectSize is refused with ErrTooLarge
+// before any of its body is read. Reading past a body that ends before its declared size returns an
+// ErrCorrupt error.
+func ReadStream(gitDir, hash string) (Type, int64, io.ReadCloser, error) {
+	loc, err := locate(gitDir, hash)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if trace.Enabled() {
+		trace.Log("object", "stream %s (%s)", loc.hash, loc.source())
+	}
+	if loc.packed() {
+		return streamPacked(loc.pack)
+	}
+
+	f, err := os.Open(loc.loosePath)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("opening object file: %w", err)
+	}
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		f.Close()
+		return "", 0, nil, corrupt(loc.hash, fmt.Errorf("creating zlib reader: %w", err))
+	}
+	br := bufio.NewReader(zr)
+	objType, size, err := parseHeaderFromReader(br)
+	if err == nil {
+		err = checkType(loc.hash, objType)
+	} else {
+		err = corrupt(loc.hash, err)
+	}
+	if err == nil {
+		err = checkSize(loc.hash, size)
+	}
+	if err != nil {
+		zr.Close()
+		f.Close()
+		return "", 0, nil, err
+	}
+	return objType, size, newBodyReader(loc.hash, br, size, zr, f), nil
+}
+
+// ReadRaw opens an object's raw inflated bytes, "<type> <size>\x00"
+// header included, for debugging. The header type is returned as written,
+// even if it isn't a known object type, and the reader yields whatever
+// the object holds without checking it against the declared size. Packed
+// objects get the header their loose form would have. The caller must
+// close the reader.
+func ReadRaw(gitDir, hash string) (Type, int64, io.ReadCloser, error) {
+	loc, err := locate(gitDir, hash)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if trace.Enabled() {
+		trace.Log("object", "read raw %s (%s)", loc.hash, loc.source())
+	}
+	if loc.packed() {
+		objType, size, body, err := streamPacked(loc.pack)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		return objType, size, rawReader{io.MultiReader(strings.NewReader(Header(objType, size)), body), body}, nil
+	}
+
+	f, err := os.Open(loc.loosePath)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("opening object file: %w", err)
+	}
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		f.Close()
+		return "", 0, nil, corrupt(loc.hash, fmt.Errorf("creating zlib reader: %w", err))
+	}
+	br := bufio.NewReader(zr)
+	header, err := br.ReadString('\x00')
+	var objType Type
+	var size int64
+	if err == nil {
+		objType, size, err = parseHeader(strings.TrimSuffix(header, "\x00"))
+	}
+	if err != nil {
+		zr.Close()
+		f.Close()
+		return "", 0, nil, corrupt(loc.hash, fmt.Errorf("reading object header: %w", err))
+	}
+	if err := checkSize(loc.hash, size); err != nil {
+		zr.Close()
+		f.Close()
+		return "", 0, nil, err
+	}
+	return objType, size, rawReader{io.MultiReader(strings.NewReader(header), br), multiCloser{zr, f}}, nil
+}
+
+// rawReader pairs a reader with the closer for what it reads from.
+type rawReader struct {
+	io.Reader
+	io.Closer
+}
+
+// multiCloser closes each closer in order, returning the first error.
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var first error
+	for _, c := range m {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// streamPacked opens a pack entry for streaming. A whole object is
+// inflated as it is read; a delta has to be resolved against its base
+// first, so it is read into memory and served from there.
+func streamPacked(e packEntry) (Type, int64, io.ReadCloser, error) {
+	f, br, code, size, _, err := openPackEntryHeader(e, e.hash)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	objType, ok := packTypes[code]
+	if !ok {
+		f.Close()
+		o, err := readPacked(e)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		return o.Type, o.Size, io.NopCloser(bytes.NewReader(o.Body)), nil
+	}
+	if err := checkSize(e.hash, size); err != nil {
+		f.Close()
+		return "", 0, nil, err
+	}
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		f.Close()
+		return "", 0, nil, corrupt(e.hash, fmt.Errorf("creating zlib reader: %w", err))
+	}
+	return objType, size, newBodyReader(e.hash, zr, size, zr, f), nil
+}
+
+// bodyReader yields exactly the declared number of body bytes and closes
+// the underlying decompressor and file when closed.
+type bodyReader struct {
+	hash    string
+	r       io.Reader
+	remain  int64
+	closers []io.Closer
+}
+
+func newBodyReader(hash string, r io.Reader, size int64, closers ...io.Closer) *bodyReader {
+	return &bodyReader{hash: hash, r: r, remain: size, closers: closers}
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.remain <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remain {
+		p = p[:b.remain]
+	}
+	n, err := b.r.Read(p)
+	b.remain -= int64(n)
+	if err == io.EOF && b.remain > 0 {
+		return n, corrupt(b.hash, fmt.Errorf("body ends %d bytes short: %w", b.remain, io.ErrUnexpectedEOF))
+	}
+	if err != nil && err != io.EOF {
+		return n, corrupt(b.hash, err)
+	}
+	if b.remain == 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b *bodyReader) Close() error {
+	return multiCloser(b.closers).Close()
+}
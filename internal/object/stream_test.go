@@ -0,0 +1,154 @@
+package object
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReadStream_Loose(t *testing.T) {
+	gitDir := testGitDir(t)
+	body := bytes.Repeat([]byte("streamed line\n"), 1000)
+	sha, full, _ := Hash(TypeBlob, bytes.NewReader(body), int64(len(body)))
+	Write(gitDir, sha, full)
+
+	objType, size, r, err := ReadStream(gitDir, sha[:8])
+	if err != nil {
+		t.Fatalf("ReadStream() error: %v", err)
+	}
+	defer r.Close()
+
+	if objType != TypeBlob || size != int64(len(body)) {
+		t.Errorf("header: got %s %d", objType, size)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading stream: %v", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("body mismatch: got %d bytes", len(got))
+	}
+}
+
+func TestReadStream_Packed(t *testing.T) {
+	gitDir := testGitDir(t)
+	body := []byte("packed and streamed\n")
+	hashes, _ := writeTestPack(t, gitDir, []testObject{{TypeBlob, body}})
+
+	objType, size, r, err := ReadStream(gitDir, hashes[0])
+	if err != nil {
+		t.Fatalf("ReadStream() error: %v", err)
+	}
+	defer r.Close()
+	got, _ := io.ReadAll(r)
+	if objType != TypeBlob || size != int64(len(body)) || !bytes.Equal(got, body) {
+		t.Errorf("got %s %d %q", objType, size, got)
+	}
+}
+
+func TestReadStream_Truncated(t *testing.T) {
+	gitDir := testGitDir(t)
+	sha := "ce013625030ba8dba906f756967f9e9ca394464a"
+	// The header promises 100 bytes but the body has 6.
+	Write(gitDir, sha, []byte("blob 100\x00hello\n"))
+
+	_, _, r, err := ReadStream(gitDir, sha)
+	if err != nil {
+		t.Fatalf("ReadStream() error: %v", err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("got %v, want ErrCorrupt", err)
+	}
+}
+
+func TestReadStream_Missing(t *testing.T) {
+	gitDir := testGitDir(t)
+	if _, _, _, err := ReadStream(gitDir, "0000000000000000000000000000000000000000"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+}
+
+func TestReadRaw(t *testing.T) {
+	gitDir := testGitDir(t)
+	sha := writeTestBlob(t, gitDir, "hello\n")
+	packed, _ := writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("packed\n")}})
+
+	for hash, want := range map[string]string{
+		sha:       "blob 6\x00hello\n",
+		packed[0]: "blob 7\x00packed\n",
+	} {
+		objType, _, r, err := ReadRaw(gitDir, hash)
+		if err != nil {
+			t.Fatalf("ReadRaw(%s) error: %v", hash, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || objType != TypeBlob || string(got) != want {
+			t.Errorf("ReadRaw(%s): got %s %q, %v; want %q", hash, objType, got, err, want)
+		}
+	}
+}
+
+func TestReadStream_OverLimit(t *testing.T) {
+	gitDir := testGitDir(t)
+	body := bytes.Repeat([]byte("x"), 100)
+	loose := writeTestBlob(t, gitDir, string(body))
+	packed, _ := writeTestPack(t, gitDir, []testObject{{TypeBlob, append(body, '\n')}})
+	setMaxObjectSize(t, 50)
+
+	for name, hash := range map[string]string{"loose": loose, "packed": packed[0]} {
+		if _, _, _, err := ReadStream(gitDir, hash); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("ReadStream(%s) = %v, want ErrTooLarge", name, err)
+		}
+		if _, _, _, err := ReadRaw(gitDir, hash); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("ReadRaw(%s) = %v, want ErrTooLarge", name, err)
+		}
+	}
+}
+
+func TestUnknownType(t *testing.T) {
+	gitDir := testGitDir(t)
+	full := []byte("widget 3\x00abc")
+	sha := HashBytes(full)
+	if err := Write(gitDir, sha, full); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Read(gitDir, sha); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Read(): got %v, want ErrCorrupt", err)
+	}
+	if _, _, err := ReadHeader(gitDir, sha); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("ReadHeader(): got %v, want ErrCorrupt", err)
+	}
+	if _, _, _, err := ReadStream(gitDir, sha); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("ReadStream(): got %v, want ErrCorrupt", err)
+	}
+
+	if obj, err := ReadUnchecked(gitDir, sha); err != nil || obj.Type != "widget" {
+		t.Errorf("ReadUnchecked(): got %+v, %v", obj, err)
+	}
+	objType, size, r, err := ReadRaw(gitDir, sha)
+	if err != nil {
+		t.Fatalf("ReadRaw() error: %v", err)
+	}
+	defer r.Close()
+	got, _ := io.ReadAll(r)
+	if objType != "widget" || size != 3 || !bytes.Equal(got, full) {
+		t.Errorf("ReadRaw(): got %s %d %q", objType, size, got)
+	}
+}
+
+func BenchmarkReadStream(b *testing.B) {
+	gitDir, sha := benchBlob(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		_, _, r, err := ReadStream(gitDir, sha)
+		if err != nil {
+			b.Fatal(err)
+		}
+		io.Copy(io.Discard, r)
+		r.Close()
+	}
+}
@@ -0,0 +1,102 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Tag is a parsed annotated tag object.
+type Tag struct {
+	// Object is the hash of the tagged object, and Type its type.
+	Object  string
+	Type    Type
+	Tag     string
+	Tagger  Signature
+	Message string
+	// Sig is the signature block that follows the message of a signed
+	// tag, or "" for an unsigned one.
+	Sig string
+}
+
+// sigStarts begin the lines that open the signature blocks git appends
+// to a signed tag's message, one for each signing format.
+var sigStarts = []string{
+	"-----BEGIN PGP SIGNATURE-----",
+	"-----BEGIN PGP MESSAGE-----",
+	"-----BEGIN SIGNED MESSAGE-----",
+	"-----BEGIN SSH SIGNATURE-----",
+}
+
+// SplitSignature splits the body of a signed tag into the payload that
+// was signed and the signature block after it, which starts at the last
+// line opening one. sig is empty if there is no such line.
+func SplitSignature(body []byte) (payload, sig []byte) {
+	start := -1
+	for i := 0; i < len(body); {
+		for _, s := range sigStarts {
+			if bytes.HasPrefix(body[i:], []byte(s)) {
+				start = i
+			}
+		}
+		eol := bytes.IndexByte(body[i:], '\n')
+		if eol < 0 {
+			break
+		}
+		i += eol + 1
+	}
+	if start < 0 {
+		return body, nil
+	}
+	return body[:start], body[start:]
+}
+
+// ParseTag parses a tag object. Its headers must come in git's order:
+// object, type, tag, then tagger. The tagger line is optional, since
+// very old tags were written without one. Any headers after these
+// (such as gpgsig) are skipped. A signature block at the end of the
+// message goes in Sig.
+func ParseTag(o *Object) (*Tag, error) {
+	if o.Type != TypeTag {
+		return nil, fmt.Errorf("object %s is a %s, not a tag", o.Hash, o.Type)
+	}
+
+	headers, message, err := splitHeaders(o.Body)
+	if err != nil {
+		return nil, fmt.Errorf("tag %s: %w", o.Hash, err)
+	}
+
+	payload, sig := SplitSignature([]byte(message))
+	t := &Tag{Message: string(payload), Sig: string(sig)}
+	for i, key := range []string{"object", "type", "tag"} {
+		if i >= len(headers) || headers[i].Key != key {
+			return nil, fmt.Errorf("tag %s: missing %s header", o.Hash, key)
+		}
+	}
+	t.Object = headers[0].Value
+	t.Type = Type(headers[1].Value)
+	t.Tag = headers[2].Value
+
+	if len(headers) > 3 && headers[3].Key == "tagger" {
+		if t.Tagger, err = ParseSignature(headers[3].Value); err != nil {
+			return nil, fmt.Errorf("tag %s: tagger: %w", o.Hash, err)
+		}
+	}
+	return t, nil
+}
+
+// Bytes encodes the tag as a tag object body, the inverse of ParseTag. The
+// tagger line is omitted if Tagger is unset, and Sig follows the
+// message.
+func (t *Tag) Bytes() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "object %s\n", t.Object)
+	fmt.Fprintf(&b, "type %s\n", t.Type)
+	fmt.Fprintf(&b, "tag %s\n", t.Tag)
+	if t.Tagger != (Signature{}) {
+		fmt.Fprintf(&b, "tagger %s\n", t.Tagger)
+	}
+	b.WriteString("\n")
+	b.WriteString(t.Message)
+	b.WriteString(t.Sig)
+	return b.Bytes()
+}
@@ -0,0 +1,110 @@
+package object
+
+import (
+	"strings"
+	"testing"
+)
+
+func tagObject(body string) *Object {
+	return &Object{Type: TypeTag, Size: int64(len(body)), Hash: "7a9", Body: []byte(body)}
+}
+
+func TestParseTag(t *testing.T) {
+	body := "object 1111111111111111111111111111111111111111\n" +
+		"type commit\n" +
+		"tag v1.0.0\n" +
+		"tagger T Agger <tagger@example.com> 1700000000 +0900\n" +
+		"\n" +
+		"Release 1.0.0\n"
+
+	tag, err := ParseTag(tagObject(body))
+	if err != nil {
+		t.Fatalf("ParseTag() error: %v", err)
+	}
+	if tag.Object != "1111111111111111111111111111111111111111" || tag.Type != TypeCommit || tag.Tag != "v1.0.0" {
+		t.Errorf("got %+v", tag)
+	}
+	if tag.Tagger.Name != "T Agger" || tag.Tagger.Timezone != "+0900" {
+		t.Errorf("Tagger: got %+v", tag.Tagger)
+	}
+	if tag.Message != "Release 1.0.0\n" {
+		t.Errorf("Message: got %q", tag.Message)
+	}
+}
+
+func TestParseTag_NoTagger(t *testing.T) {
+	body := "object 1111111111111111111111111111111111111111\ntype blob\ntag old\n\nOld tag\n"
+	tag, err := ParseTag(tagObject(body))
+	if err != nil {
+		t.Fatalf("ParseTag() error: %v", err)
+	}
+	if tag.Type != TypeBlob || tag.Tagger != (Signature{}) {
+		t.Errorf("got %+v", tag)
+	}
+}
+
+func TestParseTag_Errors(t *testing.T) {
+	if _, err := ParseTag(&Object{Type: TypeCommit, Hash: "abc"}); err == nil || !strings.Contains(err.Error(), "not a tag") {
+		t.Errorf("commit: expected not-a-tag error, got %v", err)
+	}
+
+	tests := map[string]struct{ body, want string }{
+		"missing object": {"type commit\ntag v1\n\nmsg\n", "missing object header"},
+		"out of order":   {"type commit\nobject abc\ntag v1\n\nmsg\n", "missing object header"},
+		"missing tag":    {"object abc\ntype commit\n\nmsg\n", "missing tag header"},
+		"bad tagger":     {"object abc\ntype commit\ntag v1\ntagger nobody\n\nmsg\n", "tagger"},
+	}
+	for name, tt := range tests {
+		_, err := ParseTag(tagObject(tt.body))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want error containing %q", name, err, tt.want)
+		}
+	}
+}
+
+func TestTag_BytesRoundTrip(t *testing.T) {
+	for _, body := range []string{
+		"object 1111111111111111111111111111111111111111\ntype commit\ntag v1.0.0\n" +
+			"tagger T Agger <tagger@example.com> 1700000000 +0900\n\nRelease 1.0.0\n",
+		"object 1111111111111111111111111111111111111111\ntype blob\ntag old\n\nOld tag\n",
+		"object 1111111111111111111111111111111111111111\ntype commit\ntag v2\n\nSigned\n" + testSig,
+	} {
+		tag, err := ParseTag(tagObject(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(tag.Bytes()); got != body {
+			t.Errorf("Bytes():\ngot  %q\nwant %q", got, body)
+		}
+	}
+}
+
+const testSig = "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n=abcd\n-----END PGP SIGNATURE-----\n"
+
+func TestSplitSignature(t *testing.T) {
+	head := "object 1111111111111111111111111111111111111111\ntype commit\ntag v2\n\n"
+	for _, tt := range []struct {
+		body, payload, sig string
+	}{
+		{head + "Unsigned\n", head + "Unsigned\n", ""},
+		{head + "Signed\n" + testSig, head + "Signed\n", testSig},
+		// Only a block at the start of a line counts, and the last one
+		// is the signature.
+		{head + "quoting -----BEGIN PGP SIGNATURE-----\n", head + "quoting -----BEGIN PGP SIGNATURE-----\n", ""},
+		{head + testSig + testSig, head + testSig, testSig},
+		{head + "ssh\n-----BEGIN SSH SIGNATURE-----\nU1NI\n", head + "ssh\n", "-----BEGIN SSH SIGNATURE-----\nU1NI\n"},
+	} {
+		payload, sig := SplitSignature([]byte(tt.body))
+		if string(payload) != tt.payload || string(sig) != tt.sig {
+			t.Errorf("SplitSignature(%q) = %q, %q; want %q, %q", tt.body, payload, sig, tt.payload, tt.sig)
+		}
+	}
+
+	tag, err := ParseTag(tagObject(head + "Signed\n" + testSig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Message != "Signed\n" || tag.Sig != testSig {
+		t.Errorf("ParseTag: Message %q, Sig %q", tag.Message, tag.Sig)
+	}
+}
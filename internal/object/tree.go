@@ -0,0 +1,162 @@
+package object
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Tree entry modes.
+const (
+	ModeTree       uint32 = 0040000
+	ModeFile       uint32 = 0100644
+	ModeExecutable uint32 = 0100755
+	ModeSymlink    uint32 = 0120000
+	ModeGitlink    uint32 = 0160000 // submodule commit
+)
+
+// TreeEntry is one entry of a tree object.
+type TreeEntry struct {
+	Mode uint32
+	Name string
+	Hash string
+}
+
+// Type returns the type of object the entry points to, derived from its
+// mode: subtrees are trees, submodules are commits, and regular files and
+// symlinks are blobs.
+func (e TreeEntry) Type() Type {
+	switch e.Mode {
+	case ModeTree:
+		return TypeTree
+	case ModeGitlink:
+		return TypeCommit
+	default:
+		return TypeBlob
+	}
+}
+
+// String formats the entry the way ls-tree does:
+// "<mode> <type> <hash>\t<name>".
+func (e TreeEntry) String() string {
+	return fmt.Sprintf("%06o %s %s\t%s", e.Mode, e.Type(), e.Hash, e.Name)
+}
+
+// ParseTree parses a SHA-1 tree object's body, a sequence of
+// "<octal mode> <name>\0<20-byte hash>" records.
+func ParseTree(body []byte) ([]TreeEntry, error) {
+	return ParseTreeWith(SHA1, body)
+}
+
+// ParseTreeWith is ParseTree for a tree whose entries hold hashes of the
+// given algorithm, 32 bytes each for SHA-256.
+func ParseTreeWith(algo Algorithm, body []byte) ([]TreeEntry, error) {
+	size := algo.Size()
+	var entries []TreeEntry
+	for len(body) > 0 {
+		sp := bytes.IndexByte(body, ' ')
+		if sp < 0 {
+			return nil, fmt.Errorf("malformed tree entry: missing mode")
+		}
+		mode, err := strconv.ParseUint(string(body[:sp]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed tree entry mode %q", body[:sp])
+		}
+		body = body[sp+1:]
+
+		nul := bytes.IndexByte(body, 0)
+		if nul < 0 {
+			return nil, fmt.Errorf("malformed tree entry: missing name terminator")
+		}
+		name := string(body[:nul])
+		body = body[nul+1:]
+
+		if len(body) < size {
+			return nil, fmt.Errorf("malformed tree entry %q: truncated hash", name)
+		}
+		entries = append(entries, TreeEntry{
+			Mode: uint32(mode),
+			Name: name,
+			Hash: hex.EncodeToString(body[:size]),
+		})
+		body = body[size:]
+	}
+	return entries, nil
+}
+
+// EncodeTree builds a tree object body from entries. Entries are sorted
+// the way git sorts them, comparing names as bytes with subtree names
+// treated as if they ended in "/", so the resulting hash matches git's.
+// The hashes must be all SHA-1 or all SHA-256.
+func EncodeTree(entries []TreeEntry) ([]byte, error) {
+	sorted := make([]TreeEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sortName(sorted[i]) < sortName(sorted[j])
+	})
+
+	var b bytes.Buffer
+	for _, e := range sorted {
+		raw, err := hex.DecodeString(e.Hash)
+		if err != nil || !isFullHash(e.Hash) || len(e.Hash) != len(sorted[0].Hash) {
+			return nil, fmt.Errorf("tree entry %q: invalid hash %q", e.Name, e.Hash)
+		}
+		fmt.Fprintf(&b, "%o %s\x00", e.Mode, e.Name)
+		b.Write(raw)
+	}
+	return b.Bytes(), nil
+}
+
+// sortName is the key git orders tree entries by.
+func sortName(e TreeEntry) string {
+	if e.Mode == ModeTree {
+		return e.Name + "/"
+	}
+	return e.Name
+}
+
+// ReadTree reads the tree object hash and returns its entries. It errors
+// if the hash names some other type of object.
+func ReadTree(gitDir, hash string) ([]TreeEntry, error) {
+	obj, err := Read(gitDir, hash)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Type != TypeTree {
+		return nil, fmt.Errorf("object %s is a %s, not a tree", obj.Hash, obj.Type)
+	}
+	entries, err := ParseTreeWith(algorithmFor(obj.Hash), obj.Body)
+	if err != nil {
+		return nil, corrupt(obj.Hash, err)
+	}
+	return entries, nil
+}
+
+// FlattenTree returns every non-tree entry reachable from the tree hash,
+// in the order ls-tree -r lists them, with Name set to the entry's
+// slash-separated path from the root.
+func FlattenTree(gitDir, hash string) ([]TreeEntry, error) {
+	var flat []TreeEntry
+	err := flattenTree(gitDir, hash, "", &flat)
+	return flat, err
+}
+
+func flattenTree(gitDir, hash, prefix string, flat *[]TreeEntry) error {
+	entries, err := ReadTree(gitDir, hash)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		e.Name = prefix + e.Name
+		if e.Mode == ModeTree {
+			if err := flattenTree(gitDir, e.Hash, e.Name+"/", flat); err != nil {
+				return err
+			}
+			continue
+		}
+		*flat = append(*flat, e)
+	}
+	return nil
+}
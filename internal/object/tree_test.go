@@ -0,0 +1,188 @@
+package object
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// treeBody builds a raw tree body from entries, in the order given.
+func treeBody(entries ...TreeEntry) []byte {
+	var b bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%o %s\x00", e.Mode, e.Name)
+		raw, _ := hex.DecodeString(e.Hash)
+		b.Write(raw)
+	}
+	return b.Bytes()
+}
+
+func TestParseTree(t *testing.T) {
+	want := []TreeEntry{
+		{ModeFile, "README.md", "ce013625030ba8dba906f756967f9e9ca394464a"},
+		{ModeSymlink, "link", "cc628ccd10742baea8241c5924df992b5c019f71"},
+		{ModeTree, "src", "4b825dc642cb6eb9a060e54bf8d69288fbee4904"},
+		{ModeGitlink, "vendor", strings.Repeat("ab", 20)},
+	}
+
+	got, err := ParseTree(treeBody(want...))
+	if err != nil {
+		t.Fatalf("ParseTree() error: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseTree_Malformed(t *testing.T) {
+	full := treeBody(TreeEntry{ModeFile, "a", "ce013625030ba8dba906f756967f9e9ca394464a"})
+	for _, body := range [][]byte{
+		[]byte("100644"),
+		[]byte("10x644 a\x00"),
+		[]byte("100644 a"),
+		full[:len(full)-1],
+	} {
+		if _, err := ParseTree(body); err == nil {
+			t.Errorf("ParseTree(%q): expected error", body)
+		}
+	}
+}
+
+func TestTreeEntry_String(t *testing.T) {
+	tests := []struct {
+		entry TreeEntry
+		want  string
+	}{
+		{TreeEntry{ModeTree, "src", "4b825dc642cb6eb9a060e54bf8d69288fbee4904"},
+			"040000 tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\tsrc"},
+		{TreeEntry{ModeExecutable, "run.sh", "ce013625030ba8dba906f756967f9e9ca394464a"},
+			"100755 blob ce013625030ba8dba906f756967f9e9ca394464a\trun.sh"},
+		{TreeEntry{ModeSymlink, "link", "ce013625030ba8dba906f756967f9e9ca394464a"},
+			"120000 blob ce013625030ba8dba906f756967f9e9ca394464a\tlink"},
+		{TreeEntry{ModeGitlink, "sub", "ce013625030ba8dba906f756967f9e9ca394464a"},
+			"160000 commit ce013625030ba8dba906f756967f9e9ca394464a\tsub"},
+	}
+	for _, tt := range tests {
+		if got := tt.entry.String(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestReadTree(t *testing.T) {
+	gitDir := testGitDir(t)
+	blob := writeTestBlob(t, gitDir, "hello\n")
+
+	body := treeBody(TreeEntry{ModeFile, "hello.txt", blob})
+	sha, full, _ := Hash(TypeTree, bytes.NewReader(body), int64(len(body)))
+	Write(gitDir, sha, full)
+
+	entries, err := ReadTree(gitDir, sha)
+	if err != nil {
+		t.Fatalf("ReadTree() error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "hello.txt" || entries[0].Hash != blob {
+		t.Errorf("got %+v", entries)
+	}
+
+	if _, err := ReadTree(gitDir, blob); err == nil || !strings.Contains(err.Error(), "not a tree") {
+		t.Errorf("ReadTree(blob): expected not-a-tree error, got %v", err)
+	}
+}
+
+func TestReadTree_SHA256(t *testing.T) {
+	gitDir := testGitDir(t)
+	blob := writeSHA256Blob(t, gitDir, "hello\n")
+
+	body, err := EncodeTree([]TreeEntry{{ModeFile, "hello.txt", blob}})
+	if err != nil {
+		t.Fatalf("EncodeTree() error: %v", err)
+	}
+	sha, err := WriteObjectWith(SHA256, gitDir, TypeTree, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// From git mktree in a SHA-256 repository.
+	if want := "c7187e8fdb691b3a692e5f3f0bbcb6359e5046285225f18f9773d4fe54268c55"; sha != want {
+		t.Errorf("tree = %s, want %s", sha, want)
+	}
+
+	entries, err := ReadTree(gitDir, sha)
+	if err != nil {
+		t.Fatalf("ReadTree() error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "hello.txt" || entries[0].Hash != blob {
+		t.Errorf("got %+v", entries)
+	}
+
+	mixed := []TreeEntry{{ModeFile, "a", blob}, {ModeFile, "b", "ce013625030ba8dba906f756967f9e9ca394464a"}}
+	if _, err := EncodeTree(mixed); err == nil {
+		t.Error("EncodeTree accepted a mix of SHA-1 and SHA-256 hashes")
+	}
+}
+
+func TestFlattenTree(t *testing.T) {
+	gitDir := testGitDir(t)
+	blob := writeTestBlob(t, gitDir, "hello\n")
+
+	writeTree := func(entries ...TreeEntry) string {
+		body, err := EncodeTree(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sha, err := WriteObject(gitDir, TypeTree, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha
+	}
+	inner := writeTree(TreeEntry{ModeFile, "c.txt", blob})
+	sub := writeTree(TreeEntry{ModeTree, "b", inner}, TreeEntry{ModeExecutable, "run", blob})
+	root := writeTree(TreeEntry{ModeTree, "a", sub}, TreeEntry{ModeFile, "a.txt", blob})
+
+	flat, err := FlattenTree(gitDir, root)
+	if err != nil {
+		t.Fatalf("FlattenTree() error: %v", err)
+	}
+	var got []string
+	for _, e := range flat {
+		got = append(got, fmt.Sprintf("%o %s", e.Mode, e.Name))
+	}
+	want := "100644 a.txt,100644 a/b/c.txt,100755 a/run"
+	if strings.Join(got, ",") != want {
+		t.Errorf("FlattenTree() = %v, want %s", got, want)
+	}
+}
+
+func TestEncodeTree_GitOrder(t *testing.T) {
+	hash := "ce013625030ba8dba906f756967f9e9ca394464a"
+	body, err := EncodeTree([]TreeEntry{
+		{ModeTree, "foo", hash},
+		{ModeFile, "foo.c", hash},
+		{ModeFile, "foo-bar", hash},
+	})
+	if err != nil {
+		t.Fatalf("EncodeTree() error: %v", err)
+	}
+	entries, _ := ParseTree(body)
+
+	// '-' < '.' < '/', and the subtree sorts as "foo/".
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	if got := strings.Join(names, " "); got != "foo-bar foo.c foo" {
+		t.Errorf("order: got %q", got)
+	}
+
+	if _, err := EncodeTree([]TreeEntry{{ModeFile, "bad", "xyz"}}); err == nil {
+		t.Error("expected error for invalid hash")
+	}
+}
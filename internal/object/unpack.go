@@ -0,0 +1,297 @@
+package object
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// unpackedEntry is one pack entry read by UnpackObjects. Whole objects
+// have objType set from the start; deltas get it once resolved.
+type unpackedEntry struct {
+	offset  uint64
+	ref     deltaRef
+	delta   []byte
+	objType Type
+	body    []byte
+	hash    string
+}
+
+// UnpackObjects reads a packfile from r and writes each object in it to
+// gitDir as a loose object, returning how many the pack held. The pack is
+// held in memory: its trailer checksum is verified and every delta
+// resolved before anything is written, so a bad pack leaves the object
+// store untouched. A REF_DELTA base missing from the pack is looked up in
+// the repository, as for a thin pack.
+//
+// As in git, objects the repository already has, loose or packed, are
+// skipped. known, if not nil, answers those checks, so the objects a
+// fetch brings in, which are mostly new, cost no lookup each; with nil,
+// each object is looked up.
+func UnpackObjects(gitDir string, r io.Reader, known *ExistenceFilter) (int, error) {
+	entries, err := readPack(gitDir, r)
+	if err != nil {
+		return 0, err
+	}
+
+	exists := func(hash string) error { return Exists(gitDir, hash) }
+	if known != nil {
+		exists = known.Exists
+	}
+	for _, e := range entries {
+		if exists(e.hash) == nil {
+			continue
+		}
+		if err := Write(gitDir, e.hash, []byte(Header(e.objType, int64(len(e.body)))+string(e.body))); err != nil {
+			return 0, fmt.Errorf("writing %s: %w", e.hash, err)
+		}
+	}
+	return len(entries), nil
+}
+
+// QuarantinePack reads a pushed pack from r, as UnpackObjects does, and
+// writes the objects the repository doesn't have into a new quarantine,
+// to be promoted once the push is accepted. Nothing is written unless
+// the pack passes the checks fsck would make of it: every object must be
+// well-formed for its type, and everything reachable from tips, the
+// pushed ref values, must be in the pack or already in the repository.
+// Objects the repository has are taken to be complete, as git does.
+func QuarantinePack(gitDir string, r io.Reader, tips []string) (*Quarantine, error) {
+	entries, err := readPack(gitDir, r)
+	if err != nil {
+		return nil, err
+	}
+	byHash := make(map[string]*unpackedEntry, len(entries))
+	for _, e := range entries {
+		obj := &Object{Type: e.objType, Size: int64(len(e.body)), Hash: e.hash, Body: e.body}
+		if err := obj.CheckStructure(); err != nil {
+			return nil, err
+		}
+		byHash[e.hash] = e
+	}
+	if err := checkConnected(gitDir, byHash, tips); err != nil {
+		return nil, err
+	}
+
+	q, err := NewQuarantine(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if Exists(gitDir, e.hash) == nil {
+			continue
+		}
+		if err := q.Write(e.hash, []byte(Header(e.objType, int64(len(e.body)))+string(e.body))); err != nil {
+			q.Discard()
+			return nil, fmt.Errorf("writing %s: %w", e.hash, err)
+		}
+	}
+	return q, nil
+}
+
+// checkConnected walks from tips through the objects in pack, and
+// reports the first object it reaches that is neither in pack nor in
+// the repository.
+func checkConnected(gitDir string, pack map[string]*unpackedEntry, tips []string) error {
+	seen := make(map[string]bool)
+	stack := append([]string(nil), tips...)
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		e := pack[hash]
+		if e == nil {
+			if err := Exists(gitDir, hash); err != nil {
+				return fmt.Errorf("pack is incomplete: %w", err)
+			}
+			continue
+		}
+
+		// The structure was checked already, so these parse.
+		obj := &Object{Type: e.objType, Hash: e.hash, Body: e.body}
+		switch e.objType {
+		case TypeCommit:
+			c, _ := ParseCommit(obj)
+			stack = append(append(stack, c.Tree), c.Parents...)
+		case TypeTree:
+			entries, _ := ParseTree(e.body)
+			for _, te := range entries {
+				if te.Mode != ModeGitlink {
+					stack = append(stack, te.Hash)
+				}
+			}
+		case TypeTag:
+			t, _ := ParseTag(obj)
+			stack = append(stack, t.Object)
+		}
+	}
+	return nil
+}
+
+// readPack reads a whole packfile from r and resolves its deltas, with
+// bases outside the pack taken from gitDir.
+func readPack(gitDir string, r io.Reader) ([]*unpackedEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading pack: %w", err)
+	}
+	entries, err := parsePackStream(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveUnpacked(gitDir, entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// minPackEntrySize is the smallest a pack entry can be: a one-byte header
+// and the eight-byte zlib stream of an empty object, as git writes for
+// the empty blob.
+const minPackEntrySize = 9
+
+// parsePackStream checks a whole packfile's trailer and header, then
+// inflates every entry in it.
+func parsePackStream(data []byte) ([]*unpackedEntry, error) {
+	if len(data) < 12+sha1.Size {
+		return nil, fmt.Errorf("pack is truncated (%d bytes)", len(data))
+	}
+	content, trailer := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if sum := sha1.Sum(content); !bytes.Equal(sum[:], trailer) {
+		return nil, fmt.Errorf("pack checksum mismatch: trailer says %x, contents hash to %x", trailer, sum)
+	}
+	if !bytes.Equal(content[:4], []byte("PACK")) {
+		return nil, fmt.Errorf("not a packfile")
+	}
+	if v := binary.BigEndian.Uint32(content[4:8]); v != 2 && v != 3 {
+		return nil, fmt.Errorf("unsupported pack version %d", v)
+	}
+	count := binary.BigEndian.Uint32(content[8:12])
+	// The count is only a claim until the entries are read, so it mustn't
+	// size an allocation beyond what the data could hold.
+	if uint64(count)*minPackEntrySize > uint64(len(content)-12) {
+		return nil, fmt.Errorf("pack claims %d objects but has only %d bytes of entries", count, len(content)-12)
+	}
+
+	rd := bytes.NewReader(content)
+	rd.Seek(12, io.SeekStart)
+	entries := make([]*unpackedEntry, 0, count)
+	for i := range count {
+		offset := uint64(rd.Size()) - uint64(rd.Len())
+		e, err := readUnpackedEntry(rd, offset)
+		if err != nil {
+			return nil, fmt.Errorf("pack entry %d at offset %d: %w", i, offset, err)
+		}
+		entries = append(entries, e)
+	}
+	if rd.Len() > 0 {
+		return nil, fmt.Errorf("pack has %d bytes of trailing data after %d objects", rd.Len(), count)
+	}
+	return entries, nil
+}
+
+// readUnpackedEntry decodes and inflates the entry at offset. rd must be
+// positioned there; a bytes.Reader lets zlib stop exactly at the end of
+// the entry's compressed data, leaving rd at the next one.
+func readUnpackedEntry(rd *bytes.Reader, offset uint64) (*unpackedEntry, error) {
+	code, size, err := readPackEntryHeader(rd)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := readDeltaRef(rd, code, offset)
+	if err != nil {
+		return nil, err
+	}
+	if MaxObjectSize > 0 && size > MaxObjectSize {
+		return nil, fmt.Errorf("%w: entry declares %d bytes", ErrTooLarge, size)
+	}
+
+	zr, err := zlib.NewReader(rd)
+	if err != nil {
+		return nil, fmt.Errorf("creating zlib reader: %w", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(zr, size+1))
+	if err != nil {
+		return nil, fmt.Errorf("inflating: %w", err)
+	}
+	if int64(len(data)) != size {
+		return nil, fmt.Errorf("entry declares %d bytes but inflates to %d", size, len(data))
+	}
+
+	e := &unpackedEntry{offset: offset, ref: ref}
+	if objType, ok := packTypes[code]; ok {
+		e.objType, e.body = objType, data
+		e.hash = HashBytes([]byte(Header(objType, size) + string(data)))
+	} else {
+		e.delta = data
+	}
+	return e, nil
+}
+
+// resolveUnpacked applies every delta in entries. Bases can come in any
+// order (a REF_DELTA may name an object later in the pack), so it makes
+// passes until nothing is left, falling back to the repository for
+// REF_DELTA bases the pack doesn't have. A pass that resolves nothing
+// means the remaining deltas form a cycle or name missing bases.
+func resolveUnpacked(gitDir string, entries []*unpackedEntry) error {
+	byOffset := make(map[uint64]*unpackedEntry, len(entries))
+	byHash := make(map[string]*unpackedEntry, len(entries))
+	var pending []*unpackedEntry
+	for _, e := range entries {
+		byOffset[e.offset] = e
+		if e.delta == nil {
+			byHash[e.hash] = e
+		} else {
+			pending = append(pending, e)
+		}
+	}
+
+	external := false
+	for len(pending) > 0 {
+		var next []*unpackedEntry
+		for _, e := range pending {
+			var base *unpackedEntry
+			if e.ref.baseHash == "" {
+				base = byOffset[e.ref.baseOffset]
+				if base == nil {
+					return fmt.Errorf("delta at offset %d: no entry at base offset %d", e.offset, e.ref.baseOffset)
+				}
+			} else if base = byHash[e.ref.baseHash]; base == nil && external {
+				obj, err := Read(gitDir, e.ref.baseHash)
+				if err != nil {
+					return fmt.Errorf("delta at offset %d: base %s: %w", e.offset, e.ref.baseHash, err)
+				}
+				base = &unpackedEntry{objType: obj.Type, body: obj.Body, hash: obj.Hash}
+			}
+			if base == nil || base.objType == "" {
+				next = append(next, e)
+				continue
+			}
+
+			body, err := applyDelta(base.body, e.delta)
+			if err != nil {
+				return fmt.Errorf("delta at offset %d: %w", e.offset, err)
+			}
+			e.objType, e.body, e.delta = base.objType, body, nil
+			e.hash = HashBytes([]byte(Header(e.objType, int64(len(body))) + string(body)))
+			byHash[e.hash] = e
+		}
+
+		if len(next) == len(pending) {
+			if external {
+				return fmt.Errorf("%d deltas have unresolvable bases (cycle in pack?)", len(next))
+			}
+			external = true
+		} else {
+			external = false
+		}
+		pending = next
+	}
+	return nil
+}
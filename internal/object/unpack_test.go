@@ -0,0 +1,276 @@
+package object
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// packStream assembles encoded entries into a complete packfile.
+func packStream(entries ...[]byte) []byte {
+	var pack bytes.Buffer
+	pack.WriteString("PACK")
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+	binary.Write(&pack, binary.BigEndian, uint32(len(entries)))
+	for _, e := range entries {
+		pack.Write(e)
+	}
+	sum := sha1.Sum(pack.Bytes())
+	pack.Write(sum[:])
+	return pack.Bytes()
+}
+
+func TestUnpackObjects_DeltaFixtures(t *testing.T) {
+	for _, name := range []string{"ofs-delta", "ref-delta"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name+".pack"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			gitDir := testGitDir(t)
+
+			n, err := UnpackObjects(gitDir, bytes.NewReader(data), nil)
+			if err != nil {
+				t.Fatalf("UnpackObjects() error: %v", err)
+			}
+			if n != 9 {
+				t.Errorf("UnpackObjects() = %d objects, want 9", n)
+			}
+
+			loose, err := ListObjects(gitDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(loose) != 9 {
+				t.Errorf("got %d loose objects, want 9", len(loose))
+			}
+			for _, h := range deltaFixtureBlobs {
+				if err := VerifyObject(gitDir, h); err != nil {
+					t.Errorf("VerifyObject(%s) error: %v", h[:7], err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnpackObjects_RoundTrip(t *testing.T) {
+	src := testGitDir(t)
+	var hashes []string
+	for _, body := range []string{"one\n", "two\n"} {
+		h, err := WriteObject(src, TypeBlob, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, h)
+	}
+	var pack bytes.Buffer
+	if _, err := WritePack(&pack, src, hashes); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := testGitDir(t)
+	if n, err := UnpackObjects(dst, &pack, nil); err != nil || n != 2 {
+		t.Fatalf("UnpackObjects() = %d, %v; want 2, nil", n, err)
+	}
+	for _, h := range hashes {
+		if err := VerifyObject(dst, h); err != nil {
+			t.Errorf("VerifyObject(%s) error: %v", h[:7], err)
+		}
+	}
+}
+
+func TestUnpackObjects_ThinPack(t *testing.T) {
+	gitDir := testGitDir(t)
+	baseHash, err := WriteObject(gitDir, TypeBlob, []byte("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseName, _ := hex.DecodeString(baseHash)
+	delta := []byte{11, 11, 0x90, 6, 5, 't', 'h', 'e', 'r', 'e'}
+
+	n, err := UnpackObjects(gitDir, bytes.NewReader(packStream(packEntryBytes(packRefDelta, baseName, delta))), nil)
+	if err != nil {
+		t.Fatalf("UnpackObjects() error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("UnpackObjects() = %d objects, want 1", n)
+	}
+	want := HashBytes([]byte(Header(TypeBlob, 11) + "hello there"))
+	obj, err := Read(gitDir, want)
+	if err != nil {
+		t.Fatalf("Read() unpacked delta: %v", err)
+	}
+	if string(obj.Body) != "hello there" {
+		t.Errorf("unpacked body = %q", obj.Body)
+	}
+}
+
+func TestUnpackObjects_Invalid(t *testing.T) {
+	blob := packEntryBytes(packBlob, nil, []byte("hello\n"))
+	badChecksum := packStream(blob)
+	badChecksum[len(badChecksum)-1] ^= 0xff
+	missingBase := packStream(packEntryBytes(packRefDelta, bytes.Repeat([]byte{0xab}, 20), []byte{1, 1, 1, 'x'}))
+	// The second entry is a delta against the first, but declares a
+	// different base size.
+	badDelta := packStream(blob, packEntryBytes(packOfsDelta, []byte{byte(len(blob))}, []byte{9, 1, 1, 'x'}))
+
+	tests := map[string][]byte{
+		"bad checksum":     badChecksum,
+		"overstated count": withPackCount(packStream(blob), 2),
+		"truncated":        []byte("PACK"),
+		"missing base":     missingBase,
+		"bad delta":        badDelta,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			gitDir := testGitDir(t)
+			if _, err := UnpackObjects(gitDir, bytes.NewReader(data), nil); err == nil {
+				t.Fatal("UnpackObjects() succeeded, want error")
+			}
+			loose, err := ListObjects(gitDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(loose) != 0 {
+				t.Errorf("failed unpack wrote %d objects", len(loose))
+			}
+		})
+	}
+}
+
+// withPackCount rewrites a pack's object count and recomputes its trailer.
+func withPackCount(pack []byte, count uint32) []byte {
+	content := bytes.Clone(pack[:len(pack)-sha1.Size])
+	binary.BigEndian.PutUint32(content[8:12], count)
+	sum := sha1.Sum(content)
+	return append(content, sum[:]...)
+}
+
+func TestUnpackObjects_HugeCount(t *testing.T) {
+	data := withPackCount(packStream(), 0xffffffff)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := UnpackObjects(testGitDir(t), bytes.NewReader(data), nil)
+	runtime.ReadMemStats(&after)
+	if err == nil {
+		t.Fatal("UnpackObjects() succeeded, want error")
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("UnpackObjects() allocated %d bytes for an empty pack", alloc)
+	}
+}
+
+func TestUnpackObjects_SkipsExisting(t *testing.T) {
+	for _, useFilter := range []bool{true, false} {
+		t.Run(map[bool]string{true: "filter", false: "lookup"}[useFilter], func(t *testing.T) {
+			gitDir := testGitDir(t)
+			var hashes []string
+			for _, body := range []string{"packed\n", "new\n"} {
+				h, err := WriteObject(gitDir, TypeBlob, []byte(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				hashes = append(hashes, h)
+			}
+			packed, fresh := hashes[0], hashes[1]
+
+			var pack bytes.Buffer
+			if _, err := WritePack(&pack, gitDir, hashes); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := SavePack(gitDir, []string{packed}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := PrunePacked(gitDir, false); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Remove(filepath.Join(gitDir, "objects", fresh[:2], fresh[2:])); err != nil {
+				t.Fatal(err)
+			}
+
+			var known *ExistenceFilter
+			if useFilter {
+				var err error
+				if known, err = NewExistenceFilter(gitDir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n, err := UnpackObjects(gitDir, &pack, known); err != nil || n != 2 {
+				t.Fatalf("UnpackObjects() = %d, %v; want 2, nil", n, err)
+			}
+
+			loose, err := ListObjects(gitDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(loose) != 1 || loose[0] != fresh {
+				t.Errorf("loose objects = %v, want only %s", loose, fresh[:7])
+			}
+		})
+	}
+}
+
+func TestQuarantinePack(t *testing.T) {
+	src := testGitDir(t)
+	blob := writeTestBlob(t, src, "hello\n")
+	treeBody, err := EncodeTree([]TreeEntry{{ModeFile, "hello", blob}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := WriteObject(src, TypeTree, treeBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := Signature{Name: "A", Email: "a@example.com", When: 1, Timezone: "+0000"}
+	commit, err := WriteObject(src, TypeCommit, (&Commit{Tree: tree, Author: sig, Committer: sig, Message: "m\n"}).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pack := func(hashes ...string) []byte {
+		var b bytes.Buffer
+		if _, err := WritePack(&b, src, hashes); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+
+	// The blob is already there, so only the tree and commit are
+	// quarantined, and they stay out of sight until promoted.
+	dst := testGitDir(t)
+	writeTestBlob(t, dst, "hello\n")
+	q, err := QuarantinePack(dst, bytes.NewReader(pack(commit, tree, blob)), []string{commit})
+	if err != nil {
+		t.Fatalf("QuarantinePack() error: %v", err)
+	}
+	if err := Exists(dst, commit); err == nil {
+		t.Error("quarantined commit visible before Promote")
+	}
+	if err := q.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []string{commit, tree, blob} {
+		if err := VerifyObject(dst, h); err != nil {
+			t.Errorf("VerifyObject(%s) error: %v", h[:7], err)
+		}
+	}
+
+	// A pack missing the blob its tree needs is refused outright.
+	dst = testGitDir(t)
+	if _, err := QuarantinePack(dst, bytes.NewReader(pack(commit, tree)), []string{commit}); err == nil {
+		t.Error("QuarantinePack() accepted a pack missing a blob")
+	}
+	// So is one with a malformed commit.
+	broken := packStream(packEntryBytes(packCommit, nil, []byte("tree nonsense\n\nm\n")))
+	if _, err := QuarantinePack(dst, bytes.NewReader(broken), nil); err == nil {
+		t.Error("QuarantinePack() accepted a malformed commit")
+	}
+	if dirs, _ := filepath.Glob(filepath.Join(dst, "objects", quarantinePrefix+"*")); len(dirs) > 0 {
+		t.Errorf("refused packs left quarantines behind: %v", dirs)
+	}
+}
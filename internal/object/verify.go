@@ -0,0 +1,84 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrHashMismatch is returned by VerifyObject when an object's content
+// doesn't hash to the name it is stored under.
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// VerifyObject reads the object named by hash, recomputes its hash from
+// the decompressed header and body, and checks it against the name the
+// object is stored under. A loose file that fails to inflate is reported
+// as ErrCorrupt; content that hashes to a different name as
+// ErrHashMismatch.
+func VerifyObject(gitDir, hash string) error {
+	loc, err := locate(gitDir, hash)
+	if err != nil {
+		return err
+	}
+
+	var raw []byte
+	if loc.packed() {
+		o, err := readPacked(loc.pack)
+		if err != nil {
+			return err
+		}
+		raw = append([]byte(Header(o.Type, o.Size)), o.Body...)
+	} else {
+		compressed, err := os.ReadFile(loc.loosePath)
+		if err != nil {
+			return fmt.Errorf("reading object file: %w", err)
+		}
+		if raw, err = decompress(compressed); err != nil {
+			return corrupt(loc.hash, err)
+		}
+	}
+
+	if got := HashBytesWith(algorithmFor(loc.hash), raw); got != loc.hash {
+		return fmt.Errorf("object %s: %w: content hashes to %s", loc.hash, ErrHashMismatch, got)
+	}
+	return nil
+}
+
+// CheckStructure reports whether the object's body is well-formed for its
+// type: a tree must parse into entries, and a commit or tag must carry
+// its required headers. A blob can hold anything.
+func (o *Object) CheckStructure() error {
+	var err error
+	switch o.Type {
+	case TypeTree:
+		if _, err = ParseTreeWith(algorithmFor(o.Hash), o.Body); err != nil {
+			err = fmt.Errorf("tree %s: %w", o.Hash, err)
+		}
+	case TypeCommit:
+		_, err = ParseCommit(o)
+	case TypeTag:
+		_, err = ParseTag(o)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	return nil
+}
+
+// VerifyLoose runs VerifyObject on every loose object and returns one
+// error per object that fails, in hash order. The second result is set
+// only if the object directories can't be listed.
+func VerifyLoose(gitDir string) ([]error, error) {
+	hashes, err := ListObjects(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []error
+	for _, h := range hashes {
+		if err := VerifyObject(gitDir, h); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return problems, nil
+}
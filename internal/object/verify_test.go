@@ -0,0 +1,109 @@
+package object
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyObject(t *testing.T) {
+	gitDir := testGitDir(t)
+	good := writeTestBlob(t, gitDir, "hello\n")
+	sha256 := writeSHA256Blob(t, gitDir, "hello\n")
+	packed, _ := writeTestPack(t, gitDir, []testObject{{TypeBlob, []byte("packed\n")}})
+
+	for _, h := range []string{good, sha256, packed[0]} {
+		if err := VerifyObject(gitDir, h); err != nil {
+			t.Errorf("VerifyObject(%s) error: %v", h, err)
+		}
+	}
+
+	// Content stored under the wrong name.
+	wrong := strings.Repeat("ab", 20)
+	if err := Write(gitDir, wrong, []byte("blob 6\x00hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyObject(gitDir, wrong); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("mismatched object: got %v, want ErrHashMismatch", err)
+	}
+
+	// A file that isn't zlib data at all.
+	garbage := strings.Repeat("cd", 20)
+	writeLooseFile(t, gitDir, garbage, []byte("not zlib"))
+	if err := VerifyObject(gitDir, garbage); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("garbage object: got %v, want ErrCorrupt", err)
+	}
+}
+
+func TestVerifyLoose(t *testing.T) {
+	gitDir := testGitDir(t)
+	writeTestBlob(t, gitDir, "hello\n")
+
+	problems, err := VerifyLoose(gitDir)
+	if err != nil {
+		t.Fatalf("VerifyLoose() error: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("clean repository: got %v", problems)
+	}
+
+	wrong := strings.Repeat("ab", 20)
+	Write(gitDir, wrong, []byte("blob 6\x00hello\n"))
+	garbage := strings.Repeat("cd", 20)
+	writeLooseFile(t, gitDir, garbage, []byte("not zlib"))
+
+	problems, err = VerifyLoose(gitDir)
+	if err != nil {
+		t.Fatalf("VerifyLoose() error: %v", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("got %d problems, want 2: %v", len(problems), problems)
+	}
+	if !errors.Is(problems[0], ErrHashMismatch) || !errors.Is(problems[1], ErrCorrupt) {
+		t.Errorf("problems out of order or misclassified: %v", problems)
+	}
+}
+
+// writeLooseFile stores data verbatim as the loose object file for hash.
+func writeLooseFile(t *testing.T, gitDir, hash string, data []byte) {
+	t.Helper()
+	dir := filepath.Join(gitDir, "objects", hash[:2])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, hash[2:]), data, 0444); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckStructure(t *testing.T) {
+	commit := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"author A <a@example.com> 1 +0000\ncommitter A <a@example.com> 1 +0000\n\nmsg\n"
+	tag := "object 4b825dc642cb6eb9a060e54bf8d69288fbee4904\ntype tree\ntag v1\n\nmsg\n"
+	tests := []struct {
+		objType Type
+		body    string
+		ok      bool
+	}{
+		{TypeBlob, "anything\x00at all", true},
+		{TypeTree, "", true},
+		{TypeTree, "junk", false},
+		{TypeCommit, commit, true},
+		{TypeCommit, "junk\n", false},
+		{TypeCommit, "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n\nno author\n", false},
+		{TypeTag, tag, true},
+		{TypeTag, "tag v1\n\nmsg\n", false},
+	}
+	for _, tt := range tests {
+		o := &Object{Type: tt.objType, Size: int64(len(tt.body)), Body: []byte(tt.body)}
+		err := o.CheckStructure()
+		if tt.ok && err != nil {
+			t.Errorf("%s %q: %v", tt.objType, tt.body, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s %q: got %v, want ErrCorrupt", tt.objType, tt.body, err)
+		}
+	}
+}
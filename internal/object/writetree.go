@@ -0,0 +1,91 @@
+package object
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteDirTree snapshots the directory dir into the object store: a blob
+// for every file and a tree for every directory. It returns the hash of
+// the root tree. Modes come from the filesystem (100755 for executables,
+// 120000 for symlinks, 100644 otherwise). As in git, .git directories and
+// directories with nothing to track are left out.
+func WriteDirTree(gitDir, dir string) (string, error) {
+	return WriteDirTreeWith(SHA1, gitDir, dir)
+}
+
+// WriteDirTreeWith is WriteDirTree using the given algorithm.
+func WriteDirTreeWith(algo Algorithm, gitDir, dir string) (string, error) {
+	sha, _, err := writeDirTree(algo, gitDir, dir)
+	return sha, err
+}
+
+// writeDirTree writes the tree for dir and reports whether it has any
+// entries.
+func writeDirTree(algo Algorithm, gitDir, dir string) (string, bool, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false, fmt.Errorf("reading %s: %w", dir, err)
+	}
+
+	var entries []TreeEntry
+	for _, d := range dirEntries {
+		path := filepath.Join(dir, d.Name())
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", false, err
+		}
+
+		var entry TreeEntry
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if d.Name() == ".git" {
+				continue
+			}
+			sha, nonEmpty, err := writeDirTree(algo, gitDir, path)
+			if err != nil {
+				return "", false, err
+			}
+			if !nonEmpty {
+				continue
+			}
+			entry = TreeEntry{Mode: ModeTree, Hash: sha}
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return "", false, err
+			}
+			sha, err := WriteObjectWith(algo, gitDir, TypeBlob, []byte(target))
+			if err != nil {
+				return "", false, err
+			}
+			entry = TreeEntry{Mode: ModeSymlink, Hash: sha}
+		case mode.IsRegular():
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return "", false, err
+			}
+			sha, err := WriteObjectWith(algo, gitDir, TypeBlob, data)
+			if err != nil {
+				return "", false, err
+			}
+			entry = TreeEntry{Mode: ModeFile, Hash: sha}
+			if mode&0111 != 0 {
+				entry.Mode = ModeExecutable
+			}
+		default:
+			// Sockets, FIFOs, and devices can't be stored.
+			continue
+		}
+		entry.Name = d.Name()
+		entries = append(entries, entry)
+	}
+
+	body, err := EncodeTree(entries)
+	if err != nil {
+		return "", false, err
+	}
+	sha, err := WriteObjectWith(algo, gitDir, TypeTree, body)
+	return sha, len(entries) > 0, err
+}
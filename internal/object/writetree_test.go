@@ -0,0 +1,59 @@
+package object
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteDirTree_MatchesGit(t *testing.T) {
+	gitDir := testGitDir(t)
+	dir := filepath.Dir(gitDir)
+
+	files := map[string]string{
+		"a.txt":       "a\n",
+		"src/foo.c":   "c\n",
+		"src/foo/x":   "x\n",
+		"src/foo-bar": "d\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "run.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.txt", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	// Empty directories aren't tracked.
+	os.MkdirAll(filepath.Join(dir, "empty", "deeper"), 0755)
+
+	sha, err := WriteDirTree(gitDir, dir)
+	if err != nil {
+		t.Fatalf("WriteDirTree() error: %v", err)
+	}
+
+	// Hash produced by `git add -A && git write-tree` on the same layout.
+	if want := "f27cabcd35e569e0abd6f26e84fb10fc0077a89f"; sha != want {
+		t.Errorf("root tree: got %s, want %s", sha, want)
+	}
+
+	entries, err := ReadTree(gitDir, sha)
+	if err != nil {
+		t.Fatalf("ReadTree(root) error: %v", err)
+	}
+	modes := make(map[string]uint32)
+	for _, e := range entries {
+		modes[e.Name] = e.Mode
+	}
+	if modes["run.sh"] != ModeExecutable || modes["link"] != ModeSymlink || modes["src"] != ModeTree {
+		t.Errorf("modes: got %v", modes)
+	}
+	if _, ok := modes[".git"]; ok {
+		t.Error(".git should not be included")
+	}
+}
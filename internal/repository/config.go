@@ -0,0 +1,423 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// Config is a parsed git config file. Lines are kept as read, so
+// comments and formatting survive when the file is written back.
+type Config struct {
+	lines []configLine
+}
+
+// configLine is one line of a config file. Section headers set section
+// and subsection; variable lines also set key and value. Blank and
+// comment lines only have raw.
+type configLine struct {
+	raw        string
+	section    string // lowercased
+	subsection string // case-sensitive, "" if none
+	key        string // lowercased, "" for non-variable lines
+	value      string
+	header     bool
+}
+
+// ParseConfig reads <gitDir>/config. A missing file yields an empty
+// Config.
+func ParseConfig(gitDir string) (*Config, error) {
+	data, err := os.ReadFile(filepath.Join(gitDir, "config"))
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	return parseConfig(string(data))
+}
+
+// parseConfig parses config file text.
+func parseConfig(text string) (*Config, error) {
+	c := &Config{}
+	var section, subsection string
+
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return c, nil
+	}
+	for i, raw := range strings.Split(text, "\n") {
+		line := configLine{raw: raw, section: section, subsection: subsection}
+		trimmed := strings.TrimSpace(raw)
+
+		switch {
+		case trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';':
+		case trimmed[0] == '[':
+			var err error
+			section, subsection, err = parseSectionHeader(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("config line %d: %w", i+1, err)
+			}
+			line.section, line.subsection, line.header = section, subsection, true
+		default:
+			if section == "" {
+				return nil, fmt.Errorf("config line %d: variable outside any section", i+1)
+			}
+			key, value, err := parseVariable(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("config line %d: %w", i+1, err)
+			}
+			line.key, line.value = key, value
+		}
+		c.lines = append(c.lines, line)
+	}
+	return c, nil
+}
+
+// parseSectionHeader parses `[section]`, `[section "subsection"]`, or the
+// legacy `[section.subsection]` form.
+func parseSectionHeader(s string) (string, string, error) {
+	end := strings.LastIndexByte(s, ']')
+	if end < 0 {
+		return "", "", fmt.Errorf("unterminated section header %q", s)
+	}
+	if rest := strings.TrimSpace(s[end+1:]); rest != "" && rest[0] != '#' && rest[0] != ';' {
+		return "", "", fmt.Errorf("unexpected text after section header %q", s)
+	}
+	inner := strings.TrimSpace(s[1:end])
+
+	name, sub, quoted := strings.Cut(inner, " ")
+	if quoted {
+		sub = strings.TrimSpace(sub)
+		if len(sub) < 2 || sub[0] != '"' || sub[len(sub)-1] != '"' {
+			return "", "", fmt.Errorf("malformed subsection in %q", s)
+		}
+		sub = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(sub[1 : len(sub)-1])
+	} else {
+		// Legacy form; git lowercases the subsection here.
+		name, sub, _ = strings.Cut(inner, ".")
+		sub = strings.ToLower(sub)
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("empty section name in %q", s)
+	}
+	return strings.ToLower(name), sub, nil
+}
+
+// parseVariable parses `key = value` or a bare `key`, which means true.
+// Values may be double-quoted, support \" \\ \n \t escapes, and end at an
+// unquoted # or ;.
+func parseVariable(s string) (string, string, error) {
+	key, rawValue, hasValue := strings.Cut(s, "=")
+	if !hasValue {
+		if i := strings.IndexAny(key, "#;"); i >= 0 {
+			key = key[:i]
+		}
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", "", fmt.Errorf("missing variable name in %q", s)
+	}
+	for _, r := range key {
+		if !(r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return "", "", fmt.Errorf("invalid variable name %q", key)
+		}
+	}
+	key = strings.ToLower(key)
+	if !hasValue {
+		return key, "true", nil
+	}
+
+	var b strings.Builder
+	inQuote := false
+	pendingSpace := ""
+	value := strings.TrimSpace(rawValue)
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+			continue
+		case c == '\\':
+			if i+1 >= len(value) {
+				return "", "", fmt.Errorf("trailing backslash in %q", s)
+			}
+			i++
+			b.WriteString(pendingSpace)
+			pendingSpace = ""
+			switch value[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\':
+				b.WriteByte(value[i])
+			default:
+				return "", "", fmt.Errorf("invalid escape \\%c in %q", value[i], s)
+			}
+			continue
+		case !inQuote && (c == '#' || c == ';'):
+			i = len(value)
+			continue
+		case !inQuote && (c == ' ' || c == '\t'):
+			// Unquoted whitespace only counts if more value follows.
+			pendingSpace += string(c)
+			continue
+		}
+		b.WriteString(pendingSpace)
+		pendingSpace = ""
+		b.WriteByte(c)
+	}
+	if inQuote {
+		return "", "", fmt.Errorf("unterminated quote in %q", s)
+	}
+	return key, b.String(), nil
+}
+
+// splitSection splits "remote.origin" into its section and subsection.
+// Only the section name is case-insensitive.
+func splitSection(section string) (string, string) {
+	name, sub, _ := strings.Cut(section, ".")
+	return strings.ToLower(name), sub
+}
+
+// Get returns the value of key in section, where section is a plain name
+// ("core") or a section and subsection joined by a dot
+// ("remote.origin"). If the key is set more than once, the last value
+// wins, as in git.
+func (c *Config) Get(section, key string) (string, bool) {
+	name, sub := splitSection(section)
+	key = strings.ToLower(key)
+
+	value, found := "", false
+	for _, l := range c.lines {
+		if l.key == key && l.section == name && l.subsection == sub {
+			value, found = l.value, true
+		}
+	}
+	return value, found
+}
+
+// GetAll returns every value of key in section, in file order.
+func (c *Config) GetAll(section, key string) []string {
+	name, sub := splitSection(section)
+	key = strings.ToLower(key)
+
+	var values []string
+	for _, l := range c.lines {
+		if l.key == key && l.section == name && l.subsection == sub {
+			values = append(values, l.value)
+		}
+	}
+	return values
+}
+
+// GetBool returns key as a boolean, accepting git's spellings: true, yes,
+// on, and 1; false, no, off, 0, and the empty string.
+func (c *Config) GetBool(section, key string) (value, found bool, err error) {
+	s, ok := c.Get(section, key)
+	if !ok {
+		return false, false, nil
+	}
+	switch strings.ToLower(s) {
+	case "true", "yes", "on", "1":
+		return true, true, nil
+	case "false", "no", "off", "0", "":
+		return false, true, nil
+	}
+	return false, true, fmt.Errorf("bad boolean config value %q for %s.%s", s, section, key)
+}
+
+// GetInt returns key as an integer. Like git, it accepts a k, m, or g
+// suffix for multiples of 1024.
+func (c *Config) GetInt(section, key string) (value int64, found bool, err error) {
+	s, ok := c.Get(section, key)
+	if !ok {
+		return 0, false, nil
+	}
+
+	digits, mult := s, int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			mult = 1 << 10
+		case 'm', 'M':
+			mult = 1 << 20
+		case 'g', 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			digits = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, true, fmt.Errorf("bad numeric config value %q for %s.%s", s, section, key)
+	}
+	return n * mult, true, nil
+}
+
+// ParseKey splits a dotted name like "remote.origin.url" into the section
+// Get and Set take ("remote.origin") and the key ("url"). The section is
+// everything before the first dot and the key everything after the last,
+// so a subsection may itself contain dots.
+func ParseKey(name string) (section, key string, err error) {
+	i := strings.LastIndexByte(name, '.')
+	if i <= 0 || i == len(name)-1 {
+		return "", "", fmt.Errorf("key %q does not contain a section and a name", name)
+	}
+	section, key = name[:i], name[i+1:]
+	sectionName, _, _ := strings.Cut(section, ".")
+	if sectionName == "" {
+		return "", "", fmt.Errorf("key %q does not contain a section and a name", name)
+	}
+	for _, r := range sectionName {
+		if !(r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return "", "", fmt.Errorf("invalid section name in key %q", name)
+		}
+	}
+	for i, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && (r == '-' || r >= '0' && r <= '9')) {
+			return "", "", fmt.Errorf("invalid key name %q", name)
+		}
+	}
+	return section, key, nil
+}
+
+// Set sets key in section to value. An existing setting is replaced in
+// place (the last one, if the key is set more than once); otherwise the
+// key is added at the end of the section, which is created if needed.
+// Everything else in the file is left as it was.
+func (c *Config) Set(section, key, value string) {
+	name, sub := splitSection(section)
+	line := configLine{
+		raw:     "\t" + key + " = " + quoteValue(value),
+		section: name, subsection: sub,
+		key: strings.ToLower(key), value: value,
+	}
+
+	last, existing := -1, -1
+	for i, l := range c.lines {
+		if l.section != name || l.subsection != sub {
+			continue
+		}
+		if l.key == line.key {
+			existing = i
+		}
+		if l.header || l.key != "" {
+			last = i
+		}
+	}
+	if existing >= 0 {
+		c.lines[existing] = line
+		return
+	}
+	if last < 0 {
+		header := "[" + name + "]"
+		if _, _, ok := strings.Cut(section, "."); ok {
+			header = "[" + name + ` "` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(sub) + `"]`
+		}
+		c.lines = append(c.lines, configLine{raw: header, section: name, subsection: sub, header: true}, line)
+		return
+	}
+	c.lines = append(c.lines[:last+1], append([]configLine{line}, c.lines[last+1:]...)...)
+}
+
+// Unset removes every setting of key in section, reporting whether there
+// were any. As in git, a header left with nothing under it, not even a
+// comment, goes too.
+func (c *Config) Unset(section, key string) bool {
+	name, sub := splitSection(section)
+	key = strings.ToLower(key)
+	match := func(l configLine) bool { return l.section == name && l.subsection == sub }
+
+	var kept []configLine
+	for _, l := range c.lines {
+		if l.key != key || !match(l) {
+			kept = append(kept, l)
+		}
+	}
+	if len(kept) == len(c.lines) {
+		return false
+	}
+
+	c.lines = kept[:0]
+	for i, l := range kept {
+		if l.header && match(l) && (i+1 == len(kept) || kept[i+1].header && !match(kept[i+1])) {
+			continue
+		}
+		c.lines = append(c.lines, l)
+	}
+	return true
+}
+
+// quoteValue formats value for a config file, quoting it if whitespace at
+// either end or a comment character would otherwise be lost.
+func quoteValue(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(value)
+	if value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;") {
+		return `"` + escaped + `"`
+	}
+	return escaped
+}
+
+// Bytes returns the config file text, including the lines Set and Unset
+// left alone exactly as they were read.
+func (c *Config) Bytes() []byte {
+	var b strings.Builder
+	for _, l := range c.lines {
+		b.WriteString(l.raw)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+// WriteConfig writes c to <gitDir>/config. It goes through config.lock,
+// as in git, so a concurrent writer fails instead of losing an update and
+// readers never see a half-written file.
+func WriteConfig(gitDir string, c *Config) error {
+	path := filepath.Join(gitDir, "config")
+	lock := path + ".lock"
+	f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("locking config: %w", err)
+	}
+	if _, err := f.Write(c.Bytes()); err != nil {
+		f.Close()
+		os.Remove(lock)
+		return fmt.Errorf("writing config: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(lock)
+		return fmt.Errorf("writing config: %w", err)
+	}
+	if err := os.Rename(lock, path); err != nil {
+		os.Remove(lock)
+		return fmt.Errorf("writing config: %w", err)
+	}
+	return nil
+}
+
+// ObjectFormat returns the hash algorithm the repository at gitDir uses
+// for its objects. extensions.objectFormat is only honored when
+// core.repositoryformatversion is 1, as git requires; otherwise the
+// repository is SHA-1.
+func ObjectFormat(gitDir string) (object.Algorithm, error) {
+	cfg, err := ParseConfig(gitDir)
+	if err != nil {
+		return object.SHA1, err
+	}
+	version, _, err := cfg.GetInt("core", "repositoryformatversion")
+	if err != nil {
+		return object.SHA1, err
+	}
+	if version < 1 {
+		return object.SHA1, nil
+	}
+	name, _ := cfg.Get("extensions", "objectformat")
+	return object.ParseAlgorithm(strings.ToLower(name))
+}
@@ -0,0 +1,292 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+const testConfig = `# leading comment
+[core]
+	repositoryformatversion = 0
+	bare = false ; inline comment
+	filemode # bare key
+	bigFileThreshold = 512m
+[remote "origin"]
+	url = https://example.com/repo.git # trailing comment
+	fetch = +refs/heads/*:refs/remotes/origin/*
+[user]
+	name = "A U Thor"
+	email = author@example.com
+	quoted = "has # hash; and semicolon"
+	escaped = tab\there
+[branch.Main]
+	remote = origin
+[core]
+	bare = true
+`
+
+func TestParseConfig(t *testing.T) {
+	gitDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(gitDir, "config"), []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ParseConfig(gitDir)
+	if err != nil {
+		t.Fatalf("ParseConfig() error: %v", err)
+	}
+
+	tests := []struct {
+		section, key, want string
+	}{
+		{"core", "repositoryformatversion", "0"},
+		{"CORE", "RepositoryFormatVersion", "0"},
+		{"core", "filemode", "true"},
+		{"core", "bare", "true"}, // the later section wins
+		{"remote.origin", "url", "https://example.com/repo.git"},
+		{"remote.origin", "fetch", "+refs/heads/*:refs/remotes/origin/*"},
+		{"user", "name", "A U Thor"},
+		{"user", "quoted", "has # hash; and semicolon"},
+		{"user", "escaped", "tab\there"},
+		{"branch.main", "remote", "origin"},
+	}
+	for _, tt := range tests {
+		got, ok := cfg.Get(tt.section, tt.key)
+		if !ok || got != tt.want {
+			t.Errorf("Get(%q, %q) = %q, %v; want %q", tt.section, tt.key, got, ok, tt.want)
+		}
+	}
+
+	if _, ok := cfg.Get("remote.ORIGIN", "url"); ok {
+		t.Error("subsections should be case-sensitive")
+	}
+	if _, ok := cfg.Get("user", "missing"); ok {
+		t.Error("expected missing key to be unset")
+	}
+}
+
+func TestConfig_TypedValues(t *testing.T) {
+	cfg, err := parseConfig(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok, err := cfg.GetBool("core", "bare"); err != nil || !ok || !v {
+		t.Errorf("GetBool(core.bare) = %v, %v, %v", v, ok, err)
+	}
+	if v, ok, err := cfg.GetBool("core", "filemode"); err != nil || !ok || !v {
+		t.Errorf("GetBool(core.filemode) = %v, %v, %v", v, ok, err)
+	}
+	if _, _, err := cfg.GetBool("user", "name"); err == nil {
+		t.Error("GetBool on a non-boolean: expected error")
+	}
+
+	if v, ok, err := cfg.GetInt("core", "bigfilethreshold"); err != nil || !ok || v != 512<<20 {
+		t.Errorf("GetInt(core.bigFileThreshold) = %d, %v, %v", v, ok, err)
+	}
+	if v, ok, err := cfg.GetInt("core", "repositoryformatversion"); err != nil || !ok || v != 0 {
+		t.Errorf("GetInt(core.repositoryformatversion) = %d, %v, %v", v, ok, err)
+	}
+	if _, _, err := cfg.GetInt("user", "email"); err == nil {
+		t.Error("GetInt on a non-number: expected error")
+	}
+}
+
+func TestParseConfig_Missing(t *testing.T) {
+	cfg, err := ParseConfig(t.TempDir())
+	if err != nil {
+		t.Fatalf("ParseConfig() on missing file: %v", err)
+	}
+	if _, ok := cfg.Get("core", "bare"); ok {
+		t.Error("expected empty config")
+	}
+}
+
+func TestParseConfig_Malformed(t *testing.T) {
+	for _, text := range []string{
+		"key = outside\n",
+		"[core\n",
+		"[remote origin]\n",
+		"[core]\n\tbad key = 1\n",
+		"[core]\n\tkey = \"unterminated\n",
+		"[core]\n\tkey = bad\\qescape\n",
+	} {
+		if _, err := parseConfig(text); err == nil {
+			t.Errorf("parseConfig(%q): expected error", text)
+		}
+	}
+}
+
+func TestParseConfig_InitConfig(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ParseConfig(repo.GitDir)
+	if err != nil {
+		t.Fatalf("ParseConfig() on init config: %v", err)
+	}
+	if bare, _, _ := cfg.GetBool("core", "bare"); bare {
+		t.Error("init config: core.bare should be false")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name, section, key string
+	}{
+		{"core.bare", "core", "bare"},
+		{"remote.origin.url", "remote.origin", "url"},
+		{"branch.feature.v2.merge", "branch.feature.v2", "merge"},
+	}
+	for _, tt := range tests {
+		section, key, err := ParseKey(tt.name)
+		if err != nil || section != tt.section || key != tt.key {
+			t.Errorf("ParseKey(%q) = %q, %q, %v; want %q, %q", tt.name, section, key, err, tt.section, tt.key)
+		}
+	}
+	for _, name := range []string{"nodot", ".key", "core.", "co re.key", "core.bad_key", "core.9lives", ".sub.key"} {
+		if _, _, err := ParseKey(name); err == nil {
+			t.Errorf("ParseKey(%q): expected error", name)
+		}
+	}
+}
+
+func TestConfig_Set(t *testing.T) {
+	cfg, err := parseConfig(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Set("remote.origin", "url", "https://example.com/new.git")
+	cfg.Set("remote.origin", "pushurl", "ssh://example.com/new.git")
+	cfg.Set("core", "bare", "false")
+	cfg.Set("user", "note", " padded; ")
+	cfg.Set("remote.My \"Fork\"", "url", "x")
+
+	text := string(cfg.Bytes())
+	for _, want := range []string{
+		"# leading comment\n",
+		"\tbare = false ; inline comment\n", // only the last setting is replaced
+		"\turl = https://example.com/new.git\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n\tpushurl = ssh://example.com/new.git\n[user]",
+		"\tnote = \" padded; \"\n[branch.Main]",
+		"[remote \"My \\\"Fork\\\"\"]\n\turl = x\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Set() output missing %q:\n%s", want, text)
+		}
+	}
+
+	// What Set writes must read back the same.
+	reparsed, err := parseConfig(text)
+	if err != nil {
+		t.Fatalf("parseConfig() after Set: %v", err)
+	}
+	for _, tt := range []struct{ section, key, want string }{
+		{"remote.origin", "url", "https://example.com/new.git"},
+		{"core", "bare", "false"},
+		{"user", "note", " padded; "},
+		{"remote.My \"Fork\"", "url", "x"},
+	} {
+		if got, ok := reparsed.Get(tt.section, tt.key); !ok || got != tt.want {
+			t.Errorf("Get(%q, %q) = %q, %v; want %q", tt.section, tt.key, got, ok, tt.want)
+		}
+	}
+}
+
+func TestConfig_Unset(t *testing.T) {
+	cfg, err := parseConfig(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Unset("core", "bare") {
+		t.Error("Unset(core.bare) = false, want true")
+	}
+	if got := cfg.GetAll("core", "bare"); len(got) != 0 {
+		t.Errorf("core.bare still set to %q", got)
+	}
+	if cfg.Unset("core", "bare") {
+		t.Error("second Unset(core.bare) = true, want false")
+	}
+
+	// The second [core] section is now empty, so its header goes; the
+	// first keeps its other keys.
+	text := string(cfg.Bytes())
+	if strings.Count(text, "[core]") != 1 {
+		t.Errorf("expected one [core] header left:\n%s", text)
+	}
+	cfg.Unset("branch.main", "remote")
+	if strings.Contains(string(cfg.Bytes()), "[branch") {
+		t.Errorf("empty [branch.Main] header kept:\n%s", cfg.Bytes())
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ParseConfig(repo.GitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Set("user", "name", "A U Thor")
+	if err := WriteConfig(repo.GitDir, cfg); err != nil {
+		t.Fatalf("WriteConfig() error: %v", err)
+	}
+	reread, err := ParseConfig(repo.GitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reread.Get("user", "name"); got != "A U Thor" {
+		t.Errorf("user.name = %q after WriteConfig", got)
+	}
+	if _, ok := reread.Get("core", "repositoryformatversion"); !ok {
+		t.Error("WriteConfig dropped the existing settings")
+	}
+
+	// A leftover lock means another writer is active.
+	lock := filepath.Join(repo.GitDir, "config.lock")
+	if err := os.WriteFile(lock, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteConfig(repo.GitDir, cfg); err == nil {
+		t.Error("WriteConfig() with config.lock present: expected error")
+	}
+}
+
+func TestObjectFormat(t *testing.T) {
+	tests := []struct {
+		config string
+		want   object.Algorithm
+	}{
+		{"", object.SHA1},
+		{"[core]\n\trepositoryformatversion = 0\n", object.SHA1},
+		{"[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectformat = sha256\n", object.SHA256},
+		// Extensions are ignored in version 0 repositories.
+		{"[core]\n\trepositoryformatversion = 0\n[extensions]\n\tobjectformat = sha256\n", object.SHA1},
+	}
+	for _, tt := range tests {
+		gitDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(gitDir, "config"), []byte(tt.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ObjectFormat(gitDir)
+		if err != nil {
+			t.Errorf("ObjectFormat(%q) error: %v", tt.config, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ObjectFormat(%q) = %v, want %v", tt.config, got, tt.want)
+		}
+	}
+
+	gitDir := t.TempDir()
+	os.WriteFile(filepath.Join(gitDir, "config"), []byte("[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectformat = md5\n"), 0644)
+	if _, err := ObjectFormat(gitDir); err == nil {
+		t.Error("ObjectFormat with unknown format: expected error")
+	}
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"github.com/elliota43/rev/internal/object"
+)
+
+// Git's defaults for gc.auto and gc.autoPackLimit.
+const (
+	DefaultGcAuto          = 6700
+	DefaultGcAutoPackLimit = 50
+)
+
+// GcNeed is what CheckAutoGc finds wrong with a repository.
+type GcNeed struct {
+	// TooManyLoose is set when there look to be more than gc.auto loose
+	// objects, so they should be packed.
+	TooManyLoose bool
+	// TooManyPacks is set when there are more than gc.autoPackLimit
+	// packs without a .keep file, so they should be consolidated.
+	TooManyPacks bool
+}
+
+// Needed reports whether gc --auto would do anything.
+func (n GcNeed) Needed() bool {
+	return n.TooManyLoose || n.TooManyPacks
+}
+
+// CheckAutoGc decides, as git's gc --auto does, whether gitDir needs
+// repacking. Loose objects are estimated from objects/17 alone (see
+// object.SampleLoose), which is too many once it holds more than a
+// 256th of gc.auto. A gc.auto of zero or less turns the whole check
+// off, and a gc.autoPackLimit of zero or less just the pack count.
+func CheckAutoGc(gitDir string) (GcNeed, error) {
+	cfg, err := ParseConfig(gitDir)
+	if err != nil {
+		return GcNeed{}, err
+	}
+	auto, found, err := cfg.GetInt("gc", "auto")
+	if err != nil {
+		return GcNeed{}, err
+	}
+	if !found {
+		auto = DefaultGcAuto
+	}
+	if auto <= 0 {
+		return GcNeed{}, nil
+	}
+	packLimit, found, err := cfg.GetInt("gc", "autopacklimit")
+	if err != nil {
+		return GcNeed{}, err
+	}
+	if !found {
+		packLimit = DefaultGcAutoPackLimit
+	}
+
+	var need GcNeed
+	sampled, err := object.SampleLoose(gitDir)
+	if err != nil {
+		return GcNeed{}, err
+	}
+	need.TooManyLoose = int64(sampled) > (auto+255)/256
+	if packLimit > 0 {
+		packs, err := object.CountPacks(gitDir)
+		if err != nil {
+			return GcNeed{}, err
+		}
+		need.TooManyPacks = int64(packs.Count-packs.Kept) > packLimit
+	}
+	return need, nil
+}
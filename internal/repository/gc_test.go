@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckAutoGc(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := filepath.Join(repo.GitDir, "objects")
+	// Three objects in 17/ stand for about 768 in all.
+	if err := os.MkdirAll(filepath.Join(objects, "17"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		name := fmt.Sprintf("%038x", i)
+		if err := os.WriteFile(filepath.Join(objects, "17", name), nil, 0444); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"pack-a.pack", "pack-b.pack", "pack-b.keep", "pack-c.pack"} {
+		if err := os.WriteFile(filepath.Join(objects, "pack", name), nil, 0444); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		config string
+		want   GcNeed
+	}{
+		{"", GcNeed{}},
+		{"[gc]\n\tauto = 512\n", GcNeed{TooManyLoose: true}},
+		{"[gc]\n\tauto = 768\n", GcNeed{}},
+		{"[gc]\n\tautoPackLimit = 1\n", GcNeed{TooManyPacks: true}},
+		{"[gc]\n\tautoPackLimit = 2\n", GcNeed{}},
+		{"[gc]\n\tauto = 0\n\tautoPackLimit = 1\n", GcNeed{}},
+		{"[gc]\n\tauto = 1\n\tautoPackLimit = 0\n", GcNeed{TooManyLoose: true}},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(filepath.Join(repo.GitDir, "config"), []byte(tt.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := CheckAutoGc(repo.GitDir)
+		if err != nil {
+			t.Fatalf("CheckAutoGc(%q) error: %v", tt.config, err)
+		}
+		if got != tt.want {
+			t.Errorf("CheckAutoGc(%q) = %+v, want %+v", tt.config, got, tt.want)
+		}
+	}
+}
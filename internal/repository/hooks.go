@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// ErrHookFailed is returned by RunHook when the hook exits nonzero.
+var ErrHookFailed = errors.New("hook failed")
+
+// HookOptions are what RunHook gives a hook besides its name.
+type HookOptions struct {
+	Args []string
+	// Stdin is the hook's standard input; nil gives it none.
+	Stdin io.Reader
+	// Output gets both what the hook prints and its errors; nil
+	// discards them.
+	Output io.Writer
+	// Env is added to the environment the hook inherits.
+	Env []string
+}
+
+// RunHook runs the hook called name, as git does: the executable file of
+// that name in core.hooksPath, or in <gitDir>/hooks by default. It runs
+// in the top of the working tree, or in gitDir for a bare repository,
+// with GIT_DIR set. A hook that doesn't exist, or isn't executable, is
+// skipped and counts as success; one that exits nonzero gives an error
+// wrapping ErrHookFailed.
+func RunHook(gitDir, name string, opts HookOptions) error {
+	gitDir, err := filepath.Abs(gitDir)
+	if err != nil {
+		return err
+	}
+	cfg, err := ParseConfig(gitDir)
+	if err != nil {
+		return err
+	}
+	bare, _, err := cfg.GetBool("core", "bare")
+	if err != nil {
+		return err
+	}
+	dir := gitDir
+	if !bare {
+		dir = filepath.Dir(gitDir)
+	}
+	hooksDir := filepath.Join(gitDir, "hooks")
+	if p, ok := cfg.Get("core", "hookspath"); ok {
+		hooksDir = p
+		if !filepath.IsAbs(p) {
+			hooksDir = filepath.Join(dir, p)
+		}
+	}
+
+	path := filepath.Join(hooksDir, name)
+	if info, err := os.Stat(path); err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+		return nil
+	}
+	cmd := exec.Command(path, opts.Args...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GIT_DIR="+gitDir), opts.Env...)
+	cmd.Stdin = opts.Stdin
+	cmd.Stdout = opts.Output
+	cmd.Stderr = opts.Output
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return fmt.Errorf("%s: %w (exit status %d)", name, ErrHookFailed, exit.ExitCode())
+		}
+		return fmt.Errorf("running %s hook: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+//go:build unix
+
+package repository
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeHook installs script as the hook name in dir.
+func writeHook(t *testing.T, dir, name, script string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks := filepath.Join(repo.GitDir, "hooks")
+
+	// A missing hook succeeds without running anything.
+	if err := RunHook(repo.GitDir, "pre-receive", HookOptions{}); err != nil {
+		t.Errorf("missing hook: %v", err)
+	}
+
+	// A hook gets its arguments, input, and environment, and runs at the
+	// top of the working tree.
+	writeHook(t, hooks, "update", `echo "$1 $2 $(cat) $X $(pwd) $GIT_DIR"; echo oops >&2`)
+	var out bytes.Buffer
+	err = RunHook(repo.GitDir, "update", HookOptions{
+		Args:   []string{"a", "b"},
+		Stdin:  strings.NewReader("in"),
+		Output: &out,
+		Env:    []string{"X=x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "a b in x " + filepath.Dir(repo.GitDir) + " " + repo.GitDir + "\noops\n"
+	if got := out.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// One that exits nonzero fails.
+	writeHook(t, hooks, "update", "exit 3\n")
+	if err := RunHook(repo.GitDir, "update", HookOptions{}); !errors.Is(err, ErrHookFailed) {
+		t.Errorf("failing hook: got %v", err)
+	}
+
+	// One that isn't executable is skipped.
+	if err := os.Chmod(filepath.Join(hooks, "update"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := RunHook(repo.GitDir, "update", HookOptions{}); err != nil {
+		t.Errorf("non-executable hook: %v", err)
+	}
+
+	// core.hooksPath, relative to the working tree, moves them.
+	cfg, err := ParseConfig(repo.GitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Set("core", "hooksPath", "githooks")
+	if err := WriteConfig(repo.GitDir, cfg); err != nil {
+		t.Fatal(err)
+	}
+	writeHook(t, filepath.Join(filepath.Dir(repo.GitDir), "githooks"), "update", "exit 1\n")
+	if err := RunHook(repo.GitDir, "update", HookOptions{}); !errors.Is(err, ErrHookFailed) {
+		t.Errorf("hook in core.hooksPath: got %v", err)
+	}
+}
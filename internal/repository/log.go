@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"container/heap"
+	"fmt"
+	"iter"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// LogOptions controls which commits Log visits. The zero value follows
+// first parents back to the root.
+type LogOptions struct {
+	// AllParents visits every ancestor rather than the first-parent
+	// chain, newest committer date first, as git log does.
+	AllParents bool
+	// Limit stops the walk after this many commits. Zero or less means
+	// no limit.
+	Limit int
+}
+
+// Log returns an iterator over the history of start, a revision as
+// ResolveRef takes it (tags are peeled), or HEAD if start is empty.
+// Commits are read and parsed one at a time, as the caller asks for
+// them. An error is yielded as the last value, with a nil commit; if
+// HEAD's branch has no commits yet it wraps ErrUnbornBranch.
+func (r *Repository) Log(start string, opts LogOptions) iter.Seq2[*object.Commit, error] {
+	return func(yield func(*object.Commit, error) bool) {
+		var hash string
+		var err error
+		if start == "" {
+			hash, err = ResolveHead(r.GitDir)
+		} else {
+			hash, err = Peel(r.GitDir, start, object.TypeCommit)
+		}
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		// seen guards the first-parent chain against a corrupt repository
+		// looping it back on itself, and keeps a merge's shared history
+		// from being visited twice. A parent is read as soon as its child
+		// is visited, since the queue orders commits by date.
+		seen := make(map[string]bool)
+		queue := &commitQueue{}
+		push := func(hash string) error {
+			obj, err := object.Read(r.GitDir, hash)
+			if err != nil {
+				return err
+			}
+			c, err := object.ParseCommit(obj)
+			if err != nil {
+				return err
+			}
+			seen[hash] = true
+			heap.Push(queue, c)
+			return nil
+		}
+		if err := push(hash); err != nil {
+			yield(nil, err)
+			return
+		}
+		for n := 1; queue.Len() > 0; n++ {
+			c := heap.Pop(queue).(*object.Commit)
+			if !yield(c, nil) || n == opts.Limit {
+				return
+			}
+
+			parents := c.Parents
+			if !opts.AllParents && len(parents) > 1 {
+				parents = parents[:1]
+			}
+			for _, p := range parents {
+				if seen[p] {
+					if opts.AllParents {
+						continue
+					}
+					yield(nil, fmt.Errorf("commit %s: history loops back on itself", p))
+					return
+				}
+				if err := push(p); err != nil {
+					yield(nil, err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// commitQueue is a heap of commits waiting to be visited, newest
+// committer date first; commits with the same date come out in the order
+// they went in.
+type commitQueue struct {
+	commits []*object.Commit
+	order   []int
+	pushed  int
+}
+
+func (q *commitQueue) Len() int { return len(q.commits) }
+
+func (q *commitQueue) Less(i, j int) bool {
+	ti, tj := q.commits[i].Committer.When, q.commits[j].Committer.When
+	if ti != tj {
+		return ti > tj
+	}
+	return q.order[i] < q.order[j]
+}
+
+func (q *commitQueue) Swap(i, j int) {
+	q.commits[i], q.commits[j] = q.commits[j], q.commits[i]
+	q.order[i], q.order[j] = q.order[j], q.order[i]
+}
+
+func (q *commitQueue) Push(x any) {
+	q.commits = append(q.commits, x.(*object.Commit))
+	q.order = append(q.order, q.pushed)
+	q.pushed++
+}
+
+func (q *commitQueue) Pop() any {
+	n := len(q.commits) - 1
+	c := q.commits[n]
+	q.commits, q.order = q.commits[:n], q.order[:n]
+	return c
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// writeLogCommit stores a commit with an empty tree, the given parents,
+// and a committer date of when, and returns its hash.
+func writeLogCommit(t *testing.T, gitDir, message string, when int64, parents ...string) string {
+	t.Helper()
+	tree, err := object.WriteObject(gitDir, object.TypeTree, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := object.Signature{Name: "A U Thor", Email: "author@example.com", When: when, Timezone: "+0000"}
+	commit := &object.Commit{Tree: tree, Parents: parents, Author: sig, Committer: sig, Message: message + "\n"}
+	sha, err := object.WriteObject(gitDir, object.TypeCommit, commit.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+// logMessages collects the subjects Log yields, failing on an error.
+func logMessages(t *testing.T, repo *Repository, start string, opts LogOptions) string {
+	t.Helper()
+	var got []string
+	for c, err := range repo.Log(start, opts) {
+		if err != nil {
+			t.Fatalf("Log(%q, %+v): %v", start, opts, err)
+		}
+		got = append(got, strings.TrimSpace(c.Message))
+	}
+	return strings.Join(got, " ")
+}
+
+func TestLog(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := repo.GitDir
+
+	for c, err := range repo.Log("", LogOptions{}) {
+		if c != nil || !errors.Is(err, ErrUnbornBranch) {
+			t.Errorf("unborn HEAD: got %v, %v; want ErrUnbornBranch", c, err)
+		}
+	}
+
+	// root <- a <- merge <- top, with side branching off root and merged
+	// in as merge's second parent.
+	root := writeLogCommit(t, gitDir, "root", 1)
+	a := writeLogCommit(t, gitDir, "a", 2, root)
+	side := writeLogCommit(t, gitDir, "side", 3, root)
+	merge := writeLogCommit(t, gitDir, "merge", 5, a, side)
+	top := writeLogCommit(t, gitDir, "top", 6, merge)
+	writeRef(t, gitDir, "refs/heads/main", top)
+	tag, err := object.WriteObject(gitDir, object.TypeTag, []byte("object "+side+"\ntype commit\ntag v1\n\nv1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRef(t, gitDir, "refs/tags/v1", tag)
+
+	tests := []struct {
+		start string
+		opts  LogOptions
+		want  string
+	}{
+		{"", LogOptions{}, "top merge a root"},
+		{"main", LogOptions{AllParents: true}, "top merge side a root"},
+		{"", LogOptions{Limit: 2}, "top merge"},
+		{"", LogOptions{AllParents: true, Limit: 3}, "top merge side"},
+		{merge[:7], LogOptions{}, "merge a root"},
+		{"v1", LogOptions{}, "side root"},
+	}
+	for _, tt := range tests {
+		if got := logMessages(t, repo, tt.start, tt.opts); got != tt.want {
+			t.Errorf("Log(%q, %+v) = %q, want %q", tt.start, tt.opts, got, tt.want)
+		}
+	}
+
+	var hashes []string
+	for c, err := range repo.Log("", LogOptions{}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, c.Hash)
+		if len(hashes) == 2 {
+			break
+		}
+	}
+	if want := []string{top, merge}; !slices.Equal(hashes, want) {
+		t.Errorf("hashes = %v, want %v", hashes, want)
+	}
+}
+
+func TestLog_MissingParent(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan := writeLogCommit(t, repo.GitDir, "orphan", 1, strings.Repeat("1", 40))
+
+	// The missing parent is only an error if the walk gets that far.
+	if got := logMessages(t, repo, orphan, LogOptions{Limit: 1}); got != "orphan" {
+		t.Errorf("Log with limit 1 = %q, want orphan", got)
+	}
+	var last error
+	for _, err := range repo.Log(orphan, LogOptions{}) {
+		last = err
+	}
+	if !errors.Is(last, object.ErrNotFound) {
+		t.Errorf("Log past a missing parent: got %v, want ErrNotFound", last)
+	}
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// mergeTraversalWeight is how much crossing into a merge's second or
+// later parent counts against a name, against one for each first-parent
+// step, so that names following first parents win, as in git.
+const mergeTraversalWeight = 65535
+
+// revName is a commit's name relative to a ref: tip, then generation
+// first-parent steps back from it.
+type revName struct {
+	tip        string
+	generation int
+	distance   int
+	fromTag    bool
+	// deref marks a tip reached by peeling an annotated tag, written
+	// "^0" when the tagged commit itself is named.
+	deref bool
+}
+
+func (n *revName) String() string {
+	switch {
+	case n.generation > 0:
+		return fmt.Sprintf("%s~%d", n.tip, n.generation)
+	case n.deref:
+		return n.tip + "^0"
+	}
+	return n.tip
+}
+
+// betterThan reports whether n should replace other as a commit's name:
+// a tag beats a branch, and otherwise the nearer ref wins.
+func (n *revName) betterThan(other *revName) bool {
+	if n.fromTag != other.fromTag {
+		return n.fromTag
+	}
+	return n.distance < other.distance
+}
+
+// NameRevs names every commit reachable from the repository's refs, or
+// only its tags if tagsOnly is set, after the ref that reaches it most
+// directly: "main", "main~2", or "tags/v1~1^2~3" for the third
+// first-parent ancestor of the second parent of v1's parent. Refs are
+// named as in git name-rev, without "refs/" and, for branches,
+// "refs/heads/". Refs that don't lead to a commit are ignored.
+func (r *Repository) NameRevs(tagsOnly bool) (map[string]string, error) {
+	refs, err := ListRefs(r.GitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]*revName)
+	parents := make(map[string][]string)
+	type pending struct {
+		hash string
+		name *revName
+	}
+	for _, ref := range refs {
+		fromTag := strings.HasPrefix(ref.Name, "refs/tags/")
+		if tagsOnly && !fromTag {
+			continue
+		}
+		tip, err := Peel(r.GitDir, ref.Hash, object.TypeCommit)
+		if err != nil {
+			continue
+		}
+		short := strings.TrimPrefix(ref.Name, "refs/")
+		short = strings.TrimPrefix(short, "heads/")
+
+		stack := []pending{{tip, &revName{tip: short, fromTag: fromTag, deref: tip != ref.Hash}}}
+		for len(stack) > 0 {
+			p := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if old, ok := names[p.hash]; ok && !p.name.betterThan(old) {
+				continue
+			}
+			names[p.hash] = p.name
+
+			ps, ok := parents[p.hash]
+			if !ok {
+				obj, err := object.Read(r.GitDir, p.hash)
+				if err != nil {
+					return nil, err
+				}
+				c, err := object.ParseCommit(obj)
+				if err != nil {
+					return nil, err
+				}
+				ps = c.Parents
+				parents[p.hash] = ps
+			}
+			// Pushed last-first so the first parent's line is walked first.
+			for i := len(ps) - 1; i >= 0; i-- {
+				n := *p.name
+				if i == 0 {
+					n.generation++
+					n.distance++
+				} else {
+					n.tip = fmt.Sprintf("%s^%d", p.name.tip, i+1)
+					if p.name.generation > 0 {
+						n.tip = fmt.Sprintf("%s~%d^%d", p.name.tip, p.name.generation, i+1)
+					}
+					n.generation = 0
+					n.deref = false
+					n.distance += mergeTraversalWeight
+				}
+				stack = append(stack, pending{ps[i], &n})
+			}
+		}
+	}
+
+	named := make(map[string]string, len(names))
+	for hash, n := range names {
+		named[hash] = n.String()
+	}
+	return named, nil
+}
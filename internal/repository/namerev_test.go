@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+func TestNameRevs(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := repo.GitDir
+
+	// root <- a <- merge <- top on main, with side (off root) merged in
+	// as merge's second parent; an annotated tag v1 on a, a lightweight
+	// tag lw on top, and a branch topic on side.
+	root := writeLogCommit(t, gitDir, "root", 1)
+	a := writeLogCommit(t, gitDir, "a", 2, root)
+	side := writeLogCommit(t, gitDir, "side", 3, root)
+	side2 := writeLogCommit(t, gitDir, "side2", 4, side)
+	merge := writeLogCommit(t, gitDir, "merge", 5, a, side2)
+	top := writeLogCommit(t, gitDir, "top", 6, merge)
+	writeRef(t, gitDir, "refs/heads/main", top)
+	writeRef(t, gitDir, "refs/heads/topic", side)
+	tag, err := object.WriteObject(gitDir, object.TypeTag, []byte("object "+a+"\ntype commit\ntag v1\n\nv1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRef(t, gitDir, "refs/tags/v1", tag)
+
+	names, err := repo.NameRevs(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		top:   "main",
+		merge: "main~1",
+		side2: "main~1^2",
+		side:  "topic",
+		a:     "tags/v1^0", // a tag beats the nearer branch name main~2
+		root:  "tags/v1~1",
+	}
+	for hash, name := range want {
+		if names[hash] != name {
+			t.Errorf("name of %s = %q, want %q", hash[:7], names[hash], name)
+		}
+	}
+	// Every name resolves back to the commit it names.
+	for hash, name := range names {
+		if got, err := ResolveRef(gitDir, name); err != nil || got != hash {
+			t.Errorf("ResolveRef(%q) = %q, %v; want %s", name, got, err, hash)
+		}
+	}
+
+	writeRef(t, gitDir, "refs/tags/lw", top)
+	if names, err = repo.NameRevs(true); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{
+		top:   "tags/lw",
+		side2: "tags/lw~1^2",
+		side:  "tags/lw~1^2~1",
+		a:     "tags/v1^0",
+	}
+	for hash, name := range want {
+		if names[hash] != name {
+			t.Errorf("tags only: name of %s = %q, want %q", hash[:7], names[hash], name)
+		}
+	}
+	for hash, name := range names {
+		if got, err := ResolveRef(gitDir, name); err != nil || got != hash {
+			t.Errorf("tags only: ResolveRef(%q) = %q, %v; want %s", name, got, err, hash)
+		}
+	}
+}
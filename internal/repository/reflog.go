@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// ErrNoPreviousBranch is returned when @{-n} asks further back than the
+// HEAD reflog records checkouts.
+var ErrNoPreviousBranch = errors.New("no previous branch")
+
+// ReflogEntry is one line of a reflog, <gitDir>/logs/<ref>: the ref
+// moving from Old to New. Old is all zeros when the ref was created.
+type ReflogEntry struct {
+	Old, New  string
+	Committer object.Signature
+	Message   string
+}
+
+// String formats e as a reflog line, without the newline.
+func (e ReflogEntry) String() string {
+	return fmt.Sprintf("%s %s %s\t%s", e.Old, e.New, e.Committer, e.Message)
+}
+
+// AppendReflog adds e to ref's reflog. As in git, the log is only kept
+// for HEAD and branches, unless core.logAllRefUpdates is false, and for
+// any ref whose log already exists. An empty e.Old is written as the
+// zero hash, and newlines in the message become spaces.
+func AppendReflog(gitDir, ref string, e ReflogEntry) error {
+	path := filepath.Join(gitDir, "logs", filepath.FromSlash(ref))
+	if _, err := os.Stat(path); err != nil {
+		cfg, err := ParseConfig(gitDir)
+		if err != nil {
+			return err
+		}
+		logAll, found, err := cfg.GetBool("core", "logallrefupdates")
+		if err != nil {
+			return err
+		}
+		if found && !logAll || ref != "HEAD" && !strings.HasPrefix(ref, "refs/heads/") {
+			return nil
+		}
+	}
+
+	if e.Old == "" {
+		e.Old = strings.Repeat("0", len(e.New))
+	}
+	e.Message = strings.TrimSpace(strings.ReplaceAll(e.Message, "\n", " "))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating reflog directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening reflog for %s: %w", ref, err)
+	}
+	_, err = fmt.Fprintln(f, e)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing reflog for %s: %w", ref, err)
+	}
+	return nil
+}
+
+// ReadReflog returns ref's reflog, oldest entry first. A ref with no log
+// has no entries.
+func ReadReflog(gitDir, ref string) ([]ReflogEntry, error) {
+	data, err := os.ReadFile(filepath.Join(gitDir, "logs", filepath.FromSlash(ref)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading reflog for %s: %w", ref, err)
+	}
+
+	var entries []ReflogEntry
+	for i, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		e, err := parseReflogLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("reflog for %s, line %d: %w", ref, i+1, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// parseReflogLine parses "<old> <new> <committer>\t<message>".
+func parseReflogLine(line string) (ReflogEntry, error) {
+	head, message, _ := strings.Cut(line, "\t")
+	old, rest, ok1 := strings.Cut(head, " ")
+	newHash, ident, ok2 := strings.Cut(rest, " ")
+	if !ok1 || !ok2 || !isHash(old) || !isHash(newHash) {
+		return ReflogEntry{}, fmt.Errorf("malformed entry %q", line)
+	}
+	committer, err := object.ParseSignature(ident)
+	if err != nil {
+		return ReflogEntry{}, err
+	}
+	return ReflogEntry{Old: old, New: newHash, Committer: committer, Message: message}, nil
+}
+
+// ReflogAt returns the value ref had n changes ago, as ref@{n} names it:
+// n = 0 is the newest entry's new value, and n = len(entries) the oldest
+// entry's old value, unless the ref was created there.
+func ReflogAt(gitDir, ref string, n int) (string, error) {
+	entries, err := ReadReflog(gitDir, ref)
+	if err != nil {
+		return "", err
+	}
+	if n == len(entries) && n > 0 && strings.Trim(entries[0].Old, "0") != "" {
+		return entries[0].Old, nil
+	}
+	if n >= len(entries) {
+		return "", fmt.Errorf("%w: log for '%s' only has %d entries", ErrUnknownRevision, ref, len(entries))
+	}
+	return entries[len(entries)-1-n].New, nil
+}
+
+// PreviousBranch returns what was checked out before the nth most recent
+// checkout, as @{-n} names it, read from the "checkout: moving from
+// <old> to <new>" entries in HEAD's reflog. It is a branch's short name,
+// or the commit hash if HEAD was detached.
+func PreviousBranch(gitDir string, n int) (string, error) {
+	entries, err := ReadReflog(gitDir, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		from, ok := strings.CutPrefix(entries[i].Message, "checkout: moving from ")
+		if !ok {
+			continue
+		}
+		if n--; n == 0 {
+			from, _, _ = strings.Cut(from, " to ")
+			return from, nil
+		}
+	}
+	return "", ErrNoPreviousBranch
+}
+
+// parseReflogSuffix splits "<ref>@{<n>}" into ref and n. ok is false if
+// name has no such suffix; a negative n is @{-n}.
+func parseReflogSuffix(name string) (ref string, n int, ok bool) {
+	base, suffix, found := strings.Cut(name, "@{")
+	if !found || !strings.HasSuffix(suffix, "}") {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(suffix, "}"))
+	if err != nil || strings.HasPrefix(suffix, "+") || strings.HasPrefix(suffix, "-0") {
+		return "", 0, false
+	}
+	return base, n, true
+}
+
+// resolveReflog resolves "<ref>@{n}" and "@{-n}" names for ResolveRef.
+// An empty ref means the current branch, as in git, or HEAD when it is
+// detached; "@" means HEAD.
+func resolveReflog(gitDir, ref string, n int) (string, error) {
+	if n < 0 {
+		if ref != "" {
+			return "", fmt.Errorf("%w: %s@{%d}", ErrUnknownRevision, ref, n)
+		}
+		prev, err := PreviousBranch(gitDir, -n)
+		if errors.Is(err, ErrNoPreviousBranch) {
+			return "", fmt.Errorf("%w: @{%d}: %w", ErrUnknownRevision, n, err)
+		}
+		if err != nil {
+			return "", err
+		}
+		if hash, ok, err := readRef(gitDir, "refs/heads/"+prev); err != nil || ok {
+			return hash, err
+		}
+		if isHash(prev) {
+			return prev, nil
+		}
+		return "", fmt.Errorf("%w: @{%d} is branch '%s', which no longer exists", ErrUnknownRevision, n, prev)
+	}
+
+	full := "HEAD"
+	switch ref {
+	case "":
+		branch, ok, err := ReadSymbolicRef(gitDir, "HEAD")
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			full = branch
+		}
+	case "@":
+	default:
+		var err error
+		if full, err = fullRefName(gitDir, ref); err != nil {
+			return "", err
+		}
+	}
+	return ReflogAt(gitDir, full, n)
+}
+
+// fullRefName returns the full name of the ref a short name resolves to,
+// searched as ResolveRef searches.
+func fullRefName(gitDir, name string) (string, error) {
+	for _, pattern := range refSearchPath {
+		if pattern == "%s" && !strings.HasPrefix(name, "refs/") && !isPseudoref(name) {
+			continue
+		}
+		full := fmt.Sprintf(pattern, name)
+		if _, ok, err := readRef(gitDir, full); err != nil {
+			return "", err
+		} else if ok {
+			return full, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownRevision, name)
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+func TestReflog(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := repo.GitDir
+	one := writeTestObject(t, gitDir, "one\n")
+	two := writeTestObject(t, gitDir, "two\n")
+	who := object.Signature{Name: "A U Thor", Email: "author@example.com", When: 1700000000, Timezone: "+0100"}
+
+	// main gets one, topic is made from it and gets two, and HEAD moves
+	// from main to topic, to a detached one, and back to main. side's log
+	// starts with it already at one.
+	writeRef(t, gitDir, "refs/heads/main", one)
+	writeRef(t, gitDir, "refs/heads/topic", two)
+	writeRef(t, gitDir, "refs/heads/side", two)
+	logs := []struct {
+		ref      string
+		old, new string
+		msg      string
+	}{
+		{"HEAD", "", one, "commit (initial): one"},
+		{"refs/heads/main", "", one, "commit (initial): one"},
+		{"refs/heads/topic", "", one, "branch: Created from HEAD"},
+		{"HEAD", one, one, "checkout: moving from main to topic"},
+		{"HEAD", one, two, "commit: two\nwith a body"},
+		{"refs/heads/topic", one, two, "commit: two"},
+		{"HEAD", two, one, "checkout: moving from topic to " + one},
+		{"HEAD", one, one, "checkout: moving from " + one + " to main"},
+		{"refs/tags/v1", "", one, "not logged"},
+		{"refs/heads/side", one, two, "reset: moving to " + two},
+	}
+	for _, l := range logs {
+		if err := AppendReflog(gitDir, l.ref, ReflogEntry{Old: l.old, New: l.new, Committer: who, Message: l.msg}); err != nil {
+			t.Fatalf("AppendReflog(%s) error: %v", l.ref, err)
+		}
+	}
+
+	entries, err := ReadReflog(gitDir, "HEAD")
+	if err != nil {
+		t.Fatalf("ReadReflog() error: %v", err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("ReadReflog() = %d entries, want 5", len(entries))
+	}
+	want := ReflogEntry{Old: one, New: two, Committer: who, Message: "commit: two with a body"}
+	if entries[2] != want {
+		t.Errorf("entry 2 = %+v, want %+v", entries[2], want)
+	}
+	if entries[0].Old != "0000000000000000000000000000000000000000" {
+		t.Errorf("first entry's old value = %s, want the zero hash", entries[0].Old)
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "logs", "refs", "tags", "v1")); !os.IsNotExist(err) {
+		t.Errorf("tag reflog written without core.logAllRefUpdates: %v", err)
+	}
+
+	tests := []struct {
+		name, want string
+	}{
+		{"@", one},
+		{"HEAD@{0}", one},
+		{"HEAD@{2}", two},
+		{"@@{2}", two},
+		{"@{0}", one}, // main's reflog, since HEAD is on main
+		{"topic@{1}", one},
+		{"refs/heads/topic@{0}", two},
+		{"side@{1}", one}, // the oldest entry's old value
+		{"@{-1}", one},    // the detached HEAD
+		{"@{-2}", two},    // topic
+		{"@{-3}", one},    // main
+	}
+	for _, tt := range tests {
+		got, err := ResolveRef(gitDir, tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveRef(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	for _, name := range []string{"@{1}", "HEAD@{5}", "topic@{2}", "side@{2}", "nope@{0}", "main@{-1}", "@{-4}", "@{-0}", "@{+1}"} {
+		if _, err := ResolveRef(gitDir, name); err == nil {
+			t.Errorf("ResolveRef(%q): want error", name)
+		}
+	}
+	if _, err := PreviousBranch(gitDir, 4); !errors.Is(err, ErrNoPreviousBranch) {
+		t.Errorf("PreviousBranch(4) error = %v, want ErrNoPreviousBranch", err)
+	}
+}
@@ -0,0 +1,376 @@
+package repository
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/trace"
+)
+
+// Ref is a named reference and the object it points to.
+type Ref struct {
+	// Name is the full ref name, e.g. "refs/heads/main".
+	Name string
+	// Hash is the 40-char object SHA the ref points to.
+	Hash string
+}
+
+// ListRefs returns every ref under refs/, merging loose ref files with
+// the packed-refs file. A loose ref overrides a packed ref of the same
+// name, as in git. The "<ref>.lock" files of updates in progress aren't
+// refs, and are skipped. Results are sorted by name.
+func ListRefs(gitDir string) ([]Ref, error) {
+	start := time.Now()
+	refs, err := readPackedRefs(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	symbolic := make(map[string]string)
+	refsDir := filepath.Join(gitDir, "refs")
+	err = filepath.WalkDir(refsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == refsDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(d.Name(), ".lock") {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading ref %s: %w", path, err)
+		}
+
+		rel, err := filepath.Rel(gitDir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		content := strings.TrimSpace(string(data))
+
+		if target, ok := strings.CutPrefix(content, "ref: "); ok {
+			symbolic[name] = target
+			return nil
+		}
+		if !isHash(content) {
+			return fmt.Errorf("ref %s: malformed contents %q", name, content)
+		}
+		refs[name] = content
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking refs: %w", err)
+	}
+
+	// Symbolic refs under refs/ (e.g. refs/remotes/origin/HEAD) are listed
+	// with the hash of the ref they point to.
+	for name, target := range symbolic {
+		if hash, ok := refs[target]; ok {
+			refs[name] = hash
+		}
+	}
+
+	result := make([]Ref, 0, len(refs))
+	for name, hash := range refs {
+		result = append(result, Ref{Name: name, Hash: hash})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	if trace.Enabled() {
+		trace.Log("refs", "loaded %d refs in %s", len(result), trace.Since(start))
+	}
+	return result, nil
+}
+
+// ErrUnbornBranch is returned by ResolveHead when HEAD names a branch that
+// has no commits yet, as in a freshly initialized repository.
+var ErrUnbornBranch = errors.New("branch has no commits yet")
+
+// maxSymrefDepth bounds how many symbolic refs are followed, so a cycle
+// can't loop forever.
+const maxSymrefDepth = 5
+
+// ResolveHead returns the commit HEAD points to. A symbolic HEAD
+// ("ref: refs/heads/main") is followed to its branch; a detached HEAD
+// holds the hash directly. If the branch doesn't exist yet, the error
+// wraps ErrUnbornBranch.
+func ResolveHead(gitDir string) (string, error) {
+	return resolveHead(gitDir, func(name string) (string, bool, error) {
+		return readRef(gitDir, name)
+	})
+}
+
+// resolveHead implements ResolveHead, looking up the branch a symbolic
+// HEAD names with lookup.
+func resolveHead(gitDir string, lookup func(name string) (string, bool, error)) (string, error) {
+	data, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return "", fmt.Errorf("reading HEAD: %w", err)
+	}
+	content := strings.TrimSpace(string(data))
+
+	target, symbolic := strings.CutPrefix(content, "ref: ")
+	if !symbolic {
+		if !isHash(content) {
+			return "", fmt.Errorf("HEAD is neither a ref nor a hash: %q", content)
+		}
+		return content, nil
+	}
+
+	hash, ok, err := lookup(target)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%s: %w", target, ErrUnbornBranch)
+	}
+	return hash, nil
+}
+
+// HeadTree returns the tree of the commit HEAD points to, or "" if HEAD
+// is an unborn branch, as in a repository with no commits yet.
+func HeadTree(gitDir string) (string, error) {
+	head, err := ResolveHead(gitDir)
+	if errors.Is(err, ErrUnbornBranch) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return headCommitTree(gitDir, head)
+}
+
+// headCommitTree returns the tree of head, which must be a commit.
+func headCommitTree(gitDir, head string) (string, error) {
+	obj, err := object.Read(gitDir, head)
+	if err != nil {
+		return "", err
+	}
+	if obj.Type != object.TypeCommit {
+		return "", fmt.Errorf("HEAD is a %s, not a commit", obj.Type)
+	}
+	c, err := object.ParseCommit(obj)
+	if err != nil {
+		return "", err
+	}
+	return c.Tree, nil
+}
+
+// readRef returns the hash stored in the ref name ("HEAD",
+// "refs/heads/main", ...), checking the loose file before packed-refs and
+// following symbolic refs. ok is false if the ref, or the ref it points
+// to, doesn't exist.
+func readRef(gitDir, name string) (hash string, ok bool, err error) {
+	for range maxSymrefDepth {
+		path := filepath.Join(gitDir, filepath.FromSlash(name))
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) && !isDir(path) {
+			return "", false, fmt.Errorf("reading ref %s: %w", name, err)
+		}
+		if err != nil {
+			packed, err := readPackedRefs(gitDir)
+			if err != nil {
+				return "", false, err
+			}
+			hash, ok := packed[name]
+			if trace.Enabled() && ok {
+				trace.Log("refs", "read %s (packed)", name)
+			}
+			return hash, ok, nil
+		}
+
+		content := strings.TrimSpace(string(data))
+		target, symbolic := strings.CutPrefix(content, "ref: ")
+		if !symbolic {
+			if !isHash(content) {
+				return "", false, fmt.Errorf("ref %s: malformed contents %q", name, content)
+			}
+			if trace.Enabled() {
+				trace.Log("refs", "read %s (loose)", name)
+			}
+			return content, true, nil
+		}
+		if trace.Enabled() {
+			trace.Log("refs", "read %s -> %s (symbolic)", name, target)
+		}
+		name = target
+	}
+	return "", false, fmt.Errorf("ref %s: too many levels of symbolic refs", name)
+}
+
+// isDir reports whether path is a directory, such as refs/heads when a
+// ref name is a bare namespace.
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// isHash reports whether s is a full lowercase hex object name, SHA-1
+// or SHA-256.
+func isHash(s string) bool {
+	return (len(s) == object.SHA1.HexLen() || len(s) == object.SHA256.HexLen()) && isHexPrefix(s)
+}
+
+// readPackedRefs parses <gitDir>/packed-refs into a name → hash map.
+// A missing file is not an error; it just means nothing is packed.
+// Peeled lines ("^<sha>") describe the preceding tag and are skipped.
+func readPackedRefs(gitDir string) (map[string]string, error) {
+	refs := make(map[string]string)
+
+	f, err := os.Open(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return refs, nil
+		}
+		return nil, fmt.Errorf("opening packed-refs: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("malformed packed-refs line: %q", line)
+		}
+		refs[name] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading packed-refs: %w", err)
+	}
+	return refs, nil
+}
+
+// RefStore loads a repository's refs once and answers lookups and
+// listings from memory, so a command that consults refs repeatedly
+// (status, for-each-ref, branch) walks refs/ and reads packed-refs only
+// once. Ref writes made through this package (UpdateRef, CreateBranch,
+// and the rest) make every store for the git dir reload on its next
+// call; changes made behind the package's back need Invalidate.
+type RefStore struct {
+	gitDir string
+
+	mu     sync.Mutex
+	loaded bool
+	// writes is the git dir's write count when the refs were loaded.
+	writes uint64
+	refs   []Ref
+	byName map[string]string
+}
+
+// refWrites counts the ref writes made through this package, per git
+// dir, so a RefStore can tell its cache went stale.
+var refWrites sync.Map // cleaned git dir -> *atomic.Uint64
+
+// refWriteCount returns the counter for gitDir's ref writes.
+func refWriteCount(gitDir string) *atomic.Uint64 {
+	c, _ := refWrites.LoadOrStore(filepath.Clean(gitDir), new(atomic.Uint64))
+	return c.(*atomic.Uint64)
+}
+
+// refsWritten records a ref write in gitDir.
+func refsWritten(gitDir string) {
+	refWriteCount(gitDir).Add(1)
+}
+
+// NewRefStore returns an empty RefStore for gitDir. Refs are loaded on
+// first use.
+func NewRefStore(gitDir string) *RefStore {
+	return &RefStore{gitDir: gitDir}
+}
+
+// List returns every ref, sorted by name. The returned slice is shared
+// and must not be modified.
+func (s *RefStore) List() ([]Ref, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s.refs, nil
+}
+
+// Lookup returns the hash of the ref with the given full name.
+func (s *RefStore) Lookup(name string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return "", false, err
+	}
+	hash, ok := s.byName[name]
+	if trace.Enabled() {
+		trace.Log("refs", "lookup %s (cached)", name)
+	}
+	return hash, ok, nil
+}
+
+// ResolveHead is ResolveHead with the branch looked up in the store.
+// HEAD itself is read from disk each time: it is a single small file,
+// and every commit or checkout rewrites it.
+func (s *RefStore) ResolveHead() (string, error) {
+	return resolveHead(s.gitDir, s.Lookup)
+}
+
+// HeadTree is HeadTree with the branch looked up in the store.
+func (s *RefStore) HeadTree() (string, error) {
+	head, err := s.ResolveHead()
+	if errors.Is(err, ErrUnbornBranch) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return headCommitTree(s.gitDir, head)
+}
+
+// Invalidate drops the cached refs so the next call reloads them.
+func (s *RefStore) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loaded = false
+	s.refs = nil
+	s.byName = nil
+}
+
+// load reads all refs if they aren't cached, or if a ref was written
+// since they were. Callers must hold s.mu.
+func (s *RefStore) load() error {
+	writes := refWriteCount(s.gitDir).Load()
+	if s.loaded && s.writes == writes {
+		return nil
+	}
+
+	refs, err := ListRefs(s.gitDir)
+	if err != nil {
+		return err
+	}
+
+	s.byName = make(map[string]string, len(refs))
+	for _, r := range refs {
+		s.byName[r.Name] = r.Hash
+	}
+	s.refs = refs
+	s.writes = writes
+	s.loaded = true
+	return nil
+}
@@ -0,0 +1,316 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// writeRef writes a loose ref file, creating parent directories.
+func writeRef(t *testing.T, gitDir, name, content string) {
+	t.Helper()
+	p := filepath.Join(gitDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListRefs(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		shaA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+		shaB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+		shaC = "cccccccccccccccccccccccccccccccccccccccc"
+	)
+
+	writeRef(t, repo.GitDir, "refs/heads/main", shaA)
+	writeRef(t, repo.GitDir, "refs/heads/feature/x", shaB)
+	writeRef(t, repo.GitDir, "refs/remotes/origin/HEAD", "ref: refs/heads/main")
+	// An update in progress, which isn't a ref.
+	writeRef(t, repo.GitDir, "refs/heads/main.lock", shaB)
+
+	packed := "# pack-refs with: peeled fully-peeled sorted\n" +
+		shaC + " refs/heads/main\n" +
+		shaC + " refs/tags/v1.0\n" +
+		"^" + shaB + "\n"
+	if err := os.WriteFile(filepath.Join(repo.GitDir, "packed-refs"), []byte(packed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := ListRefs(repo.GitDir)
+	if err != nil {
+		t.Fatalf("ListRefs() error: %v", err)
+	}
+
+	want := []Ref{
+		{"refs/heads/feature/x", shaB},
+		{"refs/heads/main", shaA}, // loose overrides packed
+		{"refs/remotes/origin/HEAD", shaA},
+		{"refs/tags/v1.0", shaC},
+	}
+	if len(refs) != len(want) {
+		t.Fatalf("got %d refs, want %d: %v", len(refs), len(want), refs)
+	}
+	for i := range want {
+		if refs[i] != want[i] {
+			t.Errorf("ref %d: got %v, want %v", i, refs[i], want[i])
+		}
+	}
+}
+
+func TestListRefs_Malformed(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRef(t, repo.GitDir, "refs/heads/main", "not a hash")
+
+	if refs, err := ListRefs(repo.GitDir); err == nil {
+		t.Errorf("ListRefs() = %v, want an error for a malformed ref", refs)
+	}
+}
+
+func TestListRefs_Empty(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := ListRefs(repo.GitDir)
+	if err != nil {
+		t.Fatalf("ListRefs() error: %v", err)
+	}
+	if len(refs) != 0 {
+		t.Errorf("expected no refs in fresh repo, got %v", refs)
+	}
+}
+
+func TestRefStore(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const shaA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	const shaB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	writeRef(t, repo.GitDir, "refs/heads/main", shaA)
+
+	store := repo.Refs()
+	hash, ok, err := store.Lookup("refs/heads/main")
+	if err != nil || !ok || hash != shaA {
+		t.Fatalf("Lookup(main): got %q, %v, %v", hash, ok, err)
+	}
+
+	// Writes behind the store's back aren't seen until Invalidate.
+	writeRef(t, repo.GitDir, "refs/heads/main", shaB)
+	if hash, _, _ := store.Lookup("refs/heads/main"); hash != shaA {
+		t.Errorf("cached Lookup: got %q, want %q", hash, shaA)
+	}
+
+	store.Invalidate()
+	if hash, _, _ := store.Lookup("refs/heads/main"); hash != shaB {
+		t.Errorf("Lookup after Invalidate: got %q, want %q", hash, shaB)
+	}
+
+	if _, ok, _ := store.Lookup("refs/heads/missing"); ok {
+		t.Error("Lookup(missing) should report not found")
+	}
+	if repo.Refs() != store {
+		t.Error("Refs() should return the same store on every call")
+	}
+}
+
+func TestRefStore_SeesWrites(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := repo.GitDir
+	first := writeTestCommit(t, gitDir, "first")
+	second := writeTestCommit(t, gitDir, "second")
+	for i := range 2000 {
+		writeRef(t, gitDir, fmt.Sprintf("refs/tags/v%d", i), first)
+	}
+	store := repo.Refs()
+	if refs, err := store.List(); err != nil || len(refs) != 2000 {
+		t.Fatalf("List() = %d refs, %v; want 2000", len(refs), err)
+	}
+
+	lookup := func(step, name, want string) {
+		t.Helper()
+		hash, ok, err := store.Lookup(name)
+		if err != nil || hash != want || ok != (want != "") {
+			t.Errorf("%s: Lookup(%s) = %q, %v, %v; want %q", step, name, hash, ok, err, want)
+		}
+	}
+
+	if err := CreateBranch(gitDir, "main", first); err != nil {
+		t.Fatal(err)
+	}
+	lookup("CreateBranch", "refs/heads/main", first)
+	if head, err := store.ResolveHead(); err != nil || head != first {
+		t.Errorf("ResolveHead() = %q, %v; want %s", head, err, first)
+	}
+
+	if err := UpdateRef(gitDir, "HEAD", second); err != nil {
+		t.Fatal(err)
+	}
+	lookup("UpdateRef", "refs/heads/main", second)
+
+	if err := WriteSymbolicRef(gitDir, "refs/remotes/origin/HEAD", "refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+	lookup("WriteSymbolicRef", "refs/remotes/origin/HEAD", second)
+
+	if err := CreateBranch(gitDir, "topic", first); err != nil {
+		t.Fatal(err)
+	}
+	lookup("CreateBranch", "refs/heads/topic", first)
+	if _, err := DeleteBranch(gitDir, "topic"); err != nil {
+		t.Fatal(err)
+	}
+	lookup("DeleteBranch", "refs/heads/topic", "")
+
+	if err := DetachHead(gitDir, first); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := store.ResolveHead(); err != nil || head != first {
+		t.Errorf("ResolveHead() after DetachHead = %q, %v; want %s", head, err, first)
+	}
+}
+
+// benchRepoWithTags creates a repo with n loose tags.
+func benchRepoWithTags(b *testing.B, n int) string {
+	b.Helper()
+	repo, err := Init(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	content := []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa\n")
+	for i := range n {
+		p := filepath.Join(repo.GitDir, "refs", "tags", fmt.Sprintf("v%d", i))
+		if err := os.WriteFile(p, content, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return repo.GitDir
+}
+
+func BenchmarkListRefs_Lookup(b *testing.B) {
+	gitDir := benchRepoWithTags(b, 5000)
+	for b.Loop() {
+		refs, err := ListRefs(gitDir)
+		if err != nil || len(refs) != 5000 {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRefStore_Lookup(b *testing.B) {
+	store := NewRefStore(benchRepoWithTags(b, 5000))
+	for b.Loop() {
+		if _, ok, err := store.Lookup("refs/tags/v42"); err != nil || !ok {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestResolveHead(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := repo.GitDir
+	hash := "1111111111111111111111111111111111111111"
+
+	// A fresh repository's HEAD names a branch that doesn't exist yet.
+	if _, err := ResolveHead(gitDir); !errors.Is(err, ErrUnbornBranch) {
+		t.Fatalf("fresh repo: got %v, want ErrUnbornBranch", err)
+	}
+
+	writeRef(t, gitDir, "refs/heads/main", hash)
+	if got, err := ResolveHead(gitDir); err != nil || got != hash {
+		t.Errorf("symbolic HEAD: got %q, %v", got, err)
+	}
+
+	// A branch that only exists in packed-refs.
+	packed := "2222222222222222222222222222222222222222"
+	os.WriteFile(filepath.Join(gitDir, "packed-refs"), []byte(packed+" refs/heads/packed\n"), 0644)
+	writeRef(t, gitDir, "HEAD", "ref: refs/heads/packed")
+	if got, err := ResolveHead(gitDir); err != nil || got != packed {
+		t.Errorf("packed branch: got %q, %v", got, err)
+	}
+
+	// Detached HEAD.
+	detached := "3333333333333333333333333333333333333333"
+	writeRef(t, gitDir, "HEAD", detached)
+	if got, err := ResolveHead(gitDir); err != nil || got != detached {
+		t.Errorf("detached HEAD: got %q, %v", got, err)
+	}
+
+	writeRef(t, gitDir, "HEAD", "garbage")
+	if _, err := ResolveHead(gitDir); err == nil || errors.Is(err, ErrUnbornBranch) {
+		t.Errorf("garbage HEAD: got %v, want a non-unborn error", err)
+	}
+}
+
+func TestHeadTree(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := repo.GitDir
+
+	if tree, err := HeadTree(gitDir); err != nil || tree != "" {
+		t.Errorf("unborn HEAD: got %q, %v; want empty", tree, err)
+	}
+
+	tree, err := object.WriteObject(gitDir, object.TypeTree, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := object.Signature{Name: "A U Thor", Email: "author@example.com", When: 1700000000, Timezone: "+0000"}
+	commit := &object.Commit{Tree: tree, Author: sig, Committer: sig, Message: "init\n"}
+	sha, err := object.WriteObject(gitDir, object.TypeCommit, commit.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRef(t, gitDir, "refs/heads/main", sha)
+
+	if got, err := HeadTree(gitDir); err != nil || got != tree {
+		t.Errorf("HeadTree() = %q, %v; want %s", got, err, tree)
+	}
+	if got, err := repo.Refs().HeadTree(); err != nil || got != tree {
+		t.Errorf("RefStore.HeadTree() = %q, %v; want %s", got, err, tree)
+	}
+
+	writeRef(t, gitDir, "HEAD", tree)
+	if _, err := HeadTree(gitDir); err == nil {
+		t.Error("HeadTree() with HEAD at a tree: want error")
+	}
+}
+
+func TestResolveHead_SymrefCycle(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRef(t, repo.GitDir, "refs/heads/main", "ref: refs/heads/other")
+	writeRef(t, repo.GitDir, "refs/heads/other", "ref: refs/heads/main")
+
+	if _, err := ResolveHead(repo.GitDir); err == nil || !strings.Contains(err.Error(), "too many levels") {
+		t.Errorf("got %v, want symref depth error", err)
+	}
+}
@@ -7,18 +7,35 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/elliota43/rev/internal/object"
 )
 
 var (
 	ErrRepoAlreadyExists = errors.New("repository already exists")
+	// ErrNotARepository is returned by Open and FindGitDir when there is
+	// no repository where one was expected.
+	ErrNotARepository = errors.New("not a git repository")
 )
 
 // Repository represents an initialized git repository.
 type Repository struct {
-	// Path is the working directory (the repo root).
+	// Path is the working directory (the repo root). It is empty for a
+	// bare repository, which has no working tree.
 	Path string
-	// GitDir is the path to the .git directory.
+	// GitDir is the path to the .git directory, or to the repository
+	// itself if it is bare.
 	GitDir string
+
+	refs *RefStore
+}
+
+// Refs returns the repository's ref cache, creating it on first use.
+func (r *Repository) Refs() *RefStore {
+	if r.refs == nil {
+		r.refs = NewRefStore(r.GitDir)
+	}
+	return r.refs
 }
 
 // Init initializes a new git repository at the given path.
@@ -40,7 +57,7 @@ func Init(path string) (*Repository, error) {
 		return nil, err
 	}
 
-	if err := createInitialFiles(gitDir); err != nil {
+	if err := createInitialFiles(gitDir, false); err != nil {
 		return nil, err
 	}
 
@@ -50,14 +67,139 @@ func Init(path string) (*Repository, error) {
 	}, nil
 }
 
-// Open finds the nearest .git directory by walking up from startDir
-// and returns a Repository handle.  If startDir is empty, uses the
-// current working directory.
-func Open(startDir string) (*Repository, error) {
+// InitBare initializes a bare repository at the given path: the objects,
+// refs, HEAD, and config that Init puts under .git go directly in path,
+// and there is no working tree. The directory may already exist but must
+// not already hold a repository.
+func InitBare(path string) (*Repository, error) {
+	gitDir, err := resolveRepoRoot(path)
+	if err != nil {
+		return nil, fmt.Errorf("resolving repo root: %w", err)
+	}
+
+	if exists(filepath.Join(gitDir, "HEAD")) {
+		return nil, ErrRepoAlreadyExists
+	}
+
+	if err := createDirStructure(gitDir); err != nil {
+		return nil, err
+	}
+
+	if err := createInitialFiles(gitDir, true); err != nil {
+		return nil, err
+	}
+
+	return &Repository{GitDir: gitDir}, nil
+}
+
+// Open returns a handle to the repository at path. Path must be the
+// repository itself: either its working directory, whose .git is used, or
+// the git dir directly (a .git directory or a bare repository). Anything
+// that doesn't look like a git dir (HEAD and objects/ present) is
+// ErrNotARepository.
+//
+// With an empty path the repository comes from the environment, as in
+// git: GIT_DIR if set, otherwise a search upward from the current
+// directory (see FindGitDir). GIT_WORK_TREE then overrides the working
+// tree; without it, a GIT_DIR repository that isn't bare is worked on
+// from the current directory. An explicit path ignores both variables.
+func Open(path string) (*Repository, error) {
+	if path == "" {
+		return openFromEnv()
+	}
+
+	dir, err := resolveRepoRoot(path)
+	if err != nil {
+		return nil, fmt.Errorf("resolving repo root: %w", err)
+	}
+	if gitDir := filepath.Join(dir, ".git"); isGitDir(gitDir) {
+		return openGitDir(gitDir)
+	}
+	if isGitDir(dir) {
+		return openGitDir(dir)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotARepository, path)
+}
+
+// openFromEnv implements Open(""), honoring GIT_DIR and GIT_WORK_TREE.
+func openFromEnv() (*Repository, error) {
+	gitDir, err := FindGitDir("")
+	if err != nil {
+		return nil, err
+	}
+	repo, err := openGitDir(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if workTree := os.Getenv("GIT_WORK_TREE"); workTree != "" {
+		if repo.Path, err = resolveRepoRoot(workTree); err != nil {
+			return nil, fmt.Errorf("resolving GIT_WORK_TREE: %w", err)
+		}
+	} else if os.Getenv("GIT_DIR") != "" && repo.Path != "" {
+		if repo.Path, err = os.Getwd(); err != nil {
+			return nil, fmt.Errorf("getting working directory: %w", err)
+		}
+	}
+	return repo, nil
+}
+
+// openGitDir returns a handle for a git dir known to exist. The working
+// tree is the git dir's parent unless core.bare says there is none.
+// core.maxObjectSize and core.deltaBaseCacheLimit, if set, become
+// object.MaxObjectSize and object.DeltaBaseCacheLimit.
+func openGitDir(gitDir string) (*Repository, error) {
+	cfg, err := ParseConfig(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	bare, _, err := cfg.GetBool("core", "bare")
+	if err != nil {
+		return nil, err
+	}
+	limit, found, err := cfg.GetInt("core", "maxobjectsize")
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		object.MaxObjectSize = limit
+	}
+	cacheLimit, found, err := cfg.GetInt("core", "deltabasecachelimit")
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		object.DeltaBaseCacheLimit = cacheLimit
+	}
+
+	repo := &Repository{GitDir: gitDir}
+	if !bare {
+		repo.Path = filepath.Dir(gitDir)
+	}
+	return repo, nil
+}
+
+// FindGitDir walks up from startDir, or the current working directory if
+// startDir is empty, and returns the first git dir it finds: a .git
+// directory, or a directory that is itself a bare repository. If GIT_DIR
+// is set, it is returned instead without searching, provided it is a git
+// dir.
+func FindGitDir(startDir string) (string, error) {
+	if env := os.Getenv("GIT_DIR"); env != "" {
+		gitDir, err := resolveRepoRoot(env)
+		if err != nil {
+			return "", fmt.Errorf("resolving GIT_DIR: %w", err)
+		}
+		if !isGitDir(gitDir) {
+			return "", fmt.Errorf("%w: GIT_DIR=%s", ErrNotARepository, env)
+		}
+		return gitDir, nil
+	}
+
 	if startDir == "" {
 		wd, err := os.Getwd()
 		if err != nil {
-			return nil, fmt.Errorf("getting working directory: %w", err)
+			return "", fmt.Errorf("getting working directory: %w", err)
 		}
 		startDir = wd
 	}
@@ -65,21 +207,32 @@ func Open(startDir string) (*Repository, error) {
 	dir := startDir
 	for {
 		candidate := filepath.Join(dir, ".git")
-		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
-			return &Repository{
-				Path:   dir,
-				GitDir: candidate,
-			}, nil
+		if isGitDir(candidate) {
+			return candidate, nil
+		}
+		if isGitDir(dir) {
+			return dir, nil
 		}
 
 		parent := filepath.Dir(dir)
 		if parent == dir {
-			return nil, fmt.Errorf("not a git repository (or any parent up to /)")
+			return "", fmt.Errorf("%w (or any parent up to /)", ErrNotARepository)
 		}
 		dir = parent
 	}
 }
 
+// isGitDir reports whether dir has the HEAD file and objects directory
+// every repository starts with.
+func isGitDir(dir string) bool {
+	head, err := os.Stat(filepath.Join(dir, "HEAD"))
+	if err != nil || head.IsDir() {
+		return false
+	}
+	objects, err := os.Stat(filepath.Join(dir, "objects"))
+	return err == nil && objects.IsDir()
+}
+
 // resolveRepoRoot converts user-supplied path into an absolute directory path.
 func resolveRepoRoot(path string) (string, error) {
 	if path == "" || path == "." {
@@ -118,17 +271,17 @@ func createDirStructure(gitDir string) error {
 }
 
 // createInitialFiles writes HEAD, config, and description.
-func createInitialFiles(gitDir string) error {
+func createInitialFiles(gitDir string, bare bool) error {
 	files := map[string]string{
 		"HEAD":        "ref: refs/heads/main\n",
 		"description": "Unnamed repository; edit this file 'description' to name the repository.\n",
-		"config": `[core]
+		"config": fmt.Sprintf(`[core]
 repositoryformatversion = 0
 filemode = true
-bare = false
+bare = %t
 logallrefupdates = true
 ignorecase = true
-precomposeunicode = true`,
+precomposeunicode = true`, bare),
 	}
 
 	for name, content := range files {
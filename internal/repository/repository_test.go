@@ -1,9 +1,12 @@
 package repository
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/elliota43/rev/internal/object"
 )
 
 func TestInit(t *testing.T) {
@@ -67,6 +70,47 @@ func TestInit_AlreadyExists(t *testing.T) {
 	}
 }
 
+func TestInitBare(t *testing.T) {
+	tmpDir := filepath.Join(t.TempDir(), "project.git")
+
+	repo, err := InitBare(tmpDir)
+	if err != nil {
+		t.Fatalf("InitBare() error: %v", err)
+	}
+	if repo.GitDir != tmpDir || repo.Path != "" {
+		t.Errorf("got Path %q GitDir %q", repo.Path, repo.GitDir)
+	}
+	for _, name := range []string{"HEAD", "config", "objects/pack", "refs/heads"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Errorf("expected %s at the top level: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, ".git")); !os.IsNotExist(err) {
+		t.Errorf("bare repo should have no .git, stat err: %v", err)
+	}
+	cfg, _ := ParseConfig(tmpDir)
+	if bare, _, _ := cfg.GetBool("core", "bare"); !bare {
+		t.Error("core.bare should be true")
+	}
+
+	if _, err := InitBare(tmpDir); !errors.Is(err, ErrRepoAlreadyExists) {
+		t.Errorf("second InitBare(): got %v, want ErrRepoAlreadyExists", err)
+	}
+
+	// Open recognizes it both directly and from inside it.
+	opened, err := Open(tmpDir)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if opened.GitDir != tmpDir || opened.Path != "" {
+		t.Errorf("Open(): got Path %q GitDir %q", opened.Path, opened.GitDir)
+	}
+	gitDir, err := FindGitDir(filepath.Join(tmpDir, "refs", "heads"))
+	if err != nil || gitDir != tmpDir {
+		t.Errorf("FindGitDir() inside bare repo: got %q, %v", gitDir, err)
+	}
+}
+
 func TestOpen(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -75,32 +119,143 @@ func TestOpen(t *testing.T) {
 		t.Fatalf("Init() error: %v", err)
 	}
 
-	// Open from the repo root
-	repo, err := Open(tmpDir)
+	// Open the repo root, or its .git directory directly
+	for _, path := range []string{tmpDir, created.GitDir} {
+		repo, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open(%s) error: %v", path, err)
+		}
+		if repo.GitDir != created.GitDir || repo.Path != tmpDir {
+			t.Errorf("Open(%s): got %q, %q", path, repo.Path, repo.GitDir)
+		}
+	}
+
+	// An explicit path is not searched upward
+	subDir := filepath.Join(tmpDir, "src", "pkg")
+	os.MkdirAll(subDir, 0755)
+	if _, err := Open(subDir); !errors.Is(err, ErrNotARepository) {
+		t.Errorf("Open() of subdir: got %v, want ErrNotARepository", err)
+	}
+}
+
+func TestOpen_MaxObjectSize(t *testing.T) {
+	t.Cleanup(func() { object.MaxObjectSize = object.DefaultMaxObjectSize })
+	repo, err := Init(t.TempDir())
 	if err != nil {
-		t.Fatalf("Open() error: %v", err)
+		t.Fatal(err)
 	}
-	if repo.GitDir != created.GitDir {
-		t.Errorf("GitDir: got %q, want %q", repo.GitDir, created.GitDir)
+	if _, err := Open(repo.Path); err != nil {
+		t.Fatal(err)
+	}
+	if object.MaxObjectSize != object.DefaultMaxObjectSize {
+		t.Errorf("unset: MaxObjectSize = %d, want %d", object.MaxObjectSize, object.DefaultMaxObjectSize)
+	}
+
+	f, _ := os.OpenFile(filepath.Join(repo.GitDir, "config"), os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("[core]\n\tmaxObjectSize = 2m\n")
+	f.Close()
+	if _, err := Open(repo.Path); err != nil {
+		t.Fatal(err)
+	}
+	if object.MaxObjectSize != 2<<20 {
+		t.Errorf("maxObjectSize = 2m: MaxObjectSize = %d", object.MaxObjectSize)
+	}
+}
+
+func TestOpen_DeltaBaseCacheLimit(t *testing.T) {
+	t.Cleanup(func() { object.DeltaBaseCacheLimit = object.DefaultDeltaBaseCacheLimit })
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.OpenFile(filepath.Join(repo.GitDir, "config"), os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("[core]\n\tdeltaBaseCacheLimit = 1m\n")
+	f.Close()
+	if _, err := Open(repo.Path); err != nil {
+		t.Fatal(err)
+	}
+	if object.DeltaBaseCacheLimit != 1<<20 {
+		t.Errorf("deltaBaseCacheLimit = 1m: DeltaBaseCacheLimit = %d", object.DeltaBaseCacheLimit)
+	}
+}
+
+func TestFindGitDir(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	created, err := Init(tmpDir)
+	if err != nil {
+		t.Fatalf("Init() error: %v", err)
 	}
 
-	// Open from a subdirectory
 	subDir := filepath.Join(tmpDir, "src", "pkg")
 	os.MkdirAll(subDir, 0755)
 
-	repo, err = Open(subDir)
+	gitDir, err := FindGitDir(subDir)
 	if err != nil {
-		t.Fatalf("Open() from subdir error: %v", err)
+		t.Fatalf("FindGitDir() from subdir error: %v", err)
 	}
-	if repo.GitDir != created.GitDir {
-		t.Errorf("GitDir from subdir: got %q, want %q", repo.GitDir, created.GitDir)
+	if gitDir != created.GitDir {
+		t.Errorf("GitDir from subdir: got %q, want %q", gitDir, created.GitDir)
 	}
 }
 
 func TestOpen_NotARepo(t *testing.T) {
 	tmpDir := t.TempDir()
 	_, err := Open(tmpDir)
-	if err == nil {
-		t.Error("Open() in non-repo should return error")
+	if !errors.Is(err, ErrNotARepository) {
+		t.Errorf("Open() in non-repo: got %v, want ErrNotARepository", err)
+	}
+	if _, err := FindGitDir(tmpDir); !errors.Is(err, ErrNotARepository) {
+		t.Errorf("FindGitDir() in non-repo: got %v, want ErrNotARepository", err)
+	}
+
+	// A .git directory without HEAD and objects/ doesn't count.
+	os.Mkdir(filepath.Join(tmpDir, ".git"), 0755)
+	if _, err := Open(tmpDir); !errors.Is(err, ErrNotARepository) {
+		t.Errorf("Open() of empty .git: got %v, want ErrNotARepository", err)
+	}
+}
+
+func TestOpen_GitDirEnv(t *testing.T) {
+	repoDir := t.TempDir()
+	created, err := Init(repoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elsewhere := t.TempDir()
+	t.Chdir(elsewhere)
+
+	// Without GIT_DIR the search from an unrelated directory fails.
+	if _, err := Open(""); !errors.Is(err, ErrNotARepository) {
+		t.Fatalf("Open() without GIT_DIR: got %v, want ErrNotARepository", err)
+	}
+
+	// GIT_DIR short-circuits the search; the current directory becomes
+	// the working tree.
+	t.Setenv("GIT_DIR", created.GitDir)
+	repo, err := Open("")
+	if err != nil {
+		t.Fatalf("Open() with GIT_DIR: %v", err)
+	}
+	if repo.GitDir != created.GitDir || repo.Path != elsewhere {
+		t.Errorf("GIT_DIR: got Path %q GitDir %q", repo.Path, repo.GitDir)
+	}
+
+	// GIT_WORK_TREE names the working tree explicitly.
+	t.Setenv("GIT_WORK_TREE", repoDir)
+	if repo, err = Open(""); err != nil || repo.Path != repoDir {
+		t.Errorf("GIT_WORK_TREE: got %+v, %v", repo, err)
+	}
+
+	// An explicit path wins over the environment.
+	other := t.TempDir()
+	otherRepo, _ := Init(other)
+	if repo, err = Open(other); err != nil || repo.GitDir != otherRepo.GitDir || repo.Path != other {
+		t.Errorf("explicit path: got %+v, %v", repo, err)
+	}
+
+	t.Setenv("GIT_DIR", elsewhere)
+	if _, err := Open(""); !errors.Is(err, ErrNotARepository) {
+		t.Errorf("GIT_DIR at a non-repository: got %v, want ErrNotARepository", err)
 	}
 }
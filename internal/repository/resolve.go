@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/trace"
+)
+
+// ErrUnknownRevision is returned by ResolveRef when a name matches no ref
+// and no object.
+var ErrUnknownRevision = errors.New("unknown revision")
+
+// refSearchPath lists where a short ref name is looked for, in git's
+// order of precedence.
+var refSearchPath = []string{
+	"%s",
+	"refs/%s",
+	"refs/tags/%s",
+	"refs/heads/%s",
+	"refs/remotes/%s",
+	"refs/remotes/%s/HEAD",
+}
+
+// ResolveRef resolves a revision name to a full object hash. It accepts
+// HEAD, full ref names, short branch, tag, and remote names (searched in
+// git's precedence order, so a tag shadows a branch of the same name),
+// and full or abbreviated hashes. A 40-char hash is taken as-is if it
+// exists; ref names win over abbreviated hashes, as in git. "@" is HEAD,
+// "<ref>@{n}" is the value ref had n changes ago by its reflog (the
+// current branch's for a bare "@{n}", or HEAD's if detached), and
+// "@{-n}" is the branch or commit checked out before the nth most recent
+// checkout. Any of these may be followed by "~<n>", the nth first-parent
+// ancestor, and "^<n>", the nth parent ("^0" being the commit itself),
+// with n defaulting to 1, as in "HEAD~", "main~2^2", or "tags/v1^0".
+func ResolveRef(gitDir, name string) (string, error) {
+	hash, err := resolveRef(gitDir, name)
+	if trace.Enabled() {
+		if err != nil {
+			trace.Log("refs", "resolve %s: %v", name, err)
+		} else {
+			trace.Log("refs", "resolve %s = %s", name, hash)
+		}
+	}
+	return hash, err
+}
+
+func resolveRef(gitDir, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty revision name")
+	}
+
+	if isHash(name) {
+		return object.ResolveHash(gitDir, name)
+	}
+	if base, steps := splitAncestry(name); steps != "" {
+		hash, err := resolveRef(gitDir, base)
+		if err != nil {
+			return "", err
+		}
+		return walkAncestry(gitDir, name, hash, steps)
+	}
+	if name == "@" {
+		name = "HEAD"
+	}
+	if ref, n, ok := parseReflogSuffix(name); ok {
+		return resolveReflog(gitDir, ref, n)
+	}
+
+	for _, pattern := range refSearchPath {
+		// Only refs and pseudorefs (HEAD, ORIG_HEAD, ...) live directly
+		// under the git dir; "config" and friends are not revisions.
+		if pattern == "%s" && !strings.HasPrefix(name, "refs/") && !isPseudoref(name) {
+			continue
+		}
+		hash, ok, err := readRef(gitDir, fmt.Sprintf(pattern, name))
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return hash, nil
+		}
+	}
+
+	if isHexPrefix(name) {
+		hash, err := object.ResolveHash(gitDir, name)
+		if errors.Is(err, object.ErrNotFound) {
+			return "", fmt.Errorf("%w: %s", ErrUnknownRevision, name)
+		}
+		return hash, err
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownRevision, name)
+}
+
+// splitAncestry splits name into the revision it starts from and the
+// trailing "~<n>" and "^<n>" steps. steps is empty if name has none, or if
+// what follows the first "~" or "^" isn't only such steps.
+func splitAncestry(name string) (base, steps string) {
+	i := strings.IndexAny(name, "~^")
+	if i <= 0 {
+		return name, ""
+	}
+	for _, c := range name[i:] {
+		if !(c == '~' || c == '^' || c >= '0' && c <= '9') {
+			return name, ""
+		}
+	}
+	return name[:i], name[i:]
+}
+
+// walkAncestry follows steps from hash, which name resolved to: "~<n>"
+// goes back n first parents and "^<n>" to the nth parent. Annotated tags
+// along the way are peeled to their commits.
+func walkAncestry(gitDir, name, hash, steps string) (string, error) {
+	for steps != "" {
+		op := steps[0]
+		digits := strings.TrimLeft(steps[1:], "0123456789")
+		count := steps[1 : len(steps)-len(digits)]
+		steps = digits
+		n := 1
+		if count != "" {
+			var err error
+			if n, err = strconv.Atoi(count); err != nil {
+				return "", fmt.Errorf("%w: %s", ErrUnknownRevision, name)
+			}
+		}
+
+		commit, err := Peel(gitDir, hash, object.TypeCommit)
+		if err != nil {
+			return "", err
+		}
+		if op == '^' {
+			if n == 0 {
+				hash = commit
+				continue
+			}
+			parents, err := commitParents(gitDir, commit)
+			if err != nil {
+				return "", err
+			}
+			if n > len(parents) {
+				return "", fmt.Errorf("%w: %s", ErrUnknownRevision, name)
+			}
+			hash = parents[n-1]
+			continue
+		}
+		for range n {
+			parents, err := commitParents(gitDir, commit)
+			if err != nil {
+				return "", err
+			}
+			if len(parents) == 0 {
+				return "", fmt.Errorf("%w: %s", ErrUnknownRevision, name)
+			}
+			commit = parents[0]
+		}
+		hash = commit
+	}
+	return hash, nil
+}
+
+// commitParents returns the parents of commit.
+func commitParents(gitDir, commit string) ([]string, error) {
+	obj, err := object.Read(gitDir, commit)
+	if err != nil {
+		return nil, err
+	}
+	c, err := object.ParseCommit(obj)
+	if err != nil {
+		return nil, err
+	}
+	return c.Parents, nil
+}
+
+// isPseudoref reports whether name has the all-caps form of HEAD,
+// FETCH_HEAD, ORIG_HEAD, and similar refs kept at the top of the git dir.
+func isPseudoref(name string) bool {
+	for _, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c == '_') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// isHexPrefix reports whether s could be an abbreviated object hash.
+func isHexPrefix(s string) bool {
+	if len(s) < 4 || len(s) > object.SHA256.HexLen() {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// maxPeelDepth bounds how many annotated tags Peel follows, so a tag
+// chain that loops can't hang it.
+const maxPeelDepth = 32
+
+// Peel resolves rev and dereferences it until it reaches an object of
+// type want: annotated tags are followed to their targets, and a commit
+// yields its tree when want is a tree. It returns the object's hash, and
+// errors if rev leads somewhere else.
+func Peel(gitDir, rev string, want object.Type) (string, error) {
+	hash, err := ResolveRef(gitDir, rev)
+	if err != nil {
+		return "", err
+	}
+	for range maxPeelDepth {
+		obj, err := object.Read(gitDir, hash)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case obj.Type == want:
+			return hash, nil
+		case obj.Type == object.TypeTag:
+			tag, err := object.ParseTag(obj)
+			if err != nil {
+				return "", err
+			}
+			hash = tag.Object
+		case obj.Type == object.TypeCommit && want == object.TypeTree:
+			c, err := object.ParseCommit(obj)
+			if err != nil {
+				return "", err
+			}
+			hash = c.Tree
+		default:
+			return "", fmt.Errorf("%s is a %s, not a %s", rev, obj.Type, want)
+		}
+	}
+	return "", fmt.Errorf("%s: too many levels of tags", rev)
+}
+
+// LookupPath returns the entry at the slash-separated path p in the tree
+// rev peels to.
+func LookupPath(gitDir, rev, p string) (object.TreeEntry, error) {
+	tree, err := Peel(gitDir, rev, object.TypeTree)
+	if err != nil {
+		return object.TreeEntry{}, err
+	}
+	entry := object.TreeEntry{Mode: object.ModeTree, Hash: tree}
+	for _, name := range strings.Split(p, "/") {
+		found := false
+		if entry.Mode == object.ModeTree {
+			entries, err := object.ReadTree(gitDir, entry.Hash)
+			if err != nil {
+				return object.TreeEntry{}, err
+			}
+			for _, e := range entries {
+				if e.Name == name {
+					entry, found = e, true
+					break
+				}
+			}
+		}
+		if !found {
+			return object.TreeEntry{}, fmt.Errorf("path '%s' does not exist in '%s'", p, rev)
+		}
+	}
+	return entry, nil
+}
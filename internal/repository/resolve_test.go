@@ -0,0 +1,261 @@
+package repository
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/trace"
+)
+
+// writeTestObject stores a blob in the repository and returns its hash.
+func writeTestObject(t *testing.T, gitDir, content string) string {
+	t.Helper()
+	sha, err := object.WriteObject(gitDir, object.TypeBlob, []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+func TestResolveRef(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := repo.GitDir
+
+	mainHash := writeTestObject(t, gitDir, "main\n")
+	tagHash := writeTestObject(t, gitDir, "tag\n")
+	remoteHash := writeTestObject(t, gitDir, "remote\n")
+
+	writeRef(t, gitDir, "refs/heads/main", mainHash)
+	writeRef(t, gitDir, "refs/heads/dup", mainHash)
+	writeRef(t, gitDir, "refs/tags/dup", tagHash)
+	writeRef(t, gitDir, "refs/tags/v1", tagHash)
+	writeRef(t, gitDir, "refs/remotes/origin/main", remoteHash)
+	writeRef(t, gitDir, "refs/remotes/origin/HEAD", "ref: refs/remotes/origin/main")
+
+	tests := []struct {
+		name, want string
+	}{
+		{"HEAD", mainHash},
+		{"main", mainHash},
+		{"refs/heads/main", mainHash},
+		{"heads/main", mainHash},
+		{"v1", tagHash},
+		{"dup", tagHash}, // tags take precedence over branches
+		{"origin/main", remoteHash},
+		{"origin", remoteHash}, // refs/remotes/origin/HEAD
+		{mainHash, mainHash},
+		{mainHash[:7], mainHash},
+	}
+	for _, tt := range tests {
+		got, err := ResolveRef(gitDir, tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveRef(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	for _, name := range []string{"nope", "heads", "config", "description", "deadbeef", strings.Repeat("0", 40)} {
+		if _, err := ResolveRef(gitDir, name); err == nil {
+			t.Errorf("ResolveRef(%q): expected error", name)
+		}
+	}
+	if _, err := ResolveRef(gitDir, "nope"); !errors.Is(err, ErrUnknownRevision) {
+		t.Errorf("ResolveRef(nope): got %v, want ErrUnknownRevision", err)
+	}
+}
+
+func TestResolveRef_Trace(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := writeTestObject(t, repo.GitDir, "main\n")
+	writeRef(t, repo.GitDir, "refs/heads/main", hash)
+
+	var buf bytes.Buffer
+	trace.SetOutput(&buf)
+	t.Cleanup(func() { trace.SetOutput(nil) })
+	ResolveRef(repo.GitDir, "HEAD")
+	ResolveRef(repo.GitDir, "nope")
+
+	for _, want := range []string{
+		"refs: read HEAD -> refs/heads/main (symbolic)\n",
+		"refs: read refs/heads/main (loose)\n",
+		"refs: resolve HEAD = " + hash + "\n",
+		"refs: resolve nope: unknown revision: nope\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("trace output missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestResolveRef_Ambiguous(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := writeTestObject(t, repo.GitDir, "hello\n")
+
+	// A second loose file sharing the 4-char prefix.
+	dir := filepath.Join(repo.GitDir, "objects", hash[:2])
+	if err := os.WriteFile(filepath.Join(dir, hash[2:4]+strings.Repeat("f", 36)), nil, 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ResolveRef(repo.GitDir, hash[:4])
+	if err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("got %v, want ambiguity error", err)
+	}
+}
+
+func TestResolveRef_Ancestry(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := repo.GitDir
+
+	// root <- a <- merge <- top, with side (off root) merged in as
+	// merge's second parent, and an annotated tag v1 on top.
+	root := writeLogCommit(t, gitDir, "root", 1)
+	a := writeLogCommit(t, gitDir, "a", 2, root)
+	side := writeLogCommit(t, gitDir, "side", 3, root)
+	merge := writeLogCommit(t, gitDir, "merge", 4, a, side)
+	top := writeLogCommit(t, gitDir, "top", 5, merge)
+	writeRef(t, gitDir, "refs/heads/main", top)
+	tag, err := object.WriteObject(gitDir, object.TypeTag, []byte("object "+top+"\ntype commit\ntag v1\n\nv1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRef(t, gitDir, "refs/tags/v1", tag)
+
+	tests := []struct {
+		name, want string
+	}{
+		{"main~0", top},
+		{"main~", merge},
+		{"HEAD~1", merge},
+		{"@~2", a},
+		{"main~3", root},
+		{"main^", merge},
+		{"main^^", a},
+		{"main~1^2", side},
+		{"main^1^2^", root},
+		{"main^0", top},
+		{"v1^0", top},
+		{"v1~2", a},
+		{top[:7] + "~1", merge},
+	}
+	for _, tt := range tests {
+		got, err := ResolveRef(gitDir, tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveRef(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	for _, name := range []string{"main~4", "main^2", "main~1^3", "nope~1", "main~x"} {
+		if _, err := ResolveRef(gitDir, name); !errors.Is(err, ErrUnknownRevision) {
+			t.Errorf("ResolveRef(%q): got %v, want ErrUnknownRevision", name, err)
+		}
+	}
+}
+
+func TestPeel(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := repo.GitDir
+
+	blob := writeTestObject(t, gitDir, "blob\n")
+	tree, err := object.WriteObject(gitDir, object.TypeTree, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := object.Signature{Name: "A U Thor", Email: "author@example.com", When: 1700000000, Timezone: "+0000"}
+	commit, err := object.WriteObject(gitDir, object.TypeCommit, (&object.Commit{Tree: tree, Author: sig, Committer: sig, Message: "c\n"}).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := object.WriteObject(gitDir, object.TypeTag, (&object.Tag{Object: commit, Type: object.TypeCommit, Tag: "v1", Tagger: sig, Message: "t\n"}).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRef(t, gitDir, "refs/tags/v1", tag)
+
+	tests := []struct {
+		rev  string
+		want object.Type
+		hash string
+	}{
+		{"v1", object.TypeTag, tag},
+		{"v1", object.TypeCommit, commit},
+		{"v1", object.TypeTree, tree},
+		{commit, object.TypeTree, tree},
+		{blob, object.TypeBlob, blob},
+	}
+	for _, tt := range tests {
+		got, err := Peel(gitDir, tt.rev, tt.want)
+		if err != nil || got != tt.hash {
+			t.Errorf("Peel(%.7s, %s) = %q, %v; want %q", tt.rev, tt.want, got, err, tt.hash)
+		}
+	}
+
+	for _, bad := range []struct {
+		rev  string
+		want object.Type
+	}{{blob, object.TypeTree}, {tree, object.TypeCommit}, {"v1", object.TypeBlob}} {
+		if _, err := Peel(gitDir, bad.rev, bad.want); err == nil {
+			t.Errorf("Peel(%.7s, %s): want error", bad.rev, bad.want)
+		}
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := repo.GitDir
+
+	blob := writeTestObject(t, gitDir, "blob\n")
+	subBody, err := object.EncodeTree([]object.TreeEntry{{Mode: object.ModeFile, Name: "f", Hash: blob}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := object.WriteObject(gitDir, object.TypeTree, subBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootBody, err := object.EncodeTree([]object.TreeEntry{
+		{Mode: object.ModeTree, Name: "d", Hash: sub},
+		{Mode: object.ModeFile, Name: "top", Hash: blob},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := object.WriteObject(gitDir, object.TypeTree, rootBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, want := range map[string]string{"d/f": blob, "d": sub, "top": blob} {
+		e, err := LookupPath(gitDir, root, p)
+		if err != nil || e.Hash != want {
+			t.Errorf("LookupPath(%q) = %s, %v, want %s", p, e.Hash, err, want)
+		}
+	}
+	for _, p := range []string{"missing", "top/f", "d/f/g"} {
+		if _, err := LookupPath(gitDir, root, p); err == nil {
+			t.Errorf("LookupPath(%q): want error", p)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadSymbolicRef returns the ref that the symbolic ref name points to,
+// e.g. "refs/heads/main" for a HEAD containing "ref: refs/heads/main".
+// ok is false when name holds a hash instead (a detached HEAD).
+func ReadSymbolicRef(gitDir, name string) (target string, ok bool, err error) {
+	data, err := os.ReadFile(filepath.Join(gitDir, filepath.FromSlash(name)))
+	if err != nil {
+		return "", false, fmt.Errorf("reading %s: %w", name, err)
+	}
+	target, ok = strings.CutPrefix(strings.TrimSpace(string(data)), "ref: ")
+	if !ok {
+		return "", false, nil
+	}
+	return target, true, nil
+}
+
+// WriteSymbolicRef makes name a symbolic ref to target, writing exactly
+// "ref: <target>\n". target must be a valid name under refs/; it doesn't
+// have to exist yet, which is how a fresh repository's HEAD works.
+func WriteSymbolicRef(gitDir, name, target string) error {
+	if err := CheckRefName(target); err != nil {
+		return err
+	}
+	if name != "HEAD" {
+		if err := CheckRefName(name); err != nil {
+			return err
+		}
+	}
+	return writeRefFile(gitDir, name, "ref: "+target+"\n")
+}
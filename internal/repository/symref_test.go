@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSymbolicRef(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, ok, err := ReadSymbolicRef(repo.GitDir, "HEAD")
+	if err != nil || !ok || target != "refs/heads/main" {
+		t.Errorf("fresh HEAD: got %q, %v, %v", target, ok, err)
+	}
+
+	if err := WriteSymbolicRef(repo.GitDir, "HEAD", "refs/heads/feature"); err != nil {
+		t.Fatalf("WriteSymbolicRef() error: %v", err)
+	}
+	if got := readRefFile(t, repo.GitDir, "HEAD"); got != "ref: refs/heads/feature\n" {
+		t.Errorf("HEAD contents: got %q", got)
+	}
+	if name, _, _ := CurrentBranch(repo.GitDir); name != "feature" {
+		t.Errorf("CurrentBranch: got %q", name)
+	}
+
+	writeRef(t, repo.GitDir, "HEAD", strings.Repeat("1", 40))
+	if _, ok, err := ReadSymbolicRef(repo.GitDir, "HEAD"); err != nil || ok {
+		t.Errorf("detached HEAD: got ok=%v, err=%v", ok, err)
+	}
+
+	if err := WriteSymbolicRef(repo.GitDir, "HEAD", "main"); err == nil {
+		t.Error("expected error for a target outside refs/")
+	}
+	if _, _, err := ReadSymbolicRef(repo.GitDir, "refs/heads/missing"); err == nil {
+		t.Error("expected error for a missing ref")
+	}
+}
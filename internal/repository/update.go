@@ -0,0 +1,343 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+var (
+	// ErrBranchExists is returned by CreateBranch when the branch is
+	// already there.
+	ErrBranchExists = errors.New("branch already exists")
+	// ErrCurrentBranch is returned by DeleteBranch for the checked-out
+	// branch.
+	ErrCurrentBranch = errors.New("cannot delete the checked-out branch")
+	// ErrStaleRef is returned by UpdateRefs when a ref no longer has the
+	// value an update expected.
+	ErrStaleRef = errors.New("ref has changed")
+)
+
+// UpdateRef points ref ("refs/heads/main", "HEAD", ...) at sha. If ref is
+// symbolic, the ref it points to is updated instead, so updating HEAD
+// moves the current branch. The write goes through a <ref>.lock file
+// renamed into place, as git does, so readers never see a partial ref
+// and two concurrent writers can't both succeed.
+func UpdateRef(gitDir, ref, sha string) error {
+	if !isHash(sha) {
+		return fmt.Errorf("update %s: invalid object name %q", ref, sha)
+	}
+
+	target, err := followSymref(gitDir, ref)
+	if err != nil {
+		return err
+	}
+	if target != "HEAD" {
+		if err := CheckRefName(target); err != nil {
+			return err
+		}
+	}
+	return writeRefFile(gitDir, target, sha+"\n")
+}
+
+// DetachHead points HEAD straight at sha, leaving the branch it was on
+// where it is.
+func DetachHead(gitDir, sha string) error {
+	if !isHash(sha) {
+		return fmt.Errorf("detach HEAD: invalid object name %q", sha)
+	}
+	return writeRefFile(gitDir, "HEAD", sha+"\n")
+}
+
+// CreateBranch creates refs/heads/<name> pointing at the commit startSha.
+// It fails with ErrBranchExists if the branch already exists, and checks
+// that startSha names a commit before writing anything.
+func CreateBranch(gitDir, name, startSha string) error {
+	ref := "refs/heads/" + name
+	if err := CheckRefName(ref); err != nil {
+		return err
+	}
+
+	objType, _, err := object.ReadHeader(gitDir, startSha)
+	if err != nil {
+		return fmt.Errorf("create branch %s: %w", name, err)
+	}
+	if objType != object.TypeCommit {
+		return fmt.Errorf("create branch %s: %s is a %s, not a commit", name, startSha, objType)
+	}
+	full, err := object.ResolveHash(gitDir, startSha)
+	if err != nil {
+		return err
+	}
+
+	if _, ok, err := readRef(gitDir, ref); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("%w: %s", ErrBranchExists, name)
+	}
+	return writeRefFile(gitDir, ref, full+"\n")
+}
+
+// CurrentBranch returns the short name of the branch HEAD points to
+// ("main"). ok is false when HEAD is detached. The branch need not have
+// any commits yet.
+func CurrentBranch(gitDir string) (name string, ok bool, err error) {
+	target, ok, err := ReadSymbolicRef(gitDir, "HEAD")
+	if err != nil || !ok {
+		return "", false, err
+	}
+	name, ok = strings.CutPrefix(target, "refs/heads/")
+	return name, ok, nil
+}
+
+// DeleteBranch removes refs/heads/<name>, both the loose file and any
+// packed-refs entry, and its reflog, and returns the hash it pointed at.
+// It refuses to delete the branch HEAD is on.
+func DeleteBranch(gitDir, name string) (string, error) {
+	ref := "refs/heads/" + name
+	if err := CheckRefName(ref); err != nil {
+		return "", err
+	}
+	if current, ok, err := CurrentBranch(gitDir); err != nil {
+		return "", err
+	} else if ok && current == name {
+		return "", fmt.Errorf("%w: %s", ErrCurrentBranch, name)
+	}
+
+	hash, ok, err := readRef(gitDir, ref)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("branch %s not found", name)
+	}
+
+	if err := removePackedRef(gitDir, ref); err != nil {
+		return "", err
+	}
+	if err := os.Remove(filepath.Join(gitDir, filepath.FromSlash(ref))); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("deleting %s: %w", ref, err)
+	}
+	refsWritten(gitDir)
+	if err := os.Remove(filepath.Join(gitDir, "logs", filepath.FromSlash(ref))); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("deleting reflog for %s: %w", ref, err)
+	}
+	return hash, nil
+}
+
+// removePackedRef rewrites packed-refs without ref and its peeled line,
+// going through packed-refs.lock like other ref writes. It does nothing
+// if ref isn't packed.
+func removePackedRef(gitDir, ref string) error {
+	path := filepath.Join(gitDir, "packed-refs")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading packed-refs: %w", err)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	var kept []string
+	found := false
+	for i := 0; i < len(lines); i++ {
+		if _, name, ok := strings.Cut(strings.TrimSuffix(lines[i], "\n"), " "); ok && name == ref {
+			found = true
+			if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "^") {
+				i++
+			}
+			continue
+		}
+		kept = append(kept, lines[i])
+	}
+	if !found {
+		return nil
+	}
+	return writeRefFile(gitDir, "packed-refs", strings.Join(kept, ""))
+}
+
+// CheckRefName validates a full ref name using the main rules of
+// git check-ref-format: no empty or dot-leading components, no "..",
+// "@{", "//", or trailing "/" or ".lock", and none of the characters
+// git reserves for revision syntax.
+func CheckRefName(ref string) error {
+	invalid := func(why string) error {
+		return fmt.Errorf("invalid ref name %q: %s", ref, why)
+	}
+
+	if !strings.HasPrefix(ref, "refs/") {
+		return invalid("must start with refs/")
+	}
+	if strings.Contains(ref, "..") || strings.Contains(ref, "@{") {
+		return invalid(`contains ".." or "@{"`)
+	}
+	if strings.HasSuffix(ref, "/") || strings.HasSuffix(ref, ".") {
+		return invalid(`ends with "/" or "."`)
+	}
+	for _, c := range ref {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return invalid(fmt.Sprintf("contains %q", c))
+		}
+	}
+	for _, part := range strings.Split(ref, "/") {
+		if part == "" {
+			return invalid("has an empty path component")
+		}
+		if part[0] == '.' || strings.HasSuffix(part, ".lock") {
+			return invalid(`a component starts with "." or ends with ".lock"`)
+		}
+	}
+	return nil
+}
+
+// followSymref returns the ref that a write to name should land on:
+// name itself, or the end of its chain of symbolic refs.
+func followSymref(gitDir, name string) (string, error) {
+	for range maxSymrefDepth {
+		data, err := os.ReadFile(filepath.Join(gitDir, filepath.FromSlash(name)))
+		if err != nil {
+			return name, nil
+		}
+		target, symbolic := strings.CutPrefix(strings.TrimSpace(string(data)), "ref: ")
+		if !symbolic {
+			return name, nil
+		}
+		name = target
+	}
+	return "", fmt.Errorf("ref %s: too many levels of symbolic refs", name)
+}
+
+// writeRefFile writes content to the loose ref file for ref, creating
+// parent directories. It takes <ref>.lock exclusively and renames it into
+// place, then marks the git dir's RefStores stale.
+func writeRefFile(gitDir, ref, content string) error {
+	path := filepath.Join(gitDir, filepath.FromSlash(ref))
+	lock, err := lockRef(ref, path)
+	if err != nil {
+		return err
+	}
+
+	_, err = lock.WriteString(content)
+	if closeErr := lock.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(lock.Name(), path)
+	}
+	if err != nil {
+		os.Remove(lock.Name())
+		return fmt.Errorf("writing ref %s: %w", ref, err)
+	}
+	refsWritten(gitDir)
+	return nil
+}
+
+// lockRef creates <path>.lock for ref, and path's parent directories. It
+// fails if the lock is already held.
+func lockRef(ref, path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("creating ref directory: %w", err)
+	}
+	lockPath := path + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("update %s: %s exists; another process may be writing it", ref, lockPath)
+		}
+		return nil, fmt.Errorf("locking %s: %w", ref, err)
+	}
+	return lock, nil
+}
+
+// RefUpdate is one change for UpdateRefs: the ref Name moves from Old to
+// New. An empty Old means the ref mustn't exist yet, and an empty New
+// deletes it.
+type RefUpdate struct {
+	Name, Old, New string
+}
+
+// UpdateRefs applies updates together. Every ref is locked and checked
+// against its Old value before any is written, so if one can't be locked
+// or has moved on, none change; the error for a ref that has moved wraps
+// ErrStaleRef. Only a failure while renaming the locks into place, after
+// all the checks pass, can leave some refs updated and others not.
+func UpdateRefs(gitDir string, updates []RefUpdate) error {
+	type held struct {
+		RefUpdate
+		path string
+		lock *os.File
+	}
+	var locks []held
+	release := func() {
+		for _, l := range locks {
+			l.lock.Close()
+			os.Remove(l.lock.Name())
+		}
+	}
+
+	for _, u := range updates {
+		if err := CheckRefName(u.Name); err != nil {
+			release()
+			return err
+		}
+		if u.New != "" && !isHash(u.New) {
+			release()
+			return fmt.Errorf("update %s: invalid object name %q", u.Name, u.New)
+		}
+		path := filepath.Join(gitDir, filepath.FromSlash(u.Name))
+		lock, err := lockRef(u.Name, path)
+		if err != nil {
+			release()
+			return err
+		}
+		locks = append(locks, held{u, path, lock})
+
+		current, _, err := readRef(gitDir, u.Name)
+		if err != nil {
+			release()
+			return err
+		}
+		if current != u.Old {
+			release()
+			return fmt.Errorf("%w: %s is at %q, expected %q", ErrStaleRef, u.Name, current, u.Old)
+		}
+		if u.New != "" {
+			if _, err := lock.WriteString(u.New + "\n"); err != nil {
+				release()
+				return fmt.Errorf("writing ref %s: %w", u.Name, err)
+			}
+		}
+		if err := lock.Close(); err != nil {
+			release()
+			return fmt.Errorf("writing ref %s: %w", u.Name, err)
+		}
+	}
+
+	defer refsWritten(gitDir)
+	for i, l := range locks {
+		var err error
+		if l.New == "" {
+			// The loose file goes last, so a failure leaves the ref as it
+			// was rather than uncovering a stale packed value.
+			if err = removePackedRef(gitDir, l.Name); err == nil {
+				if err = os.Remove(l.path); os.IsNotExist(err) {
+					err = nil
+				}
+			}
+			os.Remove(l.lock.Name())
+		} else {
+			err = os.Rename(l.lock.Name(), l.path)
+		}
+		if err != nil {
+			locks = locks[i:]
+			release()
+			return fmt.Errorf("updating %s: %w", l.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+)
+
+// writeTestCommit stores a root commit and returns its hash.
+func writeTestCommit(t *testing.T, gitDir, msg string) string {
+	t.Helper()
+	body := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"author A <a@example.com> 1700000000 +0000\n" +
+		"committer A <a@example.com> 1700000000 +0000\n\n" + msg + "\n"
+	sha, err := object.WriteObject(gitDir, object.TypeCommit, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+func readRefFile(t *testing.T, gitDir, ref string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCreateBranch(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := writeTestCommit(t, repo.GitDir, "first")
+
+	if err := CreateBranch(repo.GitDir, "feature/x", commit[:7]); err != nil {
+		t.Fatalf("CreateBranch() error: %v", err)
+	}
+	if got := readRefFile(t, repo.GitDir, "refs/heads/feature/x"); got != commit+"\n" {
+		t.Errorf("ref contents: got %q", got)
+	}
+
+	if err := CreateBranch(repo.GitDir, "feature/x", commit); !errors.Is(err, ErrBranchExists) {
+		t.Errorf("existing branch: got %v, want ErrBranchExists", err)
+	}
+
+	blob := writeTestObject(t, repo.GitDir, "not a commit\n")
+	if err := CreateBranch(repo.GitDir, "blob", blob); err == nil || !strings.Contains(err.Error(), "not a commit") {
+		t.Errorf("blob start point: got %v", err)
+	}
+	if err := CreateBranch(repo.GitDir, "missing", strings.Repeat("0", 40)); !errors.Is(err, object.ErrNotFound) {
+		t.Errorf("missing start point: got %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.GitDir, "refs", "heads", "blob")); !os.IsNotExist(err) {
+		t.Error("no ref should be written for an invalid start point")
+	}
+}
+
+func TestUpdateRef(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := writeTestCommit(t, repo.GitDir, "first")
+	second := writeTestCommit(t, repo.GitDir, "second")
+
+	// Updating HEAD on an unborn branch creates the branch.
+	if err := UpdateRef(repo.GitDir, "HEAD", first); err != nil {
+		t.Fatalf("UpdateRef(HEAD) error: %v", err)
+	}
+	if got := readRefFile(t, repo.GitDir, "HEAD"); got != "ref: refs/heads/main\n" {
+		t.Errorf("HEAD should stay symbolic, got %q", got)
+	}
+	if got, _ := ResolveHead(repo.GitDir); got != first {
+		t.Errorf("ResolveHead: got %q, want %q", got, first)
+	}
+
+	if err := UpdateRef(repo.GitDir, "refs/heads/main", second); err != nil {
+		t.Fatalf("UpdateRef() error: %v", err)
+	}
+	if got := readRefFile(t, repo.GitDir, "refs/heads/main"); got != second+"\n" {
+		t.Errorf("ref contents: got %q", got)
+	}
+
+	if err := UpdateRef(repo.GitDir, "refs/heads/main", "abc"); err == nil {
+		t.Error("expected error for a short hash")
+	}
+}
+
+func TestDetachHead(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := writeTestCommit(t, repo.GitDir, "first")
+	second := writeTestCommit(t, repo.GitDir, "second")
+	if err := UpdateRef(repo.GitDir, "HEAD", first); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DetachHead(repo.GitDir, second); err != nil {
+		t.Fatalf("DetachHead() error: %v", err)
+	}
+	if got := readRefFile(t, repo.GitDir, "HEAD"); got != second+"\n" {
+		t.Errorf("HEAD = %q, want the bare hash", got)
+	}
+	if got := readRefFile(t, repo.GitDir, "refs/heads/main"); got != first+"\n" {
+		t.Errorf("main moved to %q", got)
+	}
+	if _, ok, _ := CurrentBranch(repo.GitDir); ok {
+		t.Error("CurrentBranch() reports a branch after DetachHead")
+	}
+}
+
+func TestUpdateRef_Locked(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := writeTestCommit(t, repo.GitDir, "first")
+
+	lock := filepath.Join(repo.GitDir, "refs", "heads", "main.lock")
+	if err := os.WriteFile(lock, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef(repo.GitDir, "refs/heads/main", commit); err == nil || !strings.Contains(err.Error(), "lock") {
+		t.Errorf("got %v, want lock error", err)
+	}
+}
+
+func TestCheckRefName(t *testing.T) {
+	valid := []string{"refs/heads/main", "refs/heads/feature/x-1", "refs/tags/v1.0"}
+	for _, ref := range valid {
+		if err := CheckRefName(ref); err != nil {
+			t.Errorf("CheckRefName(%q) = %v, want nil", ref, err)
+		}
+	}
+
+	invalid := []string{
+		"main", "refs/heads/", "refs/heads//x", "refs/heads/a..b", "refs/heads/.hidden",
+		"refs/heads/x.lock", "refs/heads/a b", "refs/heads/a~1", "refs/heads/a^", "refs/heads/a:b",
+		"refs/heads/a?", "refs/heads/a*", "refs/heads/a[", "refs/heads/a\\b", "refs/heads/a@{1}",
+		"refs/heads/x.",
+	}
+	for _, ref := range invalid {
+		if err := CheckRefName(ref); err == nil {
+			t.Errorf("CheckRefName(%q) = nil, want error", ref)
+		}
+	}
+}
+
+func TestCurrentBranch(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok, err := CurrentBranch(repo.GitDir); err != nil || !ok || name != "main" {
+		t.Errorf("fresh repo: got %q, %v, %v", name, ok, err)
+	}
+
+	writeRef(t, repo.GitDir, "HEAD", strings.Repeat("1", 40))
+	if _, ok, err := CurrentBranch(repo.GitDir); err != nil || ok {
+		t.Errorf("detached HEAD: got ok=%v, err=%v", ok, err)
+	}
+}
+
+func TestDeleteBranch(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := writeTestCommit(t, repo.GitDir, "first")
+	UpdateRef(repo.GitDir, "HEAD", commit)
+	CreateBranch(repo.GitDir, "topic", commit)
+
+	if _, err := DeleteBranch(repo.GitDir, "main"); !errors.Is(err, ErrCurrentBranch) {
+		t.Errorf("current branch: got %v, want ErrCurrentBranch", err)
+	}
+
+	was, err := DeleteBranch(repo.GitDir, "topic")
+	if err != nil || was != commit {
+		t.Fatalf("DeleteBranch(topic) = %q, %v", was, err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.GitDir, "refs", "heads", "topic")); !os.IsNotExist(err) {
+		t.Error("loose ref should be removed")
+	}
+
+	if _, err := DeleteBranch(repo.GitDir, "topic"); err == nil {
+		t.Error("deleting a missing branch: expected error")
+	}
+}
+
+func TestDeleteBranch_Packed(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := writeTestCommit(t, repo.GitDir, "first")
+	packed := "# pack-refs with: peeled fully-peeled sorted \n" +
+		commit + " refs/heads/packed\n" +
+		commit + " refs/tags/v1\n" +
+		"^" + strings.Repeat("2", 40) + "\n"
+	os.WriteFile(filepath.Join(repo.GitDir, "packed-refs"), []byte(packed), 0644)
+	writeRef(t, repo.GitDir, "refs/heads/packed", commit) // also loose
+
+	if _, err := DeleteBranch(repo.GitDir, "packed"); err != nil {
+		t.Fatalf("DeleteBranch() error: %v", err)
+	}
+
+	want := "# pack-refs with: peeled fully-peeled sorted \n" +
+		commit + " refs/tags/v1\n" +
+		"^" + strings.Repeat("2", 40) + "\n"
+	if got := readRefFile(t, repo.GitDir, "packed-refs"); got != want {
+		t.Errorf("packed-refs:\ngot  %q\nwant %q", got, want)
+	}
+	if _, ok, _ := readRef(repo.GitDir, "refs/heads/packed"); ok {
+		t.Error("branch should be gone")
+	}
+}
+
+func TestUpdateRefs(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := writeTestCommit(t, repo.GitDir, "first")
+	second := writeTestCommit(t, repo.GitDir, "second")
+	os.WriteFile(filepath.Join(repo.GitDir, "packed-refs"), []byte(first+" refs/tags/old\n"), 0644)
+	writeRef(t, repo.GitDir, "refs/heads/main", first)
+
+	err = UpdateRefs(repo.GitDir, []RefUpdate{
+		{Name: "refs/heads/main", Old: first, New: second},
+		{Name: "refs/heads/topic", New: first},
+		{Name: "refs/tags/old", Old: first},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ref, want := range map[string]string{"refs/heads/main": second, "refs/heads/topic": first, "refs/tags/old": ""} {
+		if got, _, _ := readRef(repo.GitDir, ref); got != want {
+			t.Errorf("%s = %q, want %q", ref, got, want)
+		}
+	}
+
+	// One stale ref stops the lot, and no locks are left behind.
+	err = UpdateRefs(repo.GitDir, []RefUpdate{
+		{Name: "refs/heads/topic", Old: first, New: second},
+		{Name: "refs/heads/main", Old: first, New: first},
+	})
+	if !errors.Is(err, ErrStaleRef) {
+		t.Fatalf("got %v, want ErrStaleRef", err)
+	}
+	if got, _, _ := readRef(repo.GitDir, "refs/heads/topic"); got != first {
+		t.Errorf("topic moved to %s despite the failed update", got)
+	}
+	locks, _ := filepath.Glob(filepath.Join(repo.GitDir, "refs", "heads", "*.lock"))
+	if len(locks) > 0 {
+		t.Errorf("locks left behind: %v", locks)
+	}
+
+	if err := UpdateRefs(repo.GitDir, []RefUpdate{{Name: "refs/heads/new", Old: first, New: second}}); !errors.Is(err, ErrStaleRef) {
+		t.Errorf("creating a ref expected to exist: got %v, want ErrStaleRef", err)
+	}
+
+	// A rename that fails, here onto a directory in the way, releases its
+	// own lock as well as those not yet renamed.
+	if err := os.MkdirAll(filepath.Join(repo.GitDir, "refs", "heads", "blocked", "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = UpdateRefs(repo.GitDir, []RefUpdate{
+		{Name: "refs/heads/blocked", New: first},
+		{Name: "refs/heads/later", New: first},
+	})
+	if err == nil {
+		t.Fatal("renaming onto a directory succeeded")
+	}
+	locks, _ = filepath.Glob(filepath.Join(repo.GitDir, "refs", "heads", "*.lock"))
+	if len(locks) > 0 {
+		t.Errorf("locks left behind after a failed rename: %v", locks)
+	}
+}
@@ -0,0 +1,124 @@
+// Package server exposes repositories over HTTP.
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/elliota43/rev/internal/repository"
+	"github.com/elliota43/rev/internal/trace"
+)
+
+var (
+	looseObjectPath = regexp.MustCompile(`^/objects/[0-9a-f]{2}/[0-9a-f]{38}$`)
+	packFilePath    = regexp.MustCompile(`^/objects/pack/pack-[0-9a-f]{40}\.(pack|idx)$`)
+)
+
+// DumbHandler serves a repository over git's "dumb" HTTP protocol: clients
+// fetch info/refs and then walk the object graph by requesting loose
+// objects and packfiles at their on-disk paths. The info files that
+// `git update-server-info` would normally maintain are generated on each
+// request, so the served view is always current. It is read-only.
+type DumbHandler struct {
+	gitDir string
+}
+
+// NewDumbHandler returns a handler serving the repository at gitDir.
+func NewDumbHandler(gitDir string) *DumbHandler {
+	return &DumbHandler{gitDir: gitDir}
+}
+
+func (h *DumbHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "read-only repository", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := path.Clean("/" + r.URL.Path)
+	if trace.Enabled() {
+		trace.Log("server", "dumb %s %s", r.Method, p)
+	}
+	switch {
+	case p == "/info/refs":
+		h.serveInfoRefs(w)
+	case p == "/objects/info/packs":
+		h.servePacks(w)
+	case p == "/HEAD":
+		h.serveFile(w, r, p, "text/plain")
+	case looseObjectPath.MatchString(p):
+		h.serveFile(w, r, p, "application/x-git-loose-object")
+	case packFilePath.MatchString(p):
+		contentType := "application/x-git-packed-objects"
+		if strings.HasSuffix(p, ".idx") {
+			contentType = "application/x-git-packed-objects-toc"
+		}
+		h.serveFile(w, r, p, contentType)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveInfoRefs writes one "<sha>\t<refname>" line per ref, the format
+// dumb clients expect from info/refs.
+func (h *DumbHandler) serveInfoRefs(w http.ResponseWriter) {
+	refs, err := repository.ListRefs(h.gitDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var b strings.Builder
+	for _, ref := range refs {
+		fmt.Fprintf(&b, "%s\t%s\n", ref.Hash, ref.Name)
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(b.String()))
+}
+
+// servePacks writes objects/info/packs: a "P <pack>" line per packfile,
+// terminated by a blank line.
+func (h *DumbHandler) servePacks(w http.ResponseWriter) {
+	packs, err := filepath.Glob(filepath.Join(h.gitDir, "objects", "pack", "pack-*.pack"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var b strings.Builder
+	for _, p := range packs {
+		fmt.Fprintf(&b, "P %s\n", filepath.Base(p))
+	}
+	b.WriteString("\n")
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(b.String()))
+}
+
+// serveFile serves a file from the git directory. urlPath has already
+// been cleaned and matched against the allowed layouts.
+func (h *DumbHandler) serveFile(w http.ResponseWriter, r *http.Request, urlPath, contentType string) {
+	f, err := os.Open(filepath.Join(h.gitDir, filepath.FromSlash(urlPath)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/repository"
+)
+
+// testServer starts a dumb-HTTP server over a repo with one blob and a
+// main branch pointing at it.
+func testServer(t *testing.T) (*httptest.Server, string, string) {
+	t.Helper()
+
+	repo, err := repository.Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("hello\n")
+	sha, full, err := object.Hash(object.TypeBlob, bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := object.Write(repo.GitDir, sha, full); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo.GitDir, "refs", "heads", "main"), []byte(sha+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(NewDumbHandler(repo.GitDir))
+	t.Cleanup(srv.Close)
+	return srv, repo.GitDir, sha
+}
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestDumbHandler_InfoRefs(t *testing.T) {
+	srv, _, sha := testServer(t)
+
+	code, body := get(t, srv.URL+"/info/refs")
+	if code != http.StatusOK {
+		t.Fatalf("status: got %d", code)
+	}
+	if want := sha + "\trefs/heads/main\n"; body != want {
+		t.Errorf("info/refs: got %q, want %q", body, want)
+	}
+}
+
+func TestDumbHandler_HEAD(t *testing.T) {
+	srv, _, _ := testServer(t)
+
+	code, body := get(t, srv.URL+"/HEAD")
+	if code != http.StatusOK || body != "ref: refs/heads/main\n" {
+		t.Errorf("HEAD: got %d %q", code, body)
+	}
+}
+
+func TestDumbHandler_LooseObject(t *testing.T) {
+	srv, gitDir, sha := testServer(t)
+
+	code, body := get(t, srv.URL+"/objects/"+sha[:2]+"/"+sha[2:])
+	if code != http.StatusOK {
+		t.Fatalf("status: got %d", code)
+	}
+	want, err := os.ReadFile(filepath.Join(gitDir, "objects", sha[:2], sha[2:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != string(want) {
+		t.Error("loose object body doesn't match the file on disk")
+	}
+}
+
+func TestDumbHandler_Packs(t *testing.T) {
+	srv, gitDir, _ := testServer(t)
+
+	name := "pack-" + "ab" + string(bytes.Repeat([]byte("0"), 38)) + ".pack"
+	if err := os.WriteFile(filepath.Join(gitDir, "objects", "pack", name), []byte("PACK"), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	code, body := get(t, srv.URL+"/objects/info/packs")
+	if code != http.StatusOK {
+		t.Fatalf("status: got %d", code)
+	}
+	if want := "P " + name + "\n\n"; body != want {
+		t.Errorf("objects/info/packs: got %q, want %q", body, want)
+	}
+
+	code, body = get(t, srv.URL+"/objects/pack/"+name)
+	if code != http.StatusOK || body != "PACK" {
+		t.Errorf("pack file: got %d %q", code, body)
+	}
+}
+
+func TestDumbHandler_Rejects(t *testing.T) {
+	srv, _, _ := testServer(t)
+
+	for _, p := range []string{"/config", "/objects/../config", "/refs/heads/main", "/objects/zz/nothex"} {
+		if code, _ := get(t, srv.URL+p); code != http.StatusNotFound {
+			t.Errorf("GET %s: got %d, want 404", p, code)
+		}
+	}
+
+	resp, err := http.Post(srv.URL+"/info/refs", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got %d, want 405", resp.StatusCode)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxPktLen is the longest pkt-line, length prefix included, that git
+// reads or writes.
+const maxPktLen = 65520
+
+// errFlush is returned by readPkt for a flush-pkt, "0000", which ends a
+// section of the conversation.
+var errFlush = errors.New("flush-pkt")
+
+// writePkt writes data as one pkt-line: its length, including the four
+// length digits, in hex, then the data.
+func writePkt(w io.Writer, data []byte) error {
+	if len(data)+4 > maxPktLen {
+		return fmt.Errorf("pkt-line of %d bytes is too long", len(data))
+	}
+	if _, err := fmt.Fprintf(w, "%04x", len(data)+4); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// writePktf writes a formatted pkt-line.
+func writePktf(w io.Writer, format string, args ...any) error {
+	return writePkt(w, fmt.Appendf(nil, format, args...))
+}
+
+// writeFlush writes a flush-pkt.
+func writeFlush(w io.Writer) error {
+	_, err := io.WriteString(w, "0000")
+	return err
+}
+
+// readPkt reads one pkt-line and returns its data. A flush-pkt gives
+// errFlush; running out of input before a line starts gives io.EOF.
+func readPkt(r *bufio.Reader) ([]byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated pkt-line length")
+		}
+		return nil, err
+	}
+	n, err := strconv.ParseUint(string(head[:]), 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad pkt-line length %q", head[:])
+	}
+	switch {
+	case n == 0:
+		return nil, errFlush
+	case n < 4 || n > maxPktLen:
+		return nil, fmt.Errorf("bad pkt-line length %q", head[:])
+	}
+	data := make([]byte, n-4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("truncated pkt-line: %w", err)
+	}
+	return data, nil
+}
+
+// readPktText reads a pkt-line of text, dropping its trailing newline.
+func readPktText(r *bufio.Reader) (string, error) {
+	data, err := readPkt(r)
+	return strings.TrimSuffix(string(data), "\n"), err
+}
+
+// sidebandWriter sends what is written to it on one band of git's
+// side-band-64k multiplexing, each pkt-line carrying the band number and
+// as much data as fits.
+type sidebandWriter struct {
+	w    io.Writer
+	band byte
+}
+
+func (s sidebandWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), maxPktLen-5)
+		if err := writePkt(s.w, append([]byte{s.band}, p[:n]...)); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPktLine_RoundTrip(t *testing.T) {
+	var b bytes.Buffer
+	writePktf(&b, "want %s\n", "abc")
+	writePkt(&b, []byte("no newline"))
+	writeFlush(&b)
+	writePkt(&b, nil)
+	if got, want := b.String(), "000dwant abc\n000eno newline00000004"; got != want {
+		t.Fatalf("wrote %q, want %q", got, want)
+	}
+
+	r := bufio.NewReader(&b)
+	for _, want := range []string{"want abc", "no newline", "flush", "", "eof"} {
+		got, err := readPktText(r)
+		switch err {
+		case nil:
+		case errFlush:
+			got = "flush"
+		case io.EOF:
+			got = "eof"
+		default:
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("read %q, want %q", got, want)
+		}
+	}
+}
+
+func TestPktLine_Errors(t *testing.T) {
+	for _, in := range []string{"00", "zzzz", "0003", "fff1", "0009abc"} {
+		if _, err := readPkt(bufio.NewReader(strings.NewReader(in))); err == nil || err == io.EOF {
+			t.Errorf("readPkt(%q) = %v, want an error", in, err)
+		}
+	}
+	if err := writePkt(io.Discard, make([]byte, maxPktLen-3)); err == nil {
+		t.Error("writePkt of an over-long line succeeded")
+	}
+}
+
+func TestSidebandWriter(t *testing.T) {
+	var b bytes.Buffer
+	data := bytes.Repeat([]byte("x"), maxPktLen)
+	if n, err := (sidebandWriter{&b, 1}).Write(data); n != len(data) || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+
+	// The data doesn't fit in one pkt-line, so it takes two, each led by
+	// the band.
+	r := bufio.NewReader(&b)
+	var got []byte
+	for range 2 {
+		line, err := readPkt(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line[0] != 1 {
+			t.Errorf("band %d, want 1", line[0])
+		}
+		got = append(got, line[1:]...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read back %d bytes, want %d", len(got), len(data))
+	}
+	if _, err := readPkt(r); err != io.EOF {
+		t.Errorf("more after the data: %v", err)
+	}
+}
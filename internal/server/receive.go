@@ -0,0 +1,502 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/repository"
+	"github.com/elliota43/rev/internal/trace"
+)
+
+// receivePackCaps are the capabilities advertised to pushing clients.
+// Packs may use either kind of delta, since they are unpacked whole.
+// With side-band-64k, what the receive hooks print is passed on to the
+// client.
+var receivePackCaps = []string{"report-status", "delete-refs", "atomic", "side-band-64k", "ofs-delta", "agent=rev"}
+
+// DefaultMaxInputSize is the largest pack a push may send when
+// receive.maxInputSize isn't set. Packs are read whole into memory, so
+// unlike git, rev doesn't default to no limit.
+const DefaultMaxInputSize = 512 << 20
+
+// errInputTooLarge is the error reading a pushed pack larger than
+// receive.maxInputSize.
+var errInputTooLarge = errors.New("pack exceeds maximum allowed size")
+
+// receiveCommand is one ref update in a push: ref moves from old to new,
+// either of which may be zeroHash, for a ref being created or deleted.
+type receiveCommand struct {
+	old, new, ref string
+	// refused is why the update was turned down, as reported back to the
+	// client, or empty if it was made.
+	refused string
+}
+
+func (c *receiveCommand) isDelete() bool { return c.new == zeroHash }
+
+// refUpdate converts c for repository.UpdateRefs, which uses an empty
+// hash where the protocol uses zeroHash.
+func (c *receiveCommand) refUpdate() repository.RefUpdate {
+	u := repository.RefUpdate{Name: c.ref, Old: c.old, New: c.new}
+	if u.Old == zeroHash {
+		u.Old = ""
+	}
+	if u.New == zeroHash {
+		u.New = ""
+	}
+	return u
+}
+
+// parseReceiveRequest reads the commands a client sends to
+// git-receive-pack, up to the flush-pkt before the pack, and the
+// capabilities turned on in the first.
+func parseReceiveRequest(r *bufio.Reader) ([]*receiveCommand, map[string]bool, error) {
+	var cmds []*receiveCommand
+	caps := make(map[string]bool)
+	for {
+		line, err := readPktText(r)
+		if err == errFlush {
+			return cmds, caps, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(cmds) == 0 {
+			var capList string
+			line, capList, _ = strings.Cut(line, "\x00")
+			for _, c := range strings.Fields(capList) {
+				caps[c] = true
+			}
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || !objectName.MatchString(fields[0]) || !objectName.MatchString(fields[1]) {
+			return nil, nil, fmt.Errorf("expected a ref update, got %q", line)
+		}
+		cmds = append(cmds, &receiveCommand{old: fields[0], new: fields[1], ref: fields[2]})
+	}
+}
+
+// serveReceivePack answers a POST to git-receive-pack: it takes the
+// pushed pack and makes the ref updates that pass its checks, and
+// reports on each if the client asks.
+func (h *SmartHandler) serveReceivePack(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/x-git-receive-pack-request" {
+		http.Error(w, "expected a receive-pack request", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := requestBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+	in := bufio.NewReader(body)
+	cmds, caps, err := parseReceiveRequest(in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if trace.Enabled() {
+		trace.Log("server", "receive-pack: %d commands", len(cmds))
+		for _, c := range cmds {
+			trace.Log("server", "receive-pack: %s %s -> %s", c.ref, c.old, c.new)
+		}
+	}
+
+	maxInput, err := h.maxInputSize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-receive-pack-result")
+	w.Header().Set("Cache-Control", "no-cache")
+	if len(cmds) == 0 {
+		return
+	}
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+	hookOut := io.Discard
+	if caps["side-band-64k"] {
+		hookOut = sidebandWriter{out, 2}
+	}
+	var pack io.Reader = in
+	if maxInput > 0 {
+		pack = &limitedPack{r: in, n: maxInput}
+	}
+	unpackErr := h.receivePack(pack, cmds, caps["atomic"], hookOut)
+	if trace.Enabled() {
+		if unpackErr != nil {
+			trace.Log("server", "receive-pack: unpack failed: %v", unpackErr)
+		}
+		for _, c := range cmds {
+			if c.refused != "" {
+				trace.Log("server", "receive-pack: ng %s %s", c.ref, c.refused)
+			} else {
+				trace.Log("server", "receive-pack: ok %s", c.ref)
+			}
+		}
+	}
+	if !caps["report-status"] {
+		if caps["side-band-64k"] {
+			writeFlush(out)
+		}
+		return
+	}
+
+	var report bytes.Buffer
+	if unpackErr != nil {
+		writePktf(&report, "unpack %s\n", unpackErr)
+	} else {
+		writePktf(&report, "unpack ok\n")
+	}
+	for _, c := range cmds {
+		if c.refused != "" {
+			writePktf(&report, "ng %s %s\n", c.ref, c.refused)
+		} else {
+			writePktf(&report, "ok %s\n", c.ref)
+		}
+	}
+	writeFlush(&report)
+	if !caps["side-band-64k"] {
+		out.Write(report.Bytes())
+		return
+	}
+	sidebandWriter{out, 1}.Write(report.Bytes())
+	writeFlush(out)
+}
+
+// denyReceivePack answers a receive-pack request with 403 Forbidden and
+// returns true unless pushing is enabled, by h.ReceivePack or
+// http.receivepack.
+func (h *SmartHandler) denyReceivePack(w http.ResponseWriter) bool {
+	if h.ReceivePack {
+		return false
+	}
+	cfg, err := repository.ParseConfig(h.gitDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	enabled, _, err := cfg.GetBool("http", "receivepack")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	if !enabled {
+		http.Error(w, "service not enabled: git-receive-pack", http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+// maxInputSize returns receive.maxInputSize, the most bytes of pack a
+// push may send, or DefaultMaxInputSize if it isn't set. Zero means no
+// limit, as in git.
+func (h *SmartHandler) maxInputSize() (int64, error) {
+	cfg, err := repository.ParseConfig(h.gitDir)
+	if err != nil {
+		return 0, err
+	}
+	size, found, err := cfg.GetInt("receive", "maxinputsize")
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return DefaultMaxInputSize, nil
+	}
+	return size, nil
+}
+
+// limitedPack reads from r until more than n bytes have come, then fails
+// with errInputTooLarge.
+type limitedPack struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedPack) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if l.n -= int64(n); l.n < 0 {
+		return 0, errInputTooLarge
+	}
+	return n, err
+}
+
+// countingReader counts the bytes read through it, for tracing.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// receivePack reads the pack that follows cmds from r into quarantine,
+// checks each command, and then promotes the objects and updates the
+// refs for the commands that passed, setting refused on the rest. With
+// atomic, one refusal refuses them all. The pre-receive, update, and
+// post-receive hooks run along the way, as in git, printing to hookOut.
+// It returns an error only if the pack couldn't be taken, in which case
+// every command is refused.
+func (h *SmartHandler) receivePack(r io.Reader, cmds []*receiveCommand, atomic bool, hookOut io.Writer) error {
+	refuseAll := func(why string) {
+		for _, c := range cmds {
+			if c.refused == "" {
+				c.refused = why
+			}
+		}
+	}
+
+	// A pack comes with anything but a push of deletes alone.
+	var tips []string
+	for _, c := range cmds {
+		if !c.isDelete() {
+			tips = append(tips, c.new)
+		}
+	}
+	var q *object.Quarantine
+	if len(tips) > 0 {
+		start := time.Now()
+		counted := &countingReader{r: r}
+		var err error
+		if q, err = object.QuarantinePack(h.gitDir, counted, tips); err != nil {
+			refuseAll("unpacker error")
+			return err
+		}
+		defer q.Discard()
+		if trace.Enabled() {
+			trace.Log("server", "receive-pack: received pack (%d bytes) in %s", counted.n, trace.Since(start))
+		}
+	}
+
+	checks, err := h.receiveChecks()
+	if err != nil {
+		refuseAll(err.Error())
+		return nil
+	}
+	accepted := 0
+	for _, c := range cmds {
+		if c.refused = checks.check(q, c); c.refused == "" {
+			accepted++
+		}
+	}
+	if atomic && accepted < len(cmds) {
+		refuseAll("atomic push failed")
+		accepted = 0
+	}
+	if accepted == 0 {
+		return nil
+	}
+
+	// pre-receive sees the pushed objects while they are still in
+	// quarantine, and can turn the whole push down.
+	env, err := quarantineEnv(h.gitDir, q)
+	if err != nil {
+		refuseAll(err.Error())
+		return nil
+	}
+	if err := repository.RunHook(h.gitDir, "pre-receive", repository.HookOptions{
+		Stdin:  hookInput(cmds),
+		Output: hookOut,
+		Env:    env,
+	}); err != nil {
+		refuseAll("pre-receive hook declined")
+		return nil
+	}
+
+	if q != nil {
+		if err := q.Promote(); err != nil {
+			refuseAll("unpacker error")
+			return err
+		}
+	}
+
+	// update can turn down each ref on its own.
+	for _, c := range cmds {
+		if c.refused != "" {
+			continue
+		}
+		if err := repository.RunHook(h.gitDir, "update", repository.HookOptions{
+			Args:   []string{c.ref, c.old, c.new},
+			Output: hookOut,
+		}); err != nil {
+			c.refused = "hook declined"
+			accepted--
+		}
+	}
+	if atomic && accepted < len(cmds) {
+		refuseAll("atomic push failed")
+		return nil
+	}
+
+	if atomic {
+		var updates []repository.RefUpdate
+		for _, c := range cmds {
+			updates = append(updates, c.refUpdate())
+		}
+		if err := repository.UpdateRefs(h.gitDir, updates); err != nil {
+			refuseAll("failed to update refs")
+		}
+	} else {
+		for _, c := range cmds {
+			if c.refused != "" {
+				continue
+			}
+			if err := repository.UpdateRefs(h.gitDir, []repository.RefUpdate{c.refUpdate()}); err != nil {
+				c.refused = "failed to update ref"
+			}
+		}
+	}
+
+	// post-receive hears about what was updated, and can't undo it.
+	if in := hookInput(cmds); in.Len() > 0 {
+		repository.RunHook(h.gitDir, "post-receive", repository.HookOptions{Stdin: in, Output: hookOut})
+	}
+	return nil
+}
+
+// hookInput is the standard input of the pre-receive and post-receive
+// hooks: a line "<old> <new> <ref>" for each command not refused.
+func hookInput(cmds []*receiveCommand) *bytes.Buffer {
+	var b bytes.Buffer
+	for _, c := range cmds {
+		if c.refused == "" {
+			fmt.Fprintf(&b, "%s %s %s\n", c.old, c.new, c.ref)
+		}
+	}
+	return &b
+}
+
+// quarantineEnv points a hook's git at q's objects as well as the
+// repository's, the way git runs pre-receive. q may be nil, for a push
+// that brought no objects.
+func quarantineEnv(gitDir string, q *object.Quarantine) ([]string, error) {
+	if q == nil {
+		return nil, nil
+	}
+	dir, err := filepath.Abs(q.Dir)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := filepath.Abs(filepath.Join(gitDir, "objects"))
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		"GIT_QUARANTINE_PATH=" + dir,
+		"GIT_OBJECT_DIRECTORY=" + dir,
+		"GIT_ALTERNATE_OBJECT_DIRECTORIES=" + objects,
+	}, nil
+}
+
+// receiveChecks holds what checking a push's commands needs to know
+// about the repository.
+type receiveChecks struct {
+	// currentBranch is the branch checked out in the working tree, which
+	// a push mustn't move; it is empty for a bare repository.
+	currentBranch string
+	// denyNonFastForwards is receive.denyNonFastForwards: whether a
+	// branch may only move forward. Otherwise it is up to the client to
+	// insist on that unless forced, as git's does.
+	denyNonFastForwards bool
+}
+
+func (h *SmartHandler) receiveChecks() (*receiveChecks, error) {
+	cfg, err := repository.ParseConfig(h.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	checks := &receiveChecks{}
+	if checks.denyNonFastForwards, _, err = cfg.GetBool("receive", "denynonfastforwards"); err != nil {
+		return nil, err
+	}
+	bare, _, err := cfg.GetBool("core", "bare")
+	if err != nil {
+		return nil, err
+	}
+	if !bare {
+		name, ok, err := repository.CurrentBranch(h.gitDir)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			checks.currentBranch = "refs/heads/" + name
+		}
+	}
+	return checks, nil
+}
+
+// check returns why c may not go ahead, or "" if it may. q holds the
+// pushed objects; whether the ref is still at c.old is left to the
+// update itself.
+func (rc *receiveChecks) check(q *object.Quarantine, c *receiveCommand) string {
+	if err := repository.CheckRefName(c.ref); err != nil {
+		return "funny refname"
+	}
+	if c.ref == rc.currentBranch {
+		if c.isDelete() {
+			return "deletion of the current branch prohibited"
+		}
+		return "branch is currently checked out"
+	}
+	if !rc.denyNonFastForwards || c.isDelete() || c.old == zeroHash || !strings.HasPrefix(c.ref, "refs/heads/") {
+		return ""
+	}
+	ff, err := isAncestor(q, c.old, c.new)
+	if err != nil {
+		return "bad ref"
+	}
+	if !ff {
+		return "non-fast-forward"
+	}
+	return ""
+}
+
+// isAncestor reports whether the commit ancestor is commit or one of its
+// ancestors, reading commits through q.
+func isAncestor(q *object.Quarantine, ancestor, commit string) (bool, error) {
+	seen := map[string]bool{commit: true}
+	queue := []string{commit}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if hash == ancestor {
+			return true, nil
+		}
+		obj, err := q.Read(hash)
+		if err != nil {
+			return false, err
+		}
+		c, err := object.ParseCommit(obj)
+		if err != nil {
+			return false, err
+		}
+		for _, p := range c.Parents {
+			if !seen[p] {
+				seen[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/repository"
+	"github.com/elliota43/rev/internal/trace"
+)
+
+// pushed is a commit on top of a smartRepo's second, made in another
+// repository, and the pack that brings it.
+type pushed struct {
+	commit, tree, blob string
+	pack               []byte
+}
+
+// newPush makes a commit whose parent is parent and whose tree adds a
+// file c to the files a and b the smartRepo's second commit has. With
+// withBlob false, the pack leaves out c's blob.
+func newPush(t *testing.T, r *smartRepo, parent string, withBlob bool) *pushed {
+	t.Helper()
+	repo, err := repository.Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(typ object.Type, body []byte) string {
+		t.Helper()
+		h, err := object.WriteObject(repo.GitDir, typ, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	p := &pushed{blob: write(object.TypeBlob, []byte("c\n"))}
+	var entries []object.TreeEntry
+	for _, name := range []string{"a", "b"} {
+		hash := object.HashBytes([]byte(object.Header(object.TypeBlob, 2) + name + "\n"))
+		entries = append(entries, object.TreeEntry{Mode: object.ModeFile, Name: name, Hash: hash})
+	}
+	entries = append(entries, object.TreeEntry{Mode: object.ModeFile, Name: "c", Hash: p.blob})
+	body, err := object.EncodeTree(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.tree = write(object.TypeTree, body)
+	sig := object.Signature{Name: "A", Email: "a@example.com", When: 2, Timezone: "+0000"}
+	p.commit = write(object.TypeCommit, (&object.Commit{Tree: p.tree, Parents: []string{parent}, Author: sig, Committer: sig, Message: "c\n"}).Bytes())
+
+	objects := []string{p.commit, p.tree}
+	if withBlob {
+		objects = append(objects, p.blob)
+	}
+	var pack bytes.Buffer
+	if _, err := object.WritePack(&pack, repo.GitDir, objects); err != nil {
+		t.Fatal(err)
+	}
+	p.pack = pack.Bytes()
+	return p
+}
+
+// receivePack posts commands and pack to git-receive-pack, asking for a
+// report, and returns the report.
+func (r *smartRepo) receivePack(t *testing.T, pack []byte, caps string, commands ...string) string {
+	t.Helper()
+	commands[0] += "\x00report-status " + caps
+	request := pkts(append(commands, "")...) + string(pack)
+	resp, err := http.Post(r.srv.URL+"/git-receive-pack", "application/x-git-receive-pack-request", strings.NewReader(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+// setConfig sets a variable in r's config.
+func (r *smartRepo) setConfig(t *testing.T, section, key, value string) {
+	t.Helper()
+	cfg, err := repository.ParseConfig(r.gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Set(section, key, value)
+	if err := repository.WriteConfig(r.gitDir, cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (r *smartRepo) ref(t *testing.T, name string) string {
+	t.Helper()
+	hash, err := repository.ResolveRef(r.gitDir, name)
+	if err != nil {
+		return ""
+	}
+	return hash
+}
+
+func TestSmartHandler_InfoRefsReceivePack(t *testing.T) {
+	r := newSmartRepo(t)
+	r.setConfig(t, "http", "receivepack", "true")
+
+	code, body := get(t, r.srv.URL+"/info/refs?service=git-receive-pack")
+	want := pkts("# service=git-receive-pack", "",
+		r.second+" refs/heads/main\x00report-status delete-refs atomic side-band-64k ofs-delta agent=rev",
+		r.v1tag+" refs/tags/v1",
+		"")
+	if code != http.StatusOK || body != want {
+		t.Errorf("got %d:\n%q\nwant:\n%q", code, body, want)
+	}
+}
+
+func TestSmartHandler_Push(t *testing.T) {
+	r := newSmartRepo(t)
+	r.setConfig(t, "http", "receivepack", "true")
+	p := newPush(t, r, r.second, true)
+
+	// A new branch, and main, which is checked out, in one push.
+	got := r.receivePack(t, p.pack, "",
+		zeroHash+" "+p.commit+" refs/heads/topic",
+		r.second+" "+p.commit+" refs/heads/main")
+	want := pkts("unpack ok", "ok refs/heads/topic", "ng refs/heads/main branch is currently checked out", "")
+	if got != want {
+		t.Errorf("push: got %q, want %q", got, want)
+	}
+	if ref := r.ref(t, "refs/heads/topic"); ref != p.commit {
+		t.Errorf("topic = %s, want %s", ref, p.commit)
+	}
+	for _, h := range []string{p.commit, p.tree, p.blob} {
+		if err := object.VerifyObject(r.gitDir, h); err != nil {
+			t.Errorf("pushed object: %v", err)
+		}
+	}
+
+	// An update from a value the ref no longer has fails.
+	got = r.receivePack(t, emptyPack(t), "", r.first+" "+r.second+" refs/heads/topic")
+	if want := pkts("unpack ok", "ng refs/heads/topic failed to update ref", ""); got != want {
+		t.Errorf("stale update: got %q, want %q", got, want)
+	}
+
+	// Deleting alone sends no pack.
+	got = r.receivePack(t, nil, "", r.v1tag+" "+zeroHash+" refs/tags/v1")
+	if want := pkts("unpack ok", "ok refs/tags/v1", ""); got != want {
+		t.Errorf("delete: got %q, want %q", got, want)
+	}
+	if ref := r.ref(t, "refs/tags/v1"); ref != "" {
+		t.Errorf("v1 still at %s", ref)
+	}
+}
+
+func TestSmartHandler_PushIncomplete(t *testing.T) {
+	r := newSmartRepo(t)
+	r.setConfig(t, "http", "receivepack", "true")
+	p := newPush(t, r, r.second, false)
+
+	got := r.receivePack(t, p.pack, "", zeroHash+" "+p.commit+" refs/heads/topic")
+	if !strings.Contains(got, "unpack pack is incomplete") || !strings.Contains(got, "ng refs/heads/topic unpacker error") {
+		t.Errorf("got %q", got)
+	}
+	if ref := r.ref(t, "refs/heads/topic"); ref != "" {
+		t.Errorf("topic created at %s", ref)
+	}
+	if err := object.Exists(r.gitDir, p.commit); err == nil {
+		t.Error("objects from a refused pack were kept")
+	}
+}
+
+func TestSmartHandler_PushNonFastForward(t *testing.T) {
+	r := newSmartRepo(t)
+	r.setConfig(t, "http", "receivepack", "true")
+	p := newPush(t, r, r.second, true)
+	if got := r.receivePack(t, p.pack, "", zeroHash+" "+p.commit+" refs/heads/topic"); !strings.Contains(got, "ok refs/heads/topic") {
+		t.Fatalf("creating topic: %q", got)
+	}
+	rewind := []string{p.commit + " " + r.first + " refs/heads/topic", zeroHash + " " + r.first + " refs/heads/other"}
+
+	// Going back is a forced push, which is allowed by default.
+	got := r.receivePack(t, emptyPack(t), "", rewind[0])
+	if want := pkts("unpack ok", "ok refs/heads/topic", ""); got != want {
+		t.Errorf("forced push: got %q, want %q", got, want)
+	}
+
+	// receive.denyNonFastForwards turns it down, and with atomic, the
+	// rest of the push too.
+	r.setConfig(t, "receive", "denyNonFastForwards", "true")
+	// Forward again first.
+	r.receivePack(t, emptyPack(t), "", r.first+" "+p.commit+" refs/heads/topic")
+	got = r.receivePack(t, emptyPack(t), "atomic", rewind...)
+	want := pkts("unpack ok", "ng refs/heads/topic non-fast-forward", "ng refs/heads/other atomic push failed", "")
+	if got != want {
+		t.Errorf("atomic: got %q, want %q", got, want)
+	}
+	if ref := r.ref(t, "refs/heads/other"); ref != "" {
+		t.Errorf("other created at %s by a failed atomic push", ref)
+	}
+}
+
+func TestSmartHandler_ReceivePackDisabled(t *testing.T) {
+	r := newSmartRepo(t)
+	p := newPush(t, r, r.second, true)
+	request := pkts(zeroHash+" "+p.commit+" refs/heads/topic\x00report-status", "") + string(p.pack)
+	post := func(url string) int {
+		t.Helper()
+		resp, err := http.Post(url+"/git-receive-pack", "application/x-git-receive-pack-request", strings.NewReader(request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Off by default, and with http.receivepack false.
+	for _, value := range []string{"", "false"} {
+		if value != "" {
+			r.setConfig(t, "http", "receivepack", value)
+		}
+		if code, body := get(t, r.srv.URL+"/info/refs?service=git-receive-pack"); code != http.StatusForbidden {
+			t.Errorf("http.receivepack=%q: info/refs got %d %q", value, code, body)
+		}
+		if code := post(r.srv.URL); code != http.StatusForbidden {
+			t.Errorf("http.receivepack=%q: push got %d", value, code)
+		}
+	}
+	if ref := r.ref(t, "refs/heads/topic"); ref != "" {
+		t.Errorf("topic created at %s", ref)
+	}
+
+	// ReceivePack turns it on regardless.
+	h := NewSmartHandler(r.gitDir)
+	h.ReceivePack = true
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	if code, _ := get(t, srv.URL+"/info/refs?service=git-receive-pack"); code != http.StatusOK {
+		t.Errorf("ReceivePack: info/refs got %d", code)
+	}
+	if code := post(srv.URL); code != http.StatusOK {
+		t.Errorf("ReceivePack: push got %d", code)
+	}
+	if ref := r.ref(t, "refs/heads/topic"); ref != p.commit {
+		t.Errorf("topic = %s, want %s", ref, p.commit)
+	}
+}
+
+func TestSmartHandler_PushTooLarge(t *testing.T) {
+	r := newSmartRepo(t)
+	r.setConfig(t, "http", "receivepack", "true")
+	p := newPush(t, r, r.second, true)
+	r.setConfig(t, "receive", "maxInputSize", strconv.Itoa(len(p.pack)-1))
+
+	got := r.receivePack(t, p.pack, "", zeroHash+" "+p.commit+" refs/heads/topic")
+	want := pkts("unpack reading pack: pack exceeds maximum allowed size", "ng refs/heads/topic unpacker error", "")
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if ref := r.ref(t, "refs/heads/topic"); ref != "" {
+		t.Errorf("topic created at %s", ref)
+	}
+
+	// A pack of exactly the limit is fine.
+	r.setConfig(t, "receive", "maxInputSize", strconv.Itoa(len(p.pack)))
+	got = r.receivePack(t, p.pack, "", zeroHash+" "+p.commit+" refs/heads/topic")
+	if want := pkts("unpack ok", "ok refs/heads/topic", ""); got != want {
+		t.Errorf("at the limit: got %q, want %q", got, want)
+	}
+}
+
+// emptyPack returns a pack with no objects, which is what git sends to
+// point a ref at a commit the server already has.
+func emptyPack(t *testing.T) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if _, err := object.WritePack(&b, t.TempDir(), nil); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestSmartHandler_Trace(t *testing.T) {
+	r := newSmartRepo(t)
+	r.setConfig(t, "http", "receivepack", "true")
+	p := newPush(t, r, r.second, true)
+
+	var buf bytes.Buffer
+	trace.SetOutput(&buf)
+	t.Cleanup(func() { trace.SetOutput(nil) })
+	r.uploadPack(t, pkts("want "+r.second+" multi_ack_detailed", "", "have "+r.first, "done"))
+	r.receivePack(t, p.pack, "", zeroHash+" "+p.commit+" refs/heads/topic")
+	get(t, r.srv.URL+"/HEAD")
+
+	for _, want := range []string{
+		"server: upload-pack: 1 wants, 1 haves, done true\n",
+		"server: upload-pack: 1 of 1 haves in common\n",
+		" (3 objects) in ",
+		"server: receive-pack: 1 commands\n",
+		"server: receive-pack: refs/heads/topic " + zeroHash + " -> " + p.commit + "\n",
+		"server: receive-pack: received pack (",
+		"server: receive-pack: ok refs/heads/topic\n",
+		"server: dumb GET /HEAD\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("trace output missing %q:\n%s", want, buf.String())
+		}
+	}
+}
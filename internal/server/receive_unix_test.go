@@ -0,0 +1,94 @@
+//go:build unix
+
+package server
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeHook installs script as the hook name in r.
+func (r *smartRepo) writeHook(t *testing.T, name, script string) {
+	t.Helper()
+	dir := filepath.Join(r.gitDir, "hooks")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// demux splits a side-band response into what came on band 2 and the
+// pkt-lines carried on band 1.
+func demux(t *testing.T, resp string) (progress, report string) {
+	t.Helper()
+	in := bufio.NewReader(strings.NewReader(resp))
+	var b1, b2 strings.Builder
+	for {
+		data, err := readPkt(in)
+		if err == errFlush {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch data[0] {
+		case 1:
+			b1.Write(data[1:])
+		case 2:
+			b2.Write(data[1:])
+		default:
+			t.Fatalf("band %d: %q", data[0], data[1:])
+		}
+	}
+	return b2.String(), b1.String()
+}
+
+func TestSmartHandler_PushHooks(t *testing.T) {
+	r := newSmartRepo(t)
+	r.setConfig(t, "http", "receivepack", "true")
+	p := newPush(t, r, r.second, true)
+	log := filepath.Join(t.TempDir(), "log")
+
+	// pre-receive can see the pushed objects before they are promoted;
+	// update turns down one ref; post-receive hears only of the other.
+	r.writeHook(t, "pre-receive", `cat >>`+log+`; test -n "$GIT_QUARANTINE_PATH" && test -d "$GIT_OBJECT_DIRECTORY" && echo pre ok`+"\n")
+	r.writeHook(t, "update", `echo "update $1"; test "$1" != refs/heads/no`+"\n")
+	r.writeHook(t, "post-receive", `sed 's/^/post /' >>`+log+"; echo post ok\n")
+
+	got := r.receivePack(t, p.pack, "side-band-64k",
+		zeroHash+" "+p.commit+" refs/heads/topic",
+		zeroHash+" "+p.commit+" refs/heads/no")
+	progress, report := demux(t, got)
+	if want := "pre ok\nupdate refs/heads/topic\nupdate refs/heads/no\npost ok\n"; progress != want {
+		t.Errorf("hook output: got %q, want %q", progress, want)
+	}
+	if want := pkts("unpack ok", "ok refs/heads/topic", "ng refs/heads/no hook declined", ""); report != want {
+		t.Errorf("report: got %q, want %q", report, want)
+	}
+	if ref := r.ref(t, "refs/heads/no"); ref != "" {
+		t.Errorf("no created at %s", ref)
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic, no := zeroHash+" "+p.commit+" refs/heads/topic\n", zeroHash+" "+p.commit+" refs/heads/no\n"
+	if want := topic + no + "post " + topic; string(data) != want {
+		t.Errorf("hook input: got %q, want %q", data, want)
+	}
+
+	// A failing pre-receive turns down the whole push.
+	r.writeHook(t, "pre-receive", "exit 1\n")
+	got = r.receivePack(t, emptyPack(t), "", r.v1tag+" "+zeroHash+" refs/tags/v1", zeroHash+" "+r.second+" refs/heads/other")
+	if want := pkts("unpack ok", "ng refs/tags/v1 pre-receive hook declined", "ng refs/heads/other pre-receive hook declined", ""); got != want {
+		t.Errorf("pre-receive declined: got %q, want %q", got, want)
+	}
+	if ref := r.ref(t, "refs/tags/v1"); ref != r.v1tag {
+		t.Errorf("v1 = %q after a declined push", ref)
+	}
+}
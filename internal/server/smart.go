@@ -0,0 +1,402 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/repository"
+	"github.com/elliota43/rev/internal/trace"
+)
+
+var objectName = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// zeroHash stands in for a ref's value when there are no refs to list.
+const zeroHash = "0000000000000000000000000000000000000000"
+
+// uploadPackCaps are the capabilities advertised with the refs.
+// multi_ack_detailed is the negotiation git's client expects over HTTP;
+// packs are sent over side-band-64k when the client asks for it. No
+// progress is ever sent, so no-progress is accepted but changes nothing.
+var uploadPackCaps = []string{"multi_ack_detailed", "side-band-64k", "no-progress", "agent=rev"}
+
+// SmartHandler serves a repository over git's "smart" HTTP protocol, so
+// git can clone and fetch from it: the client is told what the refs
+// are, says which it wants and which commits it already has, and gets
+// back a pack of just the objects it is missing. Pushes go the other
+// way, through receive-pack, if it is enabled. Each request stands
+// alone, as in git's stateless-RPC mode. Anything else, including
+// clients that don't ask for a service, is handed to a DumbHandler.
+// Packs are written without deltas, and only SHA-1 repositories can be
+// served.
+type SmartHandler struct {
+	gitDir string
+	dumb   *DumbHandler
+
+	// ReceivePack lets clients push, whatever http.receivepack says.
+	// Otherwise, as git's http-backend treats a client that hasn't
+	// authenticated, which none here have, pushing is refused unless
+	// http.receivepack is true.
+	ReceivePack bool
+}
+
+// NewSmartHandler returns a handler serving the repository at gitDir.
+func NewSmartHandler(gitDir string) *SmartHandler {
+	return &SmartHandler{gitDir: gitDir, dumb: NewDumbHandler(gitDir)}
+}
+
+func (h *SmartHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := path.Clean("/" + r.URL.Path)
+	service := r.URL.Query().Get("service")
+	switch {
+	case p == "/info/refs" && service != "":
+		h.serveInfoRefs(w, r, service)
+	case p == "/git-upload-pack":
+		h.serveUploadPack(w, r)
+	case p == "/git-receive-pack":
+		if h.denyReceivePack(w) {
+			return
+		}
+		h.serveReceivePack(w, r)
+	default:
+		h.dumb.ServeHTTP(w, r)
+	}
+}
+
+// serveInfoRefs answers a smart client's first request with the ref
+// advertisement for service.
+func (h *SmartHandler) serveInfoRefs(w http.ResponseWriter, r *http.Request, service string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var refs []repository.Ref
+	var caps []string
+	var err error
+	switch service {
+	case "git-upload-pack":
+		refs, err = h.advertisedRefs()
+		if err == nil {
+			caps, err = h.uploadPackCaps(refs)
+		}
+	case "git-receive-pack":
+		if h.denyReceivePack(w) {
+			return
+		}
+		refs, err = repository.ListRefs(h.gitDir)
+		caps = receivePackCaps
+	default:
+		http.Error(w, "service not enabled: "+service, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if trace.Enabled() {
+		trace.Log("server", "info/refs for %s: %d refs", service, len(refs))
+	}
+
+	var b bytes.Buffer
+	writePktf(&b, "# service=%s\n", service)
+	writeFlush(&b)
+	advertiseRefs(&b, refs, caps)
+	w.Header().Set("Content-Type", "application/x-"+service+"-advertisement")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(b.Bytes())
+}
+
+// advertiseRefs writes one pkt-line per ref, the first carrying the
+// capabilities after a NUL, then a flush-pkt.
+func advertiseRefs(w io.Writer, refs []repository.Ref, caps []string) {
+	if len(refs) == 0 {
+		writePktf(w, "%s capabilities^{}\x00%s\n", zeroHash, strings.Join(caps, " "))
+	}
+	for i, ref := range refs {
+		if i == 0 {
+			writePktf(w, "%s %s\x00%s\n", ref.Hash, ref.Name, strings.Join(caps, " "))
+			continue
+		}
+		writePktf(w, "%s %s\n", ref.Hash, ref.Name)
+	}
+	writeFlush(w)
+}
+
+// uploadPackCaps returns the capabilities to advertise with refs, the
+// upload-pack refs: uploadPackCaps, and the branch HEAD is on, if refs
+// lists HEAD.
+func (h *SmartHandler) uploadPackCaps(refs []repository.Ref) ([]string, error) {
+	caps := append([]string(nil), uploadPackCaps...)
+	target, ok, err := repository.ReadSymbolicRef(h.gitDir, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	if ok && len(refs) > 0 && refs[0].Name == "HEAD" {
+		caps = append(caps, "symref=HEAD:"+target)
+	}
+	return caps, nil
+}
+
+// advertisedRefs returns the refs upload-pack offers: HEAD, unless it is
+// an unborn branch, then every ref, each annotated tag followed by
+// "<name>^{}" with what the tag peels to.
+func (h *SmartHandler) advertisedRefs() ([]repository.Ref, error) {
+	var refs []repository.Ref
+	head, err := repository.ResolveHead(h.gitDir)
+	switch {
+	case err == nil:
+		refs = append(refs, repository.Ref{Name: "HEAD", Hash: head})
+	case !errors.Is(err, repository.ErrUnbornBranch):
+		return nil, err
+	}
+
+	all, err := repository.ListRefs(h.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range all {
+		refs = append(refs, ref)
+		peeled, err := peelTags(h.gitDir, ref.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if peeled != ref.Hash {
+			refs = append(refs, repository.Ref{Name: ref.Name + "^{}", Hash: peeled})
+		}
+	}
+	return refs, nil
+}
+
+// maxTagDepth bounds how many annotated tags peelTags follows.
+const maxTagDepth = 32
+
+// peelTags follows hash through annotated tags to the first object that
+// isn't one.
+func peelTags(gitDir, hash string) (string, error) {
+	for range maxTagDepth {
+		typ, _, err := object.ReadHeader(gitDir, hash)
+		if err != nil {
+			return "", err
+		}
+		if typ != object.TypeTag {
+			return hash, nil
+		}
+		obj, err := object.Read(gitDir, hash)
+		if err != nil {
+			return "", err
+		}
+		tag, err := object.ParseTag(obj)
+		if err != nil {
+			return "", err
+		}
+		hash = tag.Object
+	}
+	return "", fmt.Errorf("%s: too many levels of tags", hash)
+}
+
+// uploadRequest is what a client sends to git-upload-pack.
+type uploadRequest struct {
+	wants []string
+	// caps are the capabilities the client turned on, from its first
+	// want line.
+	caps  map[string]bool
+	haves []string
+	// done is set when the client has finished negotiating and wants
+	// its pack. Without it, the request is one round of haves, answered
+	// with acknowledgements alone.
+	done bool
+}
+
+// parseUploadRequest reads the want lines, a flush-pkt, then have lines
+// up to "done" or another flush-pkt.
+func parseUploadRequest(r *bufio.Reader) (*uploadRequest, error) {
+	req := &uploadRequest{caps: make(map[string]bool)}
+	for {
+		line, err := readPktText(r)
+		if err == errFlush {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "want" || !objectName.MatchString(fields[1]) {
+			return nil, fmt.Errorf("expected want, got %q", line)
+		}
+		if len(req.wants) == 0 {
+			for _, c := range fields[2:] {
+				req.caps[c] = true
+			}
+		}
+		req.wants = append(req.wants, fields[1])
+	}
+	// A client with nothing to fetch sends only the flush-pkt.
+	if len(req.wants) == 0 {
+		return req, nil
+	}
+
+	for {
+		line, err := readPktText(r)
+		if err == errFlush {
+			return req, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == "done" {
+			req.done = true
+			return req, nil
+		}
+		hash, ok := strings.CutPrefix(line, "have ")
+		if !ok || !objectName.MatchString(hash) {
+			return nil, fmt.Errorf("expected have or done, got %q", line)
+		}
+		req.haves = append(req.haves, hash)
+	}
+}
+
+// serveUploadPack answers a POST to git-upload-pack: it acknowledges the
+// haves it recognizes and, once the client is done, sends the pack.
+func (h *SmartHandler) serveUploadPack(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/x-git-upload-pack-request" {
+		http.Error(w, "expected an upload-pack request", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := requestBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+	req, err := parseUploadRequest(bufio.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if trace.Enabled() {
+		trace.Log("server", "upload-pack: %d wants, %d haves, done %t", len(req.wants), len(req.haves), req.done)
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+	w.Header().Set("Cache-Control", "no-cache")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+	if err := h.uploadPack(out, req); err != nil {
+		writePktf(out, "ERR upload-pack: %s\n", err)
+	}
+}
+
+// requestBody returns r's body, inflated if the client gzipped it, as
+// git does with large requests.
+func requestBody(r *http.Request) (io.ReadCloser, error) {
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		return r.Body, nil
+	}
+	return gzip.NewReader(r.Body)
+}
+
+// uploadPack writes the response to req, following git's upload-pack.
+// Errors found before anything is written are returned, for the caller
+// to send as an ERR line; a failure while writing the pack is reported
+// on the error band if there is one.
+func (h *SmartHandler) uploadPack(w io.Writer, req *uploadRequest) error {
+	if len(req.wants) == 0 {
+		return nil
+	}
+	refs, err := h.advertisedRefs()
+	if err != nil {
+		return err
+	}
+	ours := make(map[string]bool)
+	for _, ref := range refs {
+		ours[ref.Hash] = true
+	}
+	for _, want := range req.wants {
+		if !ours[want] {
+			return fmt.Errorf("not our ref %s", want)
+		}
+	}
+
+	// Each have the repository also has is acknowledged. With
+	// multi_ack_detailed that is every one, as "common"; without it,
+	// only the first.
+	multiAck := req.caps["multi_ack_detailed"]
+	var common []string
+	known := make(map[string]bool)
+	for _, have := range req.haves {
+		if known[have] || object.Exists(h.gitDir, have) != nil {
+			continue
+		}
+		known[have] = true
+		switch {
+		case multiAck:
+			writePktf(w, "ACK %s common\n", have)
+		case len(common) == 0:
+			writePktf(w, "ACK %s\n", have)
+		}
+		common = append(common, have)
+	}
+	if trace.Enabled() {
+		trace.Log("server", "upload-pack: %d of %d haves in common", len(common), len(req.haves))
+	}
+	if !req.done {
+		if len(common) == 0 || multiAck {
+			writePktf(w, "NAK\n")
+		}
+		return nil
+	}
+	switch {
+	case len(common) == 0:
+		writePktf(w, "NAK\n")
+	case multiAck:
+		writePktf(w, "ACK %s\n", common[len(common)-1])
+	}
+
+	start := time.Now()
+	objects, err := object.Reachable(h.gitDir, req.wants, common)
+	if err != nil {
+		return err
+	}
+	if !req.caps["side-band-64k"] {
+		written, err := object.WritePack(w, h.gitDir, objects)
+		if err == nil {
+			traceSentPack(written, len(objects), start)
+		}
+		return err
+	}
+	// Buffered so each pkt-line carries as much of the pack as it can.
+	pack := bufio.NewWriterSize(sidebandWriter{w, 1}, maxPktLen-5)
+	written, err := object.WritePack(pack, h.gitDir, objects)
+	if err == nil {
+		err = pack.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(sidebandWriter{w, 3}, "%s\n", err)
+		return nil
+	}
+	traceSentPack(written, len(objects), start)
+	return writeFlush(w)
+}
+
+// traceSentPack logs the pack upload-pack sent, counting the time from
+// start, when the walk for its objects began.
+func traceSentPack(p *object.WrittenPack, objects int, start time.Time) {
+	if trace.Enabled() {
+		trace.Log("server", "upload-pack: sent pack %s (%d objects) in %s", p.Checksum, objects, trace.Since(start))
+	}
+}
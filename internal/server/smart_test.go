@@ -0,0 +1,259 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/repository"
+)
+
+// smartRepo is a repository with two commits on main, each adding a
+// file, and an annotated tag v1 of the first.
+type smartRepo struct {
+	srv                  *httptest.Server
+	gitDir               string
+	first, second, v1tag string
+}
+
+func newSmartRepo(t *testing.T) *smartRepo {
+	t.Helper()
+	repo, err := repository.Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(typ object.Type, body []byte) string {
+		t.Helper()
+		h, err := object.WriteObject(repo.GitDir, typ, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	sig := object.Signature{Name: "A", Email: "a@example.com", When: 1, Timezone: "+0000"}
+	var entries []object.TreeEntry
+	commit := func(name string, parents ...string) string {
+		t.Helper()
+		entries = append(entries, object.TreeEntry{Mode: object.ModeFile, Name: name, Hash: write(object.TypeBlob, []byte(name+"\n"))})
+		body, err := object.EncodeTree(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &object.Commit{Tree: write(object.TypeTree, body), Parents: parents, Author: sig, Committer: sig, Message: name + "\n"}
+		return write(object.TypeCommit, c.Bytes())
+	}
+
+	r := &smartRepo{gitDir: repo.GitDir}
+	r.first = commit("a")
+	r.second = commit("b", r.first)
+	r.v1tag = write(object.TypeTag, (&object.Tag{Object: r.first, Type: object.TypeCommit, Tag: "v1", Tagger: sig, Message: "v1\n"}).Bytes())
+	for ref, hash := range map[string]string{"refs/heads/main": r.second, "refs/tags/v1": r.v1tag} {
+		if err := repository.UpdateRef(repo.GitDir, ref, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r.srv = httptest.NewServer(NewSmartHandler(repo.GitDir))
+	t.Cleanup(r.srv.Close)
+	return r
+}
+
+// pkts encodes lines as pkt-lines; "" is a flush-pkt.
+func pkts(lines ...string) string {
+	var b bytes.Buffer
+	for _, l := range lines {
+		if l == "" {
+			writeFlush(&b)
+		} else {
+			writePktf(&b, "%s\n", l)
+		}
+	}
+	return b.String()
+}
+
+// uploadPack posts request to git-upload-pack and returns the response.
+func (r *smartRepo) uploadPack(t *testing.T, request string) []byte {
+	t.Helper()
+	resp, err := http.Post(r.srv.URL+"/git-upload-pack", "application/x-git-upload-pack-request", strings.NewReader(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-git-upload-pack-result" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	return body
+}
+
+// unpack loads pack into a new repository and returns how many objects
+// it held.
+func unpack(t *testing.T, pack []byte) int {
+	t.Helper()
+	repo, err := repository.Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := object.UnpackObjects(repo.GitDir, bytes.NewReader(pack), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSmartHandler_InfoRefs(t *testing.T) {
+	r := newSmartRepo(t)
+
+	resp, err := http.Get(r.srv.URL + "/info/refs?service=git-upload-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-git-upload-pack-advertisement" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	want := pkts("# service=git-upload-pack", "",
+		r.second+" HEAD\x00multi_ack_detailed side-band-64k no-progress agent=rev symref=HEAD:refs/heads/main",
+		r.second+" refs/heads/main",
+		r.v1tag+" refs/tags/v1",
+		r.first+" refs/tags/v1^{}",
+		"")
+	if string(body) != want {
+		t.Errorf("got:\n%q\nwant:\n%q", body, want)
+	}
+}
+
+func TestSmartHandler_InfoRefsEmpty(t *testing.T) {
+	repo, err := repository.Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewSmartHandler(repo.GitDir))
+	defer srv.Close()
+
+	code, body := get(t, srv.URL+"/info/refs?service=git-upload-pack")
+	want := pkts("# service=git-upload-pack", "",
+		zeroHash+" capabilities^{}\x00multi_ack_detailed side-band-64k no-progress agent=rev", "")
+	if code != http.StatusOK || body != want {
+		t.Errorf("got %d %q, want %q", code, body, want)
+	}
+}
+
+func TestSmartHandler_Clone(t *testing.T) {
+	r := newSmartRepo(t)
+
+	resp := r.uploadPack(t, pkts("want "+r.second+" side-band-64k", "want "+r.v1tag, "", "done"))
+	in := bufio.NewReader(bytes.NewReader(resp))
+	if line, err := readPktText(in); line != "NAK" || err != nil {
+		t.Fatalf("got %q, %v; want NAK", line, err)
+	}
+	var pack []byte
+	for {
+		data, err := readPkt(in)
+		if err == errFlush {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data[0] != 1 {
+			t.Fatalf("band %d: %q", data[0], data[1:])
+		}
+		pack = append(pack, data[1:]...)
+	}
+	// Two commits, two trees, two blobs, and the tag.
+	if n := unpack(t, pack); n != 7 {
+		t.Errorf("pack has %d objects, want 7", n)
+	}
+}
+
+func TestSmartHandler_Fetch(t *testing.T) {
+	r := newSmartRepo(t)
+	unknown := strings.Repeat("1", 40)
+
+	// A round of negotiation acknowledges the haves the server has, and
+	// sends no pack.
+	resp := r.uploadPack(t, pkts("want "+r.second+" multi_ack_detailed", "", "have "+unknown, "have "+r.first, ""))
+	if want := pkts("ACK "+r.first+" common", "NAK"); string(resp) != want {
+		t.Errorf("negotiation: got %q, want %q", resp, want)
+	}
+
+	// Once done, the pack has only the second commit, its tree, and the
+	// blob it added.
+	resp = r.uploadPack(t, pkts("want "+r.second+" multi_ack_detailed", "", "have "+r.first, "done"))
+	acks := pkts("ACK "+r.first+" common", "ACK "+r.first)
+	if !bytes.HasPrefix(resp, []byte(acks)) {
+		t.Fatalf("got %q, want it to start %q", resp, acks)
+	}
+	if n := unpack(t, resp[len(acks):]); n != 3 {
+		t.Errorf("pack has %d objects, want 3", n)
+	}
+
+	// Without multi_ack_detailed, only the first common have is
+	// acknowledged.
+	resp = r.uploadPack(t, pkts("want "+r.second, "", "have "+r.first, "have "+r.v1tag, ""))
+	if want := pkts("ACK " + r.first); string(resp) != want {
+		t.Errorf("single ack: got %q, want %q", resp, want)
+	}
+
+	// Nothing is wanted when the client is already up to date.
+	if resp := r.uploadPack(t, pkts("")); len(resp) != 0 {
+		t.Errorf("no wants: got %q", resp)
+	}
+}
+
+func TestSmartHandler_Rejects(t *testing.T) {
+	r := newSmartRepo(t)
+
+	unknown := strings.Repeat("2", 40)
+	resp := r.uploadPack(t, pkts("want "+unknown, "", "done"))
+	if want := pkts("ERR upload-pack: not our ref " + unknown); string(resp) != want {
+		t.Errorf("unadvertised want: got %q", resp)
+	}
+
+	for _, tt := range []struct {
+		method, path, contentType, body string
+		want                            int
+	}{
+		{"GET", "/info/refs?service=git-frobnicate", "", "", http.StatusForbidden},
+		{"POST", "/info/refs?service=git-upload-pack", "", "", http.StatusMethodNotAllowed},
+		{"GET", "/git-upload-pack", "", "", http.StatusMethodNotAllowed},
+		{"POST", "/git-upload-pack", "text/plain", pkts("", "done"), http.StatusUnsupportedMediaType},
+		{"POST", "/git-upload-pack", "application/x-git-upload-pack-request", pkts("have "+r.first, ""), http.StatusBadRequest},
+	} {
+		req, err := http.NewRequest(tt.method, r.srv.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", tt.contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s: got %d, want %d", tt.method, tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestSmartHandler_DumbFallback(t *testing.T) {
+	r := newSmartRepo(t)
+
+	code, body := get(t, r.srv.URL+"/info/refs")
+	if want := r.second + "\trefs/heads/main\n" + r.v1tag + "\trefs/tags/v1\n"; code != http.StatusOK || body != want {
+		t.Errorf("dumb info/refs: got %d %q, want %q", code, body, want)
+	}
+}
@@ -0,0 +1,102 @@
+// Package trace provides GIT_TRACE-style event logging for diagnosing slow
+// commands. It is configured at startup from the REV_TRACE environment
+// variable: "1", "2", or "true" log to stderr, and an absolute path
+// appends to that file. Anything else (including unset) disables tracing.
+//
+// Hot paths should guard calls with Enabled so that disabled tracing
+// costs a single bool check and no argument formatting:
+//
+//	if trace.Enabled() {
+//		trace.Log("object", "read %s", hash)
+//	}
+package trace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	enabled bool
+
+	mu  sync.Mutex
+	out io.Writer
+	// file is the trace file configure opened, closed when tracing is
+	// reconfigured.
+	file io.Closer
+)
+
+func init() {
+	configure(os.Getenv("REV_TRACE"))
+}
+
+// configure enables tracing according to a REV_TRACE value.
+func configure(value string) {
+	switch value {
+	case "", "0", "false":
+		setOutput(nil, nil)
+	case "1", "2", "true":
+		setOutput(os.Stderr, nil)
+	default:
+		if !filepath.IsAbs(value) {
+			setOutput(nil, nil)
+			return
+		}
+		f, err := os.OpenFile(value, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: could not open REV_TRACE file %s: %v\n", value, err)
+			setOutput(nil, nil)
+			return
+		}
+		setOutput(f, f)
+	}
+}
+
+// SetOutput sends events to w, or disables tracing if w is nil,
+// overriding REV_TRACE. It lets tests capture the events a package logs.
+func SetOutput(w io.Writer) {
+	setOutput(w, nil)
+}
+
+// setOutput switches the trace writer, closing a file the previous
+// configuration opened. owned is closed in turn on the next switch.
+func setOutput(w io.Writer, owned io.Closer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if file != nil {
+		file.Close()
+	}
+	out, file = w, owned
+	enabled = w != nil
+}
+
+// Enabled reports whether tracing is on.
+func Enabled() bool {
+	return enabled
+}
+
+// Log writes a timestamped event under the given category, e.g.
+// "12:34:56.789012 object: read ce0136 (loose)". It does nothing when
+// tracing is disabled.
+func Log(category, format string, args ...any) {
+	if !enabled {
+		return
+	}
+
+	line := fmt.Sprintf("%s %s: %s\n",
+		time.Now().Format("15:04:05.000000"), category, fmt.Sprintf(format, args...))
+
+	mu.Lock()
+	defer mu.Unlock()
+	io.WriteString(out, line)
+}
+
+// Since formats the time elapsed since start for trace messages.
+func Since(start time.Time) string {
+	return time.Since(start).Round(time.Microsecond).String()
+}
@@ -0,0 +1,79 @@
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestConfigure_Disabled(t *testing.T) {
+	for _, v := range []string{"", "0", "false", "relative/path"} {
+		configure(v)
+		if Enabled() {
+			t.Errorf("REV_TRACE=%q should leave tracing disabled", v)
+		}
+	}
+}
+
+func TestLog_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.log")
+	configure(path)
+	defer configure("")
+
+	if !Enabled() {
+		t.Fatal("REV_TRACE=<abs path> should enable tracing")
+	}
+	Log("object", "read %s", "ce0136")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := regexp.MustCompile(`^\d\d:\d\d:\d\d\.\d{6} object: read ce0136\n$`)
+	if !want.Match(data) {
+		t.Errorf("trace output: got %q", data)
+	}
+}
+
+func TestLog_DisabledWritesNothing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.log")
+	configure(path)
+	configure("")
+
+	Log("object", "read %s", "ce0136")
+
+	data, _ := os.ReadFile(path)
+	if len(data) != 0 {
+		t.Errorf("disabled trace wrote %q", data)
+	}
+}
+
+func TestConfigure_ClosesPreviousFile(t *testing.T) {
+	configure(filepath.Join(t.TempDir(), "trace.log"))
+	f := file.(*os.File)
+	configure(filepath.Join(t.TempDir(), "other.log"))
+	defer configure("")
+
+	if _, err := f.Write([]byte("x")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("first trace file still open after reconfiguring: write error %v", err)
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	Log("refs", "lookup %s", "HEAD")
+	SetOutput(nil)
+	Log("refs", "lookup %s", "main")
+
+	if Enabled() {
+		t.Error("SetOutput(nil) should disable tracing")
+	}
+	want := regexp.MustCompile(`^\S+ refs: lookup HEAD\n$`)
+	if !want.Match(buf.Bytes()) {
+		t.Errorf("trace output: got %q", buf.String())
+	}
+}
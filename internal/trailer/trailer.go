@@ -0,0 +1,331 @@
+// Package trailer parses and edits the trailer block at the end of a
+// commit message ("Signed-off-by: ...", "Reviewed-by: ...").
+package trailer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Trailer is a single "Key: value" line.
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+// String formats the trailer as it appears in a message.
+func (t Trailer) String() string {
+	return t.Key + ": " + t.Value
+}
+
+// Signoff returns the Signed-off-by trailer for the given identity.
+func Signoff(name, email string) Trailer {
+	return Trailer{Key: "Signed-off-by", Value: fmt.Sprintf("%s <%s>", name, email)}
+}
+
+// Parse parses a "Key: value" string such as a --trailer argument.
+func Parse(s string) (Trailer, error) {
+	t, ok := parseLine(s)
+	if !ok {
+		return Trailer{}, fmt.Errorf("invalid trailer %q (want \"Key: value\")", s)
+	}
+	return t, nil
+}
+
+// Where says where a new trailer goes, as in --where.
+type Where int
+
+const (
+	// End places the trailer after all existing trailers.
+	End Where = iota
+	// Start places it before all existing trailers.
+	Start
+	// After places it after the last trailer with the same key, or at
+	// the end if there is none.
+	After
+	// Before places it before the first trailer with the same key, or at
+	// the start if there is none.
+	Before
+)
+
+// IfExists says what to do when a trailer with the same key is already
+// present, as in --if-exists.
+type IfExists int
+
+const (
+	// AddIfDifferentNeighbor adds the trailer unless the trailer it would
+	// be placed next to is identical. This is git's default, and is why
+	// signing off twice doesn't duplicate the line.
+	AddIfDifferentNeighbor IfExists = iota
+	// AddIfDifferent adds the trailer unless an identical one exists
+	// anywhere in the block.
+	AddIfDifferent
+	// Add always adds the trailer.
+	Add
+	// Replace deletes the same-key trailer closest to the insertion point
+	// and adds the new one.
+	Replace
+	// DoNothing leaves the message alone.
+	DoNothing
+)
+
+// IfMissing says what to do when no trailer with the same key is
+// present, as in --if-missing.
+type IfMissing int
+
+const (
+	// AddIfMissing adds the trailer (the default).
+	AddIfMissing IfMissing = iota
+	// SkipIfMissing leaves the message alone.
+	SkipIfMissing
+)
+
+// Options controls how Apply places trailers. The zero value matches
+// git's defaults.
+type Options struct {
+	Where     Where
+	IfExists  IfExists
+	IfMissing IfMissing
+}
+
+// ParseWhere parses a --where value.
+func ParseWhere(s string) (Where, error) {
+	switch s {
+	case "end":
+		return End, nil
+	case "start":
+		return Start, nil
+	case "after":
+		return After, nil
+	case "before":
+		return Before, nil
+	}
+	return End, fmt.Errorf("unknown --where value %q", s)
+}
+
+// ParseIfExists parses an --if-exists value.
+func ParseIfExists(s string) (IfExists, error) {
+	switch s {
+	case "addIfDifferentNeighbor":
+		return AddIfDifferentNeighbor, nil
+	case "addIfDifferent":
+		return AddIfDifferent, nil
+	case "add":
+		return Add, nil
+	case "replace":
+		return Replace, nil
+	case "doNothing":
+		return DoNothing, nil
+	}
+	return AddIfDifferentNeighbor, fmt.Errorf("unknown --if-exists value %q", s)
+}
+
+// ParseIfMissing parses an --if-missing value.
+func ParseIfMissing(s string) (IfMissing, error) {
+	switch s {
+	case "add":
+		return AddIfMissing, nil
+	case "doNothing":
+		return SkipIfMissing, nil
+	}
+	return AddIfMissing, fmt.Errorf("unknown --if-missing value %q", s)
+}
+
+// Message is a commit message split into its body and trailer block.
+type Message struct {
+	// Body is everything before the trailer block, without trailing
+	// blank lines.
+	Body string
+	// Trailers is the parsed trailer block, in order.
+	Trailers []Trailer
+
+	// raw holds each trailer's original text, including continuation
+	// lines, so untouched trailers are written back exactly.
+	raw []string
+}
+
+// Split separates msg into its body and trailer block. The block is the
+// final paragraph, provided it isn't also the first (the subject can
+// never be trailers) and every line in it is a trailer or a continuation
+// of one (a line starting with whitespace).
+func Split(msg string) *Message {
+	msg = strings.TrimRight(msg, " \t\r\n")
+	lines := strings.Split(msg, "\n")
+
+	start := len(lines)
+	for start > 0 && strings.TrimSpace(lines[start-1]) != "" {
+		start--
+	}
+	if start == 0 {
+		return &Message{Body: msg}
+	}
+
+	m := &Message{Body: strings.TrimRight(strings.Join(lines[:start], "\n"), " \t\r\n")}
+	for _, line := range lines[start:] {
+		if isContinuation(line) {
+			if len(m.Trailers) == 0 {
+				return &Message{Body: msg}
+			}
+			last := len(m.Trailers) - 1
+			m.Trailers[last].Value += " " + strings.TrimSpace(line)
+			m.raw[last] += "\n" + line
+			continue
+		}
+		t, ok := parseLine(line)
+		if !ok {
+			return &Message{Body: msg}
+		}
+		m.Trailers = append(m.Trailers, t)
+		m.raw = append(m.raw, line)
+	}
+	return m
+}
+
+// String reassembles the message, separating the body from the trailer
+// block with a blank line. The result ends in a newline unless empty.
+func (m *Message) String() string {
+	var b strings.Builder
+	b.WriteString(m.Body)
+	if m.Body != "" && len(m.raw) > 0 {
+		b.WriteString("\n\n")
+	}
+	b.WriteString(strings.Join(m.raw, "\n"))
+	if b.Len() == 0 {
+		return ""
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// Add places t in the trailer block according to opts.
+func (m *Message) Add(t Trailer, opts Options) {
+	same := -1 // index of the same-key trailer nearest the insertion point
+	for i, existing := range m.Trailers {
+		if !strings.EqualFold(existing.Key, t.Key) {
+			continue
+		}
+		if same < 0 || opts.Where == End || opts.Where == After {
+			same = i
+		}
+	}
+
+	if same < 0 {
+		if opts.IfMissing == SkipIfMissing {
+			return
+		}
+		if opts.Where == Start || opts.Where == Before {
+			m.insert(0, t)
+		} else {
+			m.insert(len(m.Trailers), t)
+		}
+		return
+	}
+
+	pos := m.position(t, opts.Where)
+	switch opts.IfExists {
+	case DoNothing:
+		return
+	case AddIfDifferent:
+		for _, existing := range m.Trailers {
+			if existing.equal(t) {
+				return
+			}
+		}
+	case AddIfDifferentNeighbor:
+		neighbor := pos - 1
+		if opts.Where == Start || opts.Where == Before {
+			neighbor = pos
+		}
+		if neighbor >= 0 && neighbor < len(m.Trailers) && m.Trailers[neighbor].equal(t) {
+			return
+		}
+	case Replace:
+		m.remove(same)
+		pos = m.position(t, opts.Where)
+	}
+	m.insert(pos, t)
+}
+
+// position returns the index at which t would be inserted.
+func (m *Message) position(t Trailer, where Where) int {
+	switch where {
+	case Start:
+		return 0
+	case After:
+		for i := len(m.Trailers) - 1; i >= 0; i-- {
+			if strings.EqualFold(m.Trailers[i].Key, t.Key) {
+				return i + 1
+			}
+		}
+	case Before:
+		for i, existing := range m.Trailers {
+			if strings.EqualFold(existing.Key, t.Key) {
+				return i
+			}
+		}
+		return 0
+	}
+	return len(m.Trailers)
+}
+
+func (m *Message) insert(i int, t Trailer) {
+	m.Trailers = slices.Insert(m.Trailers, i, t)
+	m.raw = slices.Insert(m.raw, i, t.String())
+}
+
+func (m *Message) remove(i int) {
+	m.Trailers = slices.Delete(m.Trailers, i, i+1)
+	m.raw = slices.Delete(m.raw, i, i+1)
+}
+
+// Apply adds each trailer to msg according to opts and returns the
+// resulting message.
+func Apply(msg string, trailers []Trailer, opts Options) string {
+	m := Split(msg)
+	for _, t := range trailers {
+		m.Add(t, opts)
+	}
+	return m.String()
+}
+
+// Insert adds t to the end of msg's trailer block with git's default
+// policy, starting a new block (separated by a blank line) if the message
+// doesn't end in one. Like `git commit --signoff`, nothing is added when
+// the last trailer is already identical. The result always ends in a
+// newline.
+func Insert(msg string, t Trailer) string {
+	return Apply(msg, []Trailer{t}, Options{})
+}
+
+// parseLine parses a trailer line. Keys are alphanumerics and dashes, so
+// ordinary prose containing a colon ("Note that: ...") is only treated as
+// a trailer when the text before the colon is a single token.
+func parseLine(line string) (Trailer, bool) {
+	key, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return Trailer{}, false
+	}
+	key = strings.TrimRight(key, " \t")
+	if key == "" {
+		return Trailer{}, false
+	}
+	for _, r := range key {
+		if !(r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return Trailer{}, false
+		}
+	}
+	return Trailer{Key: key, Value: strings.TrimSpace(value)}, true
+}
+
+// isContinuation reports whether line continues the previous trailer's
+// value, which git marks with leading whitespace.
+func isContinuation(line string) bool {
+	return line != "" && (line[0] == ' ' || line[0] == '\t')
+}
+
+// equal compares trailers the way git does: keys case-insensitively,
+// values exactly.
+func (t Trailer) equal(other Trailer) bool {
+	return strings.EqualFold(t.Key, other.Key) && t.Value == other.Value
+}
@@ -0,0 +1,145 @@
+package trailer
+
+import "testing"
+
+func TestInsert(t *testing.T) {
+	signoff := Signoff("A U Thor", "author@example.com")
+
+	tests := []struct {
+		name, msg, want string
+	}{
+		{
+			"subject only starts a new block",
+			"Fix bug\n",
+			"Fix bug\n\nSigned-off-by: A U Thor <author@example.com>\n",
+		},
+		{
+			"empty message",
+			"",
+			"Signed-off-by: A U Thor <author@example.com>\n",
+		},
+		{
+			"subject that looks like a trailer is not a block",
+			"Docs: fix typo\n",
+			"Docs: fix typo\n\nSigned-off-by: A U Thor <author@example.com>\n",
+		},
+		{
+			"body prose is not a block",
+			"Fix bug\n\nThis explains the fix.\n",
+			"Fix bug\n\nThis explains the fix.\n\nSigned-off-by: A U Thor <author@example.com>\n",
+		},
+		{
+			"appends to existing block",
+			"Fix bug\n\nBody.\n\nReviewed-by: R <r@example.com>\n",
+			"Fix bug\n\nBody.\n\nReviewed-by: R <r@example.com>\nSigned-off-by: A U Thor <author@example.com>\n",
+		},
+		{
+			"identical last trailer is not duplicated",
+			"Fix bug\n\nSigned-off-by: A U Thor <author@example.com>\n",
+			"Fix bug\n\nSigned-off-by: A U Thor <author@example.com>\n",
+		},
+		{
+			"identical but not last is added again",
+			"Fix bug\n\nSigned-off-by: A U Thor <author@example.com>\nAcked-by: B <b@example.com>\n",
+			"Fix bug\n\nSigned-off-by: A U Thor <author@example.com>\nAcked-by: B <b@example.com>\nSigned-off-by: A U Thor <author@example.com>\n",
+		},
+		{
+			"continuation lines stay in the block",
+			"Fix bug\n\nNote-by: someone with a\n  long value\n",
+			"Fix bug\n\nNote-by: someone with a\n  long value\nSigned-off-by: A U Thor <author@example.com>\n",
+		},
+		{
+			"trailing blank lines are trimmed",
+			"Fix bug\n\nAcked-by: B <b@example.com>\n\n\n",
+			"Fix bug\n\nAcked-by: B <b@example.com>\nSigned-off-by: A U Thor <author@example.com>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Insert(tt.msg, signoff); got != tt.want {
+				t.Errorf("Insert:\ngot  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tr, err := Parse("Reviewed-by:   R <r@example.com>  ")
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if tr.Key != "Reviewed-by" || tr.Value != "R <r@example.com>" {
+		t.Errorf("Parse: got %+v", tr)
+	}
+
+	for _, bad := range []string{"no colon", ": empty key", "two words: value"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) should fail", bad)
+		}
+	}
+}
+
+func TestApply_Policies(t *testing.T) {
+	const msg = "Subject\n\nAcked-by: A\nCc: X\nAcked-by: B\n"
+	acked := func(v string) Trailer { return Trailer{Key: "Acked-by", Value: v} }
+
+	tests := []struct {
+		name    string
+		trailer Trailer
+		opts    Options
+		want    string
+	}{
+		{"end", acked("C"), Options{}, "Subject\n\nAcked-by: A\nCc: X\nAcked-by: B\nAcked-by: C\n"},
+		{"start", acked("C"), Options{Where: Start}, "Subject\n\nAcked-by: C\nAcked-by: A\nCc: X\nAcked-by: B\n"},
+		{"after same key", Trailer{"Cc", "Y"}, Options{Where: After}, "Subject\n\nAcked-by: A\nCc: X\nCc: Y\nAcked-by: B\n"},
+		{"before same key", Trailer{"Cc", "Y"}, Options{Where: Before}, "Subject\n\nAcked-by: A\nCc: Y\nCc: X\nAcked-by: B\n"},
+		{"after missing key goes to end", Trailer{"Fixes", "1"}, Options{Where: After}, "Subject\n\nAcked-by: A\nCc: X\nAcked-by: B\nFixes: 1\n"},
+		{"before missing key goes to start", Trailer{"Fixes", "1"}, Options{Where: Before}, "Subject\n\nFixes: 1\nAcked-by: A\nCc: X\nAcked-by: B\n"},
+		{"neighbor identical", acked("B"), Options{}, msg},
+		{"neighbor different", acked("A"), Options{}, "Subject\n\nAcked-by: A\nCc: X\nAcked-by: B\nAcked-by: A\n"},
+		{"addIfDifferent skips anywhere", acked("A"), Options{IfExists: AddIfDifferent}, msg},
+		{"add always", acked("B"), Options{IfExists: Add}, "Subject\n\nAcked-by: A\nCc: X\nAcked-by: B\nAcked-by: B\n"},
+		{"replace nearest", acked("C"), Options{IfExists: Replace}, "Subject\n\nAcked-by: A\nCc: X\nAcked-by: C\n"},
+		{"replace nearest at start", acked("C"), Options{Where: Start, IfExists: Replace}, "Subject\n\nAcked-by: C\nCc: X\nAcked-by: B\n"},
+		{"doNothing", acked("C"), Options{IfExists: DoNothing}, msg},
+		{"missing doNothing", Trailer{"Fixes", "1"}, Options{IfMissing: SkipIfMissing}, msg},
+		{"case-insensitive keys", Trailer{"acked-BY", "B"}, Options{}, msg},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Apply(msg, []Trailer{tt.trailer}, tt.opts); got != tt.want {
+				t.Errorf("Apply:\ngot  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	m := Split("Subject\n\nBody text.\n\nFixes: 12\nNote-by: long\n  continued\n")
+	if m.Body != "Subject\n\nBody text." {
+		t.Errorf("Body: got %q", m.Body)
+	}
+	want := []Trailer{{"Fixes", "12"}, {"Note-by", "long continued"}}
+	if len(m.Trailers) != len(want) {
+		t.Fatalf("Trailers: got %v, want %v", m.Trailers, want)
+	}
+	for i := range want {
+		if m.Trailers[i] != want[i] {
+			t.Errorf("trailer %d: got %v, want %v", i, m.Trailers[i], want[i])
+		}
+	}
+
+	// Untouched trailers are written back exactly, continuation included.
+	if got, want := m.String(), "Subject\n\nBody text.\n\nFixes: 12\nNote-by: long\n  continued\n"; got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+
+	if m := Split("Subject\n\nnot: a trailer block\nplain line\n"); len(m.Trailers) != 0 {
+		t.Errorf("mixed paragraph should not be a trailer block, got %v", m.Trailers)
+	}
+	if m := Split("Subject\n\n  leading continuation\n"); len(m.Trailers) != 0 {
+		t.Errorf("continuation without a trailer should not be a block, got %v", m.Trailers)
+	}
+}
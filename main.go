@@ -1,14 +1,33 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/elliota43/rev/internal/color"
+	"github.com/elliota43/rev/internal/diff"
+	"github.com/elliota43/rev/internal/fastimport"
+	"github.com/elliota43/rev/internal/index"
 	"github.com/elliota43/rev/internal/object"
 	"github.com/elliota43/rev/internal/repository"
+	"github.com/elliota43/rev/internal/server"
+	"github.com/elliota43/rev/internal/trailer"
 )
 
 func main() {
@@ -25,20 +44,99 @@ func main() {
 		err = runHashObject(os.Args[2:])
 	case "cat-file":
 		err = runCatFile(os.Args[2:])
+	case "show-ref":
+		err = runShowRef(os.Args[2:])
+	case "serve":
+		err = runServe(os.Args[2:])
+	case "interpret-trailers":
+		err = runInterpretTrailers(os.Args[2:])
+	case "prune-packed":
+		err = runPrunePacked(os.Args[2:])
+	case "repack":
+		err = runRepack(os.Args[2:])
+	case "gc":
+		err = runGc(os.Args[2:])
+	case "ls-tree":
+		err = runLsTree(os.Args[2:])
+	case "commit-tree":
+		err = runCommitTree(os.Args[2:])
+	case "write-tree":
+		err = runWriteTree(os.Args[2:])
+	case "config":
+		err = runConfig(os.Args[2:])
+	case "rev-parse":
+		err = runRevParse(os.Args[2:])
+	case "name-rev":
+		err = runNameRev(os.Args[2:])
+	case "branch":
+		err = runBranch(os.Args[2:])
+	case "symbolic-ref":
+		err = runSymbolicRef(os.Args[2:])
+	case "log":
+		err = runLog(os.Args[2:])
+	case "fsck":
+		err = runFsck(os.Args[2:])
+	case "verify-tag":
+		err = runVerifyTag(os.Args[2:])
+	case "count-objects":
+		err = runCountObjects(os.Args[2:])
+	case "pack-objects":
+		err = runPackObjects(os.Args[2:])
+	case "unpack-objects":
+		err = runUnpackObjects(os.Args[2:])
+	case "fast-import":
+		err = runFastImport(os.Args[2:])
+	case "fast-export":
+		err = runFastExport(os.Args[2:])
+	case "add":
+		err = runAdd(os.Args[2:])
+	case "rm":
+		err = runRm(os.Args[2:])
+	case "status":
+		err = runStatus(os.Args[2:])
+	case "commit":
+		err = runCommit(os.Args[2:])
+	case "read-tree":
+		err = runReadTree(os.Args[2:])
+	case "checkout":
+		err = runCheckout(os.Args[2:])
+	case "switch":
+		err = runSwitch(os.Args[2:])
+	case "restore":
+		err = runRestore(os.Args[2:])
+	case "diff":
+		err = runDiff(os.Args[2:])
+	case "ls-files":
+		err = runLsFiles(os.Args[2:])
+	case "show":
+		err = runShow(os.Args[2:])
 	default:
 		printUsage()
 		os.Exit(1)
 	}
 
+	var code exitCode
+	if errors.As(err, &code) {
+		os.Exit(int(code))
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-// runInit handles `rev init [path]`.
+// exitCode is returned by commands whose exit status is the whole answer
+// (e.g. "no refs matched"), so main exits with it without printing.
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(c))
+}
+
+// runInit handles `rev init [--bare] [path]`.
 func runInit(args []string) error {
 	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	bare := fs.Bool("bare", false, "Create a bare repository, with no working tree")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -48,7 +146,11 @@ func runInit(args []string) error {
 		dir = "."
 	}
 
-	repo, err := repository.Init(dir)
+	create := repository.Init
+	if *bare {
+		create = repository.InitBare
+	}
+	repo, err := create(dir)
 	if err != nil {
 		return fmt.Errorf("initializing repository: %w", err)
 	}
@@ -57,74 +159,176 @@ func runInit(args []string) error {
 	return nil
 }
 
-// runHashObject handles `rev hash-object [-w] [--stdin] <file>`.
+// runHashObject handles `rev hash-object [-t <type>] [-w] [--stdin]
+// [--literally] <file>...`. It prints one hash per input, stdin first and
+// then files in argument order. A file that can't be hashed is reported
+// and skipped, and the command exits nonzero once the rest are done.
+// Trees, commits, and tags must be well-formed unless --literally is
+// given, which also accepts any type name, for building broken objects
+// on purpose.
 func runHashObject(args []string) error {
 	fs := flag.NewFlagSet("hash-object", flag.ContinueOnError)
+	typeName := fs.String("t", string(object.TypeBlob), "Object type: blob, tree, commit, or tag")
 	write := fs.Bool("w", false, "Write the object into the object database")
 	stdin := fs.Bool("stdin", false, "Read the object from standard input")
+	literally := fs.Bool("literally", false, "Skip type and structure checks")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	objType, err := object.ParseType(*typeName)
+	if *literally {
+		// Any name goes, as long as it still makes a parseable header.
+		if *typeName == "" || strings.ContainsAny(*typeName, " \x00") {
+			return fmt.Errorf("invalid object type %q", *typeName)
+		}
+		objType, err = object.Type(*typeName), nil
+	}
+	if err != nil {
+		return err
+	}
+	if !*stdin && fs.NArg() == 0 {
+		return fmt.Errorf("hash-object requires a file path or --stdin")
+	}
 
-	var reader io.Reader
-	var size int64
+	// Inside a repository, hash with its object format; outside one,
+	// hash-object still works (without -w) and uses SHA-1.
+	algo := object.SHA1
+	repo, repoErr := repository.Open("")
+	if repoErr == nil {
+		if algo, err = repository.ObjectFormat(repo.GitDir); err != nil {
+			return err
+		}
+	} else if *write {
+		return repoErr
+	}
 
-	if *stdin {
-		data, err := io.ReadAll(os.Stdin)
+	hash := func(r io.Reader, size int64) error {
+		sha, fullObject, err := object.HashWith(algo, objType, r, size)
 		if err != nil {
-			return fmt.Errorf("reading stdin: %w", err)
+			return fmt.Errorf("hashing object: %w", err)
 		}
-		size = int64(len(data))
-		reader = bytes.NewReader(data)
-	} else {
-		filePath := fs.Arg(0)
-		if filePath == "" {
-			return fmt.Errorf("hash-object requires a file path or --stdin")
+		if *literally {
+			if *write {
+				if err := object.Write(repo.GitDir, sha, fullObject); err != nil {
+					return fmt.Errorf("writing object: %w", err)
+				}
+			}
+			fmt.Println(sha)
+			return nil
 		}
 
-		info, err := os.Stat(filePath)
-		if err != nil {
-			return fmt.Errorf("stat %s: %w", filePath, err)
+		body := fullObject[len(object.Header(objType, size)):]
+		obj := &object.Object{Type: objType, Size: size, Hash: sha, Body: body}
+		if err := obj.CheckStructure(); err != nil {
+			return err
 		}
-		size = info.Size()
-
-		f, err := os.Open(filePath)
-		if err != nil {
-			return fmt.Errorf("opening %s: %w", filePath, err)
+		if *write {
+			if sha, err = object.WriteVerifiedWith(algo, repo.GitDir, fullObject); err != nil {
+				return fmt.Errorf("writing object: %w", err)
+			}
 		}
-		defer f.Close()
-		reader = f
-	}
-
-	sha, fullObject, err := object.Hash(object.TypeBlob, reader, size)
-	if err != nil {
-		return fmt.Errorf("hashing object: %w", err)
+		fmt.Println(sha)
+		return nil
 	}
 
-	if *write {
-		repo, err := repository.Open("")
+	if *stdin {
+		data, err := io.ReadAll(os.Stdin)
 		if err != nil {
+			return fmt.Errorf("reading stdin: %w", err)
+		}
+		if err := hash(bytes.NewReader(data), int64(len(data))); err != nil {
 			return err
 		}
-		if err := object.Write(repo.GitDir, sha, fullObject); err != nil {
-			return fmt.Errorf("writing object: %w", err)
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		if err := hashFile(path, hash); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			failed = true
 		}
 	}
+	if failed {
+		return exitCode(1)
+	}
+	return nil
+}
 
-	fmt.Println(sha)
+// hashFile opens path and passes its contents and size to hash. Errors
+// name the path.
+func hashFile(path string, hash func(io.Reader, int64) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s: is a directory", path)
+	}
+	if err := hash(f, info.Size()); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
 	return nil
 }
 
-// runCatFile handles `rev cat-file (-t | -s | -e | -p) <hash>`.
+// runCatFile handles `rev cat-file (-t | -s | -e | -p [--follow] | --raw)
+// <hash>` and
+// `rev cat-file (--batch | --batch-check | --batch-command)[=<format>]
+// [--buffer]`.
 func runCatFile(args []string) error {
 	fs := flag.NewFlagSet("cat-file", flag.ContinueOnError)
 	showType := fs.Bool("t", false, "Show the object type")
 	showSize := fs.Bool("s", false, "Show the object size")
 	checkExists := fs.Bool("e", false, "Check if object exists (exit silently)")
 	prettyPrint := fs.Bool("p", false, "Pretty-print the object contents")
+	follow := fs.Bool("follow", false, "With -p, also print what an annotated tag points to, through any chain of tags")
+	raw := fs.Bool("raw", false, "Print the raw inflated object, header included")
+	textconv := fs.Bool("textconv", false, "Print the blob named <rev>:<path> as its diff driver's textconv command converts it")
+	allowUnknown := fs.Bool("allow-unknown-type", false, "Allow -t and -s on objects of unknown type")
+	buffer := fs.Bool("buffer", false, "Don't flush batch output after every object")
+	var batch, batchCheck, batchCommand batchFlag
+	fs.Var(&batch, "batch", "Print info and contents of each object named on stdin, as `format` says")
+	fs.Var(&batchCheck, "batch-check", "Print info of each object named on stdin, as `format` says")
+	fs.Var(&batchCommand, "batch-command", "Run contents, info, and flush commands from stdin, printing info as `format` says")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	if *follow && !*prettyPrint {
+		return fmt.Errorf("cat-file --follow only goes with -p")
+	}
+
+	if batch.on || batchCheck.on || batchCommand.on {
+		if fs.NArg() > 0 {
+			return fmt.Errorf("cat-file --batch reads object names from stdin, not arguments")
+		}
+		opts := batchOptions{contents: batch.on, commands: batchCommand.on, buffer: *buffer}
+		var format string
+		switch {
+		case batchCommand.on:
+			format = batchCommand.format
+		case batch.on:
+			format = batch.format
+		default:
+			format = batchCheck.format
+		}
+		if format == "" {
+			format = defaultBatchFormat
+		}
+		var err error
+		if opts.format, err = parseBatchFormat(format); err != nil {
+			return err
+		}
+		repo, err := repository.Open("")
+		if err != nil {
+			return err
+		}
+		return catFileBatch(repo.GitDir, os.Stdin, os.Stdout, opts)
+	}
 
 	hash := fs.Arg(0)
 	if hash == "" {
@@ -136,27 +340,4033 @@ func runCatFile(args []string) error {
 		return err
 	}
 
-	// -e just checks existence, no need to fully parse.
+	if *textconv {
+		return catFileTextconv(os.Stdout, repo, hash)
+	}
+
+	// Any revision names an object, as for rev-parse: HEAD, "@{-1}",
+	// branch names, and abbreviated hashes alike.
+	hash, err = repository.ResolveRef(repo.GitDir, hash)
+	if *checkExists && (errors.Is(err, repository.ErrUnknownRevision) || errors.Is(err, object.ErrNotFound)) {
+		return exitCode(1)
+	}
+	if err != nil {
+		return err
+	}
+
+	// -e exits 1 for a missing object and 2 for one that exists but
+	// can't be read, since the two need very different fixes.
 	if *checkExists {
-		return object.Exists(repo.GitDir, hash)
+		_, err := object.Read(repo.GitDir, hash)
+		switch {
+		case errors.Is(err, object.ErrNotFound):
+			return exitCode(1)
+		case errors.Is(err, object.ErrCorrupt):
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return exitCode(2)
+		}
+		return err
+	}
+
+	// Raw output is streamed so large objects stay out of memory, and
+	// takes the header as written so objects of any type can be dumped.
+	if *raw {
+		_, _, r, err := object.ReadRaw(repo.GitDir, hash)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(os.Stdout, r)
+		return err
+	}
+
+	// -t and -s only need the header, so skip inflating the body.
+	if *showType || *showSize {
+		readHeader := object.ReadHeader
+		if *allowUnknown {
+			readHeader = func(gitDir, hash string) (object.Type, int64, error) {
+				objType, size, r, err := object.ReadRaw(gitDir, hash)
+				if err == nil {
+					r.Close()
+				}
+				return objType, size, err
+			}
+		}
+		objType, size, err := readHeader(repo.GitDir, hash)
+		if err != nil {
+			return err
+		}
+		if *showType {
+			fmt.Println(objType)
+		} else {
+			fmt.Println(size)
+		}
+		return nil
+	}
+
+	if !*prettyPrint {
+		return fmt.Errorf("cat-file requires one of: -t, -s, -e, -p, --raw, --textconv")
+	}
+
+	// --follow prints each tag in a chain and then what the last one
+	// tags, a blank line between each. seen guards against a corrupt
+	// chain that loops.
+	seen := make(map[string]bool)
+	for {
+		next, err := catFilePretty(os.Stdout, repo.GitDir, hash, *follow)
+		if err != nil || next == "" {
+			return err
+		}
+		if seen[next] {
+			return fmt.Errorf("tag %s: chain of tags loops back on itself", next)
+		}
+		seen[next] = true
+		fmt.Println()
+		hash = next
+	}
+}
+
+// catFileTextconv writes the blob name, which must be <rev>:<path>, or
+// :<path> for the index, as its path's textconv command converts it, or
+// as it is if there is none.
+func catFileTextconv(w io.Writer, repo *repository.Repository, name string) error {
+	rev, p, ok := strings.Cut(name, ":")
+	if !ok || p == "" {
+		return fmt.Errorf("cat-file --textconv needs <rev>:<path>, not %q", name)
+	}
+	var hash string
+	if rev == "" {
+		idx, err := index.ReadIndex(repo.GitDir)
+		if err != nil {
+			return err
+		}
+		e := idx.Find(p)
+		if e == nil {
+			return fmt.Errorf("path '%s' is not in the index", p)
+		}
+		hash = e.Hash
+	} else {
+		e, err := repository.LookupPath(repo.GitDir, rev, p)
+		if err != nil {
+			return err
+		}
+		hash = e.Hash
 	}
 
 	obj, err := object.Read(repo.GitDir, hash)
 	if err != nil {
 		return err
 	}
+	if obj.Type != object.TypeBlob {
+		return fmt.Errorf("%s is a %s, not a blob", name, obj.Type)
+	}
+	textconv, err := diff.LoadTextconv(repo.GitDir, repo.Path)
+	if err != nil {
+		return err
+	}
+	data, _, err := textconv.Convert(p, hash, obj.Body)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
 
-	switch {
-	case *showType:
-		fmt.Println(obj.Type)
-	case *showSize:
-		fmt.Println(obj.Size)
-	case *prettyPrint:
-		fmt.Print(obj.PrettyPrint())
-	default:
-		return fmt.Errorf("cat-file requires one of: -t, -s, -e, -p")
+// catFilePretty writes hash's object as cat-file -p does. If follow is
+// set and the object is an annotated tag, it returns the tagged object's
+// hash.
+func catFilePretty(w io.Writer, gitDir, hash string, follow bool) (string, error) {
+	// Blobs are copied straight through so large files stay out of memory.
+	// Other types are buffered from the same stream, so every object is
+	// inflated exactly once.
+	objType, size, r, err := object.ReadStream(gitDir, hash)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	if objType == object.TypeBlob {
+		_, err := io.Copy(w, r)
+		return "", err
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	obj := &object.Object{Type: objType, Size: size, Hash: hash, Body: body}
+	if _, err := io.WriteString(w, obj.PrettyPrint()); err != nil {
+		return "", err
+	}
+	if !follow || objType != object.TypeTag {
+		return "", nil
+	}
+	tag, err := object.ParseTag(obj)
+	if err != nil {
+		return "", err
+	}
+	return tag.Object, nil
+}
+
+// batchFlag is --batch, --batch-check, or --batch-command, each with an
+// optional =<format>. A bare flag leaves format empty, for the default.
+type batchFlag struct {
+	on     bool
+	format string
+}
+
+func (f *batchFlag) String() string { return f.format }
+
+func (f *batchFlag) IsBoolFlag() bool { return true }
+
+func (f *batchFlag) Set(v string) error {
+	f.on = true
+	if v != "true" {
+		f.format = v
+	}
+	return nil
+}
+
+// defaultBatchFormat is the info line cat-file --batch prints when not
+// given a format.
+const defaultBatchFormat = "%(objectname) %(objecttype) %(objectsize)"
+
+// batchOptions configures catFileBatch.
+type batchOptions struct {
+	// contents prints each object's bytes after its info line.
+	contents bool
+	// commands reads "contents <object>", "info <object>", and "flush"
+	// lines instead of bare object names.
+	commands bool
+	format   batchFormat
+	// buffer holds output until the buffer fills or input ends, rather
+	// than flushing after every object.
+	buffer bool
+}
+
+// batchFormat is a parsed --batch format: literal text interleaved with
+// %(atom) placeholders.
+type batchFormat struct {
+	parts []batchPart
+	// usesRest is set when the format has %(rest), which changes how
+	// input lines are read: the object name ends at the first
+	// whitespace, and what follows is echoed by %(rest).
+	usesRest bool
+}
+
+// batchPart is literal text, or the atom named in a placeholder.
+type batchPart struct {
+	literal, atom string
+}
+
+// batchAtoms are the placeholders a batch format may use.
+var batchAtoms = map[string]bool{"objectname": true, "objecttype": true, "objectsize": true, "rest": true}
+
+// parseBatchFormat parses a --batch format. "%%" is a literal percent
+// sign, and a "%" that doesn't start a placeholder is kept as is.
+func parseBatchFormat(s string) (batchFormat, error) {
+	var f batchFormat
+	var lit strings.Builder
+	for s != "" {
+		switch {
+		case strings.HasPrefix(s, "%%"):
+			lit.WriteByte('%')
+			s = s[2:]
+			continue
+		case strings.HasPrefix(s, "%("):
+			end := strings.IndexByte(s, ')')
+			if end < 0 {
+				break
+			}
+			atom := s[2:end]
+			if !batchAtoms[atom] {
+				return batchFormat{}, fmt.Errorf("unknown format element: %s", atom)
+			}
+			if lit.Len() > 0 {
+				f.parts = append(f.parts, batchPart{literal: lit.String()})
+				lit.Reset()
+			}
+			f.parts = append(f.parts, batchPart{atom: atom})
+			f.usesRest = f.usesRest || atom == "rest"
+			s = s[end+1:]
+			continue
+		}
+		lit.WriteByte(s[0])
+		s = s[1:]
+	}
+	if lit.Len() > 0 {
+		f.parts = append(f.parts, batchPart{literal: lit.String()})
+	}
+	return f, nil
+}
+
+// split separates an input line into the object name and the text
+// %(rest) echoes. Without %(rest) in the format, the whole line is the
+// name, spaces and all.
+func (f batchFormat) split(line string) (name, rest string) {
+	if !f.usesRest {
+		return line, ""
+	}
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimLeft(line[i:], " \t")
+}
+
+// expand fills in the format for one object.
+func (f batchFormat) expand(hash string, objType object.Type, size int64, rest string) string {
+	var b strings.Builder
+	for _, p := range f.parts {
+		switch p.atom {
+		case "":
+			b.WriteString(p.literal)
+		case "objectname":
+			b.WriteString(hash)
+		case "objecttype":
+			b.WriteString(string(objType))
+		case "objectsize":
+			b.WriteString(strconv.FormatInt(size, 10))
+		case "rest":
+			b.WriteString(rest)
+		}
+	}
+	return b.String()
+}
+
+// catFileBatch answers cat-file --batch and --batch-check. For each
+// object name read from in, one per line, it writes the info line
+// opts.format describes and, with contents, the object's bytes and a
+// newline. Names that don't resolve print "<name> missing" (or
+// "ambiguous") and the batch goes on. Output is flushed after every
+// object so callers can drive it interactively, unless opts.buffer trades
+// that for fewer writes.
+//
+// With opts.commands (--batch-command), each line is instead a command:
+// "info <object>" and "contents <object>" print as --batch-check and
+// --batch would, and "flush" writes out buffered output.
+func catFileBatch(gitDir string, in io.Reader, out io.Writer, opts batchOptions) error {
+	bw := bufio.NewWriter(out)
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		line := sc.Text()
+		name, rest := opts.format.split(line)
+		contents := opts.contents
+		if opts.commands {
+			cmd, arg, _ := strings.Cut(line, " ")
+			switch cmd {
+			case "info", "contents":
+				if arg == "" {
+					return fmt.Errorf("%s requires arguments", cmd)
+				}
+				name, rest, contents = arg, "", cmd == "contents"
+			case "flush":
+				if !opts.buffer {
+					return fmt.Errorf("flush is only for --buffer mode")
+				}
+				if err := bw.Flush(); err != nil {
+					return err
+				}
+				continue
+			case "":
+				return fmt.Errorf("empty command in input")
+			default:
+				return fmt.Errorf("unknown command: '%s'", line)
+			}
+		}
+
+		hash, err := repository.ResolveRef(gitDir, name)
+		switch {
+		case err == nil:
+		case name == "", errors.Is(err, repository.ErrUnknownRevision), errors.Is(err, object.ErrNotFound):
+			fmt.Fprintf(bw, "%s missing\n", name)
+		case errors.Is(err, object.ErrAmbiguous):
+			fmt.Fprintf(bw, "%s ambiguous\n", name)
+		default:
+			return err
+		}
+
+		if err == nil {
+			if err := catFileBatchObject(bw, gitDir, hash, rest, opts.format, contents); err != nil {
+				return err
+			}
+		}
+		if !opts.buffer {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// catFileBatchObject writes one --batch or --batch-check record for hash:
+// its info line, then with contents its bytes.
+func catFileBatchObject(w io.Writer, gitDir, hash, rest string, format batchFormat, contents bool) error {
+	if !contents {
+		objType, size, err := object.ReadHeader(gitDir, hash)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, format.expand(hash, objType, size, rest))
+		return err
+	}
+
+	objType, size, r, err := object.ReadStream(gitDir, hash)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	fmt.Fprintln(w, format.expand(hash, objType, size, rest))
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// runShowRef handles `rev show-ref [--heads] [--tags] [--verify] [<pattern>...]`.
+func runShowRef(args []string) error {
+	fs := flag.NewFlagSet("show-ref", flag.ContinueOnError)
+	heads := fs.Bool("heads", false, "Only show refs under refs/heads")
+	tags := fs.Bool("tags", false, "Only show refs under refs/tags")
+	verify := fs.Bool("verify", false, "Require exact full ref names")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+
+	if *verify {
+		if fs.NArg() == 0 {
+			return fmt.Errorf("--verify requires a reference")
+		}
+		for _, name := range fs.Args() {
+			hash, ok, err := verifyRef(repo.Refs(), name)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("'%s' - not a valid ref", name)
+			}
+			fmt.Printf("%s %s\n", hash, name)
+		}
+		return nil
+	}
+
+	refs, err := repo.Refs().List()
+	if err != nil {
+		return err
+	}
+
+	matched := 0
+	for _, r := range refs {
+		if (*heads || *tags) &&
+			!(*heads && strings.HasPrefix(r.Name, "refs/heads/")) &&
+			!(*tags && strings.HasPrefix(r.Name, "refs/tags/")) {
+			continue
+		}
+		if fs.NArg() > 0 && !refMatchesAny(r.Name, fs.Args()) {
+			continue
+		}
+		fmt.Printf("%s %s\n", r.Hash, r.Name)
+		matched++
+	}
+
+	if matched == 0 {
+		return exitCode(1)
+	}
+	return nil
+}
+
+// verifyRef looks up name for show-ref --verify. HEAD isn't under refs/,
+// where the store looks, so it is resolved on its own; an unborn HEAD is
+// not a valid ref.
+func verifyRef(store *repository.RefStore, name string) (string, bool, error) {
+	if name != "HEAD" {
+		return store.Lookup(name)
+	}
+	hash, err := store.ResolveHead()
+	if errors.Is(err, repository.ErrUnbornBranch) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return hash, true, nil
+}
+
+// refMatchesAny reports whether name matches one of the show-ref patterns.
+// Like git, a pattern matches whole trailing path components, so "main"
+// matches refs/heads/main but not refs/heads/domain.
+func refMatchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if name == p || strings.HasSuffix(name, "/"+p) {
+			return true
+		}
+	}
+	return false
+}
+
+// runServe handles `rev serve [--dumb] [--enable-receive-pack] <addr>`.
+// By default the repository is served over smart HTTP, which git can
+// clone and fetch over, and push over too with --enable-receive-pack or
+// http.receivepack set; --dumb serves only the dumb protocol's files,
+// read-only.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	dumb := fs.Bool("dumb", false, "Serve the repository over the dumb HTTP protocol only")
+	receivePack := fs.Bool("enable-receive-pack", false, "Accept pushes, whatever http.receivepack says")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	addr := fs.Arg(0)
+	if addr == "" {
+		return fmt.Errorf("serve requires an address, e.g. :8080")
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+
+	if *dumb {
+		fmt.Printf("Serving %s over dumb HTTP on %s\n", repo.GitDir, addr)
+		return http.ListenAndServe(addr, server.NewDumbHandler(repo.GitDir))
+	}
+	if err := requireSHA1(repo.GitDir, "serve"); err != nil {
+		return err
+	}
+	fmt.Printf("Serving %s over smart HTTP on %s\n", repo.GitDir, addr)
+	h := server.NewSmartHandler(repo.GitDir)
+	h.ReceivePack = *receivePack
+	return http.ListenAndServe(addr, h)
+}
+
+// runInterpretTrailers handles `rev interpret-trailers [--where <w>]
+// [--if-exists <action>] [--if-missing <action>] [--only-trailers]
+// [--trailer <key: value>]... [<file>]`.
+func runInterpretTrailers(args []string) error {
+	fs := flag.NewFlagSet("interpret-trailers", flag.ContinueOnError)
+	var trailerArgs stringList
+	fs.Var(&trailerArgs, "trailer", "Trailer to add, as \"Key: value\" (repeatable)")
+	where := fs.String("where", "end", "Placement: end, start, after, or before")
+	ifExists := fs.String("if-exists", "addIfDifferentNeighbor", "Action when the key exists: addIfDifferentNeighbor, addIfDifferent, add, replace, doNothing")
+	ifMissing := fs.String("if-missing", "add", "Action when the key is missing: add or doNothing")
+	onlyTrailers := fs.Bool("only-trailers", false, "Output only the trailers")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var opts trailer.Options
+	var err error
+	if opts.Where, err = trailer.ParseWhere(*where); err != nil {
+		return err
+	}
+	if opts.IfExists, err = trailer.ParseIfExists(*ifExists); err != nil {
+		return err
+	}
+	if opts.IfMissing, err = trailer.ParseIfMissing(*ifMissing); err != nil {
+		return err
+	}
+
+	var input []byte
+	if path := fs.Arg(0); path != "" {
+		input, err = os.ReadFile(path)
+	} else {
+		input, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return fmt.Errorf("reading message: %w", err)
 	}
 
+	msg := trailer.Split(string(input))
+	for _, arg := range trailerArgs {
+		t, err := trailer.Parse(arg)
+		if err != nil {
+			return err
+		}
+		msg.Add(t, opts)
+	}
+
+	if *onlyTrailers {
+		for _, t := range msg.Trailers {
+			fmt.Println(t)
+		}
+		return nil
+	}
+	fmt.Print(msg)
+	return nil
+}
+
+// runPrunePacked handles `rev prune-packed [-n | --dry-run]`.
+func runPrunePacked(args []string) error {
+	fs := flag.NewFlagSet("prune-packed", flag.ContinueOnError)
+	var dryRun bool
+	fs.BoolVar(&dryRun, "n", false, "Only list the objects that would be removed")
+	fs.BoolVar(&dryRun, "dry-run", false, "Only list the objects that would be removed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+
+	pruned, err := object.PrunePacked(repo.GitDir, dryRun)
+	if dryRun {
+		for _, p := range pruned {
+			fmt.Printf("rm -f %s\n", p)
+		}
+	}
+	return err
+}
+
+// runRepack handles `rev repack [-a] [-d] [--window=<n>] [--depth=<n>]`.
+// Loose objects not yet in a pack go into a new one; with -a, so do the
+// objects of every existing pack, consolidating them. With -d, the packs
+// and loose objects the new pack supersedes are removed. Objects are
+// stored whole unless --window asks for deltas.
+func runRepack(args []string) error {
+	fs := flag.NewFlagSet("repack", flag.ContinueOnError)
+	var opts object.RepackOptions
+	fs.BoolVar(&opts.All, "a", false, "Pack everything, existing packs included, into a single pack")
+	fs.BoolVar(&opts.Delete, "d", false, "Remove packs and loose objects made redundant by the new pack")
+	fs.IntVar(&opts.Window, "window", 0, "Try each object as a delta against this many others")
+	fs.IntVar(&opts.Depth, "depth", object.DefaultPackDepth, "Longest delta chain to write")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+	if err := requireSHA1(repo.GitDir, "repack"); err != nil {
+		return err
+	}
+
+	res, err := object.Repack(repo.GitDir, opts)
+	if err != nil {
+		return err
+	}
+	if res.Checksum == "" {
+		fmt.Println("Nothing new to pack.")
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Wrote pack-%s with %d objects", res.Checksum, res.Objects)
+	if opts.Delete {
+		fmt.Fprintf(os.Stderr, ", removed %d packs and %d loose objects", len(res.Removed), len(res.Pruned))
+	}
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+// runGc handles `rev gc [--aggressive] [--auto]`: everything, packs and
+// loose objects alike, is repacked into one pack with deltas and what it
+// supersedes is removed, as repack -a -d --window does. Plain gc uses
+// pack.window and pack.depth (10 and 50 by default); --aggressive
+// searches far wider, with gc.aggressiveWindow and gc.aggressiveDepth
+// (250 and 50). Either way deltas are recomputed rather than reused, so
+// the difference is only how hard the search tries. Pack sizes before
+// and after are reported. With --auto, nothing happens unless
+// autoGc finds the repository needs it.
+func runGc(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	aggressive := fs.Bool("aggressive", false, "Search a much wider window for deltas")
+	auto := fs.Bool("auto", false, "Only repack if gc.auto or gc.autoPackLimit is exceeded")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+	if err := requireSHA1(repo.GitDir, "gc"); err != nil {
+		return err
+	}
+	if *auto {
+		return autoGc(repo.GitDir)
+	}
+	opts, err := gcOptions(repo.GitDir, *aggressive)
+	if err != nil {
+		return err
+	}
+
+	loose, err := object.CountLoose(repo.GitDir, false)
+	if err != nil {
+		return err
+	}
+	before, err := object.CountPacks(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	res, err := object.Repack(repo.GitDir, opts)
+	if err != nil {
+		return err
+	}
+	after, err := object.CountPacks(repo.GitDir)
+	if err != nil {
+		return err
+	}
+
+	if res.Checksum == "" {
+		fmt.Println("Nothing to pack.")
+		return nil
+	}
+	fmt.Printf("Packed %d objects into pack-%s\n", res.Objects, res.Checksum)
+	fmt.Printf("before: packs %d bytes, loose objects %d bytes\n", before.Size, loose.Size)
+	fmt.Printf("after:  packs %d bytes\n", after.Size)
+	return nil
+}
+
+// gcOptions returns the repack gc does, with the delta window and depth
+// from config.
+func gcOptions(gitDir string, aggressive bool) (object.RepackOptions, error) {
+	cfg, err := repository.ParseConfig(gitDir)
+	if err != nil {
+		return object.RepackOptions{}, err
+	}
+	section, window, depth := "pack", "window", "depth"
+	opts := object.RepackOptions{All: true, Delete: true, Window: object.DefaultPackWindow, Depth: object.DefaultPackDepth}
+	if aggressive {
+		section, window, depth = "gc", "aggressivewindow", "aggressivedepth"
+		opts.Window = 250
+	}
+	if n, found, err := cfg.GetInt(section, window); err != nil {
+		return object.RepackOptions{}, err
+	} else if found {
+		opts.Window = int(n)
+	}
+	if n, found, err := cfg.GetInt(section, depth); err != nil {
+		return object.RepackOptions{}, err
+	} else if found {
+		opts.Depth = int(n)
+	}
+	return opts, nil
+}
+
+// autoGc is gc --auto, which commit runs once it has written its
+// objects. If repository.CheckAutoGc finds too many packs, everything is
+// repacked as by gc; if only too many loose objects, just those not yet
+// packed go into a new pack, and their loose copies are removed.
+// SHA-256 repositories, which can't be packed, are left alone.
+func autoGc(gitDir string) error {
+	if format, err := repository.ObjectFormat(gitDir); err != nil || format != object.SHA1 {
+		return err
+	}
+	need, err := repository.CheckAutoGc(gitDir)
+	if err != nil || !need.Needed() {
+		return err
+	}
+	opts, err := gcOptions(gitDir, false)
+	if err != nil {
+		return err
+	}
+	opts.All = need.TooManyPacks
+	fmt.Fprintln(os.Stderr, "Auto packing the repository for optimum performance.")
+	_, err = object.Repack(gitDir, opts)
+	return err
+}
+
+// runFsck handles `rev fsck`. It prints one line per loose object that
+// fails to inflate or doesn't hash to its name, and exits 1 if any did.
+func runFsck(args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+
+	problems, err := object.VerifyLoose(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "error: %v\n", p)
+	}
+	if len(problems) > 0 {
+		return exitCode(1)
+	}
+	return nil
+}
+
+// runVerifyTag handles `rev verify-tag [-v] <tag>...`. Each tag must be
+// an annotated tag with an OpenPGP signature, which gpg (or gpg.program)
+// must accept; its report goes to stderr. With -v, the signed part of
+// the tag is printed too.
+func runVerifyTag(args []string) error {
+	fs := flag.NewFlagSet("verify-tag", flag.ContinueOnError)
+	verbose := fs.Bool("v", false, "Print the contents of the tag")
+	fs.BoolVar(verbose, "verbose", false, "Print the contents of the tag")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: rev verify-tag [-v] <tag>...")
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+	cfg, err := repository.ParseConfig(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	failed := false
+	for _, name := range fs.Args() {
+		if err := verifyTag(cfg, repo.GitDir, name, *verbose); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		return exitCode(1)
+	}
+	return nil
+}
+
+// verifyTag checks the signature of the tag name for rev verify-tag.
+func verifyTag(cfg *repository.Config, gitDir, name string, verbose bool) error {
+	hash, err := repository.ResolveRef(gitDir, name)
+	if err != nil {
+		return err
+	}
+	obj, err := object.Read(gitDir, hash)
+	if err != nil {
+		return err
+	}
+	if obj.Type != object.TypeTag {
+		return fmt.Errorf("%s: cannot verify a non-tag object of type %s", name, obj.Type)
+	}
+	payload, sig := object.SplitSignature(obj.Body)
+	if verbose {
+		os.Stdout.Write(payload)
+	}
+	if len(sig) == 0 {
+		return fmt.Errorf("%s: no signature found", name)
+	}
+	return verifySignature(cfg, payload, sig)
+}
+
+// verifySignature runs gpg to check that sig, an armored OpenPGP
+// signature, signs payload, the way git does: the signature goes in a
+// temporary file, the payload on stdin, and gpg must report a good
+// signature on its status output.
+func verifySignature(cfg *repository.Config, payload, sig []byte) error {
+	if !bytes.HasPrefix(sig, []byte("-----BEGIN PGP SIGNATURE-----")) {
+		return fmt.Errorf("only OpenPGP signatures can be verified")
+	}
+	program, ok := cfg.Get("gpg", "program")
+	if !ok {
+		program = "gpg"
+	}
+
+	f, err := os.CreateTemp("", ".rev_vtag_tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(sig)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(program, "--status-fd=1", "--verify", f.Name(), "-")
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stderr = os.Stderr
+	status, err := cmd.Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) {
+			return fmt.Errorf("running %s: %w", program, err)
+		}
+	}
+	if err != nil || !bytes.Contains(append([]byte("\n"), status...), []byte("\n[GNUPG:] GOODSIG ")) {
+		return fmt.Errorf("bad signature")
+	}
+	return nil
+}
+
+// runCountObjects handles `rev count-objects [-v]`. Sizes are in KiB of
+// compressed object data; git counts allocated disk blocks instead, so
+// its figures run higher for repositories of small objects.
+func runCountObjects(args []string) error {
+	fs := flag.NewFlagSet("count-objects", flag.ContinueOnError)
+	verbose := fs.Bool("v", false, "Also break the count down by object type")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+
+	stats, err := object.CountLoose(repo.GitDir, *verbose)
+	if err != nil {
+		return err
+	}
+	kib := stats.Size / 1024
+	if !*verbose {
+		fmt.Printf("%d objects, %d kilobytes\n", stats.Count, kib)
+		return nil
+	}
+	fmt.Printf("count: %d\n", stats.Count)
+	fmt.Printf("size: %d\n", kib)
+	for _, t := range []object.Type{object.TypeBlob, object.TypeTree, object.TypeCommit, object.TypeTag} {
+		fmt.Printf("%s: %d\n", t, stats.ByType[t])
+	}
+	return nil
+}
+
+// runPackObjects handles `rev pack-objects [--stdout] [--window=<n>]
+// [--depth=<n>]`. It reads object names from stdin, one per line;
+// anything after the name (such as the path rev-list --objects prints)
+// is ignored. The pack and its index go into objects/pack and the pack's
+// checksum is printed, or with --stdout the pack alone is written to
+// stdout. Objects are stored whole unless --window asks for deltas.
+func runPackObjects(args []string) error {
+	fs := flag.NewFlagSet("pack-objects", flag.ContinueOnError)
+	toStdout := fs.Bool("stdout", false, "Write the pack to stdout instead of objects/pack")
+	var opts object.PackOptions
+	fs.IntVar(&opts.Window, "window", 0, "Try each object as a delta against this many others")
+	fs.IntVar(&opts.Depth, "depth", object.DefaultPackDepth, "Longest delta chain to write")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+	if err := requireSHA1(repo.GitDir, "pack-objects"); err != nil {
+		return err
+	}
+
+	var hashes []string
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			hashes = append(hashes, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading stdin: %w", err)
+	}
+
+	if *toStdout {
+		out := bufio.NewWriter(os.Stdout)
+		if _, err := object.WriteDeltaPack(out, repo.GitDir, hashes, opts); err != nil {
+			return err
+		}
+		return out.Flush()
+	}
+
+	checksum, err := object.SaveDeltaPack(repo.GitDir, hashes, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println(checksum)
+	return nil
+}
+
+// runUnpackObjects handles `rev unpack-objects < <pack>`.
+func runUnpackObjects(args []string) error {
+	fs := flag.NewFlagSet("unpack-objects", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+	if err := requireSHA1(repo.GitDir, "unpack-objects"); err != nil {
+		return err
+	}
+
+	// Most of what a pack brings is new, so a filter over the objects
+	// already here answers almost every existence check from memory.
+	known, err := object.NewExistenceFilter(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	n, err := object.UnpackObjects(repo.GitDir, os.Stdin, known)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Unpacked %d objects\n", n)
+	return nil
+}
+
+// runFastImport handles `rev fast-import [--force] [--quiet]
+// [--import-marks=<file>] [--export-marks=<file>] < <stream>`.
+func runFastImport(args []string) error {
+	fs := flag.NewFlagSet("fast-import", flag.ContinueOnError)
+	force := fs.Bool("force", false, "Update branches even if the new tip doesn't contain the old one")
+	quiet := fs.Bool("quiet", false, "Don't print statistics")
+	importMarks := fs.String("import-marks", "", "Load marks from this file before importing")
+	exportMarks := fs.String("export-marks", "", "Write the marks to this file when done")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+
+	im := fastimport.NewImporter(repo)
+	im.Force = *force
+	im.Progress = os.Stdout
+	if *importMarks != "" {
+		f, err := os.Open(*importMarks)
+		if err != nil {
+			return err
+		}
+		err = fastimport.ReadMarks(f, im.Marks)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	importErr := im.Import(os.Stdin)
+	// Marks are saved even after a failure, so an import can be resumed
+	// from what it got through.
+	if *exportMarks != "" {
+		var buf bytes.Buffer
+		if err := fastimport.WriteMarks(&buf, im.Marks); err != nil {
+			return err
+		}
+		if err := os.WriteFile(*exportMarks, buf.Bytes(), 0666); err != nil {
+			return err
+		}
+	}
+	if importErr != nil {
+		return importErr
+	}
+	if !*quiet {
+		fmt.Fprintf(os.Stderr, "fast-import: %d blobs, %d commits, %d tags\n", im.Stats.Blobs, im.Stats.Commits, im.Stats.Tags)
+	}
+	return nil
+}
+
+// runFastExport handles `rev fast-export [--import-marks=<file>]
+// [--export-marks=<file>] (--all | <ref>...)`.
+func runFastExport(args []string) error {
+	fs := flag.NewFlagSet("fast-export", flag.ContinueOnError)
+	all := fs.Bool("all", false, "Export every ref under refs/")
+	importMarks := fs.String("import-marks", "", "Skip objects marked in this file by an earlier export")
+	exportMarks := fs.String("export-marks", "", "Write the marks to this file when done")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *all == (fs.NArg() > 0) {
+		return fmt.Errorf("usage: fast-export (--all | <ref>...)")
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+
+	var refs []string
+	if *all {
+		list, err := repo.Refs().List()
+		if err != nil {
+			return err
+		}
+		for _, r := range list {
+			refs = append(refs, r.Name)
+		}
+	}
+	for _, name := range fs.Args() {
+		ref, err := fullRefName(repo, name)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, ref)
+	}
+
+	ex := fastimport.NewExporter(repo)
+	if *importMarks != "" {
+		f, err := os.Open(*importMarks)
+		if err != nil {
+			return err
+		}
+		err = fastimport.ReadMarks(f, ex.Marks)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := ex.Export(os.Stdout, refs); err != nil {
+		return err
+	}
+	if *exportMarks != "" {
+		var buf bytes.Buffer
+		if err := fastimport.WriteMarks(&buf, ex.Marks); err != nil {
+			return err
+		}
+		return os.WriteFile(*exportMarks, buf.Bytes(), 0666)
+	}
+	return nil
+}
+
+// fullRefName expands a ref name as given on the command line ("main",
+// "tags/v1", "HEAD") to the full name of the ref it means, searching in
+// the order rev-parse does.
+func fullRefName(repo *repository.Repository, name string) (string, error) {
+	if name == "HEAD" {
+		target, ok, err := repository.ReadSymbolicRef(repo.GitDir, "HEAD")
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("HEAD is detached; name a ref to export")
+		}
+		return target, nil
+	}
+	for _, ref := range []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name, "refs/remotes/" + name} {
+		if !strings.HasPrefix(ref, "refs/") {
+			continue
+		}
+		if _, ok, err := repo.Refs().Lookup(ref); err != nil {
+			return "", err
+		} else if ok {
+			return ref, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not a ref", name)
+}
+
+// runLsTree handles `rev ls-tree [-r] [-d] [-t] [--name-only] <tree>`.
+func runLsTree(args []string) error {
+	fs := flag.NewFlagSet("ls-tree", flag.ContinueOnError)
+	var opts lsTreeOptions
+	fs.BoolVar(&opts.recurse, "r", false, "Recurse into subtrees")
+	fs.BoolVar(&opts.treesOnly, "d", false, "Show only subtrees")
+	fs.BoolVar(&opts.showTrees, "t", false, "Show subtrees as well as recursing into them")
+	fs.BoolVar(&opts.nameOnly, "name-only", false, "Show only the path of each entry")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("ls-tree requires a tree hash")
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+
+	return listTree(repo.GitDir, positional[0], "", opts)
+}
+
+// lsTreeOptions says which entries ls-tree descends into and prints, and
+// how it prints them.
+type lsTreeOptions struct {
+	recurse   bool // -r: list subtrees' contents
+	showTrees bool // -t: also print the subtrees -r descends into
+	treesOnly bool // -d: print subtrees and nothing else
+	nameOnly  bool // --name-only: print paths without mode, type, or hash
+}
+
+// descends reports whether ls-tree lists e's contents.
+func (o lsTreeOptions) descends(e object.TreeEntry) bool {
+	return o.recurse && e.Mode == object.ModeTree
+}
+
+// shows reports whether ls-tree prints e itself.
+func (o lsTreeOptions) shows(e object.TreeEntry) bool {
+	switch {
+	case o.treesOnly:
+		return e.Mode == object.ModeTree
+	case o.descends(e):
+		return o.showTrees
+	}
+	return true
+}
+
+// listTree prints the entries of a tree that opts selects, prefixing
+// names with prefix.
+func listTree(gitDir, hash, prefix string, opts lsTreeOptions) error {
+	entries, err := object.ReadTree(gitDir, hash)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		e.Name = prefix + e.Name
+		if opts.shows(e) {
+			if opts.nameOnly {
+				fmt.Println(e.Name)
+			} else {
+				fmt.Println(e)
+			}
+		}
+		if opts.descends(e) {
+			if err := listTree(gitDir, e.Hash, e.Name+"/", opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runCommitTree handles `rev commit-tree <tree> [-p <parent>]... [-m <message>]...`.
+func runCommitTree(args []string) error {
+	fs := flag.NewFlagSet("commit-tree", flag.ContinueOnError)
+	var parents, messages stringList
+	fs.Var(&parents, "p", "Parent commit (repeatable)")
+	fs.Var(&messages, "m", "Commit message paragraph (repeatable); read from stdin if omitted")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("commit-tree requires exactly one tree hash")
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+	algo, err := repository.ObjectFormat(repo.GitDir)
+	if err != nil {
+		return err
+	}
+
+	tree, err := expectType(repo.GitDir, positional[0], object.TypeTree)
+	if err != nil {
+		return err
+	}
+	commit := &object.Commit{Tree: tree}
+	for _, p := range parents {
+		hash, err := expectType(repo.GitDir, p, object.TypeCommit)
+		if err != nil {
+			return err
+		}
+		commit.Parents = append(commit.Parents, hash)
+	}
+
+	if len(messages) > 0 {
+		commit.Message = strings.Join(messages, "\n\n") + "\n"
+	} else {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("reading stdin: %w", err)
+		}
+		commit.Message = string(data)
+	}
+
+	cfg, err := repository.ParseConfig(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	if commit.Author, err = identity(cfg, "AUTHOR"); err != nil {
+		return err
+	}
+	if commit.Committer, err = identity(cfg, "COMMITTER"); err != nil {
+		return err
+	}
+
+	sha, err := object.WriteObjectWith(algo, repo.GitDir, object.TypeCommit, commit.Bytes())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(sha)
+	return nil
+}
+
+// runWriteTree handles `rev write-tree [<dir>]`. Without an argument it
+// writes the index, as git does; with one it snapshots that directory
+// directly, bypassing the index.
+func runWriteTree(args []string) error {
+	fs := flag.NewFlagSet("write-tree", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+	algo, err := repository.ObjectFormat(repo.GitDir)
+	if err != nil {
+		return err
+	}
+
+	var sha string
+	if dir := fs.Arg(0); dir != "" {
+		sha, err = object.WriteDirTreeWith(algo, repo.GitDir, dir)
+	} else {
+		var idx *index.Index
+		if idx, err = index.ReadIndex(repo.GitDir); err == nil {
+			sha, err = idx.WriteTree(repo.GitDir)
+		}
+		// Writing the index back saves the cached tree.
+		if err == nil {
+			err = index.WriteIndex(repo.GitDir, idx)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(sha)
+	return nil
+}
+
+// runConfig handles `rev config <name> [<value>]` and
+// `rev config --unset <name>`, where name is a dotted section.key such as
+// remote.origin.url. Reading an unset name exits 1 quietly, as in git.
+func runConfig(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	unset := fs.Bool("unset", false, "Remove the variable from the config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 || *unset && fs.NArg() != 1 {
+		return fmt.Errorf("usage: rev config <name> [<value>] | rev config --unset <name>")
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+	section, key, err := repository.ParseKey(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cfg, err := repository.ParseConfig(repo.GitDir)
+	if err != nil {
+		return err
+	}
+
+	// Like git, refuse to change a variable set more than once rather
+	// than guess which value is meant, and exit 5 for that or for
+	// unsetting a variable that isn't there.
+	if *unset || fs.NArg() == 2 {
+		switch n := len(cfg.GetAll(section, key)); {
+		case n > 1:
+			fmt.Fprintf(os.Stderr, "warning: %s has multiple values\n", fs.Arg(0))
+			return exitCode(5)
+		case n == 0 && *unset:
+			return exitCode(5)
+		}
+	}
+
+	switch {
+	case *unset:
+		cfg.Unset(section, key)
+	case fs.NArg() == 2:
+		cfg.Set(section, key, fs.Arg(1))
+	default:
+		value, ok := cfg.Get(section, key)
+		if !ok {
+			return exitCode(1)
+		}
+		fmt.Println(value)
+		return nil
+	}
+	return repository.WriteConfig(repo.GitDir, cfg)
+}
+
+// runAdd handles `rev add <path>...`. Nothing is staged unless every
+// path can be: the index is only rewritten once all of them are added.
+func runAdd(args []string) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: rev add <path>...")
+	}
+
+	repo, err := openWorkTree("add")
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	for _, arg := range fs.Args() {
+		rel, err := workTreePath(repo, arg)
+		if err != nil {
+			return err
+		}
+		if err := idx.AddPath(repo.GitDir, repo.Path, rel); err != nil {
+			return err
+		}
+	}
+	return index.WriteIndex(repo.GitDir, idx)
+}
+
+// runRm handles `rev rm [--cached] [-f] [-r] <path>...`. As in git, a
+// file whose staged content differs from HEAD or from the working tree is
+// refused without -f, since removing it would lose that content; with
+// --cached only a file matching neither is refused. Nothing is removed
+// unless every path passes.
+func runRm(args []string) error {
+	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
+	cached := fs.Bool("cached", false, "Only remove from the index, keeping the working tree file")
+	force := fs.Bool("f", false, "Remove even if the file has staged or local changes")
+	recursive := fs.Bool("r", false, "Allow recursive removal when a directory is named")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("usage: rev rm [--cached] [-f] [-r] <path>...")
+	}
+
+	repo, err := openWorkTree("rm")
+	if err != nil {
+		return err
+	}
+	idx, err := index.ReadIndex(repo.GitDir)
+	if err != nil {
+		return err
+	}
+
+	var targets []*index.Entry
+	for _, arg := range positional {
+		rel, err := workTreePath(repo, arg)
+		if err != nil {
+			return err
+		}
+		matched := 0
+		for _, e := range idx.Entries {
+			switch {
+			case e.Path == rel:
+			case rel == "" || strings.HasPrefix(e.Path, rel+"/"):
+				if !*recursive {
+					return fmt.Errorf("not removing '%s' recursively without -r", arg)
+				}
+			default:
+				continue
+			}
+			targets = append(targets, e)
+			matched++
+		}
+		if matched == 0 {
+			return fmt.Errorf("pathspec '%s' did not match any files", arg)
+		}
+	}
+
+	if !*force {
+		if err := checkRemovable(repo, targets, *cached); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range targets {
+		idx.Remove(e.Path)
+	}
+	if err := index.WriteIndex(repo.GitDir, idx); err != nil {
+		return err
+	}
+	for _, e := range targets {
+		fmt.Printf("rm '%s'\n", e.Path)
+		if !*cached {
+			if err := index.RemoveFile(repo.Path, e.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRemovable refuses to remove entries whose content exists only in
+// the index (see runRm).
+func checkRemovable(repo *repository.Repository, entries []*index.Entry, cached bool) error {
+	headTree, err := repository.HeadTree(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	inHead := make(map[string]object.TreeEntry)
+	if headTree != "" {
+		flat, err := object.FlattenTree(repo.GitDir, headTree)
+		if err != nil {
+			return err
+		}
+		for _, te := range flat {
+			inHead[te.Name] = te
+		}
+	}
+
+	filters, err := index.LoadFilters(repo.GitDir, repo.Path)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		te, ok := inHead[e.Path]
+		staged := !ok || te.Hash != e.Hash || te.Mode != e.Mode
+
+		// A file already gone from the working tree has nothing to lose.
+		local := false
+		sha, _, err := filters.HashFile(filepath.Join(repo.Path, filepath.FromSlash(e.Path)), e.Path)
+		if err == nil {
+			local = sha != e.Hash
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		switch {
+		case staged && local:
+			return fmt.Errorf("'%s' has staged content different from both the file and the HEAD (use -f to force removal)", e.Path)
+		case cached:
+		case staged:
+			return fmt.Errorf("'%s' has changes staged in the index (use --cached to keep the file, or -f to force removal)", e.Path)
+		case local:
+			return fmt.Errorf("'%s' has local modifications (use --cached to keep the file, or -f to force removal)", e.Path)
+		}
+	}
+	return nil
+}
+
+// runStatus handles `rev status [--abbrev[=<n>] | --no-abbrev]
+// [--color[=<when>]]`.
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	var abbrev abbrevFlag
+	fs.Var(&abbrev, "abbrev", "Abbreviate a detached HEAD to at least `n` digits (default core.abbrev)")
+	noAbbrev := fs.Bool("no-abbrev", false, "Show a detached HEAD in full")
+	var colorWhen colorFlag
+	fs.Var(&colorWhen, "color", "Color output: `when` is always (the default for a bare --color), never, or auto")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := openWorkTree("status")
+	if err != nil {
+		return err
+	}
+	cfg, err := repository.ParseConfig(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	out, err := colorWriter(cfg, string(colorWhen))
+	if err != nil {
+		return err
+	}
+	abbrevLen, err := abbrevLength(cfg, abbrev, *noAbbrev)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	headTree, err := repo.Refs().HeadTree()
+	if err != nil {
+		return err
+	}
+	st, err := idx.Status(repo.GitDir, repo.Path, headTree)
+	if err != nil {
+		return err
+	}
+
+	if branch, ok, err := repository.CurrentBranch(repo.GitDir); err != nil {
+		return err
+	} else if ok {
+		fmt.Fprintf(out, "On branch %s\n", branch)
+	} else {
+		head, err := repo.Refs().ResolveHead()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "HEAD detached at %s\n", abbrevHash(repo.GitDir, head, abbrevLen))
+	}
+	if headTree == "" {
+		fmt.Fprint(out, "\nNo commits yet\n\n")
+	}
+
+	printChanges := func(title string, changes []index.Change, c color.Color) {
+		if len(changes) == 0 {
+			return
+		}
+		fmt.Fprintf(out, "%s:\n", title)
+		for _, ch := range changes {
+			fmt.Fprintf(out, "\t%s\n", out.Paint(c, fmt.Sprintf("%-12s%s", ch.Kind.String()+":", ch.Path)))
+		}
+		fmt.Fprintln(out)
+	}
+	printChanges("Changes to be committed", st.Staged, color.StatusStaged)
+	printChanges("Changes not staged for commit", st.Unstaged, color.StatusUnstaged)
+	if len(st.Untracked) > 0 {
+		fmt.Fprint(out, "Untracked files:\n")
+		for _, p := range st.Untracked {
+			fmt.Fprintf(out, "\t%s\n", out.Paint(color.StatusUnstaged, p))
+		}
+		fmt.Fprintln(out)
+	}
+
+	switch {
+	case len(st.Staged) > 0:
+	case len(st.Unstaged) > 0:
+		fmt.Fprintln(out, `no changes added to commit (use "rev add")`)
+	case len(st.Untracked) > 0:
+		fmt.Fprintln(out, `nothing added to commit but untracked files present (use "rev add" to track)`)
+	case headTree == "":
+		fmt.Fprintln(out, `nothing to commit (create/copy files and use "rev add" to track)`)
+	default:
+		fmt.Fprintln(out, "nothing to commit, working tree clean")
+	}
+	return nil
+}
+
+// runCommit handles `rev commit -m <msg>... [--author=<ident>] [-s]
+// [--trailer <trailer>]...`: the index is written as a tree, committed on
+// top of HEAD (with no parent for the first commit), and the current
+// branch, or a detached HEAD, moved to it. It refuses an empty index and
+// a tree identical to HEAD's. --author replaces the author's name and
+// email, and -s adds the committer's Signed-off-by trailer ahead of any
+// given with --trailer.
+func runCommit(args []string) error {
+	fs := flag.NewFlagSet("commit", flag.ContinueOnError)
+	var messages, trailerArgs stringList
+	fs.Var(&messages, "m", "Commit message paragraph (repeatable)")
+	author := fs.String("author", "", "Override the author, given as \"Name <email>\"")
+	signoff := fs.Bool("signoff", false, "Add a Signed-off-by trailer for the committer")
+	fs.BoolVar(signoff, "s", false, "Same as --signoff")
+	fs.Var(&trailerArgs, "trailer", "Trailer to add, as \"Key: value\" (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		return fmt.Errorf("commit: a message is required (use -m)")
+	}
+	var authorIdent object.Signature
+	if *author != "" {
+		var err error
+		if authorIdent, err = parseIdent(*author); err != nil {
+			return fmt.Errorf("--author: %w", err)
+		}
+	}
+	var trailers []trailer.Trailer
+	for _, arg := range trailerArgs {
+		t, err := trailer.Parse(arg)
+		if err != nil {
+			return err
+		}
+		trailers = append(trailers, t)
+	}
+
+	repo, err := openWorkTree("commit")
+	if err != nil {
+		return err
+	}
+	idx, err := index.ReadIndex(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	if len(idx.Entries) == 0 {
+		return fmt.Errorf(`nothing to commit (use "rev add" to track files)`)
+	}
+	tree, err := idx.WriteTree(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	if err := index.WriteIndex(repo.GitDir, idx); err != nil {
+		return err
+	}
+
+	commit := &object.Commit{Tree: tree, Message: strings.Join(messages, "\n\n") + "\n"}
+	head, err := repository.ResolveHead(repo.GitDir)
+	switch {
+	case errors.Is(err, repository.ErrUnbornBranch):
+	case err != nil:
+		return err
+	default:
+		headTree, err := repository.HeadTree(repo.GitDir)
+		if err != nil {
+			return err
+		}
+		if headTree == tree {
+			return fmt.Errorf(`nothing to commit: no changes staged (use "rev add")`)
+		}
+		commit.Parents = []string{head}
+	}
+
+	cfg, err := repository.ParseConfig(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	commit.Author = authorIdent
+	if *author == "" {
+		if commit.Author, err = identity(cfg, "AUTHOR"); err != nil {
+			return err
+		}
+	}
+	if commit.Committer, err = identity(cfg, "COMMITTER"); err != nil {
+		return err
+	}
+	if *signoff {
+		commit.Message = trailer.Insert(commit.Message, trailer.Signoff(commit.Committer.Name, commit.Committer.Email))
+	}
+	for _, t := range trailers {
+		commit.Message = trailer.Insert(commit.Message, t)
+	}
+
+	sha, err := object.WriteObjectWith(idx.Algorithm, repo.GitDir, object.TypeCommit, commit.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := repository.UpdateRef(repo.GitDir, "HEAD", sha); err != nil {
+		return err
+	}
+
+	where, logged := "detached HEAD", []string{"HEAD"}
+	if branch, ok, err := repository.CurrentBranch(repo.GitDir); err != nil {
+		return err
+	} else if ok {
+		where = branch
+		logged = append(logged, "refs/heads/"+branch)
+	}
+	action := "commit"
+	if len(commit.Parents) == 0 {
+		where += " (root-commit)"
+		action = "commit (initial)"
+	}
+	if err := logRefs(repo.GitDir, head, sha, action+": "+object.Subject(commit.Message), logged...); err != nil {
+		return err
+	}
+	fmt.Printf("[%s %s] %s\n", where, shortHash(repo.GitDir, sha), object.Subject(commit.Message))
+	return autoGc(repo.GitDir)
+}
+
+// runReadTree handles `rev read-tree <tree-ish>`. The working tree is
+// left alone, so until it is checked out every file shows as changed.
+func runReadTree(args []string) error {
+	fs := flag.NewFlagSet("read-tree", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: rev read-tree <tree-ish>")
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+	tree, err := repository.Peel(repo.GitDir, fs.Arg(0), object.TypeTree)
+	if err != nil {
+		return err
+	}
+	idx, err := index.FromTree(repo.GitDir, tree)
+	if err != nil {
+		return err
+	}
+	return index.WriteIndex(repo.GitDir, idx)
+}
+
+// runCheckout handles `rev checkout [-f] <branch|commit>`. A branch name
+// leaves HEAD pointing at the branch; anything else that names a commit
+// detaches HEAD there. "-" and "@{-n}" go back to what was checked out
+// before, re-attaching HEAD if that was a branch.
+func runCheckout(args []string) error {
+	fs := flag.NewFlagSet("checkout", flag.ContinueOnError)
+	force := fs.Bool("f", false, "Throw away local changes to tracked files")
+	rest, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("usage: rev checkout [-f] <branch|commit>")
+	}
+
+	repo, err := openWorkTree("checkout")
+	if err != nil {
+		return err
+	}
+	target, err := previousCheckout(repo.GitDir, rest[0])
+	if err != nil {
+		return err
+	}
+	branch := "refs/heads/" + target
+	sha, isBranch, err := repo.Refs().Lookup(branch)
+	if err != nil {
+		return err
+	}
+	if !isBranch {
+		if sha, err = repository.Peel(repo.GitDir, target, object.TypeCommit); err != nil {
+			return err
+		}
+	}
+	if err := checkoutTree(repo, sha, *force); err != nil {
+		return err
+	}
+
+	if isBranch {
+		return attachHead(repo, target, sha)
+	}
+	return detachHead(repo, sha)
+}
+
+// runSwitch handles `rev switch [-f] <branch>`, `rev switch [-f] -c
+// <new-branch> [<start>]`, and `rev switch [-f] --detach <commit>`.
+// Unlike checkout, it only moves HEAD to a branch unless told to detach,
+// and never touches individual paths. As with checkout, "-" is the branch
+// checked out before this one.
+func runSwitch(args []string) error {
+	fs := flag.NewFlagSet("switch", flag.ContinueOnError)
+	create := fs.String("c", "", "Create a branch with this `name` at the start commit (default HEAD), and switch to it")
+	detach := fs.Bool("detach", false, "Detach HEAD at the named commit")
+	force := fs.Bool("f", false, "Throw away local changes to tracked files")
+	fs.BoolVar(force, "discard-changes", false, "Same as -f")
+	rest, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 1 || len(rest) == 0 && *create == "" || *create != "" && *detach {
+		return fmt.Errorf("usage: rev switch [-f] (<branch> | -c <new-branch> [<start>] | --detach <commit>)")
+	}
+
+	repo, err := openWorkTree("switch")
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		if rest[0], err = previousCheckout(repo.GitDir, rest[0]); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case *create != "":
+		branch := "refs/heads/" + *create
+		if err := repository.CheckRefName(branch); err != nil {
+			return err
+		}
+		if _, exists, err := repo.Refs().Lookup(branch); err != nil {
+			return err
+		} else if exists {
+			return fmt.Errorf("a branch named '%s' already exists", *create)
+		}
+		var sha, startName string
+		if len(rest) == 0 {
+			sha, err = repository.ResolveHead(repo.GitDir)
+		} else {
+			startName = rest[0]
+			sha, err = repository.Peel(repo.GitDir, startName, object.TypeCommit)
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkoutTree(repo, sha, *force); err != nil {
+			return err
+		}
+		from, old, err := headPosition(repo.GitDir)
+		if err != nil {
+			return err
+		}
+		if err := repository.CreateBranch(repo.GitDir, *create, sha); err != nil {
+			return err
+		}
+		if err := logRefs(repo.GitDir, "", sha, "branch: Created from "+cmp.Or(startName, "HEAD"), branch); err != nil {
+			return err
+		}
+		if err := repository.WriteSymbolicRef(repo.GitDir, "HEAD", branch); err != nil {
+			return err
+		}
+		if err := logRefs(repo.GitDir, old, sha, fmt.Sprintf("checkout: moving from %s to %s", from, *create), "HEAD"); err != nil {
+			return err
+		}
+		fmt.Printf("Switched to a new branch '%s'\n", *create)
+		return nil
+
+	case *detach:
+		sha, err := repository.Peel(repo.GitDir, rest[0], object.TypeCommit)
+		if err != nil {
+			return err
+		}
+		if err := checkoutTree(repo, sha, *force); err != nil {
+			return err
+		}
+		return detachHead(repo, sha)
+	}
+
+	target := rest[0]
+	branch := "refs/heads/" + target
+	sha, isBranch, err := repo.Refs().Lookup(branch)
+	if err != nil {
+		return err
+	}
+	if !isBranch {
+		if _, err := repository.Peel(repo.GitDir, target, object.TypeCommit); err == nil {
+			return fmt.Errorf("a branch is expected, got commit '%s' (use --detach to detach HEAD there)", target)
+		}
+		return fmt.Errorf("invalid reference: %s", target)
+	}
+	if err := checkoutTree(repo, sha, *force); err != nil {
+		return err
+	}
+	return attachHead(repo, target, sha)
+}
+
+// runRestore handles `rev restore [-S | --staged] [-W | --worktree]
+// [-s <tree> | --source=<tree>] <path>...`. Files are restored to the
+// working tree, or only to the index with --staged alone. They come from
+// the index, or from HEAD if the index is being restored, unless
+// --source names another tree-ish. Tracked files under a path that the
+// source lacks are removed, as in git.
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	staged := fs.Bool("staged", false, "Restore the index")
+	fs.BoolVar(staged, "S", false, "Same as --staged")
+	worktree := fs.Bool("worktree", false, "Restore the working tree (the default without --staged)")
+	fs.BoolVar(worktree, "W", false, "Same as --worktree")
+	source := fs.String("source", "", "Restore from this `tree-ish` instead of the index or HEAD")
+	fs.StringVar(source, "s", "", "Same as --source")
+	rest, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return fmt.Errorf("you must specify path(s) to restore")
+	}
+	if !*staged {
+		*worktree = true
+	}
+
+	repo, err := openWorkTree("restore")
+	if err != nil {
+		return err
+	}
+	idx, err := index.ReadIndex(repo.GitDir)
+	if err != nil {
+		return err
+	}
+
+	from := make(map[string]object.TreeEntry)
+	if *source != "" || *staged {
+		var tree string
+		if *source != "" {
+			tree, err = repository.Peel(repo.GitDir, *source, object.TypeTree)
+		} else {
+			tree, err = repository.HeadTree(repo.GitDir)
+		}
+		if err != nil {
+			return err
+		}
+		if tree != "" {
+			flat, err := object.FlattenTree(repo.GitDir, tree)
+			if err != nil {
+				return err
+			}
+			for _, te := range flat {
+				from[te.Name] = te
+			}
+		}
+	} else {
+		for _, e := range idx.Entries {
+			if e.Stage == 0 {
+				from[e.Path] = object.TreeEntry{Mode: e.Mode, Name: e.Path, Hash: e.Hash}
+			}
+		}
+	}
+
+	// A path matches what the source and the index have at or under it.
+	seen := make(map[string]bool)
+	var paths []string
+	for _, arg := range rest {
+		rel, err := workTreePath(repo, arg)
+		if err != nil {
+			return err
+		}
+		matched := false
+		match := func(p string) {
+			if rel != "" && p != rel && !strings.HasPrefix(p, rel+"/") {
+				return
+			}
+			matched = true
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+		for p := range from {
+			match(p)
+		}
+		for _, e := range idx.Entries {
+			match(e.Path)
+		}
+		if !matched {
+			return fmt.Errorf("pathspec '%s' did not match any files", arg)
+		}
+	}
+	slices.Sort(paths)
+
+	if err := idx.Restore(repo.GitDir, repo.Path, from, paths, *staged, *worktree); err != nil {
+		return err
+	}
+	return index.WriteIndex(repo.GitDir, idx)
+}
+
+// previousCheckout returns arg, unless it is "-" or "@{-n}", which
+// checkout and switch take to mean what was checked out before: the
+// branch by name, so HEAD attaches to it again, or the commit if HEAD
+// was detached.
+func previousCheckout(gitDir, arg string) (string, error) {
+	n := 1
+	if arg != "-" {
+		digits, ok := strings.CutPrefix(arg, "@{-")
+		digits, ok2 := strings.CutSuffix(digits, "}")
+		var err error
+		if n, err = strconv.Atoi(digits); !ok || !ok2 || err != nil || n < 1 || digits[0] == '+' {
+			return arg, nil
+		}
+	}
+	prev, err := repository.PreviousBranch(gitDir, n)
+	if errors.Is(err, repository.ErrNoPreviousBranch) {
+		return "", fmt.Errorf("%s: no branch was checked out before", arg)
+	}
+	return prev, err
+}
+
+// checkoutTree moves the index and working tree from HEAD's tree to the
+// commit sha's, as checkout -f does if force is set, and leaves HEAD
+// alone.
+func checkoutTree(repo *repository.Repository, sha string, force bool) error {
+	tree, err := repository.Peel(repo.GitDir, sha, object.TypeTree)
+	if err != nil {
+		return err
+	}
+	oldTree, err := repository.HeadTree(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	idx, err := index.ReadIndex(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	if err := idx.Checkout(repo.GitDir, repo.Path, oldTree, tree, force); err != nil {
+		return err
+	}
+	return index.WriteIndex(repo.GitDir, idx)
+}
+
+// attachHead points HEAD at the branch name, whose tip is sha, and says
+// so, recording the checkout in HEAD's reflog.
+func attachHead(repo *repository.Repository, name, sha string) error {
+	current, onBranch, err := repository.CurrentBranch(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	if onBranch && current == name {
+		fmt.Printf("Already on '%s'\n", name)
+		return nil
+	}
+	from, old, err := headPosition(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	if err := repository.WriteSymbolicRef(repo.GitDir, "HEAD", "refs/heads/"+name); err != nil {
+		return err
+	}
+	if err := logRefs(repo.GitDir, old, sha, fmt.Sprintf("checkout: moving from %s to %s", from, name), "HEAD"); err != nil {
+		return err
+	}
+	fmt.Printf("Switched to branch '%s'\n", name)
+	return nil
+}
+
+// detachHead points HEAD at the commit sha and says so, recording the
+// checkout in HEAD's reflog.
+func detachHead(repo *repository.Repository, sha string) error {
+	from, old, err := headPosition(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	if err := repository.DetachHead(repo.GitDir, sha); err != nil {
+		return err
+	}
+	if err := logRefs(repo.GitDir, old, sha, fmt.Sprintf("checkout: moving from %s to %s", from, sha), "HEAD"); err != nil {
+		return err
+	}
+	obj, err := object.Read(repo.GitDir, sha)
+	if err != nil {
+		return err
+	}
+	commit, err := object.ParseCommit(obj)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("HEAD is now at %s %s\n", shortHash(repo.GitDir, sha), object.Subject(commit.Message))
+	return nil
+}
+
+// headPosition returns where HEAD is, as a checkout reflog entry names
+// it (the branch, or the commit when detached), and the commit it is
+// at, "" on an unborn branch.
+func headPosition(gitDir string) (from, sha string, err error) {
+	sha, err = repository.ResolveHead(gitDir)
+	if errors.Is(err, repository.ErrUnbornBranch) {
+		sha, err = "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	branch, onBranch, err := repository.CurrentBranch(gitDir)
+	if err != nil {
+		return "", "", err
+	}
+	if onBranch {
+		return branch, sha, nil
+	}
+	return sha, sha, nil
+}
+
+// logRefs records refs moving from old to sha in their reflogs, with
+// msg. The committer signs each entry; with no identity configured the
+// user's login and host name stand in, as in git, rather than failing a
+// command whose real work is done.
+func logRefs(gitDir, old, sha, msg string, refs ...string) error {
+	cfg, err := repository.ParseConfig(gitDir)
+	if err != nil {
+		return err
+	}
+	who, err := identity(cfg, "COMMITTER")
+	if err != nil {
+		login := cmp.Or(os.Getenv("USER"), "unknown")
+		host, _ := os.Hostname()
+		who = signatureNow(login, login+"@"+cmp.Or(host, "localhost"))
+	}
+	for _, ref := range refs {
+		e := repository.ReflogEntry{Old: old, New: sha, Committer: who, Message: msg}
+		if err := repository.AppendReflog(gitDir, ref, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runDiff handles `rev diff [--cached] [--color[=<when>]] [<rev> <rev>]`
+// and `rev diff --no-index <path> <path>`.
+// With no revisions it shows unstaged changes, comparing the index with
+// the working tree, or with --cached, staged ones, comparing HEAD with
+// the index. Two revisions are compared as blobs if both are, and as
+// trees otherwise, with -M[<n>] pairing deleted and added files into
+// renames. --no-index compares two files or directories on disk and
+// needs no repository. --word-diff[=<mode>] shows changed lines word by
+// word, splitting words with --word-diff-regex or diff.wordRegex;
+// --color-words[=<regex>] is the same as --word-diff=color. --stat shows
+// only a histogram of the lines changed in each file. Lines are matched
+// up with --diff-algorithm, or diff.algorithm, defaulting to Myers.
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	cached := fs.Bool("cached", false, "Compare the index with HEAD instead of the working tree")
+	noIndex := fs.Bool("no-index", false, "Compare two paths on the filesystem, outside any repository")
+	var colorWhen colorFlag
+	fs.Var(&colorWhen, "color", "Color output: `when` is always (the default for a bare --color), never, or auto")
+	var renames renameFlag
+	fs.Var(&renames, "M", "Detect renames at least `n` similar (e.g. -M60%; default 50%)")
+	fs.Var(&renames, "find-renames", "Same as -M")
+	var wordDiff wordDiffFlag
+	fs.Var(&wordDiff, "word-diff", "Show changed words: `mode` is plain (the default), color, porcelain, or none")
+	wordRegex := fs.String("word-diff-regex", "", "Treat each match of `regex` as a word; implies --word-diff")
+	var colorWords colorWordsFlag
+	fs.Var(&colorWords, "color-words", "Same as --word-diff=color, with words matching the optional `regex`")
+	var stat statFlag
+	fs.Var(&stat, "stat", "Show a diffstat instead, at most `width[,name-width]` columns wide")
+	var algorithm algorithmFlag
+	fs.Var(&algorithm, "diff-algorithm", "Match up lines with `algorithm`: myers (the default), minimal, patience, or histogram")
+	for _, name := range []string{"minimal", "patience", "histogram"} {
+		fs.Var(algorithmAlias{&algorithm, name}, name, "Same as --diff-algorithm="+name)
+	}
+	rest, err := parseInterspersed(fs, attachRenameScore(fs, args))
+	if err != nil {
+		return err
+	}
+	options := func(cfg *repository.Config) (diffOptions, error) {
+		opts, err := wordDiffOptions(cfg, wordDiff, *wordRegex, colorWords)
+		if err != nil {
+			return opts, err
+		}
+		if opts.algorithm, err = diffAlgorithm(cfg, string(algorithm)); err != nil {
+			return opts, err
+		}
+		if stat.on {
+			opts.stat = &diffStat{width: stat.width, nameWidth: stat.nameWidth}
+		}
+		return opts, nil
+	}
+	if *noIndex {
+		if len(rest) != 2 || *cached {
+			return fmt.Errorf("usage: rev diff --no-index <path> <path>")
+		}
+		cfg := &repository.Config{}
+		if repo, err := repository.Open(""); err == nil {
+			if cfg, err = repository.ParseConfig(repo.GitDir); err != nil {
+				return err
+			}
+		}
+		opts, err := options(cfg)
+		if err != nil {
+			return err
+		}
+		out, err := colorWriter(cfg, opts.colorWhen(string(colorWhen)))
+		if err != nil {
+			return err
+		}
+		return finishDiff(out, opts, diffNoIndex(out, opts, rest[0], rest[1]))
+	}
+	if len(rest) != 0 && (len(rest) != 2 || *cached) {
+		return fmt.Errorf("usage: rev diff [--cached] | rev diff <rev> <rev> | rev diff --no-index <path> <path>")
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+	cfg, err := repository.ParseConfig(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	opts, err := options(cfg)
+	if err != nil {
+		return err
+	}
+	if opts.textconv, err = diff.LoadTextconv(repo.GitDir, repo.Path); err != nil {
+		return err
+	}
+	out, err := colorWriter(cfg, opts.colorWhen(string(colorWhen)))
+	if err != nil {
+		return err
+	}
+
+	if len(rest) == 2 {
+		return finishDiff(out, opts, diffRevs(out, opts, repo.GitDir, rest[0], rest[1], renames))
+	}
+
+	if repo.Path == "" {
+		return fmt.Errorf("diff: this operation must be run in a work tree")
+	}
+	idx, err := index.ReadIndex(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	headTree, err := repo.Refs().HeadTree()
+	if err != nil {
+		return err
+	}
+	st, err := idx.Status(repo.GitDir, repo.Path, headTree)
+	if err != nil {
+		return err
+	}
+
+	if *cached {
+		head := make(map[string]object.TreeEntry)
+		if headTree != "" {
+			flat, err := object.FlattenTree(repo.GitDir, headTree)
+			if err != nil {
+				return err
+			}
+			for _, te := range flat {
+				head[te.Name] = te
+			}
+		}
+		for _, c := range st.Staged {
+			var old, new diffSide
+			if te, ok := head[c.Path]; ok {
+				old = diffSide{path: c.Path, mode: te.Mode, hash: te.Hash}
+			}
+			if e := idx.Find(c.Path); e != nil {
+				new = diffSide{path: c.Path, mode: e.Mode, hash: e.Hash}
+			}
+			if err := printChange(out, opts, repo.GitDir, old, new); err != nil {
+				return err
+			}
+		}
+		return finishDiff(out, opts, nil)
+	}
+
+	filters, err := index.LoadFilters(repo.GitDir, repo.Path)
+	if err != nil {
+		return err
+	}
+	for _, c := range st.Unstaged {
+		e := idx.Find(c.Path)
+		old := diffSide{path: c.Path, mode: e.Mode, hash: e.Hash}
+		var new diffSide
+		if c.Kind != index.Deleted {
+			data, mode, err := filters.ReadWorkFile(filepath.Join(repo.Path, filepath.FromSlash(c.Path)), c.Path)
+			if err != nil {
+				return err
+			}
+			hash := object.HashBytesWith(idx.Algorithm, []byte(object.Header(object.TypeBlob, int64(len(data)))+string(data)))
+			new = diffSide{path: c.Path, mode: mode, hash: hash, workTree: true, data: data}
+		}
+		if err := printChange(out, opts, repo.GitDir, old, new); err != nil {
+			return err
+		}
+	}
+	return finishDiff(out, opts, nil)
+}
+
+// finishDiff writes the diffstat gathered for --stat, if any, once the
+// diff that returned err is done. A diff exiting 1 to say there were
+// differences still gets its diffstat.
+func finishDiff(out *color.Writer, opts diffOptions, err error) error {
+	var code exitCode
+	if opts.stat != nil && (err == nil || errors.As(err, &code)) {
+		opts.stat.print(out)
+	}
+	return err
+}
+
+// diffNoIndex writes the diff between two files, or two directories
+// with files paired by their path inside each. A file compared with a
+// directory is paired with the file of the same name in it, as in git.
+// Like git, it exits with status 1 if anything differs.
+func diffNoIndex(out *color.Writer, opts diffOptions, a, b string) error {
+	var isDir [2]bool
+	for i, path := range []string{a, b} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("could not access '%s'", path)
+		}
+		isDir[i] = info.IsDir()
+	}
+	switch {
+	case isDir[0] && !isDir[1]:
+		a = filepath.Join(a, filepath.Base(b))
+	case !isDir[0] && isDir[1]:
+		b = filepath.Join(b, filepath.Base(a))
+	}
+	if isDir[0] != isDir[1] {
+		for _, path := range []string{a, b} {
+			if _, err := os.Lstat(path); err != nil {
+				return fmt.Errorf("could not access '%s'", path)
+			}
+		}
+	}
+
+	var names []string
+	if isDir[0] && isDir[1] {
+		seen := make(map[string]bool)
+		for _, root := range []string{a, b} {
+			err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(root, path)
+				if err != nil {
+					return err
+				}
+				if !seen[rel] {
+					seen[rel] = true
+					names = append(names, rel)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		slices.SortFunc(names, func(x, y string) int {
+			return strings.Compare(filepath.ToSlash(x), filepath.ToSlash(y))
+		})
+	} else {
+		names = []string{""}
+	}
+
+	changed := false
+	for _, name := range names {
+		var sides [2]diffSide
+		for i, root := range []string{a, b} {
+			full := root
+			if name != "" {
+				full = filepath.Join(root, name)
+			}
+			data, mode, err := index.ReadWorkFile(full)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			hash := object.HashBytes([]byte(object.Header(object.TypeBlob, int64(len(data))) + string(data)))
+			path := strings.TrimLeft(filepath.ToSlash(full), "/")
+			sides[i] = diffSide{path: path, mode: mode, hash: hash, workTree: true, data: data}
+		}
+		if sides[0].mode == sides[1].mode && sides[0].hash == sides[1].hash {
+			continue
+		}
+		changed = true
+		if err := printChange(out, opts, "", sides[0], sides[1]); err != nil {
+			return err
+		}
+	}
+	if changed {
+		return exitCode(1)
+	}
+	return nil
+}
+
+// diffRevs writes the diff between two blobs or, for anything else, the
+// trees the two revisions name.
+func diffRevs(out *color.Writer, opts diffOptions, gitDir, revA, revB string, renames renameFlag) error {
+	var hashes, trees [2]string
+	blobs := true
+	for i, rev := range []string{revA, revB} {
+		hash, err := repository.ResolveRef(gitDir, rev)
+		if err != nil {
+			return err
+		}
+		objType, _, err := object.ReadHeader(gitDir, hash)
+		if err != nil {
+			return err
+		}
+		hashes[i] = hash
+		blobs = blobs && objType == object.TypeBlob
+	}
+	if blobs {
+		return printFileDiff(out, opts, gitDir,
+			diffSide{path: revA, mode: object.ModeFile, hash: hashes[0]},
+			diffSide{path: revB, mode: object.ModeFile, hash: hashes[1]}, 0)
+	}
+
+	for i, hash := range hashes {
+		tree, err := repository.Peel(gitDir, hash, object.TypeTree)
+		if err != nil {
+			return err
+		}
+		trees[i] = tree
+	}
+	changes, err := diff.DiffTrees(gitDir, trees[0], trees[1])
+	if err != nil {
+		return err
+	}
+	if renames.on {
+		if changes, err = diff.DetectRenames(gitDir, changes, renames.threshold); err != nil {
+			return err
+		}
+	}
+	for _, c := range changes {
+		old := diffSide{path: c.OldPath, mode: c.OldMode, hash: c.OldHash}
+		new := diffSide{path: c.NewPath, mode: c.NewMode, hash: c.NewHash}
+		if c.Kind == diff.Renamed {
+			err = printFileDiff(out, opts, gitDir, old, new, c.Similarity)
+		} else {
+			err = printChange(out, opts, gitDir, old, new)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameFlag is -M[<n>]: rename detection, with an optional minimum
+// similarity.
+type renameFlag struct {
+	on        bool
+	threshold int
+}
+
+func (f *renameFlag) String() string {
+	if !f.on {
+		return ""
+	}
+	return fmt.Sprintf("%d%%", f.threshold)
+}
+
+func (f *renameFlag) IsBoolFlag() bool { return true }
+
+// Set takes the similarity the way git does: a percentage like "60%", or
+// digits read as a decimal fraction, so "6" and "60" both mean 60%.
+func (f *renameFlag) Set(v string) error {
+	f.on, f.threshold = true, diff.DefaultRenameThreshold
+	if v == "true" {
+		return nil
+	}
+	if pct, ok := strings.CutSuffix(v, "%"); ok {
+		n, err := strconv.Atoi(pct)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid similarity %q", v)
+		}
+		f.threshold = min(n, 100)
+		return nil
+	}
+	if v == "" || strings.Trim(v, "0123456789") != "" {
+		return fmt.Errorf("invalid similarity %q", v)
+	}
+	f.threshold, _ = strconv.Atoi((v + "0")[:2])
+	return nil
+}
+
+// diffOptions controls how printFileDiff shows a file's changes. The
+// zero value shows them line by line.
+type diffOptions struct {
+	// wordDiff is how --word-diff marks changed words: plain, color, or
+	// porcelain; "" means a line diff.
+	wordDiff  string
+	wordRegex *regexp.Regexp
+	algorithm diff.Algorithm
+	// stat, if set, collects each file's line counts for --stat in
+	// place of its diff.
+	stat *diffStat
+	// textconv converts the files whose diff driver has a textconv
+	// command before they are diffed.
+	textconv *diff.Textconv
+}
+
+// colorWhen returns the --color value to use given the one on the
+// command line: --word-diff=color needs color, so it turns it on unless
+// the command line says otherwise.
+func (o diffOptions) colorWhen(flagValue string) string {
+	if flagValue == "" && o.wordDiff == "color" {
+		return "always"
+	}
+	return flagValue
+}
+
+// wordDiffFlag is --word-diff[=<mode>], plain when bare.
+type wordDiffFlag string
+
+func (f *wordDiffFlag) String() string { return string(*f) }
+
+func (f *wordDiffFlag) IsBoolFlag() bool { return true }
+
+func (f *wordDiffFlag) Set(v string) error {
+	switch v {
+	case "true":
+		v = "plain"
+	case "plain", "color", "porcelain", "none":
+	default:
+		return fmt.Errorf("bad --word-diff argument %q", v)
+	}
+	*f = wordDiffFlag(v)
+	return nil
+}
+
+// colorWordsFlag is --color-words[=<regex>].
+type colorWordsFlag struct {
+	on    bool
+	regex string
+}
+
+func (f *colorWordsFlag) String() string { return f.regex }
+
+func (f *colorWordsFlag) IsBoolFlag() bool { return true }
+
+func (f *colorWordsFlag) Set(v string) error {
+	f.on = true
+	if v != "true" {
+		f.regex = v
+	}
+	return nil
+}
+
+// algorithmFlag is --diff-algorithm=<name>, which --minimal, --patience,
+// and --histogram also set.
+type algorithmFlag string
+
+func (f *algorithmFlag) String() string { return string(*f) }
+
+func (f *algorithmFlag) Set(v string) error {
+	if _, err := diff.ParseAlgorithm(v); err != nil {
+		return err
+	}
+	*f = algorithmFlag(v)
+	return nil
+}
+
+// algorithmAlias is a flag such as --patience that stands for one
+// --diff-algorithm.
+type algorithmAlias struct {
+	flag *algorithmFlag
+	name string
+}
+
+func (a algorithmAlias) String() string { return "" }
+
+func (a algorithmAlias) IsBoolFlag() bool { return true }
+
+func (a algorithmAlias) Set(v string) error {
+	if v != "true" {
+		return fmt.Errorf("--%s takes no value", a.name)
+	}
+	*a.flag = algorithmFlag(a.name)
+	return nil
+}
+
+// diffAlgorithm returns the algorithm named on the command line, or else
+// by diff.algorithm, or else Myers.
+func diffAlgorithm(cfg *repository.Config, flagValue string) (diff.Algorithm, error) {
+	name := flagValue
+	if name == "" {
+		name, _ = cfg.Get("diff", "algorithm")
+	}
+	if name == "" {
+		return diff.Myers, nil
+	}
+	return diff.ParseAlgorithm(name)
+}
+
+// wordDiffOptions works out the word diff from the flags. As in git, a
+// word regex on its own turns on --word-diff, and diff.wordRegex is used
+// when no regex is given.
+func wordDiffOptions(cfg *repository.Config, mode wordDiffFlag, regex string, colorWords colorWordsFlag) (diffOptions, error) {
+	var opts diffOptions
+	switch {
+	case colorWords.on:
+		opts.wordDiff = "color"
+		if colorWords.regex != "" {
+			regex = colorWords.regex
+		}
+	case mode == "none":
+		return opts, nil
+	case mode != "":
+		opts.wordDiff = string(mode)
+	case regex != "":
+		opts.wordDiff = "plain"
+	default:
+		return opts, nil
+	}
+	if regex == "" {
+		regex, _ = cfg.Get("diff", "wordRegex")
+	}
+	if regex != "" {
+		re, err := diff.CompileWordRegex(regex)
+		if err != nil {
+			return opts, fmt.Errorf("invalid word regex %q: %w", regex, err)
+		}
+		opts.wordRegex = re
+	}
+	return opts, nil
+}
+
+// diffSide is one side of a file's diff. A zero mode means the file is
+// absent on that side. A working-tree file isn't in the object store, so
+// its content is carried in data; otherwise it is read by hash.
+type diffSide struct {
+	path     string
+	mode     uint32
+	hash     string
+	workTree bool
+	data     []byte
+}
+
+// printChange writes the diff for one changed path. A file that became a
+// symlink or the other way around is shown, as git does, as a deletion
+// followed by an addition, though --stat counts it as one change.
+func printChange(out *color.Writer, opts diffOptions, gitDir string, old, new diffSide) error {
+	if opts.stat == nil && old.mode != 0 && new.mode != 0 && (old.mode == object.ModeSymlink) != (new.mode == object.ModeSymlink) {
+		if err := printFileDiff(out, opts, gitDir, old, diffSide{path: new.path}, 0); err != nil {
+			return err
+		}
+		return printFileDiff(out, opts, gitDir, diffSide{path: old.path}, new, 0)
+	}
+	return printFileDiff(out, opts, gitDir, old, new, 0)
+}
+
+// printFileDiff writes git's diff between old and new, or nothing if
+// they are the same. A nonzero similarity marks the pair as a rename.
+// With --stat, the file is added to opts.stat instead.
+func printFileDiff(out *color.Writer, opts diffOptions, gitDir string, old, new diffSide, similarity int) error {
+	if similarity == 0 && old.mode == new.mode && old.hash == new.hash {
+		return nil
+	}
+	oldPath, newPath := old.path, new.path
+	if oldPath == "" {
+		oldPath = newPath
+	}
+	if newPath == "" {
+		newPath = oldPath
+	}
+	if opts.stat != nil {
+		return opts.stat.add(gitDir, opts.algorithm, old, new, oldPath, newPath)
+	}
+	meta := func(format string, a ...any) {
+		fmt.Fprintln(out, out.Paint(color.DiffMeta, fmt.Sprintf(format, a...)))
+	}
+
+	// The contents are read, and converted, before anything is written,
+	// so that a failing textconv command leaves no partial header.
+	var data [2][]byte
+	var converted [2]bool
+	if old.hash != new.hash {
+		var err error
+		if data, err = readSides(gitDir, old, new); err != nil {
+			return err
+		}
+		for i, side := range []diffSide{old, new} {
+			if side.mode != 0 {
+				if data[i], converted[i], err = opts.textconv.Convert(side.path, side.hash, data[i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	meta("diff --git a/%s b/%s", oldPath, newPath)
+	switch {
+	case old.mode == 0:
+		meta("new file mode %06o", new.mode)
+	case new.mode == 0:
+		meta("deleted file mode %06o", old.mode)
+	case old.mode != new.mode:
+		meta("old mode %06o", old.mode)
+		meta("new mode %06o", new.mode)
+	}
+	if similarity != 0 {
+		meta("similarity index %d%%", similarity)
+		meta("rename from %s", old.path)
+		meta("rename to %s", new.path)
+	}
+	if old.hash == new.hash {
+		return nil
+	}
+
+	oldShort, newShort := abbrevSide(gitDir, old), abbrevSide(gitDir, new)
+	if oldShort == "" {
+		oldShort = strings.Repeat("0", len(newShort))
+	}
+	if newShort == "" {
+		newShort = strings.Repeat("0", len(oldShort))
+	}
+	if old.mode == new.mode {
+		meta("index %s..%s %06o", oldShort, newShort, old.mode)
+	} else {
+		meta("index %s..%s", oldShort, newShort)
+	}
+
+	oldName, newName := "a/"+oldPath, "b/"+newPath
+	if old.mode == 0 {
+		oldName = "/dev/null"
+	}
+	if new.mode == 0 {
+		newName = "/dev/null"
+	}
+	if !converted[0] && diff.IsBinary(data[0]) || !converted[1] && diff.IsBinary(data[1]) {
+		fmt.Fprintf(out, "Binary files %s and %s differ\n", oldName, newName)
+		return nil
+	}
+	hunks := opts.algorithm.DiffBlobs(data[0], data[1])
+	if len(hunks) == 0 {
+		return nil
+	}
+	meta("--- %s", oldName)
+	meta("+++ %s", newName)
+	if opts.wordDiff != "" {
+		printWordHunks(out, opts, hunks)
+	} else {
+		printHunks(out, hunks)
+	}
+	return nil
+}
+
+// readSides returns the contents of old and new, empty for an absent
+// side.
+func readSides(gitDir string, old, new diffSide) ([2][]byte, error) {
+	var data [2][]byte
+	for i, side := range []diffSide{old, new} {
+		data[i] = side.data
+		if side.mode == 0 || side.workTree {
+			continue
+		}
+		obj, err := object.Read(gitDir, side.hash)
+		if err != nil {
+			return data, err
+		}
+		data[i] = obj.Body
+	}
+	return data, nil
+}
+
+// abbrevSide returns the short hash for side, or "" if it is absent. A
+// working-tree file's blob isn't stored, so its hash is cut to the
+// minimum length rather than checked for uniqueness.
+func abbrevSide(gitDir string, side diffSide) string {
+	switch {
+	case side.mode == 0:
+		return ""
+	case side.workTree:
+		return side.hash[:object.MinAbbrev]
+	}
+	return shortHash(gitDir, side.hash)
+}
+
+// printHunks writes hunks in unified format, marking a last line that
+// has no newline.
+func printHunks(out *color.Writer, hunks []diff.Hunk) {
+	for _, h := range hunks {
+		fmt.Fprintln(out, out.Paint(color.DiffHunk, h.Header()))
+		for _, l := range h.Lines {
+			text := strings.TrimSuffix(l.Text, "\n")
+			switch l.Op {
+			case diff.Delete:
+				fmt.Fprintln(out, out.Paint(color.DiffRemoved, "-"+text))
+			case diff.Insert:
+				fmt.Fprintln(out, out.Paint(color.DiffAdded, "+"+text))
+			default:
+				fmt.Fprintln(out, " "+text)
+			}
+			if !strings.HasSuffix(l.Text, "\n") {
+				fmt.Fprintln(out, "\\ No newline at end of file")
+			}
+		}
+	}
+}
+
+// printWordHunks writes hunks the way --word-diff does. Each run of
+// removed and added lines is diffed word by word and written as the new
+// text with the changes marked in it; context lines lose their leading
+// space except in porcelain mode, and a missing final newline isn't
+// mentioned.
+func printWordHunks(out *color.Writer, opts diffOptions, hunks []diff.Hunk) {
+	for _, h := range hunks {
+		fmt.Fprintln(out, out.Paint(color.DiffHunk, h.Header()))
+		var old, new strings.Builder
+		flush := func() {
+			for _, seg := range diff.DiffWords(old.String(), new.String(), opts.wordRegex) {
+				printWords(out, opts.wordDiff, seg)
+			}
+			old.Reset()
+			new.Reset()
+		}
+		for _, l := range h.Lines {
+			text := l.Text
+			if !strings.HasSuffix(text, "\n") {
+				text += "\n"
+			}
+			switch l.Op {
+			case diff.Delete:
+				old.WriteString(text)
+			case diff.Insert:
+				new.WriteString(text)
+			default:
+				flush()
+				printWords(out, opts.wordDiff, diff.WordSegment{Op: diff.Equal, Text: text})
+			}
+		}
+		flush()
+	}
+}
+
+// printWords writes one piece of a word diff in the given --word-diff
+// mode, marking each of its lines separately. Porcelain mode puts every
+// piece on a line of its own, prefixed with its op, and writes the
+// newlines of the text as "~" lines.
+func printWords(out *color.Writer, mode string, seg diff.WordSegment) {
+	var prefix, suffix string
+	switch mode {
+	case "plain":
+		switch seg.Op {
+		case diff.Delete:
+			prefix, suffix = "[-", "-]"
+		case diff.Insert:
+			prefix, suffix = "{+", "+}"
+		}
+	case "porcelain":
+		prefix = map[diff.Op]string{diff.Equal: " ", diff.Delete: "-", diff.Insert: "+"}[seg.Op]
+	}
+	newline := "\n"
+	if mode == "porcelain" {
+		newline = "~\n"
+	}
+
+	for text := seg.Text; text != ""; {
+		line, rest, found := strings.Cut(text, "\n")
+		if line != "" {
+			line = prefix + line + suffix
+			switch seg.Op {
+			case diff.Delete:
+				line = out.Paint(color.DiffRemoved, line)
+			case diff.Insert:
+				line = out.Paint(color.DiffAdded, line)
+			}
+			if mode == "porcelain" {
+				line += "\n"
+			}
+			fmt.Fprint(out, line)
+		}
+		if !found {
+			break
+		}
+		fmt.Fprint(out, newline)
+		text = rest
+	}
+}
+
+// statFlag is --stat[=<width>[,<name-width>]]: a diffstat in place of
+// the diff, with optional limits on the line's width and the part of it
+// given to names.
+type statFlag struct {
+	on               bool
+	width, nameWidth int
+}
+
+func (f *statFlag) String() string {
+	if !f.on {
+		return ""
+	}
+	return fmt.Sprintf("%d,%d", f.width, f.nameWidth)
+}
+
+func (f *statFlag) IsBoolFlag() bool { return true }
+
+func (f *statFlag) Set(v string) error {
+	f.on, f.width, f.nameWidth = true, 0, 0
+	if v == "true" {
+		return nil
+	}
+	width, nameWidth, hasName := strings.Cut(v, ",")
+	var err error
+	if f.width, err = strconv.Atoi(width); err != nil || f.width < 0 {
+		return fmt.Errorf("invalid --stat width %q", width)
+	}
+	if hasName {
+		if f.nameWidth, err = strconv.Atoi(nameWidth); err != nil || f.nameWidth < 0 {
+			return fmt.Errorf("invalid --stat name width %q", nameWidth)
+		}
+	}
+	return nil
+}
+
+// diffStat gathers the files of a diff for --stat, which can only be
+// laid out once every name and count is known. A zero width means the
+// terminal's.
+type diffStat struct {
+	width, nameWidth int
+	files            []fileStat
+}
+
+// fileStat is one file's line in a diffstat. For a binary file, added
+// and deleted are its new and old sizes in bytes.
+type fileStat struct {
+	name           string
+	added, deleted int
+	binary         bool
+}
+
+// add counts the lines that differ between old and new, as matched up
+// by alg.
+func (s *diffStat) add(gitDir string, alg diff.Algorithm, old, new diffSide, oldPath, newPath string) error {
+	f := fileStat{name: newPath}
+	if oldPath != newPath {
+		f.name = renameName(oldPath, newPath)
+	}
+	if old.hash != new.hash {
+		data, err := readSides(gitDir, old, new)
+		if err != nil {
+			return err
+		}
+		if diff.IsBinary(data[0]) || diff.IsBinary(data[1]) {
+			f.binary, f.added, f.deleted = true, len(data[1]), len(data[0])
+			s.files = append(s.files, f)
+			return nil
+		}
+		for _, h := range alg.DiffBlobs(data[0], data[1]) {
+			for _, l := range h.Lines {
+				switch l.Op {
+				case diff.Insert:
+					f.added++
+				case diff.Delete:
+					f.deleted++
+				}
+			}
+		}
+	}
+	s.files = append(s.files, f)
+	return nil
+}
+
+// renameName shows a rename as git's diffstat does, with the part both
+// paths share left outside braces: "dir/{old => new}/file".
+func renameName(a, b string) string {
+	// The common prefix and suffix each end at a slash.
+	prefix := 0
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '/' {
+			prefix = i + 1
+		}
+	}
+	suffix := 0
+	for i, j := len(a)-1, len(b)-1; i >= max(prefix-1, 0) && j >= max(prefix-1, 0) && a[i] == b[j]; i, j = i-1, j-1 {
+		if a[i] == '/' {
+			suffix = len(a) - i
+		}
+	}
+	if prefix+suffix == 0 {
+		return a + " => " + b
+	}
+	aMid, bMid := a[prefix:max(len(a)-suffix, prefix)], b[prefix:max(len(b)-suffix, prefix)]
+	return a[:prefix] + "{" + aMid + " => " + bMid + "}" + a[len(a)-suffix:]
+}
+
+// print writes the diffstat laid out as git does it. Each file gets a
+// line with its name, its count of changed lines, and a graph of +s and
+// -s, scaled down if the largest change won't fit; names too long for
+// the room left are cut from the front. A summary line follows.
+func (s *diffStat) print(out *color.Writer) {
+	if len(s.files) == 0 {
+		return
+	}
+	width := s.width
+	if width == 0 {
+		width = color.Columns(out.Writer)
+	}
+
+	var maxName, maxChange, numberWidth, binWidth int
+	for _, f := range s.files {
+		maxName = max(maxName, utf8.RuneCountInString(f.name))
+		if f.binary {
+			// "Bin <old> -> <new> bytes", with the count column wide
+			// enough for "Bin".
+			binWidth = max(binWidth, 14+len(strconv.Itoa(f.added))+len(strconv.Itoa(f.deleted)))
+			numberWidth = 3
+			continue
+		}
+		maxChange = max(maxChange, f.added+f.deleted)
+	}
+	numberWidth = max(numberWidth, len(strconv.Itoa(maxChange)))
+
+	// Each line is " <name> | <count> <graph>", so 6 columns go to the
+	// separators. If everything doesn't fit, the graph gets at most 3/8
+	// of the width, and the name what is left.
+	width = max(width, 16+6+numberWidth)
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	nameWidth := maxName
+	if s.nameWidth > 0 {
+		nameWidth = min(nameWidth, s.nameWidth)
+	}
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = max(width*3/8-numberWidth-6, 6)
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	var adds, dels int
+	for _, f := range s.files {
+		name, prefix := f.name, ""
+		if runes := []rune(name); len(runes) > nameWidth {
+			prefix = "..."
+			name = string(runes[len(runes)-max(nameWidth-3, 0):])
+			if i := strings.IndexByte(name, '/'); i >= 0 {
+				name = name[i:]
+			}
+		}
+		padding := max(nameWidth-len(prefix)-utf8.RuneCountInString(name), 0)
+		line := " " + prefix + name + strings.Repeat(" ", padding) + " | "
+
+		if f.binary {
+			line += fmt.Sprintf("%*s", numberWidth, "Bin")
+			if f.added != 0 || f.deleted != 0 {
+				line += fmt.Sprintf(" %s -> %s bytes",
+					out.Paint(color.DiffRemoved, strconv.Itoa(f.deleted)), out.Paint(color.DiffAdded, strconv.Itoa(f.added)))
+			}
+			fmt.Fprintln(out, line)
+			continue
+		}
+
+		adds, dels = adds+f.added, dels+f.deleted
+		add, del := f.added, f.deleted
+		if graphWidth <= maxChange {
+			total := scaleLinear(add+del, graphWidth, maxChange)
+			if total < 2 && add != 0 && del != 0 {
+				total = 2
+			}
+			if add < del {
+				add = scaleLinear(add, graphWidth, maxChange)
+				del = total - add
+			} else {
+				del = scaleLinear(del, graphWidth, maxChange)
+				add = total - del
+			}
+		}
+		line += fmt.Sprintf("%*d", numberWidth, f.added+f.deleted)
+		if f.added+f.deleted != 0 {
+			line += " "
+		}
+		fmt.Fprintln(out, line+out.Paint(color.DiffAdded, strings.Repeat("+", add))+out.Paint(color.DiffRemoved, strings.Repeat("-", del)))
+	}
+
+	summary := fmt.Sprintf(" %d %s changed", len(s.files), plural(len(s.files), "file", "files"))
+	if adds != 0 || dels == 0 {
+		summary += fmt.Sprintf(", %d %s(+)", adds, plural(adds, "insertion", "insertions"))
+	}
+	if dels != 0 || adds == 0 {
+		summary += fmt.Sprintf(", %d %s(-)", dels, plural(dels, "deletion", "deletions"))
+	}
+	fmt.Fprintln(out, summary)
+}
+
+// scaleLinear scales n, out of max, to width columns, keeping at least
+// one column for anything nonzero.
+func scaleLinear(n, width, max int) int {
+	if n == 0 {
+		return 0
+	}
+	return 1 + n*(width-1)/max
+}
+
+// plural returns one if n is 1 and many otherwise.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// runLsFiles handles `rev ls-files [-s] [--others]`. Paths are relative
+// to the top of the working tree.
+func runLsFiles(args []string) error {
+	fs := flag.NewFlagSet("ls-files", flag.ContinueOnError)
+	stage := fs.Bool("s", false, "Show each entry's mode, hash, and stage")
+	others := fs.Bool("others", false, "List untracked files that aren't ignored instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: rev ls-files [-s] [--others]")
+	}
+
+	repo, err := openWorkTree("ls-files")
+	if err != nil {
+		return err
+	}
+	idx, err := index.ReadIndex(repo.GitDir)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	if *others {
+		untracked, err := idx.Untracked(repo.GitDir, repo.Path)
+		if err != nil {
+			return err
+		}
+		for _, p := range untracked {
+			fmt.Fprintln(out, p)
+		}
+		return nil
+	}
+	for _, e := range idx.Entries {
+		if *stage {
+			fmt.Fprintf(out, "%06o %s %d\t%s\n", e.Mode, e.Hash, e.Stage, e.Path)
+		} else {
+			fmt.Fprintln(out, e.Path)
+		}
+	}
+	return nil
+}
+
+// runShow handles `rev show [--color[=<when>]] [<object>]`, printing an
+// object the way suits its type: a commit as in log followed by its
+// diff against the first parent (or the empty tree for a root commit), a
+// tag as its header and message followed by whatever it points to, a
+// tree as a listing of its entries, and a blob as its content. The
+// object defaults to HEAD.
+func runShow(args []string) error {
+	fs := flag.NewFlagSet("show", flag.ContinueOnError)
+	var colorWhen colorFlag
+	fs.Var(&colorWhen, "color", "Color output: `when` is always (the default for a bare --color), never, or auto")
+	rest, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 1 {
+		return fmt.Errorf("usage: rev show [<object>]")
+	}
+	rev := "HEAD"
+	if len(rest) == 1 {
+		rev = rest[0]
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+	cfg, err := repository.ParseConfig(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	out, err := colorWriter(cfg, string(colorWhen))
+	if err != nil {
+		return err
+	}
+
+	textconv, err := diff.LoadTextconv(repo.GitDir, repo.Path)
+	if err != nil {
+		return err
+	}
+
+	hash, err := repository.ResolveRef(repo.GitDir, rev)
+	if err != nil {
+		return err
+	}
+	return showObject(out, diffOptions{textconv: textconv}, repo.GitDir, rev, hash)
+}
+
+// showObject writes the object hash for rev show, with a commit's diff
+// shown as opts says. name is what the user called it, which a tree's
+// header repeats.
+func showObject(out *color.Writer, opts diffOptions, gitDir, name, hash string) error {
+	// Tags can point at tags, so the chain is followed in a loop, with
+	// seen guarding against one that comes back around.
+	seen := make(map[string]bool)
+	for {
+		if seen[hash] {
+			return fmt.Errorf("tag %s: chain loops back on itself", hash)
+		}
+		seen[hash] = true
+
+		obj, err := object.Read(gitDir, hash)
+		if err != nil {
+			return err
+		}
+		switch obj.Type {
+		case object.TypeBlob:
+			_, err := out.Write(obj.Body)
+			return err
+
+		case object.TypeTree:
+			entries, err := object.ReadTree(gitDir, hash)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%s\n\n", out.Paint(color.LogHash, "tree "+name))
+			for _, e := range entries {
+				if e.Mode == object.ModeTree {
+					fmt.Fprintf(out, "%s/\n", e.Name)
+				} else {
+					fmt.Fprintln(out, e.Name)
+				}
+			}
+			return nil
+
+		case object.TypeCommit:
+			commit, err := object.ParseCommit(obj)
+			if err != nil {
+				return err
+			}
+			printCommit(out, gitDir, hash, commit)
+			return showCommitDiff(out, opts, gitDir, commit)
+
+		case object.TypeTag:
+			tag, err := object.ParseTag(obj)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(out, out.Paint(color.LogHash, "tag "+tag.Tag))
+			if tag.Tagger != (object.Signature{}) {
+				fmt.Fprintf(out, "Tagger: %s <%s>\n", tag.Tagger.Name, tag.Tagger.Email)
+				fmt.Fprintf(out, "Date:   %s\n", tag.Tagger.Time().Format("Mon Jan 2 15:04:05 2006 -0700"))
+			}
+			fmt.Fprintf(out, "\n%s%s\n", tag.Message, tag.Sig)
+			name, hash = tag.Object, tag.Object
+
+		default:
+			return fmt.Errorf("object %s has unknown type %s", hash, obj.Type)
+		}
+	}
+}
+
+// showCommitDiff writes the diff between commit's first parent and
+// commit, set off from the message by a blank line if there is one. A
+// merge gets a combined diff against all its parents instead.
+func showCommitDiff(out *color.Writer, opts diffOptions, gitDir string, commit *object.Commit) error {
+	if len(commit.Parents) > 1 {
+		return showCombinedDiff(out, opts, gitDir, commit)
+	}
+	parentTree := ""
+	if len(commit.Parents) > 0 {
+		var err error
+		if parentTree, err = repository.Peel(gitDir, commit.Parents[0], object.TypeTree); err != nil {
+			return err
+		}
+	}
+	changes, err := diff.DiffTrees(gitDir, parentTree, commit.Tree)
+	if err != nil {
+		return err
+	}
+	if len(changes) > 0 {
+		fmt.Fprintln(out)
+	}
+	for _, c := range changes {
+		old := diffSide{path: c.OldPath, mode: c.OldMode, hash: c.OldHash}
+		new := diffSide{path: c.NewPath, mode: c.NewMode, hash: c.NewHash}
+		if err := printChange(out, opts, gitDir, old, new); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// combinedPath is a path a merge changed from every parent, with its
+// mode and blob in each parent and in the merge.
+type combinedPath struct {
+	path    string
+	parents []diffSide
+	result  diffSide
+}
+
+// showCombinedDiff writes the combined diff of a merge commit, as git
+// show's "diff --cc": only the paths that differ from every parent, and
+// in them only the hunks that don't take one parent's version whole.
+func showCombinedDiff(out *color.Writer, opts diffOptions, gitDir string, commit *object.Commit) error {
+	if len(commit.Parents) > diff.MaxParents {
+		return fmt.Errorf("cannot show a combined diff of %d parents", len(commit.Parents))
+	}
+	var paths []*combinedPath
+	for i, parent := range commit.Parents {
+		tree, err := repository.Peel(gitDir, parent, object.TypeTree)
+		if err != nil {
+			return err
+		}
+		changes, err := diff.DiffTrees(gitDir, tree, commit.Tree)
+		if err != nil {
+			return err
+		}
+		changed := make(map[string]diff.Change)
+		for _, c := range changes {
+			changed[c.Path()] = c
+		}
+		// Keep only the paths changed from every parent so far.
+		var kept []*combinedPath
+		for _, p := range paths {
+			if c, ok := changed[p.path]; ok {
+				p.parents = append(p.parents, diffSide{path: p.path, mode: c.OldMode, hash: c.OldHash})
+				kept = append(kept, p)
+			}
+		}
+		if i == 0 {
+			for _, c := range changes {
+				kept = append(kept, &combinedPath{
+					path:    c.Path(),
+					parents: []diffSide{{path: c.Path(), mode: c.OldMode, hash: c.OldHash}},
+					result:  diffSide{path: c.Path(), mode: c.NewMode, hash: c.NewHash},
+				})
+			}
+		}
+		paths = kept
+	}
+
+	for i, p := range paths {
+		if i == 0 {
+			fmt.Fprintln(out)
+		}
+		if err := printCombinedDiff(out, opts, gitDir, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printCombinedDiff writes the combined diff of one path, or nothing if
+// it has no hunks worth showing and its mode is the same in every
+// parent.
+func printCombinedDiff(out *color.Writer, opts diffOptions, gitDir string, p *combinedPath) error {
+	sides := append(slices.Clone(p.parents), p.result)
+	data := make([][]byte, len(sides))
+	binary := false
+	for i, side := range sides {
+		switch side.mode {
+		case 0:
+		case object.ModeGitlink:
+			data[i] = []byte("Subproject commit " + side.hash + "\n")
+		default:
+			obj, err := object.Read(gitDir, side.hash)
+			if err != nil {
+				return err
+			}
+			converted := false
+			if data[i], converted, err = opts.textconv.Convert(p.path, side.hash, obj.Body); err != nil {
+				return err
+			}
+			binary = binary || !converted && diff.IsBinary(data[i])
+		}
+	}
+	var hunks []diff.CombinedHunk
+	if !binary {
+		hunks = opts.algorithm.DiffCombined(data[:len(p.parents)], data[len(p.parents)])
+	}
+	modeDiffers, added := false, p.result.mode != 0
+	for _, parent := range p.parents {
+		modeDiffers = modeDiffers || parent.mode != p.result.mode
+		added = added && parent.mode == 0
+	}
+	if !binary && len(hunks) == 0 && !modeDiffers {
+		return nil
+	}
+
+	meta := func(format string, a ...any) {
+		fmt.Fprintln(out, out.Paint(color.DiffMeta, fmt.Sprintf(format, a...)))
+	}
+	meta("diff --cc %s", p.path)
+	short := func(side diffSide) string {
+		if s := abbrevSide(gitDir, side); s != "" {
+			return s
+		}
+		return strings.Repeat("0", object.MinAbbrev)
+	}
+	var index, modes []string
+	for _, parent := range p.parents {
+		index = append(index, short(parent))
+		modes = append(modes, fmt.Sprintf("%06o", parent.mode))
+	}
+	meta("index %s..%s", strings.Join(index, ","), short(p.result))
+	switch {
+	case !modeDiffers:
+	case added:
+		meta("new file mode %06o", p.result.mode)
+	case p.result.mode == 0:
+		meta("deleted file mode %s", strings.Join(modes, ","))
+	default:
+		meta("mode %s..%06o", strings.Join(modes, ","), p.result.mode)
+	}
+	if binary {
+		fmt.Fprintln(out, "Binary files differ")
+		return nil
+	}
+	oldName, newName := "a/"+p.path, "b/"+p.path
+	if added {
+		oldName = "/dev/null"
+	}
+	if p.result.mode == 0 {
+		newName = "/dev/null"
+	}
+	meta("--- %s", oldName)
+	meta("+++ %s", newName)
+	for _, h := range hunks {
+		fmt.Fprintln(out, out.Paint(color.DiffHunk, h.Header()))
+		for _, l := range h.Lines {
+			text := l.Marks + strings.TrimSuffix(l.Text, "\n")
+			switch {
+			case strings.Contains(l.Marks, "-"):
+				fmt.Fprintln(out, out.Paint(color.DiffRemoved, text))
+			case strings.Contains(l.Marks, "+"):
+				fmt.Fprintln(out, out.Paint(color.DiffAdded, text))
+			default:
+				fmt.Fprintln(out, text)
+			}
+		}
+	}
+	return nil
+}
+
+// openWorkTree opens the current repository for a command that needs a
+// working tree and an index, which means one that isn't bare.
+func openWorkTree(command string) (*repository.Repository, error) {
+	repo, err := repository.Open("")
+	if err != nil {
+		return nil, err
+	}
+	if repo.Path == "" {
+		return nil, fmt.Errorf("%s: this operation must be run in a work tree", command)
+	}
+	return repo, nil
+}
+
+// workTreePath converts a path given on the command line, relative to the
+// current directory, into the slash-separated path relative to the top of
+// the working tree that the index uses ("" for the top itself).
+func workTreePath(repo *repository.Repository, arg string) (string, error) {
+	abs, err := filepath.Abs(arg)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(repo.Path, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: outside repository at %s", arg, repo.Path)
+	}
+	if rel == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// requireSHA1 rejects commands that read or write packs, whose encoding
+// still assumes 20-byte hashes, when the repository uses another object
+// format.
+func requireSHA1(gitDir, command string) error {
+	algo, err := repository.ObjectFormat(gitDir)
+	if err != nil {
+		return err
+	}
+	if algo != object.SHA1 {
+		return fmt.Errorf("%s: not supported for %s repositories yet", command, algo)
+	}
+	return nil
+}
+
+// runRevParse handles `rev rev-parse [--short[=<n>]] <rev>...`. --short
+// prints the shortest unique prefix, at least n or core.abbrev digits.
+func runRevParse(args []string) error {
+	fs := flag.NewFlagSet("rev-parse", flag.ContinueOnError)
+	// -1 until --short is given; a bare --short sets it to 0.
+	short := abbrevFlag(-1)
+	fs.Var(&short, "short", "Abbreviate each hash to at least `n` digits (default core.abbrev)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("rev-parse requires a revision")
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+	abbrevLen := object.SHA256.HexLen()
+	if short >= 0 {
+		cfg, err := repository.ParseConfig(repo.GitDir)
+		if err != nil {
+			return err
+		}
+		if abbrevLen, err = abbrevLength(cfg, short, false); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range fs.Args() {
+		hash, err := repository.ResolveRef(repo.GitDir, name)
+		if err != nil {
+			return err
+		}
+		fmt.Println(abbrevHash(repo.GitDir, hash, abbrevLen))
+	}
+	return nil
+}
+
+// runNameRev handles `rev name-rev [--tags] [--name-only] <commit>...`,
+// printing each commit with a name such as main~2 built from the ref
+// that reaches it most directly, preferring tags, or "undefined" if no
+// ref does. An argument that doesn't resolve is reported and skipped.
+func runNameRev(args []string) error {
+	fs := flag.NewFlagSet("name-rev", flag.ContinueOnError)
+	tagsOnly := fs.Bool("tags", false, "Only use tags to name commits")
+	nameOnly := fs.Bool("name-only", false, "Print only the name, not the commit as given")
+	rest, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return fmt.Errorf("usage: rev name-rev [--tags] [--name-only] <commit>...")
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+	names, err := repo.NameRevs(*tagsOnly)
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range rest {
+		hash, err := repository.ResolveRef(repo.GitDir, arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not get sha1 for %s. Skipping.\n", arg)
+			continue
+		}
+		name, ok := names[hash]
+		if !ok {
+			name = "undefined"
+		} else if *tagsOnly && *nameOnly {
+			name = strings.TrimPrefix(name, "tags/")
+		}
+		if *nameOnly {
+			fmt.Println(name)
+		} else {
+			fmt.Printf("%s %s\n", arg, name)
+		}
+	}
+	return nil
+}
+
+// runBranch handles `rev branch [<name> | -d <name>]`.
+func runBranch(args []string) error {
+	fs := flag.NewFlagSet("branch", flag.ContinueOnError)
+	var del bool
+	fs.BoolVar(&del, "d", false, "Delete the named branch")
+	fs.BoolVar(&del, "D", false, "Delete the named branch (same as -d)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+
+	name := fs.Arg(0)
+	switch {
+	case del:
+		if name == "" {
+			return fmt.Errorf("branch -d requires a branch name")
+		}
+		// Unlike git, -d doesn't yet check the branch is merged.
+		was, err := repository.DeleteBranch(repo.GitDir, name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted branch %s (was %s).\n", name, shortHash(repo.GitDir, was))
+		return nil
+
+	case name != "":
+		head, err := repository.ResolveHead(repo.GitDir)
+		if errors.Is(err, repository.ErrUnbornBranch) {
+			return fmt.Errorf("cannot create branch %s: HEAD has no commits yet", name)
+		}
+		if err != nil {
+			return err
+		}
+		if err := repository.CreateBranch(repo.GitDir, name, head); err != nil {
+			return err
+		}
+		return logRefs(repo.GitDir, "", head, "branch: Created from HEAD", "refs/heads/"+name)
+	}
+
+	refs, err := repo.Refs().List()
+	if err != nil {
+		return err
+	}
+	current, _, err := repository.CurrentBranch(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	for _, r := range refs {
+		branch, ok := strings.CutPrefix(r.Name, "refs/heads/")
+		if !ok {
+			continue
+		}
+		marker := "  "
+		if branch == current {
+			marker = "* "
+		}
+		fmt.Println(marker + branch)
+	}
+	return nil
+}
+
+// runSymbolicRef handles `rev symbolic-ref <name> [<ref>]`.
+func runSymbolicRef(args []string) error {
+	fs := flag.NewFlagSet("symbolic-ref", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return fmt.Errorf("usage: symbolic-ref <name> [<ref>]")
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+
+	name := fs.Arg(0)
+	if fs.NArg() == 2 {
+		return repository.WriteSymbolicRef(repo.GitDir, name, fs.Arg(1))
+	}
+
+	target, ok, err := repository.ReadSymbolicRef(repo.GitDir, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("ref %s is not a symbolic ref", name)
+	}
+	fmt.Println(target)
+	return nil
+}
+
+// runLog handles `rev log [-n <count>] [--oneline] [--raw]
+// [--abbrev[=<n>] | --no-abbrev] [--color[=<when>]] [<commit>]`. --raw
+// follows each commit with the files it changed from its first parent
+// in diff-tree's raw format; merges show none.
+func runLog(args []string) error {
+	fs := flag.NewFlagSet("log", flag.ContinueOnError)
+	limit := fs.Int("n", -1, "Show at most this many commits")
+	oneline := fs.Bool("oneline", false, "Show each commit as \"<short-hash> <subject>\"")
+	raw := fs.Bool("raw", false, "Follow each commit with the files it changed, as raw diff records")
+	var abbrev abbrevFlag
+	fs.Var(&abbrev, "abbrev", "Abbreviate --oneline and --raw hashes to at least `n` digits (default core.abbrev)")
+	noAbbrev := fs.Bool("no-abbrev", false, "Show full hashes with --oneline and --raw")
+	var colorWhen colorFlag
+	fs.Var(&colorWhen, "color", "Color output: `when` is always (the default for a bare --color), never, or auto")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := repository.Open("")
+	if err != nil {
+		return err
+	}
+
+	cfg, err := repository.ParseConfig(repo.GitDir)
+	if err != nil {
+		return err
+	}
+	out, err := colorWriter(cfg, string(colorWhen))
+	if err != nil {
+		return err
+	}
+	abbrevLen, err := abbrevLength(cfg, abbrev, *noAbbrev)
+	if err != nil {
+		return err
+	}
+
+	if *limit == 0 {
+		return nil
+	}
+	n := 0
+	for commit, err := range repo.Log(fs.Arg(0), repository.LogOptions{Limit: *limit}) {
+		if errors.Is(err, repository.ErrUnbornBranch) && fs.Arg(0) == "" {
+			branch, _, _ := repository.CurrentBranch(repo.GitDir)
+			return fmt.Errorf("your current branch '%s' does not have any commits yet", branch)
+		}
+		if err != nil {
+			return err
+		}
+
+		if *oneline {
+			short := abbrevHash(repo.GitDir, commit.Hash, abbrevLen)
+			fmt.Fprintf(out, "%s %s\n", out.Paint(color.LogHash, short), object.Subject(commit.Message))
+		} else {
+			if n > 0 {
+				fmt.Fprintln(out)
+			}
+			printCommit(out, repo.GitDir, commit.Hash, commit)
+		}
+		if *raw && len(commit.Parents) < 2 {
+			if err := printRaw(out, repo.GitDir, commit, abbrevLen, !*oneline); err != nil {
+				return err
+			}
+		}
+		n++
+	}
+	return nil
+}
+
+// printRaw writes the files commit changed from its parent, or that it
+// added if it is a root commit, as ":<old mode> <new mode> <old hash>
+// <new hash> <status>\t<path>" records, with hashes abbreviated to at
+// least abbrevLen digits. A separated listing is set off from the commit
+// message by a blank line, if there is anything to list.
+func printRaw(out io.Writer, gitDir string, commit *object.Commit, abbrevLen int, separated bool) error {
+	var parentTree string
+	if len(commit.Parents) > 0 {
+		tree, err := repository.Peel(gitDir, commit.Parents[0], object.TypeTree)
+		if err != nil {
+			return err
+		}
+		parentTree = tree
+	}
+	changes, err := diff.DiffTrees(gitDir, parentTree, commit.Tree)
+	if err != nil {
+		return err
+	}
+	if separated && len(changes) > 0 {
+		fmt.Fprintln(out)
+	}
+	for _, c := range changes {
+		oldHash, newHash := "", ""
+		if c.OldHash != "" {
+			oldHash = abbrevHash(gitDir, c.OldHash, abbrevLen)
+		}
+		if c.NewHash != "" {
+			newHash = abbrevHash(gitDir, c.NewHash, abbrevLen)
+		}
+		if oldHash == "" {
+			oldHash = strings.Repeat("0", len(newHash))
+		}
+		if newHash == "" {
+			newHash = strings.Repeat("0", len(oldHash))
+		}
+		fmt.Fprintf(out, ":%06o %06o %s %s %s\t%s\n", c.OldMode, c.NewMode, oldHash, newHash, c.Kind.Status(), c.Path())
+	}
+	return nil
+}
+
+// printCommit writes a commit in git log's default (medium) format.
+func printCommit(out *color.Writer, gitDir, hash string, c *object.Commit) {
+	fmt.Fprintln(out, out.Paint(color.LogHash, "commit "+hash))
+	if len(c.Parents) > 1 {
+		short := make([]string, len(c.Parents))
+		for i, p := range c.Parents {
+			short[i] = shortHash(gitDir, p)
+		}
+		fmt.Fprintf(out, "Merge: %s\n", strings.Join(short, " "))
+	}
+	fmt.Fprintf(out, "Author: %s <%s>\n", c.Author.Name, c.Author.Email)
+	fmt.Fprintf(out, "Date:   %s\n\n", c.Author.Time().Format("Mon Jan 2 15:04:05 2006 -0700"))
+	for _, line := range strings.Split(strings.TrimRight(c.Message, "\n"), "\n") {
+		fmt.Fprintf(out, "    %s\n", line)
+	}
+}
+
+// shortHash abbreviates hash to its shortest unique prefix, falling back
+// to the minimum length if the object can't be found (e.g. a missing
+// parent).
+func shortHash(gitDir, hash string) string {
+	short, err := object.AbbrevHash(gitDir, hash)
+	if err != nil {
+		return hash[:object.MinAbbrev]
+	}
+	return short
+}
+
+// abbrevFlag is --abbrev[=<n>], the least number of hex digits to
+// abbreviate hashes to. Zero, as for a bare --abbrev, defers to
+// core.abbrev.
+type abbrevFlag int
+
+func (f *abbrevFlag) String() string { return strconv.Itoa(int(*f)) }
+
+func (f *abbrevFlag) IsBoolFlag() bool { return true }
+
+// Set raises lengths below object.ShortestAbbrev to it, as git does.
+func (f *abbrevFlag) Set(v string) error {
+	if v == "true" {
+		*f = 0
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid abbreviation length %q", v)
+	}
+	*f = abbrevFlag(max(n, object.ShortestAbbrev))
+	return nil
+}
+
+// abbrevLength returns the length to abbreviate hashes to: all of it
+// with --no-abbrev (full), else the --abbrev length, else core.abbrev,
+// which may be "auto" for the default or "no" for full hashes.
+func abbrevLength(cfg *repository.Config, flagValue abbrevFlag, full bool) (int, error) {
+	switch {
+	case full:
+		return object.SHA256.HexLen(), nil
+	case flagValue != 0:
+		return int(flagValue), nil
+	}
+	switch v, _ := cfg.Get("core", "abbrev"); strings.ToLower(v) {
+	case "", "auto":
+		return object.MinAbbrev, nil
+	case "no":
+		return object.SHA256.HexLen(), nil
+	}
+	n, _, err := cfg.GetInt("core", "abbrev")
+	if err != nil {
+		return 0, err
+	}
+	if n < object.ShortestAbbrev || n > int64(object.SHA256.HexLen()) {
+		return 0, fmt.Errorf("abbrev length out of range: %d", n)
+	}
+	return int(n), nil
+}
+
+// abbrevHash is shortHash with a minimum length of n, as returned by
+// abbrevLength.
+func abbrevHash(gitDir, hash string, n int) string {
+	short, err := object.AbbrevHashMin(gitDir, hash, n)
+	if err != nil {
+		return hash[:min(max(n, object.ShortestAbbrev), len(hash))]
+	}
+	return short
+}
+
+// colorFlag is --color[=<when>]. As in git, a bare --color means always;
+// the value itself is checked by colorWriter.
+type colorFlag string
+
+func (f *colorFlag) String() string { return string(*f) }
+
+func (f *colorFlag) IsBoolFlag() bool { return true }
+
+func (f *colorFlag) Set(v string) error {
+	if v == "true" {
+		v = "always"
+	}
+	*f = colorFlag(v)
+	return nil
+}
+
+// colorWriter returns a color.Writer for stdout. An explicit --color value
+// wins; otherwise color.ui from the config applies, defaulting to auto.
+func colorWriter(cfg *repository.Config, flagValue string) (*color.Writer, error) {
+	when := flagValue
+	if when == "" {
+		when, _ = cfg.Get("color", "ui")
+	}
+	mode, err := color.ParseMode(when)
+	if err != nil {
+		return nil, err
+	}
+	return color.NewWriter(os.Stdout, mode), nil
+}
+
+// expectType resolves rev and checks that it names an object of type
+// want, returning the full hash.
+func expectType(gitDir, rev string, want object.Type) (string, error) {
+	hash, err := repository.ResolveRef(gitDir, rev)
+	if err != nil {
+		return "", err
+	}
+	objType, _, err := object.ReadHeader(gitDir, hash)
+	if err != nil {
+		return "", err
+	}
+	if objType != want {
+		return "", fmt.Errorf("%s is a %s, not a %s", rev, objType, want)
+	}
+	return hash, nil
+}
+
+// identity returns the signature for role ("AUTHOR" or "COMMITTER"),
+// stamped with the current time. As in git, GIT_<role>_NAME and
+// GIT_<role>_EMAIL override user.name and user.email from the config.
+func identity(cfg *repository.Config, role string) (object.Signature, error) {
+	name, _ := cfg.Get("user", "name")
+	email, _ := cfg.Get("user", "email")
+	if v := os.Getenv("GIT_" + role + "_NAME"); v != "" {
+		name = v
+	}
+	if v := os.Getenv("GIT_" + role + "_EMAIL"); v != "" {
+		email = v
+	}
+	if name == "" || email == "" {
+		return object.Signature{}, fmt.Errorf("%s identity unknown: set user.name and user.email in the config",
+			strings.ToLower(role))
+	}
+	return signatureNow(name, email), nil
+}
+
+// parseIdent parses an identity given as "Name <email>", as for
+// commit --author, into a signature stamped with the current time.
+func parseIdent(s string) (object.Signature, error) {
+	lt := strings.IndexByte(s, '<')
+	gt := strings.LastIndexByte(s, '>')
+	if lt < 0 || gt < lt || strings.TrimSpace(s[gt+1:]) != "" {
+		return object.Signature{}, fmt.Errorf("'%s' is not 'Name <email>'", s)
+	}
+	name, email := strings.TrimSpace(s[:lt]), s[lt+1:gt]
+	if name == "" {
+		return object.Signature{}, fmt.Errorf("empty ident name (for <%s>) not allowed", email)
+	}
+	return signatureNow(name, email), nil
+}
+
+// signatureNow returns a signature for name and email stamped with the
+// current time.
+func signatureNow(name, email string) object.Signature {
+	now := time.Now()
+	return object.Signature{
+		Name:     name,
+		Email:    email,
+		When:     now.Unix(),
+		Timezone: now.Format("-0700"),
+	}
+}
+
+// parseInterspersed parses args with fs, allowing flags to follow
+// positional arguments as git's commands do, and returns the positional
+// arguments. Everything after "--" is positional.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if n := len(args) - fs.NArg(); n > 0 && args[n-1] == "--" {
+			return append(positional, fs.Args()...), nil
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// attachRenameScore rewrites -M<n> in args as -M=<n>: -M takes its value
+// attached, as in -M60%, which flag would read as a flag named "M60%".
+// Only arguments parseInterspersed will take as flags are rewritten, so
+// not those after "--" or the value of a flag that takes the next
+// argument.
+func attachRenameScore(fs *flag.FlagSet, args []string) []string {
+	args = slices.Clone(args)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if v, ok := strings.CutPrefix(arg, "-M"); ok && v != "" && v[0] != '=' {
+			args[i] = "-M=" + v
+			continue
+		}
+		if takesNextArg(fs, arg) {
+			i++
+		}
+	}
+	return args
+}
+
+// takesNextArg reports whether arg is a flag of fs whose value, since it
+// isn't attached with "=", is the argument after it.
+func takesNextArg(fs *flag.FlagSet, arg string) bool {
+	name, ok := strings.CutPrefix(arg, "-")
+	if !ok {
+		return false
+	}
+	name, _ = strings.CutPrefix(name, "-")
+	if strings.Contains(name, "=") {
+		return false
+	}
+	f := fs.Lookup(name)
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable
+// string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
 	return nil
 }
 
@@ -164,6 +4374,39 @@ func printUsage() {
 	fmt.Printf("usage: %s <command> [<args>]\n\n", os.Args[0])
 	fmt.Println("Commands:")
 	fmt.Println("  init           Initialize a new repository")
-	fmt.Println("  hash-object    Compute object ID and optionally write a blob")
+	fmt.Println("  hash-object    Compute object ID and optionally write an object")
 	fmt.Println("  cat-file       Display object type, size, or content")
+	fmt.Println("  show-ref       List references and the objects they point to")
+	fmt.Println("  serve          Serve the repository over HTTP")
+	fmt.Println("  interpret-trailers  Add or update trailers in a commit message")
+	fmt.Println("  prune-packed   Remove loose objects that are already packed")
+	fmt.Println("  repack         Pack loose objects, or with -a consolidate all packs")
+	fmt.Println("  gc             Repack everything into one delta-compressed pack, or with --auto only if needed")
+	fmt.Println("  ls-tree        List the contents of a tree object")
+	fmt.Println("  commit-tree    Create a commit object from a tree")
+	fmt.Println("  write-tree     Write the index, or a directory snapshot, as a tree object")
+	fmt.Println("  config         Get, set, or unset repository config variables")
+	fmt.Println("  rev-parse      Resolve revision names to object hashes")
+	fmt.Println("  name-rev       Name commits after the refs they can be reached from")
+	fmt.Println("  branch         List, create, or delete branches")
+	fmt.Println("  symbolic-ref   Read or change a symbolic ref such as HEAD")
+	fmt.Println("  log            Show commit history")
+	fmt.Println("  fsck           Verify loose objects against their hashes")
+	fmt.Println("  verify-tag     Check the signature of annotated tags")
+	fmt.Println("  count-objects  Count loose objects and their disk usage")
+	fmt.Println("  pack-objects   Write objects named on stdin into a packfile")
+	fmt.Println("  unpack-objects Write the objects of a packfile on stdin as loose objects")
+	fmt.Println("  fast-import    Build objects and refs from a fast-import stream on stdin")
+	fmt.Println("  fast-export    Write history as a fast-import stream")
+	fmt.Println("  add            Stage file contents in the index")
+	fmt.Println("  rm             Remove files from the index and the working tree")
+	fmt.Println("  status         Show staged, unstaged, and untracked changes")
+	fmt.Println("  commit         Record the staged changes as a new commit")
+	fmt.Println("  read-tree      Replace the index with the contents of a tree")
+	fmt.Println("  checkout       Switch the working tree to a branch or commit")
+	fmt.Println("  switch         Switch to a branch, optionally creating it")
+	fmt.Println("  restore        Restore files in the working tree or index from a tree")
+	fmt.Println("  diff           Show unstaged or staged changes, or compare two revisions or paths")
+	fmt.Println("  ls-files       List tracked files, or untracked ones with --others")
+	fmt.Println("  show           Show a commit with its diff, a tag, a tree, or a blob")
 }
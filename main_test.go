@@ -0,0 +1,1354 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/elliota43/rev/internal/index"
+	"github.com/elliota43/rev/internal/object"
+	"github.com/elliota43/rev/internal/repository"
+)
+
+// testRepo initializes a repository in a temporary directory, makes it
+// the working directory for the rest of the test, and sets an identity
+// for commits. It returns the repository root.
+func testRepo(t testing.TB) string {
+	t.Helper()
+	dir := t.TempDir()
+	if _, err := repository.Init(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	for _, role := range []string{"AUTHOR", "COMMITTER"} {
+		t.Setenv("GIT_"+role+"_NAME", "A U Thor")
+		t.Setenv("GIT_"+role+"_EMAIL", "author@example.com")
+	}
+	return dir
+}
+
+// writeFile writes content to name, relative to the working directory,
+// creating parent directories.
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// capture calls a command's run function and returns what it printed to
+// stdout.
+func capture(run func([]string) error, args ...string) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	printed := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		printed <- data
+	}()
+
+	err = run(args)
+	w.Close()
+	os.Stdout = stdout
+	return string(<-printed), err
+}
+
+// withStdin makes data the process's standard input for the rest of the
+// test.
+func withStdin(t *testing.T, data string) {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(name, []byte(data), 0666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		f.Close()
+	})
+}
+
+// mustRun is capture for a command that must succeed.
+func mustRun(t *testing.T, run func([]string) error, args ...string) string {
+	t.Helper()
+	out, err := capture(run, args...)
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return out
+}
+
+// commitFiles writes files, stages them, and commits them with message.
+func commitFiles(t *testing.T, message string, files map[string]string) {
+	t.Helper()
+	var paths []string
+	for name, content := range files {
+		writeFile(t, name, content)
+		paths = append(paths, name)
+	}
+	mustRun(t, runAdd, paths...)
+	mustRun(t, runCommit, "-m", message)
+}
+
+func TestColorFlag(t *testing.T) {
+	testRepo(t)
+	commitFiles(t, "first", map[string]string{"a": "a\n"})
+	// Something for each command to color: status and diff show the
+	// modified file, log and show the commit.
+	writeFile(t, "a", "changed\n")
+
+	commands := map[string]func([]string) error{
+		"log": runLog, "status": runStatus, "diff": runDiff, "show": runShow,
+	}
+	tests := []struct {
+		args  []string
+		color bool
+	}{
+		{nil, false}, // auto, and stdout isn't a terminal
+		{[]string{"--color"}, true},
+		{[]string{"--color=always"}, true},
+		{[]string{"--color=never"}, false},
+		{[]string{"--color=auto"}, false},
+	}
+	for name, run := range commands {
+		for _, tt := range tests {
+			out, err := capture(run, tt.args...)
+			if err != nil {
+				t.Errorf("%s %v: %v", name, tt.args, err)
+				continue
+			}
+			if got := strings.Contains(out, "\x1b["); got != tt.color {
+				t.Errorf("%s %v: colored = %v, want %v\n%q", name, tt.args, got, tt.color, out)
+			}
+		}
+	}
+
+	if _, err := capture(runLog, "--color=sometimes"); err == nil {
+		t.Error("log --color=sometimes: want error")
+	}
+}
+
+// writeLargeBlob stores a blob of size bytes of repeated text in the
+// repository in the working directory and returns its hash and content.
+func writeLargeBlob(tb testing.TB, size int) (string, []byte) {
+	tb.Helper()
+	content := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	sha, err := object.WriteObject(".git", object.TypeBlob, content)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return sha, content
+}
+
+// catFileTo runs cat-file with stdout sent to w rather than a pipe, so
+// large output isn't held in memory by the test itself.
+func catFileTo(w *os.File, args ...string) error {
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	return runCatFile(args)
+}
+
+// cat-file -p copies a blob from a single inflating stream, so its
+// memory use stays flat however large the blob is.
+func TestCatFile_LargeBlob(t *testing.T) {
+	testRepo(t)
+	sha, content := writeLargeBlob(t, 32<<20)
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err = catFileTo(out, "-p", sha)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 4<<20 {
+		t.Errorf("cat-file -p allocated %d bytes for a %d-byte blob", alloc, len(content))
+	}
+	got, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("cat-file -p printed %d bytes, want the %d-byte blob", len(got), len(content))
+	}
+}
+
+func BenchmarkCatFile_100MB(b *testing.B) {
+	testRepo(b)
+	sha, content := writeLargeBlob(b, 100<<20)
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := catFileTo(devNull, "-p", sha); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestHashObject_Literally(t *testing.T) {
+	testRepo(t)
+	writeFile(t, "junk", "junk\n")
+
+	// The normal path refuses what --literally lets through; the hashes
+	// are git's for the same input.
+	tests := []struct {
+		objType, want string
+	}{
+		{"commit", "dcb5b01a8b7d61d4c5b6853b6322adfc27118b42"},
+		{"widget", "d4daf8e564cd79af8645d5699c0cdf4ef70e7200"},
+	}
+	for _, tt := range tests {
+		if _, err := capture(runHashObject, "-t", tt.objType, "-w", "junk"); err == nil {
+			t.Errorf("hash-object -t %s of a malformed body: want error", tt.objType)
+		}
+		out := mustRun(t, runHashObject, "--literally", "-t", tt.objType, "-w", "junk")
+		if got := strings.TrimSpace(out); got != tt.want {
+			t.Errorf("hash-object --literally -t %s = %s, want %s", tt.objType, got, tt.want)
+		}
+		obj, err := object.ReadUnchecked(".git", tt.want)
+		if err != nil || string(obj.Type) != tt.objType || string(obj.Body) != "junk\n" {
+			t.Errorf("stored %s object: got %+v, %v", tt.objType, obj, err)
+		}
+	}
+
+	if _, err := capture(runHashObject, "--literally", "-t", "two words", "junk"); err == nil {
+		t.Error("hash-object --literally with a space in the type: want error")
+	}
+}
+
+func TestLsTree(t *testing.T) {
+	testRepo(t)
+	commitFiles(t, "nested", map[string]string{
+		"top": "1\n", "a/f": "2\n", "a/b/g": "3\n", "c/h": "4\n",
+	})
+	tree, err := repository.HeadTree(".git")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		flags []string
+		want  string
+	}{
+		{nil, "a c top"},
+		{[]string{"-d"}, "a c"},
+		{[]string{"-t"}, "a c top"},
+		{[]string{"-r"}, "a/b/g a/f c/h top"},
+		{[]string{"-r", "-t"}, "a a/b a/b/g a/f c c/h top"},
+		{[]string{"-r", "-d"}, "a a/b c"},
+		{[]string{"-r", "-d", "-t"}, "a a/b c"},
+	}
+	for _, tt := range tests {
+		args := append(append([]string{"--name-only"}, tt.flags...), tree)
+		out := mustRun(t, runLsTree, args...)
+		if got := strings.Join(strings.Fields(out), " "); got != tt.want {
+			t.Errorf("ls-tree %v = %q, want %q", args, got, tt.want)
+		}
+	}
+
+	// Without --name-only, entries print in full.
+	entries, err := object.ReadTree(".git", tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := entries[0].String() + "\n" + entries[1].String() + "\n"
+	if got := mustRun(t, runLsTree, tree, "-d"); got != want {
+		t.Errorf("ls-tree -d:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+// batchRun feeds input to catFileBatch with the given format and
+// returns its output.
+func batchRun(t *testing.T, format, input string, opts batchOptions) string {
+	t.Helper()
+	var err error
+	if opts.format, err = parseBatchFormat(format); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := catFileBatch(".git", strings.NewReader(input), &out, opts); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestCatFileBatch_Format(t *testing.T) {
+	testRepo(t)
+	blob, err := object.WriteObject(".git", object.TypeBlob, []byte("hi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format, input, want string
+	}{
+		{defaultBatchFormat, blob + "\n", blob + " blob 3\n"},
+		// With %(rest), the name ends at the first space or tab.
+		{"%(objectname) [%(rest)]", blob + "  two words\n", blob + " [two words]\n"},
+		{"%(rest)|%(objectsize)", blob + "\tpath/to/file\n", "path/to/file|3\n"},
+		{"[%(rest)]", blob + "\n", "[]\n"},
+		{"%(objecttype) 100%% %x", blob + "\n", "blob 100% %x\n"},
+		{"%(rest)", "nope some text\n", "nope missing\n"},
+		// Without it, the whole line is the name.
+		{defaultBatchFormat, blob + " trailing\n", blob + " trailing missing\n"},
+	}
+	for _, tt := range tests {
+		if got := batchRun(t, tt.format, tt.input, batchOptions{}); got != tt.want {
+			t.Errorf("--batch-check=%q on %q:\ngot  %q\nwant %q", tt.format, tt.input, got, tt.want)
+		}
+	}
+
+	got := batchRun(t, "%(objectsize) %(rest)", blob+" x\n", batchOptions{contents: true})
+	if want := "3 x\nhi\n\n"; got != want {
+		t.Errorf("--batch with format: got %q, want %q", got, want)
+	}
+
+	if _, err := parseBatchFormat("%(bogus)"); err == nil || !strings.Contains(err.Error(), "bogus") {
+		t.Errorf("unknown atom: got %v", err)
+	}
+}
+
+// writeLog records the size of each write it receives.
+type writeLog []int
+
+func (l *writeLog) Write(p []byte) (int, error) {
+	*l = append(*l, len(p))
+	return len(p), nil
+}
+
+func TestCatFileBatch_Buffer(t *testing.T) {
+	testRepo(t)
+	blob, err := object.WriteObject(".git", object.TypeBlob, []byte("hi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	format, err := parseBatchFormat(defaultBatchFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := blob + "\nnope\n" + blob + "\n"
+
+	for _, buffer := range []bool{false, true} {
+		var writes writeLog
+		opts := batchOptions{format: format, buffer: buffer}
+		if err := catFileBatch(".git", strings.NewReader(input), &writes, opts); err != nil {
+			t.Fatal(err)
+		}
+		// Unbuffered, each of the three records is flushed on its own;
+		// buffered, they go out together at the end of input.
+		want := 3
+		if buffer {
+			want = 1
+		}
+		if len(writes) != want {
+			t.Errorf("buffer=%v: %d writes %v, want %d", buffer, len(writes), writes, want)
+		}
+	}
+}
+
+func TestCatFileBatch_Commands(t *testing.T) {
+	testRepo(t)
+	blob, err := object.WriteObject(".git", object.TypeBlob, []byte("hi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "info " + blob + "\ncontents " + blob + "\ninfo nope\n"
+	want := blob + " blob 3\n" + blob + " blob 3\nhi\n\nnope missing\n"
+	if got := batchRun(t, defaultBatchFormat, input, batchOptions{commands: true}); got != want {
+		t.Errorf("batch-command:\ngot  %q\nwant %q", got, want)
+	}
+
+	// Buffered, output goes out at each flush and at the end of input.
+	format, _ := parseBatchFormat(defaultBatchFormat)
+	var writes writeLog
+	opts := batchOptions{commands: true, buffer: true, format: format}
+	input = "info " + blob + "\ninfo " + blob + "\nflush\ninfo " + blob + "\n"
+	if err := catFileBatch(".git", strings.NewReader(input), &writes, opts); err != nil {
+		t.Fatal(err)
+	}
+	record := len(blob + " blob 3\n")
+	if len(writes) != 2 || writes[0] != 2*record || writes[1] != record {
+		t.Errorf("buffered writes = %v, want [%d %d]", writes, 2*record, record)
+	}
+
+	for input, wantErr := range map[string]string{
+		"flush\n":       "only for --buffer",
+		"info\n":        "requires arguments",
+		"\n":            "empty command",
+		"bogus " + blob: "unknown command",
+	} {
+		opts := batchOptions{commands: true, format: format}
+		err := catFileBatch(".git", strings.NewReader(input), io.Discard, opts)
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%q: got %v, want %q", input, err, wantErr)
+		}
+	}
+}
+
+func TestDiffNoIndex(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFile(t, "f1", "a\nb\nc\n")
+	writeFile(t, "f2", "a\nB\nc\n")
+	writeFile(t, "x/same", "same\n")
+	writeFile(t, "y/same", "same\n")
+	writeFile(t, "x/sub/m", "old\n")
+	writeFile(t, "y/sub/m", "new\n")
+	writeFile(t, "x/del", "gone\n")
+	writeFile(t, "y/add", "added\n")
+	if err := os.Chmod("y/add", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expected output is from git diff --no-index.
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"f1", "f2", "diff --git a/f1 b/f2\nindex de98044..7be73ce 100644\n--- a/f1\n+++ b/f2\n" +
+			"@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"x", "y", "diff --git a/y/add b/y/add\nnew file mode 100755\nindex 0000000..d5f7fc3\n" +
+			"--- /dev/null\n+++ b/y/add\n@@ -0,0 +1 @@\n+added\n" +
+			"diff --git a/x/del b/x/del\ndeleted file mode 100644\nindex 286c5f5..0000000\n" +
+			"--- a/x/del\n+++ /dev/null\n@@ -1 +0,0 @@\n-gone\n" +
+			"diff --git a/x/sub/m b/y/sub/m\nindex 3367afd..3e75765 100644\n" +
+			"--- a/x/sub/m\n+++ b/y/sub/m\n@@ -1 +1 @@\n-old\n+new\n"},
+	}
+	for _, tt := range tests {
+		out, err := capture(runDiff, "--no-index", tt.a, tt.b)
+		if code, ok := err.(exitCode); !ok || code != 1 {
+			t.Errorf("diff --no-index %s %s: err = %v, want exit status 1", tt.a, tt.b, err)
+		}
+		if out != tt.want {
+			t.Errorf("diff --no-index %s %s:\ngot  %q\nwant %q", tt.a, tt.b, out, tt.want)
+		}
+	}
+
+	if out := mustRun(t, runDiff, "--no-index", "x/same", "y/same"); out != "" {
+		t.Errorf("diff --no-index of identical files printed %q", out)
+	}
+	if _, err := capture(runDiff, "--no-index", "x", "f1"); err == nil || !strings.Contains(err.Error(), "x/f1") {
+		t.Errorf("diff --no-index x f1: err = %v, want x/f1 not accessible", err)
+	}
+}
+
+func TestDiffNoIndex_DashDash(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFile(t, "-Ma", "a\n")
+	writeFile(t, "-Mb", "b\n")
+
+	// Paths after "--" are taken as they are, not as -M with a score.
+	out, err := capture(runDiff, "--no-index", "--", "-Ma", "-Mb")
+	if code, ok := err.(exitCode); !ok || code != 1 {
+		t.Fatalf("err = %v, want exit status 1", err)
+	}
+	if want := "diff --git a/-Ma b/-Mb\n"; !strings.HasPrefix(out, want) {
+		t.Errorf("got %q, want it to start %q", out, want)
+	}
+}
+
+func TestDiffWordDiff(t *testing.T) {
+	testRepo(t)
+	commitFiles(t, "first", map[string]string{
+		"a": "hello world foo\nsecond line here\nthird\nkeep\n",
+		"p": "x = f(a,b)\n",
+	})
+	writeFile(t, "a", "hello there foo bar\nsecond  line\nthird\nkeep\nnew line\n")
+
+	// Expected output is from git diff --word-diff.
+	body := func(out string) string {
+		_, body, _ := strings.Cut(out, "@@ -1,4 +1,5 @@")
+		return body
+	}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--word-diff"}, "\nhello [-world-]{+there+} foo {+bar+}\nsecond  line[-here-]\nthird\nkeep\n{+new line+}\n"},
+		{[]string{"--word-diff=porcelain"}, "\n hello \n-world\n+there\n  foo \n+bar\n~\n second  line\n-here\n~\n third\n~\n keep\n~\n+new line\n~\n"},
+		{[]string{"--color-words"}, "\x1b[m\nhello \x1b[31mworld\x1b[m\x1b[32mthere\x1b[m foo \x1b[32mbar\x1b[m\n" +
+			"second  line\x1b[31mhere\x1b[m\nthird\nkeep\n\x1b[32mnew line\x1b[m\n"},
+		{[]string{"--word-diff-regex=."}, "\nhello [-wo-]{+the+}r[-ld-]{+e+} foo{+ bar+}\nsecond {+ +}line[- here-]\nthird\nkeep\n{+new line+}\n"},
+	}
+	for _, tt := range tests {
+		if got := body(mustRun(t, runDiff, tt.args...)); got != tt.want {
+			t.Errorf("diff %s:\ngot  %q\nwant %q", strings.Join(tt.args, " "), got, tt.want)
+		}
+	}
+	if out := mustRun(t, runDiff, "--word-diff=none"); !strings.Contains(out, "\n-hello world foo\n") {
+		t.Errorf("--word-diff=none didn't give a line diff:\n%s", out)
+	}
+
+	commitFiles(t, "second", map[string]string{"a": "unchanged\n"})
+	writeFile(t, "p", "x = f(a,c)\n")
+	mustRun(t, runConfig, "diff.wordRegex", "[^[:space:],()]+")
+	if out := mustRun(t, runDiff, "--word-diff"); !strings.HasSuffix(out, "\nx = f(a,[-b-]{+c+})\n") {
+		t.Errorf("diff.wordRegex wasn't used:\n%s", out)
+	}
+	if _, err := capture(runDiff, "--word-diff=bogus"); err == nil {
+		t.Error("--word-diff=bogus succeeded")
+	}
+}
+
+func TestDiffAlgorithm(t *testing.T) {
+	testRepo(t)
+	commitFiles(t, "first", map[string]string{
+		"f.c": "int b()\n{\n    b_body();\n}\n\nint c()\n{\n    c_body();\n}\n",
+	})
+	writeFile(t, "f.c", "int z()\n{\n    z_body();\n}\n\nint b()\n{\n    b_body();\n}\n")
+
+	// Myers pairs up the braces; patience keeps b() whole.
+	myers, unique := "\n-int b()\n+int z()\n", "\n+int z()\n+{\n"
+	tests := []struct {
+		config string
+		args   []string
+		want   string
+	}{
+		{"", nil, myers},
+		{"", []string{"--patience"}, unique},
+		{"", []string{"--histogram"}, unique},
+		{"", []string{"--diff-algorithm=Patience"}, unique},
+		{"", []string{"--patience", "--minimal"}, myers},
+		{"histogram", nil, unique},
+		{"histogram", []string{"--diff-algorithm=myers"}, myers},
+	}
+	for _, tt := range tests {
+		if tt.config != "" {
+			mustRun(t, runConfig, "diff.algorithm", tt.config)
+		}
+		if out := mustRun(t, runDiff, tt.args...); !strings.Contains(out, tt.want) {
+			t.Errorf("diff %s with diff.algorithm=%q:\n%s", strings.Join(tt.args, " "), tt.config, out)
+		}
+	}
+	if _, err := capture(runDiff, "--diff-algorithm=fast"); err == nil {
+		t.Error("--diff-algorithm=fast succeeded")
+	}
+	mustRun(t, runConfig, "diff.algorithm", "fast")
+	if _, err := capture(runDiff); err == nil {
+		t.Error("diff.algorithm=fast was accepted")
+	}
+}
+
+func TestDiffStat(t *testing.T) {
+	testRepo(t)
+	t.Setenv("COLUMNS", "")
+	lines := func(from, to int) string {
+		var b strings.Builder
+		for i := from; i <= to; i++ {
+			fmt.Fprintf(&b, "%d\n", i)
+		}
+		return b.String()
+	}
+	long := "a_long_directory_name_that_goes_on/and_a_subdirectory/with_a_file_at_the_end.txt"
+	commitFiles(t, "first", map[string]string{
+		"big": lines(1, 200), "small": "a\n", "dir/sub/old": "x\n", "bin": "\x00\x01\x02", long: lines(1, 50),
+	})
+	first := headCommit(t).Hash
+	mustRun(t, runRm, "dir/sub/old")
+	commitFiles(t, "second", map[string]string{
+		"big": lines(1, 100) + strings.Repeat("x\n", 11), "small": "b\n", "bin": "\x00\x01\x02\x03",
+		long: lines(2, 60), "dir/sub/new": "x\n",
+	})
+	second := headCommit(t).Hash
+
+	// Expected output is from git diff --stat.
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--stat", "-M"}, "" +
+			" .../and_a_subdirectory/with_a_file_at_the_end.txt  |  11 +-\n" +
+			" big                                                | 111 ++-------------------\n" +
+			" bin                                                | Bin 3 -> 4 bytes\n" +
+			" dir/sub/{old => new}                               |   0\n" +
+			" small                                              |   2 +-\n" +
+			" 5 files changed, 22 insertions(+), 102 deletions(-)\n"},
+		{[]string{"--stat=50,10", "-M"}, "" +
+			" ...end.txt |  11 ++-\n" +
+			" big        | 111 +++----------------------------\n" +
+			" bin        | Bin 3 -> 4 bytes\n" +
+			" ...=> new} |   0\n" +
+			" small      |   2 +-\n" +
+			" 5 files changed, 22 insertions(+), 102 deletions(-)\n"},
+		{[]string{"--stat=40"}, "" +
+			" ..._a_file_at_the_end.txt |  11 +-\n" +
+			" big                       | 111 +-----\n" +
+			" bin                       | Bin 3 -> 4 bytes\n" +
+			" dir/sub/new               |   1 +\n" +
+			" dir/sub/old               |   1 -\n" +
+			" small                     |   2 +-\n" +
+			" 6 files changed, 23 insertions(+), 103 deletions(-)\n"},
+	}
+	for _, tt := range tests {
+		if got := mustRun(t, runDiff, append(tt.args, first, second)...); got != tt.want {
+			t.Errorf("diff %s:\ngot\n%s\nwant\n%s", strings.Join(tt.args, " "), got, tt.want)
+		}
+	}
+
+	// The working tree's changes, and the summary's singulars.
+	writeFile(t, "small", "c\n")
+	if got, want := mustRun(t, runDiff, "--stat"), " small | 2 +-\n 1 file changed, 1 insertion(+), 1 deletion(-)\n"; got != want {
+		t.Errorf("diff --stat:\ngot\n%s\nwant\n%s", got, want)
+	}
+	if out := mustRun(t, runDiff, "--stat", second, second); out != "" {
+		t.Errorf("diff --stat of a commit with itself printed %q", out)
+	}
+}
+
+func TestRenameName(t *testing.T) {
+	// Expected names are from git diff --stat.
+	tests := map[[2]string]string{
+		{"a/x/f", "a/y/f"}:         "a/{x => y}/f",
+		{"abc", "abd"}:             "abc => abd",
+		{"dir/file", "file"}:       "dir/file => file",
+		{"p/q/r", "p/r"}:           "p/{q => }/r",
+		{"s/t/u", "s/t/v/u"}:       "s/t/{ => v}/u",
+		{"x/a.txt", "x/b.txt"}:     "x/{a.txt => b.txt}",
+		{"n/x/sub/m", "n/y/sub/m"}: "n/{x => y}/sub/m",
+	}
+	for in, want := range tests {
+		if got := renameName(in[0], in[1]); got != want {
+			t.Errorf("renameName(%q, %q) = %q, want %q", in[0], in[1], got, want)
+		}
+	}
+}
+
+func TestAbbrev(t *testing.T) {
+	dir := testRepo(t)
+	commitFiles(t, "first", map[string]string{"a": "a\n"})
+	head := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+
+	tests := []struct {
+		run  func([]string) error
+		args []string
+		want string
+	}{
+		{runRevParse, []string{"--short", "HEAD"}, head[:7]},
+		{runRevParse, []string{"--short=2", "HEAD"}, head[:4]},
+		{runRevParse, []string{"--short=12", "HEAD"}, head[:12]},
+		{runLog, []string{"--oneline"}, head[:7] + " first"},
+		{runLog, []string{"--oneline", "--abbrev=10"}, head[:10] + " first"},
+		{runLog, []string{"--oneline", "--no-abbrev"}, head + " first"},
+	}
+	for _, tt := range tests {
+		if got := strings.TrimSpace(mustRun(t, tt.run, tt.args...)); got != tt.want {
+			t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	// core.abbrev sets the default, which the flags still override.
+	cfg := filepath.Join(dir, ".git", "config")
+	appendConfig := func(text string) {
+		f, err := os.OpenFile(cfg, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendConfig("[core]\n\tabbrev = 12\n")
+	if got := strings.TrimSpace(mustRun(t, runRevParse, "--short", "HEAD")); got != head[:12] {
+		t.Errorf("rev-parse --short with core.abbrev=12 = %q, want %q", got, head[:12])
+	}
+	if got := mustRun(t, runLog, "--oneline", "--abbrev=5"); got != head[:5]+" first\n" {
+		t.Errorf("log --oneline --abbrev=5 with core.abbrev=12 = %q", got)
+	}
+	mustRun(t, runCheckout, head)
+	if got := mustRun(t, runStatus, "--color=never"); !strings.HasPrefix(got, "HEAD detached at "+head[:12]+"\n") {
+		t.Errorf("status with core.abbrev=12:\n%s", got)
+	}
+	if got := mustRun(t, runStatus, "--no-abbrev"); !strings.HasPrefix(got, "HEAD detached at "+head+"\n") {
+		t.Errorf("status --no-abbrev:\n%s", got)
+	}
+
+	appendConfig("\tabbrev = 3\n")
+	if _, err := capture(runLog, "--oneline"); err == nil {
+		t.Error("log with core.abbrev=3: want error")
+	}
+}
+
+func TestLog(t *testing.T) {
+	testRepo(t)
+	if _, err := capture(runLog); err == nil || !strings.Contains(err.Error(), "does not have any commits yet") {
+		t.Errorf("log on an unborn branch: err = %v", err)
+	}
+	commitFiles(t, "first", map[string]string{"a": "1\n"})
+	commitFiles(t, "second", map[string]string{"a": "2\n"})
+	second := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	commitFiles(t, "third", map[string]string{"a": "3\n"})
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--oneline"}, "third second first"},
+		{[]string{"--oneline", "-n", "2"}, "third second"},
+		{[]string{"--oneline", "-n", "0"}, ""},
+		{[]string{"--oneline", second}, "second first"},
+	}
+	for _, tt := range tests {
+		var subjects []string
+		for _, line := range strings.Split(strings.TrimSpace(mustRun(t, runLog, tt.args...)), "\n") {
+			if _, subject, ok := strings.Cut(line, " "); ok {
+				subjects = append(subjects, subject)
+			}
+		}
+		if got := strings.Join(subjects, " "); got != tt.want {
+			t.Errorf("log %v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	out := mustRun(t, runLog, "-n", "2")
+	if strings.Count(out, "\ncommit ") != 1 || !strings.HasPrefix(out, "commit ") || !strings.Contains(out, "\n    third\n\ncommit ") {
+		t.Errorf("log -n 2:\n%s", out)
+	}
+}
+
+func TestLogRaw(t *testing.T) {
+	testRepo(t)
+	blob := func(content string) string {
+		return object.HashBytes([]byte(object.Header(object.TypeBlob, int64(len(content))) + content))
+	}
+	commitFiles(t, "first", map[string]string{"a": "a\n", "b": "b\n"})
+	mustRun(t, runRm, "b")
+	commitFiles(t, "second", map[string]string{"a": "a2\n", "d/c": "c\n"})
+	second := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+
+	// A merge of second with itself shows no changes.
+	tree := strings.TrimSpace(mustRun(t, runWriteTree))
+	merge := strings.TrimSpace(mustRun(t, runCommitTree, tree, "-p", second, "-p", second, "-m", "merge"))
+	branch, _, err := repository.CurrentBranch(".git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.UpdateRef(".git", "refs/heads/"+branch, merge); err != nil {
+		t.Fatal(err)
+	}
+
+	short := func(hash string) string { return hash[:7] }
+	want := short(merge) + " merge\n" +
+		short(second) + " second\n" +
+		":100644 100644 " + short(blob("a\n")) + " " + short(blob("a2\n")) + " M\ta\n" +
+		":100644 000000 " + short(blob("b\n")) + " 0000000 D\tb\n" +
+		":000000 100644 0000000 " + short(blob("c\n")) + " A\td/c\n"
+	first := strings.Split(mustRun(t, runLog, "--oneline", "-n", "3"), "\n")[2]
+	want += first + "\n" +
+		":000000 100644 0000000 " + short(blob("a\n")) + " A\ta\n" +
+		":000000 100644 0000000 " + short(blob("b\n")) + " A\tb\n"
+	if got := mustRun(t, runLog, "--oneline", "--raw"); got != want {
+		t.Errorf("log --oneline --raw:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	got := mustRun(t, runLog, "--raw", "--no-abbrev", "-n", "1", second)
+	wantTail := "    second\n\n" +
+		":100644 100644 " + blob("a\n") + " " + blob("a2\n") + " M\ta\n" +
+		":100644 000000 " + blob("b\n") + " " + strings.Repeat("0", 40) + " D\tb\n" +
+		":000000 100644 " + strings.Repeat("0", 40) + " " + blob("c\n") + " A\td/c\n"
+	if !strings.HasSuffix(got, wantTail) {
+		t.Errorf("log --raw --no-abbrev:\n%s\nwant it to end:\n%s", got, wantTail)
+	}
+}
+
+func TestShow_Merge(t *testing.T) {
+	testRepo(t)
+	blob := func(content string) string {
+		return object.HashBytes([]byte(object.Header(object.TypeBlob, int64(len(content))) + content))
+	}
+	commitFiles(t, "ours", map[string]string{"f": "a\nB1\nc\n", "g": "ours\n"})
+	ours := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	commitFiles(t, "theirs", map[string]string{"f": "a\nB2\nc\n", "g": "theirs\n"})
+	theirs := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	// f resolves the conflict by hand; g takes theirs, so isn't shown.
+	commitFiles(t, "resolved", map[string]string{"f": "a\nR\nc\n"})
+	tree := strings.TrimSpace(mustRun(t, runWriteTree))
+	merge := strings.TrimSpace(mustRun(t, runCommitTree, tree, "-p", ours, "-p", theirs, "-m", "merge"))
+
+	want := "\n    merge\n\n" +
+		"diff --cc f\n" +
+		"index " + blob("a\nB1\nc\n")[:7] + "," + blob("a\nB2\nc\n")[:7] + ".." + blob("a\nR\nc\n")[:7] + "\n" +
+		"--- a/f\n+++ b/f\n" +
+		"@@@ -1,3 -1,3 +1,3 @@@\n  a\n- B1\n -B2\n++R\n  c\n"
+	got := mustRun(t, runShow, merge)
+	if !strings.Contains(got, "\nMerge: "+ours[:7]+" "+theirs[:7]+"\n") || !strings.HasSuffix(got, want) {
+		t.Errorf("show of a merge:\n%s\nwant it to end:\n%s", got, want)
+	}
+}
+
+func TestCatFile_Follow(t *testing.T) {
+	testRepo(t)
+	commitFiles(t, "first", map[string]string{"a": "a\n"})
+	head := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	commit := mustRun(t, runCatFile, "-p", head)
+
+	inner := "object " + head + "\ntype commit\ntag inner\ntagger A U Thor <author@example.com> 1700000000 +0000\n\ninner\n"
+	outer := func(target string) string {
+		return "object " + target + "\ntype tag\ntag outer\ntagger A U Thor <author@example.com> 1700000000 +0000\n\nouter\n"
+	}
+	innerHash, err := object.WriteObject(".git", object.TypeTag, []byte(inner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outerHash, err := object.WriteObject(".git", object.TypeTag, []byte(outer(innerHash)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := mustRun(t, runCatFile, "-p", outerHash); got != outer(innerHash) {
+		t.Errorf("cat-file -p without --follow:\n%s", got)
+	}
+	want := outer(innerHash) + "\n" + inner + "\n" + commit
+	if got := mustRun(t, runCatFile, "-p", "--follow", outerHash); got != want {
+		t.Errorf("cat-file -p --follow:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if got := mustRun(t, runCatFile, "-p", "--follow", head); got != commit {
+		t.Errorf("cat-file -p --follow of a commit:\n%s", got)
+	}
+	if _, err := capture(runCatFile, "-t", "--follow", outerHash); err == nil {
+		t.Error("cat-file -t --follow: want error")
+	}
+}
+
+func TestVerifyTag(t *testing.T) {
+	testRepo(t)
+	commitFiles(t, "first", map[string]string{"a": "a\n"})
+	head := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	tag := func(name, sig string) {
+		t.Helper()
+		body := "object " + head + "\ntype commit\ntag " + name + "\ntagger A U Thor <author@example.com> 1700000000 +0000\n\n" + name + "\n" + sig
+		hash, err := object.WriteObject(".git", object.TypeTag, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repository.UpdateRef(".git", "refs/tags/"+name, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tag("unsigned", "")
+	tag("signed", "-----BEGIN PGP SIGNATURE-----\n\niQEz\n-----END PGP SIGNATURE-----\n")
+	if err := repository.UpdateRef(".git", "refs/tags/light", head); err != nil {
+		t.Fatal(err)
+	}
+	mustRun(t, runConfig, "gpg.program", "/nonexistent/gpg")
+
+	// -v prints what was signed, even when it can't be verified.
+	out, err := capture(runVerifyTag, "-v", "signed")
+	if want := "object " + head + "\ntype commit\ntag signed\ntagger A U Thor <author@example.com> 1700000000 +0000\n\nsigned\n"; out != want {
+		t.Errorf("verify-tag -v: got %q, want %q", out, want)
+	}
+	if err != exitCode(1) {
+		t.Errorf("verify-tag without gpg: got %v, want exit status 1", err)
+	}
+	for _, name := range []string{"unsigned", "light", "unsigned signed"} {
+		if _, err := capture(runVerifyTag, strings.Fields(name)...); err != exitCode(1) {
+			t.Errorf("verify-tag %s: got %v, want exit status 1", name, err)
+		}
+	}
+}
+
+func TestNameRev(t *testing.T) {
+	testRepo(t)
+	commitFiles(t, "first", map[string]string{"a": "1\n"})
+	first := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	commitFiles(t, "second", map[string]string{"a": "2\n"})
+	second := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	if err := repository.UpdateRef(".git", "refs/tags/v1", first); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, "third", map[string]string{"a": "3\n"})
+	branch, _, err := repository.CurrentBranch(".git")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"HEAD"}, "HEAD " + branch + "\n"},
+		{[]string{second}, second + " " + branch + "~1\n"},
+		{[]string{first}, first + " tags/v1\n"},
+		{[]string{"--tags", second}, second + " undefined\n"},
+		{[]string{"--tags", "--name-only", first}, "v1\n"},
+		{[]string{"--name-only", first, "HEAD"}, "tags/v1\n" + branch + "\n"},
+	}
+	for _, tt := range tests {
+		if got := mustRun(t, runNameRev, tt.args...); got != tt.want {
+			t.Errorf("name-rev %v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestShowRef_VerifyHead(t *testing.T) {
+	testRepo(t)
+	if _, err := capture(runShowRef, "--verify", "HEAD"); err == nil {
+		t.Error("show-ref --verify HEAD succeeded on an unborn branch")
+	}
+
+	commitFiles(t, "first", map[string]string{"a": "1\n"})
+	head := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	branch, _, err := repository.CurrentBranch(".git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := mustRun(t, runShowRef, "--verify", "HEAD", "refs/heads/"+branch)
+	if want := head + " HEAD\n" + head + " refs/heads/" + branch + "\n"; got != want {
+		t.Errorf("show-ref --verify = %q, want %q", got, want)
+	}
+	if _, err := capture(runShowRef, "--verify", branch); err == nil {
+		t.Error("show-ref --verify accepted a short ref name")
+	}
+}
+
+// readFile returns the content of name, relative to the working
+// directory.
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSwitch(t *testing.T) {
+	testRepo(t)
+	commitFiles(t, "first", map[string]string{"a": "1\n"})
+	first := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	commitFiles(t, "second", map[string]string{"a": "2\n"})
+	branch, _, err := repository.CurrentBranch(".git")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := mustRun(t, runSwitch, "-c", "topic", first); got != "Switched to a new branch 'topic'\n" {
+		t.Errorf("switch -c = %q", got)
+	}
+	if got := readFile(t, "a"); got != "1\n" {
+		t.Errorf("a after switch -c = %q, want 1", got)
+	}
+	if current, _, _ := repository.CurrentBranch(".git"); current != "topic" {
+		t.Errorf("on %q after switch -c, want topic", current)
+	}
+	if _, err := capture(runSwitch, "-c", "topic"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("switch -c of an existing branch: err = %v", err)
+	}
+	if _, err := capture(runSwitch, first); err == nil || !strings.Contains(err.Error(), "a branch is expected") {
+		t.Errorf("switch to a commit: err = %v", err)
+	}
+
+	writeFile(t, "a", "dirty\n")
+	if _, err := capture(runSwitch, branch); !errors.Is(err, index.ErrWouldOverwrite) {
+		t.Errorf("switch over local changes: err = %v, want ErrWouldOverwrite", err)
+	}
+	if got := mustRun(t, runSwitch, "-f", branch); got != "Switched to branch '"+branch+"'\n" {
+		t.Errorf("switch -f = %q", got)
+	}
+	if got := readFile(t, "a"); got != "2\n" {
+		t.Errorf("a after switch -f = %q, want 2", got)
+	}
+	if got := mustRun(t, runSwitch, "--detach", first); !strings.HasPrefix(got, "HEAD is now at "+first[:7]) {
+		t.Errorf("switch --detach = %q", got)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	testRepo(t)
+	commitFiles(t, "first", map[string]string{"a": "1\n", "b": "1\n", "d/x": "x\n"})
+	first := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	commitFiles(t, "second", map[string]string{"a": "2\n", "b": "2\n", "d/y": "y\n"})
+	commitFiles(t, "third", map[string]string{"a": "3\n", "b": "3\n"})
+
+	status := func() string {
+		t.Helper()
+		return mustRun(t, runStatus, "--color=never")
+	}
+
+	// A single file from an arbitrary commit goes to the working tree
+	// only, leaving its neighbors and the index alone.
+	mustRun(t, runRestore, "--source="+first, "a")
+	if got := readFile(t, "a"); got != "1\n" {
+		t.Errorf("a = %q, want 1 from the first commit", got)
+	}
+	if got := readFile(t, "b"); got != "3\n" {
+		t.Errorf("b = %q, want it untouched", got)
+	}
+	if st := status(); !strings.Contains(st, "Changes not staged for commit") || strings.Contains(st, "Changes to be committed") {
+		t.Errorf("status after restore --source:\n%s", st)
+	}
+
+	// Without a source, the working tree comes back from the index.
+	mustRun(t, runRestore, "a")
+	if got := readFile(t, "a"); got != "3\n" {
+		t.Errorf("a after restore = %q, want 3", got)
+	}
+
+	// --staged takes HEAD's version into the index, unstaging a change.
+	writeFile(t, "b", "staged\n")
+	mustRun(t, runAdd, "b")
+	mustRun(t, runRestore, "--staged", "b")
+	if got := readFile(t, "b"); got != "staged\n" {
+		t.Errorf("b after restore --staged = %q, want the edit kept", got)
+	}
+	if st := status(); strings.Contains(st, "Changes to be committed") {
+		t.Errorf("status after restore --staged:\n%s", st)
+	}
+
+	// Both from the first commit: d/y, which it lacks, goes from both.
+	mustRun(t, runRestore, "-S", "-W", "-s", first, "d")
+	if _, err := os.Lstat("d/y"); !os.IsNotExist(err) {
+		t.Errorf("d/y still on disk: %v", err)
+	}
+	if st := status(); !strings.Contains(st, "deleted:    d/y") {
+		t.Errorf("status after restore -S -W:\n%s", st)
+	}
+
+	if _, err := capture(runRestore, "nope"); err == nil || !strings.Contains(err.Error(), "did not match") {
+		t.Errorf("restore of an unknown path: err = %v", err)
+	}
+	if _, err := capture(runRestore); err == nil {
+		t.Error("restore with no paths: want error")
+	}
+}
+
+// headCommit returns the parsed commit HEAD points to.
+func headCommit(t *testing.T) *object.Commit {
+	t.Helper()
+	obj, err := object.Read(".git", strings.TrimSpace(mustRun(t, runRevParse, "HEAD")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := object.ParseCommit(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSHA256Repository(t *testing.T) {
+	testRepo(t)
+	config := "[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectformat = sha256\n"
+	if err := os.WriteFile(".git/config", []byte(config), 0666); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, "first", map[string]string{"a": "hello\n", "d/b": "world\n"})
+
+	head := headCommit(t)
+	if len(head.Hash) != 64 || len(head.Tree) != 64 {
+		t.Errorf("commit %s, tree %s: want SHA-256 names", head.Hash, head.Tree)
+	}
+	if tree := strings.TrimSpace(mustRun(t, runWriteTree)); tree != head.Tree {
+		t.Errorf("write-tree = %s, want the commit's tree %s", tree, head.Tree)
+	}
+	if out := mustRun(t, runLsFiles, "-s"); !strings.Contains(out, "2cf8d83d9ee29543b34a87727421fdecb7e3f3a183d337639025de576db9ebb4 0\ta\n") {
+		t.Errorf("ls-files -s = %q, want a's SHA-256 blob", out)
+	}
+	if out := mustRun(t, runStatus); !strings.Contains(out, "nothing to commit") {
+		t.Errorf("status after commit:\n%s", out)
+	}
+
+	// Packs still hold only SHA-1 objects.
+	if _, err := capture(runRepack); err == nil {
+		t.Error("repack succeeded in a SHA-256 repository")
+	}
+}
+
+func TestCommit_AuthorAndTrailers(t *testing.T) {
+	testRepo(t)
+	const signoff = "Signed-off-by: A U Thor <author@example.com>"
+
+	writeFile(t, "a", "1\n")
+	mustRun(t, runAdd, "a")
+	mustRun(t, runCommit, "-m", "author", "--author", "Someone Else <else@example.com>")
+	c := headCommit(t)
+	if c.Author.Name != "Someone Else" || c.Author.Email != "else@example.com" {
+		t.Errorf("--author: author = %+v", c.Author)
+	}
+	if c.Committer.Name != "A U Thor" || c.Committer.Email != "author@example.com" {
+		t.Errorf("--author: committer = %+v, want the configured identity", c.Committer)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-m", "subject", "-s"}, "subject\n\n" + signoff + "\n"},
+		{[]string{"-m", "subject", "--signoff", "--trailer", "Reviewed-by: R <r@example.com>"},
+			"subject\n\n" + signoff + "\nReviewed-by: R <r@example.com>\n"},
+		// The trailer block the message already has is extended, and a
+		// sign-off identical to the last trailer isn't repeated.
+		{[]string{"-m", "subject", "-m", "Acked-by: z", "-s", "--trailer", "Fixes: 1"},
+			"subject\n\nAcked-by: z\n" + signoff + "\nFixes: 1\n"},
+		{[]string{"-m", "subject", "-m", signoff, "-s"}, "subject\n\n" + signoff + "\n"},
+	}
+	for i, tt := range tests {
+		writeFile(t, "a", fmt.Sprintf("v%d\n", i))
+		mustRun(t, runAdd, "a")
+		mustRun(t, runCommit, tt.args...)
+		if got := headCommit(t).Message; got != tt.want {
+			t.Errorf("commit %v: message = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-m", "x", "--author", "nobody"},
+		{"-m", "x", "--author", "<a@example.com>"},
+		{"-m", "x", "--trailer", "not a trailer"},
+	} {
+		if _, err := capture(runCommit, args...); err == nil {
+			t.Errorf("commit %v: want error", args)
+		}
+	}
+}
+
+func TestFastImport(t *testing.T) {
+	testRepo(t)
+	withStdin(t, "blob\nmark :1\ndata 6\nhello\n\n"+
+		"commit refs/heads/main\nmark :2\ncommitter C <c@x> 1 +0000\ndata 6\nfirst\nM 644 :1 greeting\n\n"+
+		"progress imported\n")
+	marks := filepath.Join(t.TempDir(), "marks")
+	if out := mustRun(t, runFastImport, "--quiet", "--export-marks="+marks); out != "progress imported\n" {
+		t.Errorf("stdout = %q", out)
+	}
+
+	head := headCommit(t).Hash
+	blob := "ce013625030ba8dba906f756967f9e9ca394464a"
+	if got, want := readFile(t, marks), ":1 "+blob+"\n:2 "+head+"\n"; got != want {
+		t.Errorf("marks file:\n%s\nwant:\n%s", got, want)
+	}
+	if out := mustRun(t, runLog, "--oneline"); !strings.HasSuffix(out, " first\n") {
+		t.Errorf("log = %q", out)
+	}
+
+	// A second run can build on the first through its marks.
+	withStdin(t, "commit refs/heads/main\ncommitter C <c@x> 2 +0000\ndata 7\nsecond\nfrom :2\nD greeting\n")
+	mustRun(t, runFastImport, "--quiet", "--import-marks="+marks)
+	if out := mustRun(t, runLog, "--oneline"); strings.Count(out, "\n") != 2 {
+		t.Errorf("log after the incremental import:\n%s", out)
+	}
+
+	withStdin(t, "commit refs/heads/main\ncommitter C <c@x> 3 +0000\ndata 0\nfrom :9\n")
+	if _, err := capture(runFastImport, "--quiet"); err == nil || !strings.Contains(err.Error(), "mark not declared") {
+		t.Errorf("undeclared mark: got %v", err)
+	}
+}
+
+func TestFastExport(t *testing.T) {
+	testRepo(t)
+	commitFiles(t, "first", map[string]string{"a": "a\n", "dir/b": "b\n"})
+	commitFiles(t, "second", map[string]string{"a": "changed\n"})
+	mustRun(t, runBranch, "topic")
+	want := mustRun(t, runShowRef)
+
+	marks := filepath.Join(t.TempDir(), "marks")
+	stream := mustRun(t, runFastExport, "--export-marks="+marks, "main", "topic")
+	if !strings.Contains(stream, "commit refs/heads/main\n") || !strings.Contains(stream, "reset refs/heads/topic\n") {
+		t.Errorf("stream:\n%s", stream)
+	}
+	if n := strings.Count(readFile(t, marks), "\n"); n != 5 {
+		t.Errorf("marks file has %d marks, want 5 (3 blobs, 2 commits)", n)
+	}
+	// As in git, only the ref is left to write once every object is
+	// marked.
+	if again := mustRun(t, runFastExport, "--import-marks="+marks, "main"); again != "reset refs/heads/main\nfrom :5\n\n" {
+		t.Errorf("export with every object marked:\n%s", again)
+	}
+
+	testRepo(t)
+	withStdin(t, stream)
+	mustRun(t, runFastImport, "--quiet")
+	if got := mustRun(t, runShowRef); got != want {
+		t.Errorf("refs after re-importing:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := capture(runFastExport, "nope"); err == nil {
+		t.Error("fast-export of a missing ref: want error")
+	}
+	if _, err := capture(runFastExport); err == nil {
+		t.Error("fast-export with no refs: want error")
+	}
+}
+
+func TestGc(t *testing.T) {
+	testRepo(t)
+	content := strings.Repeat("a line that stays the same in every version\n", 100)
+	for i := range 5 {
+		content += fmt.Sprintf("version %d\n", i)
+		commitFiles(t, fmt.Sprintf("commit %d", i), map[string]string{"file": content})
+	}
+	want := mustRun(t, runLog)
+
+	out := mustRun(t, runGc, "--aggressive")
+	if !strings.HasPrefix(out, "Packed 15 objects into pack-") {
+		t.Errorf("gc --aggressive printed:\n%s", out)
+	}
+	loose, err := object.CountLoose(".git", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packs, err := object.CountPacks(".git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loose.Count != 0 || packs.Count != 1 {
+		t.Errorf("after gc: %d loose objects and %d packs, want 0 and 1", loose.Count, packs.Count)
+	}
+	// Five near-identical 4.5K blobs only fit in this with deltas.
+	if packs.Size > 4096 {
+		t.Errorf("pack and index are %d bytes", packs.Size)
+	}
+	if got := mustRun(t, runLog); got != want {
+		t.Errorf("log after gc:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGc_Auto(t *testing.T) {
+	testRepo(t)
+	countPacks := func() int {
+		t.Helper()
+		packs, err := object.CountPacks(".git")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return packs.Count
+	}
+	for i := range 3 {
+		commitFiles(t, fmt.Sprintf("commit %d", i), map[string]string{"file": fmt.Sprintf("%d\n", i)})
+		mustRun(t, runRepack)
+	}
+	if n := countPacks(); n != 3 {
+		t.Fatalf("%d packs after three repacks, want 3", n)
+	}
+
+	// Under the limit, gc --auto and commit leave the packs alone.
+	mustRun(t, runConfig, "gc.autoPackLimit", "3")
+	mustRun(t, runGc, "--auto")
+	if n := countPacks(); n != 3 {
+		t.Errorf("gc --auto under the limit: %d packs, want 3", n)
+	}
+
+	mustRun(t, runConfig, "gc.autoPackLimit", "2")
+	commitFiles(t, "over the limit", map[string]string{"file": "more\n"})
+	if n := countPacks(); n != 1 {
+		t.Errorf("commit over gc.autoPackLimit: %d packs, want 1", n)
+	}
+	if loose, err := object.CountLoose(".git", false); err != nil || loose.Count != 0 {
+		t.Errorf("commit over gc.autoPackLimit: %+v loose objects, %v", loose, err)
+	}
+}
+
+func TestReflogShorthands(t *testing.T) {
+	testRepo(t)
+	commitFiles(t, "first", map[string]string{"a": "1\n"})
+	first := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	mustRun(t, runSwitch, "-c", "topic")
+	commitFiles(t, "second", map[string]string{"a": "2\n"})
+	second := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	mustRun(t, runCheckout, "main")
+
+	tests := []struct {
+		rev, want string
+	}{
+		{"@", first},
+		{"@{-1}", second},
+		{"HEAD@{1}", second},
+		{"HEAD@{2}", first},
+		{"topic@{1}", first},
+	}
+	for _, tt := range tests {
+		if got := strings.TrimSpace(mustRun(t, runRevParse, tt.rev)); got != tt.want {
+			t.Errorf("rev-parse %s = %s, want %s", tt.rev, got, tt.want)
+		}
+	}
+	if got := mustRun(t, runCatFile, "-t", "@{-1}"); got != "commit\n" {
+		t.Errorf("cat-file -t @{-1} = %q", got)
+	}
+	if _, err := capture(runCatFile, "-e", "@{-5}"); !errors.Is(err, exitCode(1)) {
+		t.Errorf("cat-file -e @{-5}: err = %v, want exit status 1", err)
+	}
+
+	// A detached checkout is named by its commit.
+	mustRun(t, runCheckout, second)
+	mustRun(t, runCheckout, "topic")
+	if got := strings.TrimSpace(mustRun(t, runRevParse, "@{-1}")); got != second {
+		t.Errorf("rev-parse @{-1} after a detached checkout = %s, want %s", got, second)
+	}
+	if got := strings.TrimSpace(mustRun(t, runRevParse, "@{-2}")); got != first {
+		t.Errorf("rev-parse @{-2} = %s, want main at %s", got, first)
+	}
+}
+
+func TestCheckoutPrevious(t *testing.T) {
+	testRepo(t)
+	commitFiles(t, "first", map[string]string{"a": "1\n"})
+	if _, err := capture(runCheckout, "-"); err == nil || !strings.Contains(err.Error(), "no branch was checked out before") {
+		t.Errorf("checkout - with no previous branch: err = %v", err)
+	}
+	mustRun(t, runSwitch, "-c", "topic")
+	commitFiles(t, "second", map[string]string{"a": "2\n"})
+
+	current := func() string {
+		t.Helper()
+		branch, ok, err := repository.CurrentBranch(".git")
+		if err != nil || !ok {
+			t.Fatalf("HEAD isn't on a branch: %v", err)
+		}
+		return branch
+	}
+	if got := mustRun(t, runCheckout, "-"); got != "Switched to branch 'main'\n" {
+		t.Errorf("checkout - = %q", got)
+	}
+	if current() != "main" || readFile(t, "a") != "1\n" {
+		t.Errorf("after checkout -: on %s with a = %q, want main and 1", current(), readFile(t, "a"))
+	}
+	if got := mustRun(t, runSwitch, "-"); got != "Switched to branch 'topic'\n" {
+		t.Errorf("switch - = %q", got)
+	}
+	if got := mustRun(t, runCheckout, "@{-1}"); got != "Switched to branch 'main'\n" || current() != "main" {
+		t.Errorf("checkout @{-1} = %q, on %s", got, current())
+	}
+
+	// Back from a detached HEAD, checkout - detaches again and switch -
+	// refuses, as it only moves between branches.
+	first := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	mustRun(t, runCheckout, first)
+	mustRun(t, runCheckout, "topic")
+	if got := mustRun(t, runCheckout, "-"); !strings.HasPrefix(got, "HEAD is now at "+first[:7]) {
+		t.Errorf("checkout - to a detached HEAD = %q", got)
+	}
+	mustRun(t, runCheckout, "topic")
+	if _, err := capture(runSwitch, "-"); err == nil || !strings.Contains(err.Error(), "a branch is expected") {
+		t.Errorf("switch - to a detached HEAD: err = %v", err)
+	}
+}
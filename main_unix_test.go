@@ -0,0 +1,38 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTextconv(t *testing.T) {
+	testRepo(t)
+	f, err := os.OpenFile(".git/config", os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("\n[diff \"up\"]\n\ttextconv = tr a-z A-Z <\n")
+	f.Close()
+	commitFiles(t, "first", map[string]string{".gitattributes": "*.up diff=up\n", "a.up": "one\x00\n", "b": "one\x00\n"})
+	first := strings.TrimSpace(mustRun(t, runRevParse, "HEAD"))
+	commitFiles(t, "second", map[string]string{"a.up": "two\x00\n", "b": "two\x00\n"})
+
+	if got := mustRun(t, runCatFile, "--textconv", "HEAD:a.up"); got != "TWO\x00\n" {
+		t.Errorf("cat-file --textconv HEAD:a.up = %q", got)
+	}
+	if got := mustRun(t, runCatFile, "--textconv", first+":b"); got != "one\x00\n" {
+		t.Errorf("cat-file --textconv of a file without a driver = %q", got)
+	}
+	if _, err := capture(runCatFile, "--textconv", "HEAD"); err == nil {
+		t.Error("cat-file --textconv without a path: want error")
+	}
+
+	// The converted file is diffed as text; the other stays binary.
+	out, _ := capture(runDiff, first, "HEAD")
+	if !strings.Contains(out, "-ONE\x00\n+TWO\x00\n") || !strings.Contains(out, "Binary files a/b and b/b differ\n") {
+		t.Errorf("diff with textconv:\n%s", out)
+	}
+}